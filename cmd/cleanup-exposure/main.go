@@ -17,11 +17,15 @@ package main
 
 import (
 	"context"
-	"log"
 	"net/http"
 
 	"github.com/google/exposure-notifications-server/internal/cleanup"
+	"github.com/google/exposure-notifications-server/internal/health"
 	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/observability"
+	"github.com/google/exposure-notifications-server/internal/scheduler"
+	"github.com/google/exposure-notifications-server/internal/server"
 	"github.com/google/exposure-notifications-server/internal/setup"
 )
 
@@ -36,11 +40,34 @@ func main() {
 	}
 	defer closer()
 
+	srv, err := server.NewFromEnv(ctx)
+	if err != nil {
+		logger.Fatalf("server.NewFromEnv: %v", err)
+	}
+
 	handler, err := cleanup.NewExposureHandler(&config, env)
 	if err != nil {
 		logger.Fatalf("cleanup.NewExposureHandler: %v", err)
 	}
 	http.Handle("/", handler)
+	if jobs := handler.Jobs(); len(jobs) > 0 {
+		sched, err := scheduler.New(config.Scheduler, scheduler.NewDBStore(env.Database()), jobs...)
+		if err != nil {
+			logger.Fatalf("scheduler.New: %v", err)
+		}
+		srv.Go(sched.Run)
+	}
+
+	checker := health.New(env)
+	http.Handle("/healthz", checker.HandleHealthz())
+	http.Handle("/readyz", checker.HandleReadyz())
+	http.Handle("/metrics", metrics.Handler())
+
 	logger.Infof("starting cleanup server on :%s", config.Port)
-	log.Fatal(http.ListenAndServe(":"+config.Port, nil))
+	if err := srv.ListenAndServe(config.Port, observability.HTTPHandler(env.RequestLogger().Handler(http.DefaultServeMux))); err != nil {
+		logger.Fatalf("srv.ListenAndServe: %v", err)
+	}
+	if err := srv.Wait(); err != nil {
+		logger.Fatalf("srv.Wait: %v", err)
+	}
 }
@@ -0,0 +1,67 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This package is the service that downloads keys from the EU federation
+// gateway. It is intended to be invoked over HTTP on a schedule, and by the
+// gateway's callbacks.
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/exposure-notifications-server/internal/efgs"
+	"github.com/google/exposure-notifications-server/internal/health"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/observability"
+	"github.com/google/exposure-notifications-server/internal/server"
+	"github.com/google/exposure-notifications-server/internal/setup"
+)
+
+func main() {
+	ctx := context.Background()
+	logger := logging.FromContext(ctx)
+
+	var config efgs.Config
+	env, closer, err := setup.Setup(ctx, &config)
+	if err != nil {
+		logger.Fatalf("setup.Setup: %v", err)
+	}
+	defer closer()
+
+	srv, err := server.NewFromEnv(ctx)
+	if err != nil {
+		logger.Fatalf("server.NewFromEnv: %v", err)
+	}
+
+	handler, err := efgs.NewHandler(env, &config)
+	if err != nil {
+		logger.Fatalf("efgs.NewHandler: %v", err)
+	}
+	http.Handle("/", handler)
+
+	checker := health.New(env)
+	http.Handle("/healthz", checker.HandleHealthz())
+	http.Handle("/readyz", checker.HandleReadyz())
+	http.Handle("/metrics", metrics.Handler())
+
+	logger.Infof("Starting efgs server on port %s", config.Port)
+	if err := srv.ListenAndServe(config.Port, observability.HTTPHandler(env.RequestLogger().Handler(http.DefaultServeMux))); err != nil {
+		logger.Fatalf("srv.ListenAndServe: %v", err)
+	}
+	if err := srv.Wait(); err != nil {
+		logger.Fatalf("srv.Wait: %v", err)
+	}
+}
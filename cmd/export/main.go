@@ -17,11 +17,15 @@ package main
 
 import (
 	"context"
-	"log"
 	"net/http"
 
 	"github.com/google/exposure-notifications-server/internal/export"
+	"github.com/google/exposure-notifications-server/internal/health"
 	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/observability"
+	"github.com/google/exposure-notifications-server/internal/scheduler"
+	"github.com/google/exposure-notifications-server/internal/server"
 	"github.com/google/exposure-notifications-server/internal/setup"
 )
 
@@ -36,13 +40,55 @@ func main() {
 	}
 	defer closer()
 
+	srv, err := server.NewFromEnv(ctx)
+	if err != nil {
+		logger.Fatalf("server.NewFromEnv: %v", err)
+	}
+
 	batchServer, err := export.NewServer(&config, env)
 	if err != nil {
 		logger.Fatalf("unable to create server: %v", err)
 	}
-	http.HandleFunc("/create-batches", batchServer.CreateBatchesHandler) // controller that creates work items
-	http.HandleFunc("/do-work", batchServer.WorkerHandler)               // worker that executes work
+	http.HandleFunc("/create-batches", batchServer.CreateBatchesHandler)   // controller that creates work items
+	http.HandleFunc("/do-work", batchServer.WorkerHandler)                 // worker that executes work
+	http.HandleFunc("/reexport", batchServer.ReexportHandler)              // reopens batches to regenerate their files
+	http.HandleFunc("/stats", batchServer.StatsHandler)                    // reports what each batch published
+	http.HandleFunc("/aggregate-stats", batchServer.AggregateStatsHandler) // dumps signed daily stats for compliance
+	http.HandleFunc("/rotate-keys", batchServer.RotateKeysHandler)         // rotates signing keys that are due
+	http.HandleFunc("/signing-keys", batchServer.SigningKeysHandler)       // reports the state of each signing key
+
+	if jobs := batchServer.Jobs(); len(jobs) > 0 {
+		sched, err := scheduler.New(config.Scheduler, scheduler.NewDBStore(env.Database()), jobs...)
+		if err != nil {
+			logger.Fatalf("scheduler.New: %v", err)
+		}
+		srv.Go(sched.Run)
+	}
+
+	checker := health.New(env)
+	checker.AddBlobstore()
+	checker.AddSigners()
+	http.Handle("/healthz", checker.HandleHealthz())
+	http.Handle("/readyz", checker.HandleReadyz())
+	http.Handle("/metrics", metrics.Handler())
+
+	// Serves export files and indexes for deployments without a CDN, on a
+	// listener of its own so that the handlers above stay internal.
+	if config.DownloadPort != "" {
+		downloadMux := http.NewServeMux()
+		downloadMux.Handle("/download/", http.StripPrefix("/download/", http.HandlerFunc(batchServer.DownloadHandler)))
+		downloadMux.Handle("/healthz", checker.HandleHealthz())
+		logger.Infof("starting export download server on :%s", config.DownloadPort)
+		if err := srv.ListenAndServe(config.DownloadPort, observability.HTTPHandler(env.RequestLogger().Handler(downloadMux))); err != nil {
+			logger.Fatalf("srv.ListenAndServe: %v", err)
+		}
+	}
 
 	logger.Infof("starting exposure export server on :%s", config.Port)
-	log.Fatal(http.ListenAndServe(":"+config.Port, nil))
+	if err := srv.ListenAndServe(config.Port, observability.HTTPHandler(env.RequestLogger().Handler(http.DefaultServeMux))); err != nil {
+		logger.Fatalf("srv.ListenAndServe: %v", err)
+	}
+	if err := srv.Wait(); err != nil {
+		logger.Fatalf("srv.Wait: %v", err)
+	}
 }
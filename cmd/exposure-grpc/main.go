@@ -0,0 +1,99 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This package is the gRPC server for the infected keys upload service.
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/google/exposure-notifications-server/internal/handlers"
+	"github.com/google/exposure-notifications-server/internal/health"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/observability"
+	pb "github.com/google/exposure-notifications-server/internal/pb/publish"
+	"github.com/google/exposure-notifications-server/internal/publish"
+	"github.com/google/exposure-notifications-server/internal/server"
+	"github.com/google/exposure-notifications-server/internal/setup"
+)
+
+func main() {
+	ctx := context.Background()
+	logger := logging.FromContext(ctx)
+
+	var config publish.Config
+	env, closer, err := setup.Setup(ctx, &config)
+	if err != nil {
+		logger.Fatalf("setup.Setup: %v", err)
+	}
+	defer closer()
+
+	srv, err := server.NewFromEnv(ctx)
+	if err != nil {
+		logger.Fatalf("server.NewFromEnv: %v", err)
+	}
+
+	publishServer, err := publish.NewGRPCServer(ctx, &config, env)
+	if err != nil {
+		logger.Fatalf("unable to create gRPC publish server: %v", err)
+	}
+
+	// Like the HTTP API, every call takes at least the minimum request
+	// duration, so that chaff and rejected requests can't be told apart from
+	// real publishes by their timing.
+	interceptors := grpc.ChainUnaryInterceptor(
+		env.RequestLogger().UnaryInterceptor,
+		handlers.UnaryWithMinimumLatency(config.MinRequestDuration),
+		publishServer.AuthInterceptor)
+	sopts := []grpc.ServerOption{interceptors, observability.GRPCServerOption()}
+	if config.TLSCertFile != "" && config.TLSKeyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			logger.Fatalf("Failed to generate credentials: %v", err)
+		}
+		sopts = append(sopts, grpc.Creds(creds))
+	}
+
+	grpcServer := grpc.NewServer(sopts...)
+	pb.RegisterPublishServer(grpcServer, publishServer)
+
+	grpcEndpoint := ":" + config.Port
+	listen, err := net.Listen("tcp", grpcEndpoint)
+	if err != nil {
+		logger.Fatalf("Failed to start server: %v", err)
+	}
+	// Load balancers and Kubernetes probe over HTTP, so the health endpoints
+	// of the gRPC server are served on their own port.
+	checker := health.New(env)
+	healthMux := http.NewServeMux()
+	healthMux.Handle("/healthz", checker.HandleHealthz())
+	healthMux.Handle("/readyz", checker.HandleReadyz())
+	healthMux.Handle("/metrics", metrics.Handler())
+	logger.Infof("Starting health listener on :%s", config.HealthPort)
+	if err := srv.ListenAndServe(config.HealthPort, healthMux); err != nil {
+		logger.Fatalf("srv.ListenAndServe: %v", err)
+	}
+
+	logger.Infof("Starting exposure gRPC listener [%s]", grpcEndpoint)
+	srv.ServeGRPC(listen, grpcServer)
+	if err := srv.Wait(); err != nil {
+		logger.Fatalf("srv.Wait: %v", err)
+	}
+}
@@ -17,12 +17,16 @@ package main
 
 import (
 	"context"
-	"log"
 	"net/http"
 
 	"github.com/google/exposure-notifications-server/internal/handlers"
+	"github.com/google/exposure-notifications-server/internal/health"
+	"github.com/google/exposure-notifications-server/internal/loadshed"
 	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/observability"
 	"github.com/google/exposure-notifications-server/internal/publish"
+	"github.com/google/exposure-notifications-server/internal/server"
 	"github.com/google/exposure-notifications-server/internal/setup"
 )
 
@@ -37,11 +41,47 @@ func main() {
 	}
 	defer closer()
 
+	srv, err := server.NewFromEnv(ctx)
+	if err != nil {
+		logger.Fatalf("server.NewFromEnv: %v", err)
+	}
+
+	// Overloaded servers reject publish requests before doing any work. The
+	// rejections still take the minimum request duration, like any response.
+	shedder := loadshed.New(config.LoadShed, env.Database().AcquireStats)
+
 	handler, err := publish.NewHandler(ctx, &config, env)
 	if err != nil {
 		logger.Fatalf("unable to create publish handler: %v", err)
 	}
-	http.Handle("/", handlers.WithMinimumLatency(config.MinRequestDuration, handler))
+	http.Handle("/", handlers.WithMinimumLatency(config.MinRequestDuration,
+		handlers.WithPadding(config.ResponsePaddingBucketBytes, shedder.Handler(handler))))
+
+	v2Handler, err := publish.NewV2Handler(ctx, &config, env)
+	if err != nil {
+		logger.Fatalf("unable to create v2 publish handler: %v", err)
+	}
+	http.Handle("/v2/publish", handlers.WithMinimumLatency(config.MinRequestDuration,
+		handlers.WithPadding(config.ResponsePaddingBucketBytes, shedder.Handler(v2Handler))))
+
+	// Batch uploads come from health authority servers, not devices, so their
+	// timing and size needn't be hidden.
+	batchHandler, err := publish.NewBatchHandler(ctx, &config, env)
+	if err != nil {
+		logger.Fatalf("unable to create batch publish handler: %v", err)
+	}
+	http.Handle("/publish/batch", batchHandler)
+
+	checker := health.New(env)
+	http.Handle("/healthz", checker.HandleHealthz())
+	http.Handle("/readyz", checker.HandleReadyz())
+	http.Handle("/metrics", metrics.Handler())
+
 	logger.Infof("starting exposure server on :%s", config.Port)
-	log.Fatal(http.ListenAndServe(":"+config.Port, nil))
+	if err := srv.ListenAndServe(config.Port, observability.HTTPHandler(env.RequestLogger().Handler(http.DefaultServeMux))); err != nil {
+		logger.Fatalf("srv.ListenAndServe: %v", err)
+	}
+	if err := srv.Wait(); err != nil {
+		logger.Fatalf("srv.Wait: %v", err)
+	}
 }
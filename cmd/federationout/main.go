@@ -17,15 +17,14 @@ package main
 
 import (
 	"context"
-	"log"
 	"net"
-
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
+	"net/http"
 
 	"github.com/google/exposure-notifications-server/internal/federationout"
+	"github.com/google/exposure-notifications-server/internal/health"
 	"github.com/google/exposure-notifications-server/internal/logging"
-	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/server"
 	"github.com/google/exposure-notifications-server/internal/setup"
 )
 
@@ -40,29 +39,36 @@ func main() {
 	}
 	defer closer()
 
-	server := federationout.NewServer(env, &config)
-
-	var sopts []grpc.ServerOption
-	if config.TLSCertFile != "" && config.TLSKeyFile != "" {
-		creds, err := credentials.NewServerTLSFromFile(config.TLSCertFile, config.TLSKeyFile)
-		if err != nil {
-			log.Fatalf("Failed to generate credentials: %v", err)
-		}
-		sopts = append(sopts, grpc.Creds(creds))
+	srv, err := server.NewFromEnv(ctx)
+	if err != nil {
+		logger.Fatalf("server.NewFromEnv: %v", err)
 	}
 
-	if !config.AllowAnyClient {
-		sopts = append(sopts, grpc.UnaryInterceptor(server.(*federationout.Server).AuthInterceptor))
+	grpcServer, err := federationout.NewGRPCServer(env, &config)
+	if err != nil {
+		logger.Fatalf("federationout.NewGRPCServer: %v", err)
 	}
 
-	grpcServer := grpc.NewServer(sopts...)
-	pb.RegisterFederationServer(grpcServer, server)
-
 	grpcEndpoint := ":" + config.Port
 	listen, err := net.Listen("tcp", grpcEndpoint)
 	if err != nil {
 		logger.Fatalf("Failed to start server: %v", err)
 	}
+	// Load balancers and Kubernetes probe over HTTP, so the health endpoints
+	// of the gRPC server are served on their own port.
+	checker := health.New(env)
+	healthMux := http.NewServeMux()
+	healthMux.Handle("/healthz", checker.HandleHealthz())
+	healthMux.Handle("/readyz", checker.HandleReadyz())
+	healthMux.Handle("/metrics", metrics.Handler())
+	logger.Infof("Starting health listener on :%s", config.HealthPort)
+	if err := srv.ListenAndServe(config.HealthPort, healthMux); err != nil {
+		logger.Fatalf("srv.ListenAndServe: %v", err)
+	}
+
 	logger.Infof("Starting federationout gRPC listener [%s]", grpcEndpoint)
-	log.Fatal(grpcServer.Serve(listen))
+	srv.ServeGRPC(listen, grpcServer)
+	if err := srv.Wait(); err != nil {
+		logger.Fatalf("srv.Wait: %v", err)
+	}
 }
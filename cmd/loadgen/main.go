@@ -0,0 +1,338 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This tool sends publish traffic to an exposure server at a controlled rate
+// and reports the latency and outcome of the requests, for capacity planning.
+// Each publish carries valid keys, one for each of the days before today, and
+// a stub SafetyNet attestation of realistic size, so the target app must have
+// SafetyNet disabled. A share of the requests are chaff.
+//
+// The rate ramps from --qps to --max-qps over --ramp, then holds until
+// --duration has passed:
+//
+//	go run ./cmd/loadgen \
+//	  --url http://localhost:8080/v2/publish \
+//	  --app com.example.android.app \
+//	  --qps 10 --max-qps 200 --ramp 5m --duration 10m
+//
+// Servers hide failed publishes behind 200 OK unless DEBUG_API_RESPONSES is
+// set, so set it on the target to count them as errors.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/publish"
+	"github.com/google/exposure-notifications-server/internal/util"
+)
+
+var (
+	url         = flag.String("url", "http://localhost:8080", "The publish endpoint.")
+	appPackage  = flag.String("app", "com.example.android.app", "AppPackageName of the publishes.")
+	regions     = flag.String("regions", "US", "Comma separated regions of the publishes.")
+	numKeys     = flag.Int("keys", 14, "Keys per publish, one for each of the days before today.")
+	chaffRatio  = flag.Float64("chaff", 0.5, "Share of requests sent as chaff, between 0 and 1.")
+	qps         = flag.Float64("qps", 10, "Requests per second at the start.")
+	maxQPS      = flag.Float64("max-qps", 0, "Requests per second at the end of the ramp. Defaults to --qps.")
+	ramp        = flag.Duration("ramp", 0, "How long the rate takes to go from --qps to --max-qps.")
+	duration    = flag.Duration("duration", time.Minute, "How long to send requests.")
+	concurrency = flag.Int("concurrency", 500, "The most requests in flight. Requests due while this many are in flight are skipped.")
+	timeout     = flag.Duration("timeout", 30*time.Second, "Timeout of each request.")
+	reportEvery = flag.Duration("report", 10*time.Second, "How often to report progress.")
+)
+
+func main() {
+	flag.Parse()
+	rand.Seed(time.Now().UnixNano())
+	if *maxQPS <= 0 {
+		*maxQPS = *qps
+	}
+	if *qps <= 0 || *numKeys <= 0 || *concurrency <= 0 || *chaffRatio < 0 || *chaffRatio > 1 {
+		log.Fatal("--qps, --keys and --concurrency must be positive, and --chaff between 0 and 1.")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	g := &generator{
+		client:  &http.Client{Timeout: *timeout},
+		regions: strings.Split(*regions, ","),
+		stats:   map[string]*stats{"publish": {}, "chaff": {}},
+	}
+	log.Printf("Sending to %s for %v, %v to %v requests per second over %v", *url, *duration, *qps, *maxQPS, *ramp)
+	g.run(ctx)
+	g.report(os.Stdout, true)
+}
+
+// generator sends requests and records their outcomes.
+type generator struct {
+	client  *http.Client
+	regions []string
+
+	mu      sync.Mutex
+	stats   map[string]*stats
+	skipped int
+}
+
+// stats records the outcomes of one kind of request.
+type stats struct {
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    int
+}
+
+// run sends requests at the ramping rate until ctx is done, and waits for
+// those in flight.
+func (g *generator) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	inFlight := make(chan struct{}, *concurrency)
+	start := time.Now()
+	lastReport := start
+
+	next := start
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		now := time.Now()
+		next = next.Add(time.Duration(float64(time.Second) / rate(now.Sub(start))))
+		if now.Sub(lastReport) >= *reportEvery {
+			lastReport = now
+			log.Printf("%v elapsed, %.1f requests per second", now.Sub(start).Truncate(time.Second), rate(now.Sub(start)))
+			g.report(os.Stderr, false)
+		}
+
+		select {
+		case inFlight <- struct{}{}:
+		default:
+			g.mu.Lock()
+			g.skipped++
+			g.mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			g.send()
+		}()
+	}
+}
+
+// rate returns the requests per second once elapsed has passed.
+func rate(elapsed time.Duration) float64 {
+	if *ramp <= 0 || elapsed >= *ramp {
+		return *maxQPS
+	}
+	return *qps + (*maxQPS-*qps)*float64(elapsed)/float64(*ramp)
+}
+
+// send sends one publish, or chaff, and records its outcome. Requests in
+// flight when the run ends still complete, so the last ones count.
+func (g *generator) send() {
+	kind := "publish"
+	if rand.Float64() < *chaffRatio {
+		kind = "chaff"
+	}
+
+	body, err := newPublishBody(g.regions)
+	if err != nil {
+		log.Fatalf("unable to generate publish: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, *url, bytes.NewReader(body))
+	if err != nil {
+		log.Fatalf("unable to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if kind == "chaff" {
+		req.Header.Set(publish.ChaffHeader, "1")
+	}
+
+	start := time.Now()
+	resp, err := g.client.Do(req)
+	status := 0
+	if err == nil {
+		status = resp.StatusCode
+		err = readError(resp)
+	}
+	latency := time.Since(start)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := g.stats[kind]
+	if s.statuses == nil {
+		s.statuses = make(map[int]int)
+	}
+	s.latencies = append(s.latencies, latency)
+	s.statuses[status]++
+	if err != nil {
+		s.errors++
+	}
+}
+
+// readError reads and closes the body of resp, and returns an error if the
+// publish failed. Servers without DEBUG_API_RESPONSES answer most failures
+// with 200 OK.
+func readError(resp *http.Response) error {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// newPublishBody returns the JSON body of a publish of --keys valid keys.
+func newPublishBody(regions []string) ([]byte, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	data := &database.Publish{
+		Regions:        regions,
+		AppPackageName: *appPackage,
+		Platform:       "android",
+	}
+	for i := 1; i <= *numKeys; i++ {
+		key, err := util.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		risk, err := util.RandomTransmissionRisk()
+		if err != nil {
+			return nil, err
+		}
+		data.Keys = append(data.Keys, database.ExposureKey{
+			Key:              key,
+			IntervalNumber:   int32(today.AddDate(0, 0, -i).Unix() / 600),
+			IntervalCount:    144,
+			TransmissionRisk: risk,
+		})
+	}
+
+	attestation, err := stubAttestation(data)
+	if err != nil {
+		return nil, err
+	}
+	data.DeviceVerificationPayload = attestation
+	padding, err := util.RandomBytes(1000 + rand.Intn(1000))
+	if err != nil {
+		return nil, err
+	}
+	data.Padding = base64.RawStdEncoding.EncodeToString(padding)
+	return json.Marshal(data)
+}
+
+// stubAttestation returns a SafetyNet attestation of data with the size and
+// shape of a real one, whose signature doesn't verify.
+func stubAttestation(data *database.Publish) (string, error) {
+	header, err := json.Marshal(map[string]interface{}{"alg": "RS256", "x5c": []string{strings.Repeat("A", 1400), strings.Repeat("B", 1400)}})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"nonce":                      data.AndroidNonce(),
+		"timestampMs":                time.Now().UnixNano() / int64(time.Millisecond),
+		"apkPackageName":             data.AppPackageName,
+		"apkCertificateDigestSha256": []string{strings.Repeat("C", 44)},
+		"basicIntegrity":             true,
+		"ctsProfileMatch":            true,
+	})
+	if err != nil {
+		return "", err
+	}
+	sig, err := util.RandomBytes(256)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(header) + "." + enc.EncodeToString(payload) + "." + enc.EncodeToString(sig), nil
+}
+
+// report writes the outcomes so far: the count, error rate and latency
+// percentiles of each kind of request, and with details, the count of each
+// status.
+func (g *generator) report(w io.Writer, details bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, kind := range []string{"publish", "chaff"} {
+		s := g.stats[kind]
+		n := len(s.latencies)
+		if n == 0 {
+			continue
+		}
+		sorted := make([]time.Duration, n)
+		copy(sorted, s.latencies)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		fmt.Fprintf(w, "%-8s %6d requests, %5.1f%% errors, p50 %v, p90 %v, p99 %v, max %v\n",
+			kind, n, 100*float64(s.errors)/float64(n),
+			percentile(sorted, 50), percentile(sorted, 90), percentile(sorted, 99), sorted[n-1])
+		if details {
+			var statuses []int
+			for status := range s.statuses {
+				statuses = append(statuses, status)
+			}
+			sort.Ints(statuses)
+			for _, status := range statuses {
+				label := http.StatusText(status)
+				if status == 0 {
+					label = "no response"
+				}
+				fmt.Fprintf(w, "         %6d %d %s\n", s.statuses[status], status, label)
+			}
+		}
+	}
+	if g.skipped > 0 {
+		fmt.Fprintf(w, "%d requests skipped with --concurrency requests in flight\n", g.skipped)
+	}
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Round(time.Millisecond)
+}
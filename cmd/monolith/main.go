@@ -12,44 +12,88 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package main runs all the server components at different URL paths.
+// Package main runs all the server components at different URL paths, and the
+// federationout gRPC server on a port of its own, in one process. The handlers
+// that devices and health authorities call are served on PORT, and the admin
+// and job handlers on ADMIN_PORT, which must not be exposed publicly. Periodic
+// jobs whose intervals are set run in the process too, so that small
+// deployments and local development need only this binary and a database.
 package main
 
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 
+	"github.com/google/exposure-notifications-server/internal/adminconsole"
+	"github.com/google/exposure-notifications-server/internal/appadmin"
+	"github.com/google/exposure-notifications-server/internal/audit"
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
 	"github.com/google/exposure-notifications-server/internal/cleanup"
 	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/efgs"
 	"github.com/google/exposure-notifications-server/internal/export"
+	"github.com/google/exposure-notifications-server/internal/federationadmin"
 	"github.com/google/exposure-notifications-server/internal/federationin"
+	"github.com/google/exposure-notifications-server/internal/federationout"
+	"github.com/google/exposure-notifications-server/internal/federationpush"
 	"github.com/google/exposure-notifications-server/internal/handlers"
+	"github.com/google/exposure-notifications-server/internal/health"
+	"github.com/google/exposure-notifications-server/internal/keyrefresh"
+	"github.com/google/exposure-notifications-server/internal/loadshed"
 	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/observability"
 	"github.com/google/exposure-notifications-server/internal/publish"
+	"github.com/google/exposure-notifications-server/internal/scheduler"
+	"github.com/google/exposure-notifications-server/internal/server"
 	"github.com/google/exposure-notifications-server/internal/setup"
+	"github.com/google/exposure-notifications-server/internal/signing"
+	"github.com/google/exposure-notifications-server/internal/storage"
 )
 
 var _ setup.DBConfigProvider = (*MonoConfig)(nil)
 var _ setup.AuthorizedAppConfigProvider = (*MonoConfig)(nil)
 var _ setup.BlobStorageConfigProvider = (*MonoConfig)(nil)
 var _ setup.KeyManagerProvider = (*MonoConfig)(nil)
+var _ setup.KeyManagerConfigProvider = (*MonoConfig)(nil)
 
 type MonoConfig struct {
 	Port string `envconfig:"PORT" default:"8080"`
 
-	AuthorizedApp *authorizedapp.Config
-	Cleanup       *cleanup.Config
-	Export        *export.Config
-	Publish       *publish.Config
-	Database      *database.Config
-	FederationIn  *federationin.Config
+	// AdminPort is the port of the admin, cleanup, export, federation and key
+	// refresh handlers. The cron handlers among them don't authenticate their
+	// callers, so it must only be reachable from inside the deployment.
+	AdminPort string `envconfig:"ADMIN_PORT" default:"8081"`
+
+	// FederationOutPort, if set, is the port of the federationout gRPC
+	// server.
+	FederationOutPort string `envconfig:"FEDERATION_OUT_PORT"`
+
+	AdminConsole    *adminconsole.Config
+	AppAdmin        *appadmin.Config
+	AuthorizedApp   *authorizedapp.Config
+	Cleanup         *cleanup.Config
+	Export          *export.Config
+	Publish         *publish.Config
+	Database        *database.Config
+	EFGS            *efgs.Config
+	FederationAdmin *federationadmin.Config
+	FederationIn    *federationin.Config
+	FederationOut   *federationout.Config
+	FederationPush  *federationpush.Config
+	KeyRefresh      *keyrefresh.Config
+	Storage         *storage.Config
+	Signing         *signing.Config
+	Scheduler       *scheduler.Config
+	Server          *server.Config
 }
 
 func (c *MonoConfig) DB() *database.Config                       { return c.Database }
 func (c *MonoConfig) KeyManager() bool                           { return true }
-func (c *MonoConfig) BlobStorage() bool                          { return true }
+func (c *MonoConfig) KeyManagerConfig() *signing.Config          { return c.Signing }
+func (c *MonoConfig) BlobStorageConfig() *storage.Config         { return c.Storage }
 func (c *MonoConfig) AuthorizedAppConfig() *authorizedapp.Config { return c.AuthorizedApp }
 
 func main() {
@@ -71,41 +115,165 @@ func realMain(ctx context.Context) error {
 	}
 	defer closer()
 
+	srv := server.New(ctx, config.Server)
+
+	// Public handlers go on mux, and admin and cron handlers on adminMux.
+	mux := http.NewServeMux()
+	adminMux := http.NewServeMux()
+
 	// Cleanup export
 	cleanupExport, err := cleanup.NewExportHandler(config.Cleanup, env)
 	if err != nil {
 		return fmt.Errorf("cleanup.NewExportHandler: %w", err)
 	}
-	http.Handle("/cleanup-export", cleanupExport)
+	adminMux.Handle("/cleanup-export", cleanupExport)
+	jobs := cleanupExport.Jobs()
 
 	// Cleanup exposure
 	cleanupExposure, err := cleanup.NewExposureHandler(config.Cleanup, env)
 	if err != nil {
 		return fmt.Errorf("cleanup.NewExposureHandler: %w", err)
 	}
-	http.Handle("/cleanup-exposure", cleanupExposure)
+	adminMux.Handle("/cleanup-exposure", cleanupExposure)
+	jobs = append(jobs, cleanupExposure.Jobs()...)
+
+	// App admin
+	appAdmin, err := appadmin.NewHandler(env, config.AppAdmin)
+	if err != nil {
+		return fmt.Errorf("appadmin.NewHandler: %w", err)
+	}
+	adminMux.Handle("/app-admin/", http.StripPrefix("/app-admin", appAdmin))
+
+	// Audit log
+	auditLog, err := audit.NewHandler(env, config.AppAdmin.Timeout)
+	if err != nil {
+		return fmt.Errorf("audit.NewHandler: %w", err)
+	}
+	adminMux.Handle("/audit", auditLog)
+
+	// Admin console, only when configured
+	if config.AdminConsole.Audience != "" {
+		adminConsole, err := adminconsole.NewHandler(env, config.AdminConsole)
+		if err != nil {
+			return fmt.Errorf("adminconsole.NewHandler: %w", err)
+		}
+		adminMux.Handle("/admin-console/", http.StripPrefix("/admin-console", adminConsole))
+	}
 
 	// Export
 	exportServer, err := export.NewServer(config.Export, env)
 	if err != nil {
 		return fmt.Errorf("export.NewServer: %w", err)
 	}
-	http.HandleFunc("/export/create-batches", exportServer.CreateBatchesHandler)
-	http.HandleFunc("/export/do-work", exportServer.WorkerHandler)
+	adminMux.HandleFunc("/export/create-batches", exportServer.CreateBatchesHandler)
+	adminMux.HandleFunc("/export/do-work", exportServer.WorkerHandler)
+	adminMux.HandleFunc("/export/reexport", exportServer.ReexportHandler)
+	adminMux.HandleFunc("/export/stats", exportServer.StatsHandler)
+	adminMux.HandleFunc("/export/aggregate-stats", exportServer.AggregateStatsHandler)
+	adminMux.HandleFunc("/export/rotate-keys", exportServer.RotateKeysHandler)
+	adminMux.HandleFunc("/export/signing-keys", exportServer.SigningKeysHandler)
+	mux.Handle("/export/download/", http.StripPrefix("/export/download/", http.HandlerFunc(exportServer.DownloadHandler)))
+	jobs = append(jobs, exportServer.Jobs()...)
+
+	// Health authority key refresh
+	keyRefresh, err := keyrefresh.NewHandler(config.KeyRefresh, env)
+	if err != nil {
+		return fmt.Errorf("keyrefresh.NewHandler: %w", err)
+	}
+	adminMux.Handle("/key-refresh", keyRefresh)
+	jobs = append(jobs, keyRefresh.Jobs()...)
+
+	// Federation admin
+	federationAdmin, err := federationadmin.NewHandler(env, config.FederationAdmin)
+	if err != nil {
+		return fmt.Errorf("federationadmin.NewHandler: %w", err)
+	}
+	adminMux.Handle("/federation-admin/", http.StripPrefix("/federation-admin", federationAdmin))
 
 	// Federation in
-	http.Handle("/federation-in", federationin.NewHandler(env, config.FederationIn))
+	federationIn := federationin.NewHandler(env, config.FederationIn)
+	adminMux.Handle("/federation-in", federationIn)
+	jobs = append(jobs, federationIn.Jobs()...)
 
-	// Federation out
-	// TODO: this is a grpc listener and requires a lot of setup.
+	// Federation push
+	federationPush, err := federationpush.NewHandler(env, config.FederationPush)
+	if err != nil {
+		return fmt.Errorf("federationpush.NewHandler: %w", err)
+	}
+	adminMux.Handle("/federation-push", federationPush)
+	jobs = append(jobs, federationPush.Jobs()...)
 
-	// Publish
+	// EU federation gateway, only when configured
+	if config.EFGS.URL != "" {
+		efgsDownload, err := efgs.NewHandler(env, config.EFGS)
+		if err != nil {
+			return fmt.Errorf("efgs.NewHandler: %w", err)
+		}
+		adminMux.Handle("/efgs", efgsDownload)
+	}
+
+	// Federation out, only when configured
+	if config.FederationOutPort != "" {
+		grpcServer, err := federationout.NewGRPCServer(env, config.FederationOut)
+		if err != nil {
+			return fmt.Errorf("federationout.NewGRPCServer: %w", err)
+		}
+		listener, err := net.Listen("tcp", ":"+config.FederationOutPort)
+		if err != nil {
+			return fmt.Errorf("net.Listen: %w", err)
+		}
+		logger.Infof("federationout gRPC running at :%s", config.FederationOutPort)
+		srv.ServeGRPC(listener, grpcServer)
+	}
+
+	// Publish, wrapped like in the exposure server
+	shedder := loadshed.New(config.Publish.LoadShed, env.Database().AcquireStats)
 	publishServer, err := publish.NewHandler(ctx, config.Publish, env)
 	if err != nil {
 		return fmt.Errorf("publish.NewHandler: %w", err)
 	}
-	http.HandleFunc("/publish", handlers.WithMinimumLatency(config.Publish.MinRequestDuration, publishServer))
+	mux.Handle("/publish", handlers.WithMinimumLatency(config.Publish.MinRequestDuration,
+		handlers.WithPadding(config.Publish.ResponsePaddingBucketBytes, shedder.Handler(publishServer))))
+
+	publishV2Server, err := publish.NewV2Handler(ctx, config.Publish, env)
+	if err != nil {
+		return fmt.Errorf("publish.NewV2Handler: %w", err)
+	}
+	mux.Handle("/v2/publish", handlers.WithMinimumLatency(config.Publish.MinRequestDuration,
+		handlers.WithPadding(config.Publish.ResponsePaddingBucketBytes, shedder.Handler(publishV2Server))))
 
+	batchServer, err := publish.NewBatchHandler(ctx, config.Publish, env)
+	if err != nil {
+		return fmt.Errorf("publish.NewBatchHandler: %w", err)
+	}
+	mux.Handle("/publish/batch", batchServer)
+
+	// Scheduled jobs, only when their intervals are set
+	if len(jobs) > 0 {
+		sched, err := scheduler.New(config.Scheduler, scheduler.NewDBStore(env.Database()), jobs...)
+		if err != nil {
+			return fmt.Errorf("scheduler.New: %w", err)
+		}
+		srv.Go(sched.Run)
+	}
+
+	// Health
+	checker := health.New(env)
+	checker.AddBlobstore()
+	checker.AddSigners()
+	for _, m := range []*http.ServeMux{mux, adminMux} {
+		m.Handle("/healthz", checker.HandleHealthz())
+		m.Handle("/readyz", checker.HandleReadyz())
+	}
+	adminMux.Handle("/metrics", metrics.Handler())
+
+	logger.Infof("monolith admin handlers running at :%s", config.AdminPort)
+	if err := srv.ListenAndServe(config.AdminPort, observability.HTTPHandler(env.RequestLogger().Handler(adminMux))); err != nil {
+		return fmt.Errorf("srv.ListenAndServe: %w", err)
+	}
 	logger.Infof("monolith running at :%s", config.Port)
-	return http.ListenAndServe(":"+config.Port, nil)
+	if err := srv.ListenAndServe(config.Port, observability.HTTPHandler(env.RequestLogger().Handler(mux))); err != nil {
+		return fmt.Errorf("srv.ListenAndServe: %w", err)
+	}
+	return srv.Wait()
 }
@@ -0,0 +1,190 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This tool populates the database with realistic exposures, for demos,
+// staging environments and reproducing performance issues. The exposures are
+// uploads of --keys keys each, spread over the --days UTC days before today,
+// across --regions and --report-types:
+//
+//	go run ./cmd/seed --exposures 100000 --regions US,CA --days 14
+//
+// With --export, it also creates an export config for each region, with
+// batches covering the same days, and writes their files with the export
+// worker. It then needs the export server's environment, for the blobstore and
+// key manager:
+//
+//	go run ./cmd/seed --exposures 100000 --regions US --export \
+//	  --bucket-name exports --signing-key projects/.../cryptoKeyVersions/1
+//
+// Batches left over when WORKER_TIMEOUT passes are exported by the export
+// server's next /do-work. Runs with the same --seed generate the same
+// exposures.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/export"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/seed"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/setup"
+)
+
+var (
+	numExposures      = flag.Int("exposures", 10000, "The number of exposures to insert.")
+	regions           = flag.String("regions", "US", "Comma separated regions of the uploads.")
+	days              = flag.Int("days", 14, "The number of days before today over which the uploads are spread.")
+	keysPerUpload     = flag.Int("keys", 14, "Keys per upload, one for each of the days before the upload.")
+	reportTypes       = flag.String("report-types", "confirmed:8,likely:2", "Comma separated report types of the uploads, with their weights.")
+	appPackage        = flag.String("app", "com.example.android.app", "AppPackageName of the exposures.")
+	healthAuthorityID = flag.String("health-authority-id", "", "The health authority of the exposures, if any.")
+	travelers         = flag.Float64("travelers", 0.1, "Share of uploads marked as travelers, between 0 and 1.")
+	truncateWindow    = flag.Duration("truncate-window", time.Hour, "The creation window to which creation times are truncated, see TRUNCATE_WINDOW.")
+	randomSeed        = flag.Int64("seed", 0, "Seeds the generator. If 0, a random seed is used.")
+	batchSize         = flag.Int("batch-size", 1000, "Exposures inserted per transaction.")
+
+	doExport          = flag.Bool("export", false, "Also create export configs and batches for the days, and write their files.")
+	bucketName        = flag.String("bucket-name", "", "With --export, the bucket of the export files.")
+	filenamePrefix    = flag.String("filename-prefix", "seed", "With --export, the prefix of the export files, followed by the region.")
+	period            = flag.Duration("period", 24*time.Hour, "With --export, the period of the export configs.")
+	signingKey        = flag.String("signing-key", "", "With --export, the key manager ID of the key that signs the export files.")
+	signingKeyID      = flag.String("signing-key-id", "", "The ID of the signing key (for clients).")
+	signingKeyVersion = flag.String("signing-key-version", "", "The version of the signing key (for clients).")
+	bundleID          = flag.String("bundle-id", "", "The BundleID to put in export headers.")
+)
+
+// config is the environment of the tool without --export.
+type config struct {
+	Database *database.Config
+}
+
+var _ setup.DBConfigProvider = (*config)(nil)
+
+// DB returns the database config.
+func (c *config) DB() *database.Config {
+	return c.Database
+}
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+	logger := logging.FromContext(ctx)
+
+	weights, err := parseReportTypes(*reportTypes)
+	if err != nil {
+		logger.Fatalf("--report-types: %v", err)
+	}
+	if *randomSeed == 0 {
+		*randomSeed = time.Now().UnixNano()
+	}
+	now := time.Now()
+	opts := &seed.Options{
+		Exposures:         *numExposures,
+		Regions:           strings.Split(*regions, ","),
+		Days:              *days,
+		KeysPerUpload:     *keysPerUpload,
+		ReportTypes:       weights,
+		AppPackageName:    *appPackage,
+		HealthAuthorityID: *healthAuthorityID,
+		TravelerRate:      *travelers,
+		TruncateWindow:    *truncateWindow,
+		Now:               now,
+		Seed:              *randomSeed,
+	}
+	if err := opts.Validate(); err != nil {
+		logger.Fatalf("invalid flags: %v", err)
+	}
+	if *doExport && (*bucketName == "" || *signingKey == "") {
+		logger.Fatal("--export requires --bucket-name and --signing-key.")
+	}
+
+	var (
+		env          *serverenv.ServerEnv
+		closer       setup.Defer
+		exportConfig export.Config
+	)
+	if *doExport {
+		env, closer, err = setup.Setup(ctx, &exportConfig)
+	} else {
+		env, closer, err = setup.Setup(ctx, &config{})
+	}
+	if err != nil {
+		logger.Fatalf("setup.Setup: %v", err)
+	}
+	defer closer()
+	db := env.Database()
+
+	logger.Infof("Inserting %d exposures with seed %d", opts.Exposures, opts.Seed)
+	if err := seed.Insert(ctx, db, opts, *batchSize); err != nil {
+		logger.Fatalf("seed.Insert: %v", err)
+	}
+	if !*doExport {
+		return
+	}
+
+	_, err = seed.CreateExports(ctx, db, &seed.ExportOptions{
+		BucketName:        *bucketName,
+		FilenamePrefix:    *filenamePrefix,
+		Regions:           opts.Regions,
+		Period:            *period,
+		Days:              *days,
+		Now:               now,
+		SigningKey:        *signingKey,
+		SigningKeyID:      *signingKeyID,
+		SigningKeyVersion: *signingKeyVersion,
+		AppPackageName:    *appPackage,
+		BundleID:          *bundleID,
+	})
+	if err != nil {
+		logger.Fatalf("seed.CreateExports: %v", err)
+	}
+
+	srv, err := export.NewServer(&exportConfig, env)
+	if err != nil {
+		logger.Fatalf("export.NewServer: %v", err)
+	}
+	srv.RunWorker(ctx, os.Stdout)
+}
+
+// parseReportTypes parses report types with their weights, such as
+// confirmed:8,likely:2. A report type without a weight weighs 1.
+func parseReportTypes(s string) (map[string]int, error) {
+	weights := make(map[string]int)
+	for _, part := range strings.Split(s, ",") {
+		typ, weight := part, 1
+		if i := strings.Index(part, ":"); i >= 0 {
+			typ = part[:i]
+			w, err := strconv.Atoi(part[i+1:])
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("invalid weight in %q", part)
+			}
+			weight = w
+		}
+		switch typ {
+		case database.ReportTypeConfirmed, database.ReportTypeLikely, database.ReportTypeNegative:
+		default:
+			return nil, fmt.Errorf("unknown report type %q", typ)
+		}
+		weights[typ] = weight
+	}
+	return weights, nil
+}
@@ -0,0 +1,306 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package adminconsole is a web console and JSON API for the configuration
+// operators otherwise edit with SQL: export configs, signature infos,
+// authorized apps and health authorities.
+package adminconsole
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/audit"
+	authorizedappdb "github.com/google/exposure-notifications-server/internal/authorizedapp/database"
+	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/handlers"
+	"github.com/google/exposure-notifications-server/internal/jsonutil"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+)
+
+const (
+	apiPrefix = "/api"
+	idParam   = "id"
+)
+
+// consoleDB is the part of the database the console manages.
+type consoleDB interface {
+	ListExportConfigs(ctx context.Context) ([]*database.ExportConfig, error)
+	AddExportConfig(ctx context.Context, ec *database.ExportConfig) error
+	UpdateExportConfig(ctx context.Context, ec *database.ExportConfig) error
+
+	ListSignatureInfos(ctx context.Context) ([]*database.SignatureInfo, error)
+	AddSignatureInfo(ctx context.Context, si *database.SignatureInfo) error
+	UpdateSignatureInfoEnd(ctx context.Context, id int64, thru time.Time) error
+
+	ListHealthAuthorities(ctx context.Context) ([]*database.HealthAuthority, error)
+	AddHealthAuthority(ctx context.Context, ha *database.HealthAuthority) error
+	DeleteHealthAuthority(ctx context.Context, id string) error
+
+	ListHealthAuthorityKeys(ctx context.Context, healthAuthorityID string) ([]*database.HealthAuthorityKey, error)
+	AddHealthAuthorityKey(ctx context.Context, healthAuthorityID string, k *database.HealthAuthorityKey) error
+	UpdateHealthAuthorityKeyEnd(ctx context.Context, healthAuthorityID, version string, thru time.Time) error
+
+	ListConfigSettings(ctx context.Context) ([]*database.ConfigSetting, error)
+	SetConfigSetting(ctx context.Context, name, value string) error
+	DeleteConfigSetting(ctx context.Context, name string) error
+
+	AddAuditEntry(ctx context.Context, e *database.AuditEntry) error
+}
+
+// appDB is the part of the database that stores authorized apps.
+type appDB interface {
+	ListAuthorizedApps(ctx context.Context) ([]*model.AuthorizedApp, error)
+	AddAuthorizedApp(ctx context.Context, app *model.AuthorizedApp) error
+	DeleteAuthorizedApp(ctx context.Context, name string) error
+}
+
+// invalidator is implemented by AuthorizedApp providers that cache apps.
+type invalidator interface {
+	Invalidate(ctx context.Context, name string) error
+}
+
+// NewHandler returns the admin console. Each kind of configuration has an HTML
+// page at /NAME and a JSON API at /api/NAME, where NAME is export-configs,
+// signature-infos, apps, health-authorities, health-authority-keys or
+// settings. The API lists with GET, creates or updates one from a JSON body
+// with PUT, and, for apps, health authorities and settings, deletes one with
+// DELETE ?id=.
+func NewHandler(env *serverenv.ServerEnv, config *Config) (http.Handler, error) {
+	if env.Database() == nil {
+		return nil, fmt.Errorf("missing database in server environment")
+	}
+	auth, err := newAuthenticator(config)
+	if err != nil {
+		return nil, err
+	}
+	return &handler{
+		env:    env,
+		db:     env.Database(),
+		apps:   authorizedappdb.NewAuthorizedAppDB(env.Database()),
+		auth:   auth,
+		config: config,
+	}, nil
+}
+
+type handler struct {
+	env    *serverenv.ServerEnv
+	db     consoleDB
+	apps   appDB
+	auth   *authenticator
+	config *Config
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.Timeout)
+	defer cancel()
+	metrics := h.env.MetricsExporter(ctx)
+
+	email, err := h.auth.authenticate(ctx, r)
+	if err != nil {
+		metrics.WriteInt("admin-console-unauthorized", true, 1)
+		handlers.WriteError(ctx, w, "Admin console request", err)
+		return
+	}
+	ctx = audit.WithActor(ctx, email)
+
+	if r.URL.Path == "/" {
+		h.renderPage(ctx, w, http.StatusOK, indexPage, &page{Title: "Admin console", User: email, Resources: resources})
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+		res, ok := resourceByPath[strings.TrimPrefix(r.URL.Path, apiPrefix)]
+		if !ok {
+			handlers.WriteError(ctx, w, "Admin console request", &handlers.Error{Status: http.StatusNotFound, Message: http.StatusText(http.StatusNotFound)})
+			return
+		}
+		h.serveAPI(ctx, w, r, res, email)
+		return
+	}
+	res, ok := resourceByPath[r.URL.Path]
+	if !ok {
+		handlers.WriteError(ctx, w, "Admin console request", &handlers.Error{Status: http.StatusNotFound, Message: http.StatusText(http.StatusNotFound)})
+		return
+	}
+	h.servePage(ctx, w, r, res, email)
+}
+
+// serveAPI serves the JSON API of a resource.
+func (h *handler) serveAPI(ctx context.Context, w http.ResponseWriter, r *http.Request, res *resource, email string) {
+	var resp interface{}
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		resp, err = res.list(ctx, h)
+	case http.MethodPut:
+		it := res.newItem()
+		if code, uerr := jsonutil.Unmarshal(w, r, it); uerr != nil {
+			err = &handlers.Error{Status: code, Message: uerr.Error()}
+			break
+		}
+		if err = h.save(ctx, res, it); err == nil {
+			h.logChange(ctx, email, r, res)
+			resp = it
+		}
+	case http.MethodDelete:
+		if err = h.remove(ctx, res, r.URL.Query().Get(idParam)); err == nil {
+			h.logChange(ctx, email, r, res)
+		}
+	default:
+		err = &handlers.Error{Status: http.StatusMethodNotAllowed, Message: http.StatusText(http.StatusMethodNotAllowed)}
+	}
+	if err != nil {
+		handlers.WriteError(ctx, w, "Admin console request", err)
+		return
+	}
+
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logging.FromContext(ctx).Errorf("Failed writing response: %v", err)
+	}
+}
+
+// servePage serves the HTML page of a resource. Forms post back to the page
+// with an action of save or delete, and are redirected back to it when they
+// succeed.
+func (h *handler) servePage(ctx context.Context, w http.ResponseWriter, r *http.Request, res *resource, email string) {
+	p := &page{Title: res.title, User: email, Resources: resources}
+	status := http.StatusOK
+
+	switch r.Method {
+	case http.MethodGet:
+		switch r.URL.Query().Get("done") {
+		case "save":
+			p.Message = "Saved."
+		case "delete":
+			p.Message = "Deleted."
+		}
+	case http.MethodPost:
+		action, err := h.submit(ctx, r, res)
+		if err == nil {
+			h.logChange(ctx, email, r, res)
+			// A relative redirect keeps any path prefix the console is served under.
+			w.Header().Set("Location", "?done="+action)
+			w.WriteHeader(http.StatusSeeOther)
+			return
+		}
+		var ae *handlers.Error
+		if !errors.As(err, &ae) {
+			handlers.WriteError(ctx, w, "Admin console request", err)
+			return
+		}
+		status, p.Error = ae.Status, ae.Message
+	default:
+		handlers.WriteError(ctx, w, "Admin console request", &handlers.Error{Status: http.StatusMethodNotAllowed, Message: http.StatusText(http.StatusMethodNotAllowed)})
+		return
+	}
+
+	items, err := res.list(ctx, h)
+	if err != nil {
+		handlers.WriteError(ctx, w, "Admin console request", err)
+		return
+	}
+	p.Items = items
+	h.renderPage(ctx, w, status, res.page, p)
+}
+
+// submit applies a form posted to a resource's page and returns its action.
+func (h *handler) submit(ctx context.Context, r *http.Request, res *resource) (string, error) {
+	if !sameOrigin(r) {
+		return "", &handlers.Error{Status: http.StatusForbidden, Message: "cross-origin form posts are not allowed"}
+	}
+	if err := r.ParseForm(); err != nil {
+		return "", handlers.BadRequest("invalid form: %v", err)
+	}
+
+	action := r.PostForm.Get("action")
+	switch action {
+	case "save":
+		it, err := res.fromForm(&form{values: r.PostForm})
+		if err != nil {
+			return "", err
+		}
+		return action, h.save(ctx, res, it)
+	case "delete":
+		return action, h.remove(ctx, res, r.PostForm.Get(idParam))
+	}
+	return "", handlers.BadRequest("unknown action %q", action)
+}
+
+// save validates and stores an item of res, and records the change in the
+// audit log.
+func (h *handler) save(ctx context.Context, res *resource, it item) error {
+	if err := it.save(ctx, h); err != nil {
+		return err
+	}
+	audit.Record(ctx, h.db, audit.ActionSave, res.audit, it.auditID(), it)
+	return nil
+}
+
+func (h *handler) remove(ctx context.Context, res *resource, id string) error {
+	if res.remove == nil {
+		return handlers.Errorf(http.StatusMethodNotAllowed, "%s can't be deleted", res.title)
+	}
+	err := res.remove(ctx, h, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return handlers.Errorf(http.StatusNotFound, "unknown %s %q", idParam, id)
+	}
+	if err == nil {
+		audit.Record(ctx, h.db, audit.ActionDelete, res.audit, id, nil)
+	}
+	return err
+}
+
+func (h *handler) logChange(ctx context.Context, email string, r *http.Request, res *resource) {
+	h.env.MetricsExporter(ctx).WriteInt("admin-console-changes", true, 1)
+	logging.FromContext(ctx).Infof("%v: %s %s (%s)", email, r.Method, r.URL.Path, res.title)
+}
+
+// invalidate drops the app from this server's AuthorizedApp cache, and the
+// cache shared with other servers, if there are any, so that changes apply
+// at once here and wherever the app isn't cached in memory.
+func (h *handler) invalidate(ctx context.Context, name string) {
+	if inv, ok := h.env.AuthorizedAppProvider().(invalidator); ok {
+		if err := inv.Invalidate(ctx, name); err != nil {
+			logging.FromContext(ctx).Errorf("Failed to invalidate app %v: %v", name, err)
+		}
+	}
+}
+
+// sameOrigin reports whether a form post comes from the console itself.
+// Browsers send the proxy's session cookie with cross-site posts too, so
+// these must be rejected.
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
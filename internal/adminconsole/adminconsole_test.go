@@ -0,0 +1,315 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adminconsole
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeDB keeps health authorities and audit entries in memory. The other kinds
+// of configuration are always empty.
+type fakeDB struct {
+	has   map[string]*database.HealthAuthority
+	audit []*database.AuditEntry
+}
+
+func (f *fakeDB) ListExportConfigs(ctx context.Context) ([]*database.ExportConfig, error) {
+	return nil, nil
+}
+
+func (f *fakeDB) AddExportConfig(ctx context.Context, ec *database.ExportConfig) error {
+	return nil
+}
+
+func (f *fakeDB) UpdateExportConfig(ctx context.Context, ec *database.ExportConfig) error {
+	return database.ErrNotFound
+}
+
+func (f *fakeDB) ListSignatureInfos(ctx context.Context) ([]*database.SignatureInfo, error) {
+	return nil, nil
+}
+
+func (f *fakeDB) AddSignatureInfo(ctx context.Context, si *database.SignatureInfo) error {
+	return nil
+}
+
+func (f *fakeDB) UpdateSignatureInfoEnd(ctx context.Context, id int64, thru time.Time) error {
+	return database.ErrNotFound
+}
+
+func (f *fakeDB) ListHealthAuthorities(ctx context.Context) ([]*database.HealthAuthority, error) {
+	var has []*database.HealthAuthority
+	for _, ha := range f.has {
+		has = append(has, ha)
+	}
+	sort.Slice(has, func(i, j int) bool { return has[i].ID < has[j].ID })
+	return has, nil
+}
+
+func (f *fakeDB) AddHealthAuthority(ctx context.Context, ha *database.HealthAuthority) error {
+	f.has[ha.ID] = ha
+	return nil
+}
+
+func (f *fakeDB) DeleteHealthAuthority(ctx context.Context, id string) error {
+	if _, ok := f.has[id]; !ok {
+		return database.ErrNotFound
+	}
+	delete(f.has, id)
+	return nil
+}
+
+func (f *fakeDB) ListHealthAuthorityKeys(ctx context.Context, healthAuthorityID string) ([]*database.HealthAuthorityKey, error) {
+	return nil, nil
+}
+
+func (f *fakeDB) AddHealthAuthorityKey(ctx context.Context, healthAuthorityID string, k *database.HealthAuthorityKey) error {
+	return nil
+}
+
+func (f *fakeDB) UpdateHealthAuthorityKeyEnd(ctx context.Context, healthAuthorityID, version string, thru time.Time) error {
+	return database.ErrNotFound
+}
+
+func (f *fakeDB) ListConfigSettings(ctx context.Context) ([]*database.ConfigSetting, error) {
+	return nil, nil
+}
+
+func (f *fakeDB) SetConfigSetting(ctx context.Context, name, value string) error {
+	return nil
+}
+
+func (f *fakeDB) DeleteConfigSetting(ctx context.Context, name string) error {
+	return database.ErrNotFound
+}
+
+func (f *fakeDB) ListAuthorizedApps(ctx context.Context) ([]*model.AuthorizedApp, error) {
+	return nil, nil
+}
+
+func (f *fakeDB) AddAuthorizedApp(ctx context.Context, app *model.AuthorizedApp) error {
+	return nil
+}
+
+func (f *fakeDB) DeleteAuthorizedApp(ctx context.Context, name string) error {
+	return database.ErrNotFound
+}
+
+func (f *fakeDB) AddAuditEntry(ctx context.Context, e *database.AuditEntry) error {
+	f.audit = append(f.audit, e)
+	return nil
+}
+
+type testConsole struct {
+	h      *handler
+	db     *fakeDB
+	issuer *testIssuer
+}
+
+func newTestConsole(t *testing.T) *testConsole {
+	t.Helper()
+
+	issuer := newTestIssuer(t)
+	db := &fakeDB{has: make(map[string]*database.HealthAuthority)}
+	return &testConsole{
+		h: &handler{
+			env:    serverenv.New(context.Background(), serverenv.WithMetricsExporter(metrics.NewLogsBasedFromContext)),
+			db:     db,
+			apps:   db,
+			auth:   newTestAuthenticator(t, issuer),
+			config: &Config{Timeout: time.Minute},
+		},
+		db:     db,
+		issuer: issuer,
+	}
+}
+
+// serve sends the request as the allowed operator.
+func (c *testConsole) serve(t *testing.T, r *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r.Header.Set(iapHeader, c.issuer.sign(t, c.issuer.claims("operator@example.com")))
+	if r.Header.Get("Content-Type") == "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	c.h.ServeHTTP(w, r)
+	return w
+}
+
+func TestUnauthenticated(t *testing.T) {
+	c := newTestConsole(t)
+	defer c.issuer.srv.Close()
+
+	w := httptest.NewRecorder()
+	c.h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/health-authorities", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestHealthAuthorityAPI(t *testing.T) {
+	c := newTestConsole(t)
+	defer c.issuer.srv.Close()
+
+	body := `{"healthAuthorityId":"us-wa","allowTravelers":true,"onsetTransmissionRisk":{"3":6}}`
+	w := c.serve(t, httptest.NewRequest(http.MethodPut, "/api/health-authorities", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT: got status %d: %s", w.Code, w.Body)
+	}
+
+	w = c.serve(t, httptest.NewRequest(http.MethodPut, "/api/health-authorities",
+		strings.NewReader(`{"healthAuthorityId":"us-ca","onsetTransmissionRisk":{"3":9}}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("PUT invalid risk: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = c.serve(t, httptest.NewRequest(http.MethodGet, "/api/health-authorities", nil))
+	var got []*HealthAuthority
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []*HealthAuthority{{ID: "us-wa", AllowTravelers: true, OnsetTransmissionRisk: map[int32]int{3: 6}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GET mismatch (-want, +got):\n%s", diff)
+	}
+
+	cases := []struct {
+		method string
+		target string
+		status int
+	}{
+		{http.MethodDelete, "/api/health-authorities?id=us-wa", http.StatusNoContent},
+		{http.MethodDelete, "/api/health-authorities?id=us-wa", http.StatusNotFound},
+		{http.MethodDelete, "/api/export-configs?id=1", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/health-authorities", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/unknown", http.StatusNotFound},
+		{http.MethodDelete, "/api/settings?id=CLEANUP_TTL", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		w := c.serve(t, httptest.NewRequest(tc.method, tc.target, nil))
+		if w.Code != tc.status {
+			t.Errorf("%s %s: got status %d, want %d", tc.method, tc.target, w.Code, tc.status)
+		}
+	}
+
+	var audited []string
+	for _, e := range c.db.audit {
+		audited = append(audited, e.Actor+" "+e.Action+" "+e.Resource+" "+e.ResourceID)
+	}
+	wantAudit := []string{
+		"operator@example.com save health_authority us-wa",
+		"operator@example.com delete health_authority us-wa",
+	}
+	if diff := cmp.Diff(wantAudit, audited); diff != "" {
+		t.Errorf("audit mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestHealthAuthorityForm(t *testing.T) {
+	form := url.Values{
+		"action":                {"save"},
+		"healthAuthorityId":     {"us-wa"},
+		"embargoSameDayKeys":    {"on"},
+		"onsetTransmissionRisk": {"0=8, 7=2"},
+	}
+
+	cases := []struct {
+		name   string
+		header map[string]string
+		status int
+		want   map[string]*database.HealthAuthority
+	}{
+		{
+			name:   "same origin",
+			header: map[string]string{"Origin": "http://example.com", "Sec-Fetch-Site": "same-origin"},
+			status: http.StatusSeeOther,
+			want: map[string]*database.HealthAuthority{
+				"us-wa": {ID: "us-wa", EmbargoSameDayKeys: true, OnsetTransmissionRisk: map[int32]int{0: 8, 7: 2}},
+			},
+		},
+		{
+			name:   "cross origin",
+			header: map[string]string{"Origin": "https://attacker.example"},
+			status: http.StatusForbidden,
+			want:   map[string]*database.HealthAuthority{},
+		},
+		{
+			name:   "cross site",
+			header: map[string]string{"Sec-Fetch-Site": "cross-site"},
+			status: http.StatusForbidden,
+			want:   map[string]*database.HealthAuthority{},
+		},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestConsole(t)
+			defer c.issuer.srv.Close()
+
+			r := httptest.NewRequest(http.MethodPost, "/health-authorities", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for k, v := range tc.header {
+				r.Header.Set(k, v)
+			}
+			w := c.serve(t, r)
+			if w.Code != tc.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tc.status, w.Body)
+			}
+			if tc.status == http.StatusSeeOther {
+				if loc := w.Header().Get("Location"); loc != "?done=save" {
+					t.Errorf("got Location %q", loc)
+				}
+			}
+			if diff := cmp.Diff(tc.want, c.db.has); diff != "" {
+				t.Errorf("health authorities mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSettingsAPI(t *testing.T) {
+	c := newTestConsole(t)
+	defer c.issuer.srv.Close()
+
+	cases := []struct {
+		body   string
+		status int
+	}{
+		{`{"name":"CLEANUP_TTL","value":"240h"}`, http.StatusOK},
+		{`{"name":"CLEANUP_TTL","value":"ten days"}`, http.StatusBadRequest},
+		{`{"name":"DB_PASSWORD","value":"secret"}`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		w := c.serve(t, httptest.NewRequest(http.MethodPut, "/api/settings", strings.NewReader(tc.body)))
+		if w.Code != tc.status {
+			t.Errorf("PUT %s: got status %d, want %d", tc.body, w.Code, tc.status)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adminconsole
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/exposure-notifications-server/internal/handlers"
+	"github.com/google/exposure-notifications-server/internal/verification"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Authentication modes.
+const (
+	AuthIAP  = "IAP"
+	AuthOIDC = "OIDC"
+)
+
+const (
+	// iapHeader carries the JWT that Identity-Aware Proxy signs for each
+	// request it lets through.
+	iapHeader  = "X-Goog-IAP-JWT-Assertion"
+	iapIssuer  = "https://cloud.google.com/iap"
+	iapJWKSURI = "https://www.gstatic.com/iap/verify/public_key-jwk"
+
+	bearer = "Bearer "
+)
+
+// operatorClaims are the claims of the token that authenticates an operator.
+type operatorClaims struct {
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified"`
+
+	jwt.StandardClaims
+}
+
+// authenticator checks the token on each request and that it belongs to an
+// allowed operator.
+type authenticator struct {
+	// header is the request header with the token, or empty for a bearer
+	// token in the Authorization header.
+	header   string
+	issuers  []string
+	audience string
+	jwksURI  string
+	emails   map[string]struct{}
+	keys     *verification.KeySet
+}
+
+func newAuthenticator(config *Config) (*authenticator, error) {
+	if config.Audience == "" {
+		return nil, fmt.Errorf("ADMIN_AUDIENCE is required")
+	}
+	if len(config.AllowedEmails) == 0 {
+		return nil, fmt.Errorf("ADMIN_ALLOWED_EMAILS is required")
+	}
+	a := &authenticator{
+		audience: config.Audience,
+		emails:   make(map[string]struct{}, len(config.AllowedEmails)),
+		keys:     verification.NewKeySet(config.KeysCacheDuration),
+	}
+	for _, e := range config.AllowedEmails {
+		a.emails[strings.ToLower(strings.TrimSpace(e))] = struct{}{}
+	}
+
+	switch config.AuthMode {
+	case AuthIAP:
+		a.header = iapHeader
+		a.issuers = []string{iapIssuer}
+		a.jwksURI = iapJWKSURI
+	case AuthOIDC:
+		if len(config.OIDCIssuers) == 0 || config.OIDCJWKSURI == "" {
+			return nil, fmt.Errorf("OIDC_ISSUERS and OIDC_JWKS_URI are required with ADMIN_AUTH_MODE=%s", AuthOIDC)
+		}
+		a.issuers = config.OIDCIssuers
+		a.jwksURI = config.OIDCJWKSURI
+	default:
+		return nil, fmt.Errorf("ADMIN_AUTH_MODE must be %s or %s, got %q", AuthIAP, AuthOIDC, config.AuthMode)
+	}
+	return a, nil
+}
+
+// authenticate returns the email of the operator making the request.
+func (a *authenticator) authenticate(ctx context.Context, r *http.Request) (string, error) {
+	var raw string
+	if a.header != "" {
+		raw = r.Header.Get(a.header)
+	} else if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, bearer) {
+		raw = strings.TrimPrefix(auth, bearer)
+	}
+	if raw == "" {
+		return "", &handlers.Error{Status: http.StatusUnauthorized, Message: "missing token"}
+	}
+
+	claims := &operatorClaims{}
+	if _, err := jwt.ParseWithClaims(raw, claims, func(tok *jwt.Token) (interface{}, error) {
+		switch tok.Method.(type) {
+		case *jwt.SigningMethodECDSA, *jwt.SigningMethodRSA:
+		default:
+			return nil, fmt.Errorf("unsupported signing method %v", tok.Header["alg"])
+		}
+		kid, ok := tok.Header["kid"].(string)
+		if !ok || kid == "" {
+			return nil, fmt.Errorf("missing kid header")
+		}
+		return a.keys.Key(ctx, a.jwksURI, kid)
+	}); err != nil {
+		return "", handlers.Errorf(http.StatusUnauthorized, "invalid token: %v", err)
+	}
+
+	// Valid, called by ParseWithClaims, accepts tokens without an expiry.
+	if claims.ExpiresAt == 0 {
+		return "", &handlers.Error{Status: http.StatusUnauthorized, Message: "token has no expiry"}
+	}
+
+	trusted := false
+	for _, iss := range a.issuers {
+		if claims.VerifyIssuer(iss, true) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return "", handlers.Errorf(http.StatusUnauthorized, "token issuer %q is not trusted", claims.Issuer)
+	}
+	if !claims.VerifyAudience(a.audience, true) {
+		return "", handlers.Errorf(http.StatusUnauthorized, "token audience %q is not %q", claims.Audience, a.audience)
+	}
+	if claims.EmailVerified != nil && !*claims.EmailVerified {
+		return "", &handlers.Error{Status: http.StatusUnauthorized, Message: "token email is not verified"}
+	}
+
+	email := strings.ToLower(claims.Email)
+	if _, ok := a.emails[email]; !ok {
+		return "", handlers.Errorf(http.StatusForbidden, "%q may not use the admin console", claims.Email)
+	}
+	return email, nil
+}
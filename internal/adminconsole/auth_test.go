@@ -0,0 +1,189 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adminconsole
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/handlers"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const testAudience = "/projects/1234/global/backendServices/5678"
+
+// testIssuer signs tokens with a key published at its JWKS URI.
+type testIssuer struct {
+	key *ecdsa.PrivateKey
+	srv *httptest.Server
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Coordinates are fixed-size in a JWK.
+	coord := func(b []byte) string {
+		padded := make([]byte, 32)
+		copy(padded[32-len(b):], b)
+		return base64.RawURLEncoding.EncodeToString(padded)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "EC",
+				"kid": "v1",
+				"crv": "P-256",
+				"x":   coord(key.X.Bytes()),
+				"y":   coord(key.Y.Bytes()),
+			}},
+		})
+	}))
+	return &testIssuer{key: key, srv: srv}
+}
+
+func (i *testIssuer) claims(email string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   iapIssuer,
+		"sub":   "accounts.google.com:1234",
+		"aud":   testAudience,
+		"email": email,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func (i *testIssuer) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	tok.Header["kid"] = "v1"
+	raw, err := tok.SignedString(i.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// newTestAuthenticator returns an authenticator for IAP tokens from the
+// issuer.
+func newTestAuthenticator(t *testing.T, issuer *testIssuer) *authenticator {
+	t.Helper()
+
+	a, err := newAuthenticator(&Config{
+		AuthMode:          AuthIAP,
+		Audience:          testAudience,
+		AllowedEmails:     []string{"Operator@example.com"},
+		KeysCacheDuration: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.jwksURI = issuer.srv.URL
+	return a
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	cases := []struct {
+		name   string
+		config Config
+		err    string
+	}{
+		{name: "iap", config: Config{AuthMode: AuthIAP, Audience: testAudience, AllowedEmails: []string{"a@example.com"}}},
+		{name: "oidc", config: Config{AuthMode: AuthOIDC, Audience: "client-id", AllowedEmails: []string{"a@example.com"}, OIDCIssuers: []string{"https://accounts.google.com"}, OIDCJWKSURI: "https://www.googleapis.com/oauth2/v3/certs"}},
+		{name: "no audience", config: Config{AuthMode: AuthIAP, AllowedEmails: []string{"a@example.com"}}, err: "ADMIN_AUDIENCE"},
+		{name: "no emails", config: Config{AuthMode: AuthIAP, Audience: testAudience}, err: "ADMIN_ALLOWED_EMAILS"},
+		{name: "unknown mode", config: Config{AuthMode: "NONE", Audience: testAudience, AllowedEmails: []string{"a@example.com"}}, err: "ADMIN_AUTH_MODE"},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newAuthenticator(&tc.config)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	issuer := newTestIssuer(t)
+	defer issuer.srv.Close()
+	a := newTestAuthenticator(t, issuer)
+
+	cases := []struct {
+		name   string
+		claims func(c jwt.MapClaims)
+		header string
+		status int
+	}{
+		{name: "valid"},
+		{name: "missing token", header: "-", status: http.StatusUnauthorized},
+		{name: "not allowed", claims: func(c jwt.MapClaims) { c["email"] = "intruder@example.com" }, status: http.StatusForbidden},
+		{name: "wrong audience", claims: func(c jwt.MapClaims) { c["aud"] = "/projects/1234/global/backendServices/0" }, status: http.StatusUnauthorized},
+		{name: "wrong issuer", claims: func(c jwt.MapClaims) { c["iss"] = "https://accounts.google.com" }, status: http.StatusUnauthorized},
+		{name: "expired", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, status: http.StatusUnauthorized},
+		{name: "no expiry", claims: func(c jwt.MapClaims) { delete(c, "exp") }, status: http.StatusUnauthorized},
+		{name: "unverified email", claims: func(c jwt.MapClaims) { c["email_verified"] = false }, status: http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			claims := issuer.claims("operator@example.com")
+			if tc.claims != nil {
+				tc.claims(claims)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "-" {
+				r.Header.Set(iapHeader, issuer.sign(t, claims))
+			}
+
+			email, err := a.authenticate(ctx, r)
+			if tc.status == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if email != "operator@example.com" {
+					t.Errorf("got email %q, want %q", email, "operator@example.com")
+				}
+				return
+			}
+			ae, ok := err.(*handlers.Error)
+			if !ok || ae.Status != tc.status {
+				t.Errorf("got error %v, want status %d", err, tc.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adminconsole
+
+import (
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/setup"
+)
+
+// Compile-time check to assert this config matches requirements.
+var _ setup.DBConfigProvider = (*Config)(nil)
+var _ setup.AuthorizedAppConfigProvider = (*Config)(nil)
+
+// Config is the configuration for the admin console.
+type Config struct {
+	Database *database.Config
+	Port     string        `envconfig:"PORT" default:"8080"`
+	Timeout  time.Duration `envconfig:"RPC_TIMEOUT" default:"30s"`
+
+	// AuthorizedApp configures the cache of authorized apps, which the console
+	// invalidates when it changes an app, including the cache shared with
+	// the publish servers.
+	AuthorizedApp *authorizedapp.Config
+
+	// AuthMode is how operators are authenticated. IAP checks the JWT that
+	// Identity-Aware Proxy adds to every request it lets through, and OIDC
+	// checks an OIDC ID token sent as a bearer token.
+	AuthMode string `envconfig:"ADMIN_AUTH_MODE" default:"IAP"`
+
+	// Audience is the aud claim the token must carry. For IAP, this is
+	// /projects/PROJECT_NUMBER/global/backendServices/SERVICE_ID, or
+	// /projects/PROJECT_NUMBER/apps/PROJECT_ID on App Engine. For OIDC, this
+	// is the client ID the tokens are issued to.
+	Audience string `envconfig:"ADMIN_AUDIENCE"`
+
+	// OIDCIssuers are the accepted issuers of OIDC ID tokens, and
+	// OIDCJWKSURI is where their signing keys are published.
+	OIDCIssuers []string `envconfig:"OIDC_ISSUERS" default:"https://accounts.google.com,accounts.google.com"`
+	OIDCJWKSURI string   `envconfig:"OIDC_JWKS_URI" default:"https://www.googleapis.com/oauth2/v3/certs"`
+
+	// AllowedEmails are the operators who may use the console.
+	AllowedEmails []string `envconfig:"ADMIN_ALLOWED_EMAILS"`
+
+	// KeysCacheDuration is how long the token signing keys are cached.
+	KeysCacheDuration time.Duration `envconfig:"ADMIN_KEYS_CACHE_DURATION" default:"1h"`
+}
+
+// DB returns the database config.
+func (c *Config) DB() *database.Config {
+	return c.Database
+}
+
+// AuthorizedAppConfig returns the authorized app config.
+func (c *Config) AuthorizedAppConfig() *authorizedapp.Config {
+	return c.AuthorizedApp
+}
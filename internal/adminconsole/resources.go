@@ -0,0 +1,766 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adminconsole
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/appadmin"
+	"github.com/google/exposure-notifications-server/internal/audit"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/handlers"
+	"github.com/google/exposure-notifications-server/internal/runtimeconfig"
+	"github.com/google/exposure-notifications-server/internal/verification"
+)
+
+const oneDay = 24 * time.Hour
+
+// resource is a kind of configuration the console manages.
+type resource struct {
+	path  string
+	title string
+	page  *template.Template
+	// audit names the resource in the audit log.
+	audit string
+
+	// list returns every item, for the page and the API.
+	list func(ctx context.Context, h *handler) (interface{}, error)
+	// newItem returns an empty item to decode an API request into.
+	newItem func() item
+	// fromForm parses an item from the page's form.
+	fromForm func(f *form) (item, error)
+	// remove deletes the item with the given ID. It is nil if items can't be
+	// deleted.
+	remove func(ctx context.Context, h *handler, id string) error
+}
+
+// item is a single configuration, decoded from a request.
+type item interface {
+	// save validates and creates or updates the item, and updates it with
+	// what was stored.
+	save(ctx context.Context, h *handler) error
+	// auditID identifies the item in the audit log.
+	auditID() string
+}
+
+var resources = []*resource{
+	{
+		path:     "/export-configs",
+		title:    "Export configs",
+		audit:    audit.ResourceExportConfig,
+		page:     exportConfigsPage,
+		list:     listExportConfigs,
+		newItem:  func() item { return &ExportConfig{} },
+		fromForm: exportConfigFromForm,
+	},
+	{
+		path:     "/signature-infos",
+		title:    "Signature infos",
+		audit:    audit.ResourceSignatureInfo,
+		page:     signatureInfosPage,
+		list:     listSignatureInfos,
+		newItem:  func() item { return &SignatureInfo{} },
+		fromForm: signatureInfoFromForm,
+	},
+	{
+		path:     "/apps",
+		title:    "Authorized apps",
+		audit:    audit.ResourceAuthorizedApp,
+		page:     appsPage,
+		list:     listApps,
+		newItem:  func() item { return &App{} },
+		fromForm: appFromForm,
+		remove:   removeApp,
+	},
+	{
+		path:     "/health-authorities",
+		title:    "Health authorities",
+		audit:    audit.ResourceHealthAuthority,
+		page:     healthAuthoritiesPage,
+		list:     listHealthAuthorities,
+		newItem:  func() item { return &HealthAuthority{} },
+		fromForm: healthAuthorityFromForm,
+		remove:   removeHealthAuthority,
+	},
+	{
+		path:     "/health-authority-keys",
+		title:    "Health authority keys",
+		audit:    audit.ResourceHealthAuthorityKey,
+		page:     healthAuthorityKeysPage,
+		list:     listHealthAuthorityKeys,
+		newItem:  func() item { return &HealthAuthorityKey{} },
+		fromForm: healthAuthorityKeyFromForm,
+	},
+	{
+		path:     "/settings",
+		title:    "Runtime settings",
+		audit:    audit.ResourceConfigSetting,
+		page:     settingsPage,
+		list:     listSettings,
+		newItem:  func() item { return &Setting{} },
+		fromForm: settingFromForm,
+		remove:   removeSetting,
+	},
+}
+
+var resourceByPath = func() map[string]*resource {
+	m := make(map[string]*resource, len(resources))
+	for _, r := range resources {
+		m[r.path] = r
+	}
+	return m
+}()
+
+// ExportConfig is an export config. Only Thru and SignatureInfoIDs of an
+// existing config can be updated.
+type ExportConfig struct {
+	ConfigID            int64      `json:"configId,omitempty"`
+	BucketName          string     `json:"bucketName"`
+	FilenameRoot        string     `json:"filenameRoot"`
+	Period              string     `json:"period"`
+	Region              string     `json:"region"`
+	From                *time.Time `json:"from,omitempty"`
+	Thru                *time.Time `json:"thru,omitempty"`
+	SignatureInfoIDs    []int64    `json:"signatureInfoIds,omitempty"`
+	HealthAuthorityID   string     `json:"healthAuthorityId,omitempty"`
+	IncludeTravelers    bool       `json:"includeTravelers,omitempty"`
+	IncludeReportTypes  []string   `json:"includeReportTypes,omitempty"`
+	MinTransmissionRisk int        `json:"minTransmissionRisk,omitempty"`
+	DeltaOfConfigID     int64      `json:"deltaOfConfigId,omitempty"`
+}
+
+func listExportConfigs(ctx context.Context, h *handler) (interface{}, error) {
+	configs, err := h.db.ListExportConfigs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]*ExportConfig, 0, len(configs))
+	for _, ec := range configs {
+		resp = append(resp, &ExportConfig{
+			ConfigID:            ec.ConfigID,
+			BucketName:          ec.BucketName,
+			FilenameRoot:        ec.FilenameRoot,
+			Period:              ec.Period.String(),
+			Region:              ec.Region,
+			From:                timePtr(ec.From),
+			Thru:                timePtr(ec.Thru),
+			SignatureInfoIDs:    ec.SignatureInfoIDs,
+			HealthAuthorityID:   ec.HealthAuthorityID,
+			IncludeTravelers:    ec.IncludeTravelers,
+			IncludeReportTypes:  ec.IncludeReportTypes,
+			MinTransmissionRisk: ec.MinTransmissionRisk,
+			DeltaOfConfigID:     ec.DeltaOfConfigID,
+		})
+	}
+	return resp, nil
+}
+
+func exportConfigFromForm(f *form) (item, error) {
+	ec := &ExportConfig{
+		ConfigID:            f.int64("configId"),
+		BucketName:          f.str("bucketName"),
+		FilenameRoot:        f.str("filenameRoot"),
+		Period:              f.str("period"),
+		Region:              f.str("region"),
+		From:                f.time("from"),
+		Thru:                f.time("thru"),
+		SignatureInfoIDs:    f.int64s("signatureInfoIds"),
+		HealthAuthorityID:   f.str("healthAuthorityId"),
+		IncludeTravelers:    f.bool("includeTravelers"),
+		IncludeReportTypes:  f.list("includeReportTypes"),
+		MinTransmissionRisk: f.int("minTransmissionRisk"),
+		DeltaOfConfigID:     f.int64("deltaOfConfigId"),
+	}
+	return ec, f.err
+}
+
+func (ec *ExportConfig) auditID() string {
+	return strconv.FormatInt(ec.ConfigID, 10)
+}
+
+func (ec *ExportConfig) save(ctx context.Context, h *handler) error {
+	if ec.ConfigID != 0 {
+		update := &database.ExportConfig{ConfigID: ec.ConfigID, SignatureInfoIDs: ec.SignatureInfoIDs}
+		if ec.Thru != nil {
+			update.Thru = ec.Thru.UTC()
+		}
+		if err := h.db.UpdateExportConfig(ctx, update); err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return &handlers.Error{Status: http.StatusNotFound, Message: "unknown configId"}
+			}
+			return err
+		}
+		return nil
+	}
+
+	if ec.BucketName == "" || ec.FilenameRoot == "" || ec.Region == "" {
+		return handlers.BadRequest("bucketName, filenameRoot and region are required")
+	}
+	period, err := time.ParseDuration(ec.Period)
+	if err != nil {
+		return handlers.BadRequest("period %q must be a duration like 4h", ec.Period)
+	}
+	if period <= 0 || period > oneDay || oneDay%period != 0 {
+		return handlers.BadRequest("period must divide equally into 24 hours (e.g., 2h, 4h, 12h, 15m, 30m)")
+	}
+	for _, rt := range ec.IncludeReportTypes {
+		if !database.ValidReportType(rt) {
+			return handlers.BadRequest("invalid report type %q", rt)
+		}
+	}
+	if ec.MinTransmissionRisk < database.MinTransmissionRisk || ec.MinTransmissionRisk > database.MaxTransmissionRisk {
+		return handlers.BadRequest("minTransmissionRisk must be between %d and %d", database.MinTransmissionRisk, database.MaxTransmissionRisk)
+	}
+
+	add := &database.ExportConfig{
+		BucketName:          ec.BucketName,
+		FilenameRoot:        ec.FilenameRoot,
+		Period:              period,
+		Region:              strings.ToUpper(ec.Region),
+		From:                time.Now().UTC(),
+		SignatureInfoIDs:    ec.SignatureInfoIDs,
+		HealthAuthorityID:   ec.HealthAuthorityID,
+		IncludeTravelers:    ec.IncludeTravelers,
+		IncludeReportTypes:  ec.IncludeReportTypes,
+		MinTransmissionRisk: ec.MinTransmissionRisk,
+		DeltaOfConfigID:     ec.DeltaOfConfigID,
+	}
+	if ec.From != nil {
+		add.From = ec.From.UTC()
+	}
+	if ec.Thru != nil {
+		if !ec.Thru.After(add.From) {
+			return handlers.BadRequest("thru must be after from")
+		}
+		add.Thru = ec.Thru.UTC()
+	}
+	if err := h.db.AddExportConfig(ctx, add); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return handlers.BadRequest("unknown deltaOfConfigId %d", ec.DeltaOfConfigID)
+		}
+		return err
+	}
+	ec.ConfigID = add.ConfigID
+	ec.Period = period.String()
+	ec.Region = add.Region
+	ec.From = timePtr(add.From)
+	return nil
+}
+
+// SignatureInfo is a signature info. Only Thru of an existing signature info
+// can be updated.
+type SignatureInfo struct {
+	ID                int64      `json:"id,omitempty"`
+	SigningKey        string     `json:"signingKey"`
+	AppPackageName    string     `json:"appPackageName,omitempty"`
+	BundleID          string     `json:"bundleId,omitempty"`
+	SigningKeyVersion string     `json:"signingKeyVersion,omitempty"`
+	SigningKeyID      string     `json:"signingKeyId,omitempty"`
+	From              *time.Time `json:"from,omitempty"`
+	Thru              *time.Time `json:"thru,omitempty"`
+
+	// AutoRotate and RotatedToID are set by key rotation, and are ignored
+	// when saving.
+	AutoRotate  bool  `json:"autoRotate,omitempty"`
+	RotatedToID int64 `json:"rotatedToId,omitempty"`
+}
+
+func listSignatureInfos(ctx context.Context, h *handler) (interface{}, error) {
+	infos, err := h.db.ListSignatureInfos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]*SignatureInfo, 0, len(infos))
+	for _, si := range infos {
+		resp = append(resp, &SignatureInfo{
+			ID:                si.ID,
+			SigningKey:        si.SigningKey,
+			AppPackageName:    si.AppPackageName,
+			BundleID:          si.BundleID,
+			SigningKeyVersion: si.SigningKeyVersion,
+			SigningKeyID:      si.SigningKeyID,
+			From:              timePtr(si.StartTimestamp),
+			Thru:              timePtr(si.EndTimestamp),
+			AutoRotate:        si.AutoRotate,
+			RotatedToID:       si.RotatedToID,
+		})
+	}
+	return resp, nil
+}
+
+func signatureInfoFromForm(f *form) (item, error) {
+	si := &SignatureInfo{
+		ID:                f.int64("id"),
+		SigningKey:        f.str("signingKey"),
+		AppPackageName:    f.str("appPackageName"),
+		BundleID:          f.str("bundleId"),
+		SigningKeyVersion: f.str("signingKeyVersion"),
+		SigningKeyID:      f.str("signingKeyId"),
+		From:              f.time("from"),
+		Thru:              f.time("thru"),
+	}
+	return si, f.err
+}
+
+func (si *SignatureInfo) auditID() string {
+	return strconv.FormatInt(si.ID, 10)
+}
+
+func (si *SignatureInfo) save(ctx context.Context, h *handler) error {
+	var thru time.Time
+	if si.Thru != nil {
+		thru = si.Thru.UTC()
+	}
+	if si.ID != 0 {
+		if err := h.db.UpdateSignatureInfoEnd(ctx, si.ID, thru); err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return &handlers.Error{Status: http.StatusNotFound, Message: "unknown id"}
+			}
+			return err
+		}
+		return nil
+	}
+
+	if si.SigningKey == "" {
+		return handlers.BadRequest("signingKey is required")
+	}
+	add := &database.SignatureInfo{
+		SigningKey:        si.SigningKey,
+		AppPackageName:    si.AppPackageName,
+		BundleID:          si.BundleID,
+		SigningKeyVersion: si.SigningKeyVersion,
+		SigningKeyID:      si.SigningKeyID,
+		EndTimestamp:      thru,
+	}
+	if si.From != nil {
+		add.StartTimestamp = si.From.UTC()
+	}
+	if err := h.db.AddSignatureInfo(ctx, add); err != nil {
+		return err
+	}
+	si.ID = add.ID
+	si.AutoRotate, si.RotatedToID = false, 0
+	return nil
+}
+
+// App is an authorized app, as managed by the app admin API. Its ID is its
+// package name.
+type App struct {
+	appadmin.App
+}
+
+func listApps(ctx context.Context, h *handler) (interface{}, error) {
+	apps, err := h.apps.ListAuthorizedApps(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]*App, 0, len(apps))
+	for _, a := range apps {
+		resp = append(resp, &App{*appadmin.AppFromModel(a)})
+	}
+	return resp, nil
+}
+
+func appFromForm(f *form) (item, error) {
+	basicIntegrity, ctsProfileMatch := f.bool("safetyNetBasicIntegrity"), f.bool("safetyNetCtsProfileMatch")
+	a := &App{appadmin.App{
+		AppPackageName:              f.str("appPackageName"),
+		Platform:                    f.str("platform"),
+		AllowedRegions:              f.list("allowedRegions"),
+		HealthAuthorityID:           f.str("healthAuthorityId"),
+		SafetyNetDisabled:           f.bool("safetyNetDisabled"),
+		SafetyNetApkDigestSHA256:    f.list("safetyNetApkDigestSha256"),
+		SafetyNetBasicIntegrity:     &basicIntegrity,
+		SafetyNetCTSProfileMatch:    &ctsProfileMatch,
+		SafetyNetPastSeconds:        f.int("safetyNetPastSeconds"),
+		SafetyNetFutureSeconds:      f.int("safetyNetFutureSeconds"),
+		DeviceCheckDisabled:         f.bool("deviceCheckDisabled"),
+		DeviceCheckTeamID:           f.str("deviceCheckTeamId"),
+		DeviceCheckKeyID:            f.str("deviceCheckKeyId"),
+		DeviceCheckPrivateKeySecret: f.str("deviceCheckPrivateKeySecret"),
+		CertificateIssuer:           f.str("certificateIssuer"),
+		CertificateAudience:         f.str("certificateAudience"),
+		CertificateJWKSURI:          f.str("certificateJwksUri"),
+		RateLimitTokens:             f.int("rateLimitTokens"),
+		RateLimitIntervalSeconds:    f.int("rateLimitIntervalSeconds"),
+		MaxKeysPerDay:               f.int("maxKeysPerDay"),
+		GRPCAPIKeySHA256:            f.str("grpcApiKeySha256"),
+	}}
+	return a, f.err
+}
+
+func (a *App) auditID() string {
+	return a.AppPackageName
+}
+
+func (a *App) save(ctx context.Context, h *handler) error {
+	app, err := a.ToModel()
+	if err != nil {
+		return handlers.BadRequest("%v", err)
+	}
+	if err := h.apps.AddAuthorizedApp(ctx, app); err != nil {
+		return err
+	}
+	h.invalidate(ctx, app.AppPackageName)
+	a.App = *appadmin.AppFromModel(app)
+	return nil
+}
+
+func removeApp(ctx context.Context, h *handler, name string) error {
+	if err := h.apps.DeleteAuthorizedApp(ctx, name); err != nil {
+		return err
+	}
+	h.invalidate(ctx, name)
+	return nil
+}
+
+// HealthAuthority holds the settings shared by the apps of a health
+// authority.
+type HealthAuthority struct {
+	ID                    string        `json:"healthAuthorityId"`
+	EmbargoSameDayKeys    bool          `json:"embargoSameDayKeys,omitempty"`
+	AllowTravelers        bool          `json:"allowTravelers,omitempty"`
+	OnsetTransmissionRisk map[int32]int `json:"onsetTransmissionRisk,omitempty"`
+	JWKSURI               string        `json:"jwksUri,omitempty"`
+
+	// JWKSRefreshedAt is set by the key refresh, and is ignored when saving.
+	JWKSRefreshedAt *time.Time `json:"jwksRefreshedAt,omitempty"`
+}
+
+func listHealthAuthorities(ctx context.Context, h *handler) (interface{}, error) {
+	has, err := h.db.ListHealthAuthorities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]*HealthAuthority, 0, len(has))
+	for _, ha := range has {
+		resp = append(resp, &HealthAuthority{
+			ID:                    ha.ID,
+			EmbargoSameDayKeys:    ha.EmbargoSameDayKeys,
+			AllowTravelers:        ha.AllowTravelers,
+			OnsetTransmissionRisk: ha.OnsetTransmissionRisk,
+			JWKSURI:               ha.JWKSURI,
+			JWKSRefreshedAt:       ha.JWKSRefreshedAt,
+		})
+	}
+	return resp, nil
+}
+
+func healthAuthorityFromForm(f *form) (item, error) {
+	ha := &HealthAuthority{
+		ID:                    f.str("healthAuthorityId"),
+		EmbargoSameDayKeys:    f.bool("embargoSameDayKeys"),
+		AllowTravelers:        f.bool("allowTravelers"),
+		OnsetTransmissionRisk: f.riskMap("onsetTransmissionRisk"),
+		JWKSURI:               f.str("jwksUri"),
+	}
+	return ha, f.err
+}
+
+func (ha *HealthAuthority) auditID() string {
+	return ha.ID
+}
+
+func (ha *HealthAuthority) save(ctx context.Context, h *handler) error {
+	if ha.ID == "" || len(ha.ID) > 100 {
+		return handlers.BadRequest("healthAuthorityId is required and must be at most 100 characters")
+	}
+	for days, risk := range ha.OnsetTransmissionRisk {
+		if risk < database.MinTransmissionRisk || risk > database.MaxTransmissionRisk {
+			return handlers.BadRequest("invalid transmission risk %d for %d days, must be between %d and %d",
+				risk, days, database.MinTransmissionRisk, database.MaxTransmissionRisk)
+		}
+	}
+	if ha.JWKSURI != "" {
+		if u, err := url.Parse(ha.JWKSURI); err != nil || u.Scheme != "https" {
+			return handlers.BadRequest("jwksUri must be an https URL")
+		}
+	}
+	ha.JWKSRefreshedAt = nil
+	return h.db.AddHealthAuthority(ctx, &database.HealthAuthority{
+		ID:                    ha.ID,
+		EmbargoSameDayKeys:    ha.EmbargoSameDayKeys,
+		AllowTravelers:        ha.AllowTravelers,
+		OnsetTransmissionRisk: ha.OnsetTransmissionRisk,
+		JWKSURI:               ha.JWKSURI,
+	})
+}
+
+func removeHealthAuthority(ctx context.Context, h *handler, id string) error {
+	return h.db.DeleteHealthAuthority(ctx, id)
+}
+
+// HealthAuthorityKey is a key that verification certificates of a health
+// authority are signed with. Keys can only be added to health authorities
+// without a JWKS URI; the others are refreshed from their key set. Saving a
+// key without a public key only updates the thru time of an existing one.
+type HealthAuthorityKey struct {
+	HealthAuthorityID string     `json:"healthAuthorityId"`
+	Version           string     `json:"version"`
+	From              *time.Time `json:"from,omitempty"`
+	Thru              *time.Time `json:"thru,omitempty"`
+	PublicKey         string     `json:"publicKey,omitempty"`
+}
+
+func listHealthAuthorityKeys(ctx context.Context, h *handler) (interface{}, error) {
+	has, err := h.db.ListHealthAuthorities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]*HealthAuthorityKey, 0, len(has))
+	for _, ha := range has {
+		keys, err := h.db.ListHealthAuthorityKeys(ctx, ha.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			resp = append(resp, &HealthAuthorityKey{
+				HealthAuthorityID: ha.ID,
+				Version:           k.Version,
+				From:              timePtr(k.From),
+				Thru:              k.Thru,
+				PublicKey:         k.PublicKeyPEM,
+			})
+		}
+	}
+	return resp, nil
+}
+
+func healthAuthorityKeyFromForm(f *form) (item, error) {
+	k := &HealthAuthorityKey{
+		HealthAuthorityID: f.str("healthAuthorityId"),
+		Version:           f.str("version"),
+		From:              f.time("from"),
+		Thru:              f.time("thru"),
+		PublicKey:         f.str("publicKey"),
+	}
+	return k, f.err
+}
+
+func (k *HealthAuthorityKey) auditID() string {
+	return k.HealthAuthorityID + "/" + k.Version
+}
+
+func (k *HealthAuthorityKey) save(ctx context.Context, h *handler) error {
+	if k.HealthAuthorityID == "" || k.Version == "" || len(k.Version) > 100 {
+		return handlers.BadRequest("healthAuthorityId and version are required, version must be at most 100 characters")
+	}
+
+	if k.PublicKey == "" {
+		if k.Thru == nil {
+			return handlers.BadRequest("publicKey or thru is required")
+		}
+		if err := h.db.UpdateHealthAuthorityKeyEnd(ctx, k.HealthAuthorityID, k.Version, k.Thru.UTC()); err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return &handlers.Error{Status: http.StatusNotFound, Message: "unknown health authority key"}
+			}
+			return err
+		}
+		return nil
+	}
+
+	has, err := h.db.ListHealthAuthorities(ctx)
+	if err != nil {
+		return err
+	}
+	var ha *database.HealthAuthority
+	for _, a := range has {
+		if a.ID == k.HealthAuthorityID {
+			ha = a
+		}
+	}
+	if ha == nil {
+		return handlers.BadRequest("unknown health authority %q", k.HealthAuthorityID)
+	}
+	if ha.JWKSURI != "" {
+		return handlers.BadRequest("keys of health authority %q are refreshed from %s", ha.ID, ha.JWKSURI)
+	}
+	if _, err := verification.ParsePublicKeyPEM(k.PublicKey); err != nil {
+		return handlers.BadRequest("invalid publicKey: %v", err)
+	}
+
+	add := &database.HealthAuthorityKey{
+		Version:      k.Version,
+		From:         time.Now().UTC(),
+		PublicKeyPEM: k.PublicKey,
+	}
+	if k.From != nil {
+		add.From = k.From.UTC()
+	}
+	if k.Thru != nil {
+		thru := k.Thru.UTC()
+		add.Thru = &thru
+	}
+	if err := h.db.AddHealthAuthorityKey(ctx, k.HealthAuthorityID, add); err != nil {
+		return err
+	}
+	k.From, k.Thru = timePtr(add.From), add.Thru
+	return nil
+}
+
+// Setting overrides a setting of the servers while they run, see package
+// runtimeconfig. Its ID is its name.
+type Setting struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+
+	// UpdatedAt is ignored when saving.
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+func listSettings(ctx context.Context, h *handler) (interface{}, error) {
+	settings, err := h.db.ListConfigSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]*Setting, 0, len(settings))
+	for _, s := range settings {
+		resp = append(resp, &Setting{Name: s.Name, Value: s.Value, UpdatedAt: timePtr(s.UpdatedAt)})
+	}
+	return resp, nil
+}
+
+func settingFromForm(f *form) (item, error) {
+	return &Setting{Name: f.str("name"), Value: f.str("value")}, f.err
+}
+
+func (s *Setting) auditID() string {
+	return s.Name
+}
+
+func (s *Setting) save(ctx context.Context, h *handler) error {
+	if err := runtimeconfig.Validate(s.Name, s.Value); err != nil {
+		return handlers.BadRequest("%v", err)
+	}
+	s.UpdatedAt = nil
+	return h.db.SetConfigSetting(ctx, s.Name, s.Value)
+}
+
+func removeSetting(ctx context.Context, h *handler, name string) error {
+	return h.db.DeleteConfigSetting(ctx, name)
+}
+
+// form parses the fields of a posted form. The first invalid field is kept in
+// err.
+type form struct {
+	values url.Values
+	err    error
+}
+
+func (f *form) str(name string) string {
+	return strings.TrimSpace(f.values.Get(name))
+}
+
+// list parses a comma-separated list.
+func (f *form) list(name string) []string {
+	var l []string
+	for _, v := range strings.Split(f.str(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+func (f *form) bool(name string) bool {
+	v := f.str(name)
+	return v == "on" || v == "true"
+}
+
+func (f *form) int(name string) int {
+	return int(f.int64(name))
+}
+
+func (f *form) int64(name string) int64 {
+	v := f.str(name)
+	if v == "" {
+		return 0
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil && f.err == nil {
+		f.err = handlers.BadRequest("%s must be a number, got %q", name, v)
+	}
+	return i
+}
+
+// int64s parses a comma-separated list of numbers.
+func (f *form) int64s(name string) []int64 {
+	var l []int64
+	for _, v := range f.list(name) {
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			if f.err == nil {
+				f.err = handlers.BadRequest("%s must be a list of numbers, got %q", name, v)
+			}
+			continue
+		}
+		l = append(l, i)
+	}
+	return l
+}
+
+// time parses an RFC 3339 time. It returns nil if the field is empty.
+func (f *form) time(name string) *time.Time {
+	v := f.str(name)
+	if v == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		if f.err == nil {
+			f.err = handlers.BadRequest("%s must be an RFC 3339 time like 2020-09-01T00:00:00Z, got %q", name, v)
+		}
+		return nil
+	}
+	return &t
+}
+
+// riskMap parses a comma-separated list of DAYS=RISK pairs.
+func (f *form) riskMap(name string) map[int32]int {
+	pairs := f.list(name)
+	if len(pairs) == 0 {
+		return nil
+	}
+	m := make(map[int32]int, len(pairs))
+	for _, p := range pairs {
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) == 2 {
+			days, derr := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 32)
+			risk, rerr := strconv.Atoi(strings.TrimSpace(parts[1]))
+			if derr == nil && rerr == nil {
+				m[int32(days)] = risk
+				continue
+			}
+		}
+		if f.err == nil {
+			f.err = handlers.BadRequest("%s must be a list of DAYS=RISK pairs, got %q", name, p)
+		}
+	}
+	return m
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
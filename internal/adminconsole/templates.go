@@ -0,0 +1,278 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adminconsole
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/handlers"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/runtimeconfig"
+)
+
+// page is the data the HTML templates render.
+type page struct {
+	Title     string
+	User      string
+	Message   string
+	Error     string
+	Resources []*resource
+	Items     interface{}
+}
+
+var templateFuncs = template.FuncMap{
+	// Links are relative so the console can be served under a path prefix.
+	"path":  func(r *resource) string { return "." + r.path },
+	"title": func(r *resource) string { return r.title },
+	"time": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	},
+	"join": func(l []string) string { return strings.Join(l, ", ") },
+	"ids": func(l []int64) string {
+		s := make([]string, 0, len(l))
+		for _, id := range l {
+			s = append(s, strconv.FormatInt(id, 10))
+		}
+		return strings.Join(s, ", ")
+	},
+	"risks": func(m map[int32]int) string {
+		days := make([]int, 0, len(m))
+		for d := range m {
+			days = append(days, int(d))
+		}
+		sort.Ints(days)
+		s := make([]string, 0, len(days))
+		for _, d := range days {
+			s = append(s, strconv.Itoa(d)+"="+strconv.Itoa(m[int32(d)]))
+		}
+		return strings.Join(s, ", ")
+	},
+	"deref": func(b *bool) bool { return b != nil && *b },
+	"settings": func() []string {
+		names := make([]string, 0, len(runtimeconfig.Reloadable))
+		for name := range runtimeconfig.Reloadable {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	},
+}
+
+const layoutTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} - Exposure Notifications admin</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+nav a { margin-right: 1em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+label { display: block; margin: 0.4em 0; }
+.error { color: #b00; }
+.message { color: #070; }
+.user { float: right; color: #666; }
+</style>
+</head>
+<body>
+<span class="user">{{.User}}</span>
+<nav><a href="./">Home</a>{{range .Resources}}<a href="{{path .}}">{{title .}}</a>{{end}}</nav>
+<h1>{{.Title}}</h1>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Message}}<p class="message">{{.Message}}</p>{{end}}
+{{template "content" .}}
+</body>
+</html>
+`
+
+var (
+	indexPage = mustPage(`{{define "content"}}
+<p>Manage the server's configuration. Each page has a JSON API at the same path under /api.</p>
+<ul>{{range .Resources}}<li><a href="{{path .}}">{{title .}}</a></li>{{end}}</ul>
+{{end}}`)
+
+	exportConfigsPage = mustPage(`{{define "content"}}
+<table>
+<tr><th>ID</th><th>Bucket</th><th>Filename root</th><th>Period</th><th>Region</th><th>From</th><th>Thru</th><th>Signature infos</th><th>Health authority</th><th>Travelers</th><th>Report types</th><th>Min risk</th><th>Delta of</th></tr>
+{{range .Items}}<tr><td>{{.ConfigID}}</td><td>{{.BucketName}}</td><td>{{.FilenameRoot}}</td><td>{{.Period}}</td><td>{{.Region}}</td><td>{{time .From}}</td><td>{{time .Thru}}</td><td>{{ids .SignatureInfoIDs}}</td><td>{{.HealthAuthorityID}}</td><td>{{.IncludeTravelers}}</td><td>{{join .IncludeReportTypes}}</td><td>{{.MinTransmissionRisk}}</td><td>{{if .DeltaOfConfigID}}{{.DeltaOfConfigID}}{{end}}</td></tr>
+{{end}}
+</table>
+<h2>Create or update</h2>
+<p>Leave the ID empty to create a config. Only the thru time and signature infos of an existing config can be updated. Times are RFC 3339, like 2020-09-01T00:00:00Z.</p>
+<form method="post">
+<input type="hidden" name="action" value="save">
+<label>ID <input name="configId"></label>
+<label>Bucket <input name="bucketName"></label>
+<label>Filename root <input name="filenameRoot"></label>
+<label>Period <input name="period" placeholder="4h"></label>
+<label>Region <input name="region"></label>
+<label>From <input name="from" placeholder="now"></label>
+<label>Thru <input name="thru"></label>
+<label>Signature info IDs <input name="signatureInfoIds" placeholder="1, 2"></label>
+<label>Health authority <input name="healthAuthorityId"></label>
+<label><input type="checkbox" name="includeTravelers"> Include travelers</label>
+<label>Report types <input name="includeReportTypes" placeholder="confirmed, likely"></label>
+<label>Minimum transmission risk <input name="minTransmissionRisk"></label>
+<label>Delta of config ID <input name="deltaOfConfigId"></label>
+<button>Save</button>
+</form>
+{{end}}`)
+
+	signatureInfosPage = mustPage(`{{define "content"}}
+<table>
+<tr><th>ID</th><th>Signing key</th><th>Key version</th><th>Key ID</th><th>App package</th><th>Bundle ID</th><th>From</th><th>Thru</th><th>Auto rotate</th><th>Rotated to</th></tr>
+{{range .Items}}<tr><td>{{.ID}}</td><td>{{.SigningKey}}</td><td>{{.SigningKeyVersion}}</td><td>{{.SigningKeyID}}</td><td>{{.AppPackageName}}</td><td>{{.BundleID}}</td><td>{{time .From}}</td><td>{{time .Thru}}</td><td>{{.AutoRotate}}</td><td>{{if .RotatedToID}}{{.RotatedToID}}{{end}}</td></tr>
+{{end}}
+</table>
+<h2>Create or update</h2>
+<p>Leave the ID empty to create a signature info. Only the thru time of an existing signature info can be updated, to retire its key. Times are RFC 3339, like 2020-09-01T00:00:00Z.</p>
+<form method="post">
+<input type="hidden" name="action" value="save">
+<label>ID <input name="id"></label>
+<label>Signing key <input name="signingKey" size="80"></label>
+<label>Key version <input name="signingKeyVersion"></label>
+<label>Key ID <input name="signingKeyId"></label>
+<label>App package <input name="appPackageName"></label>
+<label>Bundle ID <input name="bundleId"></label>
+<label>From <input name="from"></label>
+<label>Thru <input name="thru"></label>
+<button>Save</button>
+</form>
+{{end}}`)
+
+	appsPage = mustPage(`{{define "content"}}
+<table>
+<tr><th>Package</th><th>Platform</th><th>Regions</th><th>Health authority</th><th>SafetyNet</th><th>DeviceCheck</th><th>Certificate issuer</th><th>Rate limit</th><th>Max keys per day</th><th></th></tr>
+{{range .Items}}<tr><td>{{.AppPackageName}}</td><td>{{.Platform}}</td><td>{{join .AllowedRegions}}</td><td>{{.HealthAuthorityID}}</td>
+<td>{{if .SafetyNetDisabled}}disabled{{else}}basic integrity: {{deref .SafetyNetBasicIntegrity}}, CTS: {{deref .SafetyNetCTSProfileMatch}}{{end}}</td>
+<td>{{if .DeviceCheckDisabled}}disabled{{else}}{{.DeviceCheckTeamID}} {{.DeviceCheckKeyID}} {{.DeviceCheckPrivateKeySecret}}{{end}}</td>
+<td>{{.CertificateIssuer}}</td><td>{{if .RateLimitTokens}}{{.RateLimitTokens}} per {{.RateLimitIntervalSeconds}}s{{end}}</td><td>{{if .MaxKeysPerDay}}{{.MaxKeysPerDay}}{{end}}</td>
+<td><form method="post"><input type="hidden" name="action" value="delete"><input type="hidden" name="id" value="{{.AppPackageName}}"><button>Delete</button></form></td></tr>
+{{end}}
+</table>
+<h2>Create or update</h2>
+<p>Saving an app with an existing package name replaces its settings. Servers apply changes after their AUTHORIZED_APP_CACHE_DURATION.</p>
+<form method="post">
+<input type="hidden" name="action" value="save">
+<label>Package <input name="appPackageName"></label>
+<label>Platform <select name="platform"><option>android</option><option>ios</option><option>both</option></select></label>
+<label>Allowed regions <input name="allowedRegions" placeholder="all"></label>
+<label>Health authority <input name="healthAuthorityId"></label>
+<label><input type="checkbox" name="safetyNetDisabled"> Disable SafetyNet</label>
+<label>APK digests <input name="safetyNetApkDigestSha256"></label>
+<label><input type="checkbox" name="safetyNetBasicIntegrity" checked> Require basic integrity</label>
+<label><input type="checkbox" name="safetyNetCtsProfileMatch" checked> Require CTS profile match</label>
+<label>SafetyNet past seconds <input name="safetyNetPastSeconds"></label>
+<label>SafetyNet future seconds <input name="safetyNetFutureSeconds"></label>
+<label><input type="checkbox" name="deviceCheckDisabled"> Disable DeviceCheck</label>
+<label>DeviceCheck team ID <input name="deviceCheckTeamId"></label>
+<label>DeviceCheck key ID <input name="deviceCheckKeyId"></label>
+<label>DeviceCheck private key secret <input name="deviceCheckPrivateKeySecret"></label>
+<label>Certificate issuer <input name="certificateIssuer"></label>
+<label>Certificate audience <input name="certificateAudience"></label>
+<label>Certificate JWKS URI <input name="certificateJwksUri" size="60"></label>
+<label>Rate limit tokens <input name="rateLimitTokens"></label>
+<label>Rate limit interval seconds <input name="rateLimitIntervalSeconds"></label>
+<label>Max keys per day <input name="maxKeysPerDay"></label>
+<label>gRPC API key SHA-256 <input name="grpcApiKeySha256" size="70"></label>
+<button>Save</button>
+</form>
+{{end}}`)
+
+	healthAuthoritiesPage = mustPage(`{{define "content"}}
+<table>
+<tr><th>ID</th><th>Embargo same day keys</th><th>Allow travelers</th><th>Onset transmission risk</th><th>JWKS URI</th><th>Keys refreshed</th><th></th></tr>
+{{range .Items}}<tr><td>{{.ID}}</td><td>{{.EmbargoSameDayKeys}}</td><td>{{.AllowTravelers}}</td><td>{{risks .OnsetTransmissionRisk}}</td><td>{{.JWKSURI}}</td><td>{{time .JWKSRefreshedAt}}</td>
+<td><form method="post"><input type="hidden" name="action" value="delete"><input type="hidden" name="id" value="{{.ID}}"><button>Delete</button></form></td></tr>
+{{end}}
+</table>
+<h2>Create or update</h2>
+<p>Apps of a health authority without a row use the defaults.</p>
+<form method="post">
+<input type="hidden" name="action" value="save">
+<label>ID <input name="healthAuthorityId"></label>
+<label><input type="checkbox" name="embargoSameDayKeys"> Embargo same day keys</label>
+<label><input type="checkbox" name="allowTravelers"> Allow travelers</label>
+<label>Onset transmission risk <input name="onsetTransmissionRisk" placeholder="-2=1, 0=6"></label>
+<label>JWKS URI <input name="jwksUri" size="60" placeholder="keys are added by hand"></label>
+<button>Save</button>
+</form>
+{{end}}`)
+
+	healthAuthorityKeysPage = mustPage(`{{define "content"}}
+<table>
+<tr><th>Health authority</th><th>Version</th><th>From</th><th>Thru</th><th>Public key</th></tr>
+{{range .Items}}<tr><td>{{.HealthAuthorityID}}</td><td>{{.Version}}</td><td>{{time .From}}</td><td>{{time .Thru}}</td><td><pre>{{.PublicKey}}</pre></td></tr>
+{{end}}
+</table>
+<h2>Add or end a key</h2>
+<p>Keys can only be added to health authorities without a JWKS URI. Leave the public key empty to only set the thru time of an existing key. Times are RFC 3339, like 2020-09-01T00:00:00Z.</p>
+<form method="post">
+<input type="hidden" name="action" value="save">
+<label>Health authority <input name="healthAuthorityId"></label>
+<label>Version <input name="version"></label>
+<label>From <input name="from" placeholder="now"></label>
+<label>Thru <input name="thru"></label>
+<label>Public key <textarea name="publicKey" rows="5" cols="70" placeholder="-----BEGIN PUBLIC KEY-----"></textarea></label>
+<button>Save</button>
+</form>
+{{end}}`)
+
+	settingsPage = mustPage(`{{define "content"}}
+<table>
+<tr><th>Name</th><th>Value</th><th>Updated</th><th></th></tr>
+{{range .Items}}<tr><td>{{.Name}}</td><td>{{.Value}}</td><td>{{time .UpdatedAt}}</td>
+<td><form method="post"><input type="hidden" name="action" value="delete"><input type="hidden" name="id" value="{{.Name}}"><button>Delete</button></form></td></tr>
+{{end}}
+</table>
+<h2>Set</h2>
+<p>Settings override the environment variable of the same name while servers run, and apply within DB_CONFIG_POLL_INTERVAL. Deleting one goes back to the environment.</p>
+<form method="post">
+<input type="hidden" name="action" value="save">
+<label>Name <select name="name">{{range settings}}<option>{{.}}</option>{{end}}</select></label>
+<label>Value <input name="value"></label>
+<button>Save</button>
+</form>
+{{end}}`)
+)
+
+// mustPage parses a page's content into the layout.
+func mustPage(content string) *template.Template {
+	t := template.Must(template.New("layout").Funcs(templateFuncs).Parse(layoutTemplate))
+	return template.Must(t.Parse(content))
+}
+
+func (h *handler) renderPage(ctx context.Context, w http.ResponseWriter, status int, t *template.Template, p *page) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, p); err != nil {
+		handlers.WriteError(ctx, w, "Admin console request", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := b.WriteTo(w); err != nil {
+		logging.FromContext(ctx).Errorf("Failed writing response: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/observability"
+)
+
+const (
+	// GoogleRootsURL serves the root certificates of Google Trust Services,
+	// which issues the certificates that sign SafetyNet attestations.
+	GoogleRootsURL = "https://pki.goog/roots.pem"
+
+	// maxRootsSize bounds the size of a downloaded root certificate bundle.
+	maxRootsSize = 1 << 20
+
+	// rootsRetryInterval is how long to wait after a failed download before
+	// trying again.
+	rootsRetryInterval = time.Minute
+)
+
+// RootCache downloads and caches the root certificates that attestation
+// certificate chains must lead to. If a refresh fails, the previously
+// downloaded roots continue to be used.
+type RootCache struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	// now is replaced in tests.
+	now func() time.Time
+
+	mu          sync.Mutex
+	pool        *x509.CertPool
+	expires     time.Time
+	lastAttempt time.Time
+	lastErr     error
+}
+
+// NewRootCache creates a RootCache that downloads PEM encoded root
+// certificates from url and refreshes them after ttl.
+func NewRootCache(url string, ttl time.Duration) *RootCache {
+	return &RootCache{
+		url:    url,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 10 * time.Second, Transport: observability.HTTPTransport(nil)},
+		now:    time.Now,
+	}
+}
+
+// Pool returns the cached root certificates, downloading them if they have
+// expired.
+func (c *RootCache) Pool(ctx context.Context) (*x509.CertPool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if c.pool != nil && now.Before(c.expires) {
+		return c.pool, nil
+	}
+	if c.lastErr != nil && now.Sub(c.lastAttempt) < rootsRetryInterval {
+		if c.pool != nil {
+			return c.pool, nil
+		}
+		return nil, c.lastErr
+	}
+
+	c.lastAttempt = now
+	pool, err := c.fetch(ctx)
+	if err != nil {
+		c.lastErr = fmt.Errorf("fetching root certificates: %w", err)
+		if c.pool != nil {
+			logging.FromContext(ctx).Warnf("using expired root certificates: %v", c.lastErr)
+			return c.pool, nil
+		}
+		return nil, c.lastErr
+	}
+	c.pool = pool
+	c.expires = now.Add(c.ttl)
+	c.lastErr = nil
+	return c.pool, nil
+}
+
+func (c *RootCache) fetch(ctx context.Context) (*x509.CertPool, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxRootsSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return parseRoots(data)
+}
+
+// parseRoots parses a bundle of PEM encoded certificates.
+func parseRoots(data []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	count := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		pool.AddCert(cert)
+		count++
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return pool, nil
+}
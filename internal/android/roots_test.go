@@ -0,0 +1,128 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testRootPEM(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestRootCache(t *testing.T) {
+	ctx := context.Background()
+	roots := testRootPEM(t)
+
+	requests := 0
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(roots)
+	}))
+	defer srv.Close()
+
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewRootCache(srv.URL, time.Hour)
+	cache.now = func() time.Time { return now }
+
+	pool, err := cache.Pool(ctx)
+	if err != nil {
+		t.Fatalf("Pool: %v", err)
+	}
+	if len(pool.Subjects()) != 1 {
+		t.Errorf("got %d roots, want 1", len(pool.Subjects()))
+	}
+
+	// Cached until the TTL passes.
+	now = now.Add(59 * time.Minute)
+	if _, err := cache.Pool(ctx); err != nil {
+		t.Fatalf("Pool: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1", requests)
+	}
+
+	// A failed refresh keeps using the old roots.
+	now = now.Add(2 * time.Minute)
+	fail = true
+	if got, err := cache.Pool(ctx); err != nil || got != pool {
+		t.Errorf("Pool after failed refresh = %v, %v; want cached roots", got, err)
+	}
+	if requests != 2 {
+		t.Errorf("got %d requests, want 2", requests)
+	}
+
+	// Failed refreshes aren't retried immediately.
+	if _, err := cache.Pool(ctx); err != nil {
+		t.Fatalf("Pool: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("got %d requests, want 2", requests)
+	}
+
+	fail = false
+	now = now.Add(rootsRetryInterval)
+	if _, err := cache.Pool(ctx); err != nil {
+		t.Fatalf("Pool: %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("got %d requests, want 3", requests)
+	}
+}
+
+func TestRootCacheUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not a certificate"))
+	}))
+	defer srv.Close()
+
+	cache := NewRootCache(srv.URL, time.Hour)
+	if _, err := cache.Pool(context.Background()); err == nil {
+		t.Errorf("expected error when no roots can be loaded")
+	}
+}
@@ -40,6 +40,10 @@ type VerifyOpts struct {
 	BasicIntegrity  bool
 	MinValidTime    time.Time
 	MaxValidTime    time.Time
+
+	// Roots are the certificates the attestation's certificate chain must lead
+	// to. If nil, the system roots are used.
+	Roots *x509.CertPool
 }
 
 // ValidateAttestation validates the the SafetyNet Attestation from this device
@@ -50,7 +54,7 @@ func ValidateAttestation(ctx context.Context, attestation string, opts *VerifyOp
 	defer trace.StartRegion(ctx, "ValidateAttestation").End()
 	logger := logging.FromContext(ctx)
 
-	claims, err := verifyAttestation(ctx, attestation)
+	claims, err := verifyAttestation(ctx, attestation, opts.Roots)
 	if err != nil {
 		return fmt.Errorf("verifyAttestation: %w", err)
 	}
@@ -92,17 +96,17 @@ func ValidateAttestation(ctx context.Context, attestation string, opts *VerifyOp
 
 	// The apkCertificateDigestSha256 is an array with a single entry.
 	// https://developer.android.com/training/safetynet/attestation#use-response-server
-	digestArr := claims["apkCertificateDigestSha256"].([]interface{})
+	digestArr, _ := claims["apkCertificateDigestSha256"].([]interface{})
 	claimApkDigest := ""
 	if len(digestArr) >= 1 {
-		claimApkDigest = digestArr[0].(string)
+		claimApkDigest, _ = digestArr[0].(string)
 	} else {
 		logger.Warnf("attestation didn't contain apkCertificateDigestSha256")
 	}
 
 	match := false
 	for _, digest := range opts.APKDigest {
-		if digest != claimApkDigest {
+		if digest == claimApkDigest {
 			match = true
 			break
 		}
@@ -165,7 +169,7 @@ func VerifyOptsFor(c *model.AuthorizedApp, from time.Time, nonce string) *Verify
 
 // The keyFunc is based on the Android sample code
 // https://github.com/googlesamples/android-play-safetynet/blob/d7513a54e2f28c0dcd7f8d8d0fa03adb5d87b91a/server/java/src/main/java/OfflineVerify.java
-func keyFunc(ctx context.Context, tok *jwt.Token) (interface{}, error) {
+func keyFunc(ctx context.Context, tok *jwt.Token, roots *x509.CertPool) (interface{}, error) {
 	x5c, ok := tok.Header["x5c"].([]interface{})
 	if !ok || len(x5c) == 0 {
 		return nil, fmt.Errorf("attestation is missing certificate")
@@ -174,10 +178,11 @@ func keyFunc(ctx context.Context, tok *jwt.Token) (interface{}, error) {
 	// Verify the singature of the JWS and retrieve the signature and certificates.
 	x509certs := make([]*x509.Certificate, len(x5c))
 	for i, certStr := range x5c {
-		if certStr == "" {
+		s, ok := certStr.(string)
+		if !ok || s == "" {
 			return nil, fmt.Errorf("certificate is empty")
 		}
-		certData, err := base64util.DecodeString(certStr.(string))
+		certData, err := base64util.DecodeString(s)
 		if err != nil {
 			return nil, fmt.Errorf("invalid certificate encoding: %w", err)
 		}
@@ -194,6 +199,7 @@ func keyFunc(ctx context.Context, tok *jwt.Token) (interface{}, error) {
 	opts := x509.VerifyOptions{
 		DNSName:       "attest.android.com", // required hostname for valid attestation.
 		Intermediates: pool,
+		Roots:         roots,
 	}
 
 	// Verify the first certificate, with all added as allowed intermediates.
@@ -211,13 +217,13 @@ func keyFunc(ctx context.Context, tok *jwt.Token) (interface{}, error) {
 
 // verifyAttestation extracts and verifies the signature and claims on the
 // attestation. It does NOT validate the attestation, only the signature.
-func verifyAttestation(ctx context.Context, signedAttestation string) (jwt.MapClaims, error) {
+func verifyAttestation(ctx context.Context, signedAttestation string, roots *x509.CertPool) (jwt.MapClaims, error) {
 	defer trace.StartRegion(ctx, "verifyAttestation").End()
 	// jwt.Parse also validates the signature after extracting
 	// the key via the keyFunc, which validates the certificate chain.
 	token, err := jwt.Parse(signedAttestation,
 		func(tok *jwt.Token) (interface{}, error) {
-			return keyFunc(ctx, tok)
+			return keyFunc(ctx, tok, roots)
 		})
 
 	if err != nil {
@@ -52,7 +52,7 @@ var (
 
 func TestVerifyAttestation(t *testing.T) {
 	ctx := context.Background()
-	claims, err := verifyAttestation(ctx, payload)
+	claims, err := verifyAttestation(ctx, payload, nil)
 	if err != nil {
 		t.Fatalf("error verifying attestation %v", err)
 	}
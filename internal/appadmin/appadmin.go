@@ -0,0 +1,203 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package appadmin is an authenticated API for managing the apps authorized
+// to publish keys, in place of editing the AuthorizedApp table by hand.
+package appadmin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/exposure-notifications-server/internal/audit"
+	authorizedappdb "github.com/google/exposure-notifications-server/internal/authorizedapp/database"
+	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/handlers"
+	"github.com/google/exposure-notifications-server/internal/jsonutil"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+)
+
+const appParam = "app"
+
+// appDB is the part of the database that stores authorized apps.
+type appDB interface {
+	ListAuthorizedApps(ctx context.Context) ([]*model.AuthorizedApp, error)
+	AddAuthorizedApp(ctx context.Context, app *model.AuthorizedApp) error
+	DeleteAuthorizedApp(ctx context.Context, name string) error
+}
+
+// invalidator is implemented by AuthorizedApp providers that cache apps.
+type invalidator interface {
+	Invalidate(ctx context.Context, name string) error
+	InvalidateAll(ctx context.Context) error
+}
+
+// NewHandler returns the app admin API. Requests must carry an API key with
+// the app_admin permission as a bearer token. The API serves:
+//
+//	GET, PUT /apps        authorized apps
+//	DELETE /apps?app=
+//	DELETE /cache?app=    drops an app, or every app, from the caches
+//
+// Servers cache apps for up to AUTHORIZED_APP_CACHE_DURATION, so changes can
+// take that long to apply, unless they share a cache through
+// CACHE_REDIS_ADDRESS with this API, which drops changed apps from it.
+func NewHandler(env *serverenv.ServerEnv, config *Config) (http.Handler, error) {
+	if env.Database() == nil {
+		return nil, fmt.Errorf("missing database in server environment")
+	}
+	return &handler{
+		env:    env,
+		keys:   env.Database(),
+		apps:   authorizedappdb.NewAuthorizedAppDB(env.Database()),
+		audit:  env.Database(),
+		config: config,
+	}, nil
+}
+
+type handler struct {
+	env    *serverenv.ServerEnv
+	keys   handlers.APIKeyGetter
+	apps   appDB
+	audit  audit.DB
+	config *Config
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.Timeout)
+	defer cancel()
+	logger := logging.FromContext(ctx)
+	metrics := h.env.MetricsExporter(ctx)
+
+	apiKey, err := handlers.AuthenticateAPIKey(ctx, h.keys, r, database.PermissionAppAdmin)
+	if err != nil {
+		metrics.WriteInt("app-admin-unauthorized", true, 1)
+		handlers.WriteError(ctx, w, "App admin request", err)
+		return
+	}
+	ctx = audit.WithActor(ctx, audit.APIKeyActor(apiKey))
+
+	var resp interface{}
+	switch r.URL.Path {
+	case "/apps":
+		resp, err = h.handleApps(ctx, w, r)
+	case "/cache":
+		err = h.handleCache(ctx, r)
+	default:
+		err = &handlers.Error{Status: http.StatusNotFound, Message: http.StatusText(http.StatusNotFound)}
+	}
+	if err != nil {
+		handlers.WriteError(ctx, w, "App admin request", err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		metrics.WriteInt("app-admin-changes", true, 1)
+		logger.Infof("API key %v: %s %s?%s", apiKey.Name, r.Method, r.URL.Path, r.URL.RawQuery)
+	}
+
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf("Failed writing response: %v", err)
+	}
+}
+
+func (h *handler) handleApps(ctx context.Context, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		apps, err := h.apps.ListAuthorizedApps(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp := make([]*App, 0, len(apps))
+		for _, a := range apps {
+			resp = append(resp, AppFromModel(a))
+		}
+		return resp, nil
+
+	case http.MethodPut:
+		var a App
+		if err := unmarshal(w, r, &a); err != nil {
+			return nil, err
+		}
+		app, err := a.ToModel()
+		if err != nil {
+			return nil, err
+		}
+		if err := h.apps.AddAuthorizedApp(ctx, app); err != nil {
+			return nil, err
+		}
+		h.invalidate(ctx, app.AppPackageName)
+		resp := AppFromModel(app)
+		audit.Record(ctx, h.audit, audit.ActionSave, audit.ResourceAuthorizedApp, app.AppPackageName, resp)
+		return resp, nil
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get(appParam)
+		err := h.apps.DeleteAuthorizedApp(ctx, name)
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, handlers.Errorf(http.StatusNotFound, "unknown %s %q", appParam, name)
+		}
+		if err == nil {
+			h.invalidate(ctx, name)
+			audit.Record(ctx, h.audit, audit.ActionDelete, audit.ResourceAuthorizedApp, name, nil)
+		}
+		return nil, err
+	}
+	return nil, &handlers.Error{Status: http.StatusMethodNotAllowed, Message: http.StatusText(http.StatusMethodNotAllowed)}
+}
+
+// invalidate drops the app from this server's AuthorizedApp cache, and the
+// cache shared with other servers, if there are any, so that changes apply
+// at once here and wherever the app isn't cached in memory.
+func (h *handler) invalidate(ctx context.Context, name string) {
+	if inv, ok := h.env.AuthorizedAppProvider().(invalidator); ok {
+		if err := inv.Invalidate(ctx, name); err != nil {
+			logging.FromContext(ctx).Errorf("Failed to invalidate app %v: %v", name, err)
+		}
+	}
+}
+
+// handleCache drops an app, or every app if none is given, from the caches of
+// authorized apps, for example after the database was changed by other means
+// than this API.
+func (h *handler) handleCache(ctx context.Context, r *http.Request) error {
+	if r.Method != http.MethodDelete {
+		return &handlers.Error{Status: http.StatusMethodNotAllowed, Message: http.StatusText(http.StatusMethodNotAllowed)}
+	}
+	inv, ok := h.env.AuthorizedAppProvider().(invalidator)
+	if !ok {
+		return nil
+	}
+	if name := r.URL.Query().Get(appParam); name != "" {
+		return inv.Invalidate(ctx, name)
+	}
+	return inv.InvalidateAll(ctx)
+}
+
+func unmarshal(w http.ResponseWriter, r *http.Request, data interface{}) error {
+	code, err := jsonutil.Unmarshal(w, r, data)
+	if err != nil {
+		return &handlers.Error{Status: code, Message: err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appadmin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+const (
+	adminKey   = "admin-key"
+	publishKey = "publish-key"
+)
+
+// fakeDB keeps API keys and authorized apps in memory.
+type fakeDB struct {
+	apps  map[string]*model.AuthorizedApp
+	audit []*database.AuditEntry
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{apps: make(map[string]*model.AuthorizedApp)}
+}
+
+func (f *fakeDB) GetAPIKey(ctx context.Context, rawKey string) (*database.APIKey, error) {
+	switch rawKey {
+	case adminKey:
+		return &database.APIKey{Name: "admin", Permissions: []string{database.PermissionAppAdmin}}, nil
+	case publishKey:
+		return &database.APIKey{Name: "publisher", Permissions: []string{database.PermissionBatchPublish}}, nil
+	}
+	return nil, database.ErrNotFound
+}
+
+func (f *fakeDB) ListAuthorizedApps(ctx context.Context) ([]*model.AuthorizedApp, error) {
+	var apps []*model.AuthorizedApp
+	for _, a := range f.apps {
+		apps = append(apps, a)
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].AppPackageName < apps[j].AppPackageName })
+	return apps, nil
+}
+
+func (f *fakeDB) AddAuthorizedApp(ctx context.Context, app *model.AuthorizedApp) error {
+	f.apps[app.AppPackageName] = app
+	return nil
+}
+
+func (f *fakeDB) AddAuditEntry(ctx context.Context, e *database.AuditEntry) error {
+	f.audit = append(f.audit, e)
+	return nil
+}
+
+func (f *fakeDB) DeleteAuthorizedApp(ctx context.Context, name string) error {
+	if _, ok := f.apps[name]; !ok {
+		return database.ErrNotFound
+	}
+	delete(f.apps, name)
+	return nil
+}
+
+// fakeProvider records the apps invalidated in its cache.
+type fakeProvider struct {
+	invalidated []string
+}
+
+func (p *fakeProvider) AppConfig(ctx context.Context, name string) (*model.AuthorizedApp, error) {
+	return nil, authorizedapp.AppNotFound
+}
+
+func (p *fakeProvider) Invalidate(ctx context.Context, name string) error {
+	p.invalidated = append(p.invalidated, name)
+	return nil
+}
+
+func (p *fakeProvider) InvalidateAll(ctx context.Context) error {
+	p.invalidated = append(p.invalidated, "*")
+	return nil
+}
+
+func newTestHandler(db *fakeDB, provider authorizedapp.Provider) *handler {
+	ctx := context.Background()
+	return &handler{
+		env: serverenv.New(ctx,
+			serverenv.WithMetricsExporter(metrics.NewLogsBasedFromContext),
+			serverenv.WithAuthorizedAppProvider(provider)),
+		keys:   db,
+		apps:   db,
+		audit:  db,
+		config: &Config{Timeout: time.Minute},
+	}
+}
+
+func serve(h http.Handler, key, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuthentication(t *testing.T) {
+	h := newTestHandler(newFakeDB(), &fakeProvider{})
+
+	cases := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{name: "missing key", want: http.StatusUnauthorized},
+		{name: "unknown key", key: "nope", want: http.StatusUnauthorized},
+		{name: "no permission", key: publishKey, want: http.StatusForbidden},
+		{name: "admin", key: adminKey, want: http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if w := serve(h, tc.key, http.MethodGet, "/apps", ""); w.Code != tc.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPutValidation(t *testing.T) {
+	h := newTestHandler(newFakeDB(), &fakeProvider{})
+
+	cases := []struct {
+		name string
+		body string
+	}{
+		{name: "missing name", body: `{"platform": "android"}`},
+		{name: "bad platform", body: `{"appPackageName": "com.example.app", "platform": "web"}`},
+		{name: "bad region", body: `{"appPackageName": "com.example.app", "platform": "android", "allowedRegions": ["toolong"]}`},
+		{name: "ios without devicecheck", body: `{"appPackageName": "com.example.app", "platform": "ios"}`},
+		{name: "issuer without jwks", body: `{"appPackageName": "com.example.app", "platform": "android", "certificateIssuer": "ha.example.com"}`},
+		{name: "partial rate limit", body: `{"appPackageName": "com.example.app", "platform": "android", "rateLimitTokens": 10}`},
+		{name: "bad grpc api key", body: `{"appPackageName": "com.example.app", "platform": "android", "grpcApiKeySha256": "abc"}`},
+		{name: "unknown field", body: `{"appPackageName": "com.example.app", "platform": "android", "color": "red"}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if w := serve(h, adminKey, http.MethodPut, "/apps", tc.body); w.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestApps(t *testing.T) {
+	db := newFakeDB()
+	provider := &fakeProvider{}
+	h := newTestHandler(db, provider)
+
+	w := serve(h, adminKey, http.MethodPut, "/apps",
+		`{"appPackageName": "com.example.app", "platform": "Both", "allowedRegions": ["us", "ca"],
+		  "safetyNetCtsProfileMatch": false, "safetyNetPastSeconds": 3600,
+		  "deviceCheckTeamId": "ABCD1234", "deviceCheckKeyId": "DEFG5678", "deviceCheckPrivateKeySecret": "devicecheck-key",
+		  "certificateIssuer": "ha.example.com", "certificateJwksUri": "https://ha.example.com/jwks.json",
+		  "rateLimitTokens": 10, "rateLimitIntervalSeconds": 600}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT: got status %d: %s", w.Code, w.Body.String())
+	}
+	want := &model.AuthorizedApp{
+		AppPackageName:              "com.example.app",
+		Platform:                    "both",
+		AllowedRegions:              map[string]struct{}{"US": {}, "CA": {}},
+		SafetyNetBasicIntegrity:     true,
+		SafetyNetPastTime:           time.Hour,
+		DeviceCheckTeamID:           "ABCD1234",
+		DeviceCheckKeyID:            "DEFG5678",
+		DeviceCheckPrivateKeySecret: "devicecheck-key",
+		CertificateIssuer:           "ha.example.com",
+		CertificateJWKSURI:          "https://ha.example.com/jwks.json",
+		RateLimitTokens:             10,
+		RateLimitInterval:           10 * time.Minute,
+	}
+	if diff := cmp.Diff(want, db.apps["com.example.app"], cmpopts.IgnoreFields(model.AuthorizedApp{}, "DeviceCheckPrivateKey")); diff != "" {
+		t.Errorf("stored app mismatch (-want, +got):\n%s", diff)
+	}
+
+	w = serve(h, adminKey, http.MethodGet, "/apps", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET: got status %d: %s", w.Code, w.Body.String())
+	}
+	var got []*App
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	f, tr := false, true
+	wantList := []*App{{
+		AppPackageName:              "com.example.app",
+		Platform:                    "both",
+		AllowedRegions:              []string{"CA", "US"},
+		SafetyNetBasicIntegrity:     &tr,
+		SafetyNetCTSProfileMatch:    &f,
+		SafetyNetPastSeconds:        3600,
+		DeviceCheckTeamID:           "ABCD1234",
+		DeviceCheckKeyID:            "DEFG5678",
+		DeviceCheckPrivateKeySecret: "devicecheck-key",
+		CertificateIssuer:           "ha.example.com",
+		CertificateJWKSURI:          "https://ha.example.com/jwks.json",
+		RateLimitTokens:             10,
+		RateLimitIntervalSeconds:    600,
+	}}
+	if diff := cmp.Diff(wantList, got); diff != "" {
+		t.Errorf("GET mismatch (-want, +got):\n%s", diff)
+	}
+
+	if w := serve(h, adminKey, http.MethodDelete, "/apps?app=com.example.app", ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE: got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := serve(h, adminKey, http.MethodDelete, "/apps?app=com.example.app", ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE twice: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if len(db.apps) != 0 {
+		t.Errorf("app not deleted: %v", db.apps)
+	}
+
+	// Changes are dropped from this server's cache.
+	if diff := cmp.Diff([]string{"com.example.app", "com.example.app"}, provider.invalidated); diff != "" {
+		t.Errorf("invalidated mismatch (-want, +got):\n%s", diff)
+	}
+
+	var audited []string
+	for _, e := range db.audit {
+		audited = append(audited, e.Actor+" "+e.Action+" "+e.Resource+" "+e.ResourceID)
+	}
+	wantAudited := []string{
+		"api-key:admin save authorized_app com.example.app",
+		"api-key:admin delete authorized_app com.example.app",
+	}
+	if diff := cmp.Diff(wantAudited, audited); diff != "" {
+		t.Errorf("audit mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestCache(t *testing.T) {
+	provider := &fakeProvider{}
+	h := newTestHandler(newFakeDB(), provider)
+
+	if w := serve(h, adminKey, http.MethodDelete, "/cache?app=com.example.app", ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE app: got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := serve(h, adminKey, http.MethodDelete, "/cache", ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE all: got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := serve(h, adminKey, http.MethodGet, "/cache", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+	if diff := cmp.Diff([]string{"com.example.app", "*"}, provider.invalidated); diff != "" {
+		t.Errorf("invalidated mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appadmin
+
+import (
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/setup"
+)
+
+// Compile-time check to assert this config matches requirements.
+var _ setup.DBConfigProvider = (*Config)(nil)
+var _ setup.AuthorizedAppConfigProvider = (*Config)(nil)
+
+// Config is the configuration for the app admin API.
+type Config struct {
+	Database *database.Config
+	Port     string        `envconfig:"PORT" default:"8080"`
+	Timeout  time.Duration `envconfig:"RPC_TIMEOUT" default:"30s"`
+
+	// AuthorizedApp configures the cache of authorized apps, which the API
+	// invalidates when it changes an app, including the cache shared with
+	// the publish servers.
+	AuthorizedApp *authorizedapp.Config
+}
+
+// DB returns the database config.
+func (c *Config) DB() *database.Config {
+	return c.Database
+}
+
+// AuthorizedAppConfig returns the authorized app config.
+func (c *Config) AuthorizedAppConfig() *authorizedapp.Config {
+	return c.AuthorizedApp
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appadmin
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/handlers"
+)
+
+var (
+	validSHA256Str    = `\A[0-9a-f]{64}\z`
+	validSHA256Regexp = regexp.MustCompile(validSHA256Str)
+)
+
+// App is an app authorized to publish keys. The settings of its health
+// authority are not managed here.
+type App struct {
+	AppPackageName    string   `json:"appPackageName"`
+	Platform          string   `json:"platform"`
+	AllowedRegions    []string `json:"allowedRegions,omitempty"`
+	HealthAuthorityID string   `json:"healthAuthorityId,omitempty"`
+
+	SafetyNetDisabled        bool     `json:"safetyNetDisabled,omitempty"`
+	SafetyNetApkDigestSHA256 []string `json:"safetyNetApkDigestSha256,omitempty"`
+	// SafetyNetBasicIntegrity and SafetyNetCTSProfileMatch are required
+	// unless set to false.
+	SafetyNetBasicIntegrity  *bool `json:"safetyNetBasicIntegrity,omitempty"`
+	SafetyNetCTSProfileMatch *bool `json:"safetyNetCtsProfileMatch,omitempty"`
+	SafetyNetPastSeconds     int   `json:"safetyNetPastSeconds,omitempty"`
+	SafetyNetFutureSeconds   int   `json:"safetyNetFutureSeconds,omitempty"`
+
+	DeviceCheckDisabled bool   `json:"deviceCheckDisabled,omitempty"`
+	DeviceCheckTeamID   string `json:"deviceCheckTeamId,omitempty"`
+	DeviceCheckKeyID    string `json:"deviceCheckKeyId,omitempty"`
+	// DeviceCheckPrivateKeySecret names the secret holding the DeviceCheck
+	// private key, not the key itself.
+	DeviceCheckPrivateKeySecret string `json:"deviceCheckPrivateKeySecret,omitempty"`
+
+	CertificateIssuer   string `json:"certificateIssuer,omitempty"`
+	CertificateAudience string `json:"certificateAudience,omitempty"`
+	CertificateJWKSURI  string `json:"certificateJwksUri,omitempty"`
+
+	RateLimitTokens          int    `json:"rateLimitTokens,omitempty"`
+	RateLimitIntervalSeconds int    `json:"rateLimitIntervalSeconds,omitempty"`
+	MaxKeysPerDay            int    `json:"maxKeysPerDay,omitempty"`
+	GRPCAPIKeySHA256         string `json:"grpcApiKeySha256,omitempty"`
+}
+
+// AppFromModel converts an AuthorizedApp for the API.
+func AppFromModel(a *model.AuthorizedApp) *App {
+	regions := make([]string, 0, len(a.AllowedRegions))
+	for r := range a.AllowedRegions {
+		regions = append(regions, r)
+	}
+	sort.Strings(regions)
+
+	return &App{
+		AppPackageName:              a.AppPackageName,
+		Platform:                    a.Platform,
+		AllowedRegions:              regions,
+		HealthAuthorityID:           a.HealthAuthorityID,
+		SafetyNetDisabled:           a.SafetyNetDisabled,
+		SafetyNetApkDigestSHA256:    a.SafetyNetApkDigestSHA256,
+		SafetyNetBasicIntegrity:     boolPtr(a.SafetyNetBasicIntegrity),
+		SafetyNetCTSProfileMatch:    boolPtr(a.SafetyNetCTSProfileMatch),
+		SafetyNetPastSeconds:        int(a.SafetyNetPastTime.Seconds()),
+		SafetyNetFutureSeconds:      int(a.SafetyNetFutureTime.Seconds()),
+		DeviceCheckDisabled:         a.DeviceCheckDisabled,
+		DeviceCheckTeamID:           a.DeviceCheckTeamID,
+		DeviceCheckKeyID:            a.DeviceCheckKeyID,
+		DeviceCheckPrivateKeySecret: a.DeviceCheckPrivateKeySecret,
+		CertificateIssuer:           a.CertificateIssuer,
+		CertificateAudience:         a.CertificateAudience,
+		CertificateJWKSURI:          a.CertificateJWKSURI,
+		RateLimitTokens:             a.RateLimitTokens,
+		RateLimitIntervalSeconds:    int(a.RateLimitInterval.Seconds()),
+		MaxKeysPerDay:               a.MaxKeysPerDay,
+		GRPCAPIKeySHA256:            a.GRPCAPIKeySHA256,
+	}
+}
+
+// ToModel validates the app and converts it to an AuthorizedApp. Errors
+// describe what is invalid, to return to the client.
+func (a *App) ToModel() (*model.AuthorizedApp, error) {
+	app := model.NewAuthorizedApp()
+	app.AppPackageName = strings.TrimSpace(a.AppPackageName)
+	app.Platform = strings.ToLower(strings.TrimSpace(a.Platform))
+	app.HealthAuthorityID = a.HealthAuthorityID
+	for _, r := range a.AllowedRegions {
+		app.AllowedRegions[strings.ToUpper(strings.TrimSpace(r))] = struct{}{}
+	}
+
+	app.SafetyNetDisabled = a.SafetyNetDisabled
+	app.SafetyNetApkDigestSHA256 = a.SafetyNetApkDigestSHA256
+	app.SafetyNetBasicIntegrity = a.SafetyNetBasicIntegrity == nil || *a.SafetyNetBasicIntegrity
+	app.SafetyNetCTSProfileMatch = a.SafetyNetCTSProfileMatch == nil || *a.SafetyNetCTSProfileMatch
+	app.SafetyNetPastTime = time.Duration(a.SafetyNetPastSeconds) * time.Second
+	app.SafetyNetFutureTime = time.Duration(a.SafetyNetFutureSeconds) * time.Second
+
+	app.DeviceCheckDisabled = a.DeviceCheckDisabled
+	app.DeviceCheckTeamID = a.DeviceCheckTeamID
+	app.DeviceCheckKeyID = a.DeviceCheckKeyID
+	app.DeviceCheckPrivateKeySecret = a.DeviceCheckPrivateKeySecret
+
+	app.CertificateIssuer = a.CertificateIssuer
+	app.CertificateAudience = a.CertificateAudience
+	app.CertificateJWKSURI = a.CertificateJWKSURI
+
+	app.RateLimitTokens = a.RateLimitTokens
+	app.RateLimitInterval = time.Duration(a.RateLimitIntervalSeconds) * time.Second
+	app.MaxKeysPerDay = a.MaxKeysPerDay
+	app.GRPCAPIKeySHA256 = strings.ToLower(a.GRPCAPIKeySHA256)
+
+	if err := validateApp(app); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// validateApp rejects apps that publish requests could never satisfy, or
+// that the AuthorizedApp table can't store.
+func validateApp(app *model.AuthorizedApp) error {
+	if app.AppPackageName == "" || len(app.AppPackageName) > 1000 {
+		return handlers.BadRequest("appPackageName is required and must be at most 1000 characters")
+	}
+	if !app.IsIOS() && !app.IsAndroid() {
+		return handlers.BadRequest("platform %q must be android, ios or both", app.Platform)
+	}
+	for r := range app.AllowedRegions {
+		if r == "" || len(r) > 5 {
+			return handlers.BadRequest("allowedRegions entry %q must be 1 to 5 characters", r)
+		}
+	}
+	if app.SafetyNetPastTime < 0 || app.SafetyNetFutureTime < 0 {
+		return handlers.BadRequest("safetyNetPastSeconds and safetyNetFutureSeconds must not be negative")
+	}
+	if app.IsIOS() && !app.DeviceCheckDisabled &&
+		(app.DeviceCheckTeamID == "" || app.DeviceCheckKeyID == "" || app.DeviceCheckPrivateKeySecret == "") {
+		return handlers.BadRequest("deviceCheckTeamId, deviceCheckKeyId and deviceCheckPrivateKeySecret are required for ios apps unless deviceCheckDisabled is set")
+	}
+	// Without a key set, certificates are verified with the keys stored for
+	// the app's health authority.
+	if app.RequiresCertificate() && app.CertificateJWKSURI == "" && app.HealthAuthorityID == "" {
+		return handlers.BadRequest("certificateJwksUri or healthAuthorityId is required with certificateIssuer")
+	}
+	if app.RateLimitTokens < 0 || app.RateLimitInterval < 0 || (app.RateLimitTokens == 0) != (app.RateLimitInterval == 0) {
+		return handlers.BadRequest("rateLimitTokens and rateLimitIntervalSeconds must be set together and not be negative")
+	}
+	if app.MaxKeysPerDay < 0 {
+		return handlers.BadRequest("maxKeysPerDay must not be negative")
+	}
+	if app.GRPCAPIKeySHA256 != "" && !validSHA256Regexp.MatchString(app.GRPCAPIKeySHA256) {
+		return handlers.BadRequest("grpcApiKeySha256 must match %s", validSHA256Str)
+	}
+	return nil
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
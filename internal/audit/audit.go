@@ -0,0 +1,119 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit records who changed configuration or deleted data, and when.
+//
+// Each entry is written to the AuditLog table and to the logs as a structured
+// entry with "audit" set, so it can be routed to a separate log sink. The
+// actor of an entry is taken from the context, see WithActor.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+)
+
+// Actions.
+const (
+	ActionSave   = "save"
+	ActionDelete = "delete"
+	ActionRotate = "rotate"
+)
+
+// Resources.
+const (
+	ResourceExportConfig            = "export_config"
+	ResourceSignatureInfo           = "signature_info"
+	ResourceAuthorizedApp           = "authorized_app"
+	ResourceHealthAuthority         = "health_authority"
+	ResourceHealthAuthorityKey      = "health_authority_key"
+	ResourceConfigSetting           = "config_setting"
+	ResourceFederationQuery         = "federation_in_query"
+	ResourceFederationAuthorization = "federation_out_authorization"
+	ResourceFederationPushTarget    = "federation_push_target"
+	ResourceExposures               = "exposures"
+	ResourceExportFiles             = "export_files"
+)
+
+// unknownActor is recorded when the context carries no actor.
+const unknownActor = "unknown"
+
+type actorKey struct{}
+
+// WithActor returns a context whose audit entries are recorded as taken by
+// actor, such as an operator's email, "api-key:NAME" or "system:SERVICE".
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor of ctx, or "unknown" if it has none.
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return unknownActor
+}
+
+// APIKeyActor returns the actor of requests authenticated by an API key.
+func APIKeyActor(k *database.APIKey) string {
+	return "api-key:" + k.Name
+}
+
+// SystemActor returns the actor of a job run by a service.
+func SystemActor(service string) string {
+	return "system:" + service
+}
+
+// DB is the part of the database that stores audit entries.
+type DB interface {
+	AddAuditEntry(ctx context.Context, e *database.AuditEntry) error
+}
+
+// Record records that the actor of ctx took action on a resource. details, if
+// not nil, is marshaled to JSON and should hold the changed values. The action
+// has already happened, so failures to store the entry are logged instead of
+// returned; the entry is always written to the logs.
+func Record(ctx context.Context, db DB, action, resource, resourceID string, details interface{}) {
+	logger := logging.FromContext(ctx)
+
+	e := &database.AuditEntry{
+		Actor:      ActorFromContext(ctx),
+		Action:     action,
+		Resource:   resource,
+		ResourceID: resourceID,
+	}
+	if details != nil {
+		b, err := json.Marshal(details)
+		if err != nil {
+			logger.Errorf("Failed to marshal audit details of %s %s: %v", action, resource, err)
+		} else {
+			e.Details = b
+		}
+	}
+
+	logger.Infow("audit",
+		"audit", true,
+		"actor", e.Actor,
+		"action", e.Action,
+		"resource", e.Resource,
+		"resourceID", e.ResourceID,
+		"details", e.Details)
+
+	if err := db.AddAuditEntry(ctx, e); err != nil {
+		logger.Errorf("Failed to store audit entry of %s %s %q by %s: %v", action, resource, resourceID, e.Actor, err)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/go-cmp/cmp"
+)
+
+const (
+	auditKey  = "audit-key"
+	otherKey  = "other-key"
+	failedKey = "failed-key"
+)
+
+// fakeDB keeps audit entries in memory.
+type fakeDB struct {
+	entries  []*database.AuditEntry
+	criteria database.AuditCriteria
+	err      error
+}
+
+func (f *fakeDB) AddAuditEntry(ctx context.Context, e *database.AuditEntry) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.entries = append(f.entries, e)
+	return nil
+}
+
+func (f *fakeDB) GetAPIKey(ctx context.Context, rawKey string) (*database.APIKey, error) {
+	switch rawKey {
+	case auditKey:
+		return &database.APIKey{Name: "auditor", Permissions: []string{database.PermissionAuditRead}}, nil
+	case otherKey:
+		return &database.APIKey{Name: "admin", Permissions: []string{database.PermissionAppAdmin}}, nil
+	case failedKey:
+		return nil, errors.New("database down")
+	}
+	return nil, database.ErrNotFound
+}
+
+func (f *fakeDB) ListAuditEntries(ctx context.Context, criteria database.AuditCriteria) ([]*database.AuditEntry, error) {
+	f.criteria = criteria
+	return f.entries, nil
+}
+
+func TestRecord(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeDB{}
+
+	Record(WithActor(ctx, "ops@example.com"), db, ActionSave, ResourceExportConfig, "7", map[string]string{"period": "24h"})
+	Record(ctx, db, ActionDelete, ResourceExposures, "", nil)
+
+	want := []*database.AuditEntry{
+		{Actor: "ops@example.com", Action: ActionSave, Resource: ResourceExportConfig, ResourceID: "7", Details: json.RawMessage(`{"period":"24h"}`)},
+		{Actor: "unknown", Action: ActionDelete, Resource: ResourceExposures},
+	}
+	if diff := cmp.Diff(want, db.entries); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Failures to store the entry don't panic or block the caller.
+	Record(ctx, &fakeDB{err: errors.New("database down")}, ActionDelete, ResourceExposures, "", nil)
+}
+
+func TestActors(t *testing.T) {
+	if got, want := APIKeyActor(&database.APIKey{Name: "admin"}), "api-key:admin"; got != want {
+		t.Errorf("APIKeyActor: got %q, want %q", got, want)
+	}
+	if got, want := SystemActor("cleanup-exposure"), "system:cleanup-exposure"; got != want {
+		t.Errorf("SystemActor: got %q, want %q", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	created := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	db := &fakeDB{entries: []*database.AuditEntry{
+		{ID: 1, CreatedAt: created, Actor: "api-key:admin", Action: ActionDelete, Resource: ResourceAuthorizedApp, ResourceID: "com.example.app"},
+	}}
+	h := &handler{
+		env:     serverenv.New(ctx, serverenv.WithMetricsExporter(metrics.NewLogsBasedFromContext)),
+		db:      db,
+		timeout: time.Minute,
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		key    string
+		target string
+		status int
+	}{
+		{name: "no key", method: "GET", target: "/", status: http.StatusUnauthorized},
+		{name: "unknown key", method: "GET", key: "nope", target: "/", status: http.StatusUnauthorized},
+		{name: "wrong permission", method: "GET", key: otherKey, target: "/", status: http.StatusForbidden},
+		{name: "key lookup failed", method: "GET", key: failedKey, target: "/", status: http.StatusInternalServerError},
+		{name: "wrong method", method: "POST", key: auditKey, target: "/", status: http.StatusMethodNotAllowed},
+		{name: "invalid since", method: "GET", key: auditKey, target: "/?since=yesterday", status: http.StatusBadRequest},
+		{name: "invalid limit", method: "GET", key: auditKey, target: "/?limit=0", status: http.StatusBadRequest},
+		{name: "ok", method: "GET", key: auditKey, target: "/?resource=authorized_app&since=2020-06-01T00:00:00Z&limit=10", status: http.StatusOK},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(c.method, c.target, nil)
+			if c.key != "" {
+				r.Header.Set("Authorization", "Bearer "+c.key)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != c.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, c.status, w.Body)
+			}
+		})
+	}
+
+	wantCriteria := database.AuditCriteria{
+		Resource: ResourceAuthorizedApp,
+		Since:    time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+		Limit:    10,
+	}
+	if diff := cmp.Diff(wantCriteria, db.criteria); diff != "" {
+		t.Errorf("criteria mismatch (-want, +got):\n%s", diff)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+auditKey)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var got []*Entry
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []*Entry{{ID: 1, Time: created, Actor: "api-key:admin", Action: ActionDelete, Resource: ResourceAuthorizedApp, ResourceID: "com.example.app"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("response mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/handlers"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+)
+
+// queryDB is the part of the database used by the audit log API.
+type queryDB interface {
+	GetAPIKey(ctx context.Context, rawKey string) (*database.APIKey, error)
+	ListAuditEntries(ctx context.Context, criteria database.AuditCriteria) ([]*database.AuditEntry, error)
+}
+
+// Entry is an audit entry as the API returns it.
+type Entry struct {
+	ID         int64           `json:"id"`
+	Time       time.Time       `json:"time"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	Resource   string          `json:"resource"`
+	ResourceID string          `json:"resourceID,omitempty"`
+	Details    json.RawMessage `json:"details,omitempty"`
+}
+
+// NewHandler returns the audit log API. Requests must carry an API key with
+// the audit_read permission as a bearer token. It serves the newest entries
+// first on GET, filtered by the query parameters actor, resource,
+// resource-id, since and until, which are RFC 3339 times, and at most limit
+// entries.
+func NewHandler(env *serverenv.ServerEnv, timeout time.Duration) (http.Handler, error) {
+	if env.Database() == nil {
+		return nil, fmt.Errorf("missing database in server environment")
+	}
+	return &handler{
+		env:     env,
+		db:      env.Database(),
+		timeout: timeout,
+	}, nil
+}
+
+type handler struct {
+	env     *serverenv.ServerEnv
+	db      queryDB
+	timeout time.Duration
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+	logger := logging.FromContext(ctx)
+
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := handlers.AuthenticateAPIKey(ctx, h.db, r, database.PermissionAuditRead); err != nil {
+		h.env.MetricsExporter(ctx).WriteInt("audit-unauthorized", true, 1)
+		handlers.WriteError(ctx, w, "Audit log request", err)
+		return
+	}
+
+	criteria, err := parseCriteria(r)
+	if err != nil {
+		logger.Debug(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, err := h.db.ListAuditEntries(ctx, criteria)
+	if err != nil {
+		logger.Errorf("Failed listing audit entries: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]*Entry, 0, len(entries))
+	for _, e := range entries {
+		resp = append(resp, &Entry{
+			ID:         e.ID,
+			Time:       e.CreatedAt,
+			Actor:      e.Actor,
+			Action:     e.Action,
+			Resource:   e.Resource,
+			ResourceID: e.ResourceID,
+			Details:    e.Details,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf("Failed writing response: %v", err)
+	}
+}
+
+func parseCriteria(r *http.Request) (database.AuditCriteria, error) {
+	q := r.URL.Query()
+	criteria := database.AuditCriteria{
+		Actor:      q.Get("actor"),
+		Resource:   q.Get("resource"),
+		ResourceID: q.Get("resource-id"),
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{"since", &criteria.Since},
+		{"until", &criteria.Until},
+	} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return criteria, fmt.Errorf("invalid %s %q, want an RFC 3339 time", p.name, v)
+		}
+		*p.t = t
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > database.MaxAuditEntries {
+			return criteria, fmt.Errorf("invalid limit %q, want 1 to %d", v, database.MaxAuditEntries)
+		}
+		criteria.Limit = limit
+	}
+	return criteria, nil
+}
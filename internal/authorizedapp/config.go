@@ -16,12 +16,22 @@ package authorizedapp
 
 import (
 	"time"
+
+	"github.com/google/exposure-notifications-server/internal/cache"
 )
 
 type Config struct {
 	// CacheDuration is the amount of time AuthorizedApp should be cached before
 	// being re-read from their provider.
 	CacheDuration time.Duration `envconfig:"AUTHORIZED_APP_CACHE_DURATION" default:"5m"`
+
+	// CacheJitter is the most time added at random to CacheDuration for each
+	// cached AuthorizedApp, so that refreshes are spread out.
+	CacheJitter time.Duration `envconfig:"AUTHORIZED_APP_CACHE_JITTER" default:"30s"`
+
+	// Cache configures the shared tier of the cache, through which the
+	// instances of a service share the apps they read.
+	Cache *cache.Config
 }
 
 // AuthorizedApp implements an interface for setup.
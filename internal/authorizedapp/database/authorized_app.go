@@ -17,7 +17,9 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
@@ -37,6 +39,15 @@ func NewAuthorizedAppDB(db *database.DB) *AuthorizedAppDB {
 	}
 }
 
+const authorizedAppColumns = `
+	app_package_name, platform, allowed_regions,
+	safetynet_disabled, safetynet_apk_digest, safetynet_cts_profile_match, safetynet_basic_integrity, safetynet_past_seconds, safetynet_future_seconds,
+	devicecheck_disabled, devicecheck_team_id, devicecheck_key_id, devicecheck_private_key_secret,
+	health_authority_id, COALESCE(embargo_same_day_keys, false), COALESCE(allow_travelers, false), onset_transmission_risk,
+	certificate_issuer, certificate_audience, certificate_jwks_uri,
+	rate_limit_tokens, rate_limit_interval_seconds, max_keys_per_day,
+	grpc_api_key_sha256`
+
 // GetAuthorizedApp loads a single AuthorizedApp for the given name. If no row
 // exists, this returns nil.
 func (db *AuthorizedAppDB) GetAuthorizedApp(ctx context.Context, sm secrets.SecretManager, name string) (*model.AuthorizedApp, error) {
@@ -47,28 +58,183 @@ func (db *AuthorizedAppDB) GetAuthorizedApp(ctx context.Context, sm secrets.Secr
 	defer conn.Release()
 
 	query := `
-		SELECT
-			app_package_name, platform, allowed_regions,
-			safetynet_disabled, safetynet_apk_digest, safetynet_cts_profile_match, safetynet_basic_integrity, safetynet_past_seconds, safetynet_future_seconds,
-			devicecheck_disabled, devicecheck_team_id, devicecheck_key_id, devicecheck_private_key_secret
+		SELECT` + authorizedAppColumns + `
 		FROM
 			AuthorizedApp
+		LEFT JOIN
+			HealthAuthority USING (health_authority_id)
 		WHERE app_package_name = $1`
 
-	row := conn.QueryRow(ctx, query, name)
+	config, err := scanAuthorizedApp(conn.QueryRow(ctx, query, name))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if err := ResolveSecrets(ctx, sm, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ResolveSecrets resolves the secrets of config to their plaintext values, so
+// that DeviceCheckPrivateKey is set.
+func ResolveSecrets(ctx context.Context, sm secrets.SecretManager, config *model.AuthorizedApp) error {
+	if v := config.DeviceCheckPrivateKeySecret; v != "" {
+		plaintext, err := sm.GetSecretValue(ctx, v)
+		if err != nil {
+			return fmt.Errorf("devicecheck_private_key_secret at %s (%s): %w",
+				config.AppPackageName, config.Platform, err)
+		}
+
+		key, err := ios.ParsePrivateKey(plaintext)
+		if err != nil {
+			return fmt.Errorf("failed to parse private key at %s (%s): %w",
+				config.AppPackageName, config.Platform, err)
+		}
+		config.DeviceCheckPrivateKey = key
+	}
+	return nil
+}
+
+// ListAuthorizedApps returns every AuthorizedApp, ordered by name. Secrets are
+// not resolved, so DeviceCheckPrivateKey is not set.
+func (db *AuthorizedAppDB) ListAuthorizedApps(ctx context.Context) ([]*model.AuthorizedApp, error) {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
 
+	query := `
+		SELECT` + authorizedAppColumns + `
+		FROM
+			AuthorizedApp
+		LEFT JOIN
+			HealthAuthority USING (health_authority_id)
+		ORDER BY app_package_name`
+
+	rows, err := conn.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("listing authorized apps: %w", err)
+	}
+	defer rows.Close()
+
+	var apps []*model.AuthorizedApp
+	for rows.Next() {
+		app, err := scanAuthorizedApp(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning authorized app: %w", err)
+		}
+		apps = append(apps, app)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing authorized apps: %w", err)
+	}
+	return apps, nil
+}
+
+// AddAuthorizedApp inserts the AuthorizedApp, or updates it if an app with
+// the same name exists. The settings of the app's health authority are not
+// written.
+func (db *AuthorizedAppDB) AddAuthorizedApp(ctx context.Context, app *model.AuthorizedApp) error {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	allowedRegions := make([]string, 0, len(app.AllowedRegions))
+	for r := range app.AllowedRegions {
+		allowedRegions = append(allowedRegions, r)
+	}
+	sort.Strings(allowedRegions)
+
+	query := `
+		INSERT INTO AuthorizedApp (
+			app_package_name, platform, allowed_regions,
+			safetynet_disabled, safetynet_apk_digest, safetynet_cts_profile_match, safetynet_basic_integrity, safetynet_past_seconds, safetynet_future_seconds,
+			devicecheck_disabled, devicecheck_team_id, devicecheck_key_id, devicecheck_private_key_secret,
+			health_authority_id,
+			certificate_issuer, certificate_audience, certificate_jwks_uri,
+			rate_limit_tokens, rate_limit_interval_seconds, max_keys_per_day,
+			grpc_api_key_sha256
+		)
+		VALUES (
+			$1, $2, $3,
+			$4, $5, $6, $7, NULLIF($8, 0), NULLIF($9, 0),
+			$10, NULLIF($11, ''), NULLIF($12, ''), NULLIF($13, ''),
+			$14,
+			NULLIF($15, ''), NULLIF($16, ''), NULLIF($17, ''),
+			NULLIF($18, 0), NULLIF($19, 0), NULLIF($20, 0),
+			NULLIF($21, '')
+		)
+		ON CONFLICT (app_package_name) DO UPDATE SET
+			platform = $2, allowed_regions = $3,
+			safetynet_disabled = $4, safetynet_apk_digest = $5, safetynet_cts_profile_match = $6, safetynet_basic_integrity = $7,
+			safetynet_past_seconds = NULLIF($8, 0), safetynet_future_seconds = NULLIF($9, 0),
+			devicecheck_disabled = $10, devicecheck_team_id = NULLIF($11, ''), devicecheck_key_id = NULLIF($12, ''), devicecheck_private_key_secret = NULLIF($13, ''),
+			health_authority_id = $14,
+			certificate_issuer = NULLIF($15, ''), certificate_audience = NULLIF($16, ''), certificate_jwks_uri = NULLIF($17, ''),
+			rate_limit_tokens = NULLIF($18, 0), rate_limit_interval_seconds = NULLIF($19, 0), max_keys_per_day = NULLIF($20, 0),
+			grpc_api_key_sha256 = NULLIF($21, '')`
+
+	if _, err := conn.Exec(ctx, query,
+		app.AppPackageName, app.Platform, allowedRegions,
+		app.SafetyNetDisabled, app.SafetyNetApkDigestSHA256, app.SafetyNetCTSProfileMatch, app.SafetyNetBasicIntegrity,
+		int(app.SafetyNetPastTime.Seconds()), int(app.SafetyNetFutureTime.Seconds()),
+		app.DeviceCheckDisabled, app.DeviceCheckTeamID, app.DeviceCheckKeyID, app.DeviceCheckPrivateKeySecret,
+		app.HealthAuthorityID,
+		app.CertificateIssuer, app.CertificateAudience, app.CertificateJWKSURI,
+		app.RateLimitTokens, int(app.RateLimitInterval.Seconds()), app.MaxKeysPerDay,
+		app.GRPCAPIKeySHA256,
+	); err != nil {
+		return fmt.Errorf("upserting authorized app %v: %w", app.AppPackageName, err)
+	}
+	return nil
+}
+
+// DeleteAuthorizedApp deletes the AuthorizedApp with the given name. It
+// returns database.ErrNotFound if there is no such app.
+func (db *AuthorizedAppDB) DeleteAuthorizedApp(ctx context.Context, name string) error {
+	conn, err := db.db.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	result, err := conn.Exec(ctx, `DELETE FROM AuthorizedApp WHERE app_package_name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("deleting authorized app %v: %w", name, err)
+	}
+	if result.RowsAffected() == 0 {
+		return database.ErrNotFound
+	}
+	return nil
+}
+
+// scanAuthorizedApp reads a row of authorizedAppColumns. Secrets are not
+// resolved.
+func scanAuthorizedApp(row pgx.Row) (*model.AuthorizedApp, error) {
 	config := model.NewAuthorizedApp()
 	var allowedRegions []string
 	var safetyNetPastSeconds, safetyNetFutureSeconds *int
 	var deviceCheckTeamID, deviceCheckKeyID, deviceCheckPrivateKeySecret sql.NullString
+	var certificateIssuer, certificateAudience, certificateJWKSURI sql.NullString
+	var grpcAPIKeySHA256 sql.NullString
+	var onsetTransmissionRisk []byte
+	var rateLimitTokens, rateLimitIntervalSeconds, maxKeysPerDay *int
 	if err := row.Scan(
 		&config.AppPackageName, &config.Platform, &allowedRegions,
 		&config.SafetyNetDisabled, &config.SafetyNetApkDigestSHA256, &config.SafetyNetCTSProfileMatch, &config.SafetyNetBasicIntegrity, &safetyNetPastSeconds, &safetyNetFutureSeconds,
 		&config.DeviceCheckDisabled, &deviceCheckTeamID, &deviceCheckKeyID, &deviceCheckPrivateKeySecret,
+		&config.HealthAuthorityID, &config.EmbargoSameDayKeys, &config.AllowTravelers, &onsetTransmissionRisk,
+		&certificateIssuer, &certificateAudience, &certificateJWKSURI,
+		&rateLimitTokens, &rateLimitIntervalSeconds, &maxKeysPerDay,
+		&grpcAPIKeySHA256,
 	); err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, nil
-		}
 		return nil, err
 	}
 
@@ -96,20 +262,29 @@ func (db *AuthorizedAppDB) GetAuthorizedApp(ctx context.Context, sm secrets.Secr
 		config.DeviceCheckKeyID = v.String
 	}
 
-	// Resolve secrets to their plaintext values
-	if v := deviceCheckPrivateKeySecret; v.Valid && v.String != "" {
-		plaintext, err := sm.GetSecretValue(ctx, v.String)
-		if err != nil {
-			return nil, fmt.Errorf("devicecheck_private_key_secret at %s (%s): %w",
-				config.AppPackageName, config.Platform, err)
-		}
+	config.DeviceCheckPrivateKeySecret = deviceCheckPrivateKeySecret.String
+	config.CertificateIssuer = certificateIssuer.String
+	config.CertificateAudience = certificateAudience.String
+	config.CertificateJWKSURI = certificateJWKSURI.String
+	config.GRPCAPIKeySHA256 = grpcAPIKeySHA256.String
 
-		key, err := ios.ParsePrivateKey(plaintext)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse private key at %s (%s): %w",
-				config.AppPackageName, config.Platform, err)
+	if rateLimitTokens != nil && rateLimitIntervalSeconds != nil {
+		config.RateLimitTokens = *rateLimitTokens
+		config.RateLimitInterval = time.Duration(*rateLimitIntervalSeconds) * time.Second
+	}
+	if maxKeysPerDay != nil {
+		config.MaxKeysPerDay = *maxKeysPerDay
+	}
+	if len(onsetTransmissionRisk) > 0 {
+		if err := json.Unmarshal(onsetTransmissionRisk, &config.OnsetTransmissionRisk); err != nil {
+			return nil, fmt.Errorf("onset_transmission_risk of %s: %w", config.HealthAuthorityID, err)
+		}
+		for days, risk := range config.OnsetTransmissionRisk {
+			if risk < database.MinTransmissionRisk || risk > database.MaxTransmissionRisk {
+				return nil, fmt.Errorf("onset_transmission_risk of %s: invalid transmission risk %v for %v days, must be >= %v && <= %v",
+					config.HealthAuthorityID, risk, days, database.MinTransmissionRisk, database.MaxTransmissionRisk)
+			}
 		}
-		config.DeviceCheckPrivateKey = key
 	}
 
 	return config, nil
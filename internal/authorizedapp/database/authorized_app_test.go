@@ -21,6 +21,7 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -202,16 +203,110 @@ func TestGetAuthorizedApp(t *testing.T) {
 				"myapp", "ios", []string{"US"},
 				false, "ABCD1234", "DEFG5678", "private_key",
 			},
+			exp: &model.AuthorizedApp{
+				AppPackageName:              "myapp",
+				Platform:                    "ios",
+				AllowedRegions:              map[string]struct{}{"US": {}},
+				SafetyNetCTSProfileMatch:    true,
+				SafetyNetBasicIntegrity:     true,
+				DeviceCheckDisabled:         false,
+				DeviceCheckTeamID:           "ABCD1234",
+				DeviceCheckKeyID:            "DEFG5678",
+				DeviceCheckPrivateKey:       p8PrivateKey,
+				DeviceCheckPrivateKeySecret: "private_key",
+			},
+		},
+		{
+			name: "health_authority",
+			sql: `
+				INSERT INTO AuthorizedApp (app_package_name, platform, allowed_regions, health_authority_id)
+				VALUES ($1, $2, $3, $4)
+			`,
+			args: []interface{}{"myapp", "android", []string{"US"}, "ha-1"},
+			exp: &model.AuthorizedApp{
+				AppPackageName:           "myapp",
+				Platform:                 "android",
+				AllowedRegions:           map[string]struct{}{"US": {}},
+				HealthAuthorityID:        "ha-1",
+				SafetyNetBasicIntegrity:  true,
+				SafetyNetCTSProfileMatch: true,
+			},
+		},
+		{
+			name: "health_authority_policy",
+			sql: `
+				WITH ha AS (
+					INSERT INTO HealthAuthority (health_authority_id, embargo_same_day_keys, allow_travelers)
+					VALUES ($4, true, true)
+				)
+				INSERT INTO AuthorizedApp (app_package_name, platform, allowed_regions, health_authority_id)
+				VALUES ($1, $2, $3, $4)
+			`,
+			args: []interface{}{"myapp", "android", []string{"US"}, "ha-1"},
+			exp: &model.AuthorizedApp{
+				AppPackageName:           "myapp",
+				Platform:                 "android",
+				AllowedRegions:           map[string]struct{}{"US": {}},
+				HealthAuthorityID:        "ha-1",
+				EmbargoSameDayKeys:       true,
+				AllowTravelers:           true,
+				SafetyNetBasicIntegrity:  true,
+				SafetyNetCTSProfileMatch: true,
+			},
+		},
+		{
+			name: "onset_transmission_risk",
+			sql: `
+				WITH ha AS (
+					INSERT INTO HealthAuthority (health_authority_id, onset_transmission_risk)
+					VALUES ($4, '{"-2": 1, "0": 1, "5": 4}')
+				)
+				INSERT INTO AuthorizedApp (app_package_name, platform, allowed_regions, health_authority_id)
+				VALUES ($1, $2, $3, $4)
+			`,
+			args: []interface{}{"myapp", "android", []string{"US"}, "ha-1"},
 			exp: &model.AuthorizedApp{
 				AppPackageName:           "myapp",
-				Platform:                 "ios",
+				Platform:                 "android",
 				AllowedRegions:           map[string]struct{}{"US": {}},
+				HealthAuthorityID:        "ha-1",
+				OnsetTransmissionRisk:    map[int32]int{-2: 1, 0: 1, 5: 4},
+				SafetyNetBasicIntegrity:  true,
 				SafetyNetCTSProfileMatch: true,
+			},
+		},
+		{
+			name: "limits",
+			sql: `
+				INSERT INTO AuthorizedApp (app_package_name, platform, allowed_regions, rate_limit_tokens, rate_limit_interval_seconds, max_keys_per_day)
+				VALUES ($1, $2, $3, $4, $5, $6)
+			`,
+			args: []interface{}{"myapp", "android", []string{"US"}, 10, 600, 2},
+			exp: &model.AuthorizedApp{
+				AppPackageName:           "myapp",
+				Platform:                 "android",
+				AllowedRegions:           map[string]struct{}{"US": {}},
 				SafetyNetBasicIntegrity:  true,
-				DeviceCheckDisabled:      false,
-				DeviceCheckTeamID:        "ABCD1234",
-				DeviceCheckKeyID:         "DEFG5678",
-				DeviceCheckPrivateKey:    p8PrivateKey,
+				SafetyNetCTSProfileMatch: true,
+				RateLimitTokens:          10,
+				RateLimitInterval:        10 * time.Minute,
+				MaxKeysPerDay:            2,
+			},
+		},
+		{
+			name: "grpc_api_key",
+			sql: `
+				INSERT INTO AuthorizedApp (app_package_name, platform, allowed_regions, grpc_api_key_sha256)
+				VALUES ($1, $2, $3, $4)
+			`,
+			args: []interface{}{"myapp", "android", []string{"US"}, "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"},
+			exp: &model.AuthorizedApp{
+				AppPackageName:           "myapp",
+				Platform:                 "android",
+				AllowedRegions:           map[string]struct{}{"US": {}},
+				SafetyNetBasicIntegrity:  true,
+				SafetyNetCTSProfileMatch: true,
+				GRPCAPIKeySHA256:         "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
 			},
 		},
 		{
@@ -252,3 +347,71 @@ func TestGetAuthorizedApp(t *testing.T) {
 		})
 	}
 }
+
+func TestAddListDeleteAuthorizedApp(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer coredb.ResetTestDB(t, testDB)
+	ctx := context.Background()
+	db := NewAuthorizedAppDB(testDB)
+
+	app := &model.AuthorizedApp{
+		AppPackageName:              "myapp",
+		Platform:                    "both",
+		AllowedRegions:              map[string]struct{}{"US": {}, "CA": {}},
+		HealthAuthorityID:           "ha-1",
+		SafetyNetApkDigestSHA256:    []string{"092fcfb"},
+		SafetyNetBasicIntegrity:     true,
+		SafetyNetCTSProfileMatch:    true,
+		SafetyNetPastTime:           time.Hour,
+		DeviceCheckTeamID:           "ABCD1234",
+		DeviceCheckKeyID:            "DEFG5678",
+		DeviceCheckPrivateKeySecret: "private_key",
+		CertificateIssuer:           "ha.example.com",
+		CertificateJWKSURI:          "https://ha.example.com/.well-known/jwks.json",
+		RateLimitTokens:             10,
+		RateLimitInterval:           10 * time.Minute,
+	}
+	if err := db.AddAuthorizedApp(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	other := &model.AuthorizedApp{
+		AppPackageName: "another",
+		Platform:       "android",
+		AllowedRegions: map[string]struct{}{},
+	}
+	if err := db.AddAuthorizedApp(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.ListAuthorizedApps(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*model.AuthorizedApp{other, app}, got); diff != "" {
+		t.Errorf("ListAuthorizedApps mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Updating replaces the settings.
+	app.AllowedRegions = map[string]struct{}{}
+	app.CertificateIssuer, app.CertificateJWKSURI = "", ""
+	app.RateLimitTokens, app.RateLimitInterval = 0, 0
+	if err := db.AddAuthorizedApp(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	got, err = db.ListAuthorizedApps(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*model.AuthorizedApp{other, app}, got); diff != "" {
+		t.Errorf("ListAuthorizedApps after update mismatch (-want, +got):\n%s", diff)
+	}
+
+	if err := db.DeleteAuthorizedApp(ctx, "myapp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteAuthorizedApp(ctx, "myapp"); !errors.Is(err, coredb.ErrNotFound) {
+		t.Errorf("DeleteAuthorizedApp twice: got %v, want %v", err, coredb.ErrNotFound)
+	}
+}
@@ -16,12 +16,15 @@ package authorizedapp
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 
 	authorizedappdb "github.com/google/exposure-notifications-server/internal/authorizedapp/database"
 	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/cache"
 	"github.com/google/exposure-notifications-server/internal/database"
 
 	"github.com/google/exposure-notifications-server/internal/logging"
@@ -37,14 +40,23 @@ type DatabaseProvider struct {
 	database      *database.DB
 	secretManager secrets.SecretManager
 	cacheDuration time.Duration
+	cacheJitter   time.Duration
+
+	// load reads an app from the data source. It returns nil if there is no
+	// such app.
+	load func(ctx context.Context, name string) (*model.AuthorizedApp, error)
+
+	// shared, if set, is the cache the instances of the service share apps
+	// through, between the memory cache and the data source.
+	shared *cache.Shared
 
 	cache     map[string]*cacheItem
 	cacheLock sync.RWMutex
 }
 
 type cacheItem struct {
-	value    *model.AuthorizedApp
-	cachedAt time.Time
+	value     *model.AuthorizedApp
+	expiresAt time.Time
 }
 
 // DatabaseProviderOption is used as input to the database provider.
@@ -63,8 +75,11 @@ func NewDatabaseProvider(ctx context.Context, db *database.DB, config *Config, o
 	provider := &DatabaseProvider{
 		database:      db,
 		cacheDuration: config.CacheDuration,
+		cacheJitter:   config.CacheJitter,
 		cache:         make(map[string]*cacheItem),
+		shared:        cache.NewShared(config.Cache, "authorizedapp"),
 	}
+	provider.load = provider.loadAuthorizedAppFromDatabase
 
 	// Apply options.
 	for _, opt := range opts {
@@ -74,6 +89,45 @@ func NewDatabaseProvider(ctx context.Context, db *database.DB, config *Config, o
 	return provider, nil
 }
 
+// Invalidate drops the cached config for the given app package name, from
+// this instance and the shared cache, so the next AppConfig call reads it
+// again.
+func (p *DatabaseProvider) Invalidate(ctx context.Context, name string) error {
+	p.cacheLock.Lock()
+	delete(p.cache, name)
+	p.cacheLock.Unlock()
+
+	if err := p.shared.Invalidate(ctx, name); err != nil {
+		return fmt.Errorf("authorizedapp: invalidating %v in the shared cache: %w", name, err)
+	}
+	return nil
+}
+
+// InvalidateAll drops every cached app, from this instance and the shared
+// cache, so that they are read again on next use. It is called when the
+// AuthorizedApp or HealthAuthority table changes.
+func (p *DatabaseProvider) InvalidateAll(ctx context.Context) error {
+	p.cacheLock.Lock()
+	p.cache = make(map[string]*cacheItem)
+	p.cacheLock.Unlock()
+
+	if err := p.shared.InvalidateAll(ctx); err != nil {
+		return fmt.Errorf("authorizedapp: invalidating the shared cache: %w", err)
+	}
+	return nil
+}
+
+// expiry returns when an item cached at now expires. A random jitter spreads
+// the refreshes of apps cached at the same time, and of the same app across
+// server instances.
+func (p *DatabaseProvider) expiry(now time.Time) time.Time {
+	expiresAt := now.Add(p.cacheDuration)
+	if p.cacheJitter > 0 {
+		expiresAt = expiresAt.Add(time.Duration(rand.Int63n(int64(p.cacheJitter))))
+	}
+	return expiresAt
+}
+
 // checkCache checks the local cache within a read lock.
 // The bool on return is true if there was a hit (And an error is a valid hit)
 // or false if there was a miss (or expiry) and the data source should be queried again.
@@ -84,7 +138,7 @@ func (p *DatabaseProvider) checkCache(name string) (*model.AuthorizedApp, bool,
 	defer p.cacheLock.RUnlock()
 
 	item, ok := p.cache[name]
-	if ok && time.Since(item.cachedAt) <= p.cacheDuration {
+	if ok && time.Now().Before(item.expiresAt) {
 		if item.value == nil {
 			return nil, true, AppNotFound
 		}
@@ -108,7 +162,7 @@ func (p *DatabaseProvider) AppConfig(ctx context.Context, name string) (*model.A
 	p.cacheLock.Lock()
 	defer p.cacheLock.Unlock()
 	item, ok := p.cache[name]
-	if ok && time.Since(item.cachedAt) <= p.cacheDuration {
+	if ok && time.Now().Before(item.expiresAt) {
 		if item.value == nil {
 			return nil, AppNotFound
 		}
@@ -116,16 +170,24 @@ func (p *DatabaseProvider) AppConfig(ctx context.Context, name string) (*model.A
 	}
 
 	// Load config.
-	config, err := p.loadAuthorizedAppFromDatabase(ctx, name)
+	config, err := p.loadShared(ctx, name)
 	if err != nil {
+		// Keep serving the expired config rather than failing every publish
+		// while the data source is unavailable, and try again after another
+		// cache period.
+		if ok && item.value != nil {
+			logger.Errorf("authorizedapp: refreshing %v failed, using cached config: %v", name, err)
+			item.expiresAt = p.expiry(time.Now())
+			return item.value, nil
+		}
 		return nil, fmt.Errorf("authorizedapp: %w", err)
 	}
 
 	// Cache configs.
 	logger.Infof("authorizedapp: loaded %v, caching for %s", name, p.cacheDuration)
 	p.cache[name] = &cacheItem{
-		value:    config,
-		cachedAt: time.Now(),
+		value:     config,
+		expiresAt: p.expiry(time.Now()),
 	}
 
 	// Handle not found.
@@ -137,6 +199,54 @@ func (p *DatabaseProvider) AppConfig(ctx context.Context, name string) (*model.A
 	return config, nil
 }
 
+// loadShared reads an app from the shared cache, if there is one, or loads it
+// and writes it to the shared cache. Apps are shared without their resolved
+// secrets, which each instance resolves itself.
+func (p *DatabaseProvider) loadShared(ctx context.Context, name string) (*model.AuthorizedApp, error) {
+	if p.shared == nil {
+		return p.load(ctx, name)
+	}
+	logger := logging.FromContext(ctx)
+
+	data, ok, err := p.shared.Get(ctx, name)
+	if err != nil {
+		logger.Warnf("authorizedapp: reading %v from the shared cache: %v", name, err)
+	}
+	if ok {
+		var config *model.AuthorizedApp
+		err := json.Unmarshal(data, &config)
+		if err == nil && config != nil {
+			err = authorizedappdb.ResolveSecrets(ctx, p.secretManager, config)
+		}
+		if err == nil {
+			return config, nil
+		}
+		logger.Warnf("authorizedapp: ignoring %v from the shared cache: %v", name, err)
+	}
+
+	config, err := p.load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := marshalShared(config); err != nil {
+		logger.Warnf("authorizedapp: encoding %v for the shared cache: %v", name, err)
+	} else if err := p.shared.Set(ctx, name, data, p.cacheDuration); err != nil {
+		logger.Warnf("authorizedapp: writing %v to the shared cache: %v", name, err)
+	}
+	return config, nil
+}
+
+// marshalShared encodes config for the shared cache, without its resolved
+// secrets. A nil config, for an app that doesn't exist, is encoded too.
+func marshalShared(config *model.AuthorizedApp) ([]byte, error) {
+	if config == nil {
+		return json.Marshal(config)
+	}
+	shared := *config
+	shared.DeviceCheckPrivateKey = nil
+	return json.Marshal(&shared)
+}
+
 // loadAuthorizedAppFromDatabase is a lower-level private API that actually loads and parses
 // a single AuthorizedApp from the database.
 func (p *DatabaseProvider) loadAuthorizedAppFromDatabase(ctx context.Context, name string) (*model.AuthorizedApp, error) {
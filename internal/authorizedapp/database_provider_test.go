@@ -0,0 +1,149 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authorizedapp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestDatabaseProviderCache(t *testing.T) {
+	ctx := context.Background()
+
+	app := &model.AuthorizedApp{AppPackageName: "myapp"}
+	var loads int
+	var loadErr error
+	p := &DatabaseProvider{
+		cacheDuration: time.Hour,
+		cacheJitter:   time.Minute,
+		cache:         make(map[string]*cacheItem),
+		load: func(_ context.Context, name string) (*model.AuthorizedApp, error) {
+			loads++
+			if loadErr != nil {
+				return nil, loadErr
+			}
+			if name != app.AppPackageName {
+				return nil, nil
+			}
+			return app, nil
+		},
+	}
+
+	// Repeated reads are served from the cache.
+	for i := 0; i < 2; i++ {
+		got, err := p.AppConfig(ctx, "myapp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != app {
+			t.Errorf("AppConfig: got %v, want %v", got, app)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("after cached reads: got %d loads, want 1", loads)
+	}
+	item := p.cache["myapp"]
+	if ttl := time.Until(item.expiresAt); ttl < 59*time.Minute || ttl > 61*time.Minute {
+		t.Errorf("cached for %v, want %v plus up to %v", ttl, time.Hour, time.Minute)
+	}
+
+	// Unknown apps are cached as not found.
+	for i := 0; i < 2; i++ {
+		if _, err := p.AppConfig(ctx, "unknown"); !errors.Is(err, AppNotFound) {
+			t.Errorf("AppConfig of unknown app: got %v, want %v", err, AppNotFound)
+		}
+	}
+	if loads != 2 {
+		t.Errorf("after unknown reads: got %d loads, want 2", loads)
+	}
+
+	// An expired config is served while the data source fails.
+	loadErr = errors.New("database down")
+	item.expiresAt = time.Now().Add(-time.Second)
+	got, err := p.AppConfig(ctx, "myapp")
+	if err != nil {
+		t.Fatalf("AppConfig with failing load: %v", err)
+	}
+	if got != app {
+		t.Errorf("AppConfig with failing load: got %v, want %v", got, app)
+	}
+	if !time.Now().Before(item.expiresAt) {
+		t.Errorf("stale config was not cached again")
+	}
+
+	// Without a cached config, the failure is returned.
+	if err := p.Invalidate(ctx, "myapp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.AppConfig(ctx, "myapp"); !errors.Is(err, loadErr) {
+		t.Errorf("AppConfig after Invalidate: got %v, want %v", err, loadErr)
+	}
+	if loads != 4 {
+		t.Errorf("got %d loads, want 4", loads)
+	}
+}
+
+func TestMarshalShared(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &model.AuthorizedApp{
+		AppPackageName:              "myapp",
+		AllowedRegions:              map[string]struct{}{"US": {}},
+		OnsetTransmissionRisk:       map[int32]int{0: 6, -2: 4},
+		SafetyNetPastTime:           time.Hour,
+		DeviceCheckPrivateKey:       key,
+		DeviceCheckPrivateKeySecret: "devicecheck-key",
+	}
+
+	data, err := marshalShared(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got *model.AuthorizedApp
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	// Resolved secrets aren't shared.
+	if diff := cmp.Diff(app, got, cmpopts.IgnoreFields(model.AuthorizedApp{}, "DeviceCheckPrivateKey")); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if got.DeviceCheckPrivateKey != nil {
+		t.Errorf("private key was shared")
+	}
+	if app.DeviceCheckPrivateKey != key {
+		t.Errorf("private key was removed from the app")
+	}
+
+	// Unknown apps are shared too.
+	data, err = marshalShared(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "null" {
+		t.Errorf("unknown app: got %s, want null", data)
+	}
+}
@@ -39,6 +39,25 @@ type AuthorizedApp struct {
 	// empty, all regions are permitted.
 	AllowedRegions map[string]struct{}
 
+	// HealthAuthorityID identifies the health authority the app belongs to.
+	// Exposures published by the app are scoped to it.
+	HealthAuthorityID string
+
+	// EmbargoSameDayKeys is set by the app's health authority. If true, keys
+	// that are still valid are accepted, but not exported until they expire.
+	EmbargoSameDayKeys bool
+
+	// AllowTravelers is set by the app's health authority. If true, keys of
+	// users who traveled may be written to the regions they visited as well as
+	// to one of the app's regions.
+	AllowTravelers bool
+
+	// OnsetTransmissionRisk is set by the app's health authority. It maps the
+	// days between symptom onset and a key's interval to the transmission
+	// risk of keys published without one. If it is empty, the server's
+	// transform stages decide.
+	OnsetTransmissionRisk map[int32]int
+
 	// SafetyNet configuration.
 	SafetyNetDisabled        bool
 	SafetyNetApkDigestSHA256 []string
@@ -52,6 +71,30 @@ type AuthorizedApp struct {
 	DeviceCheckKeyID      string
 	DeviceCheckTeamID     string
 	DeviceCheckPrivateKey *ecdsa.PrivateKey
+
+	// DeviceCheckPrivateKeySecret is the name of the secret holding
+	// DeviceCheckPrivateKey.
+	DeviceCheckPrivateKeySecret string
+
+	// Health authority verification certificate configuration. If
+	// CertificateIssuer is empty, publish requests don't need a certificate.
+	CertificateIssuer   string
+	CertificateAudience string
+	CertificateJWKSURI  string
+
+	// Rate limit for publish requests from a single client of the app. If
+	// RateLimitTokens is zero, the server default applies.
+	RateLimitTokens   int
+	RateLimitInterval time.Duration
+
+	// MaxKeysPerDay overrides the server's limit on the keys in a publish
+	// that start on the same UTC day. Zero means the server default.
+	MaxKeysPerDay int
+
+	// GRPCAPIKeySHA256 is the hex encoded SHA-256 hash of the API key the app
+	// presents to the gRPC publish API. If it is empty, the app can't use the
+	// gRPC API.
+	GRPCAPIKeySHA256 string
 }
 
 func NewAuthorizedApp() *AuthorizedApp {
@@ -76,6 +119,12 @@ func (c *AuthorizedApp) IsDualPlatform() bool {
 	return c.Platform == bothPlatforms
 }
 
+// RequiresCertificate returns true if publish requests from the app must carry
+// a verification certificate from its health authority.
+func (c *AuthorizedApp) RequiresCertificate() bool {
+	return c.CertificateIssuer != ""
+}
+
 // IsAllowedRegion returns true if the regions list is empty or if the given
 // region is in the list of allowed regions.
 func (c *AuthorizedApp) IsAllowedRegion(s string) bool {
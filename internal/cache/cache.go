@@ -0,0 +1,212 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cache caches configuration that is read on hot paths, such as
+// authorized apps on every publish, in two tiers: the memory of each
+// instance, and optionally Redis, which the instances of a service share.
+//
+// The shared tier sits between the memory tier and the database. An instance
+// that misses its memory reads Redis, and only reads the database if Redis
+// misses too, so the database is read about once per TTL across all
+// instances rather than once per instance. Invalidating an entry drops it
+// from both tiers; entries in the memory of other instances expire after
+// their TTL, or are dropped when those instances see the configuration
+// change, see package runtimeconfig.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/redis"
+)
+
+// getScript reads the entry ARGV[1] of the cache whose generation counter is
+// KEYS[1].
+const getScript = `
+local gen = redis.call('GET', KEYS[1]) or '0'
+return redis.call('GET', KEYS[1] .. ':' .. gen .. ':' .. ARGV[1])
+`
+
+// setScript writes the entry ARGV[1] of the cache whose generation counter is
+// KEYS[1], with the value ARGV[2] for ARGV[3] milliseconds.
+const setScript = `
+local gen = redis.call('GET', KEYS[1]) or '0'
+return redis.call('SET', KEYS[1] .. ':' .. gen .. ':' .. ARGV[1], ARGV[2], 'PX', ARGV[3])
+`
+
+// delScript deletes the entry ARGV[1] of the cache whose generation counter
+// is KEYS[1].
+const delScript = `
+local gen = redis.call('GET', KEYS[1]) or '0'
+return redis.call('DEL', KEYS[1] .. ':' .. gen .. ':' .. ARGV[1])
+`
+
+// Shared is the tier of a cache that the instances of a service share in
+// Redis. Entries are grouped in generations: invalidating every entry starts
+// a new generation, and the entries of old ones expire unread. A nil Shared
+// caches nothing.
+type Shared struct {
+	client *redis.Client
+
+	// name is the generation counter of the cache, and the prefix of its
+	// entries.
+	name string
+}
+
+// NewShared returns the shared tier of the cache name, or nil if Redis isn't
+// configured.
+func NewShared(config *Config, name string) *Shared {
+	if config == nil || config.RedisAddress == "" {
+		return nil
+	}
+	return &Shared{
+		client: redis.New(config.RedisAddress, config.RedisPassword),
+		name:   "cache:" + name,
+	}
+}
+
+// Get returns the value of key, and false if there is none.
+func (s *Shared) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if s == nil {
+		return nil, false, nil
+	}
+	reply, err := s.client.Do(ctx, "EVAL", getScript, "1", s.name, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	return []byte(value), true, nil
+}
+
+// Set sets the value of key for ttl.
+func (s *Shared) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if s == nil {
+		return nil
+	}
+	_, err := s.client.Do(ctx, "EVAL", setScript, "1", s.name, key, string(value), strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// Invalidate drops key.
+func (s *Shared) Invalidate(ctx context.Context, key string) error {
+	if s == nil {
+		return nil
+	}
+	_, err := s.client.Do(ctx, "EVAL", delScript, "1", s.name, key)
+	return err
+}
+
+// InvalidateAll drops every key by starting a new generation.
+func (s *Shared) InvalidateAll(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	_, err := s.client.Do(ctx, "INCR", s.name)
+	return err
+}
+
+// Cache is a two-tier cache of values that are loaded on a miss of both
+// tiers. Errors are not cached.
+type Cache struct {
+	ttl    time.Duration
+	jitter time.Duration
+	shared *Shared
+
+	mu    sync.RWMutex
+	items map[string]*item
+}
+
+type item struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// New creates a Cache whose entries are kept for ttl, plus a random jitter of
+// up to jitter that spreads their refreshes, in memory and in shared, which
+// may be nil.
+func New(ttl, jitter time.Duration, shared *Shared) *Cache {
+	return &Cache{
+		ttl:    ttl,
+		jitter: jitter,
+		shared: shared,
+		items:  make(map[string]*item),
+	}
+}
+
+// Get returns the value of key, calling load if neither tier has it. An
+// unavailable shared tier is logged and skipped.
+func (c *Cache) Get(ctx context.Context, key string, load func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	c.mu.RLock()
+	it, ok := c.items[key]
+	c.mu.RUnlock()
+	if ok && time.Now().Before(it.expiresAt) {
+		return it.value, nil
+	}
+
+	logger := logging.FromContext(ctx)
+	value, ok, err := c.shared.Get(ctx, key)
+	if err != nil {
+		logger.Warnf("cache: reading %v from the shared cache: %v", key, err)
+	}
+	if !ok {
+		if value, err = load(ctx); err != nil {
+			return nil, err
+		}
+		if err := c.shared.Set(ctx, key, value, c.ttl); err != nil {
+			logger.Warnf("cache: writing %v to the shared cache: %v", key, err)
+		}
+	}
+
+	c.mu.Lock()
+	c.items[key] = &item{value: value, expiresAt: c.expiry(time.Now())}
+	c.mu.Unlock()
+	return value, nil
+}
+
+// Invalidate drops key from both tiers.
+func (c *Cache) Invalidate(ctx context.Context, key string) error {
+	c.mu.Lock()
+	delete(c.items, key)
+	c.mu.Unlock()
+	return c.shared.Invalidate(ctx, key)
+}
+
+// InvalidateAll drops every key from both tiers.
+func (c *Cache) InvalidateAll(ctx context.Context) error {
+	c.mu.Lock()
+	c.items = make(map[string]*item)
+	c.mu.Unlock()
+	return c.shared.InvalidateAll(ctx)
+}
+
+// expiry returns when an item cached at now expires in memory.
+func (c *Cache) expiry(now time.Time) time.Time {
+	expiresAt := now.Add(c.ttl)
+	if c.jitter > 0 {
+		expiresAt = expiresAt.Add(time.Duration(rand.Int63n(int64(c.jitter))))
+	}
+	return expiresAt
+}
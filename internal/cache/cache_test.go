@@ -0,0 +1,96 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	c := New(time.Hour, time.Minute, nil)
+
+	var loads int
+	var loadErr error
+	load := func(context.Context) ([]byte, error) {
+		loads++
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return []byte("value"), nil
+	}
+	get := func() string {
+		t.Helper()
+		v, err := c.Get(ctx, "key", load)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(v)
+	}
+
+	if got := get(); got != "value" {
+		t.Errorf("got %q, want value", got)
+	}
+	get()
+	if loads != 1 {
+		t.Errorf("got %d loads, want 1", loads)
+	}
+
+	if err := c.Invalidate(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	get()
+	if err := c.InvalidateAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	get()
+	if loads != 3 {
+		t.Errorf("after invalidation: got %d loads, want 3", loads)
+	}
+
+	// Errors aren't cached.
+	c.InvalidateAll(ctx)
+	loadErr = errors.New("load failed")
+	if _, err := c.Get(ctx, "key", load); !errors.Is(err, loadErr) {
+		t.Errorf("got error %v, want %v", err, loadErr)
+	}
+	loadErr = nil
+	get()
+	if loads != 5 {
+		t.Errorf("after failed load: got %d loads, want 5", loads)
+	}
+}
+
+func TestNewShared(t *testing.T) {
+	if s := NewShared(&Config{}, "test"); s != nil {
+		t.Errorf("without a Redis address: got %v, want nil", s)
+	}
+	if s := NewShared(nil, "test"); s != nil {
+		t.Errorf("without a config: got %v, want nil", s)
+	}
+
+	// A nil Shared caches nothing.
+	var s *Shared
+	ctx := context.Background()
+	if err := s.Set(ctx, "key", []byte("value"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := s.Get(ctx, "key"); ok || err != nil {
+		t.Errorf("Get: got %v, %v, want a miss", ok, err)
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+// Config configures the shared tier of configuration caches.
+type Config struct {
+	// RedisAddress, if set, is the host:port of a Redis server that the
+	// instances of a service share cached configuration through.
+	RedisAddress  string `envconfig:"CACHE_REDIS_ADDRESS"`
+	RedisPassword string `envconfig:"CACHE_REDIS_PASSWORD"`
+}
@@ -21,8 +21,13 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/audit"
 	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/export"
 	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/revision"
+	"github.com/google/exposure-notifications-server/internal/scheduler"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
 	"github.com/google/exposure-notifications-server/internal/storage"
 )
@@ -33,35 +38,80 @@ const (
 
 // NewExposureHandler creates a http.Handler for deleting exposure keys
 // from the database.
-func NewExposureHandler(config *Config, env *serverenv.ServerEnv) (http.Handler, error) {
+func NewExposureHandler(config *Config, env *serverenv.ServerEnv) (*ExposureHandler, error) {
 	if env.Database() == nil {
 		return nil, fmt.Errorf("missing database in server environment")
 	}
+	if config.TTL < minTTL {
+		return nil, fmt.Errorf("CLEANUP_TTL %v is less than the minimum of %v", config.TTL, minTTL)
+	}
 
-	return &exposureCleanupHandler{
+	return &ExposureHandler{
 		config:   config,
 		env:      env,
 		database: env.Database(),
+		running:  make(chan struct{}, 1),
 	}, nil
 }
 
-type exposureCleanupHandler struct {
+// ExposureHandler deletes expired exposures, and maintains the exposure
+// keys, when it is called or, see Jobs, on a schedule of its own.
+type ExposureHandler struct {
 	config   *Config
 	env      *serverenv.ServerEnv
 	database *database.DB
+
+	// running holds a token while a cleanup runs, so runs don't overlap.
+	running chan struct{}
+}
+
+func (h *ExposureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := isDryRun(r, h.config.DryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report, err := h.cleanup(r.Context(), dryRun)
+	writeReport(r.Context(), w, report, err)
+}
+
+// Jobs returns the scheduler job that cleans up exposures every
+// CLEANUP_INTERVAL, for deployments without a scheduler to call the handler,
+// or no job if CLEANUP_INTERVAL is zero.
+func (h *ExposureHandler) Jobs() []*scheduler.Job {
+	if h.config.Interval <= 0 {
+		return nil
+	}
+	return []*scheduler.Job{{
+		Name:     "cleanup-exposure",
+		Interval: h.config.Interval,
+		Run: func(ctx context.Context) error {
+			_, err := h.cleanup(ctx, h.config.DryRun)
+			return err
+		},
+	}}
 }
 
-func (h *exposureCleanupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+// cleanup runs one cleanup, unless one is already running, and reports what
+// it deleted. In a dry run, it only counts what it would delete. Errors have
+// already been logged.
+func (h *ExposureHandler) cleanup(ctx context.Context, dryRun bool) (*Report, error) {
+	select {
+	case h.running <- struct{}{}:
+		defer func() { <-h.running }()
+	default:
+		return nil, errRunning
+	}
+
+	ctx = audit.WithActor(ctx, audit.SystemActor("cleanup-exposure"))
 	logger := logging.FromContext(ctx)
 	metrics := h.env.MetricsExporter(ctx)
 
-	cutoff, err := cutoffDate(h.config.TTL)
+	cutoff, err := cutoffDate(h.env.RuntimeConfig().Duration("CLEANUP_TTL", h.config.TTL))
 	if err != nil {
 		logger.Errorf("error processing cutoff time: %v", err)
 		metrics.WriteInt("cleanup-exposures-setup-failed", true, 1)
-		http.Error(w, "internal processing error", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	logger.Infof("Starting cleanup for records older than %v", cutoff.UTC())
 	metrics.WriteInt64("cleanup-exposures-before", false, cutoff.Unix())
@@ -70,22 +120,295 @@ func (h *exposureCleanupHandler) ServeHTTP(w http.ResponseWriter, r *http.Reques
 	timeoutCtx, cancel := context.WithTimeout(ctx, h.config.Timeout)
 	defer cancel()
 
-	count, err := h.database.DeleteExposures(timeoutCtx, cutoff)
+	if dryRun {
+		report, err := h.count(timeoutCtx, cutoff)
+		if err != nil {
+			logger.Errorf("Failed counting expired records: %v", err)
+			metrics.WriteInt("cleanup-exposures-dry-run-failed", true, 1)
+			return nil, err
+		}
+		report.log(ctx, metrics, "cleanup-exposures")
+		return report, nil
+	}
+	report := newReport(false, cutoff)
+
+	if h.database.ExposureKeyEncryptionEnabled() {
+		if err := h.rotateEncryptionKeys(timeoutCtx); err != nil {
+			logger.Errorf("Failed rotating exposure key encryption: %v", err)
+			metrics.WriteInt("cleanup-exposures-key-rotation-failed", true, 1)
+			return nil, err
+		}
+	}
+
+	if h.config.RevisionToken.Enabled() {
+		if err := h.rotateRevisionKeys(timeoutCtx, cutoff); err != nil {
+			logger.Errorf("Failed rotating revision token keys: %v", err)
+			metrics.WriteInt("cleanup-revision-key-rotation-failed", true, 1)
+			return nil, err
+		}
+	}
+
+	idempotent, err := h.database.DeleteIdempotencyRecords(timeoutCtx, time.Now().Add(-h.config.IdempotencyKeyTTL))
+	if err != nil {
+		logger.Errorf("Failed deleting idempotency records: %v", err)
+		metrics.WriteInt("cleanup-idempotency-delete-failed", true, 1)
+		return nil, err
+	}
+	metrics.WriteInt64("cleanup-idempotency-deleted", true, idempotent)
+	recordDeletions("idempotency_keys", idempotent)
+	report.Rows["PublishIdempotency"] = idempotent
+
+	certificates, err := h.database.DeleteExpiredCertificateUses(timeoutCtx, time.Now())
+	if err != nil {
+		logger.Errorf("Failed deleting verification certificate uses: %v", err)
+		metrics.WriteInt("cleanup-certificate-uses-delete-failed", true, 1)
+		return nil, err
+	}
+	metrics.WriteInt64("cleanup-certificate-uses-deleted", true, certificates)
+	recordDeletions("certificate_uses", certificates)
+	report.Rows["VerificationCertificateUse"] = certificates
+
+	syncs, downloads, err := h.database.DeleteFederationInSyncsBefore(timeoutCtx, time.Now().Add(-h.config.FederationSyncTTL))
+	if err != nil {
+		logger.Errorf("Failed deleting federation sync records: %v", err)
+		metrics.WriteInt("cleanup-federation-syncs-delete-failed", true, 1)
+		return nil, err
+	}
+	metrics.WriteInt64("cleanup-federation-syncs-deleted", true, syncs)
+	recordDeletions("federation_syncs", syncs)
+	recordDeletions("efgs_downloads", downloads)
+	report.Rows["FederationInSync"] = syncs
+	report.Rows["EFGSDownload"] = downloads
+
+	if h.config.Tombstone {
+		tombstoned, purged, err := h.tombstone(timeoutCtx, cutoff)
+		if err != nil {
+			return nil, err
+		}
+		report.Tombstoned = tombstoned
+		report.Rows["Exposure"] = purged
+		return report, nil
+	}
+
+	count, err := h.deleteExposures(timeoutCtx, cutoff)
 	if err != nil {
 		logger.Errorf("Failed deleting exposures: %v", err)
 		metrics.WriteInt("cleanup-exposures-delete-failed", true, 1)
-		http.Error(w, "internal processing error", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
 	metrics.WriteInt64("cleanup-exposures-deleted", true, count)
+	audit.Record(ctx, h.database, audit.ActionDelete, audit.ResourceExposures, "",
+		map[string]interface{}{"count": count, "before": cutoff.UTC()})
 	logger.Infof("cleanup run complete, deleted %v records.", count)
-	w.WriteHeader(http.StatusOK)
+	report.Rows["Exposure"] = count
+	return report, nil
+}
+
+// count reports the records a cleanup with the given cutoff would delete. In
+// tombstone mode, Exposure counts the exposures that would be purged, and
+// exposures that would be tombstoned are counted separately.
+func (h *ExposureHandler) count(ctx context.Context, cutoff time.Time) (*Report, error) {
+	report := newReport(true, cutoff)
+	var err error
+
+	if report.Rows["PublishIdempotency"], err = h.database.CountIdempotencyRecordsBefore(ctx, time.Now().Add(-h.config.IdempotencyKeyTTL)); err != nil {
+		return nil, err
+	}
+	if report.Rows["VerificationCertificateUse"], err = h.database.CountExpiredCertificateUses(ctx, time.Now()); err != nil {
+		return nil, err
+	}
+	syncs, downloads, err := h.database.CountFederationInSyncsBefore(ctx, time.Now().Add(-h.config.FederationSyncTTL))
+	if err != nil {
+		return nil, err
+	}
+	report.Rows["FederationInSync"] = syncs
+	report.Rows["EFGSDownload"] = downloads
+
+	for _, db := range h.database.Residencies() {
+		if !h.config.Tombstone {
+			n, err := db.CountExposuresBefore(ctx, cutoff)
+			if err != nil {
+				return nil, err
+			}
+			report.Rows["Exposure"] += n
+			continue
+		}
+		tombstoned, err := db.CountExposuresToTombstone(ctx, cutoff)
+		if err != nil {
+			return nil, err
+		}
+		purged, err := db.CountExposuresToPurge(ctx, time.Now().Add(-h.config.PurgeAfter))
+		if err != nil {
+			return nil, err
+		}
+		report.Tombstoned += tombstoned
+		report.Rows["Exposure"] += purged
+	}
+	return report, nil
+}
+
+// deleteExposures deletes the exposures created before cutoff in batches of
+// CLEANUP_DELETE_BATCH_SIZE, pausing for CLEANUP_DELETE_BATCH_PAUSE between
+// batches, so no transaction holds locks on the Exposure table for long. With
+// a batch size of zero, it deletes them all at once. Exposures are deleted
+// from every residency database. It returns the number of exposures deleted,
+// including those deleted before a failure.
+func (h *ExposureHandler) deleteExposures(ctx context.Context, cutoff time.Time) (int64, error) {
+	var total int64
+	for _, db := range h.database.Residencies() {
+		count, err := h.deleteExposuresFrom(ctx, db, cutoff)
+		total += count
+		if err != nil {
+			return total, fmt.Errorf("%v database: %w", db.ResidencyName(), err)
+		}
+	}
+	return total, nil
+}
+
+// deleteExposuresFrom deletes the exposures of db created before cutoff, see
+// deleteExposures.
+func (h *ExposureHandler) deleteExposuresFrom(ctx context.Context, db *database.DB, cutoff time.Time) (int64, error) {
+	metrics := h.env.MetricsExporter(ctx)
+
+	if h.config.DeleteBatchSize <= 0 {
+		count, err := db.DeleteExposures(ctx, cutoff)
+		recordDeletions("exposures", count)
+		return count, err
+	}
+
+	var total int64
+	for {
+		count, err := db.DeleteExposuresBatch(ctx, cutoff, h.config.DeleteBatchSize)
+		if err != nil {
+			return total, err
+		}
+		total += count
+		recordDeletions("exposures", count)
+		metrics.WriteInt("cleanup-exposures-delete-batches", true, 1)
+		if count < int64(h.config.DeleteBatchSize) {
+			return total, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(h.config.DeleteBatchPause):
+		}
+	}
+}
+
+// rotateEncryptionKeys rotates the data key that encrypts exposure keys once
+// it is older than the rotation period, re-encrypts a batch of exposures that
+// use older data keys, and deletes data keys that are no longer used. Each
+// residency database has data keys of its own.
+func (h *ExposureHandler) rotateEncryptionKeys(ctx context.Context) error {
+	for _, db := range h.database.Residencies() {
+		if err := h.rotateEncryptionKeysOf(ctx, db); err != nil {
+			return fmt.Errorf("%v database: %w", db.ResidencyName(), err)
+		}
+	}
+	return nil
+}
+
+func (h *ExposureHandler) rotateEncryptionKeysOf(ctx context.Context, db *database.DB) error {
+	logger := logging.FromContext(ctx)
+	metrics := h.env.MetricsExporter(ctx)
+
+	createdAt, err := db.ActiveExposureKeyEncryptionCreatedAt(ctx)
+	if err != nil {
+		return err
+	}
+	if h.config.KeyRotationPeriod > 0 && time.Since(createdAt) > h.config.KeyRotationPeriod {
+		if err := db.RotateExposureKeyEncryption(ctx); err != nil {
+			return err
+		}
+		logger.Infof("Rotated exposure key encryption key created at %v in the %v database", createdAt, db.ResidencyName())
+		metrics.WriteInt("cleanup-exposures-key-rotated", true, 1)
+	}
+
+	reencrypted, err := db.ReencryptExposures(ctx, h.config.ReencryptBatchSize)
+	if err != nil {
+		return err
+	}
+	metrics.WriteInt("cleanup-exposures-reencrypted", true, reencrypted)
+
+	// Servers reload data keys every few minutes, after which none should
+	// still be encrypting with a retired key.
+	deleted, err := db.DeleteRetiredExposureKeyEncryptionKeys(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	metrics.WriteInt64("cleanup-exposures-keys-deleted", true, deleted)
+	return nil
+}
+
+// rotateRevisionKeys rotates the key that encrypts revision tokens once it is
+// older than the rotation period, and deletes keys retired before cutoff. By
+// then every exposure a token issued with them could revise has expired.
+func (h *ExposureHandler) rotateRevisionKeys(ctx context.Context, cutoff time.Time) error {
+	logger := logging.FromContext(ctx)
+	metrics := h.env.MetricsExporter(ctx)
+
+	wrapper, ok := h.env.KeyManager().(database.KeyWrapper)
+	if !ok {
+		return fmt.Errorf("key manager %T cannot wrap revision keys", h.env.KeyManager())
+	}
+	tm, err := revision.New(ctx, h.database, wrapper, h.config.RevisionToken)
+	if err != nil {
+		return err
+	}
+
+	rotated, deleted, err := tm.RotateKeys(ctx, h.config.RevisionKeyRotationPeriod, cutoff)
+	if err != nil {
+		return err
+	}
+	if rotated {
+		logger.Infof("Rotated revision token key")
+		metrics.WriteInt("cleanup-revision-key-rotated", true, 1)
+	}
+	metrics.WriteInt64("cleanup-revision-keys-deleted", true, deleted)
+	return nil
+}
+
+// tombstone marks exposures older than cutoff as deleted and purges exposures
+// that have been tombstoned for longer than the configured purge period. It
+// returns the numbers of exposures tombstoned and purged.
+func (h *ExposureHandler) tombstone(ctx context.Context, cutoff time.Time) (int64, int64, error) {
+	logger := logging.FromContext(ctx)
+	metrics := h.env.MetricsExporter(ctx)
+
+	var count, purged int64
+	for _, db := range h.database.Residencies() {
+		n, err := db.TombstoneExposures(ctx, cutoff)
+		if err != nil {
+			logger.Errorf("Failed tombstoning exposures in the %v database: %v", db.ResidencyName(), err)
+			metrics.WriteInt("cleanup-exposures-tombstone-failed", true, 1)
+			return 0, 0, err
+		}
+		metrics.WriteInt64("cleanup-exposures-tombstoned", true, n)
+		count += n
+
+		n, err = db.PurgeExposures(ctx, time.Now().Add(-h.config.PurgeAfter))
+		if err != nil {
+			logger.Errorf("Failed purging exposures in the %v database: %v", db.ResidencyName(), err)
+			metrics.WriteInt("cleanup-exposures-purge-failed", true, 1)
+			return 0, 0, err
+		}
+		metrics.WriteInt64("cleanup-exposures-purged", true, n)
+		recordDeletions("exposures", n)
+		purged += n
+	}
+	audit.Record(ctx, h.database, audit.ActionDelete, audit.ResourceExposures, "",
+		map[string]interface{}{"tombstoned": count, "purged": purged, "before": cutoff.UTC()})
+
+	logger.Infof("cleanup run complete, tombstoned %v records, purged %v records.", count, purged)
+	return count, purged, nil
 }
 
 // NewExportHandler creates a http.Handler that manages deletion of
 // old export files that are no longer needed by clients for download.
-func NewExportHandler(config *Config, env *serverenv.ServerEnv) (http.Handler, error) {
+func NewExportHandler(config *Config, env *serverenv.ServerEnv) (*ExportHandler, error) {
 	if env.Database() == nil {
 		return nil, fmt.Errorf("missing database in server environment")
 	}
@@ -93,7 +416,7 @@ func NewExportHandler(config *Config, env *serverenv.ServerEnv) (http.Handler, e
 		return nil, fmt.Errorf("missing blobstore in server environment")
 	}
 
-	return &exportCleanupHandler{
+	return &ExportHandler{
 		config:    config,
 		env:       env,
 		database:  env.Database(),
@@ -101,24 +424,54 @@ func NewExportHandler(config *Config, env *serverenv.ServerEnv) (http.Handler, e
 	}, nil
 }
 
-type exportCleanupHandler struct {
+// ExportHandler deletes expired export files and batches when it is called
+// or, see Jobs, on a schedule of its own.
+type ExportHandler struct {
 	config    *Config
 	env       *serverenv.ServerEnv
 	database  *database.DB
 	blobstore storage.Blobstore
 }
 
-func (h *exportCleanupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+func (h *ExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := isDryRun(r, h.config.DryRun || h.config.ExportDryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report, err := h.cleanup(r.Context(), dryRun)
+	writeReport(r.Context(), w, report, err)
+}
+
+// Jobs returns the scheduler job that cleans up exports every
+// CLEANUP_EXPORT_INTERVAL, or no job if CLEANUP_EXPORT_INTERVAL is zero.
+func (h *ExportHandler) Jobs() []*scheduler.Job {
+	if h.config.ExportInterval <= 0 {
+		return nil
+	}
+	return []*scheduler.Job{{
+		Name:     "cleanup-export",
+		Interval: h.config.ExportInterval,
+		Run: func(ctx context.Context) error {
+			_, err := h.cleanup(ctx, h.config.DryRun || h.config.ExportDryRun)
+			return err
+		},
+	}}
+}
+
+// cleanup deletes expired export files and batches, and reports what it
+// deleted. In a dry run, it only counts what it would delete. Errors have
+// already been logged.
+func (h *ExportHandler) cleanup(ctx context.Context, dryRun bool) (*Report, error) {
+	ctx = audit.WithActor(ctx, audit.SystemActor("cleanup-export"))
 	logger := logging.FromContext(ctx)
 	metrics := h.env.MetricsExporter(ctx)
 
-	cutoff, err := cutoffDate(h.config.TTL)
+	cutoff, err := cutoffDate(h.env.RuntimeConfig().Duration("CLEANUP_TTL", h.config.TTL))
 	if err != nil {
 		logger.Errorf("error calculating cutoff time: %v", err)
 		metrics.WriteInt("cleanup-exports-setup-failed", true, 1)
-		http.Error(w, "internal processing error", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	logger.Infof("Starting cleanup for export files older than %v", cutoff.UTC())
 	metrics.WriteInt64("cleanup-exports-before", false, cutoff.Unix())
@@ -127,17 +480,155 @@ func (h *exportCleanupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	timeoutCtx, cancel := context.WithTimeout(ctx, h.config.Timeout)
 	defer cancel()
 
-	count, err := h.database.DeleteFilesBefore(timeoutCtx, cutoff, h.blobstore)
+	files, err := h.database.LookupExpiredExportFiles(timeoutCtx, cutoff)
 	if err != nil {
-		logger.Errorf("Failed deleting export files: %v", err)
+		logger.Errorf("Failed looking up expired export files: %v", err)
 		metrics.WriteInt("cleanup-exports-delete-failed", true, 1)
-		http.Error(w, "internal processing error", http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+
+	if dryRun {
+		report := newReport(true, cutoff)
+		for _, f := range files {
+			logger.Infof("Dry run: would delete export file %s/%s (%d bytes)", f.BucketName, f.Filename, f.SizeBytes)
+			report.Bytes += f.SizeBytes
+		}
+		report.Files = len(files)
+		// Only batches whose files are already deleted are counted; the
+		// batches of these files are deleted by a later run.
+		report.Rows["ExportBatch"], err = h.database.CountExportBatchesBefore(timeoutCtx, time.Now().Add(-h.config.ExportBatchTTL))
+		if err != nil {
+			logger.Errorf("Failed counting expired export batches: %v", err)
+			metrics.WriteInt("cleanup-exports-dry-run-failed", true, 1)
+			return nil, err
+		}
+		metrics.WriteInt("cleanup-exports-dry-run-files", true, report.Files)
+		metrics.WriteInt64("cleanup-exports-dry-run-bytes", true, report.Bytes)
+		report.log(ctx, metrics, "cleanup-exports")
+		return report, nil
+	}
+
+	var count int
+	var bytes int64
+	for _, configFiles := range groupByConfig(files) {
+		n, b, err := h.deleteFiles(timeoutCtx, configFiles)
+		count += n
+		bytes += b
+		recordDeletions("exports", int64(n))
+		if n > 0 {
+			audit.Record(ctx, h.database, audit.ActionDelete, audit.ResourceExportFiles,
+				fmt.Sprint(configFiles[0].ConfigID), map[string]interface{}{"files": n, "bytes": b})
+		}
+		if err != nil {
+			metrics.WriteInt("cleanup-exports-deleted", true, count)
+			metrics.WriteInt64("cleanup-exports-deleted-bytes", true, bytes)
+			logger.Errorf("Failed deleting export files of config %d: %v", configFiles[0].ConfigID, err)
+			metrics.WriteInt("cleanup-exports-delete-failed", true, 1)
+			return nil, err
+		}
 	}
 
 	metrics.WriteInt("cleanup-exports-deleted", true, count)
-	logger.Infof("cleanup run complete, deleted %v files.", count)
-	w.WriteHeader(http.StatusOK)
+	metrics.WriteInt64("cleanup-exports-deleted-bytes", true, bytes)
+
+	batches, err := h.database.DeleteExportBatchesBefore(timeoutCtx, time.Now().Add(-h.config.ExportBatchTTL))
+	if err != nil {
+		logger.Errorf("Failed deleting export batches: %v", err)
+		metrics.WriteInt("cleanup-export-batches-delete-failed", true, 1)
+		return nil, err
+	}
+	metrics.WriteInt64("cleanup-export-batches-deleted", true, batches)
+	recordDeletions("export_batches", batches)
+
+	logger.Infof("cleanup run complete, deleted %v files (%v bytes) and %v batches.", count, bytes, batches)
+	report := newReport(false, cutoff)
+	report.Files, report.Bytes = count, bytes
+	report.Rows["ExportBatch"] = batches
+	return report, nil
+}
+
+// recordDeletions counts n deleted records of kind in the Prometheus metrics.
+func recordDeletions(kind string, n int64) {
+	metrics.CleanupDeletions.WithLabelValues(kind).Add(float64(n))
+}
+
+// deleteFiles deletes the expired files of one export config. It holds the
+// lock on the config's index, and rewrites the index without the expired files
+// before deleting any of them, so clients never see a reference to a deleted
+// file. It returns the number and total size of the deleted files.
+func (h *ExportHandler) deleteFiles(ctx context.Context, files []*database.ExpiredExportFile) (int, int64, error) {
+	logger := logging.FromContext(ctx)
+	configID := files[0].ConfigID
+
+	var count int
+	var bytes int64
+	err := export.WithIndexLock(ctx, h.database, configID, h.config.Timeout, func() error {
+		entries, err := h.database.LookupExportIndexEntries(ctx, configID)
+		if err != nil {
+			return fmt.Errorf("looking up index entries: %w", err)
+		}
+		remaining := withoutExpired(entries, files)
+
+		type location struct{ bucket, root string }
+		written := make(map[location]struct{})
+		for _, f := range files {
+			loc := location{f.BucketName, f.FilenameRoot}
+			if _, ok := written[loc]; ok {
+				continue
+			}
+			indexName, err := export.WriteIndex(ctx, h.blobstore, loc.bucket, loc.root, remaining)
+			if err != nil {
+				return fmt.Errorf("rewriting index: %w", err)
+			}
+			logger.Infof("Wrote index file %q with %d entries", indexName, len(remaining))
+			written[loc] = struct{}{}
+		}
+
+		for _, f := range files {
+			blobCtx, cancel := context.WithTimeout(ctx, 50*time.Second)
+			err := h.blobstore.DeleteObject(blobCtx, f.BucketName, f.Filename)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("delete object: %w", err)
+			}
+			if err := h.database.MarkExportFileDeleted(ctx, f.BatchID, f.Filename); err != nil {
+				return fmt.Errorf("marking %s deleted: %w", f.Filename, err)
+			}
+			logger.Infof("Deleted filename %s", f.Filename)
+			count++
+			bytes += f.SizeBytes
+		}
+		return nil
+	})
+	return count, bytes, err
+}
+
+// groupByConfig splits files, which are ordered by config, into the files of
+// each config.
+func groupByConfig(files []*database.ExpiredExportFile) [][]*database.ExpiredExportFile {
+	var groups [][]*database.ExpiredExportFile
+	for i, f := range files {
+		if i == 0 || f.ConfigID != files[i-1].ConfigID {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], f)
+	}
+	return groups
+}
+
+// withoutExpired returns the index entries that are not expired.
+func withoutExpired(entries []*database.ExportIndexEntry, expired []*database.ExpiredExportFile) []*database.ExportIndexEntry {
+	skip := make(map[string]struct{}, len(expired))
+	for _, f := range expired {
+		skip[f.Filename] = struct{}{}
+	}
+	remaining := make([]*database.ExportIndexEntry, 0, len(entries))
+	for _, e := range entries {
+		if _, ok := skip[e.Filename]; !ok {
+			remaining = append(remaining, e)
+		}
+	}
+	return remaining
 }
 
 func cutoffDate(d time.Duration) (time.Time, error) {
@@ -17,6 +17,9 @@ package cleanup
 import (
 	"testing"
 	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestCutoffDate(t *testing.T) {
@@ -48,3 +51,45 @@ func TestCutoffDate(t *testing.T) {
 		}
 	}
 }
+
+func TestGroupByConfig(t *testing.T) {
+	files := []*database.ExpiredExportFile{
+		{ConfigID: 1, Filename: "a"},
+		{ConfigID: 1, Filename: "b"},
+		{ConfigID: 2, Filename: "c"},
+		{ConfigID: 3, Filename: "d"},
+		{ConfigID: 3, Filename: "e"},
+	}
+	var got [][]string
+	for _, group := range groupByConfig(files) {
+		var names []string
+		for _, f := range group {
+			names = append(names, f.Filename)
+		}
+		got = append(got, names)
+	}
+	want := [][]string{{"a", "b"}, {"c"}, {"d", "e"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	if got := groupByConfig(nil); len(got) != 0 {
+		t.Errorf("groupByConfig(nil) = %v, want no groups", got)
+	}
+}
+
+func TestWithoutExpired(t *testing.T) {
+	entries := []*database.ExportIndexEntry{
+		{Filename: "root/1.zip"},
+		{Filename: "root/2.zip"},
+		{Filename: "root/3.zip"},
+	}
+	expired := []*database.ExpiredExportFile{
+		{Filename: "root/1.zip"},
+		{Filename: "root/2.zip"},
+	}
+	got := withoutExpired(entries, expired)
+	if diff := cmp.Diff(entries[2:], got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
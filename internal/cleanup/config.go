@@ -18,12 +18,16 @@ import (
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/revision"
+	"github.com/google/exposure-notifications-server/internal/scheduler"
 	"github.com/google/exposure-notifications-server/internal/setup"
+	"github.com/google/exposure-notifications-server/internal/storage"
 )
 
 // Compile-time check to assert this config matches requirements.
 var _ setup.BlobStorageConfigProvider = (*Config)(nil)
 var _ setup.DBConfigProvider = (*Config)(nil)
+var _ setup.KeyManagerProvider = (*Config)(nil)
 
 // Config represents the configuration and associated environment variables for
 // the cleanup components.
@@ -32,6 +36,63 @@ type Config struct {
 	Timeout  time.Duration `envconfig:"CLEANUP_TIMEOUT" default:"10m"`
 	TTL      time.Duration `envconfig:"CLEANUP_TTL" default:"336h"`
 	Database *database.Config
+	Storage  *storage.Config
+
+	// Interval and ExportInterval, if not zero, make exposure and export
+	// cleanup run on their own every interval, in addition to when they are
+	// called. Scheduler configures how instances share the runs.
+	Interval       time.Duration `envconfig:"CLEANUP_INTERVAL" default:"0"`
+	ExportInterval time.Duration `envconfig:"CLEANUP_EXPORT_INTERVAL" default:"0"`
+	Scheduler      *scheduler.Config
+
+	// DeleteBatchSize is the most exposures deleted in one transaction, and
+	// DeleteBatchPause the pause between batches, which lets other writers
+	// take the locks the delete held. A batch size of zero deletes every
+	// expired exposure in one transaction.
+	DeleteBatchSize  int           `envconfig:"CLEANUP_DELETE_BATCH_SIZE" default:"10000"`
+	DeleteBatchPause time.Duration `envconfig:"CLEANUP_DELETE_BATCH_PAUSE" default:"500ms"`
+
+	// Tombstone marks expired exposures as deleted rather than deleting them,
+	// so revocation exports can be generated. Tombstoned exposures are purged
+	// once they have been tombstoned for PurgeAfter.
+	Tombstone  bool          `envconfig:"CLEANUP_TOMBSTONE" default:"false"`
+	PurgeAfter time.Duration `envconfig:"CLEANUP_PURGE_AFTER" default:"72h"`
+
+	// KeyRotationPeriod is how often the data key that encrypts exposure keys
+	// is rotated, if exposure key encryption is enabled. Each run re-encrypts
+	// up to ReencryptBatchSize exposures that use an older data key.
+	KeyRotationPeriod  time.Duration `envconfig:"CLEANUP_KEY_ROTATION_PERIOD" default:"720h"`
+	ReencryptBatchSize int           `envconfig:"CLEANUP_REENCRYPT_BATCH_SIZE" default:"10000"`
+
+	// RevisionKeyRotationPeriod is how often the key that encrypts revision
+	// tokens is rotated, if revision tokens are encrypted. Retired keys are
+	// deleted once they are older than TTL, as no exposure they could revise
+	// remains.
+	RevisionKeyRotationPeriod time.Duration `envconfig:"CLEANUP_REVISION_KEY_ROTATION_PERIOD" default:"24h"`
+	RevisionToken             *revision.Config
+
+	// IdempotencyKeyTTL is how long the responses to publish requests with an
+	// idempotency key are kept. It should match the publish server's
+	// IDEMPOTENCY_KEY_TTL.
+	IdempotencyKeyTTL time.Duration `envconfig:"IDEMPOTENCY_KEY_TTL" default:"24h"`
+
+	// ExportBatchTTL is how long the rows of an export batch, its files and
+	// its stats are kept after the batch ends, once its files have been
+	// deleted from the blobstore.
+	ExportBatchTTL time.Duration `envconfig:"CLEANUP_EXPORT_BATCH_TTL" default:"720h"`
+
+	// FederationSyncTTL is how long the records of completed federation syncs
+	// and EU gateway downloads are kept.
+	FederationSyncTTL time.Duration `envconfig:"CLEANUP_FEDERATION_SYNC_TTL" default:"720h"`
+
+	// DryRun makes both cleanups count and log what they would delete,
+	// without deleting anything, unless a request sets the dry-run query
+	// parameter to false.
+	DryRun bool `envconfig:"CLEANUP_DRY_RUN" default:"false"`
+
+	// ExportDryRun makes export cleanup log and count the export files it
+	// would delete, without deleting them or rewriting any index file.
+	ExportDryRun bool `envconfig:"CLEANUP_EXPORT_DRY_RUN" default:"false"`
 }
 
 // DB return the databsae configuration.
@@ -39,7 +100,13 @@ func (c *Config) DB() *database.Config {
 	return c.Database
 }
 
-// BlobStorage returns the BlobStorage configuration.
-func (c *Config) BlobStorage() bool {
-	return true
+// KeyManager returns true if the key manager is needed to rotate revision
+// token keys.
+func (c *Config) KeyManager() bool {
+	return c.RevisionToken.Enabled()
+}
+
+// BlobStorageConfig returns the BlobStorage configuration.
+func (c *Config) BlobStorageConfig() *storage.Config {
+	return c.Storage
 }
@@ -0,0 +1,120 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cleanup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+)
+
+// dryRunParam is the query parameter that selects a dry run.
+const dryRunParam = "dry-run"
+
+// errRunning is returned when a cleanup is asked to start while another one
+// is running.
+var errRunning = errors.New("cleanup already running")
+
+// Report lists the records a cleanup deleted or, in a dry run, would delete.
+// It is the response to a successful cleanup request.
+type Report struct {
+	DryRun bool `json:"dryRun"`
+	// Before is the cutoff time of exposures and export files.
+	Before time.Time `json:"before"`
+	// Rows maps table names to the number of rows deleted.
+	Rows map[string]int64 `json:"rows"`
+	// Tombstoned is the number of exposures tombstoned, in tombstone mode.
+	Tombstoned int64 `json:"tombstoned,omitempty"`
+	// Files and Bytes are the number and size of the export files deleted
+	// from the blobstore.
+	Files int   `json:"files,omitempty"`
+	Bytes int64 `json:"bytes,omitempty"`
+}
+
+func newReport(dryRun bool, before time.Time) *Report {
+	return &Report{
+		DryRun: dryRun,
+		Before: before.UTC(),
+		Rows:   make(map[string]int64),
+	}
+}
+
+// log logs what a dry run would delete, and writes the total number of rows
+// as the metric prefix-dry-run-rows.
+func (r *Report) log(ctx context.Context, exporter metrics.Exporter, prefix string) {
+	logger := logging.FromContext(ctx)
+
+	tables := make([]string, 0, len(r.Rows))
+	for table := range r.Rows {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var total int64
+	for _, table := range tables {
+		logger.Infof("Dry run: would delete %d rows of %s", r.Rows[table], table)
+		total += r.Rows[table]
+	}
+	if r.Tombstoned > 0 {
+		logger.Infof("Dry run: would tombstone %d exposures", r.Tombstoned)
+	}
+	logger.Infow("cleanup dry run complete",
+		"before", r.Before,
+		"rows", r.Rows,
+		"tombstoned", r.Tombstoned,
+		"files", r.Files,
+		"bytes", r.Bytes)
+	exporter.WriteInt64(prefix+"-dry-run-rows", true, total)
+}
+
+// isDryRun reports whether dry runs are configured, or r asks for a dry run
+// with the dry-run query parameter. The parameter can't turn off configured
+// dry runs.
+func isDryRun(r *http.Request, configured bool) (bool, error) {
+	v := r.URL.Query().Get(dryRunParam)
+	if v == "" {
+		return configured, nil
+	}
+	dryRun, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q", dryRunParam, v)
+	}
+	return configured || dryRun, nil
+}
+
+// writeReport responds with report, or with the error that stopped the
+// cleanup.
+func writeReport(ctx context.Context, w http.ResponseWriter, report *Report, err error) {
+	switch {
+	case errors.Is(err, errRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "internal processing error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logging.FromContext(ctx).Errorf("Failed writing cleanup report: %v", err)
+	}
+}
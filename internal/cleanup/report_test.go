@@ -0,0 +1,82 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cleanup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIsDryRun(t *testing.T) {
+	for _, test := range []struct {
+		target     string
+		configured bool
+		want       bool
+		wantErr    bool
+	}{
+		{"/", false, false, false},
+		{"/", true, true, false},
+		{"/?dry-run=true", false, true, false},
+		{"/?dry-run=false", false, false, false},
+		{"/?dry-run=false", true, true, false},
+		{"/?dry-run=maybe", false, false, true},
+	} {
+		got, err := isDryRun(httptest.NewRequest(http.MethodGet, test.target, nil), test.configured)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s, configured %v: got error %v, want error %v", test.target, test.configured, err, test.wantErr)
+		}
+		if got != test.want {
+			t.Errorf("%s, configured %v: got %v, want %v", test.target, test.configured, got, test.want)
+		}
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	ctx := context.Background()
+
+	want := newReport(true, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+	want.Rows["Exposure"] = 10
+	want.Files, want.Bytes = 2, 300
+	w := httptest.NewRecorder()
+	writeReport(ctx, w, want, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var got Report
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, &got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	for err, status := range map[error]int{
+		errRunning:            http.StatusConflict,
+		errors.New("db down"): http.StatusInternalServerError,
+	} {
+		w := httptest.NewRecorder()
+		writeReport(ctx, w, nil, err)
+		if w.Code != status {
+			t.Errorf("%v: got status %d, want %d", err, w.Code, status)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+const (
+	// PermissionBatchPublish allows an API key to publish keys for many people
+	// in a single request, without device attestation.
+	PermissionBatchPublish = "batch_publish"
+	// PermissionFederationAdmin allows an API key to use the federation admin
+	// API, which manages federation partners and reports their sync status.
+	PermissionFederationAdmin = "federation_admin"
+	// PermissionAppAdmin allows an API key to use the app admin API, which
+	// manages authorized apps.
+	PermissionAppAdmin = "app_admin"
+	// PermissionAuditRead allows an API key to read the audit log.
+	PermissionAuditRead = "audit_read"
+)
+
+// APIKey is a key that a health authority uses for server to server requests.
+// Only the SHA-256 hash of the key is stored.
+type APIKey struct {
+	KeySHA256         string
+	Name              string
+	HealthAuthorityID string
+	Permissions       []string
+	AllowedRegions    []string
+	CreatedAt         time.Time
+	RevokedAt         *time.Time
+}
+
+// HasPermission returns true if the key was granted the permission.
+func (k *APIKey) HasPermission(permission string) bool {
+	for _, p := range k.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// HashAPIKey returns the hex encoded SHA-256 hash of the key.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// InsertAPIKey stores the API key.
+func (db *DB) InsertAPIKey(ctx context.Context, k *APIKey) error {
+	return db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO
+				APIKey
+				(key_sha256, name, health_authority_id, permissions, allowed_regions, created_at, revoked_at)
+			VALUES
+				($1, $2, $3, $4, $5, $6, $7)
+			`, k.KeySHA256, k.Name, k.HealthAuthorityID, k.Permissions, k.AllowedRegions, k.CreatedAt, k.RevokedAt); err != nil {
+			return fmt.Errorf("inserting api key: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetAPIKey returns the API key with the given raw value. It returns
+// ErrNotFound if there is no such key or it was revoked.
+func (db *DB) GetAPIKey(ctx context.Context, rawKey string) (*APIKey, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(ctx, `
+		SELECT
+			key_sha256, name, health_authority_id, permissions, allowed_regions, created_at, revoked_at
+		FROM
+			APIKey
+		WHERE
+			key_sha256 = $1 AND revoked_at IS NULL
+		`, HashAPIKey(rawKey))
+
+	var k APIKey
+	if err := row.Scan(&k.KeySHA256, &k.Name, &k.HealthAuthorityID, &k.Permissions, &k.AllowedRegions, &k.CreatedAt, &k.RevokedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("reading api key: %w", err)
+	}
+	return &k, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAPIKeys(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	key := &APIKey{
+		KeySHA256:         HashAPIKey("secret"),
+		Name:              "back-office",
+		HealthAuthorityID: "ha-1",
+		Permissions:       []string{PermissionBatchPublish},
+		AllowedRegions:    []string{"US", "CA"},
+		CreatedAt:         createdAt,
+	}
+	if err := testDB.InsertAPIKey(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := testDB.GetAPIKey(ctx, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(key, got); diff != "" {
+		t.Errorf("GetAPIKey mismatch (-want, +got):\n%s", diff)
+	}
+	if !got.HasPermission(PermissionBatchPublish) {
+		t.Errorf("HasPermission(%q) = false, want true", PermissionBatchPublish)
+	}
+	if got.HasPermission("other") {
+		t.Errorf("HasPermission(%q) = true, want false", "other")
+	}
+
+	if _, err := testDB.GetAPIKey(ctx, "wrong"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetAPIKey with unknown key: got %v, want ErrNotFound", err)
+	}
+
+	revokedAt := createdAt.Add(time.Hour)
+	revoked := &APIKey{
+		KeySHA256:         HashAPIKey("revoked"),
+		Name:              "old",
+		HealthAuthorityID: "ha-1",
+		Permissions:       []string{PermissionBatchPublish},
+		AllowedRegions:    []string{"US"},
+		CreatedAt:         createdAt,
+		RevokedAt:         &revokedAt,
+	}
+	if err := testDB.InsertAPIKey(ctx, revoked); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testDB.GetAPIKey(ctx, "revoked"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetAPIKey with revoked key: got %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxAuditEntries is the most audit entries ListAuditEntries returns.
+const MaxAuditEntries = 1000
+
+// AuditEntry records an administrative or data changing action.
+type AuditEntry struct {
+	ID        int64
+	CreatedAt time.Time
+	// Actor is who took the action, such as an operator's email,
+	// "api-key:NAME" or "system:SERVICE".
+	Actor string
+	// Action is what was done, such as "save" or "delete".
+	Action string
+	// Resource is the kind of thing that changed, such as "export_config".
+	Resource   string
+	ResourceID string
+	// Details holds the changed values as JSON, or is nil.
+	Details json.RawMessage
+}
+
+// AuditCriteria selects audit entries. Empty fields match every entry.
+type AuditCriteria struct {
+	Actor      string
+	Resource   string
+	ResourceID string
+	Since      time.Time
+	Until      time.Time
+	// Limit is the most entries to return, at most MaxAuditEntries, which is
+	// also the default.
+	Limit int
+}
+
+// AddAuditEntry stores e, setting its ID and, if it is zero, its CreatedAt.
+func (db *DB) AddAuditEntry(ctx context.Context, e *AuditEntry) error {
+	if e.Actor == "" || e.Action == "" || e.Resource == "" {
+		return fmt.Errorf("audit entry needs an actor, action and resource")
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	var details interface{}
+	if len(e.Details) > 0 {
+		details = string(e.Details)
+	}
+
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(ctx, `
+		INSERT INTO
+			AuditLog
+			(created_at, actor, action, resource, resource_id, details)
+		VALUES
+			($1, $2, $3, $4, $5, $6)
+		RETURNING audit_id
+		`, e.CreatedAt, e.Actor, e.Action, e.Resource, e.ResourceID, details)
+	if err := row.Scan(&e.ID); err != nil {
+		return fmt.Errorf("inserting audit entry: %w", err)
+	}
+	return nil
+}
+
+// ListAuditEntries returns the audit entries that match criteria, newest
+// first.
+func (db *DB) ListAuditEntries(ctx context.Context, criteria AuditCriteria) ([]*AuditEntry, error) {
+	limit := criteria.Limit
+	if limit <= 0 || limit > MaxAuditEntries {
+		limit = MaxAuditEntries
+	}
+
+	var (
+		where []string
+		args  []interface{}
+	)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if criteria.Actor != "" {
+		add("actor = $%d", criteria.Actor)
+	}
+	if criteria.Resource != "" {
+		add("resource = $%d", criteria.Resource)
+	}
+	if criteria.ResourceID != "" {
+		add("resource_id = $%d", criteria.ResourceID)
+	}
+	if !criteria.Since.IsZero() {
+		add("created_at >= $%d", criteria.Since)
+	}
+	if !criteria.Until.IsZero() {
+		add("created_at < $%d", criteria.Until)
+	}
+	q := `
+		SELECT
+			audit_id, created_at, actor, action, resource, resource_id, details
+		FROM
+			AuditLog`
+	if len(where) > 0 {
+		q += `
+		WHERE
+			` + strings.Join(where, " AND ")
+	}
+	args = append(args, limit)
+	q += fmt.Sprintf(`
+		ORDER BY
+			created_at DESC, audit_id DESC
+		LIMIT $%d`, len(args))
+
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*AuditEntry
+	for rows.Next() {
+		var (
+			e       AuditEntry
+			details *string
+		)
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.Actor, &e.Action, &e.Resource, &e.ResourceID, &details); err != nil {
+			return nil, fmt.Errorf("scanning audit entry: %w", err)
+		}
+		if details != nil {
+			e.Details = json.RawMessage(*details)
+		}
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAuditEntries(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	start := time.Now().Truncate(time.Microsecond)
+	entries := []*AuditEntry{
+		{CreatedAt: start, Actor: "ops@example.com", Action: "save", Resource: "export_config", ResourceID: "1", Details: json.RawMessage(`{"period": "24h"}`)},
+		{CreatedAt: start.Add(time.Minute), Actor: "api-key:admin", Action: "delete", Resource: "authorized_app", ResourceID: "com.example.app"},
+		{CreatedAt: start.Add(2 * time.Minute), Actor: "system:cleanup-exposure", Action: "delete", Resource: "exposures", Details: json.RawMessage(`{"count": 10}`)},
+	}
+	for _, e := range entries {
+		if err := testDB.AddAuditEntry(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := testDB.AddAuditEntry(ctx, &AuditEntry{Action: "save", Resource: "export_config"}); err == nil {
+		t.Errorf("AddAuditEntry without actor: got nil error")
+	}
+
+	// JSONB normalizes whitespace, so details are compared as values.
+	opts := cmp.Transformer("json", func(m json.RawMessage) interface{} {
+		if m == nil {
+			return nil
+		}
+		var v interface{}
+		if err := json.Unmarshal(m, &v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	})
+
+	cases := []struct {
+		name     string
+		criteria AuditCriteria
+		want     []*AuditEntry
+	}{
+		{
+			name: "all",
+			want: []*AuditEntry{entries[2], entries[1], entries[0]},
+		},
+		{
+			name:     "resource",
+			criteria: AuditCriteria{Resource: "authorized_app", ResourceID: "com.example.app"},
+			want:     []*AuditEntry{entries[1]},
+		},
+		{
+			name:     "actor",
+			criteria: AuditCriteria{Actor: "ops@example.com"},
+			want:     []*AuditEntry{entries[0]},
+		},
+		{
+			name:     "time range",
+			criteria: AuditCriteria{Since: start.Add(time.Second), Until: start.Add(2 * time.Minute)},
+			want:     []*AuditEntry{entries[1]},
+		},
+		{
+			name:     "limit",
+			criteria: AuditCriteria{Limit: 1},
+			want:     []*AuditEntry{entries[2]},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := testDB.ListAuditEntries(ctx, c.criteria)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.want, got, opts); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// ClaimCertificate records that the verification certificate with the given
+// issuer and ID (jti claim) was used. It returns ErrCertificateReplayed if the
+// certificate was already used. The record is kept until expiresAt, the
+// certificate's expiry, after which the certificate is rejected anyway.
+func (db *DB) ClaimCertificate(ctx context.Context, issuer, jti string, expiresAt time.Time) error {
+	return db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			INSERT INTO
+				VerificationCertificateUse
+				(issuer, jti, expires_at)
+			VALUES
+				($1, $2, $3)
+			ON CONFLICT (issuer, jti) DO NOTHING
+			`, issuer, jti, expiresAt)
+		if err != nil {
+			return fmt.Errorf("inserting certificate use: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return ErrCertificateReplayed
+		}
+		return nil
+	})
+}
+
+// ReleaseCertificate deletes the record that the verification certificate
+// with the given issuer and ID was used, so that it can be used again. It is
+// for publishes that fail after claiming their certificate.
+func (db *DB) ReleaseCertificate(ctx context.Context, issuer, jti string) error {
+	return db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			DELETE FROM
+				VerificationCertificateUse
+			WHERE
+				issuer = $1 AND jti = $2
+			`, issuer, jti); err != nil {
+			return fmt.Errorf("deleting certificate use: %w", err)
+		}
+		return nil
+	})
+}
+
+// DeleteExpiredCertificateUses deletes the records of certificates that
+// expired before the given time. It returns the number of records deleted.
+func (db *DB) DeleteExpiredCertificateUses(ctx context.Context, before time.Time) (int64, error) {
+	var count int64
+	err := db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				VerificationCertificateUse
+			WHERE
+				expires_at < $1
+			`, before)
+		if err != nil {
+			return fmt.Errorf("deleting certificate uses: %w", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
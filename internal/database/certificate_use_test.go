@@ -0,0 +1,69 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClaimCertificate(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	expiresAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	if err := testDB.ClaimCertificate(ctx, "ha.example.com", "jti-1", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.ClaimCertificate(ctx, "ha.example.com", "jti-1", expiresAt); !errors.Is(err, ErrCertificateReplayed) {
+		t.Errorf("ClaimCertificate replay: got %v, want ErrCertificateReplayed", err)
+	}
+	// A released certificate can be claimed again.
+	if err := testDB.ReleaseCertificate(ctx, "ha.example.com", "jti-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.ClaimCertificate(ctx, "ha.example.com", "jti-1", expiresAt); err != nil {
+		t.Errorf("ClaimCertificate after release: %v", err)
+	}
+	// IDs are scoped to the issuer.
+	if err := testDB.ClaimCertificate(ctx, "other.example.com", "jti-1", expiresAt); err != nil {
+		t.Errorf("ClaimCertificate for other issuer: %v", err)
+	}
+
+	count, err := testDB.DeleteExpiredCertificateUses(ctx, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("deleted %d unexpired records, want 0", count)
+	}
+	count, err = testDB.DeleteExpiredCertificateUses(ctx, expiresAt.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("deleted %d expired records, want 2", count)
+	}
+
+	// Once the record expires, the ID can be claimed again.
+	if err := testDB.ClaimCertificate(ctx, "ha.example.com", "jti-1", expiresAt); err != nil {
+		t.Errorf("ClaimCertificate after expiry: %v", err)
+	}
+}
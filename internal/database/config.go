@@ -34,6 +34,63 @@ type Config struct {
 	PoolMaxConnLife    time.Duration `envconfig:"DB_POOL_MAX_CONN_LIFETIME"`
 	PoolMaxConnIdle    time.Duration `envconfig:"DB_POOL_MAX_CONN_IDLE_TIME"`
 	PoolHealthCheck    time.Duration `envconfig:"DB_POOL_HEALTH_CHECK_PERIOD"`
+
+	// SlowQueryThreshold, if positive, logs statements that take longer than
+	// this, without their arguments, and counts them in the pool metrics.
+	SlowQueryThreshold time.Duration `envconfig:"DB_SLOW_QUERY_THRESHOLD"`
+
+	// ExposureKeyEncryptionKey, if set, is the KMS key used to wrap the data
+	// keys that encrypt exposure keys at the application layer.
+	ExposureKeyEncryptionKey string `envconfig:"DB_EXPOSURE_KEY_ENCRYPTION_KEY"`
+
+	// MigrateOnStart applies any pending schema migrations when a server
+	// starts.
+	MigrateOnStart bool `envconfig:"DB_MIGRATE_ON_START"`
+
+	// ReplicaHost, if set, is a read replica of the database that heavy reads
+	// may be routed to. It shares every other connection setting.
+	ReplicaHost string `envconfig:"DB_REPLICA_HOST"`
+	ReplicaPort string `envconfig:"DB_REPLICA_PORT"`
+
+	// ResidencyHosts maps regions to the hosts of the databases that store the
+	// exposures published for them, such as "DE:db-eu,FR:db-eu", so that
+	// those exposures stay in the region's infrastructure. The databases
+	// share every other connection setting. Exposures of other regions, and
+	// all other data, are stored in the main database.
+	ResidencyHosts map[string]string `envconfig:"DB_RESIDENCY_HOSTS"`
+
+	// StatementTimeout, if positive, aborts any single statement that runs
+	// longer than this on the server.
+	StatementTimeout time.Duration `envconfig:"DB_STATEMENT_TIMEOUT"`
+
+	// OperationTimeout, if positive, bounds each database operation, including
+	// all retries of its transaction. It doesn't apply to IterateExposures and
+	// SyncExposures, which stream rows to their callers for as long as the
+	// callers take; StatementTimeout still applies to their queries.
+	OperationTimeout time.Duration `envconfig:"DB_OPERATION_TIMEOUT"`
+
+	// MaxRetries is the number of times a transaction that fails with a
+	// serialization failure or deadlock is retried, waiting a jittered
+	// exponential backoff between RetryBaseDelay and RetryMaxDelay.
+	MaxRetries     int           `envconfig:"DB_MAX_RETRIES" default:"3"`
+	RetryBaseDelay time.Duration `envconfig:"DB_RETRY_BASE_DELAY" default:"50ms"`
+	RetryMaxDelay  time.Duration `envconfig:"DB_RETRY_MAX_DELAY" default:"1s"`
+
+	// ConfigPollInterval is how often servers poll the database for runtime
+	// settings and configuration changes, see package runtimeconfig. Zero
+	// disables polling.
+	ConfigPollInterval time.Duration `envconfig:"DB_CONFIG_POLL_INTERVAL" default:"1m"`
+
+	// CursorSecret is the key used to sign iteration cursors handed to
+	// clients. All servers that accept each other's cursors must share it.
+	// It is required to page through IterateExposures.
+	CursorSecret string `envconfig:"DB_CURSOR_SECRET"`
+
+	// LegacyCursorsUntil, if set, accepts the unsigned cursors handed out
+	// before cursors were signed until this time, an RFC3339 timestamp, so
+	// that clients paging through an upgrade can finish. Unsigned cursors
+	// can be forged, so keep it short.
+	LegacyCursorsUntil time.Time `envconfig:"DB_LEGACY_CURSORS_UNTIL"`
 }
 
 func (c *Config) DB() *Config {
@@ -0,0 +1,150 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// The tables whose changes are counted by ConfigVersions, as named there.
+const (
+	ConfigTableSettings           = "configsetting"
+	ConfigTableAuthorizedApp      = "authorizedapp"
+	ConfigTableHealthAuthority    = "healthauthority"
+	ConfigTableHealthAuthorityKey = "healthauthoritykey"
+	ConfigTableExportConfig       = "exportconfig"
+	ConfigTableSignatureInfo      = "signatureinfo"
+)
+
+// ConfigSetting overrides a setting of the servers while they run. Name is
+// the setting's environment variable.
+type ConfigSetting struct {
+	Name      string
+	Value     string
+	UpdatedAt time.Time
+}
+
+// ListConfigSettings returns every ConfigSetting, ordered by name.
+func (db *DB) ListConfigSettings(ctx context.Context) ([]*ConfigSetting, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			name, value, updated_at
+		FROM
+			ConfigSetting
+		ORDER BY
+			name
+		`)
+	if err != nil {
+		return nil, fmt.Errorf("listing config settings: %w", err)
+	}
+	defer rows.Close()
+
+	var settings []*ConfigSetting
+	for rows.Next() {
+		var s ConfigSetting
+		if err := rows.Scan(&s.Name, &s.Value, &s.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scanning config setting: %w", err)
+		}
+		settings = append(settings, &s)
+	}
+	return settings, rows.Err()
+}
+
+// SetConfigSetting sets the value of a setting.
+func (db *DB) SetConfigSetting(ctx context.Context, name, value string) error {
+	if name == "" {
+		return fmt.Errorf("config setting name cannot be empty")
+	}
+
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO
+				ConfigSetting
+				(name, value, updated_at)
+			VALUES
+				($1, $2, NOW())
+			ON CONFLICT (name) DO UPDATE
+				SET value = $2, updated_at = NOW()
+			`, name, value); err != nil {
+			return fmt.Errorf("upserting config setting: %w", err)
+		}
+		return nil
+	})
+}
+
+// DeleteConfigSetting deletes a setting, so servers go back to its
+// environment variable. It returns ErrNotFound if the setting isn't set.
+func (db *DB) DeleteConfigSetting(ctx context.Context, name string) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				ConfigSetting
+			WHERE
+				name = $1
+			`, name)
+		if err != nil {
+			return fmt.Errorf("deleting config setting: %w", err)
+		}
+		if result.RowsAffected() != 1 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// ConfigVersions returns the number of changes to each table of configuration
+// by table name, see the ConfigTable constants. A table that has never
+// changed is missing.
+func (db *DB) ConfigVersions(ctx context.Context) (map[string]int64, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			table_name, version
+		FROM
+			ConfigVersion
+		`)
+	if err != nil {
+		return nil, fmt.Errorf("reading config versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := make(map[string]int64)
+	for rows.Next() {
+		var (
+			table   string
+			version int64
+		)
+		if err := rows.Scan(&table, &version); err != nil {
+			return nil, fmt.Errorf("scanning config version: %w", err)
+		}
+		versions[table] = version
+	}
+	return versions, rows.Err()
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestConfigSettings(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	versions, err := testDB.ConfigVersions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 0 {
+		t.Errorf("got versions %v before any change, want none", versions)
+	}
+
+	if err := testDB.SetConfigSetting(ctx, "CLEANUP_TTL", "240h"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.SetConfigSetting(ctx, "CLEANUP_TTL", "336h"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.AddHealthAuthority(ctx, &HealthAuthority{ID: "ha"}); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := testDB.ListConfigSettings(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(settings) != 1 || settings[0].Name != "CLEANUP_TTL" || settings[0].Value != "336h" {
+		t.Errorf("got settings %+v, want CLEANUP_TTL=336h", settings)
+	}
+
+	versions, err = testDB.ConfigVersions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions[ConfigTableSettings] != 2 || versions[ConfigTableHealthAuthority] != 1 {
+		t.Errorf("got versions %v, want 2 setting changes and 1 health authority change", versions)
+	}
+
+	if err := testDB.DeleteConfigSetting(ctx, "CLEANUP_TTL"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.DeleteConfigSetting(ctx, "CLEANUP_TTL"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteConfigSetting twice: got %v, want %v", err, ErrNotFound)
+	}
+}
@@ -20,10 +20,12 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/logging"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -47,6 +49,45 @@ type config struct {
 
 type DB struct {
 	Pool *pgxpool.Pool
+
+	// replica is an optional pool connected to a read replica.
+	replica *pgxpool.Pool
+
+	// slowQueries and replicaSlowQueries log the slow statements of Pool and
+	// replica, if DB_SLOW_QUERY_THRESHOLD is set.
+	slowQueries        *slowQueryLog
+	replicaSlowQueries *slowQueryLog
+
+	// residency maps regions to the databases that store their exposures,
+	// and residencyDBs lists those databases; see ForRegion. residencyHost is
+	// set on the residency databases themselves.
+	residency     map[string]*DB
+	residencyDBs  []*DB
+	residencyHost string
+
+	// keys encrypts exposure keys, if EnableExposureKeyEncryption was called.
+	keys *keyCrypter
+
+	// cursorKey is the HMAC key used to sign iteration cursors, and
+	// prevCursorKey the key it replaced, see SetCursorSecret.
+	cursorKey     []byte
+	prevCursorKey []byte
+
+	// legacyCursorsUntil is when unsigned legacy cursors stop being
+	// accepted. They aren't accepted if it is zero.
+	legacyCursorsUntil time.Time
+
+	// password, if set, is used for new connections instead of the password
+	// in the connection string, see SetPassword.
+	password string
+
+	secretsMutex sync.RWMutex
+
+	// operationTimeout, if positive, bounds each database operation.
+	operationTimeout time.Duration
+
+	// retry determines how failed transactions are retried.
+	retry retryPolicy
 }
 
 // NewFromEnv sets up the database connections using the configuration in the
@@ -57,18 +98,135 @@ func NewFromEnv(ctx context.Context, config *Config) (*DB, error) {
 	logger := logging.FromContext(ctx)
 	logger.Infof("Creating connection pool.")
 
+	if !config.LegacyCursorsUntil.IsZero() {
+		logger.Warnf("Accepting unsigned legacy cursors until %v", config.LegacyCursorsUntil)
+	}
+
+	db := &DB{
+		cursorKey:          []byte(config.CursorSecret),
+		legacyCursorsUntil: config.LegacyCursorsUntil,
+		password:           config.Password,
+		operationTimeout:   config.OperationTimeout,
+		slowQueries:        newSlowQueryLog(config.SlowQueryThreshold),
+		retry: retryPolicy{
+			maxRetries: config.MaxRetries,
+			baseDelay:  config.RetryBaseDelay,
+			maxDelay:   config.RetryMaxDelay,
+		},
+	}
+
+	pool, err := db.connect(ctx, config, db.slowQueries)
+	if err != nil {
+		return nil, err
+	}
+	db.Pool = pool
+
+	if config.ReplicaHost != "" {
+		replicaConfig := *config
+		replicaConfig.Host = config.ReplicaHost
+		if config.ReplicaPort != "" {
+			replicaConfig.Port = config.ReplicaPort
+		}
+		db.replicaSlowQueries = newSlowQueryLog(config.SlowQueryThreshold)
+		replica, err := db.connect(ctx, &replicaConfig, db.replicaSlowQueries)
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("replica: %w", err)
+		}
+		db.replica = replica
+		logger.Infof("Created replica connection pool for %v.", config.ReplicaHost)
+	}
+
+	if err := db.connectResidencies(ctx, config); err != nil {
+		db.Close(ctx)
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// connect creates a connection pool for config whose new connections use the
+// current password of db, and report slow statements to slow.
+func (db *DB) connect(ctx context.Context, config *Config, slow *slowQueryLog) (*pgxpool.Pool, error) {
 	connStr, err := dbConnectionString(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database config: %v", err)
+	}
 
+	poolConfig, err := pgxpool.ParseConfig(connStr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid database config: %v", err)
 	}
+	poolConfig.BeforeConnect = db.beforeConnect
+	slow.configure(poolConfig.ConnConfig)
 
-	pool, err := pgxpool.Connect(ctx, connStr)
+	pool, err := pgxpool.ConnectConfig(ctx, poolConfig)
 	if err != nil {
 		return nil, fmt.Errorf("creating connection pool: %v", err)
 	}
+	logging.FromContext(ctx).Infof("Connection pool for %v: min %d, max %d conns, max lifetime %v, max idle time %v, health check every %v.",
+		config.Host, poolConfig.MinConns, poolConfig.MaxConns, poolConfig.MaxConnLifetime, poolConfig.MaxConnIdleTime, poolConfig.HealthCheckPeriod)
+	return pool, nil
+}
+
+func (db *DB) beforeConnect(ctx context.Context, config *pgx.ConnConfig) error {
+	db.secretsMutex.RLock()
+	defer db.secretsMutex.RUnlock()
+	if db.password != "" {
+		config.Password = db.password
+	}
+	return nil
+}
+
+// SetPassword changes the password used to open new database connections, for
+// example after it was rotated. If the password changed, idle connections are
+// closed, so that the pools reconnect with the new password; connections in
+// use are replaced when they reach DB_POOL_MAX_CONN_LIFETIME.
+func (db *DB) SetPassword(ctx context.Context, password string) {
+	logger := logging.FromContext(ctx)
+
+	db.secretsMutex.Lock()
+	changed := db.password != password
+	db.password = password
+	db.secretsMutex.Unlock()
+	if !changed {
+		return
+	}
 
-	return &DB{Pool: pool}, nil
+	closed := 0
+	for _, pool := range []*pgxpool.Pool{db.Pool, db.replica} {
+		if pool == nil {
+			continue
+		}
+		for _, conn := range pool.AcquireAllIdle(ctx) {
+			// Released connections that are closed are removed from the pool.
+			conn.Conn().Close(ctx)
+			conn.Release()
+			closed++
+		}
+	}
+	logger.Infof("Database password changed, closed %d idle connections.", closed)
+
+	for _, rdb := range db.residencyDBs {
+		rdb.SetPassword(ctx, password)
+	}
+}
+
+// SetCursorSecret changes the key used to sign iteration cursors, for example
+// after it was rotated. Cursors signed with the previous key remain valid until
+// the key changes again.
+func (db *DB) SetCursorSecret(secret string) {
+	for _, rdb := range db.residencyDBs {
+		rdb.SetCursorSecret(secret)
+	}
+
+	db.secretsMutex.Lock()
+	defer db.secretsMutex.Unlock()
+	if secret == string(db.cursorKey) {
+		return
+	}
+	db.prevCursorKey = db.cursorKey
+	db.cursorKey = []byte(secret)
 }
 
 // Close releases database connections.
@@ -76,6 +234,12 @@ func (db *DB) Close(ctx context.Context) {
 	logger := logging.FromContext(ctx)
 	logger.Infof("Closing connection pool.")
 	db.Pool.Close()
+	if db.replica != nil {
+		db.replica.Close()
+	}
+	for _, rdb := range db.residencyDBs {
+		rdb.Close(ctx)
+	}
 }
 
 // dbConnectionString builds a connection string suitable for the pgx Postgres driver, using the
@@ -132,5 +296,8 @@ func dbValues(config *Config) map[string]string {
 	setIfPositiveDuration(p, "pool_max_conn_lifetime", config.PoolMaxConnLife)
 	setIfPositiveDuration(p, "pool_max_conn_idle_time", config.PoolMaxConnIdle)
 	setIfPositiveDuration(p, "pool_health_check_period", config.PoolHealthCheck)
+	if config.StatementTimeout > 0 {
+		p["statement_timeout"] = fmt.Sprintf("%d", config.StatementTimeout.Milliseconds())
+	}
 	return p
 }
@@ -15,10 +15,12 @@
 package database
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/jackc/pgx/v4"
 )
 
 func TestDBValues(t *testing.T) {
@@ -50,6 +52,15 @@ func TestDBValues(t *testing.T) {
 				"pool_health_check_period": "5m0s",
 			},
 		},
+		{
+			name: "statement timeout",
+			config: Config{
+				StatementTimeout: 30 * time.Second,
+			},
+			want: map[string]string{
+				"statement_timeout": "30000",
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -61,3 +72,25 @@ func TestDBValues(t *testing.T) {
 		})
 	}
 }
+
+func TestSetPassword(t *testing.T) {
+	ctx := context.Background()
+	db := &DB{}
+
+	config := &pgx.ConnConfig{}
+	config.Password = "from-env"
+	if err := db.beforeConnect(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Password, "from-env"; got != want {
+		t.Errorf("got password %q, want %q", got, want)
+	}
+
+	db.SetPassword(ctx, "rotated")
+	if err := db.beforeConnect(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Password, "rotated"; got != want {
+		t.Errorf("got password %q, want %q", got, want)
+	}
+}
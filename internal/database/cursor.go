@@ -0,0 +1,176 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/base64util"
+)
+
+// cursorVersion is the version of the cursor format produced by encodeCursor.
+// Version 1 cursors didn't carry the criteria of their query; they are
+// accepted like legacy cursors.
+const cursorVersion = 2
+
+// errNoCursorSecret is returned when a cursor is needed but no cursor secret
+// is configured.
+var errNoCursorSecret = errors.New("DB_CURSOR_SECRET is not set, cursors can't be signed")
+
+// cursorState is the state carried by an iteration cursor.
+type cursorState struct {
+	Version  int    `json:"v"`
+	Offset   int    `json:"o"`
+	Criteria string `json:"c,omitempty"`
+}
+
+// SignsCursors reports whether a cursor secret is configured, which paging
+// through IterateExposures requires.
+func (db *DB) SignsCursors() bool {
+	db.secretsMutex.RLock()
+	defer db.secretsMutex.RUnlock()
+	return len(db.cursorKey) > 0
+}
+
+// encodeCursor returns an opaque cursor that resumes the iteration of criteria
+// at offset. The cursor is the base64 encoded JSON state followed by a "." and
+// the base64 encoded HMAC-SHA256 of the state, so that it can't be altered by
+// clients. It returns the empty string if no cursor secret is configured.
+func (db *DB) encodeCursor(offset int, criteria IterateExposuresCriteria) string {
+	db.secretsMutex.RLock()
+	key := db.cursorKey
+	db.secretsMutex.RUnlock()
+	if len(key) == 0 {
+		return ""
+	}
+
+	payload, err := json.Marshal(cursorState{Version: cursorVersion, Offset: offset, Criteria: cursorCriteria(criteria)})
+	if err != nil {
+		// Marshaling two ints and a string can't fail.
+		panic(err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(cursorMAC(key, encoded))
+}
+
+// decodeCursor returns the offset encoded in cursor, which must have been
+// issued for the same criteria. Cursors without a signature are legacy
+// cursors, the base64 encoding of the decimal offset. They, and version 1
+// cursors, are only accepted until DB_LEGACY_CURSORS_UNTIL.
+func (db *DB) decodeCursor(cursor string, criteria IterateExposuresCriteria) (int, error) {
+	acceptLegacy := time.Now().Before(db.legacyCursorsUntil)
+	i := strings.IndexByte(cursor, '.')
+	if i < 0 {
+		if !acceptLegacy {
+			return 0, fmt.Errorf("unsigned cursor")
+		}
+		return decodeLegacyCursor(cursor)
+	}
+
+	if !db.SignsCursors() {
+		return 0, errNoCursorSecret
+	}
+	encoded, sig := cursor[:i], cursor[i+1:]
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return 0, fmt.Errorf("decoding cursor signature: %w", err)
+	}
+	if !db.validCursorMAC(encoded, mac) {
+		return 0, fmt.Errorf("invalid cursor signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, fmt.Errorf("decoding cursor: %w", err)
+	}
+	var state cursorState
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return 0, fmt.Errorf("decoding cursor: %w", err)
+	}
+	switch {
+	case state.Version == 1 && acceptLegacy:
+	case state.Version != cursorVersion:
+		return 0, fmt.Errorf("unsupported cursor version %d", state.Version)
+	case state.Criteria != cursorCriteria(criteria):
+		return 0, fmt.Errorf("cursor was issued for different criteria")
+	}
+	if state.Offset < 0 {
+		return 0, fmt.Errorf("invalid cursor offset %d", state.Offset)
+	}
+	return state.Offset, nil
+}
+
+// cursorCriteria returns a digest of the criteria that select and order the
+// iterated exposures, so that a cursor only resumes the query that issued it.
+// UntilTimestamp and PageSize are left out: federation pulls move the end of
+// their window forward between pages, which only adds exposures after those
+// already returned.
+func cursorCriteria(criteria IterateExposuresCriteria) string {
+	criteria.SinceTimestamp = criteria.SinceTimestamp.UTC()
+	criteria.UntilTimestamp = time.Time{}
+	criteria.PageSize = 0
+	criteria.LastCursor = ""
+	criteria.ReadPreference = ReadPrimary
+	b, err := json.Marshal(criteria)
+	if err != nil {
+		// The criteria are plain values, which can't fail to marshal.
+		panic(err)
+	}
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// validCursorMAC reports whether mac signs encoded with the current cursor key,
+// or with the previous one, so that cursors survive a key rotation.
+func (db *DB) validCursorMAC(encoded string, mac []byte) bool {
+	db.secretsMutex.RLock()
+	key, prevKey := db.cursorKey, db.prevCursorKey
+	db.secretsMutex.RUnlock()
+
+	if hmac.Equal(mac, cursorMAC(key, encoded)) {
+		return true
+	}
+	return len(prevKey) > 0 && hmac.Equal(mac, cursorMAC(prevKey, encoded))
+}
+
+func cursorMAC(key []byte, encoded string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// decodeLegacyCursor decodes a cursor issued before cursors were versioned.
+func decodeLegacyCursor(cursor string) (int, error) {
+	b, err := base64util.DecodeString(cursor)
+	if err != nil {
+		return 0, fmt.Errorf("decoding cursor: %w", err)
+	}
+	offset, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, fmt.Errorf("decoding cursor: %w", err)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("invalid cursor offset %d", offset)
+	}
+	return offset, nil
+}
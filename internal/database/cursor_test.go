@@ -0,0 +1,169 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+var criteria = IterateExposuresCriteria{IncludeRegions: []string{"US"}, SinceTimestamp: time.Unix(1600000000, 0)}
+
+func TestCursorRoundTrip(t *testing.T) {
+	db := &DB{cursorKey: []byte("secret")}
+	for _, offset := range []int{0, 1, 500, 1 << 30} {
+		got, err := db.decodeCursor(db.encodeCursor(offset, criteria), criteria)
+		if err != nil {
+			t.Fatalf("%d: %v", offset, err)
+		}
+		if got != offset {
+			t.Errorf("got offset %d, want %d", got, offset)
+		}
+	}
+}
+
+func TestCursorRejectsTampering(t *testing.T) {
+	db := &DB{cursorKey: []byte("secret")}
+	other := &DB{cursorKey: []byte("other")}
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"v":2,"o":99}`))
+	valid := db.encodeCursor(10, criteria)
+
+	for _, cursor := range []string{
+		forged + "." + base64.RawURLEncoding.EncodeToString(cursorMAC(db.cursorKey, "x")),
+		forged + valid[len(forged):],
+		other.encodeCursor(10, criteria),
+		valid + "x",
+		"." + valid,
+	} {
+		if _, err := db.decodeCursor(cursor, criteria); err == nil {
+			t.Errorf("decodeCursor(%q): expected error", cursor)
+		}
+	}
+}
+
+func TestCursorCriteria(t *testing.T) {
+	db := &DB{cursorKey: []byte("secret")}
+	cursor := db.encodeCursor(10, criteria)
+
+	// The end of the window and the page size may change between pages.
+	same := criteria
+	same.UntilTimestamp = time.Unix(1600003600, 0)
+	same.PageSize = 100
+	same.ReadPreference = ReadReplica
+	same.SinceTimestamp = criteria.SinceTimestamp.UTC()
+	if got, err := db.decodeCursor(cursor, same); err != nil || got != 10 {
+		t.Errorf("decodeCursor with %+v: got %d, %v, want 10", same, got, err)
+	}
+
+	for _, other := range []IterateExposuresCriteria{
+		{IncludeRegions: []string{"CA"}, SinceTimestamp: criteria.SinceTimestamp},
+		{IncludeRegions: criteria.IncludeRegions, SinceTimestamp: criteria.SinceTimestamp.Add(time.Second)},
+		{IncludeRegions: criteria.IncludeRegions, SinceTimestamp: criteria.SinceTimestamp, OnlyRevokedKeys: true},
+	} {
+		if _, err := db.decodeCursor(cursor, other); err == nil {
+			t.Errorf("decodeCursor with %+v: expected error", other)
+		}
+	}
+}
+
+func TestCursorWithoutSecret(t *testing.T) {
+	db := &DB{}
+	if db.SignsCursors() {
+		t.Errorf("SignsCursors() = true without a cursor secret")
+	}
+	if got := db.encodeCursor(10, criteria); got != "" {
+		t.Errorf("encodeCursor() = %q, want empty", got)
+	}
+
+	// A cursor signed with an empty key must not be accepted.
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(`{"v":2,"o":99,"c":"` + cursorCriteria(criteria) + `"}`))
+	forged := encoded + "." + base64.RawURLEncoding.EncodeToString(cursorMAC(nil, encoded))
+	if _, err := db.decodeCursor(forged, criteria); err == nil {
+		t.Errorf("decodeCursor(%q): expected error", forged)
+	}
+}
+
+func TestVersion1Cursor(t *testing.T) {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(`{"v":1,"o":42}`))
+	cursor := encoded + "." + base64.RawURLEncoding.EncodeToString(cursorMAC([]byte("secret"), encoded))
+
+	db := &DB{cursorKey: []byte("secret"), legacyCursorsUntil: time.Now().Add(time.Hour)}
+	if got, err := db.decodeCursor(cursor, criteria); err != nil || got != 42 {
+		t.Errorf("decodeCursor(%q): got %d, %v, want 42", cursor, got, err)
+	}
+
+	db.legacyCursorsUntil = time.Time{}
+	if _, err := db.decodeCursor(cursor, criteria); err == nil {
+		t.Errorf("decodeCursor(%q) after legacy cursors: expected error", cursor)
+	}
+}
+
+func TestCursorKeyRotation(t *testing.T) {
+	db := &DB{cursorKey: []byte("first")}
+	old := db.encodeCursor(10, criteria)
+
+	db.SetCursorSecret("second")
+	for _, cursor := range []string{old, db.encodeCursor(10, criteria)} {
+		if got, err := db.decodeCursor(cursor, criteria); err != nil || got != 10 {
+			t.Errorf("decodeCursor(%q): got %d, %v, want 10", cursor, got, err)
+		}
+	}
+
+	// Setting the same key again keeps the previous key.
+	db.SetCursorSecret("second")
+	if _, err := db.decodeCursor(old, criteria); err != nil {
+		t.Errorf("decodeCursor(%q): %v", old, err)
+	}
+
+	// Only one previous key is accepted.
+	db.SetCursorSecret("third")
+	if _, err := db.decodeCursor(old, criteria); err == nil {
+		t.Errorf("decodeCursor(%q): expected error", old)
+	}
+}
+
+func TestLegacyCursor(t *testing.T) {
+	db := &DB{cursorKey: []byte("secret"), legacyCursorsUntil: time.Now().Add(time.Hour)}
+	for _, test := range []struct {
+		cursor  string
+		want    int
+		wantErr bool
+	}{
+		{base64.StdEncoding.EncodeToString([]byte("42")), 42, false},
+		{base64.StdEncoding.EncodeToString([]byte("-1")), 0, true},
+		{base64.StdEncoding.EncodeToString([]byte("abc")), 0, true},
+		{"!!!", 0, true},
+	} {
+		got, err := db.decodeCursor(test.cursor, criteria)
+		if (err != nil) != test.wantErr {
+			t.Errorf("decodeCursor(%q): got error %v, want error %v", test.cursor, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("decodeCursor(%q): got %d, want %d", test.cursor, got, test.want)
+		}
+	}
+}
+
+func TestLegacyCursorRejected(t *testing.T) {
+	cursor := base64.StdEncoding.EncodeToString([]byte("42"))
+	for _, until := range []time.Time{{}, time.Now().Add(-time.Minute)} {
+		db := &DB{cursorKey: []byte("secret"), legacyCursorsUntil: until}
+		if _, err := db.decodeCursor(cursor, criteria); err == nil {
+			t.Errorf("legacy cursors until %v: decodeCursor(%q): expected error", until, cursor)
+		}
+	}
+}
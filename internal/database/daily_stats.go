@@ -0,0 +1,159 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// DailyStats holds the aggregate counts of one UTC day. It holds no keys or
+// other data of individual uploads.
+type DailyStats struct {
+	Day time.Time `json:"day"`
+
+	// Uploads is the number of publish requests whose keys were stored on the
+	// day, counted by their revision tokens, and Keys the number of keys they
+	// stored. Keys past the retention period have been deleted and are no
+	// longer counted.
+	Uploads int64 `json:"uploads"`
+	Keys    int64 `json:"keys"`
+
+	// ExportBatches is the number of export batches starting on the day, and
+	// ExportedKeys and ExportBytes the real keys and bytes of their files.
+	ExportBatches int64 `json:"exportBatches"`
+	ExportedKeys  int64 `json:"exportedKeys"`
+	ExportBytes   int64 `json:"exportBytes"`
+
+	// FederationInSyncs is the number of syncs started on the day, and
+	// FederationInKeys the keys they inserted.
+	FederationInSyncs int64 `json:"federationInSyncs"`
+	FederationInKeys  int64 `json:"federationInKeys"`
+
+	// FederationOutRequests and FederationOutKeys are the fetch requests and
+	// keys served to federation partners.
+	FederationOutRequests int64 `json:"federationOutRequests"`
+	FederationOutKeys     int64 `json:"federationOutKeys"`
+}
+
+// ListDailyStats returns the stats of each UTC day from the day of from
+// through the day before thru's, in order. Days without activity are
+// included with zero counts.
+func (db *DB) ListDailyStats(ctx context.Context, from, thru time.Time) ([]*DailyStats, error) {
+	from = from.UTC().Truncate(24 * time.Hour)
+	thru = thru.UTC().Truncate(24 * time.Hour)
+	if !from.Before(thru) {
+		return nil, nil
+	}
+
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	var stats []*DailyStats
+	byDay := make(map[time.Time]*DailyStats)
+	for day := from; day.Before(thru); day = day.AddDate(0, 0, 1) {
+		s := &DailyStats{Day: day}
+		stats = append(stats, s)
+		byDay[day] = s
+	}
+	args := []interface{}{from, thru}
+
+	// scanDay returns a row handler that scans a day and n counts, and passes
+	// the counts to set with the stats of the day.
+	scanDay := func(n int, set func(s *DailyStats, counts []int64)) func(pgx.Rows) error {
+		return func(rows pgx.Rows) error {
+			var day time.Time
+			counts := make([]int64, n)
+			dest := []interface{}{&day}
+			for i := range counts {
+				dest = append(dest, &counts[i])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				return err
+			}
+			if s, ok := byDay[time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)]; ok {
+				set(s, counts)
+			}
+			return nil
+		}
+	}
+
+	err = queryGroupCounts(ctx, conn, `
+		SELECT
+			date_trunc('day', created_at AT TIME ZONE 'UTC') AS day,
+			COUNT(DISTINCT revision_token), COUNT(*)
+		FROM
+			Exposure
+		WHERE
+			local_provenance = TRUE AND created_at >= $1 AND created_at < $2
+		GROUP BY day`, args, scanDay(2, func(s *DailyStats, counts []int64) {
+		s.Uploads, s.Keys = counts[0], counts[1]
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("counting uploads: %w", err)
+	}
+
+	err = queryGroupCounts(ctx, conn, `
+		SELECT
+			date_trunc('day', start_timestamp AT TIME ZONE 'UTC') AS day,
+			COUNT(*), COALESCE(SUM(keys), 0)::BIGINT, COALESCE(SUM(size_bytes), 0)::BIGINT
+		FROM
+			ExportBatchStats
+		WHERE
+			start_timestamp >= $1 AND start_timestamp < $2
+		GROUP BY day`, args, scanDay(3, func(s *DailyStats, counts []int64) {
+		s.ExportBatches, s.ExportedKeys, s.ExportBytes = counts[0], counts[1], counts[2]
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("counting export batches: %w", err)
+	}
+
+	err = queryGroupCounts(ctx, conn, `
+		SELECT
+			date_trunc('day', started AT TIME ZONE 'UTC') AS day,
+			COUNT(*), COALESCE(SUM(insertions), 0)::BIGINT
+		FROM
+			FederationInSync
+		WHERE
+			started >= $1 AND started < $2
+		GROUP BY day`, args, scanDay(2, func(s *DailyStats, counts []int64) {
+		s.FederationInSyncs, s.FederationInKeys = counts[0], counts[1]
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("counting federation syncs: %w", err)
+	}
+
+	err = queryGroupCounts(ctx, conn, `
+		SELECT
+			day::TIMESTAMP, COALESCE(SUM(requests), 0)::BIGINT, COALESCE(SUM(keys), 0)::BIGINT
+		FROM
+			FederationOutUsage
+		WHERE
+			day >= $1::DATE AND day < $2::DATE
+		GROUP BY day`, args, scanDay(2, func(s *DailyStats, counts []int64) {
+		s.FederationOutRequests, s.FederationOutKeys = counts[0], counts[1]
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("counting federation usage: %w", err)
+	}
+
+	return stats, nil
+}
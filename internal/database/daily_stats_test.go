@@ -0,0 +1,85 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestListDailyStats(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	day1 := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	day3 := day2.AddDate(0, 0, 1)
+
+	exposures := []*Exposure{
+		{ExposureKey: []byte("ABC"), Regions: []string{"US"}, IntervalNumber: 1, IntervalCount: 144, CreatedAt: day1, LocalProvenance: true, RevisionToken: "upload1"},
+		{ExposureKey: []byte("DEF"), Regions: []string{"US"}, IntervalNumber: 1, IntervalCount: 144, CreatedAt: day1, LocalProvenance: true, RevisionToken: "upload1"},
+		{ExposureKey: []byte("GHI"), Regions: []string{"US"}, IntervalNumber: 1, IntervalCount: 144, CreatedAt: day1.Add(time.Hour), LocalProvenance: true, RevisionToken: "upload2"},
+		{ExposureKey: []byte("JKL"), Regions: []string{"US"}, IntervalNumber: 1, IntervalCount: 144, CreatedAt: day3, LocalProvenance: true, RevisionToken: "upload3"},
+		// Federated keys are not uploads.
+		{ExposureKey: []byte("MNO"), Regions: []string{"US"}, IntervalNumber: 1, IntervalCount: 144, CreatedAt: day1},
+	}
+	if err := testDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	query := &FederationInQuery{QueryID: "query", ServerAddr: "addr", IncludeRegions: []string{"US"}}
+	if err := testDB.AddFederationInQuery(ctx, query); err != nil {
+		t.Fatal(err)
+	}
+	_, finalize, err := testDB.StartFederationInSync(ctx, query, day2.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := finalize(day2, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := &FederationOutAuthorization{Issuer: "iss", Subject: "sub"}
+	if err := testDB.AddFederationOutAuthorization(ctx, auth); err != nil {
+		t.Fatal(err)
+	}
+	for _, keys := range []int{10, 5} {
+		if err := testDB.RecordFederationOutUsage(ctx, auth.Issuer, auth.Subject, day2, keys); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := testDB.ListDailyStats(ctx, day1.Add(time.Hour), day3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*DailyStats{
+		{Day: day1, Uploads: 2, Keys: 3},
+		{Day: day2, FederationInSyncs: 1, FederationInKeys: 7, FederationOutRequests: 2, FederationOutKeys: 15},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	if got, err := testDB.ListDailyStats(ctx, day3, day3); err != nil || len(got) != 0 {
+		t.Errorf("empty range: got %v, %v, want no stats", got, err)
+	}
+}
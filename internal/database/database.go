@@ -19,6 +19,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/observability"
 
 	pgx "github.com/jackc/pgx/v4"
 )
@@ -29,6 +35,22 @@ var (
 
 	// ErrKeyConflict indicates that there was a key conflict inserting a row.
 	ErrKeyConflict = errors.New("key conflict")
+
+	// ErrInvalidRevisionToken indicates that a revision token was missing or
+	// did not match the token the exposure was published with.
+	ErrInvalidRevisionToken = errors.New("invalid revision token")
+
+	// ErrInvalidReportTypeTransition indicates that an exposure cannot be
+	// revised from its current report type to the requested one.
+	ErrInvalidReportTypeTransition = errors.New("invalid report type transition")
+
+	// ErrExportBatchesExist indicates that another process created export
+	// batches for the same config since its latest batch end was read.
+	ErrExportBatchesExist = errors.New("export batches already created")
+
+	// ErrCertificateReplayed indicates that a verification certificate was
+	// already used.
+	ErrCertificateReplayed = errors.New("verification certificate already used")
 )
 
 func toNullString(s string) sql.NullString {
@@ -41,8 +63,36 @@ func toNullString(s string) sql.NullString {
 	}
 }
 
-// inTx runs the given function f within a transaction with isolation level isoLevel.
-func (db *DB) inTx(ctx context.Context, isoLevel pgx.TxIsoLevel, f func(tx pgx.Tx) error) error {
+// inTx runs the given function f within a transaction with isolation level
+// isoLevel. If the transaction fails with a serialization failure or deadlock
+// it is retried according to the database's retry policy, so f may be called
+// more than once and must not leak state between calls.
+//
+// Each call is traced as a span named after the calling function.
+func (db *DB) inTx(ctx context.Context, isoLevel pgx.TxIsoLevel, f func(tx pgx.Tx) error) (err error) {
+	ctx, span := observability.StartSpan(ctx, operationName(2))
+	defer observability.EndSpan(span, &err)
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		err := db.inTxOnce(ctx, isoLevel, f)
+		if err == nil || attempt >= db.retry.maxRetries || !isRetryable(err) {
+			return err
+		}
+
+		logging.FromContext(ctx).Debugf("retrying transaction after attempt %d: %v", attempt+1, err)
+		span.Annotatef(nil, "retrying transaction after attempt %d: %v", attempt+1, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(db.retry.backoff(attempt)):
+		}
+	}
+}
+
+func (db *DB) inTxOnce(ctx context.Context, isoLevel pgx.TxIsoLevel, f func(tx pgx.Tx) error) error {
 	conn, err := db.Pool.Acquire(ctx)
 	if err != nil {
 		return fmt.Errorf("acquiring connection: %v", err)
@@ -56,13 +106,45 @@ func (db *DB) inTx(ctx context.Context, isoLevel pgx.TxIsoLevel, f func(tx pgx.T
 
 	if err := f(tx); err != nil {
 		if err1 := tx.Rollback(ctx); err1 != nil {
-			return fmt.Errorf("rolling back transaction: %v (original error: %v)", err1, err)
+			return fmt.Errorf("rolling back transaction: %v (original error: %w)", err1, err)
 		}
 		return err
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("committing transaction: %v", err)
+		return fmt.Errorf("committing transaction: %w", err)
 	}
 	return nil
 }
+
+// operationName returns the name of the function skip frames up the stack,
+// such as "database.InsertExposures" for (*DB).InsertExposures, to name the
+// span of a database operation.
+func operationName(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip)
+	if !ok {
+		return "database.unknown"
+	}
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return "database.unknown"
+	}
+	name := fn.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Replace(name, "(*DB).", "", 1)
+	// Drop the suffix of closures, such as ".func1".
+	if i := strings.Index(name, ".func"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// withTimeout applies the database's operation timeout, if any, to ctx.
+func (db *DB) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.operationTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, db.operationTimeout)
+}
@@ -0,0 +1,36 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"testing"
+)
+
+func (db *DB) namedOperation() string {
+	return operationName(1)
+}
+
+func TestOperationName(t *testing.T) {
+	if got, want := (&DB{}).namedOperation(), "database.namedOperation"; got != want {
+		t.Errorf("method: got %q, want %q", got, want)
+	}
+
+	closure := func() string {
+		return operationName(1)
+	}
+	if got, want := closure(), "database.TestOperationName"; got != want {
+		t.Errorf("closure: got %q, want %q", got, want)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/golang-migrate/migrate/v4"
@@ -61,6 +62,9 @@ func CreateTestDB(ctx context.Context) (*DB, error) {
 	}
 	// Override DB name.
 	config.Name = "postgres"
+	if config.CursorSecret == "" {
+		config.CursorSecret = "test-cursor-secret"
+	}
 	db, err := NewFromEnv(ctx, &config)
 	if err != nil {
 		return nil, err
@@ -76,7 +80,10 @@ func CreateTestDB(ctx context.Context) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	const source = "file://../../../migrations"
+	source, err := migrationsSource()
+	if err != nil {
+		return nil, err
+	}
 	uri := DbURI(&config)
 	m, err := migrate.New(source, uri)
 	if err != nil {
@@ -96,6 +103,26 @@ func CreateTestDB(ctx context.Context) (*DB, error) {
 	return db, nil
 }
 
+// migrationsSource returns the migrate source of the migrations directory at
+// the root of the module, so that packages at any depth can create test
+// databases.
+func migrationsSource() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return "file://" + filepath.Join(dir, "migrations"), nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no go.mod above the working directory")
+		}
+		dir = parent
+	}
+}
+
 func createDatabase(ctx context.Context, db *DB, name string) error {
 	conn, err := db.Pool.Acquire(ctx)
 	if err != nil {
@@ -118,7 +145,7 @@ func mustExec(t *testing.T, conn *pgxpool.Conn, stmt string, args ...interface{}
 	}
 }
 
-func ResetTestDB(t *testing.T, testDB *DB) {
+func ResetTestDB(t testing.TB, testDB *DB) {
 	t.Helper()
 	ctx := context.Background()
 	conn, err := testDB.Pool.Acquire(ctx)
@@ -129,9 +156,12 @@ func ResetTestDB(t *testing.T, testDB *DB) {
 
 	_, err = conn.Exec(ctx, `
 		TRUNCATE
-			FederationInQuery, FederationInSync, FederationOutAuthorization,
-			Exposure, AuthorizedApp,
-			ExportConfig, ExportBatch, ExportFile
+			FederationInQuery, FederationInSync, FederationOutAuthorization, FederationOutUsage, FederationPushTarget, EFGSDownload,
+			Exposure, AuthorizedApp, HealthAuthority, HealthAuthorityKey,
+			ExportConfig, ExportBatch, ExportFile, ExportBatchLease, ExportBatchStats,
+			ExposureOutbox, ExposureKeyEncryptionKey, ExposureKeyHashKey, RevisionTokenKey,
+			PublishIdempotency, APIKey, VerificationCertificateUse,
+			ConfigSetting, ConfigVersion, AuditLog, ScheduledJob
 	`)
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,76 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// AddEFGSDownload records a downloaded gateway batch.
+func (db *DB) AddEFGSDownload(ctx context.Context, d *EFGSDownload) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO
+				EFGSDownload
+				(batch_tag, batch_date, next_batch_tag, sync_id, keys, downloaded_at)
+			VALUES
+				($1, $2, NULLIF($3, ''), NULLIF($4, 0), $5, $6)
+			ON CONFLICT
+				(batch_tag)
+			DO UPDATE
+				SET next_batch_tag = NULLIF($3, ''), downloaded_at = $6
+		`, d.BatchTag, d.BatchDate, d.NextBatchTag, d.SyncID, d.Keys, d.DownloadedAt)
+		if err != nil {
+			return fmt.Errorf("inserting efgs download: %w", err)
+		}
+		return nil
+	})
+}
+
+// LastEFGSDownload returns the most recently downloaded batch of the given
+// day, or ErrNotFound if none was downloaded yet.
+func (db *DB) LastEFGSDownload(ctx context.Context, date time.Time) (*EFGSDownload, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(ctx, `
+		SELECT
+			batch_tag, batch_date, COALESCE(next_batch_tag, ''), COALESCE(sync_id, 0), keys, downloaded_at
+		FROM
+			EFGSDownload
+		WHERE
+			batch_date = $1
+		ORDER BY
+			downloaded_at DESC
+		LIMIT 1
+		`, date)
+
+	var d EFGSDownload
+	if err := row.Scan(&d.BatchTag, &d.BatchDate, &d.NextBatchTag, &d.SyncID, &d.Keys, &d.DownloadedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("scanning results: %w", err)
+	}
+	return &d, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEFGSDownload(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	day := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := testDB.LastEFGSDownload(ctx, day); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	downloads := []*EFGSDownload{
+		{BatchTag: "b1", BatchDate: day, NextBatchTag: "b2", Keys: 10, DownloadedAt: now},
+		{BatchTag: "b2", BatchDate: day, Keys: 5, DownloadedAt: now.Add(time.Minute)},
+		{BatchTag: "c1", BatchDate: day.AddDate(0, 0, 1), Keys: 1, DownloadedAt: now.Add(2 * time.Minute)},
+	}
+	for _, d := range downloads {
+		if err := testDB.AddEFGSDownload(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := testDB.LastEFGSDownload(ctx, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.BatchTag != "b2" || got.NextBatchTag != "" || got.Keys != 5 {
+		t.Errorf("got batch %s with next %q and %d keys, want b2 with no next and 5 keys", got.BatchTag, got.NextBatchTag, got.Keys)
+	}
+
+	// Downloading the day's last batch again records its new next batch and
+	// keeps its keys.
+	if err := testDB.AddEFGSDownload(ctx, &EFGSDownload{BatchTag: "b2", BatchDate: day, NextBatchTag: "b3", DownloadedAt: now.Add(3 * time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = testDB.LastEFGSDownload(ctx, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.BatchTag != "b2" || got.NextBatchTag != "b3" || got.Keys != 5 {
+		t.Errorf("got batch %s with next %q and %d keys, want b2 with next b3 and 5 keys", got.BatchTag, got.NextBatchTag, got.Keys)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The functions in this file count the rows that the corresponding Delete
+// functions would delete, so cleanup can report what it would do without
+// doing it.
+
+// CountExposuresBefore returns the number of exposures DeleteExposures would
+// delete.
+func (db *DB) CountExposuresBefore(ctx context.Context, before time.Time) (int64, error) {
+	return db.countRows(ctx, `SELECT COUNT(*) FROM Exposure WHERE created_at < $1`, before)
+}
+
+// CountExposuresToTombstone returns the number of exposures
+// TombstoneExposures would tombstone.
+func (db *DB) CountExposuresToTombstone(ctx context.Context, before time.Time) (int64, error) {
+	return db.countRows(ctx, `SELECT COUNT(*) FROM Exposure WHERE created_at < $1 AND deleted_at IS NULL`, before)
+}
+
+// CountExposuresToPurge returns the number of exposures PurgeExposures would
+// delete.
+func (db *DB) CountExposuresToPurge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return db.countRows(ctx, `SELECT COUNT(*) FROM Exposure WHERE deleted_at < $1`, deletedBefore)
+}
+
+// CountIdempotencyRecordsBefore returns the number of records
+// DeleteIdempotencyRecords would delete.
+func (db *DB) CountIdempotencyRecordsBefore(ctx context.Context, before time.Time) (int64, error) {
+	return db.countRows(ctx, `SELECT COUNT(*) FROM PublishIdempotency WHERE created_at < $1`, before)
+}
+
+// CountExpiredCertificateUses returns the number of records
+// DeleteExpiredCertificateUses would delete.
+func (db *DB) CountExpiredCertificateUses(ctx context.Context, before time.Time) (int64, error) {
+	return db.countRows(ctx, `SELECT COUNT(*) FROM VerificationCertificateUse WHERE expires_at < $1`, before)
+}
+
+// CountFederationInSyncsBefore returns the numbers of syncs and gateway batch
+// records DeleteFederationInSyncsBefore would delete.
+func (db *DB) CountFederationInSyncsBefore(ctx context.Context, before time.Time) (int64, int64, error) {
+	syncs, err := db.countRows(ctx, `SELECT COUNT(*) FROM FederationInSync fs WHERE `+expiredFederationInSync, before)
+	if err != nil {
+		return 0, 0, err
+	}
+	downloads, err := db.countRows(ctx, `SELECT COUNT(*) FROM EFGSDownload WHERE downloaded_at < $1`, before)
+	if err != nil {
+		return 0, 0, err
+	}
+	return syncs, downloads, nil
+}
+
+// CountExportBatchesBefore returns the number of batches
+// DeleteExportBatchesBefore would delete.
+func (db *DB) CountExportBatchesBefore(ctx context.Context, before time.Time) (int64, error) {
+	return db.countRows(ctx, `SELECT COUNT(*) FROM ExportBatch WHERE end_timestamp < $1 AND status = $2`, before, ExportBatchDeleted)
+}
+
+func (db *DB) countRows(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	var count int64
+	if err := conn.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("counting rows: %w", err)
+	}
+	return count, nil
+}
@@ -22,7 +22,6 @@ import (
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/logging"
-	"github.com/google/exposure-notifications-server/internal/storage"
 
 	pgx "github.com/jackc/pgx/v4"
 )
@@ -44,20 +43,37 @@ func (db *DB) AddExportConfig(ctx context.Context, ec *ExportConfig) error {
 		return errors.New("period must divide equally into 24 hours (e.g., 2h, 4h, 12h, 15m, 30m)")
 	}
 
+	for _, rt := range ec.IncludeReportTypes {
+		if !ValidReportType(rt) {
+			return fmt.Errorf("invalid report type %q", rt)
+		}
+	}
+	if ec.MinTransmissionRisk < 0 {
+		return errors.New("minimum transmission risk must not be negative")
+	}
+
 	var thru *time.Time
 	if !ec.Thru.IsZero() {
 		thru = &ec.Thru
 	}
 	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		if ec.DeltaOfConfigID != 0 {
+			if err := validateDeltaOf(ctx, tx, ec); err != nil {
+				return err
+			}
+		}
+
 		row := tx.QueryRow(ctx, `
 			INSERT INTO
 				ExportConfig
-				(bucket_name, filename_root, period_seconds, region, from_timestamp, thru_timestamp, signature_info_ids)
+				(bucket_name, filename_root, period_seconds, region, from_timestamp, thru_timestamp, signature_info_ids,
+				 health_authority_id, include_travelers, include_report_types, min_transmission_risk, delta_of_config_id)
 			VALUES
-				($1, $2, $3, $4, $5, $6, $7)
+				($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12::INT, 0))
 			RETURNING config_id
 		`, ec.BucketName, ec.FilenameRoot, int(ec.Period.Seconds()), ec.Region,
-			ec.From, thru, ec.SignatureInfoIDs)
+			ec.From, thru, ec.SignatureInfoIDs, ec.HealthAuthorityID, ec.IncludeTravelers,
+			ec.IncludeReportTypes, ec.MinTransmissionRisk, ec.DeltaOfConfigID)
 
 		if err := row.Scan(&ec.ConfigID); err != nil {
 			return fmt.Errorf("fetching config_id: %w", err)
@@ -66,6 +82,42 @@ func (db *DB) AddExportConfig(ctx context.Context, ec *ExportConfig) error {
 	})
 }
 
+// validateDeltaOf checks that the full config of a delta config exists, is not
+// a delta itself, and is for the same region with a period that is a multiple
+// of the delta's, so each full batch covers a whole number of deltas.
+func validateDeltaOf(ctx context.Context, tx pgx.Tx, ec *ExportConfig) error {
+	var (
+		region        string
+		periodSeconds int
+		deltaOf       int64
+	)
+	row := tx.QueryRow(ctx, `
+		SELECT
+			region, period_seconds, COALESCE(delta_of_config_id, 0)
+		FROM
+			ExportConfig
+		WHERE
+			config_id = $1
+		`, ec.DeltaOfConfigID)
+	if err := row.Scan(&region, &periodSeconds, &deltaOf); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("full config %d: %w", ec.DeltaOfConfigID, ErrNotFound)
+		}
+		return fmt.Errorf("looking up full config %d: %w", ec.DeltaOfConfigID, err)
+	}
+
+	period := time.Duration(periodSeconds) * time.Second
+	switch {
+	case deltaOf != 0:
+		return fmt.Errorf("full config %d is itself a delta config", ec.DeltaOfConfigID)
+	case region != ec.Region:
+		return fmt.Errorf("full config %d is for region %q, not %q", ec.DeltaOfConfigID, region, ec.Region)
+	case period <= ec.Period || period%ec.Period != 0:
+		return fmt.Errorf("full config %d period %v must be a multiple of the delta period %v", ec.DeltaOfConfigID, period, ec.Period)
+	}
+	return nil
+}
+
 // IterateExportConfigs applies f to each ExportConfig whose FromTimestamp is
 // before the given time. If f returns a non-nil error, the iteration stops, and
 // the returned error will match f's error with errors.Is.
@@ -84,7 +136,8 @@ func (db *DB) IterateExportConfigs(ctx context.Context, t time.Time, f func(*Exp
 
 	rows, err := conn.Query(ctx, `
 		SELECT
-			config_id, bucket_name, filename_root, period_seconds, region, from_timestamp, thru_timestamp, signature_info_ids
+			config_id, bucket_name, filename_root, period_seconds, region, from_timestamp, thru_timestamp, signature_info_ids,
+			health_authority_id, include_travelers, include_report_types, min_transmission_risk, COALESCE(delta_of_config_id, 0)
 		FROM
 			ExportConfig
 		WHERE
@@ -97,52 +150,193 @@ func (db *DB) IterateExportConfigs(ctx context.Context, t time.Time, f func(*Exp
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var (
-			m             ExportConfig
-			periodSeconds int
-			thru          *time.Time
-		)
-		if err := rows.Scan(&m.ConfigID, &m.BucketName, &m.FilenameRoot, &periodSeconds, &m.Region, &m.From, &thru, &m.SignatureInfoIDs); err != nil {
+		m, err := scanExportConfig(rows)
+		if err != nil {
 			return err
 		}
-		m.Period = time.Duration(periodSeconds) * time.Second
-		if thru != nil {
-			m.Thru = *thru
-		}
-		if err := f(&m); err != nil {
+		if err := f(m); err != nil {
 			return err
 		}
 	}
 	return rows.Err()
 }
 
+// ListExportConfigs returns every ExportConfig, including those that have
+// ended, ordered by ID.
+func (db *DB) ListExportConfigs(ctx context.Context) ([]*ExportConfig, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			config_id, bucket_name, filename_root, period_seconds, region, from_timestamp, thru_timestamp, signature_info_ids,
+			health_authority_id, include_travelers, include_report_types, min_transmission_risk, COALESCE(delta_of_config_id, 0)
+		FROM
+			ExportConfig
+		ORDER BY
+			config_id
+		`)
+	if err != nil {
+		return nil, fmt.Errorf("listing export configs: %w", err)
+	}
+	defer rows.Close()
+
+	var configs []*ExportConfig
+	for rows.Next() {
+		m, err := scanExportConfig(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning export config: %w", err)
+		}
+		configs = append(configs, m)
+	}
+	return configs, rows.Err()
+}
+
+func scanExportConfig(row pgx.Row) (*ExportConfig, error) {
+	var (
+		m             ExportConfig
+		periodSeconds int
+		thru          *time.Time
+	)
+	if err := row.Scan(&m.ConfigID, &m.BucketName, &m.FilenameRoot, &periodSeconds, &m.Region, &m.From, &thru, &m.SignatureInfoIDs,
+		&m.HealthAuthorityID, &m.IncludeTravelers, &m.IncludeReportTypes, &m.MinTransmissionRisk, &m.DeltaOfConfigID); err != nil {
+		return nil, err
+	}
+	m.Period = time.Duration(periodSeconds) * time.Second
+	if thru != nil {
+		m.Thru = *thru
+	}
+	return &m, nil
+}
+
+// UpdateExportConfig sets the end and the signature infos of an existing
+// ExportConfig. Its other fields decide the batches it has already created and
+// can't be changed. A zero Thru means the config never ends.
+func (db *DB) UpdateExportConfig(ctx context.Context, ec *ExportConfig) error {
+	var thru *time.Time
+	if !ec.Thru.IsZero() {
+		thru = &ec.Thru
+	}
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			UPDATE
+				ExportConfig
+			SET
+				thru_timestamp = $2, signature_info_ids = $3
+			WHERE
+				config_id = $1
+			`, ec.ConfigID, thru, ec.SignatureInfoIDs)
+		if err != nil {
+			return fmt.Errorf("updating export config: %w", err)
+		}
+		if result.RowsAffected() != 1 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
 func (db *DB) AddSignatureInfo(ctx context.Context, si *SignatureInfo) error {
 	if si.SigningKey == "" {
 		return fmt.Errorf("signing key cannot be empty for a signature info")
 	}
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		return addSignatureInfo(ctx, tx, si)
+	})
+}
 
-	var thru *time.Time
+func addSignatureInfo(ctx context.Context, tx pgx.Tx, si *SignatureInfo) error {
+	var from, thru *time.Time
+	if !si.StartTimestamp.IsZero() {
+		from = &si.StartTimestamp
+	}
 	if !si.EndTimestamp.IsZero() {
 		thru = &si.EndTimestamp
 	}
+	row := tx.QueryRow(ctx, `
+    INSERT INTO
+      SignatureInfo
+      (signing_key, app_package_name, bundle_id, signing_key_version, signing_key_id, from_timestamp, thru_timestamp, auto_rotate)
+    VALUES
+      ($1, $2, $3, $4, $5, $6, $7, $8)
+    RETURNING id
+  `, si.SigningKey, si.AppPackageName, si.BundleID, si.SigningKeyVersion, si.SigningKeyID, from, thru, si.AutoRotate)
+
+	if err := row.Scan(&si.ID); err != nil {
+		return fmt.Errorf("fetching id: %w", err)
+	}
+	return nil
+}
+
+// UpdateSignatureInfoEnd sets the time at which a signature info expires. This
+// retires the old key once a new one has been rolled out. A zero thru means
+// the key never expires.
+func (db *DB) UpdateSignatureInfoEnd(ctx context.Context, id int64, thru time.Time) error {
+	var thruPtr *time.Time
+	if !thru.IsZero() {
+		thruPtr = &thru
+	}
 	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
-		row := tx.QueryRow(ctx, `
-      INSERT INTO
+		result, err := tx.Exec(ctx, `
+      UPDATE
         SignatureInfo
-        (signing_key, app_package_name, bundle_id, signing_key_version, signing_key_id, thru_timestamp)
-      VALUES
-        ($1, $2, $3, $4, $5, $6)
-      RETURNING id
-    `, si.SigningKey, si.AppPackageName, si.BundleID, si.SigningKeyVersion, si.SigningKeyID, thru)
+      SET
+        thru_timestamp = $2
+      WHERE
+        id = $1
+    `, id, thruPtr)
+		if err != nil {
+			return fmt.Errorf("updating signature info: %w", err)
+		}
+		if result.RowsAffected() != 1 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
 
-		if err := row.Scan(&si.ID); err != nil {
-			return fmt.Errorf("fetching id: %w", err)
+// AddExportConfigSignatureInfo adds a signature info to an export config.
+// Batches created after this sign their export files with the new key as well
+// as the config's existing keys. Adding a signature info twice has no effect.
+func (db *DB) AddExportConfigSignatureInfo(ctx context.Context, configID, signatureInfoID int64) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+      UPDATE
+        ExportConfig
+      SET
+        signature_info_ids = array_append(signature_info_ids, $2::INT)
+      WHERE
+        config_id = $1 AND NOT ($2::INT = any(COALESCE(signature_info_ids, '{}')))
+    `, configID, signatureInfoID)
+		if err != nil {
+			return fmt.Errorf("updating export config: %w", err)
+		}
+		if result.RowsAffected() == 1 {
+			return nil
+		}
+
+		// Distinguish a missing config from one that already has the key.
+		var exists bool
+		if err := tx.QueryRow(ctx, `
+      SELECT EXISTS(SELECT 1 FROM ExportConfig WHERE config_id = $1)
+    `, configID).Scan(&exists); err != nil {
+			return fmt.Errorf("checking export config: %w", err)
+		}
+		if !exists {
+			return ErrNotFound
 		}
 		return nil
 	})
 }
 
-func (db *DB) LookupSignatureInfos(ctx context.Context, ids []int64, validUntil time.Time) ([]*SignatureInfo, error) {
+// LookupSignatureInfos returns the signature infos with the given IDs that are
+// active at the given time, ordered by ID so that export files list them in a
+// stable order. While a signing key is rotated, both the old and the new key
+// are active and every export file carries a signature from each.
+func (db *DB) LookupSignatureInfos(ctx context.Context, ids []int64, at time.Time) ([]*SignatureInfo, error) {
 	conn, err := db.Pool.Acquire(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("acquiring connection: %w", err)
@@ -151,15 +345,20 @@ func (db *DB) LookupSignatureInfos(ctx context.Context, ids []int64, validUntil
 
 	rows, err := conn.Query(ctx, `
     SELECT
-      id, signing_key, app_package_name, bundle_id, signing_key_version, signing_key_id, thru_timestamp
+      id, signing_key, app_package_name, bundle_id, signing_key_version, signing_key_id, from_timestamp, thru_timestamp
     FROM
       SignatureInfo
     WHERE
-      id = any($1) AND (thru_timestamp is NULL OR thru_timestamp >= $2)
-  `, ids, validUntil)
+      id = any($1) AND
+      (from_timestamp IS NULL OR from_timestamp <= $2) AND
+      (thru_timestamp IS NULL OR thru_timestamp >= $2)
+    ORDER BY
+      id
+  `, ids, at)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var sigInfos []*SignatureInfo
 	for rows.Next() {
@@ -167,17 +366,20 @@ func (db *DB) LookupSignatureInfos(ctx context.Context, ids []int64, validUntil
 			return nil, rows.Err()
 		}
 		var info SignatureInfo
-		var thru *time.Time
-		if err := rows.Scan(&info.ID, &info.SigningKey, &info.AppPackageName, &info.BundleID, &info.SigningKeyVersion, &info.SigningKeyID, &thru); err != nil {
+		var from, thru *time.Time
+		if err := rows.Scan(&info.ID, &info.SigningKey, &info.AppPackageName, &info.BundleID, &info.SigningKeyVersion, &info.SigningKeyID, &from, &thru); err != nil {
 			return nil, err
 		}
+		if from != nil {
+			info.StartTimestamp = *from
+		}
 		if thru != nil {
 			info.EndTimestamp = *thru
 		}
 		sigInfos = append(sigInfos, &info)
 	}
 
-	return sigInfos, nil
+	return sigInfos, rows.Err()
 }
 
 // LatestExportBatchEnd returns the end time of the most recent ExportBatch for
@@ -213,137 +415,105 @@ func (db *DB) LatestExportBatchEnd(ctx context.Context, ec *ExportConfig) (time.
 	return latestEnd, nil
 }
 
-// AddExportBatches inserts new export batches.
-func (db *DB) AddExportBatches(ctx context.Context, batches []*ExportBatch) error {
-	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
-		const stmtName = "insert export batches"
-		_, err := tx.Prepare(ctx, stmtName, `
-			INSERT INTO
-				ExportBatch
-				(config_id, bucket_name, filename_root, start_timestamp, end_timestamp, region, status, signature_info_ids)
-			VALUES
-				($1, $2, $3, $4, $5, $6, $7, $8)
-		`)
-		if err != nil {
-			return err
-		}
+// PreviousExportBatchHorizon returns the exposure horizon of the completed
+// batch of eb's config and region that ends where eb starts, or zero if there
+// is no such batch or its horizon is unknown.
+func (db *DB) PreviousExportBatchHorizon(ctx context.Context, eb *ExportBatch) (int64, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
 
-		for _, eb := range batches {
-			if _, err := tx.Exec(ctx, stmtName,
-				eb.ConfigID, eb.BucketName, eb.FilenameRoot, eb.StartTimestamp, eb.EndTimestamp, eb.Region, eb.Status, eb.SignatureInfoIDs); err != nil {
-				return err
-			}
-		}
-		return nil
-	})
-}
+	row := conn.QueryRow(ctx, `
+		SELECT
+			exposure_horizon
+		FROM
+			ExportBatch
+		WHERE
+			config_id = $1 AND region = $2 AND end_timestamp = $3 AND
+			status = $4 AND exposure_horizon IS NOT NULL
+		ORDER BY
+			batch_id DESC
+		LIMIT 1
+		`, eb.ConfigID, eb.Region, eb.StartTimestamp, ExportBatchComplete)
 
-// LeaseBatch returns a leased ExportBatch for the worker to process. If no work to do, nil will be returned.
-func (db *DB) LeaseBatch(ctx context.Context, ttl time.Duration, now time.Time) (*ExportBatch, error) {
-	// Lookup a set of candidate batch IDs.
-	var openBatchIDs []int64
-	err := func() error { // Use a func to allow defer conn.Release() to work.
-		conn, err := db.Pool.Acquire(ctx)
-		if err != nil {
-			return fmt.Errorf("acquiring connection: %w", err)
+	var horizon int64
+	if err := row.Scan(&horizon); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
 		}
-		defer conn.Release()
+		return 0, fmt.Errorf("scanning result: %w", err)
+	}
+	return horizon, nil
+}
 
-		// Query for batches that are OPEN or PENDING with expired lease. Also, only return batches with end timestamp
-		// in the past (i.e., the batch is complete).
-		rows, err := conn.Query(ctx, `
+// AddExportBatchesAfter inserts new export batches for the config, provided
+// the config's latest batch still ends at latestEnd. If another process added
+// batches for the config since latestEnd was read, no batches are inserted
+// and ErrExportBatchesExist is returned, so that concurrent batchers don't
+// create the same batches twice.
+func (db *DB) AddExportBatchesAfter(ctx context.Context, ec *ExportConfig, latestEnd time.Time, batches []*ExportBatch) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `
 			SELECT
-				batch_id
+				MAX(end_timestamp)
 			FROM
 				ExportBatch
 			WHERE
-			    (
-					status = $1
-					OR
-					(status = $2 AND lease_expires < $3)
-				)
-			AND
-				end_timestamp < $3
-			LIMIT 100
-		`, ExportBatchOpen, ExportBatchPending, now)
-		if err != nil {
-			return err
+				config_id = $1
+			`, ec.ConfigID)
+		var currentEnd *time.Time
+		if err := row.Scan(&currentEnd); err != nil {
+			return fmt.Errorf("scanning result: %w", err)
+		}
+		if currentEnd != nil && !currentEnd.Equal(latestEnd) {
+			return ErrExportBatchesExist
 		}
+		return insertExportBatches(ctx, tx, batches)
+	})
+}
 
-		for rows.Next() {
-			if err := rows.Err(); err != nil {
-				return fmt.Errorf("iterating rows: %w", err)
-			}
+// AddExportBatches inserts new export batches.
+func (db *DB) AddExportBatches(ctx context.Context, batches []*ExportBatch) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		return insertExportBatches(ctx, tx, batches)
+	})
+}
 
-			var id int64
-			if err := rows.Scan(&id); err != nil {
-				return err
-			}
-			openBatchIDs = append(openBatchIDs, id)
-		}
-		return rows.Err()
-	}()
+func insertExportBatches(ctx context.Context, tx pgx.Tx, batches []*ExportBatch) error {
+	const stmtName = "insert export batches"
+	_, err := tx.Prepare(ctx, stmtName, `
+		INSERT INTO
+			ExportBatch
+			(config_id, bucket_name, filename_root, start_timestamp, end_timestamp, region, status, signature_info_ids,
+			 health_authority_id, include_travelers, include_report_types, min_transmission_risk, delta_of_config_id)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13::INT, 0))
+	`)
 	if err != nil {
-		return nil, err
-	}
-
-	if len(openBatchIDs) == 0 {
-		return nil, nil
+		return err
 	}
 
-	// Randomize openBatchIDs so that workers aren't competing for the same job.
-	openBatchIDs = shuffle(openBatchIDs)
-
-	for _, bid := range openBatchIDs {
-		// In a serialized transaction, fetch the existing batch and make sure it can be leased, then lease it.
-		leased := false
-		err := db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
-
-			row := tx.QueryRow(ctx, `
-				SELECT
-					status, lease_expires
-				FROM
-					ExportBatch
-				WHERE
-					batch_id = $1
-				`, bid)
-
-			var status string
-			var expires *time.Time
-			if err := row.Scan(&status, &expires); err != nil {
-				return err
-			}
-
-			if status == ExportBatchComplete || (expires != nil && status == ExportBatchPending && now.Before(*expires)) {
-				// Something beat us to this batch, it's no longer available.
-				return nil
-			}
-
-			_, err = tx.Exec(ctx, `
-				UPDATE
-					ExportBatch
-				SET
-					status = $1, lease_expires = $2
-				WHERE
-				    batch_id = $3
-				`, ExportBatchPending, now.Add(ttl), bid)
-			if err != nil {
-				return err
-			}
-
-			leased = true
-			return nil
-		})
-		if err != nil {
-			return nil, err
+	for _, eb := range batches {
+		if _, err := tx.Exec(ctx, stmtName,
+			eb.ConfigID, eb.BucketName, eb.FilenameRoot, eb.StartTimestamp, eb.EndTimestamp, eb.Region, eb.Status, eb.SignatureInfoIDs,
+			eb.HealthAuthorityID, eb.IncludeTravelers, eb.IncludeReportTypes, eb.MinTransmissionRisk, eb.DeltaOfConfigID); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		if leased {
-			return db.LookupExportBatch(ctx, bid)
-		}
+// LeaseBatch returns a leased ExportBatch for the worker to process. If no work to do, nil will be returned.
+// Workers should use ClaimBatch instead, whose lease guards the completion of
+// the batch.
+func (db *DB) LeaseBatch(ctx context.Context, ttl time.Duration, now time.Time) (*ExportBatch, error) {
+	lease, err := db.claimBatch(ctx, ttl, now, now)
+	if err != nil || lease == nil {
+		return nil, err
 	}
-	// We didn't manage to lease any of the candidates, so return no work to be done (nil).
-	return nil, nil
+	return lease.Batch, nil
 }
 
 // LookupExportBatch returns an ExportBatch for the given batchID.
@@ -360,7 +530,8 @@ func (db *DB) LookupExportBatch(ctx context.Context, batchID int64) (*ExportBatc
 func lookupExportBatch(ctx context.Context, batchID int64, queryRow queryRowFn) (*ExportBatch, error) {
 	row := queryRow(ctx, `
 		SELECT
-			batch_id, config_id, bucket_name, filename_root, start_timestamp, end_timestamp, region, status, lease_expires, signature_info_ids
+			batch_id, config_id, bucket_name, filename_root, start_timestamp, end_timestamp, region, status, lease_expires, signature_info_ids,
+			health_authority_id, include_travelers, include_report_types, min_transmission_risk, COALESCE(delta_of_config_id, 0)
 		FROM
 			ExportBatch
 		WHERE
@@ -370,7 +541,8 @@ func lookupExportBatch(ctx context.Context, batchID int64, queryRow queryRowFn)
 
 	var expires *time.Time
 	eb := ExportBatch{}
-	if err := row.Scan(&eb.BatchID, &eb.ConfigID, &eb.BucketName, &eb.FilenameRoot, &eb.StartTimestamp, &eb.EndTimestamp, &eb.Region, &eb.Status, &expires, &eb.SignatureInfoIDs); err != nil {
+	if err := row.Scan(&eb.BatchID, &eb.ConfigID, &eb.BucketName, &eb.FilenameRoot, &eb.StartTimestamp, &eb.EndTimestamp, &eb.Region, &eb.Status, &expires, &eb.SignatureInfoIDs,
+		&eb.HealthAuthorityID, &eb.IncludeTravelers, &eb.IncludeReportTypes, &eb.MinTransmissionRisk, &eb.DeltaOfConfigID); err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, ErrNotFound
 		}
@@ -383,38 +555,91 @@ func lookupExportBatch(ctx context.Context, batchID int64, queryRow queryRowFn)
 }
 
 // FinalizeBatch writes the ExportFile records and marks the ExportBatch as complete.
-func (db *DB) FinalizeBatch(ctx context.Context, eb *ExportBatch, files []string, batchSize int) error {
+// sizes holds the size in bytes of each file and checksums the hex encoded
+// SHA-256 of each file. Either may be nil if they are unknown.
+func (db *DB) FinalizeBatch(ctx context.Context, eb *ExportBatch, files []string, sizes []int64, checksums []string, batchSize int) error {
 	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
-		// Update ExportFile for the files created.
-		for i, file := range files {
-			ef := ExportFile{
-				BucketName: eb.BucketName,
-				Filename:   file,
-				BatchID:    eb.BatchID,
-				Region:     eb.Region,
-				BatchNum:   i + 1,
-				BatchSize:  batchSize,
-				Status:     ExportBatchComplete,
-			}
-			if err := addExportFile(ctx, tx, &ef); err != nil {
-				if err == ErrKeyConflict {
-					logging.FromContext(ctx).Infof("ExportFile %q already exists in database, skipping without overwriting. This can occur when reprocessing a failed batch.", file)
-				} else {
-					return fmt.Errorf("adding export file entry: %w", err)
-				}
+		return finalizeBatch(ctx, tx, eb, files, sizes, checksums, batchSize)
+	})
+}
+
+func finalizeBatch(ctx context.Context, tx pgx.Tx, eb *ExportBatch, files []string, sizes []int64, checksums []string, batchSize int) error {
+	// A re-exported batch replaces the files it was finalized with before.
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM
+			ExportFile
+		WHERE
+			batch_id = $1
+		`, eb.BatchID); err != nil {
+		return fmt.Errorf("deleting previous export files: %w", err)
+	}
+
+	// Update ExportFile for the files created.
+	for i, file := range files {
+		ef := ExportFile{
+			BucketName: eb.BucketName,
+			Filename:   file,
+			BatchID:    eb.BatchID,
+			Region:     eb.Region,
+			BatchNum:   i + 1,
+			BatchSize:  batchSize,
+			Status:     ExportBatchComplete,
+		}
+		if i < len(sizes) {
+			ef.SizeBytes = sizes[i]
+		}
+		if i < len(checksums) {
+			ef.SHA256 = checksums[i]
+		}
+		if err := addExportFile(ctx, tx, &ef); err != nil {
+			if err == ErrKeyConflict {
+				logging.FromContext(ctx).Infof("ExportFile %q already exists in database, skipping without overwriting. This can occur when reprocessing a failed batch.", file)
+			} else {
+				return fmt.Errorf("adding export file entry: %w", err)
 			}
 		}
+	}
 
-		// Update ExportBatch to mark it complete.
-		if err := completeBatch(ctx, tx, eb.BatchID); err != nil {
-			return fmt.Errorf("marking batch %v complete: %w", eb.BatchID, err)
+	if eb.ExposureHorizon > 0 {
+		if _, err := tx.Exec(ctx, `
+			UPDATE
+				ExportBatch
+			SET
+				exposure_horizon = $2
+			WHERE
+				batch_id = $1
+			`, eb.BatchID, eb.ExposureHorizon); err != nil {
+			return fmt.Errorf("recording exposure horizon: %w", err)
 		}
-		return nil
-	})
+	}
+
+	// Update ExportBatch to mark it complete.
+	if err := completeBatch(ctx, tx, eb.BatchID); err != nil {
+		return fmt.Errorf("marking batch %v complete: %w", eb.BatchID, err)
+	}
+	return nil
 }
 
-// LookupExportFiles returns a list of export files for the given ExportConfig exportConfigID.
+// LookupExportFiles returns a list of export files for the given ExportConfig
+// exportConfigID, in the order of LookupExportIndexEntries.
 func (db *DB) LookupExportFiles(ctx context.Context, exportConfigID int64) ([]string, error) {
+	entries, err := db.LookupExportIndexEntries(ctx, exportConfigID)
+	if err != nil {
+		return nil, err
+	}
+	filenames := make([]string, 0, len(entries))
+	for _, e := range entries {
+		filenames = append(filenames, e.Filename)
+	}
+	return filenames, nil
+}
+
+// LookupExportIndexEntries returns the files of the completed batches of the
+// given ExportConfig that have not been deleted, ordered by the start of their
+// batch and then by their number within the batch. For a delta config, only
+// the files of batches that start at or after the end of the latest complete
+// batch of its full config are returned.
+func (db *DB) LookupExportIndexEntries(ctx context.Context, exportConfigID int64) ([]*ExportIndexEntry, error) {
 	conn, err := db.Pool.Acquire(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("acquiring connection: %w", err)
@@ -423,7 +648,7 @@ func (db *DB) LookupExportFiles(ctx context.Context, exportConfigID int64) ([]st
 
 	rows, err := conn.Query(ctx, `
 		SELECT
-			ef.filename
+			ef.filename, ef.batch_id, eb.start_timestamp, eb.end_timestamp, ef.batch_num
 		FROM
 			ExportFile ef
 		INNER JOIN
@@ -432,35 +657,41 @@ func (db *DB) LookupExportFiles(ctx context.Context, exportConfigID int64) ([]st
 			eb.config_id = $1
 		AND
 			eb.status = $2
+		AND
+			ef.status != $3
+		AND
+			eb.start_timestamp >= COALESCE((
+				SELECT
+					MAX(fb.end_timestamp)
+				FROM
+					ExportConfig ec
+				INNER JOIN
+					ExportBatch fb ON (fb.config_id = ec.delta_of_config_id)
+				WHERE
+					ec.config_id = $1
+				AND
+					fb.status = $2
+			), '-infinity'::TIMESTAMPTZ)
 		ORDER BY
-			ef.filename
-		`, exportConfigID, ExportBatchComplete)
+			eb.start_timestamp, ef.batch_num, ef.filename
+		`, exportConfigID, ExportBatchComplete, ExportBatchDeleted)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var filenames []string
+	var entries []*ExportIndexEntry
 	for rows.Next() {
 		if rows.Err() != nil {
 			return nil, rows.Err()
 		}
-		var filename string
-		if err := rows.Scan(&filename); err != nil {
+		var e ExportIndexEntry
+		if err := rows.Scan(&e.Filename, &e.BatchID, &e.BatchStart, &e.BatchEnd, &e.BatchNum); err != nil {
 			return nil, err
 		}
-		filenames = append(filenames, filename)
+		entries = append(entries, &e)
 	}
-	return filenames, nil
-}
-
-type joinedExportBatchFile struct {
-	bucketName  string
-	filename    string
-	batchID     int64
-	count       int
-	fileStatus  string
-	batchStatus string
+	return entries, rows.Err()
 }
 
 func (db *DB) LookupExportFile(ctx context.Context, filename string) (*ExportFile, error) {
@@ -472,7 +703,7 @@ func (db *DB) LookupExportFile(ctx context.Context, filename string) (*ExportFil
 
 	row := conn.QueryRow(ctx, `
 		SELECT
-			bucket_name, filename, batch_id, region, batch_num, batch_size, status
+			bucket_name, filename, batch_id, region, batch_num, batch_size, status, COALESCE(size_bytes, 0), COALESCE(sha256, '')
 		FROM
 			ExportFile
 		WHERE
@@ -481,7 +712,7 @@ func (db *DB) LookupExportFile(ctx context.Context, filename string) (*ExportFil
 		`, filename)
 
 	ef := ExportFile{}
-	if err := row.Scan(&ef.BucketName, &ef.Filename, &ef.BatchID, &ef.Region, &ef.BatchNum, &ef.BatchSize, &ef.Status); err != nil {
+	if err := row.Scan(&ef.BucketName, &ef.Filename, &ef.BatchID, &ef.Region, &ef.BatchNum, &ef.BatchSize, &ef.Status, &ef.SizeBytes, &ef.SHA256); err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, ErrNotFound
 		}
@@ -490,93 +721,160 @@ func (db *DB) LookupExportFile(ctx context.Context, filename string) (*ExportFil
 	return &ef, nil
 }
 
-// DeleteFilesBefore deletes the export batch files for batches ending before the time passed in.
-func (db *DB) DeleteFilesBefore(ctx context.Context, before time.Time, blobstore storage.Blobstore) (int, error) {
-	logger := logging.FromContext(ctx)
-
-	// Fetch filenames for  batches where at least one file is not deleted yet.
-	var files []joinedExportBatchFile
-	err := func() error { // Use a func to allow defer conn.Release() to work.
-		conn, err := db.Pool.Acquire(ctx)
-		if err != nil {
-			return fmt.Errorf("acquiring connection: %w", err)
-		}
-		defer conn.Release()
+// LookupExportConfigBucket returns the bucket that the export configs writing
+// files under filenameRoot write to, or ErrNotFound if there are none.
+func (db *DB) LookupExportConfigBucket(ctx context.Context, filenameRoot string) (string, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return "", fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
 
-		q := `
-			SELECT
-				eb.batch_id,
-				eb.status,
-				eb.bucket_name,
-				ef.filename,
-				ef.batch_size,
-				ef.status
-			FROM
-				ExportBatch eb
-			INNER JOIN
-				ExportFile ef ON (eb.batch_id = ef.batch_id)
-			WHERE
-				eb.end_timestamp < $1
-				AND eb.status != $2`
-		rows, err := conn.Query(ctx, q, before, ExportBatchDeleted)
-		if err != nil {
-			return fmt.Errorf("fetching filenames: %w", err)
+	var bucket string
+	if err := conn.QueryRow(ctx, `
+		SELECT
+			bucket_name
+		FROM
+			ExportConfig
+		WHERE
+			filename_root = $1
+		ORDER BY
+			config_id
+		LIMIT 1
+		`, filenameRoot).Scan(&bucket); err != nil {
+		if err == pgx.ErrNoRows {
+			return "", ErrNotFound
 		}
-		defer rows.Close()
+		return "", err
+	}
+	return bucket, nil
+}
 
-		for rows.Next() {
-			var f joinedExportBatchFile
-			if err := rows.Scan(&f.batchID, &f.batchStatus, &f.bucketName, &f.filename, &f.count, &f.fileStatus); err != nil {
-				return fmt.Errorf("fetching batch_id: %w", err)
-			}
-			files = append(files, f)
-		}
-		return rows.Err()
-	}()
+// LookupExpiredExportFiles returns the export files that haven't been deleted
+// yet of the batches that ended before the given time, ordered by config and
+// filename.
+func (db *DB) LookupExpiredExportFiles(ctx context.Context, before time.Time) ([]*ExpiredExportFile, error) {
+	conn, err := db.Pool.Acquire(ctx)
 	if err != nil {
-		return 0, err
+		return nil, fmt.Errorf("acquiring connection: %w", err)
 	}
+	defer conn.Release()
 
-	count := 0
-	batchFileDeleteCounter := make(map[int64]int)
-
-	for _, f := range files {
+	rows, err := conn.Query(ctx, `
+		SELECT
+			eb.config_id, eb.batch_id, eb.bucket_name, eb.filename_root, ef.filename, COALESCE(ef.size_bytes, 0)
+		FROM
+			ExportBatch eb
+		INNER JOIN
+			ExportFile ef ON (eb.batch_id = ef.batch_id)
+		WHERE
+			eb.end_timestamp < $1
+			AND eb.status != $2
+			AND ef.status != $2
+		ORDER BY
+			eb.config_id, ef.filename
+		`, before, ExportBatchDeleted)
+	if err != nil {
+		return nil, fmt.Errorf("fetching filenames: %w", err)
+	}
+	defer rows.Close()
 
-		// If file is already deleted, skip to the next.
-		if f.fileStatus == ExportBatchDeleted {
-			batchFileDeleteCounter[f.batchID]++
-			continue
+	var files []*ExpiredExportFile
+	for rows.Next() {
+		var f ExpiredExportFile
+		if err := rows.Scan(&f.ConfigID, &f.BatchID, &f.BucketName, &f.FilenameRoot, &f.Filename, &f.SizeBytes); err != nil {
+			return nil, fmt.Errorf("scanning export file: %w", err)
 		}
+		files = append(files, &f)
+	}
+	return files, rows.Err()
+}
 
-		// Delete stored file.
-		gcsCtx, cancel := context.WithTimeout(ctx, time.Second*50)
-		defer cancel()
-		if err := blobstore.DeleteObject(gcsCtx, f.bucketName, f.filename); err != nil {
-			return 0, fmt.Errorf("delete object: %w", err)
+// MarkExportFileDeleted records that an export file has been deleted from the
+// blobstore. Once every file of its batch is deleted, the batch is marked
+// deleted too.
+func (db *DB) MarkExportFileDeleted(ctx context.Context, batchID int64, filename string) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		if err := updateExportFileStatus(ctx, tx, batchID, filename, ExportBatchDeleted); err != nil {
+			return err
 		}
 
-		err := db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
-			// Update Status in ExportFile.
-			if err := updateExportFileStatus(ctx, tx, f.batchID, f.filename, ExportBatchDeleted); err != nil {
-				return fmt.Errorf("updating ExportFile: %w", err)
+		var remaining int
+		if err := tx.QueryRow(ctx, `
+			SELECT
+				COUNT(*)
+			FROM
+				ExportFile
+			WHERE
+				batch_id = $1 AND status != $2
+			`, batchID, ExportBatchDeleted).Scan(&remaining); err != nil {
+			return fmt.Errorf("counting remaining files: %w", err)
+		}
+		if remaining == 0 {
+			if err := updateExportBatchStatus(ctx, tx, batchID, ExportBatchDeleted); err != nil {
+				return err
 			}
+		}
+		return nil
+	})
+}
 
-			// If batch completely deleted, update in ExportBatch.
-			if batchFileDeleteCounter[f.batchID] == f.count {
-				if err := updateExportBatchStatus(ctx, tx, f.batchID, ExportBatchDeleted); err != nil {
-					return fmt.Errorf("updating ExportBatch: %w", err)
-				}
+// DeleteExportBatchesBefore deletes the rows of deleted export batches that
+// ended before the given time, with their files and stats. Batches whose files
+// haven't all been deleted from the blobstore are kept. It returns the number
+// of batches deleted.
+func (db *DB) DeleteExportBatchesBefore(ctx context.Context, before time.Time) (int64, error) {
+	var count int64
+	err := db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		var batchIDs []int64
+		rows, err := tx.Query(ctx, `
+			SELECT
+				batch_id
+			FROM
+				ExportBatch
+			WHERE
+				end_timestamp < $1 AND status = $2
+			FOR UPDATE
+			`, before, ExportBatchDeleted)
+		if err != nil {
+			return fmt.Errorf("selecting deleted batches: %w", err)
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return fmt.Errorf("scanning batch id: %w", err)
 			}
+			batchIDs = append(batchIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("selecting deleted batches: %w", err)
+		}
+		if len(batchIDs) == 0 {
 			return nil
-		})
-		if err != nil {
-			return 0, err
 		}
 
-		logger.Infof("Deleted filename %s", f.filename)
-		count++
+		for _, table := range []string{"ExportFile", "ExportBatchStats"} {
+			if _, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE batch_id = ANY($1)`, batchIDs); err != nil {
+				return fmt.Errorf("deleting from %s: %w", table, err)
+			}
+		}
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				ExportBatch
+			WHERE
+				batch_id = ANY($1)
+			`, batchIDs)
+		if err != nil {
+			return fmt.Errorf("deleting batches: %w", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
-
 	return count, nil
 }
 
@@ -586,11 +884,11 @@ func addExportFile(ctx context.Context, tx pgx.Tx, ef *ExportFile) error {
 	tag, err := tx.Exec(ctx, `
 		INSERT INTO
 			ExportFile
-			(bucket_name, filename, batch_id, region, batch_num, batch_size, status)
+			(bucket_name, filename, batch_id, region, batch_num, batch_size, status, size_bytes, sha256)
 		VALUES
-			($1, $2, $3, $4, $5, $6, $7)
+			($1, $2, $3, $4, $5, $6, $7, NULLIF($8::BIGINT, 0), NULLIF($9, ''))
 		ON CONFLICT (filename) DO NOTHING
-		`, ef.BucketName, ef.Filename, ef.BatchID, ef.Region, ef.BatchNum, ef.BatchSize, ef.Status)
+		`, ef.BucketName, ef.Filename, ef.BatchID, ef.Region, ef.BatchNum, ef.BatchSize, ef.Status, ef.SizeBytes, ef.SHA256)
 	if err != nil {
 		return fmt.Errorf("inserting to ExportFile: %w", err)
 	}
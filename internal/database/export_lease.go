@@ -0,0 +1,272 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// ErrLeaseLost indicates that a worker's lease on an export batch expired and
+// the batch was claimed by another worker.
+var ErrLeaseLost = errors.New("export batch lease lost")
+
+// BatchLease is a worker's claim on an export batch. Only the holder of the
+// lease's token may complete the batch. If the worker doesn't complete the
+// batch before the lease expires, for example because it is stuck, another
+// worker may claim the batch.
+type BatchLease struct {
+	Batch   *ExportBatch
+	Token   string
+	Expires time.Time
+}
+
+// ClaimBatch leases an export batch that closed before closedBefore and isn't
+// leased by another worker, for leaseDuration. If there is no work to do, nil
+// is returned.
+func (db *DB) ClaimBatch(ctx context.Context, leaseDuration time.Duration, closedBefore time.Time) (*BatchLease, error) {
+	return db.claimBatch(ctx, leaseDuration, time.Now(), closedBefore)
+}
+
+func (db *DB) claimBatch(ctx context.Context, ttl time.Duration, now, closedBefore time.Time) (*BatchLease, error) {
+	// Lookup a set of candidate batch IDs.
+	var openBatchIDs []int64
+	err := func() error { // Use a func to allow defer conn.Release() to work.
+		conn, err := db.Pool.Acquire(ctx)
+		if err != nil {
+			return fmt.Errorf("acquiring connection: %w", err)
+		}
+		defer conn.Release()
+
+		// Query for batches that are OPEN or PENDING with expired lease. Also, only return batches with end timestamp
+		// in the past (i.e., the batch is complete).
+		rows, err := conn.Query(ctx, `
+			SELECT
+				batch_id
+			FROM
+				ExportBatch
+			WHERE
+			    (
+					status = $1
+					OR
+					(status = $2 AND lease_expires < $3)
+				)
+			AND
+				end_timestamp < $4
+			LIMIT 100
+		`, ExportBatchOpen, ExportBatchPending, now, closedBefore)
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("iterating rows: %w", err)
+			}
+
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			openBatchIDs = append(openBatchIDs, id)
+		}
+		return rows.Err()
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(openBatchIDs) == 0 {
+		return nil, nil
+	}
+
+	// Randomize openBatchIDs so that workers aren't competing for the same job.
+	openBatchIDs = shuffle(openBatchIDs)
+
+	for _, bid := range openBatchIDs {
+		token, err := newLeaseToken()
+		if err != nil {
+			return nil, err
+		}
+		expires := now.Add(ttl)
+
+		// In a serialized transaction, fetch the existing batch and make sure it can be leased, then lease it.
+		leased := false
+		err = db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+			row := tx.QueryRow(ctx, `
+				SELECT
+					status, lease_expires
+				FROM
+					ExportBatch
+				WHERE
+					batch_id = $1
+				`, bid)
+
+			var status string
+			var leaseExpires *time.Time
+			if err := row.Scan(&status, &leaseExpires); err != nil {
+				return err
+			}
+
+			if status == ExportBatchComplete || (leaseExpires != nil && status == ExportBatchPending && now.Before(*leaseExpires)) {
+				// Something beat us to this batch, it's no longer available.
+				return nil
+			}
+
+			if _, err := tx.Exec(ctx, `
+				UPDATE
+					ExportBatch
+				SET
+					status = $1, lease_expires = $2
+				WHERE
+				    batch_id = $3
+				`, ExportBatchPending, expires, bid); err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO
+					ExportBatchLease
+					(batch_id, lease_token, expires_at)
+				VALUES
+					($1, $2, $3)
+				ON CONFLICT (batch_id) DO UPDATE
+				SET
+					lease_token = EXCLUDED.lease_token,
+					expires_at = EXCLUDED.expires_at
+				`, bid, token, expires); err != nil {
+				return fmt.Errorf("inserting batch lease: %w", err)
+			}
+
+			leased = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if leased {
+			batch, err := db.LookupExportBatch(ctx, bid)
+			if err != nil {
+				return nil, err
+			}
+			return &BatchLease{Batch: batch, Token: token, Expires: expires}, nil
+		}
+	}
+	// We didn't manage to lease any of the candidates, so return no work to be done (nil).
+	return nil, nil
+}
+
+// RenewBatchLease extends the lease for leaseDuration from now. It returns
+// ErrLeaseLost if the lease expired and the batch was claimed by another
+// worker.
+func (db *DB) RenewBatchLease(ctx context.Context, lease *BatchLease, leaseDuration time.Duration) error {
+	expires := time.Now().Add(leaseDuration)
+	err := db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		if err := checkBatchLease(ctx, tx, lease); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE
+				ExportBatchLease
+			SET
+				expires_at = $1
+			WHERE
+				batch_id = $2
+			`, expires, lease.Batch.BatchID); err != nil {
+			return fmt.Errorf("renewing batch lease: %w", err)
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE
+				ExportBatch
+			SET
+				lease_expires = $1
+			WHERE
+				batch_id = $2
+			`, expires, lease.Batch.BatchID); err != nil {
+			return fmt.Errorf("renewing batch lease: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	lease.Expires = expires
+	lease.Batch.LeaseExpires = expires
+	return nil
+}
+
+// FinalizeClaimedBatch is FinalizeBatch for a batch claimed with ClaimBatch.
+// It returns ErrLeaseLost, and changes nothing, if the lease expired and the
+// batch was claimed by another worker.
+func (db *DB) FinalizeClaimedBatch(ctx context.Context, lease *BatchLease, files []string, sizes []int64, checksums []string, batchSize int) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		if err := checkBatchLease(ctx, tx, lease); err != nil {
+			return err
+		}
+		if err := finalizeBatch(ctx, tx, lease.Batch, files, sizes, checksums, batchSize); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+			DELETE FROM
+				ExportBatchLease
+			WHERE
+				batch_id = $1
+			`, lease.Batch.BatchID); err != nil {
+			return fmt.Errorf("deleting batch lease: %w", err)
+		}
+		return nil
+	})
+}
+
+// checkBatchLease returns ErrLeaseLost unless the lease is the current lease
+// on its batch.
+func checkBatchLease(ctx context.Context, tx pgx.Tx, lease *BatchLease) error {
+	row := tx.QueryRow(ctx, `
+		SELECT
+			lease_token
+		FROM
+			ExportBatchLease
+		WHERE
+			batch_id = $1
+		`, lease.Batch.BatchID)
+
+	var token string
+	if err := row.Scan(&token); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrLeaseLost
+		}
+		return fmt.Errorf("reading batch lease: %w", err)
+	}
+	if token != lease.Token {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// newLeaseToken generates a random lease token.
+func newLeaseToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating lease token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
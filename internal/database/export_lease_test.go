@@ -0,0 +1,105 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClaimBatch(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	now := time.Now().Truncate(time.Microsecond)
+	config := &ExportConfig{
+		BucketName:   "mocked",
+		FilenameRoot: "root",
+		Period:       time.Hour,
+		Region:       "R",
+		From:         now.Add(-2 * time.Hour),
+		Thru:         now.Add(time.Hour),
+	}
+	if err := testDB.AddExportConfig(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.AddExportBatches(ctx, []*ExportBatch{{
+		ConfigID:       config.ConfigID,
+		BucketName:     config.BucketName,
+		FilenameRoot:   config.FilenameRoot,
+		Region:         config.Region,
+		Status:         ExportBatchOpen,
+		StartTimestamp: now.Add(-2 * time.Hour),
+		EndTimestamp:   now.Add(-time.Hour),
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := testDB.claimBatch(ctx, time.Hour, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == nil {
+		t.Fatal("could not claim a batch")
+	}
+	if first.Batch.Status != ExportBatchPending {
+		t.Errorf("claimed batch: got status %q, want pending", first.Batch.Status)
+	}
+
+	// The batch is leased, so no other worker can claim it.
+	got, err := testDB.claimBatch(ctx, time.Hour, now.Add(30*time.Minute), now)
+	if got != nil || err != nil {
+		t.Errorf("leased batch: got (%v, %v), want (nil, nil)", got, err)
+	}
+
+	// Once the lease expires, another worker claims the batch.
+	second, err := testDB.claimBatch(ctx, time.Hour, now.Add(2*time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == nil || second.Batch.BatchID != first.Batch.BatchID {
+		t.Fatalf("expired lease: got %v, want a lease on batch %d", second, first.Batch.BatchID)
+	}
+	if second.Token == first.Token {
+		t.Errorf("expired lease: got the same token %q", second.Token)
+	}
+
+	// The first worker can no longer renew or complete the batch.
+	if err := testDB.RenewBatchLease(ctx, first, time.Hour); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("RenewBatchLease with lost lease: got %v, want ErrLeaseLost", err)
+	}
+	if err := testDB.FinalizeClaimedBatch(ctx, first, []string{"file-1"}, nil, nil, 1); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("FinalizeClaimedBatch with lost lease: got %v, want ErrLeaseLost", err)
+	}
+
+	if err := testDB.RenewBatchLease(ctx, second, time.Hour); err != nil {
+		t.Errorf("RenewBatchLease: %v", err)
+	}
+	if err := testDB.FinalizeClaimedBatch(ctx, second, []string{"file-1"}, nil, nil, 1); err != nil {
+		t.Fatalf("FinalizeClaimedBatch: %v", err)
+	}
+	batch, err := testDB.LookupExportBatch(ctx, second.Batch.BatchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.Status != ExportBatchComplete {
+		t.Errorf("finalized batch: got status %q, want complete", batch.Status)
+	}
+}
@@ -34,19 +34,52 @@ type ExportConfig struct {
 	From             time.Time     `db:"from_timestamp"`
 	Thru             time.Time     `db:"thru_timestamp"`
 	SignatureInfoIDs []int64       `db:"signature_info_ids"`
+
+	// HealthAuthorityID, if set, limits the export to exposures published by
+	// that health authority.
+	HealthAuthorityID string `db:"health_authority_id"`
+
+	// IncludeTravelers also exports travelers' exposures for the region from
+	// other health authorities.
+	IncludeTravelers bool `db:"include_travelers"`
+
+	// IncludeReportTypes, if non-empty, limits the export to exposures with one
+	// of these report types. The empty string matches exposures without a
+	// report type.
+	IncludeReportTypes []string `db:"include_report_types"`
+
+	// MinTransmissionRisk, if positive, limits the export to exposures with at
+	// least this transmission risk.
+	MinTransmissionRisk int `db:"min_transmission_risk"`
+
+	// DeltaOfConfigID, if set, makes this a delta config of the given full
+	// config: its index only lists the files of batches that start after the
+	// latest complete batch of the full config, so clients can download the
+	// full config's files and then the deltas since.
+	DeltaOfConfigID int64 `db:"delta_of_config_id"`
 }
 
 type ExportBatch struct {
-	BatchID          int64     `db:"batch_id" json:"batchID"`
-	ConfigID         int64     `db:"config_id" json:"configID"`
-	BucketName       string    `db:"bucket_name" json:"bucketName"`
-	FilenameRoot     string    `db:"filename_root" json:"filenameRoot"`
-	StartTimestamp   time.Time `db:"start_timestamp" json:"startTimestamp"`
-	EndTimestamp     time.Time `db:"end_timestamp" json:"endTimestamp"`
-	Region           string    `db:"region" json:"region"`
-	Status           string    `db:"status" json:"status"`
-	LeaseExpires     time.Time `db:"lease_expires" json:"leaseExpires"`
-	SignatureInfoIDs []int64   `db:"signature_info_ids"`
+	BatchID           int64     `db:"batch_id" json:"batchID"`
+	ConfigID          int64     `db:"config_id" json:"configID"`
+	BucketName        string    `db:"bucket_name" json:"bucketName"`
+	FilenameRoot      string    `db:"filename_root" json:"filenameRoot"`
+	StartTimestamp    time.Time `db:"start_timestamp" json:"startTimestamp"`
+	EndTimestamp      time.Time `db:"end_timestamp" json:"endTimestamp"`
+	Region            string    `db:"region" json:"region"`
+	Status            string    `db:"status" json:"status"`
+	LeaseExpires      time.Time `db:"lease_expires" json:"leaseExpires"`
+	SignatureInfoIDs  []int64   `db:"signature_info_ids"`
+	HealthAuthorityID string    `db:"health_authority_id" json:"healthAuthorityID"`
+	IncludeTravelers  bool      `db:"include_travelers" json:"includeTravelers"`
+
+	IncludeReportTypes  []string `db:"include_report_types" json:"includeReportTypes"`
+	MinTransmissionRisk int      `db:"min_transmission_risk" json:"minTransmissionRisk"`
+	DeltaOfConfigID     int64    `db:"delta_of_config_id" json:"deltaOfConfigID"`
+
+	// ExposureHorizon is the change horizon of the read that exported the
+	// batch, or zero if unknown. It is recorded when the batch is finalized.
+	ExposureHorizon int64 `db:"exposure_horizon" json:"exposureHorizon,omitempty"`
 }
 
 type ExportFile struct {
@@ -57,6 +90,28 @@ type ExportFile struct {
 	BatchNum   int    `db:"batch_num"`
 	BatchSize  int    `db:"batch_size"`
 	Status     string `db:"status"`
+	SizeBytes  int64  `db:"size_bytes"` // 0 if unknown
+	SHA256     string `db:"sha256"`     // hex encoded, empty if unknown
+}
+
+// ExportIndexEntry is a file listed in the index of an export config.
+type ExportIndexEntry struct {
+	Filename   string
+	BatchID    int64
+	BatchStart time.Time
+	BatchEnd   time.Time
+	BatchNum   int
+}
+
+// ExpiredExportFile is an export file whose batch ended before the retention
+// cutoff, and which cleanup deletes.
+type ExpiredExportFile struct {
+	ConfigID     int64
+	BatchID      int64
+	BucketName   string
+	FilenameRoot string
+	Filename     string
+	SizeBytes    int64 // 0 if unknown
 }
 
 type SignatureInfo struct {
@@ -66,5 +121,12 @@ type SignatureInfo struct {
 	BundleID          string    `db:"bundle_id"`
 	SigningKeyVersion string    `db:"signing_key_version"`
 	SigningKeyID      string    `db:"signing_key_id"`
+	StartTimestamp    time.Time `db:"from_timestamp"`
 	EndTimestamp      time.Time `db:"thru_timestamp"`
+
+	// AutoRotate marks keys that the export server rotates. RotatedToID is
+	// the signature info of the key version that replaced this one, or 0.
+	AutoRotate  bool      `db:"auto_rotate"`
+	CreatedAt   time.Time `db:"created_at"`
+	RotatedToID int64     `db:"rotated_to_id"`
 }
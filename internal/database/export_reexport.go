@@ -0,0 +1,144 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// LookupCompletedExportBatchIDs returns the IDs of the completed batches of an
+// export config that start at or after from and end at or before thru, ordered
+// by start time.
+func (db *DB) LookupCompletedExportBatchIDs(ctx context.Context, configID int64, from, thru time.Time) ([]int64, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			batch_id
+		FROM
+			ExportBatch
+		WHERE
+			config_id = $1
+		AND
+			status = $2
+		AND
+			start_timestamp >= $3
+		AND
+			end_timestamp <= $4
+		ORDER BY
+			start_timestamp
+		`, configID, ExportBatchComplete, from, thru)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ReexportBatches reopens completed export batches so that the export worker
+// generates their files again. The batches are signed with the current
+// signature infos of their export config, which replaces a compromised key.
+// Batches that are not complete, such as ones whose files have been deleted,
+// are skipped. It returns the IDs of the reopened batches.
+func (db *DB) ReexportBatches(ctx context.Context, batchIDs []int64) ([]int64, error) {
+	var reopened []int64
+	err := db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			UPDATE
+				ExportBatch eb
+			SET
+				status = $1, lease_expires = NULL, signature_info_ids = ec.signature_info_ids
+			FROM
+				ExportConfig ec
+			WHERE
+				ec.config_id = eb.config_id
+			AND
+				eb.batch_id = any($2)
+			AND
+				eb.status = $3
+			RETURNING
+				eb.batch_id
+			`, ExportBatchOpen, batchIDs, ExportBatchComplete)
+		if err != nil {
+			return fmt.Errorf("reopening export batches: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			reopened = append(reopened, id)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reopened, nil
+}
+
+// LookupBatchExportFiles returns the names of the files recorded for an export
+// batch, ordered by their number within the batch.
+func (db *DB) LookupBatchExportFiles(ctx context.Context, batchID int64) ([]string, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			filename
+		FROM
+			ExportFile
+		WHERE
+			batch_id = $1
+		ORDER BY
+			batch_num, filename
+		`, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var filenames []string
+	for rows.Next() {
+		var filename string
+		if err := rows.Scan(&filename); err != nil {
+			return nil, err
+		}
+		filenames = append(filenames, filename)
+	}
+	return filenames, rows.Err()
+}
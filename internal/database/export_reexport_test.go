@@ -0,0 +1,137 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReexportBatches(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Microsecond)
+
+	oldKey := &SignatureInfo{SigningKey: "/kms/project/key/version/1"}
+	newKey := &SignatureInfo{SigningKey: "/kms/project/key/version/2"}
+	for _, si := range []*SignatureInfo{oldKey, newKey} {
+		if err := testDB.AddSignatureInfo(ctx, si); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ec := &ExportConfig{
+		BucketName:       "some-bucket",
+		FilenameRoot:     "filename-root",
+		Period:           time.Hour,
+		Region:           "US",
+		From:             now.Add(-24 * time.Hour),
+		SignatureInfoIDs: []int64{oldKey.ID},
+	}
+	if err := testDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+
+	// A completed batch and an open one.
+	complete := &ExportBatch{
+		ConfigID:         ec.ConfigID,
+		BucketName:       ec.BucketName,
+		FilenameRoot:     ec.FilenameRoot,
+		StartTimestamp:   now.Add(-3 * time.Hour),
+		EndTimestamp:     now.Add(-2 * time.Hour),
+		Region:           ec.Region,
+		Status:           ExportBatchOpen,
+		SignatureInfoIDs: []int64{oldKey.ID},
+	}
+	if err := testDB.AddExportBatches(ctx, []*ExportBatch{complete}); err != nil {
+		t.Fatal(err)
+	}
+	leased, err := testDB.LeaseBatch(ctx, time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.FinalizeBatch(ctx, leased, []string{"file-1", "file-2"}, nil, nil, 2); err != nil {
+		t.Fatal(err)
+	}
+	open := &ExportBatch{
+		ConfigID:       ec.ConfigID,
+		BucketName:     ec.BucketName,
+		FilenameRoot:   ec.FilenameRoot,
+		StartTimestamp: now.Add(-2 * time.Hour),
+		EndTimestamp:   now.Add(-time.Hour),
+		Region:         ec.Region,
+		Status:         ExportBatchOpen,
+	}
+	if err := testDB.AddExportBatches(ctx, []*ExportBatch{open}); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := testDB.LookupCompletedExportBatchIDs(ctx, ec.ConfigID, now.Add(-24*time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int64{leased.BatchID}, ids); diff != "" {
+		t.Errorf("completed batch IDs mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Rotate the key, then re-export both batches. Only the completed one is
+	// reopened, and it picks up the config's new key.
+	if err := testDB.AddExportConfigSignatureInfo(ctx, ec.ConfigID, newKey.ID); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := testDB.ReexportBatches(ctx, []int64{leased.BatchID, open.BatchID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int64{leased.BatchID}, reopened); diff != "" {
+		t.Errorf("reopened batch IDs mismatch (-want, +got):\n%s", diff)
+	}
+
+	got, err := testDB.LookupExportBatch(ctx, leased.BatchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != ExportBatchOpen {
+		t.Errorf("status = %q, want %q", got.Status, ExportBatchOpen)
+	}
+	if diff := cmp.Diff([]int64{oldKey.ID, newKey.ID}, got.SignatureInfoIDs); diff != "" {
+		t.Errorf("signature info IDs mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Finalizing the regenerated batch replaces its files.
+	files, err := testDB.LookupBatchExportFiles(ctx, leased.BatchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"file-1", "file-2"}, files); diff != "" {
+		t.Errorf("previous files mismatch (-want, +got):\n%s", diff)
+	}
+	if err := testDB.FinalizeBatch(ctx, got, []string{"file-1"}, nil, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	files, err = testDB.LookupBatchExportFiles(ctx, leased.BatchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"file-1"}, files); diff != "" {
+		t.Errorf("regenerated files mismatch (-want, +got):\n%s", diff)
+	}
+}
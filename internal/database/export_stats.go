@@ -0,0 +1,128 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// ExportBatchStats records what was published for an export batch, so health
+// authorities can audit the keys that were exported.
+type ExportBatchStats struct {
+	BatchID        int64     `json:"batchID"`
+	ConfigID       int64     `json:"configID"`
+	Region         string    `json:"region"`
+	StartTimestamp time.Time `json:"startTimestamp"`
+	EndTimestamp   time.Time `json:"endTimestamp"`
+
+	// Keys is the number of real keys exported, and KeysByReportType splits
+	// them by report type. Keys without a report type are counted under the
+	// empty string.
+	Keys             int            `json:"keys"`
+	KeysByReportType map[string]int `json:"keysByReportType"`
+
+	// DroppedKeys is the number of keys in the batch's region and time range
+	// that the export config's filters excluded.
+	DroppedKeys int `json:"droppedKeys"`
+
+	// PaddingKeys is the number of fake keys added to the batch's files.
+	PaddingKeys int `json:"paddingKeys"`
+
+	Files     int       `json:"files"`
+	SizeBytes int64     `json:"sizeBytes"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// SaveExportBatchStats records the stats of an export batch, replacing any
+// stats recorded for it before, such as when the batch is re-exported.
+func (db *DB) SaveExportBatchStats(ctx context.Context, s *ExportBatchStats) error {
+	byReportType, err := json.Marshal(s.KeysByReportType)
+	if err != nil {
+		return fmt.Errorf("marshalling keys by report type: %w", err)
+	}
+
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO
+				ExportBatchStats
+				(batch_id, config_id, region, start_timestamp, end_timestamp, keys, keys_by_report_type,
+				 dropped_keys, padding_keys, files, size_bytes, updated_at)
+			VALUES
+				($1, $2, $3, $4, $5, $6, $7::JSONB, $8, $9, $10, $11, $12)
+			ON CONFLICT (batch_id) DO UPDATE SET
+				keys = EXCLUDED.keys, keys_by_report_type = EXCLUDED.keys_by_report_type,
+				dropped_keys = EXCLUDED.dropped_keys, padding_keys = EXCLUDED.padding_keys,
+				files = EXCLUDED.files, size_bytes = EXCLUDED.size_bytes, updated_at = EXCLUDED.updated_at
+			`, s.BatchID, s.ConfigID, s.Region, s.StartTimestamp, s.EndTimestamp, s.Keys, string(byReportType),
+			s.DroppedKeys, s.PaddingKeys, s.Files, s.SizeBytes, s.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("saving stats of batch %d: %w", s.BatchID, err)
+		}
+		return nil
+	})
+}
+
+// ListExportBatchStats returns the stats of the export batches that start
+// within [from, thru), ordered by their start. If configID is non-zero, only
+// the batches of that export config are returned.
+func (db *DB) ListExportBatchStats(ctx context.Context, configID int64, from, thru time.Time) ([]*ExportBatchStats, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			batch_id, config_id, region, start_timestamp, end_timestamp, keys, keys_by_report_type,
+			dropped_keys, padding_keys, files, size_bytes, updated_at
+		FROM
+			ExportBatchStats
+		WHERE
+			($1 = 0 OR config_id = $1)
+		AND
+			start_timestamp >= $2
+		AND
+			start_timestamp < $3
+		ORDER BY
+			start_timestamp, batch_id
+		`, configID, from, thru)
+	if err != nil {
+		return nil, fmt.Errorf("listing export batch stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []*ExportBatchStats
+	for rows.Next() {
+		var (
+			s            ExportBatchStats
+			byReportType []byte
+		)
+		if err := rows.Scan(&s.BatchID, &s.ConfigID, &s.Region, &s.StartTimestamp, &s.EndTimestamp, &s.Keys, &byReportType,
+			&s.DroppedKeys, &s.PaddingKeys, &s.Files, &s.SizeBytes, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(byReportType, &s.KeysByReportType); err != nil {
+			return nil, fmt.Errorf("keys_by_report_type of batch %d: %w", s.BatchID, err)
+		}
+		stats = append(stats, &s)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExportBatchStats(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+	day := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	var configs []*ExportConfig
+	for _, region := range []string{"US", "CA"} {
+		ec := &ExportConfig{BucketName: "bucket", FilenameRoot: region, Period: 24 * time.Hour, Region: region}
+		if err := testDB.AddExportConfig(ctx, ec); err != nil {
+			t.Fatal(err)
+		}
+		configs = append(configs, ec)
+	}
+
+	var stats []*ExportBatchStats
+	for i, ec := range configs {
+		for d := 0; d < 2; d++ {
+			eb := &ExportBatch{
+				ConfigID:       ec.ConfigID,
+				BucketName:     ec.BucketName,
+				FilenameRoot:   ec.FilenameRoot,
+				StartTimestamp: day.Add(time.Duration(d) * 24 * time.Hour),
+				EndTimestamp:   day.Add(time.Duration(d+1) * 24 * time.Hour),
+				Region:         ec.Region,
+				Status:         ExportBatchOpen,
+			}
+			if err := testDB.AddExportBatches(ctx, []*ExportBatch{eb}); err != nil {
+				t.Fatal(err)
+			}
+			leased, err := testDB.LeaseBatch(ctx, time.Hour, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &ExportBatchStats{
+				BatchID:          leased.BatchID,
+				ConfigID:         ec.ConfigID,
+				Region:           ec.Region,
+				StartTimestamp:   leased.StartTimestamp,
+				EndTimestamp:     leased.EndTimestamp,
+				Keys:             10 * (i + 1),
+				KeysByReportType: map[string]int{ReportTypeConfirmed: 8 * (i + 1), "": 2 * (i + 1)},
+				DroppedKeys:      d,
+				PaddingKeys:      990,
+				Files:            1,
+				SizeBytes:        12345,
+				UpdatedAt:        day.Add(72 * time.Hour),
+			}
+			if err := testDB.SaveExportBatchStats(ctx, s); err != nil {
+				t.Fatal(err)
+			}
+			stats = append(stats, s)
+		}
+	}
+
+	// Saving the stats of a batch again replaces them.
+	stats[1].Keys = 11
+	stats[1].KeysByReportType = map[string]int{ReportTypeLikely: 11}
+	if err := testDB.SaveExportBatchStats(ctx, stats[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		configID int64
+		from     time.Time
+		thru     time.Time
+		want     []*ExportBatchStats
+	}{
+		{"all", 0, day, day.Add(48 * time.Hour), []*ExportBatchStats{stats[0], stats[2], stats[1], stats[3]}},
+		{"one config", configs[0].ConfigID, day, day.Add(48 * time.Hour), []*ExportBatchStats{stats[0], stats[1]}},
+		{"one day", 0, day.Add(24 * time.Hour), day.Add(48 * time.Hour), []*ExportBatchStats{stats[1], stats[3]}},
+		{"none", 0, day.Add(48 * time.Hour), day.Add(72 * time.Hour), nil},
+	}
+	for _, c := range cases {
+		got, err := testDB.ListExportBatchStats(ctx, c.configID, c.from, c.thru)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if diff := cmp.Diff(c.want, got); diff != "" {
+			t.Errorf("%s: mismatch (-want, +got):\n%s", c.name, diff)
+		}
+	}
+}
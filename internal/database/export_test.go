@@ -17,6 +17,7 @@ package database
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sort"
 	"testing"
 	"time"
@@ -32,11 +33,13 @@ func TestAddSignatureInfo(t *testing.T) {
 	defer ResetTestDB(t, testDB)
 	ctx := context.Background()
 
+	fromTime := time.Now().UTC().Add(-6 * time.Hour).Truncate(time.Microsecond)
 	thruTime := time.Now().UTC().Add(6 * time.Hour).Truncate(time.Microsecond)
 	want := &SignatureInfo{
 		SigningKey:        "/kms/project/key/1",
 		SigningKeyVersion: "1",
 		SigningKeyID:      "310",
+		StartTimestamp:    fromTime,
 		EndTimestamp:      thruTime,
 	}
 	if err := testDB.AddSignatureInfo(ctx, want); err != nil {
@@ -50,12 +53,12 @@ func TestAddSignatureInfo(t *testing.T) {
 	var got SignatureInfo
 	err = conn.QueryRow(ctx, `
 		SELECT
-		  id, signing_key, app_package_name, bundle_id, signing_key_version, signing_key_id, thru_timestamp
+		  id, signing_key, app_package_name, bundle_id, signing_key_version, signing_key_id, from_timestamp, thru_timestamp
 		FROM
 			SignatureInfo
 		WHERE
 			id = $1
-	`, want.ID).Scan(&got.ID, &got.SigningKey, &got.AppPackageName, &got.BundleID, &got.SigningKeyVersion, &got.SigningKeyID, &got.EndTimestamp)
+	`, want.ID).Scan(&got.ID, &got.SigningKey, &got.AppPackageName, &got.BundleID, &got.SigningKeyVersion, &got.SigningKeyID, &got.StartTimestamp, &got.EndTimestamp)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -91,25 +94,118 @@ func TestLookupSignatureInfos(t *testing.T) {
 			SigningKeyVersion: "3",
 			SigningKeyID:      "310",
 		},
+		{
+			SigningKey:        "/kms/project/key/version/4",
+			SigningKeyVersion: "4",
+			SigningKeyID:      "310",
+			StartTimestamp:    testTime.Add(-1 * time.Hour).Truncate(time.Microsecond),
+		},
+		{
+			SigningKey:        "/kms/project/key/version/5",
+			SigningKeyVersion: "5",
+			SigningKeyID:      "310",
+			StartTimestamp:    testTime.Add(1 * time.Hour).Truncate(time.Microsecond),
+		},
 	}
 	for _, si := range want {
-		testDB.AddSignatureInfo(ctx, si)
+		if err := testDB.AddSignatureInfo(ctx, si); err != nil {
+			t.Fatal(err)
+		}
 	}
 
-	ids := []int64{want[0].ID, want[1].ID, want[2].ID}
+	var ids []int64
+	for _, si := range want {
+		ids = append(ids, si.ID)
+	}
 	got, err := testDB.LookupSignatureInfos(ctx, ids, testTime)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// The first entry (want[0]) is expired and won't be returned.
-	want = want[1:]
+	// The first entry (want[0]) is expired and the last (want[4]) is not yet
+	// active, so neither is returned.
+	want = want[1:4]
 
 	if diff := cmp.Diff(want, got); diff != "" {
 		t.Errorf("mismatch (-want, +got):\n%v", diff)
 	}
 }
 
+func TestRotateSignatureInfo(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	oldKey := &SignatureInfo{SigningKey: "/kms/project/key/version/1", SigningKeyVersion: "1"}
+	newKey := &SignatureInfo{SigningKey: "/kms/project/key/version/2", SigningKeyVersion: "2"}
+	for _, si := range []*SignatureInfo{oldKey, newKey} {
+		if err := testDB.AddSignatureInfo(ctx, si); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ec := &ExportConfig{
+		BucketName:       "bucket",
+		FilenameRoot:     "root",
+		Period:           24 * time.Hour,
+		Region:           "US",
+		From:             time.Now().Add(-time.Hour),
+		SignatureInfoIDs: []int64{oldKey.ID},
+	}
+	if err := testDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding the key twice is a no-op.
+	for i := 0; i < 2; i++ {
+		if err := testDB.AddExportConfigSignatureInfo(ctx, ec.ConfigID, newKey.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := testDB.AddExportConfigSignatureInfo(ctx, ec.ConfigID+1, newKey.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AddExportConfigSignatureInfo of missing config: got %v, want %v", err, ErrNotFound)
+	}
+
+	var gotIDs []int64
+	if err := testDB.IterateExportConfigs(ctx, time.Now(), func(got *ExportConfig) error {
+		gotIDs = got.SignatureInfoIDs
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int64{oldKey.ID, newKey.ID}, gotIDs); diff != "" {
+		t.Errorf("signature info IDs mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Both keys are active during the overlap.
+	now := time.Now()
+	got, err := testDB.LookupSignatureInfos(ctx, gotIDs, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d active signature infos, want 2", len(got))
+	}
+
+	// Once the old key is retired, only the new key remains.
+	if err := testDB.UpdateSignatureInfoEnd(ctx, oldKey.ID, now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	got, err = testDB.LookupSignatureInfos(ctx, gotIDs, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != newKey.ID {
+		t.Errorf("got active signature infos %v, want only %d", got, newKey.ID)
+	}
+
+	if err := testDB.UpdateSignatureInfoEnd(ctx, newKey.ID+100, now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateSignatureInfoEnd of missing signature info: got %v, want %v", err, ErrNotFound)
+	}
+}
+
 func TestAddExportConfig(t *testing.T) {
 	if testDB == nil {
 		t.Skip("no test DB")
@@ -120,13 +216,17 @@ func TestAddExportConfig(t *testing.T) {
 	fromTime := time.Now()
 	thruTime := fromTime.Add(6 * time.Hour)
 	want := &ExportConfig{
-		BucketName:       "mocked",
-		FilenameRoot:     "root",
-		Period:           3 * time.Hour,
-		Region:           "i1",
-		From:             fromTime,
-		Thru:             thruTime,
-		SignatureInfoIDs: []int64{42, 84},
+		BucketName:          "mocked",
+		FilenameRoot:        "root",
+		Period:              3 * time.Hour,
+		Region:              "i1",
+		From:                fromTime,
+		Thru:                thruTime,
+		SignatureInfoIDs:    []int64{42, 84},
+		HealthAuthorityID:   "ha-1",
+		IncludeTravelers:    true,
+		IncludeReportTypes:  []string{ReportTypeConfirmed, ReportTypeLikely},
+		MinTransmissionRisk: 3,
 	}
 	if err := testDB.AddExportConfig(ctx, want); err != nil {
 		t.Fatal(err)
@@ -142,12 +242,14 @@ func TestAddExportConfig(t *testing.T) {
 	)
 	err = conn.QueryRow(ctx, `
 		SELECT
-			config_id, bucket_name, filename_root, period_seconds, region, from_timestamp, thru_timestamp, signature_info_ids
+			config_id, bucket_name, filename_root, period_seconds, region, from_timestamp, thru_timestamp, signature_info_ids,
+			health_authority_id, include_travelers, include_report_types, min_transmission_risk
 		FROM
 			ExportConfig
 		WHERE
 			config_id = $1
-	`, want.ConfigID).Scan(&got.ConfigID, &got.BucketName, &got.FilenameRoot, &psecs, &got.Region, &got.From, &got.Thru, &got.SignatureInfoIDs)
+	`, want.ConfigID).Scan(&got.ConfigID, &got.BucketName, &got.FilenameRoot, &psecs, &got.Region, &got.From, &got.Thru, &got.SignatureInfoIDs,
+		&got.HealthAuthorityID, &got.IncludeTravelers, &got.IncludeReportTypes, &got.MinTransmissionRisk)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -216,6 +318,50 @@ func TestIterateExportConfigs(t *testing.T) {
 	}
 }
 
+func TestListAndUpdateExportConfigs(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	now := time.Now().Truncate(time.Microsecond)
+	ended := &ExportConfig{BucketName: "b", FilenameRoot: "ended", Period: time.Hour, Region: "US", From: now.Add(-48 * time.Hour), Thru: now.Add(-24 * time.Hour)}
+	active := &ExportConfig{BucketName: "b", FilenameRoot: "active", Period: time.Hour, Region: "US", From: now, SignatureInfoIDs: []int64{1}}
+	for _, ec := range []*ExportConfig{ended, active} {
+		if err := testDB.AddExportConfig(ctx, ec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := testDB.ListExportConfigs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*ExportConfig{ended, active}, got); diff != "" {
+		t.Errorf("ListExportConfigs mismatch (-want, +got):\n%s", diff)
+	}
+
+	active.Thru = now.Add(time.Hour)
+	active.SignatureInfoIDs = []int64{1, 2}
+	active.Region = "CA" // not updated
+	if err := testDB.UpdateExportConfig(ctx, active); err != nil {
+		t.Fatal(err)
+	}
+	active.Region = "US"
+	got, err = testDB.ListExportConfigs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*ExportConfig{ended, active}, got); diff != "" {
+		t.Errorf("ListExportConfigs after update mismatch (-want, +got):\n%s", diff)
+	}
+
+	if err := testDB.UpdateExportConfig(ctx, &ExportConfig{ConfigID: active.ConfigID + 100}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateExportConfig of missing config: got %v, want %v", err, ErrNotFound)
+	}
+}
+
 func TestBatches(t *testing.T) {
 	if testDB == nil {
 		t.Skip("no test DB")
@@ -265,6 +411,21 @@ func TestBatches(t *testing.T) {
 		t.Errorf("LatestExportBatchEnd: got %s, want %s", gotLatest, wantLatest)
 	}
 
+	// A batcher that read an older latest end can't add batches.
+	stale := *batches[0]
+	stale.StartTimestamp = wantLatest
+	stale.EndTimestamp = wantLatest.Add(time.Minute)
+	if err := testDB.AddExportBatchesAfter(ctx, config, batches[0].EndTimestamp, []*ExportBatch{&stale}); !errors.Is(err, ErrExportBatchesExist) {
+		t.Errorf("AddExportBatchesAfter with stale latest end: got %v, want ErrExportBatchesExist", err)
+	}
+	gotLatest, err = testDB.LatestExportBatchEnd(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotLatest.Equal(wantLatest) {
+		t.Errorf("LatestExportBatchEnd after conflict: got %s, want %s", gotLatest, wantLatest)
+	}
+
 	leaseBatches := func() int64 {
 		t.Helper()
 		var batchID int64
@@ -376,8 +537,13 @@ func TestFinalizeBatch(t *testing.T) {
 
 	// Finalize the batch.
 	files := []string{"file1.txt", "file2.txt"}
+	sizes := []int64{1024, 512}
+	checksums := []string{
+		"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		"60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752",
+	}
 	batchSize := 10
-	if err := testDB.FinalizeBatch(ctx, eb, files, batchSize); err != nil {
+	if err := testDB.FinalizeBatch(ctx, eb, files, sizes, checksums, batchSize); err != nil {
 		t.Fatal(err)
 	}
 
@@ -412,6 +578,8 @@ func TestFinalizeBatch(t *testing.T) {
 			BatchNum:   i + 1,
 			BatchSize:  batchSize,
 			Status:     ExportBatchComplete,
+			SizeBytes:  sizes[i],
+			SHA256:     checksums[i],
 		}
 		if diff := cmp.Diff(want, got); diff != "" {
 			t.Errorf("mismatch for %q (-want, +got):\n%s", filename, diff)
@@ -419,6 +587,252 @@ func TestFinalizeBatch(t *testing.T) {
 	}
 }
 
+func TestLookupExportConfigBucket(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	ec := &ExportConfig{
+		BucketName:   "some-bucket",
+		FilenameRoot: "filename-root",
+		Period:       time.Hour,
+		Region:       "US",
+		From:         time.Now().Truncate(time.Microsecond),
+	}
+	if err := testDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := testDB.LookupExportConfigBucket(ctx, "filename-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "some-bucket" {
+		t.Errorf("got bucket %q, want %q", got, "some-bucket")
+	}
+
+	if _, err := testDB.LookupExportConfigBucket(ctx, "other-root"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestExpiredExportFiles(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Microsecond)
+
+	ec := &ExportConfig{
+		BucketName:   "some-bucket",
+		FilenameRoot: "filename-root",
+		Period:       time.Hour,
+		Region:       "US",
+	}
+	if err := testDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+
+	// An old and a recent batch, each completed with two files.
+	var batches []*ExportBatch
+	for i, end := range []time.Time{now.Add(-48 * time.Hour), now.Add(-time.Hour)} {
+		eb := &ExportBatch{
+			ConfigID:       ec.ConfigID,
+			BucketName:     ec.BucketName,
+			FilenameRoot:   ec.FilenameRoot,
+			StartTimestamp: end.Add(-time.Hour),
+			EndTimestamp:   end,
+			Region:         ec.Region,
+			Status:         ExportBatchOpen,
+		}
+		if err := testDB.AddExportBatches(ctx, []*ExportBatch{eb}); err != nil {
+			t.Fatal(err)
+		}
+		leased, err := testDB.LeaseBatch(ctx, time.Hour, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := []string{fmt.Sprintf("batch%d-1.zip", i), fmt.Sprintf("batch%d-2.zip", i)}
+		if err := testDB.FinalizeBatch(ctx, leased, files, []int64{100, 200}, nil, 2); err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, leased)
+	}
+
+	got, err := testDB.LookupExpiredExportFiles(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*ExpiredExportFile{
+		{ConfigID: ec.ConfigID, BatchID: batches[0].BatchID, BucketName: "some-bucket", FilenameRoot: "filename-root", Filename: "batch0-1.zip", SizeBytes: 100},
+		{ConfigID: ec.ConfigID, BatchID: batches[0].BatchID, BucketName: "some-bucket", FilenameRoot: "filename-root", Filename: "batch0-2.zip", SizeBytes: 200},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The batch stays complete until its last file is deleted.
+	for i, f := range got {
+		if err := testDB.MarkExportFileDeleted(ctx, f.BatchID, f.Filename); err != nil {
+			t.Fatal(err)
+		}
+		eb, err := testDB.LookupExportBatch(ctx, f.BatchID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatus := ExportBatchComplete
+		if i == len(got)-1 {
+			wantStatus = ExportBatchDeleted
+		}
+		if eb.Status != wantStatus {
+			t.Errorf("after deleting %s, batch status = %q, want %q", f.Filename, eb.Status, wantStatus)
+		}
+	}
+
+	got, err = testDB.LookupExpiredExportFiles(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got %d expired files after deletion, want 0", len(got))
+	}
+
+	// Deleted files are no longer listed in the index.
+	files, err := testDB.LookupExportFiles(ctx, ec.ConfigID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"batch1-1.zip", "batch1-2.zip"}, files); diff != "" {
+		t.Errorf("index mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Only the rows of the deleted batch are deleted.
+	n, err := testDB.CountExportBatchesBefore(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("CountExportBatchesBefore: got %d, want 1", n)
+	}
+	n, err = testDB.DeleteExportBatchesBefore(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("DeleteExportBatchesBefore: deleted %d batches, want 1", n)
+	}
+	if _, err := testDB.LookupExportBatch(ctx, batches[0].BatchID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted batch: got %v, want ErrNotFound", err)
+	}
+	if _, err := testDB.LookupExportBatch(ctx, batches[1].BatchID); err != nil {
+		t.Errorf("complete batch: %v", err)
+	}
+}
+
+func TestDeltaExportConfig(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Microsecond)
+	day := now.Truncate(24 * time.Hour).Add(-48 * time.Hour)
+
+	full := &ExportConfig{
+		BucketName:   "some-bucket",
+		FilenameRoot: "full",
+		Period:       24 * time.Hour,
+		Region:       "US",
+	}
+	if err := testDB.AddExportConfig(ctx, full); err != nil {
+		t.Fatal(err)
+	}
+
+	// A delta config must be for the same region as its full config, with a
+	// smaller period.
+	invalid := []*ExportConfig{
+		{FilenameRoot: "missing", Period: time.Hour, Region: "US", DeltaOfConfigID: full.ConfigID + 100},
+		{FilenameRoot: "region", Period: time.Hour, Region: "CA", DeltaOfConfigID: full.ConfigID},
+		{FilenameRoot: "period", Period: 24 * time.Hour, Region: "US", DeltaOfConfigID: full.ConfigID},
+	}
+	for _, ec := range invalid {
+		if err := testDB.AddExportConfig(ctx, ec); err == nil {
+			t.Errorf("AddExportConfig(%s): expected error", ec.FilenameRoot)
+		}
+	}
+
+	delta := &ExportConfig{
+		BucketName:      "some-bucket",
+		FilenameRoot:    "delta",
+		Period:          time.Hour,
+		Region:          "US",
+		DeltaOfConfigID: full.ConfigID,
+	}
+	if err := testDB.AddExportConfig(ctx, delta); err != nil {
+		t.Fatal(err)
+	}
+
+	complete := func(ec *ExportConfig, start time.Time, filename string) {
+		t.Helper()
+		eb := &ExportBatch{
+			ConfigID:        ec.ConfigID,
+			BucketName:      ec.BucketName,
+			FilenameRoot:    ec.FilenameRoot,
+			StartTimestamp:  start,
+			EndTimestamp:    start.Add(ec.Period),
+			Region:          ec.Region,
+			Status:          ExportBatchOpen,
+			DeltaOfConfigID: ec.DeltaOfConfigID,
+		}
+		if err := testDB.AddExportBatches(ctx, []*ExportBatch{eb}); err != nil {
+			t.Fatal(err)
+		}
+		leased, err := testDB.LeaseBatch(ctx, time.Hour, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leased.DeltaOfConfigID != ec.DeltaOfConfigID {
+			t.Errorf("batch DeltaOfConfigID = %d, want %d", leased.DeltaOfConfigID, ec.DeltaOfConfigID)
+		}
+		if err := testDB.FinalizeBatch(ctx, leased, []string{filename}, []int64{100}, nil, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	complete(delta, day.Add(22*time.Hour), "delta/22.zip")
+	complete(delta, day.Add(23*time.Hour), "delta/23.zip")
+	complete(delta, day.Add(24*time.Hour), "delta/24.zip")
+
+	// Until the full config has a complete batch, all deltas are listed.
+	files, err := testDB.LookupExportFiles(ctx, delta.ConfigID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"delta/22.zip", "delta/23.zip", "delta/24.zip"}, files); diff != "" {
+		t.Errorf("index mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Deltas covered by a complete full batch are no longer listed.
+	complete(full, day, "full/0.zip")
+	files, err = testDB.LookupExportFiles(ctx, delta.ConfigID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"delta/24.zip"}, files); diff != "" {
+		t.Errorf("index mismatch (-want, +got):\n%s", diff)
+	}
+	files, err = testDB.LookupExportFiles(ctx, full.ConfigID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"full/0.zip"}, files); diff != "" {
+		t.Errorf("full index mismatch (-want, +got):\n%s", diff)
+	}
+}
+
 // TestKeysInBatch ensures that keys are fetched in the correct batch when they fall on boundary conditions.
 func TestKeysInBatch(t *testing.T) {
 	if testDB == nil {
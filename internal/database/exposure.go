@@ -16,21 +16,33 @@ package database
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/base64util"
 	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/observability"
 
+	"github.com/jackc/pgconn"
 	pgx "github.com/jackc/pgx/v4"
 )
 
 const (
 	// InsertExposuresBatchSize is the maximum number of exposures that can be inserted at once.
 	InsertExposuresBatchSize = 500
+
+	// MaxPageSize is the largest page of exposures that can be requested in a
+	// single call to IterateExposures.
+	MaxPageSize = 50000
+
+	// pgUniqueViolation is the Postgres error code for a unique constraint
+	// violation.
+	pgUniqueViolation = "23505"
 )
 
 // IterateExposuresCriteria is criteria to iterate exposures.
@@ -43,36 +55,124 @@ type IterateExposuresCriteria struct {
 
 	// OnlyLocalProvenance indicates that only exposures with LocalProvenance=true will be returned.
 	OnlyLocalProvenance bool
+
+	// ExcludeLocalProvenance indicates that only exposures with
+	// LocalProvenance=false, i.e. those received through federation, will be
+	// returned. Setting both this and OnlyLocalProvenance matches nothing.
+	ExcludeLocalProvenance bool
+
+	// ExcludeFederated excludes every exposure that arrived through
+	// federation; see Exposure.Federated. Unlike OnlyLocalProvenance, it also
+	// excludes exposures with a federation sync or origin, whatever their
+	// provenance flag says.
+	ExcludeFederated bool
+
+	// IncludeTransmissionRisks, if non-empty, restricts results to exposures
+	// with one of the given transmission risks.
+	IncludeTransmissionRisks []int
+
+	// MinTransmissionRisk, if positive, restricts results to exposures with a
+	// transmission risk greater than or equal to this value.
+	MinTransmissionRisk int
+
+	// IncludeReportTypes, if non-empty, restricts results to exposures with
+	// one of the given report types. The empty string matches exposures
+	// without a report type.
+	IncludeReportTypes []string
+
+	// PageSize, if positive, caps the number of exposures returned by a single
+	// call to IterateExposures. It must not exceed MaxPageSize.
+	PageSize int
+
+	// ReadPreference determines whether the exposures may be read from a
+	// replica. The default is to read from the primary.
+	ReadPreference ReadPreference
+
+	// OnlyRevokedKeys indicates that only tombstoned exposures will be
+	// returned. When set, SinceTimestamp and UntilTimestamp are matched against
+	// the time the exposure was tombstoned rather than when it was created.
+	// Tombstoned exposures are never returned otherwise.
+	OnlyRevokedKeys bool
+
+	// HealthAuthorityID, if set, restricts results to exposures published
+	// through an app belonging to that health authority.
+	HealthAuthorityID string
+
+	// IncludeTravelers extends HealthAuthorityID to also match travelers'
+	// exposures from any health authority.
+	IncludeTravelers bool
+
+	// LookbackHorizon, if positive, restricts the exposures created before
+	// LookbackUntil to those changed by transactions with IDs of at least
+	// LookbackHorizon, which an earlier read with that horizon may not have
+	// seen. See IterateExposuresWithHorizon.
+	LookbackHorizon int64
+	LookbackUntil   time.Time
 }
 
 // IterateExposures calls f on each Exposure in the database that matches the
 // given criteria. If f returns an error, the iteration stops, and the returned
 // error will match f's error with errors.Is.
 //
-// If an error occurs during the query, IterateExposures will return a non-empty
-// string along with a non-nil error. That string, when passed as
-// criteria.LastCursor in a subsequent call to IterateExposures, will continue
-// the iteration at the failed row. If IterateExposures returns a nil error,
+// If an error occurs during the query and a cursor secret is configured,
+// IterateExposures will return a non-empty string along with a non-nil error.
+// That string, when passed as criteria.LastCursor in a subsequent call to
+// IterateExposures with the same criteria, will continue the iteration at the
+// failed row. If IterateExposures returns a nil error,
 // the first return value will be the empty string.
-func (db *DB) IterateExposures(ctx context.Context, criteria IterateExposuresCriteria, f func(*Exposure) error) (cur string, err error) {
-	conn, err := db.Pool.Acquire(ctx)
+//
+// If criteria.PageSize is set and a full page of results was returned,
+// IterateExposures returns a non-empty cursor along with a nil error. Passing
+// that cursor as criteria.LastCursor returns the next page. Paging requires a
+// cursor secret. An empty cursor
+// with a nil error indicates there are no more results.
+func (db *DB) IterateExposures(ctx context.Context, criteria IterateExposuresCriteria, f func(*Exposure) error) (string, error) {
+	return db.iterateExposures(ctx, criteria, f, nil)
+}
+
+// IterateExposuresWithHorizon is IterateExposures without paging. It also
+// returns the change horizon of the read: every exposure changed by a
+// transaction with an ID below the horizon was visible to it, whether or not
+// it matched. A later read can pass the horizon as LookbackHorizon to skip the
+// exposures this read already saw.
+func (db *DB) IterateExposuresWithHorizon(ctx context.Context, criteria IterateExposuresCriteria, f func(*Exposure) error) (int64, error) {
+	if criteria.PageSize != 0 || criteria.LastCursor != "" {
+		return 0, errors.New("IterateExposuresWithHorizon doesn't page")
+	}
+	var horizon int64
+	if _, err := db.iterateExposures(ctx, criteria, f, &horizon); err != nil {
+		return 0, err
+	}
+	return horizon, nil
+}
+
+// iterateExposures implements IterateExposures. If horizon isn't nil, it is
+// set to the change horizon of the read.
+func (db *DB) iterateExposures(ctx context.Context, criteria IterateExposuresCriteria, f func(*Exposure) error, horizon *int64) (cur string, err error) {
+	if criteria.PageSize < 0 || criteria.PageSize > MaxPageSize {
+		return "", fmt.Errorf("page size must be >= 0 and <= %d, got %d", MaxPageSize, criteria.PageSize)
+	}
+	if criteria.PageSize > 0 && !db.SignsCursors() {
+		return "", fmt.Errorf("paging exposures: %w", errNoCursorSecret)
+	}
+
+	ctx, span := observability.StartSpan(ctx, "database.IterateExposures")
+	defer observability.EndSpan(span, &err)
+
+	conn, err := db.readPool(ctx, criteria).Acquire(ctx)
 	if err != nil {
 		return "", fmt.Errorf("acquiring connection: %v", err)
 	}
 	defer conn.Release()
 	offset := 0
 	if criteria.LastCursor != "" {
-		offsetStr, err := decodeCursor(criteria.LastCursor)
+		offset, err = db.decodeCursor(criteria.LastCursor, criteria)
 		if err != nil {
 			return "", fmt.Errorf("decoding cursor: %v", err)
 		}
-		offset, err = strconv.Atoi(offsetStr)
-		if err != nil {
-			return "", fmt.Errorf("decoding cursor %v", err)
-		}
 	}
 
-	query, args, err := generateExposureQuery(criteria)
+	query, args, err := generateExposureQuery(criteria, offset)
 	if err != nil {
 		return "", fmt.Errorf("generating where: %v", err)
 	}
@@ -82,13 +182,23 @@ func (db *DB) IterateExposures(ctx context.Context, criteria IterateExposuresCri
 	// TODO: this is a pretty weak cursor solution, but not too bad since we'll
 	// typically have queries ahead of the cleanup and before the current
 	// ingestion window, and those should be stable.
-	cursor := func() string { return encodeCursor(strconv.Itoa(offset)) }
+	cursor := func() string { return db.encodeCursor(offset, criteria) }
+
+	if horizon != nil {
+		// Transactions below the xmin of a snapshot taken before the query,
+		// on the same server, are finished before the query's snapshot.
+		row := conn.QueryRow(ctx, `SELECT txid_snapshot_xmin(txid_current_snapshot())`)
+		if err := row.Scan(horizon); err != nil {
+			return "", fmt.Errorf("reading change horizon: %w", err)
+		}
+	}
 
 	rows, err := conn.Query(ctx, query, args...)
 	if err != nil {
 		return cursor(), err
 	}
 	defer rows.Close()
+	count := 0
 	for rows.Next() {
 		if err := ctx.Err(); err != nil {
 			return cursor(), err
@@ -99,11 +209,12 @@ func (db *DB) IterateExposures(ctx context.Context, criteria IterateExposuresCri
 			syncID     *int64
 		)
 		if err := rows.Scan(&encodedKey, &m.TransmissionRisk, &m.AppPackageName, &m.Regions, &m.IntervalNumber,
-			&m.IntervalCount, &m.CreatedAt, &m.LocalProvenance, &syncID); err != nil {
+			&m.IntervalCount, &m.CreatedAt, &m.LocalProvenance, &syncID, &m.ReportType, &m.DaysSinceSymptomOnset,
+			&m.HealthAuthorityID, &m.Traveler, &m.Origin); err != nil {
 			return cursor(), err
 		}
 		var err error
-		m.ExposureKey, err = decodeExposureKey(encodedKey)
+		m.ExposureKey, err = db.openExposureKey(ctx, encodedKey)
 		if err != nil {
 			return cursor(), err
 		}
@@ -114,23 +225,54 @@ func (db *DB) IterateExposures(ctx context.Context, criteria IterateExposuresCri
 			return cursor(), err
 		}
 		offset++
+		count++
 	}
 	if err := rows.Err(); err != nil {
 		return cursor(), err
 	}
+	// A full page means there may be more results; hand back a cursor so the
+	// caller can resume from here.
+	if criteria.PageSize > 0 && count == criteria.PageSize {
+		return cursor(), nil
+	}
 	return "", nil
 }
 
-func generateExposureQuery(criteria IterateExposuresCriteria) (string, []interface{}, error) {
-	var args []interface{}
+func generateExposureQuery(criteria IterateExposuresCriteria, offset int) (string, []interface{}, error) {
+	where, args, timeColumn := exposureCriteriaClause(criteria)
 	q := `
 		SELECT
 			exposure_key, transmission_risk, app_package_name, regions, interval_number, interval_count,
-			created_at, local_provenance, sync_id
+			created_at, local_provenance, sync_id, report_type, days_since_onset, health_authority_id,
+			traveler, COALESCE(origin, '')
 		FROM
 			Exposure
-		WHERE 1=1
-	`
+		WHERE ` + where
+
+	// Exposures created in the same window share their timestamp, so the key
+	// breaks ties to keep the order, and the pages, stable.
+	q += " ORDER BY " + timeColumn + ", exposure_key"
+
+	if criteria.PageSize > 0 {
+		args = append(args, criteria.PageSize)
+		q += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	if offset > 0 {
+		args = append(args, offset)
+		q += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+	q = strings.ReplaceAll(q, "\n", " ")
+
+	return q, args, nil
+}
+
+// exposureCriteriaClause returns the WHERE clause and its arguments that
+// select the exposures matching criteria, along with the timestamp column the
+// criteria's time range applies to. Paging fields are ignored.
+func exposureCriteriaClause(criteria IterateExposuresCriteria) (string, []interface{}, string) {
+	var args []interface{}
+	q := "1=1"
 
 	if len(criteria.IncludeRegions) == 1 {
 		args = append(args, criteria.IncludeRegions)
@@ -142,6 +284,14 @@ func generateExposureQuery(criteria IterateExposuresCriteria) (string, []interfa
 		q += fmt.Sprintf(" AND NOT (regions && $%d)", len(args)) // Operation "&&" means "array overlaps / intersects"
 	}
 
+	timeColumn := "created_at"
+	if criteria.OnlyRevokedKeys {
+		timeColumn = "deleted_at"
+		q += " AND deleted_at IS NOT NULL"
+	} else {
+		q += " AND deleted_at IS NULL"
+	}
+
 	// It is important for StartTimestamp to be inclusive (as opposed to exclusive). When the exposure keys are
 	// published, they are truncated to a time boundary (e.g., time.Hour). Even though the exposure keys might arrive
 	// during a current open export batch window, the exposure keys are truncated to the start of that window,
@@ -149,12 +299,18 @@ func generateExposureQuery(criteria IterateExposuresCriteria) (string, []interfa
 	// (in the case where the publish window and the export period align).
 	if !criteria.SinceTimestamp.IsZero() {
 		args = append(args, criteria.SinceTimestamp)
-		q += fmt.Sprintf(" AND created_at >= $%d", len(args))
+		q += fmt.Sprintf(" AND %s >= $%d", timeColumn, len(args))
 	}
 
 	if !criteria.UntilTimestamp.IsZero() {
 		args = append(args, criteria.UntilTimestamp)
-		q += fmt.Sprintf(" AND created_at < $%d", len(args))
+		q += fmt.Sprintf(" AND %s < $%d", timeColumn, len(args))
+	}
+
+	if criteria.LookbackHorizon > 0 {
+		// The high bits of change_id hold the ID of the changing transaction.
+		args = append(args, criteria.LookbackUntil, criteria.LookbackHorizon)
+		q += fmt.Sprintf(" AND (%s >= $%d OR (change_id >> 20) >= $%d)", timeColumn, len(args)-1, len(args))
 	}
 
 	if criteria.OnlyLocalProvenance {
@@ -162,51 +318,474 @@ func generateExposureQuery(criteria IterateExposuresCriteria) (string, []interfa
 		q += fmt.Sprintf(" AND local_provenance = $%d", len(args))
 	}
 
-	q += " ORDER BY created_at"
+	if criteria.ExcludeLocalProvenance {
+		args = append(args, false)
+		q += fmt.Sprintf(" AND local_provenance = $%d", len(args))
+	}
 
-	if criteria.LastCursor != "" {
-		decoded, err := decodeCursor(criteria.LastCursor)
-		if err != nil {
-			return "", nil, err
+	if criteria.ExcludeFederated {
+		q += " AND local_provenance AND sync_id IS NULL AND origin IS NULL"
+	}
+
+	if len(criteria.IncludeTransmissionRisks) > 0 {
+		args = append(args, criteria.IncludeTransmissionRisks)
+		q += fmt.Sprintf(" AND transmission_risk = ANY($%d)", len(args))
+	}
+
+	if criteria.MinTransmissionRisk > 0 {
+		args = append(args, criteria.MinTransmissionRisk)
+		q += fmt.Sprintf(" AND transmission_risk >= $%d", len(args))
+	}
+
+	if len(criteria.IncludeReportTypes) > 0 {
+		args = append(args, criteria.IncludeReportTypes)
+		q += fmt.Sprintf(" AND report_type = ANY($%d)", len(args))
+	}
+
+	if criteria.HealthAuthorityID != "" {
+		args = append(args, criteria.HealthAuthorityID)
+		if criteria.IncludeTravelers {
+			q += fmt.Sprintf(" AND (health_authority_id = $%d OR traveler)", len(args))
+		} else {
+			q += fmt.Sprintf(" AND health_authority_id = $%d", len(args))
 		}
-		args = append(args, decoded)
-		q += fmt.Sprintf(" OFFSET $%d", len(args))
 	}
-	q = strings.ReplaceAll(q, "\n", " ")
 
-	return q, args, nil
+	return q, args, timeColumn
 }
 
-// InsertExposures inserts a set of exposures.
+// ConflictPolicy determines how UpsertExposures treats an exposure whose key
+// already exists in the database.
+type ConflictPolicy int
+
+const (
+	// OnConflictSkip leaves the existing exposure untouched.
+	OnConflictSkip ConflictPolicy = iota
+
+	// OnConflictReplace overwrites the existing exposure with the new one.
+	OnConflictReplace
+
+	// OnConflictMergeRegions keeps the existing exposure but adds any regions
+	// from the new exposure that were not already present.
+	OnConflictMergeRegions
+)
+
+func (p ConflictPolicy) String() string {
+	switch p {
+	case OnConflictSkip:
+		return "skip"
+	case OnConflictReplace:
+		return "replace"
+	case OnConflictMergeRegions:
+		return "merge-regions"
+	default:
+		return fmt.Sprintf("ConflictPolicy(%d)", int(p))
+	}
+}
+
+// conflictClause returns the ON CONFLICT clause for the given policy, where
+// keyColumn is the unique column that identifies an exposure by its key.
+func conflictClause(policy ConflictPolicy, keyColumn string) (string, error) {
+	switch policy {
+	case OnConflictSkip:
+		return "ON CONFLICT DO NOTHING", nil
+	case OnConflictReplace:
+		return `ON CONFLICT (` + keyColumn + `) DO UPDATE
+			SET transmission_risk = EXCLUDED.transmission_risk, app_package_name = EXCLUDED.app_package_name,
+			    regions = EXCLUDED.regions, interval_number = EXCLUDED.interval_number,
+			    interval_count = EXCLUDED.interval_count, created_at = EXCLUDED.created_at,
+			    local_provenance = EXCLUDED.local_provenance, sync_id = EXCLUDED.sync_id,
+			    report_type = EXCLUDED.report_type, revision_token = EXCLUDED.revision_token,
+			    days_since_onset = EXCLUDED.days_since_onset, health_authority_id = EXCLUDED.health_authority_id,
+			    traveler = EXCLUDED.traveler, origin = EXCLUDED.origin`, nil
+	case OnConflictMergeRegions:
+		return `ON CONFLICT (` + keyColumn + `) DO UPDATE
+			SET regions = ARRAY(SELECT DISTINCT UNNEST(Exposure.regions || EXCLUDED.regions) ORDER BY 1)`, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %v", policy)
+	}
+}
+
+// InsertExposures inserts a set of exposures. Exposures whose key already
+// exists are skipped.
 func (db *DB) InsertExposures(ctx context.Context, exposures []*Exposure) error {
+	return db.UpsertExposures(ctx, exposures, OnConflictSkip)
+}
+
+// UpsertExposures inserts a set of exposures, resolving exposures whose key
+// already exists according to policy. A conflict never fails the batch.
+func (db *DB) UpsertExposures(ctx context.Context, exposures []*Exposure, policy ConflictPolicy) error {
+	if _, err := conflictClause(policy, db.exposureKeyColumn()); err != nil {
+		return err
+	}
+
 	return db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
-		const stmtName = "insert exposures"
+		n, err := db.upsertExposures(ctx, tx, exposures, policy)
+		if err != nil {
+			return err
+		}
+		return writeOutboxEvent(ctx, tx, exposures, n)
+	})
+}
+
+// upsertExposures writes exposures within tx and returns the number of rows
+// inserted or updated.
+func (db *DB) upsertExposures(ctx context.Context, tx pgx.Tx, exposures []*Exposure, policy ConflictPolicy) (int64, error) {
+	onConflict, err := conflictClause(policy, db.exposureKeyColumn())
+	if err != nil {
+		return 0, err
+	}
+	if err := db.hashLegacyExposures(ctx, tx, exposures); err != nil {
+		return 0, err
+	}
+
+	stmtName := "upsert exposures " + policy.String()
+	_, err = tx.Prepare(ctx, stmtName, `
+		INSERT INTO
+			Exposure
+		    (`+strings.Join(exposureColumns, ", ")+`)
+		VALUES
+		  ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		`+onConflict)
+	if err != nil {
+		return 0, fmt.Errorf("preparing insert statement: %w", err)
+	}
+
+	var written int64
+	for _, inf := range exposures {
+		values, err := db.exposureColumnValues(ctx, inf)
+		if err != nil {
+			return 0, err
+		}
+		result, err := tx.Exec(ctx, stmtName, values...)
+		if err != nil {
+			return 0, fmt.Errorf("inserting exposure: %w", err)
+		}
+		written += result.RowsAffected()
+	}
+	return written, nil
+}
+
+// InsertResult is the outcome of inserting a single exposure with
+// InsertExposuresDedupe.
+type InsertResult int
+
+const (
+	// InsertAccepted means the exposure was inserted.
+	InsertAccepted InsertResult = iota
+
+	// InsertDuplicate means an exposure with the same key and interval number
+	// already existed, typically because a client retried a publish.
+	InsertDuplicate
+
+	// InsertConflict means an exposure with the same key but a different
+	// interval number already existed.
+	InsertConflict
+)
+
+func (r InsertResult) String() string {
+	switch r {
+	case InsertAccepted:
+		return "accepted"
+	case InsertDuplicate:
+		return "duplicate"
+	case InsertConflict:
+		return "conflict"
+	default:
+		return fmt.Sprintf("InsertResult(%d)", int(r))
+	}
+}
+
+// InsertExposuresDedupe inserts a set of exposures, skipping any whose key was
+// already inserted, whether by an earlier batch or earlier in this one. It
+// returns the outcome for each exposure, in the same order as exposures.
+func (db *DB) InsertExposuresDedupe(ctx context.Context, exposures []*Exposure) ([]InsertResult, error) {
+	var results []InsertResult
+	err := db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		results = make([]InsertResult, 0, len(exposures))
+		if err := db.hashLegacyExposures(ctx, tx, exposures); err != nil {
+			return err
+		}
+
+		const stmtName = "insert exposures dedupe"
 		_, err := tx.Prepare(ctx, stmtName, `
 			INSERT INTO
 				Exposure
-			    (exposure_key, transmission_risk, app_package_name, regions, interval_number, interval_count,
-			     created_at, local_provenance, sync_id)
+			    (`+strings.Join(exposureColumns, ", ")+`)
 			VALUES
-			  ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-			ON CONFLICT (exposure_key) DO NOTHING
-		`)
+			  ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+			ON CONFLICT DO NOTHING
+			RETURNING exposure_key
+			`)
 		if err != nil {
-			return fmt.Errorf("preparing insert statement: %v", err)
+			return fmt.Errorf("preparing insert statement: %w", err)
 		}
 
+		var accepted int64
 		for _, inf := range exposures {
-			var syncID *int64
-			if inf.FederationSyncID != 0 {
-				syncID = &inf.FederationSyncID
+			values, err := db.exposureColumnValues(ctx, inf)
+			if err != nil {
+				return err
 			}
-			_, err := tx.Exec(ctx, stmtName, encodeExposureKey(inf.ExposureKey), inf.TransmissionRisk, inf.AppPackageName, inf.Regions, inf.IntervalNumber, inf.IntervalCount,
-				inf.CreatedAt, inf.LocalProvenance, syncID)
+			var key string
+			err = tx.QueryRow(ctx, stmtName, values...).Scan(&key)
+			if err == nil {
+				results = append(results, InsertAccepted)
+				accepted++
+				continue
+			}
+			if err != pgx.ErrNoRows {
+				return fmt.Errorf("inserting exposure: %w", err)
+			}
+
+			var intervalNumber int32
+			row := tx.QueryRow(ctx, `
+				SELECT
+					interval_number
+				FROM
+					Exposure
+				WHERE
+					exposure_key = $1 OR exposure_key_hash = $2
+				`, values[0], db.hashExposureKey(inf.ExposureKey))
+			if err := row.Scan(&intervalNumber); err != nil {
+				return fmt.Errorf("reading existing exposure: %w", err)
+			}
+			if intervalNumber == inf.IntervalNumber {
+				results = append(results, InsertDuplicate)
+			} else {
+				results = append(results, InsertConflict)
+			}
+		}
+		return writeOutboxEvent(ctx, tx, exposures, accepted)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// exposureColumns are the columns written when inserting an exposure, in the
+// order returned by exposureColumnValues.
+var exposureColumns = []string{
+	"exposure_key", "transmission_risk", "app_package_name", "regions", "interval_number", "interval_count",
+	"created_at", "local_provenance", "sync_id", "report_type", "revision_token", "days_since_onset",
+	"health_authority_id", "traveler", "origin", "exposure_key_hash",
+}
+
+func (db *DB) exposureColumnValues(ctx context.Context, inf *Exposure) ([]interface{}, error) {
+	encodedKey, err := db.sealExposureKey(ctx, inf.ExposureKey)
+	if err != nil {
+		return nil, err
+	}
+	var syncID *int64
+	if inf.FederationSyncID != 0 {
+		syncID = &inf.FederationSyncID
+	}
+	var origin *string
+	if inf.Origin != "" {
+		origin = &inf.Origin
+	}
+	return []interface{}{
+		encodedKey, inf.TransmissionRisk, inf.AppPackageName, inf.Regions, inf.IntervalNumber, inf.IntervalCount,
+		inf.CreatedAt, inf.LocalProvenance, syncID, inf.ReportType, hashRevisionToken(inf.RevisionToken), inf.DaysSinceSymptomOnset,
+		inf.HealthAuthorityID, inf.Traveler, origin, db.hashExposureKey(inf.ExposureKey),
+	}, nil
+}
+
+// BulkInsertExposures inserts a large set of exposures using the COPY
+// protocol, which is much faster than InsertExposures for large batches such
+// as federation pulls. COPY can't skip conflicting rows, so if any exposure
+// already exists the batch falls back to row-by-row inserts that skip
+// conflicts, with the same result as InsertExposures.
+func (db *DB) BulkInsertExposures(ctx context.Context, exposures []*Exposure) error {
+	return db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		return db.bulkInsertExposures(ctx, tx, exposures)
+	})
+}
+
+// BulkInsertExposuresInChunks inserts exposures like BulkInsertExposures,
+// chunkSize at a time, in a single transaction: either all of the exposures
+// are stored or none are. A chunkSize of zero or less inserts them all at
+// once.
+func (db *DB) BulkInsertExposuresInChunks(ctx context.Context, exposures []*Exposure, chunkSize int) error {
+	if chunkSize <= 0 {
+		chunkSize = len(exposures)
+	}
+	return db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		for start := 0; start < len(exposures); start += chunkSize {
+			end := start + chunkSize
+			if end > len(exposures) {
+				end = len(exposures)
+			}
+			if err := db.bulkInsertExposures(ctx, tx, exposures[start:end]); err != nil {
+				return fmt.Errorf("inserting exposures %d to %d of %d: %w", start, end, len(exposures), err)
+			}
+		}
+		return nil
+	})
+}
+
+// bulkInsertExposures copies exposures into the exposure table within tx.
+func (db *DB) bulkInsertExposures(ctx context.Context, tx pgx.Tx, exposures []*Exposure) error {
+	logger := logging.FromContext(ctx)
+
+	if err := db.hashLegacyExposures(ctx, tx, exposures); err != nil {
+		return err
+	}
+
+	// Copy inside a savepoint so that a conflict doesn't abort the
+	// enclosing transaction.
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("creating savepoint: %w", err)
+	}
+
+	rows := make([][]interface{}, 0, len(exposures))
+	for _, inf := range exposures {
+		values, err := db.exposureColumnValues(ctx, inf)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, values)
+	}
+	n, err := sp.CopyFrom(ctx, pgx.Identifier{"exposure"}, exposureColumns, pgx.CopyFromRows(rows))
+	if err == nil {
+		if err := sp.Commit(ctx); err != nil {
+			return fmt.Errorf("releasing savepoint: %w", err)
+		}
+		return writeOutboxEvent(ctx, tx, exposures, n)
+	}
+
+	if rbErr := sp.Rollback(ctx); rbErr != nil {
+		return fmt.Errorf("rolling back savepoint: %w", rbErr)
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgUniqueViolation {
+		return fmt.Errorf("copying exposures: %w", err)
+	}
+
+	logger.Infof("bulk insert of %d exposures conflicted, falling back to row inserts", len(exposures))
+	n, err = db.upsertExposures(ctx, tx, exposures, OnConflictSkip)
+	if err != nil {
+		return err
+	}
+	return writeOutboxEvent(ctx, tx, exposures, n)
+}
+
+// ReviseExposures revises previously published exposures, for example when a
+// likely diagnosis is later confirmed. Each exposure must either be new, in
+// which case it is inserted, or match an existing exposure that was published
+// with the same revision token. Existing exposures have their report type and
+// transmission risk replaced and their created_at moved to the revision's
+// created_at so that exports pick them up again; the prior state is recorded
+// in revised_from.
+//
+// The revision is atomic: if any exposure has a mismatched revision token or
+// an invalid report type transition, nothing is changed. The number of
+// existing exposures that were revised is returned.
+func (db *DB) ReviseExposures(ctx context.Context, revisionToken string, exposures []*Exposure) (int, error) {
+	if revisionToken == "" {
+		return 0, ErrInvalidRevisionToken
+	}
+	tokenHash := hashRevisionToken(revisionToken)
+
+	revised := 0
+	err := db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		revised = 0
+		for _, exp := range exposures {
+			var encodedKey, reportType string
+			var storedHash *string
+			row := tx.QueryRow(ctx, `
+				SELECT
+					exposure_key, report_type, revision_token
+				FROM
+					Exposure
+				WHERE
+					exposure_key = ANY($1) OR exposure_key_hash = $2
+				FOR UPDATE
+				`, db.exposureKeyCandidates(exp.ExposureKey), db.hashExposureKey(exp.ExposureKey))
+			if err := row.Scan(&encodedKey, &reportType, &storedHash); err != nil {
+				if err == pgx.ErrNoRows {
+					if err := db.insertRevisedExposure(ctx, tx, exp, tokenHash); err != nil {
+						return err
+					}
+					continue
+				}
+				return fmt.Errorf("reading exposure: %w", err)
+			}
+
+			if storedHash == nil || *storedHash != *tokenHash {
+				return ErrInvalidRevisionToken
+			}
+			if reportType == exp.ReportType {
+				continue
+			}
+			if !ValidReportTypeTransition(reportType, exp.ReportType) {
+				return fmt.Errorf("%w: %q to %q", ErrInvalidReportTypeTransition, reportType, exp.ReportType)
+			}
+
+			_, err := tx.Exec(ctx, `
+				UPDATE
+					Exposure
+				SET
+					revised_from = jsonb_build_object(
+						'report_type', report_type,
+						'transmission_risk', transmission_risk,
+						'days_since_onset', days_since_onset,
+						'created_at', created_at),
+					report_type = $2, transmission_risk = $3, created_at = $4, revised_at = $5,
+					days_since_onset = COALESCE($6, days_since_onset)
+				WHERE
+					exposure_key = $1
+				`, encodedKey, exp.ReportType, exp.TransmissionRisk, exp.CreatedAt, time.Now().UTC(), exp.DaysSinceSymptomOnset)
 			if err != nil {
-				return fmt.Errorf("inserting exposure: %v", err)
+				return fmt.Errorf("revising exposure: %w", err)
 			}
+			revised++
 		}
 		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+	return revised, nil
+}
+
+func (db *DB) insertRevisedExposure(ctx context.Context, tx pgx.Tx, exp *Exposure, tokenHash *string) error {
+	var syncID *int64
+	if exp.FederationSyncID != 0 {
+		syncID = &exp.FederationSyncID
+	}
+	encodedKey, err := db.sealExposureKey(ctx, exp.ExposureKey)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO
+			Exposure
+		    (exposure_key, transmission_risk, app_package_name, regions, interval_number, interval_count,
+		     created_at, local_provenance, sync_id, report_type, revision_token, days_since_onset,
+		     health_authority_id, traveler, exposure_key_hash)
+		VALUES
+		  ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		`, encodedKey, exp.TransmissionRisk, exp.AppPackageName, exp.Regions, exp.IntervalNumber, exp.IntervalCount,
+		exp.CreatedAt, exp.LocalProvenance, syncID, exp.ReportType, tokenHash, exp.DaysSinceSymptomOnset,
+		exp.HealthAuthorityID, exp.Traveler, db.hashExposureKey(exp.ExposureKey))
+	if err != nil {
+		return fmt.Errorf("inserting exposure: %w", err)
+	}
+	return nil
+}
+
+// hashRevisionToken returns the hex encoded SHA-256 of token, or nil if token
+// is empty.
+func hashRevisionToken(token string) *string {
+	if token == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(token))
+	h := hex.EncodeToString(sum[:])
+	return &h
 }
 
 // DeleteExposures deletes exposures created before "before" date. Returns the number of records deleted.
@@ -221,7 +800,40 @@ func (db *DB) DeleteExposures(ctx context.Context, before time.Time) (int64, err
 				created_at < $1
 			`, before)
 		if err != nil {
-			return fmt.Errorf("deleting exposures: %v", err)
+			return fmt.Errorf("deleting exposures: %w", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// DeleteExposuresBatch deletes at most limit exposures created before the
+// given time, so large deletes can be split into short transactions that
+// don't hold locks for long. It returns the number of exposures deleted, which
+// is less than limit once no more remain.
+func (db *DB) DeleteExposuresBatch(ctx context.Context, before time.Time, limit int) (int64, error) {
+	var count int64
+	err := db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				Exposure
+			WHERE
+				exposure_key IN (
+					SELECT
+						exposure_key
+					FROM
+						Exposure
+					WHERE
+						created_at < $1
+					LIMIT $2
+				)
+			`, before, limit)
+		if err != nil {
+			return fmt.Errorf("deleting exposures: %w", err)
 		}
 		count = result.RowsAffected()
 		return nil
@@ -232,16 +844,54 @@ func (db *DB) DeleteExposures(ctx context.Context, before time.Time) (int64, err
 	return count, nil
 }
 
-func encodeCursor(s string) string {
-	return base64.StdEncoding.EncodeToString([]byte(s))
+// TombstoneExposures marks exposures created before the given time as deleted
+// instead of removing them, so that revocation exports can report them. The
+// rows are removed later by PurgeExposures. It returns the number of exposures
+// tombstoned.
+func (db *DB) TombstoneExposures(ctx context.Context, before time.Time) (int64, error) {
+	var count int64
+	err := db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			UPDATE
+				Exposure
+			SET
+				deleted_at = $2
+			WHERE
+				created_at < $1 AND deleted_at IS NULL
+			`, before, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("tombstoning exposures: %w", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
 }
 
-func decodeCursor(encoded string) (string, error) {
-	b, err := base64util.DecodeString(encoded)
+// PurgeExposures deletes exposures that were tombstoned before the given time.
+// It returns the number of exposures deleted.
+func (db *DB) PurgeExposures(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	var count int64
+	err := db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				Exposure
+			WHERE
+				deleted_at < $1
+			`, deletedBefore)
+		if err != nil {
+			return fmt.Errorf("purging exposures: %w", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("decoding cursor: %v", err)
+		return 0, err
 	}
-	return string(b), nil
+	return count, nil
 }
 
 func encodeExposureKey(b []byte) string {
@@ -18,6 +18,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"math"
 	"sort"
 	"strings"
 	"time"
@@ -41,6 +42,10 @@ const (
 	MinIntervalCount = 1
 	MaxIntervalCount = 144
 
+	// Days since symptom onset constraints (inclusive..inclusive)
+	MinDaysSinceSymptomOnset = -14
+	MaxDaysSinceSymptomOnset = 14
+
 	// Self explanatory.
 	// oneDay = time.Hour * 24
 
@@ -48,6 +53,56 @@ const (
 	intervalLength = 10 * time.Minute
 )
 
+// Report types describe how a diagnosis was reached. An exposure without a
+// report type predates report type support.
+const (
+	ReportTypeConfirmed = "confirmed"
+	ReportTypeLikely    = "likely"
+	ReportTypeNegative  = "negative"
+)
+
+// Transmission risks assigned to keys uploaded without an explicit risk,
+// based on their report type.
+const (
+	TransmissionRiskConfirmed = 2
+	TransmissionRiskLikely    = 4
+	TransmissionRiskNegative  = 6
+)
+
+// ValidReportType returns true if reportType is a known report type or empty.
+func ValidReportType(reportType string) bool {
+	switch reportType {
+	case "", ReportTypeConfirmed, ReportTypeLikely, ReportTypeNegative:
+		return true
+	}
+	return false
+}
+
+// ValidReportTypeTransition returns true if an exposure published with report
+// type from may be revised to report type to. Only likely diagnoses may be
+// revised, either to confirmed or to negative.
+func ValidReportTypeTransition(from, to string) bool {
+	return from == ReportTypeLikely && (to == ReportTypeConfirmed || to == ReportTypeNegative)
+}
+
+// ReportTypeTransmissionRisk returns the transmission risk for a key. If the
+// client provided a non-zero risk it is used, otherwise the risk is derived
+// from the report type.
+func ReportTypeTransmissionRisk(reportType string, providedRisk int) int {
+	if providedRisk != 0 {
+		return providedRisk
+	}
+	switch reportType {
+	case ReportTypeConfirmed:
+		return TransmissionRiskConfirmed
+	case ReportTypeLikely:
+		return TransmissionRiskLikely
+	case ReportTypeNegative:
+		return TransmissionRiskNegative
+	}
+	return providedRisk
+}
+
 // Publish represents the body of the PublishInfectedIds API call.
 // Keys: Required and must have length >= 1 and <= 21 (`maxKeysPerPublish`)
 // Regions: Array of regions. System defined, must match configuration.
@@ -61,6 +116,18 @@ const (
 // VerificationAuthorityName: a string that should be verified against the code provider.
 //  Note: This project doesn't directly include a diagnosis code verification System
 //        but does provide the ability to configure one in `serverevn.ServerEnv`
+// Padding: Optional. Random data clients add so that the size of the request
+//   doesn't reveal its contents. It is ignored.
+// ReportType: Optional. One of "confirmed", "likely" or "negative".
+// RevisionToken: Optional. The token returned by a previous publish. If set,
+//   the keys revise previously published keys instead of being inserted.
+// HMACKey: Optional. Base64 encoded key the client used to compute the HMAC of
+//   the keys that is bound into the verification certificate.
+// Traveler: Optional. True if the user visited other regions. Regions may then
+//   also list the regions visited, if the app's health authority allows it.
+// SymptomOnsetInterval: Optional. The interval number of the day symptoms
+//   started. The days since onset of each key that doesn't carry its own are
+//   computed from it.
 type Publish struct {
 	Keys                      []ExposureKey `json:"temporaryExposureKeys"`
 	Regions                   []string      `json:"regions"`
@@ -69,6 +136,11 @@ type Publish struct {
 	DeviceVerificationPayload string        `json:"deviceVerificationPayload"`
 	VerificationPayload       string        `json:"verificationPayload"`
 	Padding                   string        `json:"padding"`
+	ReportType                string        `json:"reportType"`
+	RevisionToken             string        `json:"revisionToken"`
+	HMACKey                   string        `json:"hmackey"`
+	Traveler                  bool          `json:"traveler"`
+	SymptomOnsetInterval      int32         `json:"symptomOnsetInterval,omitempty"`
 }
 
 // AndroidNonce returns the Android. This ensures that the data in the request
@@ -128,11 +200,13 @@ func (p *Publish) AndroidNonce() string {
 // IntervalCount must >= `minIntervalCount` and <= `maxIntervalCount`
 //   1 - 144 inclusive.
 // transmissionRisk must be >= 0 and <= 8
+// daysSinceOnsetOfSymptoms is optional and must be >= -14 and <= 14
 type ExposureKey struct {
-	Key              string `json:"key"`
-	IntervalNumber   int32  `json:"rollingStartNumber"`
-	IntervalCount    int32  `json:"rollingPeriod"`
-	TransmissionRisk int    `json:"transmissionRisk"`
+	Key                      string `json:"key"`
+	IntervalNumber           int32  `json:"rollingStartNumber"`
+	IntervalCount            int32  `json:"rollingPeriod"`
+	TransmissionRisk         int    `json:"transmissionRisk"`
+	DaysSinceOnsetOfSymptoms *int32 `json:"daysSinceOnsetOfSymptoms,omitempty"`
 }
 
 // ExposureKeys represents a set of ExposureKey objects as input to
@@ -158,6 +232,52 @@ type Exposure struct {
 	CreatedAt        time.Time `db:"created_at"`
 	LocalProvenance  bool      `db:"local_provenance"`
 	FederationSyncID int64     `db:"sync_id"`
+	ReportType       string    `db:"report_type"`
+
+	// DaysSinceSymptomOnset is the number of days between symptom onset and
+	// the key's interval, or nil if unknown.
+	DaysSinceSymptomOnset *int32 `db:"days_since_onset"`
+
+	// RevisionToken is the ID of the revision token that permits later
+	// revision of this exposure. Only a hash of it is stored and it is never
+	// read back.
+	RevisionToken string `db:"-"`
+
+	// HealthAuthorityID identifies the health authority whose app published
+	// the exposure. It is empty for exposures that aren't scoped to one.
+	HealthAuthorityID string `db:"health_authority_id"`
+
+	// Traveler is true if the exposure's owner visited regions other than
+	// their home region. Exports can include travelers' exposures from other
+	// health authorities.
+	Traveler bool `db:"traveler"`
+
+	// ChangeID orders exposures by when they were last inserted or revised. It
+	// is assigned by the database and only read by SyncExposures.
+	ChangeID int64 `db:"change_id"`
+
+	// Origin identifies the server an exposure received through federation was
+	// first published to. It is empty for exposures published to this server.
+	Origin string `db:"origin"`
+}
+
+// Federated reports whether the exposure arrived through federation rather
+// than being published to this server. Federated exposures must never be
+// federated again, or keys would bounce between partners that federate with
+// each other.
+func (e *Exposure) Federated() bool {
+	return !e.LocalProvenance || e.FederationSyncID != 0 || e.Origin != ""
+}
+
+// DaysSinceSymptomOnset returns the number of days, rounded to the nearest
+// day, between the symptom onset interval and a key's interval. It returns nil
+// if they are too far apart to be stored.
+func DaysSinceSymptomOnset(intervalNumber, onsetInterval int32) *int32 {
+	days := int32(math.Round(float64(intervalNumber-onsetInterval) / float64(MaxIntervalCount)))
+	if days < MinDaysSinceSymptomOnset || days > MaxDaysSinceSymptomOnset {
+		return nil
+	}
+	return &days
 }
 
 // IntervalNumber calculates the exposure notification system interval
@@ -171,25 +291,81 @@ func TruncateWindow(t time.Time, d time.Duration) time.Time {
 	return t.Truncate(d)
 }
 
+// IntervalTime returns the start time of an interval number.
+func IntervalTime(intervalNumber int32) time.Time {
+	return time.Unix(int64(intervalNumber)*int64(intervalLength.Seconds()), 0).UTC()
+}
+
+// embargoUntil returns the creation time of an embargoed exposure: the end of
+// the creation window in which the exposure's key stops being valid, or
+// createdAt if the key is no longer valid by then.
+func embargoUntil(exp *Exposure, createdAt time.Time, truncateWindow time.Duration) time.Time {
+	end := IntervalTime(exp.IntervalNumber + exp.IntervalCount)
+	if !end.After(createdAt) {
+		return createdAt
+	}
+	release := TruncateWindow(end, truncateWindow)
+	if release.Before(end) {
+		release = release.Add(truncateWindow)
+	}
+	return release
+}
+
 // Transformer represents a configured Publish -> Exposure[] transformer.
 type Transformer struct {
 	maxExposureKeys     int
 	maxIntervalStartAge time.Duration // How many intervals old does this server accept?
 	truncateWindow      time.Duration
+	clockSkew           time.Duration // How far ahead of the server's clock may a device be?
+	stages              []TransformStage
+}
+
+// TransformerOption configures optional Transformer behavior.
+type TransformerOption func(*Transformer)
+
+// WithClockSkewTolerance allows keys that end up to d after the time of the
+// publish, to tolerate devices whose clocks run ahead of the server's.
+func WithClockSkewTolerance(d time.Duration) TransformerOption {
+	return func(t *Transformer) {
+		t.clockSkew = d
+	}
+}
+
+// WithTransformStages replaces the DefaultTransformStages that adjust the
+// exposures built from each publish request. The defaults are kept if no
+// stages are given.
+func WithTransformStages(stages ...TransformStage) TransformerOption {
+	return func(t *Transformer) {
+		t.stages = stages
+	}
 }
 
 // NewTransformer creates a transformer for turning publish API requests into
 // records for insertion into the database. On the call to TransformPublish
 // all data is validated according to the transformer that is used.
-func NewTransformer(maxExposureKeys int, maxIntervalStartAge time.Duration, truncateWindow time.Duration) (*Transformer, error) {
+func NewTransformer(maxExposureKeys int, maxIntervalStartAge time.Duration, truncateWindow time.Duration, opts ...TransformerOption) (*Transformer, error) {
 	if maxExposureKeys < 0 || maxExposureKeys > maxKeysPerPublish {
 		return nil, fmt.Errorf("maxExposureKeys must be > 0 and <= %v, got %v", maxKeysPerPublish, maxExposureKeys)
 	}
-	return &Transformer{
+	t := &Transformer{
 		maxExposureKeys:     maxExposureKeys,
 		maxIntervalStartAge: maxIntervalStartAge,
 		truncateWindow:      truncateWindow,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.stages == nil {
+		stages, err := TransformStagesByName(DefaultTransformStages)
+		if err != nil {
+			return nil, err
+		}
+		t.stages = stages
+	}
+	if t.clockSkew < 0 {
+		return nil, fmt.Errorf("clock skew tolerance must be >= 0, got %v", t.clockSkew)
+	}
+	return t, nil
 }
 
 // TransformExposureKey converts individual key data to an exposure entity.
@@ -200,6 +376,13 @@ func NewTransformer(maxExposureKeys int, maxIntervalStartAge time.Duration, trun
 // * MinIntervalCount <= interval count <= MaxIntervalCount
 //
 func TransformExposureKey(exposureKey ExposureKey, appPackageName string, upcaseRegions []string, createdAt time.Time, minIntervalNumber, maxIntervalNumber int32) (*Exposure, error) {
+	return transformExposureKey(exposureKey, appPackageName, upcaseRegions, createdAt, minIntervalNumber, maxIntervalNumber, maxIntervalNumber)
+}
+
+// transformExposureKey is TransformExposureKey with a separate bound on the
+// end of the key's interval, so that keys that are still valid can be
+// accepted.
+func transformExposureKey(exposureKey ExposureKey, appPackageName string, upcaseRegions []string, createdAt time.Time, minIntervalNumber, maxIntervalNumber, maxEndIntervalNumber int32) (*Exposure, error) {
 	binKey, err := base64util.DecodeString(exposureKey.Key)
 	if err != nil {
 		return nil, err
@@ -222,24 +405,29 @@ func TransformExposureKey(exposureKey ExposureKey, appPackageName string, upcase
 	}
 
 	// Validate that the key is no longer effective.
-	if exposureKey.IntervalNumber+exposureKey.IntervalCount > maxIntervalNumber {
+	if exposureKey.IntervalNumber+exposureKey.IntervalCount > maxEndIntervalNumber {
 		return nil, fmt.Errorf("interval number %v + interval count %v represents a key that is still valid, must end <= %v",
-			exposureKey.IntervalNumber, exposureKey.IntervalCount, maxIntervalNumber)
+			exposureKey.IntervalNumber, exposureKey.IntervalCount, maxEndIntervalNumber)
 	}
 
 	if tr := exposureKey.TransmissionRisk; tr < MinTransmissionRisk || tr > MaxTransmissionRisk {
 		return nil, fmt.Errorf("invalid transmission risk: %v, must be >= %v && <= %v", tr, MinTransmissionRisk, MaxTransmissionRisk)
 	}
 
+	if ds := exposureKey.DaysSinceOnsetOfSymptoms; ds != nil && (*ds < MinDaysSinceSymptomOnset || *ds > MaxDaysSinceSymptomOnset) {
+		return nil, fmt.Errorf("invalid days since symptom onset: %v, must be >= %v && <= %v", *ds, MinDaysSinceSymptomOnset, MaxDaysSinceSymptomOnset)
+	}
+
 	return &Exposure{
-		ExposureKey:      binKey,
-		TransmissionRisk: exposureKey.TransmissionRisk,
-		AppPackageName:   appPackageName,
-		Regions:          upcaseRegions,
-		IntervalNumber:   exposureKey.IntervalNumber,
-		IntervalCount:    exposureKey.IntervalCount,
-		CreatedAt:        createdAt,
-		LocalProvenance:  true,
+		ExposureKey:           binKey,
+		TransmissionRisk:      exposureKey.TransmissionRisk,
+		AppPackageName:        appPackageName,
+		Regions:               upcaseRegions,
+		IntervalNumber:        exposureKey.IntervalNumber,
+		IntervalCount:         exposureKey.IntervalCount,
+		CreatedAt:             createdAt,
+		LocalProvenance:       true,
+		DaysSinceSymptomOnset: exposureKey.DaysSinceOnsetOfSymptoms,
 	}, nil
 }
 
@@ -249,7 +437,23 @@ func TransformExposureKey(exposureKey ExposureKey, appPackageName string, upcase
 // * 0 exposure Keys in the requests
 // * > Transformer.maxExposureKeys in the request
 //
-func (t *Transformer) TransformPublish(inData *Publish, batchTime time.Time) ([]*Exposure, error) {
+//
+// Any extra stages run before the transformer's own, for settings that vary
+// by request, such as those of the app's health authority.
+func (t *Transformer) TransformPublish(inData *Publish, batchTime time.Time, extra ...TransformStage) ([]*Exposure, error) {
+	return t.transformPublish(inData, batchTime, false, extra)
+}
+
+// TransformPublishEmbargoed is TransformPublish for health authorities that
+// accept keys that are still valid. Such keys are embargoed: their CreatedAt
+// is the end of the creation window in which they stop being valid, so that
+// exports and federation, which only read complete windows, don't release
+// them before then.
+func (t *Transformer) TransformPublishEmbargoed(inData *Publish, batchTime time.Time, extra ...TransformStage) ([]*Exposure, error) {
+	return t.transformPublish(inData, batchTime, true, extra)
+}
+
+func (t *Transformer) transformPublish(inData *Publish, batchTime time.Time, embargo bool, extra []TransformStage) ([]*Exposure, error) {
 	// Validate the number of keys that want to be published.
 	if len(inData.Keys) == 0 {
 		return nil, fmt.Errorf("no exposure keys in publish request")
@@ -257,6 +461,12 @@ func (t *Transformer) TransformPublish(inData *Publish, batchTime time.Time) ([]
 	if len(inData.Keys) > t.maxExposureKeys {
 		return nil, fmt.Errorf("too many exposure keys in publish: %v, max of %v is allowed", len(inData.Keys), t.maxExposureKeys)
 	}
+	if !ValidReportType(inData.ReportType) {
+		return nil, fmt.Errorf("invalid report type: %q", inData.ReportType)
+	}
+	if onset := inData.SymptomOnsetInterval; onset < 0 || onset > IntervalNumber(batchTime.Add(t.clockSkew)) {
+		return nil, fmt.Errorf("invalid symptom onset interval: %v", onset)
+	}
 
 	createdAt := TruncateWindow(batchTime, t.truncateWindow)
 	entities := make([]*Exposure, 0, len(inData.Keys))
@@ -264,25 +474,37 @@ func (t *Transformer) TransformPublish(inData *Publish, batchTime time.Time) ([]
 	// An exposure key must have an interval >= minInterval (max configured age)
 	minIntervalNumber := IntervalNumber(batchTime.Add(-1 * t.maxIntervalStartAge))
 	// And have an interval <= maxInterval (configured allowed clock skew)
-	maxIntervalNumber := IntervalNumber(batchTime)
-
-	// Regions are a multi-value property, uppercase them for storage.
-	// There is no set of "valid" regions overall, but it is defined
-	// elsewhere by what regions an authorized application may write to.
-	// See `authorizedapp.Config`
-	upcaseRegions := make([]string, len(inData.Regions))
-	for i, r := range inData.Regions {
-		upcaseRegions[i] = strings.ToUpper(r)
+	maxIntervalNumber := IntervalNumber(batchTime.Add(t.clockSkew))
+	// And, unless embargoed, no longer be valid.
+	maxEndIntervalNumber := maxIntervalNumber
+	if embargo {
+		maxEndIntervalNumber = maxIntervalNumber + MaxIntervalCount
 	}
 
 	for _, exposureKey := range inData.Keys {
-		exposure, err := TransformExposureKey(exposureKey, inData.AppPackageName, upcaseRegions, createdAt, minIntervalNumber, maxIntervalNumber)
+		exposure, err := transformExposureKey(exposureKey, inData.AppPackageName, inData.Regions, createdAt, minIntervalNumber, maxIntervalNumber, maxEndIntervalNumber)
 		if err != nil {
 			return nil, fmt.Errorf("Invalid publish data: %v", err)
 		}
+		if embargo {
+			exposure.CreatedAt = embargoUntil(exposure, createdAt, t.truncateWindow)
+		}
+		exposure.ReportType = inData.ReportType
+		exposure.Traveler = inData.Traveler
+		if exposure.DaysSinceSymptomOnset == nil && inData.SymptomOnsetInterval > 0 {
+			exposure.DaysSinceSymptomOnset = DaysSinceSymptomOnset(exposure.IntervalNumber, inData.SymptomOnsetInterval)
+		}
 		entities = append(entities, exposure)
 	}
 
+	stages := make([]TransformStage, 0, len(extra)+len(t.stages))
+	stages = append(append(stages, extra...), t.stages...)
+	for _, stage := range stages {
+		if err := stage(inData, entities); err != nil {
+			return nil, fmt.Errorf("Invalid publish data: %v", err)
+		}
+	}
+
 	// Ensure that the uploaded keys are for a consecutive time period. No
 	// overlaps and no gaps.
 	// 1) Sort by interval number.
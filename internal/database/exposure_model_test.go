@@ -236,6 +236,34 @@ func TestPublishValidation(t *testing.T) {
 			},
 			m: fmt.Sprintf("interval number %v + interval count %v represents a key that is still valid, must end <= %v", currentInterval-143, 144, currentInterval),
 		},
+		{
+			name: "invalid report type",
+			p: &Publish{
+				Keys: []ExposureKey{
+					{
+						Key:            encodeKey(generateKey(t)),
+						IntervalNumber: currentInterval - 2,
+						IntervalCount:  1,
+					},
+				},
+				ReportType: "maybe",
+			},
+			m: `invalid report type: "maybe"`,
+		},
+		{
+			name: "days since symptom onset too high",
+			p: &Publish{
+				Keys: []ExposureKey{
+					{
+						Key:                      encodeKey(generateKey(t)),
+						IntervalNumber:           currentInterval - 2,
+						IntervalCount:            1,
+						DaysSinceOnsetOfSymptoms: int32Ptr(MaxDaysSinceSymptomOnset + 1),
+					},
+				},
+			},
+			m: fmt.Sprintf("invalid days since symptom onset: %v, must be >= %v && <= %v", MaxDaysSinceSymptomOnset+1, MinDaysSinceSymptomOnset, MaxDaysSinceSymptomOnset),
+		},
 	}
 
 	for _, c := range cases {
@@ -440,6 +468,85 @@ func TestTransform(t *testing.T) {
 	}
 }
 
+func TestTransformClockSkew(t *testing.T) {
+	batchTime := time.Date(2020, 2, 29, 11, 15, 1, 0, time.UTC)
+	source := &Publish{
+		Keys: []ExposureKey{
+			{
+				Key:            encodeKey(generateKey(t)),
+				IntervalNumber: IntervalNumber(batchTime),
+				IntervalCount:  3, // ends 30 minutes after batchTime
+			},
+		},
+		Regions:        []string{"US"},
+		AppPackageName: "com.google",
+	}
+
+	allowedAge := 14 * 24 * time.Hour
+	transformer, err := NewTransformer(10, allowedAge, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transformer.TransformPublish(source, batchTime); err == nil {
+		t.Errorf("expected key ending after batch time to be rejected")
+	}
+
+	transformer, err = NewTransformer(10, allowedAge, time.Hour, WithClockSkewTolerance(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transformer.TransformPublish(source, batchTime); err != nil {
+		t.Errorf("TransformPublish with clock skew tolerance returned unexpected error: %v", err)
+	}
+
+	if _, err := NewTransformer(10, allowedAge, time.Hour, WithClockSkewTolerance(-time.Minute)); err == nil {
+		t.Errorf("expected error for negative clock skew tolerance")
+	}
+}
+
+func TestTransformEmbargoed(t *testing.T) {
+	batchTime := time.Date(2020, 2, 29, 11, 15, 1, 0, time.UTC)
+	today := IntervalNumber(time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC))
+	source := &Publish{
+		Keys: []ExposureKey{
+			{
+				Key:            encodeKey(generateKey(t)),
+				IntervalNumber: today - MaxIntervalCount,
+				IntervalCount:  MaxIntervalCount,
+			},
+			{
+				Key:            encodeKey(generateKey(t)),
+				IntervalNumber: today,
+				IntervalCount:  MaxIntervalCount,
+			},
+		},
+		Regions:        []string{"US"},
+		AppPackageName: "com.google",
+	}
+
+	transformer, err := NewTransformer(10, 14*24*time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transformer.TransformPublish(source, batchTime); err == nil {
+		t.Errorf("expected still valid key to be rejected")
+	}
+
+	got, err := transformer.TransformPublishEmbargoed(source, batchTime)
+	if err != nil {
+		t.Fatalf("TransformPublishEmbargoed returned unexpected error: %v", err)
+	}
+	want := []time.Time{
+		time.Date(2020, 2, 29, 11, 0, 0, 0, time.UTC),
+		time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for i, exp := range got {
+		if !exp.CreatedAt.Equal(want[i]) {
+			t.Errorf("key %d: got CreatedAt %v, want %v", i, exp.CreatedAt, want[i])
+		}
+	}
+}
+
 func TestTransformOverlapping(t *testing.T) {
 	captureStartTime := time.Date(2020, 2, 29, 11, 15, 1, 0, time.UTC)
 	intervalNumber := IntervalNumber(captureStartTime)
@@ -506,3 +613,118 @@ func TestTransformOverlapping(t *testing.T) {
 		})
 	}
 }
+
+func TestReportTypeTransmissionRisk(t *testing.T) {
+	cases := []struct {
+		reportType string
+		provided   int
+		want       int
+	}{
+		{"", 0, 0},
+		{"", 3, 3},
+		{ReportTypeConfirmed, 0, TransmissionRiskConfirmed},
+		{ReportTypeLikely, 0, TransmissionRiskLikely},
+		{ReportTypeNegative, 0, TransmissionRiskNegative},
+		{ReportTypeConfirmed, 7, 7},
+	}
+	for _, c := range cases {
+		if got := ReportTypeTransmissionRisk(c.reportType, c.provided); got != c.want {
+			t.Errorf("ReportTypeTransmissionRisk(%q, %d) = %d, want %d", c.reportType, c.provided, got, c.want)
+		}
+	}
+}
+
+func TestValidReportTypeTransition(t *testing.T) {
+	cases := []struct {
+		from, to string
+		want     bool
+	}{
+		{ReportTypeLikely, ReportTypeConfirmed, true},
+		{ReportTypeLikely, ReportTypeNegative, true},
+		{ReportTypeConfirmed, ReportTypeLikely, false},
+		{ReportTypeConfirmed, ReportTypeNegative, false},
+		{"", ReportTypeConfirmed, false},
+		{ReportTypeLikely, "", false},
+	}
+	for _, c := range cases {
+		if got := ValidReportTypeTransition(c.from, c.to); got != c.want {
+			t.Errorf("ValidReportTypeTransition(%q, %q) = %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestDaysSinceSymptomOnset(t *testing.T) {
+	onset := IntervalNumber(time.Date(2020, 2, 20, 0, 0, 0, 0, time.UTC))
+	cases := []struct {
+		interval int32
+		want     *int32
+	}{
+		{onset, int32Ptr(0)},
+		{onset + MaxIntervalCount, int32Ptr(1)},
+		{onset - 3*MaxIntervalCount, int32Ptr(-3)},
+		{onset + MaxIntervalCount/2, int32Ptr(1)},
+		{onset + MaxIntervalCount/2 - 1, int32Ptr(0)},
+		{onset + 14*MaxIntervalCount, int32Ptr(14)},
+		{onset + 15*MaxIntervalCount, nil},
+		{onset - 15*MaxIntervalCount, nil},
+	}
+	for _, c := range cases {
+		got := DaysSinceSymptomOnset(c.interval, onset)
+		if diff := cmp.Diff(c.want, got); diff != "" {
+			t.Errorf("DaysSinceSymptomOnset(%v, %v) mismatch (-want, +got):\n%s", c.interval, onset, diff)
+		}
+	}
+}
+
+func TestTransformSymptomOnset(t *testing.T) {
+	batchTime := time.Date(2020, 2, 29, 11, 15, 1, 0, time.UTC)
+	today := IntervalNumber(time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC))
+	source := &Publish{
+		Keys: []ExposureKey{
+			{
+				Key:            encodeKey(generateKey(t)),
+				IntervalNumber: today - 3*MaxIntervalCount,
+				IntervalCount:  MaxIntervalCount,
+			},
+			{
+				Key:                      encodeKey(generateKey(t)),
+				IntervalNumber:           today - 2*MaxIntervalCount,
+				IntervalCount:            MaxIntervalCount,
+				DaysSinceOnsetOfSymptoms: int32Ptr(4),
+			},
+			{
+				Key:            encodeKey(generateKey(t)),
+				IntervalNumber: today - MaxIntervalCount,
+				IntervalCount:  MaxIntervalCount,
+			},
+		},
+		Regions:              []string{"US"},
+		AppPackageName:       "com.google",
+		SymptomOnsetInterval: today - 2*MaxIntervalCount,
+	}
+
+	transformer, err := NewTransformer(10, 14*24*time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := transformer.TransformPublish(source, batchTime)
+	if err != nil {
+		t.Fatalf("TransformPublish returned unexpected error: %v", err)
+	}
+	// Keys that carry their own days since onset keep them.
+	want := []*int32{int32Ptr(-1), int32Ptr(4), int32Ptr(1)}
+	for i, exp := range got {
+		if diff := cmp.Diff(want[i], exp.DaysSinceSymptomOnset); diff != "" {
+			t.Errorf("key %d: days since onset mismatch (-want, +got):\n%s", i, diff)
+		}
+	}
+
+	source.SymptomOnsetInterval = today + MaxIntervalCount
+	if _, err := transformer.TransformPublish(source, batchTime); err == nil {
+		t.Errorf("expected symptom onset in the future to be rejected")
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// ExposureStats are aggregate counts of the exposures matching some criteria.
+type ExposureStats struct {
+	// Total is the number of matching exposures.
+	Total int64
+
+	// ByRegion is the number of matching exposures in each region. An exposure
+	// with several regions is counted in each of them.
+	ByRegion map[string]int64
+
+	// ByDay is the number of matching exposures per UTC day, keyed by the start
+	// of the day. The day is taken from the timestamp the criteria's time range
+	// applies to.
+	ByDay map[time.Time]int64
+
+	// ByReportType is the number of matching exposures per report type.
+	// Exposures without a report type are counted under the empty string.
+	ByReportType map[string]int64
+}
+
+// CountExposures returns the number of exposures matching criteria. The
+// paging fields of criteria are ignored.
+func (db *DB) CountExposures(ctx context.Context, criteria IterateExposuresCriteria) (int64, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	where, args, _ := exposureCriteriaClause(criteria)
+	var count int64
+	if err := conn.QueryRow(ctx, `SELECT COUNT(*) FROM Exposure WHERE `+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("counting exposures: %w", err)
+	}
+	return count, nil
+}
+
+// ExposureStats returns aggregate counts of the exposures matching criteria.
+// The paging fields of criteria are ignored.
+func (db *DB) ExposureStats(ctx context.Context, criteria IterateExposuresCriteria) (*ExposureStats, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	where, args, timeColumn := exposureCriteriaClause(criteria)
+	stats := &ExposureStats{
+		ByRegion:     make(map[string]int64),
+		ByDay:        make(map[time.Time]int64),
+		ByReportType: make(map[string]int64),
+	}
+
+	if err := conn.QueryRow(ctx, `SELECT COUNT(*) FROM Exposure WHERE `+where, args...).Scan(&stats.Total); err != nil {
+		return nil, fmt.Errorf("counting exposures: %w", err)
+	}
+
+	err = queryGroupCounts(ctx, conn, `
+		SELECT
+			region, COUNT(*)
+		FROM
+			Exposure, UNNEST(regions) AS region
+		WHERE `+where+`
+		GROUP BY region`, args, func(rows pgx.Rows) error {
+		var region string
+		var n int64
+		if err := rows.Scan(&region, &n); err != nil {
+			return err
+		}
+		stats.ByRegion[region] = n
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("counting exposures by region: %w", err)
+	}
+
+	err = queryGroupCounts(ctx, conn, `
+		SELECT
+			date_trunc('day', `+timeColumn+` AT TIME ZONE 'UTC') AS day, COUNT(*)
+		FROM
+			Exposure
+		WHERE `+where+`
+		GROUP BY day`, args, func(rows pgx.Rows) error {
+		var day time.Time
+		var n int64
+		if err := rows.Scan(&day, &n); err != nil {
+			return err
+		}
+		stats.ByDay[time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)] = n
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("counting exposures by day: %w", err)
+	}
+
+	err = queryGroupCounts(ctx, conn, `
+		SELECT
+			report_type, COUNT(*)
+		FROM
+			Exposure
+		WHERE `+where+`
+		GROUP BY report_type`, args, func(rows pgx.Rows) error {
+		var reportType string
+		var n int64
+		if err := rows.Scan(&reportType, &n); err != nil {
+			return err
+		}
+		stats.ByReportType[reportType] = n
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("counting exposures by report type: %w", err)
+	}
+
+	return stats, nil
+}
+
+// queryGroupCounts runs query and calls f on each resulting row.
+func queryGroupCounts(ctx context.Context, conn *pgxpool.Conn, query string, args []interface{}, f func(pgx.Rows) error) error {
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := f(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
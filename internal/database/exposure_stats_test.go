@@ -0,0 +1,87 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExposureStats(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	day1 := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	exposures := []*Exposure{
+		{ExposureKey: []byte("ABC"), Regions: []string{"US"}, IntervalNumber: 1, IntervalCount: 144, CreatedAt: day1, ReportType: ReportTypeConfirmed},
+		{ExposureKey: []byte("DEF"), Regions: []string{"US", "CA"}, IntervalNumber: 1, IntervalCount: 144, CreatedAt: day1.Add(time.Hour), ReportType: ReportTypeLikely},
+		{ExposureKey: []byte("GHI"), Regions: []string{"MX"}, IntervalNumber: 1, IntervalCount: 144, CreatedAt: day2},
+	}
+	if err := testDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		criteria IterateExposuresCriteria
+		want     *ExposureStats
+	}{
+		{
+			name:     "all",
+			criteria: IterateExposuresCriteria{},
+			want: &ExposureStats{
+				Total:        3,
+				ByRegion:     map[string]int64{"US": 2, "CA": 1, "MX": 1},
+				ByDay:        map[time.Time]int64{day1: 2, day2: 1},
+				ByReportType: map[string]int64{ReportTypeConfirmed: 1, ReportTypeLikely: 1, "": 1},
+			},
+		},
+		{
+			name:     "since",
+			criteria: IterateExposuresCriteria{SinceTimestamp: day2},
+			want: &ExposureStats{
+				Total:        1,
+				ByRegion:     map[string]int64{"MX": 1},
+				ByDay:        map[time.Time]int64{day2: 1},
+				ByReportType: map[string]int64{"": 1},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := testDB.ExposureStats(ctx, test.criteria)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+
+			count, err := testDB.CountExposures(ctx, test.criteria)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != test.want.Total {
+				t.Errorf("CountExposures: got %d, want %d", count, test.want.Total)
+			}
+		})
+	}
+}
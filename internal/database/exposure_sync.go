@@ -0,0 +1,128 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// SyncExposuresCriteria selects the exposures returned by SyncExposures.
+type SyncExposuresCriteria struct {
+	// SinceChangeID is the change ID returned by the previous call, or 0 to
+	// start from the beginning.
+	SinceChangeID int64
+
+	// Limit, if positive, caps the number of exposures returned by a single
+	// call. It must not exceed MaxPageSize.
+	Limit int
+
+	// Region, if set, restricts results to exposures of that region, read
+	// from the database that stores them; see ForRegion. Change IDs are only
+	// comparable within one database, so a high-water mark must be kept per
+	// region when residency is configured.
+	Region string
+
+	// ReadPreference determines whether the exposures may be read from a
+	// replica. The default is to read from the primary.
+	ReadPreference ReadPreference
+}
+
+// SyncExposures calls f, in change order, for up to criteria.Limit exposures
+// that were inserted or revised after the change ID criteria.SinceChangeID,
+// and returns the change ID to pass as SinceChangeID on the next call.
+// Tombstoned exposures are skipped.
+//
+// Unlike created_at, change IDs are safe to use as a high-water mark: an
+// exposure is only returned once every transaction that could write an
+// earlier change ID has finished, so a later call never returns a change
+// that sorts before the mark.
+func (db *DB) SyncExposures(ctx context.Context, criteria SyncExposuresCriteria, f func(*Exposure) error) (int64, error) {
+	sinceChangeID := criteria.SinceChangeID
+	if criteria.Limit < 0 || criteria.Limit > MaxPageSize {
+		return sinceChangeID, fmt.Errorf("limit must be >= 0 and <= %d, got %d", MaxPageSize, criteria.Limit)
+	}
+
+	store := db
+	if criteria.Region != "" {
+		store = db.ForRegion(criteria.Region)
+	}
+
+	// On a replica, the snapshot below only sees replayed transactions, so the
+	// high-water mark stays behind anything it hasn't replayed yet.
+	pool := store.readPool(ctx, IterateExposuresCriteria{ReadPreference: criteria.ReadPreference})
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return sinceChangeID, fmt.Errorf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	args := []interface{}{sinceChangeID}
+	q := `
+		SELECT
+			exposure_key, transmission_risk, app_package_name, regions, interval_number, interval_count,
+			created_at, local_provenance, sync_id, report_type, days_since_onset, change_id,
+			health_authority_id, traveler, COALESCE(origin, '')
+		FROM
+			Exposure
+		WHERE
+			change_id > $1 AND
+			(change_id >> 20) < txid_snapshot_xmin(txid_current_snapshot()) AND
+			deleted_at IS NULL`
+	if criteria.Region != "" {
+		args = append(args, []string{criteria.Region})
+		q += fmt.Sprintf(" AND (regions && $%d)", len(args))
+	}
+	q += " ORDER BY change_id"
+	if criteria.Limit > 0 {
+		args = append(args, criteria.Limit)
+		q += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := conn.Query(ctx, q, args...)
+	if err != nil {
+		return sinceChangeID, fmt.Errorf("querying exposures: %w", err)
+	}
+	defer rows.Close()
+
+	mark := sinceChangeID
+	for rows.Next() {
+		var (
+			m          Exposure
+			encodedKey string
+			syncID     *int64
+		)
+		if err := rows.Scan(&encodedKey, &m.TransmissionRisk, &m.AppPackageName, &m.Regions, &m.IntervalNumber,
+			&m.IntervalCount, &m.CreatedAt, &m.LocalProvenance, &syncID, &m.ReportType, &m.DaysSinceSymptomOnset,
+			&m.ChangeID, &m.HealthAuthorityID, &m.Traveler, &m.Origin); err != nil {
+			return mark, err
+		}
+		m.ExposureKey, err = store.openExposureKey(ctx, encodedKey)
+		if err != nil {
+			return mark, err
+		}
+		if syncID != nil {
+			m.FederationSyncID = *syncID
+		}
+		if err := f(&m); err != nil {
+			return mark, err
+		}
+		mark = m.ChangeID
+	}
+	if err := rows.Err(); err != nil {
+		return mark, err
+	}
+	return mark, nil
+}
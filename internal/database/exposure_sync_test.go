@@ -0,0 +1,97 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSyncExposures(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	var exposures []*Exposure
+	for _, key := range []string{"AAA", "BBB", "CCC"} {
+		exposures = append(exposures, &Exposure{
+			ExposureKey:      []byte(key),
+			TransmissionRisk: 2,
+			Regions:          []string{"US"},
+			IntervalNumber:   100,
+			IntervalCount:    144,
+			CreatedAt:        createdAt,
+		})
+	}
+	if err := testDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	sync := func(since int64, limit int) ([]string, int64) {
+		t.Helper()
+		var keys []string
+		mark, err := testDB.SyncExposures(ctx, SyncExposuresCriteria{SinceChangeID: since, Limit: limit}, func(e *Exposure) error {
+			keys = append(keys, string(e.ExposureKey))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys, mark
+	}
+
+	got, mark := sync(0, 2)
+	if diff := cmp.Diff([]string{"AAA", "BBB"}, got); diff != "" {
+		t.Errorf("first page mismatch (-want, +got):\n%s", diff)
+	}
+	got, mark = sync(mark, 2)
+	if diff := cmp.Diff([]string{"CCC"}, got); diff != "" {
+		t.Errorf("second page mismatch (-want, +got):\n%s", diff)
+	}
+	got, same := sync(mark, 2)
+	if len(got) != 0 || same != mark {
+		t.Errorf("sync at high-water mark returned %v, %d; want nothing, %d", got, same, mark)
+	}
+
+	// Revising an exposure moves it past the high-water mark.
+	revised := *exposures[0]
+	revised.TransmissionRisk = 5
+	if err := testDB.UpsertExposures(ctx, []*Exposure{&revised}, OnConflictReplace); err != nil {
+		t.Fatal(err)
+	}
+	// Re-inserting an unchanged exposure doesn't.
+	if err := testDB.InsertExposures(ctx, exposures[1:2]); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = sync(mark, 0)
+	if diff := cmp.Diff([]string{"AAA"}, got); diff != "" {
+		t.Errorf("revision mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestSyncExposuresInvalidLimit(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	if _, err := testDB.SyncExposures(context.Background(), SyncExposuresCriteria{Limit: -1}, nil); err == nil {
+		t.Errorf("expected error for negative limit")
+	}
+}
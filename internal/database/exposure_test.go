@@ -16,7 +16,10 @@ package database
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -64,6 +67,7 @@ func TestExposures(t *testing.T) {
 			CreatedAt:       batchTime.Add(3 * time.Hour),
 			Regions:         []string{"US"},
 			LocalProvenance: false,
+			Origin:          "partner.example.com",
 		},
 	}
 	if err := testDB.InsertExposures(ctx, exposures); err != nil {
@@ -107,6 +111,30 @@ func TestExposures(t *testing.T) {
 			},
 			nil,
 		},
+		{
+			IterateExposuresCriteria{OnlyLocalProvenance: true},
+			[]int{0, 1},
+		},
+		{
+			IterateExposuresCriteria{ExcludeLocalProvenance: true},
+			[]int{2, 3},
+		},
+		{
+			IterateExposuresCriteria{IncludeRegions: []string{"US"}, ExcludeLocalProvenance: true},
+			[]int{3},
+		},
+		{
+			IterateExposuresCriteria{ExcludeRegions: []string{"US"}, OnlyLocalProvenance: true},
+			[]int{1},
+		},
+		{
+			IterateExposuresCriteria{OnlyLocalProvenance: true, ExcludeLocalProvenance: true},
+			nil,
+		},
+		{
+			IterateExposuresCriteria{ExcludeFederated: true},
+			[]int{0, 1},
+		},
 	} {
 		got, err := listExposures(ctx, test.criteria)
 		if err != nil {
@@ -121,7 +149,14 @@ func TestExposures(t *testing.T) {
 		}
 	}
 
-	// Delete some exposures.
+	// Delete some exposures, after counting them.
+	count, err := testDB.CountExposuresBefore(ctx, exposures[2].CreatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("CountExposuresBefore: got %d, want 2", count)
+	}
 	gotN, err := testDB.DeleteExposures(ctx, exposures[2].CreatedAt)
 	if err != nil {
 		t.Fatal(err)
@@ -138,6 +173,16 @@ func TestExposures(t *testing.T) {
 		t.Errorf("DeleteExposures: mismatch (-want, +got):\n%s", diff)
 	}
 
+	// Delete the rest in batches of one.
+	for _, want := range []int64{1, 1, 0} {
+		gotN, err := testDB.DeleteExposuresBatch(ctx, time.Now(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotN != want {
+			t.Errorf("DeleteExposuresBatch: deleted %d, want %d", gotN, want)
+		}
+	}
 }
 
 func listExposures(ctx context.Context, c IterateExposuresCriteria) (_ []*Exposure, err error) {
@@ -194,7 +239,7 @@ func TestIterateExposuresCursor(t *testing.T) {
 	if diff := cmp.Diff(exposures[:2], seen); diff != "" {
 		t.Fatalf("exposures mismatch (-want, +got):\n%s", diff)
 	}
-	if want := encodeCursor("2"); cursor != want {
+	if want := testDB.encodeCursor(2, IterateExposuresCriteria{}); cursor != want {
 		t.Fatalf("cursor: got %q, want %q", cursor, want)
 	}
 	// Resume from the cursor.
@@ -212,3 +257,844 @@ func TestIterateExposuresCursor(t *testing.T) {
 		t.Fatalf("cursor: got %q, want empty", cursor)
 	}
 }
+
+func TestIterateExposuresPageSize(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	batchTime := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC).Truncate(time.Microsecond)
+	var exposures []*Exposure
+	for i := 0; i < 5; i++ {
+		exposures = append(exposures, &Exposure{
+			ExposureKey:    []byte{byte('A' + i)},
+			Regions:        []string{"US"},
+			IntervalNumber: int32(i),
+			CreatedAt:      batchTime.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	if err := testDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	// Page through with a page size that doesn't evenly divide the results.
+	criteria := IterateExposuresCriteria{PageSize: 2}
+	var pages [][]*Exposure
+	for {
+		var page []*Exposure
+		cursor, err := testDB.IterateExposures(ctx, criteria, func(e *Exposure) error {
+			page = append(page, e)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, page)
+		if cursor == "" {
+			break
+		}
+		if len(pages) > len(exposures) {
+			t.Fatalf("too many pages, cursor %q never ended", cursor)
+		}
+		criteria.LastCursor = cursor
+	}
+
+	want := [][]*Exposure{exposures[0:2], exposures[2:4], exposures[4:]}
+	if diff := cmp.Diff(want, pages); diff != "" {
+		t.Errorf("pages mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestIterateExposuresPageSizeSameCreatedAt(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	// Exposures of one creation window share their created_at.
+	batchTime := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	var want []string
+	var exposures []*Exposure
+	for i := 0; i < 7; i++ {
+		key := []byte{byte('A' + i)}
+		want = append(want, string(key))
+		exposures = append(exposures, &Exposure{
+			ExposureKey:    key,
+			Regions:        []string{"US"},
+			IntervalNumber: int32(i),
+			CreatedAt:      batchTime,
+		})
+	}
+	if err := testDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	criteria := IterateExposuresCriteria{PageSize: 2}
+	var got []string
+	for pages := 0; ; pages++ {
+		if pages > len(exposures) {
+			t.Fatalf("too many pages, cursor %q never ended", criteria.LastCursor)
+		}
+		cursor, err := testDB.IterateExposures(ctx, criteria, func(e *Exposure) error {
+			got = append(got, string(e.ExposureKey))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cursor == "" {
+			break
+		}
+		criteria.LastCursor = cursor
+	}
+
+	// Every exposure is on exactly one page.
+	sort.Strings(got)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("keys mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestIterateExposuresInvalidPageSize(t *testing.T) {
+	// Page size validation happens before the database is contacted.
+	db := &DB{}
+	for _, pageSize := range []int{-1, MaxPageSize + 1} {
+		_, err := db.IterateExposures(context.Background(), IterateExposuresCriteria{PageSize: pageSize}, func(*Exposure) error { return nil })
+		if err == nil {
+			t.Errorf("page size %d: expected error", pageSize)
+		}
+	}
+}
+
+func TestIterateExposuresTransmissionRisk(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	batchTime := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC).Truncate(time.Microsecond)
+	var exposures []*Exposure
+	for i, risk := range []int{0, 2, 4, 6, 8} {
+		exposures = append(exposures, &Exposure{
+			ExposureKey:      []byte{byte('A' + i)},
+			TransmissionRisk: risk,
+			Regions:          []string{"US"},
+			CreatedAt:        batchTime.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	if err := testDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		criteria IterateExposuresCriteria
+		want     []int
+	}{
+		{
+			IterateExposuresCriteria{MinTransmissionRisk: 4},
+			[]int{2, 3, 4},
+		},
+		{
+			IterateExposuresCriteria{IncludeTransmissionRisks: []int{0, 6}},
+			[]int{0, 3},
+		},
+		{
+			IterateExposuresCriteria{IncludeTransmissionRisks: []int{2, 6}, MinTransmissionRisk: 4},
+			[]int{3},
+		},
+		{
+			IterateExposuresCriteria{MinTransmissionRisk: MaxTransmissionRisk + 1},
+			nil,
+		},
+	} {
+		got, err := listExposures(ctx, test.criteria)
+		if err != nil {
+			t.Fatalf("%+v: %v", test.criteria, err)
+		}
+		var want []*Exposure
+		for _, i := range test.want {
+			want = append(want, exposures[i])
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%+v: mismatch (-want, +got):\n%s", test.criteria, diff)
+		}
+	}
+}
+
+func TestIterateExposuresHealthAuthority(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	batchTime := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC).Truncate(time.Microsecond)
+	var exposures []*Exposure
+	for i, ha := range []string{"", "ha-1", "ha-2", "ha-1"} {
+		exposures = append(exposures, &Exposure{
+			ExposureKey:       []byte{byte('A' + i)},
+			Regions:           []string{"US"},
+			CreatedAt:         batchTime.Add(time.Duration(i) * time.Minute),
+			HealthAuthorityID: ha,
+		})
+	}
+	if err := testDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		healthAuthorityID string
+		want              []int
+	}{
+		{"", []int{0, 1, 2, 3}},
+		{"ha-1", []int{1, 3}},
+		{"ha-2", []int{2}},
+		{"ha-3", nil},
+	} {
+		got, err := listExposures(ctx, IterateExposuresCriteria{HealthAuthorityID: test.healthAuthorityID})
+		if err != nil {
+			t.Fatalf("%q: %v", test.healthAuthorityID, err)
+		}
+		var want []*Exposure
+		for _, i := range test.want {
+			want = append(want, exposures[i])
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%q: mismatch (-want, +got):\n%s", test.healthAuthorityID, diff)
+		}
+	}
+}
+
+func TestIterateExposuresIncludeTravelers(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	batchTime := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC).Truncate(time.Microsecond)
+	exposures := []*Exposure{
+		{ExposureKey: []byte("A"), Regions: []string{"US"}, HealthAuthorityID: "ha-1"},
+		{ExposureKey: []byte("B"), Regions: []string{"CA", "US"}, HealthAuthorityID: "ha-2", Traveler: true},
+		{ExposureKey: []byte("C"), Regions: []string{"CA"}, HealthAuthorityID: "ha-2", Traveler: true},
+		{ExposureKey: []byte("D"), Regions: []string{"US"}, HealthAuthorityID: "ha-2"},
+	}
+	for i, exp := range exposures {
+		exp.CreatedAt = batchTime.Add(time.Duration(i) * time.Minute)
+	}
+	if err := testDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		includeTravelers bool
+		want             []int
+	}{
+		{false, []int{0}},
+		{true, []int{0, 1}},
+	} {
+		criteria := IterateExposuresCriteria{
+			IncludeRegions:    []string{"US"},
+			HealthAuthorityID: "ha-1",
+			IncludeTravelers:  test.includeTravelers,
+		}
+		got, err := listExposures(ctx, criteria)
+		if err != nil {
+			t.Fatalf("%+v: %v", criteria, err)
+		}
+		var want []*Exposure
+		for _, i := range test.want {
+			want = append(want, exposures[i])
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%+v: mismatch (-want, +got):\n%s", criteria, diff)
+		}
+	}
+}
+
+func TestIterateExposuresReportTypes(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	batchTime := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC).Truncate(time.Microsecond)
+	exposures := []*Exposure{
+		{ExposureKey: []byte("A"), Regions: []string{"US"}, ReportType: ReportTypeConfirmed, TransmissionRisk: 2},
+		{ExposureKey: []byte("B"), Regions: []string{"US"}, ReportType: ReportTypeLikely, TransmissionRisk: 4},
+		{ExposureKey: []byte("C"), Regions: []string{"US"}, ReportType: ReportTypeNegative, TransmissionRisk: 6},
+		{ExposureKey: []byte("D"), Regions: []string{"US"}, TransmissionRisk: 5},
+	}
+	for i, exp := range exposures {
+		exp.CreatedAt = batchTime.Add(time.Duration(i) * time.Minute)
+	}
+	if err := testDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		reportTypes []string
+		minRisk     int
+		want        []int
+	}{
+		{nil, 0, []int{0, 1, 2, 3}},
+		{[]string{ReportTypeConfirmed}, 0, []int{0}},
+		{[]string{ReportTypeConfirmed, ReportTypeLikely}, 0, []int{0, 1}},
+		{[]string{ReportTypeConfirmed, ""}, 0, []int{0, 3}},
+		{nil, 4, []int{1, 2, 3}},
+		{[]string{ReportTypeConfirmed, ReportTypeLikely}, 3, []int{1}},
+	} {
+		criteria := IterateExposuresCriteria{
+			IncludeRegions:      []string{"US"},
+			IncludeReportTypes:  test.reportTypes,
+			MinTransmissionRisk: test.minRisk,
+		}
+		got, err := listExposures(ctx, criteria)
+		if err != nil {
+			t.Fatalf("%+v: %v", criteria, err)
+		}
+		var want []*Exposure
+		for _, i := range test.want {
+			want = append(want, exposures[i])
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%+v: mismatch (-want, +got):\n%s", criteria, diff)
+		}
+	}
+}
+
+func TestIterateExposuresLookbackHorizon(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	start := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	early := &Exposure{ExposureKey: []byte("A"), Regions: []string{"US"}, CreatedAt: start.Add(-30 * time.Minute)}
+	if err := testDB.InsertExposures(ctx, []*Exposure{early}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The previous batch's read sees the early exposure.
+	previous := IterateExposuresCriteria{IncludeRegions: []string{"US"}, UntilTimestamp: start}
+	var seen int
+	horizon, err := testDB.IterateExposuresWithHorizon(ctx, previous, func(*Exposure) error {
+		seen++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen != 1 || horizon <= 0 {
+		t.Fatalf("previous read saw %d exposures with horizon %d, want 1 with a positive horizon", seen, horizon)
+	}
+
+	// A late exposure is created in the previous batch's window after it was
+	// read, and a current one in the next batch's window.
+	late := &Exposure{ExposureKey: []byte("B"), Regions: []string{"US"}, CreatedAt: start.Add(-20 * time.Minute)}
+	current := &Exposure{ExposureKey: []byte("C"), Regions: []string{"US"}, CreatedAt: start.Add(10 * time.Minute)}
+	if err := testDB.InsertExposures(ctx, []*Exposure{late, current}); err != nil {
+		t.Fatal(err)
+	}
+
+	next := IterateExposuresCriteria{
+		IncludeRegions:  []string{"US"},
+		SinceTimestamp:  start.Add(-time.Hour),
+		UntilTimestamp:  start.Add(time.Hour),
+		LookbackUntil:   start,
+		LookbackHorizon: horizon,
+	}
+	got, err := listExposures(ctx, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*Exposure{late, current}, got); diff != "" {
+		t.Errorf("lookback mismatch (-want, +got):\n%s", diff)
+	}
+
+	if _, err := testDB.IterateExposuresWithHorizon(ctx, IterateExposuresCriteria{PageSize: 1}, func(*Exposure) error { return nil }); err == nil {
+		t.Errorf("IterateExposuresWithHorizon with a page size: expected error")
+	}
+}
+
+func TestUpsertExposures(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	ctx := context.Background()
+
+	batchTime := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC).Truncate(time.Microsecond)
+	existing := &Exposure{
+		ExposureKey:      []byte("ABC"),
+		TransmissionRisk: 2,
+		Regions:          []string{"CA", "US"},
+		IntervalNumber:   100,
+		IntervalCount:    144,
+		CreatedAt:        batchTime,
+		LocalProvenance:  true,
+	}
+	incoming := &Exposure{
+		ExposureKey:      []byte("ABC"),
+		TransmissionRisk: 5,
+		Regions:          []string{"MX", "US"},
+		IntervalNumber:   100,
+		IntervalCount:    144,
+		CreatedAt:        batchTime.Add(time.Hour),
+		LocalProvenance:  true,
+	}
+	fresh := &Exposure{
+		ExposureKey:           []byte("DEF"),
+		Regions:               []string{"US"},
+		IntervalNumber:        200,
+		IntervalCount:         144,
+		CreatedAt:             batchTime.Add(2 * time.Hour),
+		ReportType:            ReportTypeConfirmed,
+		DaysSinceSymptomOnset: int32Ptr(-2),
+	}
+
+	merged := *existing
+	merged.Regions = []string{"CA", "MX", "US"}
+
+	for _, test := range []struct {
+		policy ConflictPolicy
+		want   []*Exposure
+	}{
+		{OnConflictSkip, []*Exposure{existing, fresh}},
+		{OnConflictReplace, []*Exposure{incoming, fresh}},
+		{OnConflictMergeRegions, []*Exposure{&merged, fresh}},
+	} {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			defer ResetTestDB(t, testDB)
+
+			if err := testDB.InsertExposures(ctx, []*Exposure{existing}); err != nil {
+				t.Fatal(err)
+			}
+			if err := testDB.UpsertExposures(ctx, []*Exposure{incoming, fresh}, test.policy); err != nil {
+				t.Fatal(err)
+			}
+			got, err := listExposures(ctx, IterateExposuresCriteria{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConflictClause(t *testing.T) {
+	for _, policy := range []ConflictPolicy{OnConflictSkip, OnConflictReplace, OnConflictMergeRegions} {
+		if _, err := conflictClause(policy, "exposure_key"); err != nil {
+			t.Errorf("%v: unexpected error: %v", policy, err)
+		}
+	}
+	if _, err := conflictClause(ConflictPolicy(99), "exposure_key"); err == nil {
+		t.Errorf("expected error for unknown policy")
+	}
+}
+
+func TestReviseExposures(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC).Truncate(time.Microsecond)
+	likely := &Exposure{
+		ExposureKey:      []byte("ABC"),
+		TransmissionRisk: TransmissionRiskLikely,
+		Regions:          []string{"US"},
+		IntervalNumber:   100,
+		IntervalCount:    144,
+		CreatedAt:        createdAt,
+		LocalProvenance:  true,
+		ReportType:       ReportTypeLikely,
+		RevisionToken:    "token",
+	}
+	if err := testDB.InsertExposures(ctx, []*Exposure{likely}); err != nil {
+		t.Fatal(err)
+	}
+
+	revisedAt := createdAt.Add(time.Hour)
+	confirmed := *likely
+	confirmed.ReportType = ReportTypeConfirmed
+	confirmed.TransmissionRisk = TransmissionRiskConfirmed
+	confirmed.CreatedAt = revisedAt
+	confirmed.RevisionToken = ""
+
+	// A mismatched token must not change anything.
+	if _, err := testDB.ReviseExposures(ctx, "wrong", []*Exposure{&confirmed}); !errors.Is(err, ErrInvalidRevisionToken) {
+		t.Fatalf("expected ErrInvalidRevisionToken, got %v", err)
+	}
+
+	n, err := testDB.ReviseExposures(ctx, "token", []*Exposure{&confirmed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("revised %d exposures, want 1", n)
+	}
+
+	got, err := listExposures(ctx, IterateExposuresCriteria{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*Exposure{&confirmed}, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	var prior struct {
+		ReportType       string `json:"report_type"`
+		TransmissionRisk int    `json:"transmission_risk"`
+	}
+	conn, err := testDB.Pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Release()
+	if err := conn.QueryRow(ctx, `SELECT revised_from FROM Exposure`).Scan(&prior); err != nil {
+		t.Fatal(err)
+	}
+	if prior.ReportType != ReportTypeLikely || prior.TransmissionRisk != TransmissionRiskLikely {
+		t.Errorf("revised_from = %+v, want likely state", prior)
+	}
+
+	// Confirmed diagnoses cannot be revised back to likely.
+	back := confirmed
+	back.ReportType = ReportTypeLikely
+	if _, err := testDB.ReviseExposures(ctx, "token", []*Exposure{&back}); !errors.Is(err, ErrInvalidReportTypeTransition) {
+		t.Errorf("expected ErrInvalidReportTypeTransition, got %v", err)
+	}
+}
+
+func TestTombstoneExposures(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC).Truncate(time.Microsecond)
+	old := &Exposure{
+		ExposureKey:    []byte("ABC"),
+		Regions:        []string{"US"},
+		IntervalNumber: 100,
+		IntervalCount:  144,
+		CreatedAt:      createdAt,
+	}
+	recent := &Exposure{
+		ExposureKey:    []byte("DEF"),
+		Regions:        []string{"US"},
+		IntervalNumber: 200,
+		IntervalCount:  144,
+		CreatedAt:      createdAt.Add(time.Hour),
+	}
+	if err := testDB.InsertExposures(ctx, []*Exposure{old, recent}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := testDB.TombstoneExposures(ctx, recent.CreatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("TombstoneExposures: tombstoned %d, want 1", n)
+	}
+
+	for _, test := range []struct {
+		criteria IterateExposuresCriteria
+		want     []*Exposure
+	}{
+		{IterateExposuresCriteria{}, []*Exposure{recent}},
+		{IterateExposuresCriteria{OnlyRevokedKeys: true}, []*Exposure{old}},
+		{IterateExposuresCriteria{OnlyRevokedKeys: true, UntilTimestamp: createdAt}, nil},
+	} {
+		got, err := listExposures(ctx, test.criteria)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%+v: mismatch (-want, +got):\n%s", test.criteria, diff)
+		}
+	}
+
+	n, err = testDB.PurgeExposures(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("PurgeExposures: purged %d, want 1", n)
+	}
+	got, err := listExposures(ctx, IterateExposuresCriteria{OnlyRevokedKeys: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("PurgeExposures: got %d revoked exposures, want 0", len(got))
+	}
+}
+
+func TestExposureWritesRetried(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC).Truncate(time.Microsecond)
+	exposure := &Exposure{
+		ExposureKey:    []byte("ABC"),
+		Regions:        []string{"US"},
+		IntervalNumber: 100,
+		IntervalCount:  144,
+		CreatedAt:      createdAt,
+	}
+	before := createdAt.Add(time.Hour)
+
+	for _, test := range []struct {
+		name  string
+		setup func() error
+		write func() (int64, error)
+	}{
+		{
+			name: "upsert",
+			write: func() (int64, error) {
+				if err := testDB.UpsertExposures(ctx, []*Exposure{exposure}, OnConflictSkip); err != nil {
+					return 0, err
+				}
+				got, err := listExposures(ctx, IterateExposuresCriteria{})
+				return int64(len(got)), err
+			},
+		},
+		{
+			name:  "delete",
+			setup: func() error { return testDB.InsertExposures(ctx, []*Exposure{exposure}) },
+			write: func() (int64, error) { return testDB.DeleteExposures(ctx, before) },
+		},
+		{
+			name:  "delete batch",
+			setup: func() error { return testDB.InsertExposures(ctx, []*Exposure{exposure}) },
+			write: func() (int64, error) { return testDB.DeleteExposuresBatch(ctx, before, 10) },
+		},
+		{
+			name:  "tombstone",
+			setup: func() error { return testDB.InsertExposures(ctx, []*Exposure{exposure}) },
+			write: func() (int64, error) { return testDB.TombstoneExposures(ctx, before) },
+		},
+		{
+			name: "purge",
+			setup: func() error {
+				if err := testDB.InsertExposures(ctx, []*Exposure{exposure}); err != nil {
+					return err
+				}
+				_, err := testDB.TombstoneExposures(ctx, before)
+				return err
+			},
+			write: func() (int64, error) { return testDB.PurgeExposures(ctx, time.Now().Add(time.Minute)) },
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			defer ResetTestDB(t, testDB)
+			if test.setup != nil {
+				if err := test.setup(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Fail the first statement that writes to Exposure with a
+			// serialization failure. The sequence isn't rolled back with the
+			// failed transaction, so the retry succeeds.
+			conn, err := testDB.Pool.Acquire(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Release()
+			mustExec(t, conn, `CREATE SEQUENCE fail_once_seq`)
+			mustExec(t, conn, `
+				CREATE FUNCTION fail_once() RETURNS TRIGGER AS $$
+				BEGIN
+					IF nextval('fail_once_seq') = 1 THEN
+						RAISE EXCEPTION 'injected conflict' USING ERRCODE = 'serialization_failure';
+					END IF;
+					RETURN NULL;
+				END;
+				$$ LANGUAGE plpgsql`)
+			mustExec(t, conn, `
+				CREATE TRIGGER fail_once BEFORE INSERT OR UPDATE OR DELETE ON Exposure
+				FOR EACH STATEMENT EXECUTE PROCEDURE fail_once()`)
+			defer mustExec(t, conn, `
+				DROP TRIGGER fail_once ON Exposure;
+				DROP FUNCTION fail_once;
+				DROP SEQUENCE fail_once_seq`)
+
+			n, err := test.write()
+			if err != nil {
+				t.Fatalf("write was not retried: %v", err)
+			}
+			if n != 1 {
+				t.Errorf("wrote %d exposures, want 1", n)
+			}
+			var attempts int
+			if err := conn.QueryRow(ctx, `SELECT last_value FROM fail_once_seq`).Scan(&attempts); err != nil {
+				t.Fatal(err)
+			}
+			if attempts != 2 {
+				t.Errorf("got %d attempts, want 2", attempts)
+			}
+		})
+	}
+}
+
+func TestBulkInsertExposures(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	exposures := benchmarkExposures(10)
+	if err := testDB.BulkInsertExposures(ctx, exposures[:5]); err != nil {
+		t.Fatal(err)
+	}
+	// Overlaps with the first batch, so this takes the fallback path.
+	if err := testDB.BulkInsertExposures(ctx, exposures[3:]); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := listExposures(ctx, IterateExposuresCriteria{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(exposures, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestBulkInsertExposuresInChunks(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	exposures := benchmarkExposures(10)
+	if err := testDB.BulkInsertExposuresInChunks(ctx, exposures, 3); err != nil {
+		t.Fatal(err)
+	}
+	got, err := listExposures(ctx, IterateExposuresCriteria{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(exposures, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The app package name of an exposure in the last chunk is too long for
+	// its column, so none of the chunks before it may be stored either.
+	ResetTestDB(t, testDB)
+	failing := benchmarkExposures(10)
+	failing[8].AppPackageName = strings.Repeat("x", 101)
+	if err := testDB.BulkInsertExposuresInChunks(ctx, failing, 3); err == nil {
+		t.Fatal("BulkInsertExposuresInChunks: got nil error, want error")
+	}
+	got, err = listExposures(ctx, IterateExposuresCriteria{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got %d exposures after a failed insert, want 0", len(got))
+	}
+}
+
+// benchmarkExposures returns n distinct exposures with increasing created_at.
+func benchmarkExposures(n int) []*Exposure {
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	exposures := make([]*Exposure, 0, n)
+	for i := 0; i < n; i++ {
+		key := make([]byte, KeyLength)
+		binary.BigEndian.PutUint64(key, uint64(i))
+		exposures = append(exposures, &Exposure{
+			ExposureKey:    key,
+			Regions:        []string{"US"},
+			IntervalNumber: 100,
+			IntervalCount:  144,
+			CreatedAt:      createdAt.Add(time.Duration(i) * time.Second),
+		})
+	}
+	return exposures
+}
+
+func benchmarkInsert(b *testing.B, insert func(context.Context, []*Exposure) error) {
+	if testDB == nil {
+		b.Skip("no test DB")
+	}
+	ctx := context.Background()
+	exposures := benchmarkExposures(InsertExposuresBatchSize * 10)
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		ResetTestDB(b, testDB)
+		b.StartTimer()
+		if err := insert(ctx, exposures); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	ResetTestDB(b, testDB)
+}
+
+func BenchmarkInsertExposures(b *testing.B) {
+	benchmarkInsert(b, testDB.InsertExposures)
+}
+
+func BenchmarkBulkInsertExposures(b *testing.B) {
+	benchmarkInsert(b, testDB.BulkInsertExposures)
+}
+
+func TestInsertExposuresDedupe(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	first := &Exposure{ExposureKey: []byte("ABC"), Regions: []string{"US"}, IntervalNumber: 100, IntervalCount: 144, CreatedAt: createdAt}
+	retried := *first
+	retried.CreatedAt = createdAt.Add(time.Hour)
+	conflicting := *first
+	conflicting.IntervalNumber = 244
+	fresh := &Exposure{ExposureKey: []byte("DEF"), Regions: []string{"US"}, IntervalNumber: 244, IntervalCount: 144, CreatedAt: createdAt}
+
+	results, err := testDB.InsertExposuresDedupe(ctx, []*Exposure{first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]InsertResult{InsertAccepted}, results); diff != "" {
+		t.Errorf("first insert mismatch (-want, +got):\n%s", diff)
+	}
+
+	results, err = testDB.InsertExposuresDedupe(ctx, []*Exposure{&retried, &conflicting, fresh, fresh})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []InsertResult{InsertDuplicate, InsertConflict, InsertAccepted, InsertDuplicate}
+	if diff := cmp.Diff(want, results); diff != "" {
+		t.Errorf("second insert mismatch (-want, +got):\n%s", diff)
+	}
+
+	got, err := listExposures(ctx, IterateExposuresCriteria{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*Exposure{first, fresh}, got); diff != "" {
+		t.Errorf("stored exposures mismatch (-want, +got):\n%s", diff)
+	}
+}
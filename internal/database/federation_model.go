@@ -26,6 +26,16 @@ type FederationInQuery struct {
 	IncludeRegions []string  `db:"include_regions"`
 	ExcludeRegions []string  `db:"exclude_regions"`
 	LastTimestamp  time.Time `db:"last_timestamp"`
+
+	// RegionMap maps the upper-cased region identifiers used by the partner to
+	// the ones used locally, e.g. {"UK": "GB"}. Regions not in the map are kept
+	// as they are.
+	RegionMap map[string]string `db:"region_map"`
+
+	// ReportTypes and MinTransmissionRisk filter the keys pulled; see
+	// FederationReportAllowed.
+	ReportTypes         []string `db:"report_types"`
+	MinTransmissionRisk int      `db:"min_transmission_risk"`
 }
 
 // FederationInSync is the result of a federation query pulled from other servers.
@@ -36,6 +46,22 @@ type FederationInSync struct {
 	Completed    time.Time `db:"completed"`
 	Insertions   int       `db:"insertions"`
 	MaxTimestamp time.Time `db:"max_timestamp"`
+
+	// NextFetchToken is the fetch token of the next page while the sync is
+	// unfinished; see CheckpointFederationInSync.
+	NextFetchToken string `db:"next_fetch_token"`
+}
+
+// FederationInStatus summarizes the syncs of a federation query.
+type FederationInStatus struct {
+	QueryID string
+	// LastSync is the latest sync, which is still running or was interrupted
+	// if it has not completed. LastCompleted is the latest sync that did
+	// complete. Either is nil if there is no such sync.
+	LastSync      *FederationInSync
+	LastCompleted *FederationInSync
+	// KeysPulled is the number of keys inserted by all the query's syncs.
+	KeysPulled int64
 }
 
 // FederationOutAuthorization is an authorized client that reads federation data from this server.
@@ -47,4 +73,82 @@ type FederationOutAuthorization struct {
 	Note           string   `db:"note"`
 	IncludeRegions []string `db:"include_regions"`
 	ExcludeRegions []string `db:"exclude_regions"`
+
+	// RequestsPerMinute and KeysPerDay override the server's quotas for the
+	// partner. Zero uses the server default, and a negative value lifts the
+	// quota.
+	RequestsPerMinute int `db:"requests_per_minute"`
+	KeysPerDay        int `db:"keys_per_day"`
+
+	// ReportTypes and MinTransmissionRisk filter the keys served to the
+	// partner; see FederationReportAllowed.
+	ReportTypes         []string `db:"report_types"`
+	MinTransmissionRisk int      `db:"min_transmission_risk"`
+}
+
+// FederationReportAllowed reports whether a key with the given report type
+// and transmission risk passes a federation filter. If reportTypes is empty,
+// every report type passes, including keys without one. Otherwise only keys
+// with one of the listed report types pass. Keys with a transmission risk
+// below minTransmissionRisk never pass.
+func FederationReportAllowed(reportTypes []string, minTransmissionRisk int, reportType string, transmissionRisk int) bool {
+	if transmissionRisk < minTransmissionRisk {
+		return false
+	}
+	if len(reportTypes) == 0 {
+		return true
+	}
+	for _, t := range reportTypes {
+		if t == reportType {
+			return true
+		}
+	}
+	return false
+}
+
+// Federation push protocols.
+const (
+	// FederationPushGRPC pushes batches with the Push RPC of the federation
+	// gRPC service.
+	FederationPushGRPC = "GRPC"
+	// FederationPushREST pushes batches as protobuf POST requests, in the style
+	// of the EU federation gateway.
+	FederationPushREST = "REST"
+	// FederationPushEFGS uploads signed batches to the EU federation gateway.
+	// The endpoint is the gateway's base URL.
+	FederationPushEFGS = "EFGS"
+)
+
+// FederationPushTarget is a partner that this server pushes local keys to.
+type FederationPushTarget struct {
+	TargetID       string   `db:"target_id"`
+	Protocol       string   `db:"protocol"`
+	Endpoint       string   `db:"endpoint"`
+	Audience       string   `db:"oidc_audience"`
+	IncludeRegions []string `db:"include_regions"`
+	ExcludeRegions []string `db:"exclude_regions"`
+
+	// AckedChangeID is the Exposure change ID up to which the partner has
+	// acknowledged keys; see SyncExposures.
+	AckedChangeID int64     `db:"acked_change_id"`
+	LastSuccessAt time.Time `db:"last_success_at"`
+	LastFailureAt time.Time `db:"last_failure_at"`
+	// Failures counts the failed pushes since the last success.
+	Failures  int    `db:"failures"`
+	LastError string `db:"last_error"`
+	// KeysPushed counts the keys the partner has acknowledged.
+	KeysPushed int64 `db:"keys_pushed"`
+}
+
+// EFGSDownload is a batch downloaded from the EU federation gateway.
+type EFGSDownload struct {
+	BatchTag  string    `db:"batch_tag"`
+	BatchDate time.Time `db:"batch_date"`
+	// NextBatchTag is the tag of the day's next batch, or empty if this was
+	// the day's last batch when it was downloaded.
+	NextBatchTag string `db:"next_batch_tag"`
+	// SyncID is the FederationInSync the batch's keys were inserted under.
+	SyncID       int64     `db:"sync_id"`
+	Keys         int       `db:"keys"`
+	DownloadedAt time.Time `db:"downloaded_at"`
 }
@@ -0,0 +1,43 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import "testing"
+
+func TestFederationReportAllowed(t *testing.T) {
+	cases := []struct {
+		name        string
+		reportTypes []string
+		minRisk     int
+		reportType  string
+		risk        int
+		want        bool
+	}{
+		{name: "no filter", reportType: ReportTypeLikely, risk: 4, want: true},
+		{name: "no filter, no report type", risk: 0, want: true},
+		{name: "listed report type", reportTypes: []string{ReportTypeConfirmed}, reportType: ReportTypeConfirmed, risk: 2, want: true},
+		{name: "unlisted report type", reportTypes: []string{ReportTypeConfirmed}, reportType: ReportTypeLikely, risk: 4, want: false},
+		{name: "missing report type", reportTypes: []string{ReportTypeConfirmed}, risk: 2, want: false},
+		{name: "risk at minimum", minRisk: 3, reportType: ReportTypeLikely, risk: 3, want: true},
+		{name: "risk below minimum", minRisk: 3, reportType: ReportTypeConfirmed, risk: 2, want: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := FederationReportAllowed(c.reportTypes, c.minRisk, c.reportType, c.risk); got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// ErrPushConflict is returned by AckFederationPush when the target's
+// acknowledged change ID moved since the batch was read, which means another
+// worker pushed concurrently.
+var ErrPushConflict = errors.New("federation push target was acknowledged concurrently")
+
+// maxPushErrorLength bounds the error text kept on a push target.
+const maxPushErrorLength = 1000
+
+// AddFederationPushTarget adds a FederationPushTarget, or updates the
+// configuration of an existing one. The acknowledgement state of an existing
+// target is kept.
+func (db *DB) AddFederationPushTarget(ctx context.Context, t *FederationPushTarget) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO
+				FederationPushTarget
+				(target_id, protocol, endpoint, oidc_audience, include_regions, exclude_regions, acked_change_id)
+			VALUES
+				($1, $2, $3, NULLIF($4, ''), $5, $6, $7)
+			ON CONFLICT
+				(target_id)
+			DO UPDATE
+				SET protocol = $2, endpoint = $3, oidc_audience = NULLIF($4, ''), include_regions = $5, exclude_regions = $6
+		`, t.TargetID, t.Protocol, t.Endpoint, t.Audience, t.IncludeRegions, t.ExcludeRegions, t.AckedChangeID)
+		if err != nil {
+			return fmt.Errorf("upserting federation push target: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetFederationPushTarget returns the push target with the given ID, or
+// ErrNotFound.
+func (db *DB) GetFederationPushTarget(ctx context.Context, targetID string) (*FederationPushTarget, error) {
+	targets, err := db.listFederationPushTargets(ctx, "WHERE target_id = $1", targetID)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, ErrNotFound
+	}
+	return targets[0], nil
+}
+
+// ListFederationPushTargets returns all push targets, ordered by ID.
+func (db *DB) ListFederationPushTargets(ctx context.Context) ([]*FederationPushTarget, error) {
+	return db.listFederationPushTargets(ctx, "")
+}
+
+func (db *DB) listFederationPushTargets(ctx context.Context, where string, args ...interface{}) ([]*FederationPushTarget, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			target_id, protocol, endpoint, COALESCE(oidc_audience, ''), include_regions, exclude_regions,
+			acked_change_id, last_success_at, last_failure_at, failures, COALESCE(last_error, ''), keys_pushed
+		FROM
+			FederationPushTarget
+		`+where+`
+		ORDER BY
+			target_id
+		`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying federation push targets: %w", err)
+	}
+	defer rows.Close()
+
+	var targets []*FederationPushTarget
+	for rows.Next() {
+		var (
+			t                FederationPushTarget
+			success, failure *time.Time
+		)
+		if err := rows.Scan(&t.TargetID, &t.Protocol, &t.Endpoint, &t.Audience, &t.IncludeRegions, &t.ExcludeRegions,
+			&t.AckedChangeID, &success, &failure, &t.Failures, &t.LastError, &t.KeysPushed); err != nil {
+			return nil, fmt.Errorf("scanning results: %w", err)
+		}
+		if success != nil {
+			t.LastSuccessAt = *success
+		}
+		if failure != nil {
+			t.LastFailureAt = *failure
+		}
+		targets = append(targets, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating federation push targets: %w", err)
+	}
+	return targets, nil
+}
+
+// AckFederationPush records that the partner acknowledged every key up to the
+// change ID to, having read the batch from the change ID from, and adds the
+// number of keys in the batch to the target's count. It clears the target's
+// failures. If the target is no longer at from, ErrPushConflict is
+// returned and nothing is changed.
+func (db *DB) AckFederationPush(ctx context.Context, targetID string, from, to int64, keys int) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			UPDATE
+				FederationPushTarget
+			SET
+				acked_change_id = $3, last_success_at = $4, failures = 0, last_error = NULL, keys_pushed = keys_pushed + $5
+			WHERE
+				target_id = $1 AND acked_change_id = $2
+			`, targetID, from, to, time.Now().UTC(), keys)
+		if err != nil {
+			return fmt.Errorf("acknowledging federation push: %w", err)
+		}
+		if result.RowsAffected() != 1 {
+			return ErrPushConflict
+		}
+		return nil
+	})
+}
+
+// RecordFederationPushFailure records a failed push to the target. The
+// acknowledged change ID is unchanged, so the next push retries the same keys.
+func (db *DB) RecordFederationPushFailure(ctx context.Context, targetID string, pushErr error) error {
+	msg := pushErr.Error()
+	if r := []rune(msg); len(r) > maxPushErrorLength {
+		msg = string(r[:maxPushErrorLength])
+	}
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			UPDATE
+				FederationPushTarget
+			SET
+				last_failure_at = $2, failures = failures + 1, last_error = $3
+			WHERE
+				target_id = $1
+			`, targetID, time.Now().UTC(), msg)
+		if err != nil {
+			return fmt.Errorf("recording federation push failure: %w", err)
+		}
+		return nil
+	})
+}
+
+// DeleteFederationPushTarget deletes the push target with the given ID, or
+// returns ErrNotFound.
+func (db *DB) DeleteFederationPushTarget(ctx context.Context, targetID string) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `DELETE FROM FederationPushTarget WHERE target_id = $1`, targetID)
+		if err != nil {
+			return fmt.Errorf("deleting federation push target: %w", err)
+		}
+		if result.RowsAffected() != 1 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
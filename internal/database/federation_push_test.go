@@ -0,0 +1,102 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestFederationPushTarget(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	if _, err := testDB.GetFederationPushTarget(ctx, "partner"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+
+	want := &FederationPushTarget{
+		TargetID:       "partner",
+		Protocol:       FederationPushREST,
+		Endpoint:       "https://partner.example.com/upload",
+		IncludeRegions: []string{"US"},
+		ExcludeRegions: []string{"TEST"},
+		AckedChangeID:  5,
+	}
+	if err := testDB.AddFederationPushTarget(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := testDB.GetFederationPushTarget(ctx, want.TargetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// A failure is recorded without moving the acknowledged change.
+	if err := testDB.RecordFederationPushFailure(ctx, want.TargetID, errors.New("unavailable")); err != nil {
+		t.Fatal(err)
+	}
+	got, err = testDB.GetFederationPushTarget(ctx, want.TargetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Failures != 1 || got.LastError != "unavailable" || got.LastFailureAt.IsZero() || got.AckedChangeID != 5 {
+		t.Errorf("after failure got %+v", got)
+	}
+
+	// Acknowledging from a stale change conflicts.
+	if err := testDB.AckFederationPush(ctx, want.TargetID, 4, 10, 3); !errors.Is(err, ErrPushConflict) {
+		t.Errorf("AckFederationPush from stale change: got %v, want ErrPushConflict", err)
+	}
+	if err := testDB.AckFederationPush(ctx, want.TargetID, 5, 10, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Updating the configuration keeps the acknowledgement.
+	want.Endpoint = "https://partner.example.com/v2/upload"
+	want.Audience = "https://partner.example.com"
+	want.AckedChangeID = 0
+	if err := testDB.AddFederationPushTarget(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	targets, err := testDB.ListFederationPushTargets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.AckedChangeID = 10
+	want.KeysPushed = 3
+	if diff := cmp.Diff([]*FederationPushTarget{want}, targets, cmpopts.IgnoreFields(FederationPushTarget{}, "LastSuccessAt", "LastFailureAt")); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if targets[0].LastSuccessAt.IsZero() {
+		t.Errorf("LastSuccessAt not set after acknowledgement")
+	}
+
+	if err := testDB.DeleteFederationPushTarget(ctx, want.TargetID); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.DeleteFederationPushTarget(ctx, want.TargetID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteFederationPushTarget twice: got %v, want ErrNotFound", err)
+	}
+}
@@ -16,12 +16,18 @@ package database
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	pgx "github.com/jackc/pgx/v4"
 )
 
+// ErrFederationInQueryInUse is returned by DeleteFederationInQuery when the
+// query has syncs.
+var ErrFederationInQueryInUse = errors.New("federation query has syncs")
+
 // FinalizeSyncFn is used to finalize a historical sync record.
 type FinalizeSyncFn func(maxTimestamp time.Time, totalInserted int) error
 
@@ -38,42 +44,126 @@ func (db *DB) GetFederationInQuery(ctx context.Context, queryID string) (*Federa
 	return getFederationInQuery(ctx, queryID, conn.QueryRow)
 }
 
+const federationInQueryColumns = `query_id, server_addr, oidc_audience, include_regions, exclude_regions, last_timestamp, region_map,
+			report_types, COALESCE(min_transmission_risk, 0)`
+
 func getFederationInQuery(ctx context.Context, queryID string, queryRow queryRowFn) (*FederationInQuery, error) {
 	row := queryRow(ctx, `
 		SELECT
-			query_id, server_addr, oidc_audience, include_regions, exclude_regions, last_timestamp
+			`+federationInQueryColumns+`
 		FROM
 			FederationInQuery 
 		WHERE 
 			query_id=$1
 		`, queryID)
+	return scanFederationInQuery(row)
+}
 
+func scanFederationInQuery(row pgx.Row) (*FederationInQuery, error) {
 	// See https://www.opsdash.com/blog/postgres-arrays-golang.html for working with Postgres arrays in Go.
 	q := FederationInQuery{}
-	if err := row.Scan(&q.QueryID, &q.ServerAddr, &q.Audience, &q.IncludeRegions, &q.ExcludeRegions, &q.LastTimestamp); err != nil {
+	var regionMap []byte
+	if err := row.Scan(&q.QueryID, &q.ServerAddr, &q.Audience, &q.IncludeRegions, &q.ExcludeRegions, &q.LastTimestamp, &regionMap,
+		&q.ReportTypes, &q.MinTransmissionRisk); err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, ErrNotFound
 		}
 		return nil, fmt.Errorf("scanning results: %w", err)
 	}
+	if regionMap != nil {
+		if err := json.Unmarshal(regionMap, &q.RegionMap); err != nil {
+			return nil, fmt.Errorf("region_map of %s: %w", q.QueryID, err)
+		}
+	}
 	return &q, nil
 }
 
+// ListFederationInQueries returns all federation queries, ordered by ID.
+func (db *DB) ListFederationInQueries(ctx context.Context) ([]*FederationInQuery, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			`+federationInQueryColumns+`
+		FROM
+			FederationInQuery
+		ORDER BY
+			query_id
+		`)
+	if err != nil {
+		return nil, fmt.Errorf("querying federation queries: %w", err)
+	}
+	defer rows.Close()
+
+	var queries []*FederationInQuery
+	for rows.Next() {
+		q, err := scanFederationInQuery(rows)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating federation queries: %w", err)
+	}
+	return queries, nil
+}
+
+// DeleteFederationInQuery deletes the federation query with the given ID. It
+// returns ErrNotFound if there is no such query, and ErrFederationInQueryInUse
+// if the query has already synced, because its syncs record the origin of the
+// keys it pulled.
+func (db *DB) DeleteFederationInQuery(ctx context.Context, queryID string) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		var syncs int
+		row := tx.QueryRow(ctx, `SELECT COUNT(*) FROM FederationInSync WHERE query_id = $1`, queryID)
+		if err := row.Scan(&syncs); err != nil {
+			return fmt.Errorf("counting federation syncs: %w", err)
+		}
+		if syncs > 0 {
+			return ErrFederationInQueryInUse
+		}
+
+		result, err := tx.Exec(ctx, `DELETE FROM FederationInQuery WHERE query_id = $1`, queryID)
+		if err != nil {
+			return fmt.Errorf("deleting federation query: %w", err)
+		}
+		if result.RowsAffected() != 1 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
 // AddFederationInQuery adds a FederationInQuery entity. It will overwrite a query with matching q.queryID if it exists.
 func (db *DB) AddFederationInQuery(ctx context.Context, q *FederationInQuery) error {
+	var regionMap []byte
+	if len(q.RegionMap) > 0 {
+		var err error
+		if regionMap, err = json.Marshal(q.RegionMap); err != nil {
+			return fmt.Errorf("marshalling region map: %w", err)
+		}
+	}
+
 	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
 		query := `
 			INSERT INTO
 				FederationInQuery
-				(query_id, server_addr, oidc_audience, include_regions, exclude_regions, last_timestamp)
+				(query_id, server_addr, oidc_audience, include_regions, exclude_regions, last_timestamp, region_map, report_types, min_transmission_risk)
 			VALUES
-				($1, $2, $3, $4, $5, $6)
+				($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0))
 			ON CONFLICT
 				(query_id)
 			DO UPDATE
-				SET server_addr = $2, oidc_audience = $3, include_regions = $4, exclude_regions = $5, last_timestamp = $6
+				SET server_addr = $2, oidc_audience = $3, include_regions = $4, exclude_regions = $5, last_timestamp = $6, region_map = $7,
+					report_types = $8, min_transmission_risk = NULLIF($9, 0)
 		`
-		_, err := tx.Exec(ctx, query, q.QueryID, q.ServerAddr, q.Audience, q.IncludeRegions, q.ExcludeRegions, q.LastTimestamp)
+		_, err := tx.Exec(ctx, query, q.QueryID, q.ServerAddr, q.Audience, q.IncludeRegions, q.ExcludeRegions, q.LastTimestamp, regionMap,
+			q.ReportTypes, q.MinTransmissionRisk)
 		if err != nil {
 			return fmt.Errorf("upserting federation query: %w", err)
 		}
@@ -95,19 +185,22 @@ func (db *DB) GetFederationInSync(ctx context.Context, syncID int64) (*Federatio
 func getFederationInSync(ctx context.Context, syncID int64, queryRowContext queryRowFn) (*FederationInSync, error) {
 	row := queryRowContext(ctx, `
 		SELECT
-			sync_id, query_id, started, completed, insertions, max_timestamp
+			sync_id, query_id, started, completed, insertions, max_timestamp, COALESCE(next_fetch_token, '')
 		FROM
 			FederationInSync
 		WHERE
 			sync_id=$1
 		`, syncID)
+	return scanFederationInSync(row)
+}
 
+func scanFederationInSync(row pgx.Row) (*FederationInSync, error) {
 	s := FederationInSync{}
 	var (
 		completed, max *time.Time
 		insertions     *int
 	)
-	if err := row.Scan(&s.SyncID, &s.QueryID, &s.Started, &completed, &insertions, &max); err != nil {
+	if err := row.Scan(&s.SyncID, &s.QueryID, &s.Started, &completed, &insertions, &max, &s.NextFetchToken); err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, ErrNotFound
 		}
@@ -193,3 +286,228 @@ func (db *DB) StartFederationInSync(ctx context.Context, q *FederationInQuery, s
 
 	return syncID, finalize, nil
 }
+
+// expiredFederationInSync selects the FederationInSync rows, as fs, that
+// completed before $1 and can be deleted.
+const expiredFederationInSync = `
+				fs.completed < $1
+			AND
+				fs.sync_id NOT IN (
+					SELECT MAX(sync_id) FROM FederationInSync GROUP BY query_id
+				)
+			AND
+				NOT EXISTS (SELECT 1 FROM EFGSDownload d WHERE d.sync_id = fs.sync_id AND d.downloaded_at >= $1)
+			AND
+				NOT EXISTS (SELECT 1 FROM Exposure e WHERE e.sync_id = fs.sync_id)
+			`
+
+// DeleteFederationInSyncsBefore deletes the records of federation syncs, and of
+// the EU gateway batches they downloaded, that completed before the given
+// time. The latest sync of each query is kept, as are syncs whose exposures
+// haven't been deleted yet. It returns the number of syncs and gateway batch
+// records deleted.
+func (db *DB) DeleteFederationInSyncsBefore(ctx context.Context, before time.Time) (int64, int64, error) {
+	var syncs, downloads int64
+	err := db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				EFGSDownload
+			WHERE
+				downloaded_at < $1
+			`, before)
+		if err != nil {
+			return fmt.Errorf("deleting efgs downloads: %w", err)
+		}
+		downloads = result.RowsAffected()
+
+		result, err = tx.Exec(ctx, `
+			DELETE FROM
+				FederationInSync fs
+			WHERE
+				`+expiredFederationInSync, before)
+		if err != nil {
+			return fmt.Errorf("deleting federation syncs: %w", err)
+		}
+		syncs = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return syncs, downloads, nil
+}
+
+// CheckpointFederationInSync records the progress of an unfinished sync: the
+// fetch token of the next page, and the max timestamp and number of keys
+// inserted so far. If the sync then fails, the query's next sync can resume
+// from the token; see ResumableFederationInSync.
+func (db *DB) CheckpointFederationInSync(ctx context.Context, syncID int64, nextFetchToken string, maxTimestamp time.Time, totalInserted int) error {
+	var max *time.Time
+	if !maxTimestamp.IsZero() {
+		max = &maxTimestamp
+	}
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			UPDATE
+				FederationInSync
+			SET
+				next_fetch_token = NULLIF($1, ''),
+				max_timestamp = $2,
+				insertions = $3
+			WHERE
+				sync_id = $4 AND completed IS NULL
+		`, nextFetchToken, max, totalInserted, syncID)
+		if err != nil {
+			return fmt.Errorf("updating federation sync: %w", err)
+		}
+		if result.RowsAffected() != 1 {
+			return fmt.Errorf("no unfinished federation sync %d", syncID)
+		}
+		return nil
+	})
+}
+
+// ResumableFederationInSync returns the latest sync of the query if it didn't
+// complete but checkpointed a fetch token, which a new sync can resume from.
+// Otherwise it returns ErrNotFound.
+func (db *DB) ResumableFederationInSync(ctx context.Context, queryID string) (*FederationInSync, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(ctx, `
+		SELECT
+			sync_id, query_id, started, completed, insertions, max_timestamp, COALESCE(next_fetch_token, '')
+		FROM
+			FederationInSync
+		WHERE
+			query_id = $1
+		ORDER BY
+			sync_id DESC
+		LIMIT 1
+		`, queryID)
+	s, err := scanFederationInSync(row)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Completed.IsZero() || s.NextFetchToken == "" {
+		return nil, ErrNotFound
+	}
+	return s, nil
+}
+
+// ListFederationInStatus returns the sync status of every federation query,
+// ordered by query ID.
+func (db *DB) ListFederationInStatus(ctx context.Context) ([]*FederationInStatus, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			q.query_id, COALESCE(SUM(s.insertions), 0)
+		FROM
+			FederationInQuery q
+		LEFT JOIN
+			FederationInSync s ON s.query_id = q.query_id
+		GROUP BY
+			q.query_id
+		ORDER BY
+			q.query_id
+		`)
+	if err != nil {
+		return nil, fmt.Errorf("querying federation pull totals: %w", err)
+	}
+	var (
+		statuses []*FederationInStatus
+		byQuery  = make(map[string]*FederationInStatus)
+	)
+	for rows.Next() {
+		var st FederationInStatus
+		if err := rows.Scan(&st.QueryID, &st.KeysPulled); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning results: %w", err)
+		}
+		statuses = append(statuses, &st)
+		byQuery[st.QueryID] = &st
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating federation pull totals: %w", err)
+	}
+
+	// The latest sync of each query, and the latest one that completed.
+	for _, completedOnly := range []bool{false, true} {
+		where := ""
+		if completedOnly {
+			where = "WHERE completed IS NOT NULL"
+		}
+		rows, err := conn.Query(ctx, `
+			SELECT DISTINCT ON (query_id)
+				sync_id, query_id, started, completed, insertions, max_timestamp, COALESCE(next_fetch_token, '')
+			FROM
+				FederationInSync
+			`+where+`
+			ORDER BY
+				query_id, sync_id DESC
+			`)
+		if err != nil {
+			return nil, fmt.Errorf("querying latest federation syncs: %w", err)
+		}
+		for rows.Next() {
+			s, err := scanFederationInSync(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			st, ok := byQuery[s.QueryID]
+			if !ok {
+				continue
+			}
+			if completedOnly {
+				st.LastCompleted = s
+			} else {
+				st.LastSync = s
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("iterating latest federation syncs: %w", err)
+		}
+	}
+	return statuses, nil
+}
+
+// TombstoneFederationInExposures marks every exposure pulled by the given
+// federation query as deleted, for example when a partner withdraws keys or
+// the partnership ends. As with TombstoneExposures, revocation exports report
+// the keys and PurgeExposures later removes them. It returns the number of
+// exposures tombstoned.
+func (db *DB) TombstoneFederationInExposures(ctx context.Context, queryID string) (int64, error) {
+	var count int64
+	err := db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			UPDATE
+				Exposure
+			SET
+				deleted_at = $2
+			WHERE
+				sync_id IN (SELECT sync_id FROM FederationInSync WHERE query_id = $1)
+			AND
+				deleted_at IS NULL
+			`, queryID, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("tombstoning exposures: %w", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
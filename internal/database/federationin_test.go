@@ -59,6 +59,9 @@ func TestFederationIn(t *testing.T) {
 
 	// AddFederationQuery should overwrite.
 	want.ServerAddr = "addr2"
+	want.RegionMap = map[string]string{"UK": "GB"}
+	want.ReportTypes = []string{ReportTypeConfirmed}
+	want.MinTransmissionRisk = 2
 	if err := testDB.AddFederationInQuery(ctx, want); err != nil {
 		t.Fatal(err)
 	}
@@ -111,3 +114,257 @@ func TestFederationIn(t *testing.T) {
 		t.Errorf("mismatch (-want, +got):\n%s", diff)
 	}
 }
+
+func TestFederationInQueryListAndStatus(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	synced := &FederationInQuery{QueryID: "synced", ServerAddr: "a"}
+	idle := &FederationInQuery{QueryID: "idle", ServerAddr: "b"}
+	for _, q := range []*FederationInQuery{synced, idle} {
+		if err := testDB.AddFederationInQuery(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queries, err := testDB.ListFederationInQueries(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*FederationInQuery{idle, synced}, queries, cmpopts.IgnoreFields(FederationInQuery{}, "LastTimestamp")); diff != "" {
+		t.Errorf("list mismatch (-want, +got):\n%s", diff)
+	}
+
+	// One completed sync, then one that was interrupted.
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	_, finalize, err := testDB.StartFederationInSync(ctx, synced, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := finalize(now, 4); err != nil {
+		t.Fatal(err)
+	}
+	interrupted, _, err := testDB.StartFederationInSync(ctx, synced, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.CheckpointFederationInSync(ctx, interrupted, "token", now, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := testDB.ListFederationInStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("got %d statuses, want 2", len(statuses))
+	}
+	if got := statuses[0]; got.QueryID != "idle" || got.LastSync != nil || got.LastCompleted != nil || got.KeysPulled != 0 {
+		t.Errorf("idle status: got %+v", got)
+	}
+	got := statuses[1]
+	if got.QueryID != "synced" || got.KeysPulled != 6 {
+		t.Errorf("synced status: got %+v, want 6 keys pulled", got)
+	}
+	if got.LastSync == nil || got.LastSync.SyncID != interrupted || !got.LastSync.Completed.IsZero() {
+		t.Errorf("last sync: got %+v, want unfinished sync %d", got.LastSync, interrupted)
+	}
+	if got.LastCompleted == nil || got.LastCompleted.Insertions != 4 {
+		t.Errorf("last completed sync: got %+v, want 4 insertions", got.LastCompleted)
+	}
+
+	// A query can only be deleted until it syncs.
+	if err := testDB.DeleteFederationInQuery(ctx, synced.QueryID); !errors.Is(err, ErrFederationInQueryInUse) {
+		t.Errorf("deleting synced query: got %v, want ErrFederationInQueryInUse", err)
+	}
+	if err := testDB.DeleteFederationInQuery(ctx, idle.QueryID); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.DeleteFederationInQuery(ctx, idle.QueryID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting twice: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestResumableFederationInSync(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	q := &FederationInQuery{QueryID: "partner", ServerAddr: "addr"}
+	if err := testDB.AddFederationInQuery(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testDB.ResumableFederationInSync(ctx, q.QueryID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+
+	// A sync that fails after a checkpoint can be resumed.
+	now := time.Now().Truncate(time.Microsecond)
+	syncID, _, err := testDB.StartFederationInSync(ctx, q, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.CheckpointFederationInSync(ctx, syncID, "page-2", now.Add(-time.Hour), 5); err != nil {
+		t.Fatal(err)
+	}
+	got, err := testDB.ResumableFederationInSync(ctx, q.QueryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &FederationInSync{
+		SyncID:         syncID,
+		QueryID:        q.QueryID,
+		Started:        now,
+		Insertions:     5,
+		MaxTimestamp:   now.Add(-time.Hour),
+		NextFetchToken: "page-2",
+	}
+	if diff := cmp.Diff(want, got, cmpopts.EquateApproxTime(time.Microsecond)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Once a later sync completes, there is nothing to resume.
+	laterID, finalize, err := testDB.StartFederationInSync(ctx, q, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := finalize(now, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testDB.ResumableFederationInSync(ctx, q.QueryID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+
+	// Completed syncs can't be checkpointed.
+	if err := testDB.CheckpointFederationInSync(ctx, laterID, "page-3", now, 1); err == nil {
+		t.Errorf("expected error checkpointing a completed sync")
+	}
+}
+
+func TestTombstoneFederationInExposures(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	var exposures []*Exposure
+	for i, queryID := range []string{"partner-a", "partner-b"} {
+		q := &FederationInQuery{QueryID: queryID, ServerAddr: "addr"}
+		if err := testDB.AddFederationInQuery(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+		syncID, _, err := testDB.StartFederationInSync(ctx, q, createdAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exposures = append(exposures, &Exposure{
+			ExposureKey:      []byte(queryID),
+			Regions:          []string{"US"},
+			IntervalNumber:   int32(100 + i),
+			IntervalCount:    144,
+			CreatedAt:        createdAt,
+			FederationSyncID: syncID,
+		})
+	}
+	local := &Exposure{
+		ExposureKey:     []byte("local"),
+		Regions:         []string{"US"},
+		IntervalNumber:  200,
+		IntervalCount:   144,
+		CreatedAt:       createdAt,
+		LocalProvenance: true,
+	}
+	if err := testDB.InsertExposures(ctx, append(exposures, local)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := testDB.TombstoneFederationInExposures(ctx, "partner-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("TombstoneFederationInExposures: tombstoned %d, want 1", n)
+	}
+
+	got, err := listExposures(ctx, IterateExposuresCriteria{OnlyRevokedKeys: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*Exposure{exposures[0]}, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestDeleteFederationInSyncsBefore(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	q := &FederationInQuery{QueryID: "partner", ServerAddr: "addr"}
+	if err := testDB.AddFederationInQuery(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+
+	// Four old syncs: a completed one, one whose exposure still exists, an
+	// unfinished one, and the latest one.
+	now := time.Now().Truncate(time.Microsecond)
+	var syncIDs []int64
+	for i, finish := range []bool{true, true, false, true} {
+		syncID, finalize, err := testDB.StartFederationInSync(ctx, q, now.Add(time.Duration(i-72)*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if finish {
+			if err := finalize(now, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		syncIDs = append(syncIDs, syncID)
+	}
+	exposure := &Exposure{
+		ExposureKey:      []byte("ABC"),
+		Regions:          []string{"US"},
+		IntervalNumber:   18,
+		CreatedAt:        now.Add(-71 * time.Hour),
+		FederationSyncID: syncIDs[1],
+	}
+	if err := testDB.InsertExposures(ctx, []*Exposure{exposure}); err != nil {
+		t.Fatal(err)
+	}
+	download := &EFGSDownload{BatchTag: "b1", BatchDate: now.Add(-72 * time.Hour), Keys: 1, DownloadedAt: now.Add(-72 * time.Hour)}
+	if err := testDB.AddEFGSDownload(ctx, download); err != nil {
+		t.Fatal(err)
+	}
+
+	syncs, downloads, err := testDB.CountFederationInSyncsBefore(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if syncs != 1 || downloads != 1 {
+		t.Errorf("counted %d syncs and %d downloads, want 1 and 1", syncs, downloads)
+	}
+
+	syncs, downloads, err = testDB.DeleteFederationInSyncsBefore(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if syncs != 1 || downloads != 1 {
+		t.Errorf("deleted %d syncs and %d downloads, want 1 and 1", syncs, downloads)
+	}
+	if _, err := testDB.GetFederationInSync(ctx, syncIDs[0]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("completed sync: got %v, want ErrNotFound", err)
+	}
+	for _, id := range syncIDs[1:] {
+		if _, err := testDB.GetFederationInSync(ctx, id); err != nil {
+			t.Errorf("sync %d: %v", id, err)
+		}
+	}
+}
@@ -17,6 +17,7 @@ package database
 import (
 	"context"
 	"fmt"
+	"time"
 
 	pgx "github.com/jackc/pgx/v4"
 )
@@ -27,15 +28,19 @@ func (db *DB) AddFederationOutAuthorization(ctx context.Context, auth *Federatio
 		q := `
 			INSERT INTO
 				FederationOutAuthorization
-				(oidc_issuer, oidc_subject, oidc_audience, note, include_regions, exclude_regions)
+				(oidc_issuer, oidc_subject, oidc_audience, note, include_regions, exclude_regions, requests_per_minute, keys_per_day,
+				report_types, min_transmission_risk)
 			VALUES
-				($1, $2, $3, $4, $5, $6)
+				($1, $2, $3, $4, $5, $6, NULLIF($7, 0), NULLIF($8, 0), $9, NULLIF($10, 0))
 			ON CONFLICT ON CONSTRAINT
 				federation_authorization_pk
 			DO UPDATE
-				SET oidc_audience = $3, note = $4, include_regions = $5, exclude_regions = $6
+				SET oidc_audience = $3, note = $4, include_regions = $5, exclude_regions = $6,
+					requests_per_minute = NULLIF($7, 0), keys_per_day = NULLIF($8, 0),
+					report_types = $9, min_transmission_risk = NULLIF($10, 0)
 		`
-		_, err := tx.Exec(ctx, q, auth.Issuer, auth.Subject, auth.Audience, auth.Note, auth.IncludeRegions, auth.ExcludeRegions)
+		_, err := tx.Exec(ctx, q, auth.Issuer, auth.Subject, auth.Audience, auth.Note, auth.IncludeRegions, auth.ExcludeRegions,
+			auth.RequestsPerMinute, auth.KeysPerDay, auth.ReportTypes, auth.MinTransmissionRisk)
 		if err != nil {
 			return fmt.Errorf("upserting federation authorization: %w", err)
 		}
@@ -53,7 +58,8 @@ func (db *DB) GetFederationOutAuthorization(ctx context.Context, issuer, subject
 
 	row := conn.QueryRow(ctx, `
 		SELECT
-			oidc_issuer, oidc_subject, oidc_audience, note, include_regions, exclude_regions
+			oidc_issuer, oidc_subject, oidc_audience, note, include_regions, exclude_regions,
+			COALESCE(requests_per_minute, 0), COALESCE(keys_per_day, 0), report_types, COALESCE(min_transmission_risk, 0)
 		FROM
 			FederationOutAuthorization
 		WHERE
@@ -63,7 +69,8 @@ func (db *DB) GetFederationOutAuthorization(ctx context.Context, issuer, subject
 		LIMIT 1
 		`, issuer, subject)
 	auth := FederationOutAuthorization{}
-	if err := row.Scan(&auth.Issuer, &auth.Subject, &auth.Audience, &auth.Note, &auth.IncludeRegions, &auth.ExcludeRegions); err != nil {
+	if err := row.Scan(&auth.Issuer, &auth.Subject, &auth.Audience, &auth.Note, &auth.IncludeRegions, &auth.ExcludeRegions,
+		&auth.RequestsPerMinute, &auth.KeysPerDay, &auth.ReportTypes, &auth.MinTransmissionRisk); err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, ErrNotFound
 		}
@@ -71,3 +78,109 @@ func (db *DB) GetFederationOutAuthorization(ctx context.Context, issuer, subject
 	}
 	return &auth, nil
 }
+
+// ListFederationOutAuthorizations returns all FederationOutAuthorization
+// records, ordered by issuer and subject.
+func (db *DB) ListFederationOutAuthorizations(ctx context.Context) ([]*FederationOutAuthorization, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			oidc_issuer, oidc_subject, oidc_audience, note, include_regions, exclude_regions,
+			COALESCE(requests_per_minute, 0), COALESCE(keys_per_day, 0), report_types, COALESCE(min_transmission_risk, 0)
+		FROM
+			FederationOutAuthorization
+		ORDER BY
+			oidc_issuer, oidc_subject
+		`)
+	if err != nil {
+		return nil, fmt.Errorf("querying federation authorizations: %w", err)
+	}
+	defer rows.Close()
+
+	var auths []*FederationOutAuthorization
+	for rows.Next() {
+		auth := FederationOutAuthorization{}
+		if err := rows.Scan(&auth.Issuer, &auth.Subject, &auth.Audience, &auth.Note, &auth.IncludeRegions, &auth.ExcludeRegions,
+			&auth.RequestsPerMinute, &auth.KeysPerDay, &auth.ReportTypes, &auth.MinTransmissionRisk); err != nil {
+			return nil, fmt.Errorf("scanning results: %w", err)
+		}
+		auths = append(auths, &auth)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating federation authorizations: %w", err)
+	}
+	return auths, nil
+}
+
+// DeleteFederationOutAuthorization deletes a FederationOutAuthorization record,
+// or returns ErrNotFound if there is none.
+func (db *DB) DeleteFederationOutAuthorization(ctx context.Context, issuer, subject string) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				FederationOutAuthorization
+			WHERE
+				oidc_issuer = $1
+			AND
+				oidc_subject = $2
+			`, issuer, subject)
+		if err != nil {
+			return fmt.Errorf("deleting federation authorization: %w", err)
+		}
+		if result.RowsAffected() != 1 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// FederationOutKeysServed returns the number of keys served to the partner
+// on the UTC day of day.
+func (db *DB) FederationOutKeysServed(ctx context.Context, issuer, subject string, day time.Time) (int64, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	var keys int64
+	row := conn.QueryRow(ctx, `
+		SELECT
+			COALESCE(SUM(keys), 0)
+		FROM
+			FederationOutUsage
+		WHERE
+			oidc_issuer = $1 AND oidc_subject = $2 AND day = $3
+		`, issuer, subject, day.UTC().Truncate(24*time.Hour))
+	if err := row.Scan(&keys); err != nil {
+		return 0, fmt.Errorf("scanning results: %w", err)
+	}
+	return keys, nil
+}
+
+// RecordFederationOutUsage adds a fetch request that served the given number
+// of keys to the partner's usage on the UTC day of day.
+func (db *DB) RecordFederationOutUsage(ctx context.Context, issuer, subject string, day time.Time, keys int) error {
+	return db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO
+				FederationOutUsage
+				(oidc_issuer, oidc_subject, day, requests, keys)
+			VALUES
+				($1, $2, $3, 1, $4)
+			ON CONFLICT
+				(oidc_issuer, oidc_subject, day)
+			DO UPDATE
+				SET requests = FederationOutUsage.requests + 1, keys = FederationOutUsage.keys + $4
+			`, issuer, subject, day.UTC().Truncate(24*time.Hour), keys)
+		if err != nil {
+			return fmt.Errorf("recording federation usage: %w", err)
+		}
+		return nil
+	})
+}
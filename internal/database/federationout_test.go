@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -67,4 +68,81 @@ func TestFederationOutAuthorization(t *testing.T) {
 	if diff := cmp.Diff(want, got); diff != "" {
 		t.Errorf("mismatch (-want, +got):\n%s", diff)
 	}
+
+	// Quotas and filters round trip, with zero meaning the default.
+	want.RequestsPerMinute = 10
+	want.KeysPerDay = -1
+	want.ReportTypes = []string{ReportTypeConfirmed, ReportTypeLikely}
+	want.MinTransmissionRisk = 3
+	if err := testDB.AddFederationOutAuthorization(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err = testDB.GetFederationOutAuthorization(ctx, want.Issuer, want.Subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// List, then delete it.
+	auths, err := testDB.ListFederationOutAuthorizations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*FederationOutAuthorization{want}, auths); diff != "" {
+		t.Errorf("list mismatch (-want, +got):\n%s", diff)
+	}
+	if err := testDB.DeleteFederationOutAuthorization(ctx, want.Issuer, want.Subject); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.DeleteFederationOutAuthorization(ctx, want.Issuer, want.Subject); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting twice: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestFederationOutUsage(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	auth := &FederationOutAuthorization{Issuer: "iss", Subject: "sub"}
+	if err := testDB.AddFederationOutAuthorization(ctx, auth); err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2020, 9, 1, 22, 0, 0, 0, time.UTC)
+	for _, keys := range []int{10, 5} {
+		if err := testDB.RecordFederationOutUsage(ctx, auth.Issuer, auth.Subject, day, keys); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := testDB.RecordFederationOutUsage(ctx, auth.Issuer, auth.Subject, day.Add(3*time.Hour), 7); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		day  time.Time
+		want int64
+	}{
+		{day: day.Add(-22 * time.Hour), want: 15},
+		{day: day.Add(2 * time.Hour), want: 7},
+		{day: day.Add(-48 * time.Hour), want: 0},
+	}
+	for _, c := range cases {
+		got, err := testDB.FederationOutKeysServed(ctx, auth.Issuer, auth.Subject, c.day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("FederationOutKeysServed(%v): got %d, want %d", c.day, got, c.want)
+		}
+	}
+
+	// Usage goes with the authorization.
+	if err := testDB.DeleteFederationOutAuthorization(ctx, auth.Issuer, auth.Subject); err != nil {
+		t.Fatal(err)
+	}
 }
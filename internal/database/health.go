@@ -0,0 +1,77 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Ping checks that the database, and the read replica if one is configured,
+// accept queries.
+func (db *DB) Ping(ctx context.Context) error {
+	if err := ping(ctx, db.Pool); err != nil {
+		return err
+	}
+	if db.replica != nil {
+		if err := ping(ctx, db.replica); err != nil {
+			return fmt.Errorf("replica: %w", err)
+		}
+	}
+	for _, rdb := range db.residencyDBs {
+		if err := rdb.Ping(ctx); err != nil {
+			return fmt.Errorf("residency database %v: %w", rdb.residencyHost, err)
+		}
+	}
+	return nil
+}
+
+func ping(ctx context.Context, pool *pgxpool.Pool) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	if err := conn.Conn().Ping(ctx); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the last schema migration applied to
+// the database, which is zero if none were applied. dirty is true if that
+// migration failed part way.
+func (db *DB) SchemaVersion(ctx context.Context) (version uint, dirty bool, err error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return 0, false, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	var v int64
+	row := conn.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`)
+	if err := row.Scan(&v, &dirty); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("reading schema version: %w", err)
+	}
+	return uint(v), dirty, nil
+}
@@ -0,0 +1,361 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// HealthAuthority holds the settings shared by all apps of a health
+// authority. A health authority without a row uses the defaults.
+type HealthAuthority struct {
+	ID string
+
+	// EmbargoSameDayKeys accepts keys that are still valid, and holds them
+	// back from exports until they expire.
+	EmbargoSameDayKeys bool
+
+	// AllowTravelers lets apps write the keys of users who traveled to the
+	// regions they visited.
+	AllowTravelers bool
+
+	// OnsetTransmissionRisk maps the days between symptom onset and a key's
+	// interval to the transmission risk of keys published without one.
+	OnsetTransmissionRisk map[int32]int
+
+	// JWKSURI, if set, is where the health authority publishes the keys of
+	// its verification certificates as a JSON Web Key Set. Its keys are
+	// refreshed from there, see SyncHealthAuthorityKeys. Otherwise they are
+	// added by hand with AddHealthAuthorityKey.
+	JWKSURI string
+
+	// JWKSRefreshedAt is when the keys were last refreshed from JWKSURI. It
+	// is set by SyncHealthAuthorityKeys.
+	JWKSRefreshedAt *time.Time
+}
+
+// HealthAuthorityKey is a public key that verification certificates of a
+// health authority are signed with.
+type HealthAuthorityKey struct {
+	// Version is the key ID in the kid header of the certificates.
+	Version string
+
+	// From and Thru bound when certificates signed with the key are accepted.
+	// A nil Thru means the key doesn't expire.
+	From time.Time
+	Thru *time.Time
+
+	// PublicKeyPEM is the PEM encoded PKIX public key.
+	PublicKeyPEM string
+}
+
+// IsValidAt reports whether certificates signed with the key are accepted at
+// t.
+func (k *HealthAuthorityKey) IsValidAt(t time.Time) bool {
+	return !t.Before(k.From) && (k.Thru == nil || t.Before(*k.Thru))
+}
+
+// ListHealthAuthorities returns every HealthAuthority, ordered by ID.
+func (db *DB) ListHealthAuthorities(ctx context.Context) ([]*HealthAuthority, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			health_authority_id, embargo_same_day_keys, allow_travelers, onset_transmission_risk,
+			jwks_uri, jwks_refreshed_at
+		FROM
+			HealthAuthority
+		ORDER BY
+			health_authority_id
+		`)
+	if err != nil {
+		return nil, fmt.Errorf("listing health authorities: %w", err)
+	}
+	defer rows.Close()
+
+	var has []*HealthAuthority
+	for rows.Next() {
+		var (
+			ha   HealthAuthority
+			risk []byte
+		)
+		if err := rows.Scan(&ha.ID, &ha.EmbargoSameDayKeys, &ha.AllowTravelers, &risk,
+			&ha.JWKSURI, &ha.JWKSRefreshedAt); err != nil {
+			return nil, fmt.Errorf("scanning health authority: %w", err)
+		}
+		if len(risk) > 0 {
+			if err := json.Unmarshal(risk, &ha.OnsetTransmissionRisk); err != nil {
+				return nil, fmt.Errorf("onset_transmission_risk of %s: %w", ha.ID, err)
+			}
+		}
+		has = append(has, &ha)
+	}
+	return has, rows.Err()
+}
+
+// AddHealthAuthority inserts the HealthAuthority, or updates it if one with
+// the same ID exists. JWKSRefreshedAt is not written.
+func (db *DB) AddHealthAuthority(ctx context.Context, ha *HealthAuthority) error {
+	if ha.ID == "" {
+		return fmt.Errorf("health authority ID cannot be empty")
+	}
+	var risk []byte
+	if len(ha.OnsetTransmissionRisk) > 0 {
+		for days, r := range ha.OnsetTransmissionRisk {
+			if r < MinTransmissionRisk || r > MaxTransmissionRisk {
+				return fmt.Errorf("invalid transmission risk %v for %v days, must be >= %v && <= %v", r, days, MinTransmissionRisk, MaxTransmissionRisk)
+			}
+		}
+		var err error
+		if risk, err = json.Marshal(ha.OnsetTransmissionRisk); err != nil {
+			return fmt.Errorf("marshaling onset transmission risk: %w", err)
+		}
+	}
+
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO
+				HealthAuthority
+				(health_authority_id, embargo_same_day_keys, allow_travelers, onset_transmission_risk, jwks_uri)
+			VALUES
+				($1, $2, $3, $4, $5)
+			ON CONFLICT (health_authority_id) DO UPDATE
+				SET embargo_same_day_keys = $2, allow_travelers = $3, onset_transmission_risk = $4, jwks_uri = $5
+			`, ha.ID, ha.EmbargoSameDayKeys, ha.AllowTravelers, risk, ha.JWKSURI); err != nil {
+			return fmt.Errorf("upserting health authority: %w", err)
+		}
+		return nil
+	})
+}
+
+// DeleteHealthAuthority deletes the HealthAuthority with the given ID and its
+// keys, so its apps use the defaults. It returns ErrNotFound if there is no such health
+// authority.
+func (db *DB) DeleteHealthAuthority(ctx context.Context, id string) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				HealthAuthority
+			WHERE
+				health_authority_id = $1
+			`, id)
+		if err != nil {
+			return fmt.Errorf("deleting health authority: %w", err)
+		}
+		if result.RowsAffected() != 1 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// ListHealthAuthorityKeys returns the keys of the health authority, including
+// expired ones, ordered by From.
+func (db *DB) ListHealthAuthorityKeys(ctx context.Context, healthAuthorityID string) ([]*HealthAuthorityKey, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			version, from_timestamp, thru_timestamp, public_key
+		FROM
+			HealthAuthorityKey
+		WHERE
+			health_authority_id = $1
+		ORDER BY
+			from_timestamp, version
+		`, healthAuthorityID)
+	if err != nil {
+		return nil, fmt.Errorf("listing health authority keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*HealthAuthorityKey
+	for rows.Next() {
+		var k HealthAuthorityKey
+		if err := rows.Scan(&k.Version, &k.From, &k.Thru, &k.PublicKeyPEM); err != nil {
+			return nil, fmt.Errorf("scanning health authority key: %w", err)
+		}
+		keys = append(keys, &k)
+	}
+	return keys, rows.Err()
+}
+
+// AddHealthAuthorityKey inserts a key of the health authority, or replaces
+// the key with the same version. The health authority must exist.
+func (db *DB) AddHealthAuthorityKey(ctx context.Context, healthAuthorityID string, k *HealthAuthorityKey) error {
+	if k.Version == "" {
+		return fmt.Errorf("health authority key version cannot be empty")
+	}
+	if k.PublicKeyPEM == "" {
+		return fmt.Errorf("health authority key cannot be empty")
+	}
+
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO
+				HealthAuthorityKey
+				(health_authority_id, version, from_timestamp, thru_timestamp, public_key)
+			VALUES
+				($1, $2, $3, $4, $5)
+			ON CONFLICT (health_authority_id, version) DO UPDATE
+				SET from_timestamp = $3, thru_timestamp = $4, public_key = $5
+			`, healthAuthorityID, k.Version, k.From, k.Thru, k.PublicKeyPEM); err != nil {
+			return fmt.Errorf("upserting health authority key: %w", err)
+		}
+		return nil
+	})
+}
+
+// UpdateHealthAuthorityKeyEnd sets when a key of the health authority stops
+// being accepted. It returns ErrNotFound if there is no such key.
+func (db *DB) UpdateHealthAuthorityKeyEnd(ctx context.Context, healthAuthorityID, version string, thru time.Time) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			UPDATE
+				HealthAuthorityKey
+			SET
+				thru_timestamp = $3
+			WHERE
+				health_authority_id = $1 AND version = $2
+			`, healthAuthorityID, version, thru)
+		if err != nil {
+			return fmt.Errorf("updating health authority key: %w", err)
+		}
+		if result.RowsAffected() != 1 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// SyncHealthAuthorityKeys brings the keys of the health authority in line
+// with the key set it publishes, which maps versions to PEM encoded keys.
+// Versions new to the key set, or whose key changed, are valid from now. Keys
+// that were removed from the key set stay valid until graceEnd, so that
+// certificates issued shortly before a rotation are still accepted. A key that
+// reappears before then is valid again, one that reappears later stays
+// expired. It returns the number of keys added
+// and ended.
+func (db *DB) SyncHealthAuthorityKeys(ctx context.Context, healthAuthorityID string, keySet map[string]string, now, graceEnd time.Time) (int, int, error) {
+	var added, ended int
+	err := db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		added, ended = 0, 0
+
+		rows, err := tx.Query(ctx, `
+			SELECT
+				version, from_timestamp, thru_timestamp, public_key
+			FROM
+				HealthAuthorityKey
+			WHERE
+				health_authority_id = $1
+			FOR UPDATE
+			`, healthAuthorityID)
+		if err != nil {
+			return fmt.Errorf("reading health authority keys: %w", err)
+		}
+		existing := make(map[string]*HealthAuthorityKey)
+		for rows.Next() {
+			var k HealthAuthorityKey
+			if err := rows.Scan(&k.Version, &k.From, &k.Thru, &k.PublicKeyPEM); err != nil {
+				rows.Close()
+				return fmt.Errorf("scanning health authority key: %w", err)
+			}
+			existing[k.Version] = &k
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("reading health authority keys: %w", err)
+		}
+
+		for version, pem := range keySet {
+			k, ok := existing[version]
+			switch {
+			case !ok || k.PublicKeyPEM != pem:
+				added++
+				_, err = tx.Exec(ctx, `
+					INSERT INTO
+						HealthAuthorityKey
+						(health_authority_id, version, from_timestamp, thru_timestamp, public_key)
+					VALUES
+						($1, $2, $3, NULL, $4)
+					ON CONFLICT (health_authority_id, version) DO UPDATE
+						SET from_timestamp = $3, thru_timestamp = NULL, public_key = $4
+					`, healthAuthorityID, version, now, pem)
+			case k.Thru != nil && k.Thru.After(now):
+				_, err = tx.Exec(ctx, `
+					UPDATE
+						HealthAuthorityKey
+					SET
+						thru_timestamp = NULL
+					WHERE
+						health_authority_id = $1 AND version = $2
+					`, healthAuthorityID, version)
+			}
+			if err != nil {
+				return fmt.Errorf("updating health authority key %v: %w", version, err)
+			}
+		}
+
+		for version, k := range existing {
+			if _, ok := keySet[version]; ok || k.Thru != nil {
+				continue
+			}
+			ended++
+			if _, err := tx.Exec(ctx, `
+				UPDATE
+					HealthAuthorityKey
+				SET
+					thru_timestamp = $3
+				WHERE
+					health_authority_id = $1 AND version = $2
+				`, healthAuthorityID, version, graceEnd); err != nil {
+				return fmt.Errorf("ending health authority key %v: %w", version, err)
+			}
+		}
+
+		result, err := tx.Exec(ctx, `
+			UPDATE
+				HealthAuthority
+			SET
+				jwks_refreshed_at = $2
+			WHERE
+				health_authority_id = $1
+			`, healthAuthorityID, now)
+		if err != nil {
+			return fmt.Errorf("updating health authority: %w", err)
+		}
+		if result.RowsAffected() != 1 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return added, ended, nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHealthAuthorities(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	if err := testDB.AddHealthAuthority(ctx, &HealthAuthority{ID: "bad", OnsetTransmissionRisk: map[int32]int{0: MaxTransmissionRisk + 1}}); err == nil {
+		t.Errorf("AddHealthAuthority with invalid risk: got nil error")
+	}
+
+	ha1 := &HealthAuthority{ID: "ha-1", EmbargoSameDayKeys: true}
+	ha2 := &HealthAuthority{ID: "ha-2", AllowTravelers: true, OnsetTransmissionRisk: map[int32]int{-2: 1, 0: 4}, JWKSURI: "https://ha-2.example/jwks"}
+	for _, ha := range []*HealthAuthority{ha2, ha1} {
+		if err := testDB.AddHealthAuthority(ctx, ha); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ha1.AllowTravelers = true
+	if err := testDB.AddHealthAuthority(ctx, ha1); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := testDB.ListHealthAuthorities(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*HealthAuthority{ha1, ha2}, got); diff != "" {
+		t.Errorf("ListHealthAuthorities mismatch (-want, +got):\n%s", diff)
+	}
+
+	if err := testDB.DeleteHealthAuthority(ctx, "ha-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.DeleteHealthAuthority(ctx, "ha-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteHealthAuthority twice: got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestSyncHealthAuthorityKeys(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	if err := testDB.AddHealthAuthority(ctx, &HealthAuthority{ID: "ha", JWKSURI: "https://ha.example/jwks"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := testDB.SyncHealthAuthorityKeys(ctx, "unknown", nil, time.Now(), time.Now()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SyncHealthAuthorityKeys of unknown health authority: got %v, want %v", err, ErrNotFound)
+	}
+
+	t0 := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(24 * time.Hour)
+	t2 := t1.Add(24 * time.Hour)
+	grace := time.Hour
+	ptr := func(t time.Time) *time.Time { return &t }
+
+	cases := []struct {
+		name   string
+		now    time.Time
+		keySet map[string]string
+		added  int
+		ended  int
+		want   []*HealthAuthorityKey
+	}{
+		{
+			name:   "first keys",
+			now:    t0,
+			keySet: map[string]string{"v1": "pem-1"},
+			added:  1,
+			want:   []*HealthAuthorityKey{{Version: "v1", From: t0, PublicKeyPEM: "pem-1"}},
+		},
+		{
+			name:   "rotated",
+			now:    t1,
+			keySet: map[string]string{"v2": "pem-2"},
+			added:  1,
+			ended:  1,
+			want: []*HealthAuthorityKey{
+				{Version: "v1", From: t0, Thru: ptr(t1.Add(grace)), PublicKeyPEM: "pem-1"},
+				{Version: "v2", From: t1, PublicKeyPEM: "pem-2"},
+			},
+		},
+		{
+			name:   "rotation undone within grace period",
+			now:    t1.Add(grace / 2),
+			keySet: map[string]string{"v1": "pem-1", "v2": "pem-2"},
+			want: []*HealthAuthorityKey{
+				{Version: "v1", From: t0, PublicKeyPEM: "pem-1"},
+				{Version: "v2", From: t1, PublicKeyPEM: "pem-2"},
+			},
+		},
+		{
+			name:   "key changed",
+			now:    t2,
+			keySet: map[string]string{"v1": "pem-1", "v2": "pem-3"},
+			added:  1,
+			want: []*HealthAuthorityKey{
+				{Version: "v1", From: t0, PublicKeyPEM: "pem-1"},
+				{Version: "v2", From: t2, PublicKeyPEM: "pem-3"},
+			},
+		},
+	}
+	for _, tc := range cases {
+		added, ended, err := testDB.SyncHealthAuthorityKeys(ctx, "ha", tc.keySet, tc.now, tc.now.Add(grace))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if added != tc.added || ended != tc.ended {
+			t.Errorf("%s: got %d added, %d ended, want %d, %d", tc.name, added, ended, tc.added, tc.ended)
+		}
+		got, err := testDB.ListHealthAuthorityKeys(ctx, "ha")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: keys mismatch (-want, +got):\n%s", tc.name, diff)
+		}
+	}
+
+	has, err := testDB.ListHealthAuthorities(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := has[0].JWKSRefreshedAt; got == nil || !got.Equal(t2) {
+		t.Errorf("got JWKSRefreshedAt %v, want %v", got, t2)
+	}
+
+	if err := testDB.UpdateHealthAuthorityKeyEnd(ctx, "ha", "v3", t2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateHealthAuthorityKeyEnd of unknown key: got %v, want %v", err, ErrNotFound)
+	}
+	if err := testDB.DeleteHealthAuthority(ctx, "ha"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := testDB.ListHealthAuthorityKeys(ctx, "ha"); err != nil || len(keys) != 0 {
+		t.Errorf("keys after deleting health authority: got %v, %v", keys, err)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPing(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+
+	if err := testDB.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+
+	version, dirty, err := testDB.SchemaVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version == 0 || dirty {
+		t.Errorf("SchemaVersion = %d, %t, want a clean, migrated schema", version, dirty)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// IdempotencyRecord is the response to a publish request that carried an
+// idempotency key. Keys are scoped to the app and to CertificateHash, which
+// identifies the request's verification certificate, or is empty if it had
+// none. RequestHash identifies the request body, so a key reused for a
+// different request can be detected.
+type IdempotencyRecord struct {
+	AppPackageName  string
+	CertificateHash string
+	Key             string
+	RequestHash    string
+	Response       []byte
+	CreatedAt      time.Time
+}
+
+// GetIdempotencyRecord returns the record for the given app, certificate hash
+// and key that was created after since. It returns ErrNotFound if there is no
+// such record.
+func (db *DB) GetIdempotencyRecord(ctx context.Context, appPackageName, certificateHash, key string, since time.Time) (*IdempotencyRecord, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(ctx, `
+		SELECT
+			app_package_name, certificate_hash, idempotency_key, request_hash, response, created_at
+		FROM
+			PublishIdempotency
+		WHERE
+			app_package_name = $1 AND certificate_hash = $2 AND idempotency_key = $3 AND created_at > $4
+		`, appPackageName, certificateHash, key, since)
+
+	var rec IdempotencyRecord
+	if err := row.Scan(&rec.AppPackageName, &rec.CertificateHash, &rec.Key, &rec.RequestHash, &rec.Response, &rec.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("reading idempotency record: %w", err)
+	}
+	return &rec, nil
+}
+
+// SaveIdempotencyRecord stores the record. If a concurrent request with the
+// same key already stored one, that record is kept unless it was created
+// before expiredBefore.
+func (db *DB) SaveIdempotencyRecord(ctx context.Context, rec *IdempotencyRecord, expiredBefore time.Time) error {
+	return db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO
+				PublishIdempotency
+				(app_package_name, certificate_hash, idempotency_key, request_hash, response, created_at)
+			VALUES
+				($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (app_package_name, certificate_hash, idempotency_key) DO UPDATE
+			SET
+				request_hash = EXCLUDED.request_hash,
+				response = EXCLUDED.response,
+				created_at = EXCLUDED.created_at
+			WHERE
+				PublishIdempotency.created_at < $7
+			`, rec.AppPackageName, rec.CertificateHash, rec.Key, rec.RequestHash, rec.Response, rec.CreatedAt, expiredBefore); err != nil {
+			return fmt.Errorf("inserting idempotency record: %w", err)
+		}
+		return nil
+	})
+}
+
+// DeleteIdempotencyRecords deletes records created before the given time. It
+// returns the number of records deleted.
+func (db *DB) DeleteIdempotencyRecords(ctx context.Context, before time.Time) (int64, error) {
+	var count int64
+	err := db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				PublishIdempotency
+			WHERE
+				created_at < $1
+			`, before)
+		if err != nil {
+			return fmt.Errorf("deleting idempotency records: %w", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
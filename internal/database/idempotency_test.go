@@ -0,0 +1,102 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIdempotencyRecords(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	rec := &IdempotencyRecord{
+		AppPackageName:  "com.example.app",
+		CertificateHash: "cert-1",
+		Key:             "key-1",
+		RequestHash:     "hash-1",
+		Response:        []byte(`{"revisionToken":"abc"}`),
+		CreatedAt:       createdAt,
+	}
+	if err := testDB.SaveIdempotencyRecord(ctx, rec, createdAt.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := testDB.GetIdempotencyRecord(ctx, rec.AppPackageName, rec.CertificateHash, rec.Key, createdAt.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(rec, got); diff != "" {
+		t.Errorf("GetIdempotencyRecord mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Keys are scoped to the app.
+	if _, err := testDB.GetIdempotencyRecord(ctx, "com.example.other", rec.CertificateHash, rec.Key, createdAt.Add(-time.Hour)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetIdempotencyRecord for other app: got %v, want ErrNotFound", err)
+	}
+	// And to the certificate.
+	if _, err := testDB.GetIdempotencyRecord(ctx, rec.AppPackageName, "cert-2", rec.Key, createdAt.Add(-time.Hour)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetIdempotencyRecord for other certificate: got %v, want ErrNotFound", err)
+	}
+	// Expired records are not returned.
+	if _, err := testDB.GetIdempotencyRecord(ctx, rec.AppPackageName, rec.CertificateHash, rec.Key, createdAt); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetIdempotencyRecord after expiry: got %v, want ErrNotFound", err)
+	}
+
+	// A concurrent request doesn't replace a live record.
+	other := *rec
+	other.RequestHash = "hash-2"
+	other.CreatedAt = createdAt.Add(time.Minute)
+	if err := testDB.SaveIdempotencyRecord(ctx, &other, other.CreatedAt.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	got, err = testDB.GetIdempotencyRecord(ctx, rec.AppPackageName, rec.CertificateHash, rec.Key, createdAt.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RequestHash != rec.RequestHash {
+		t.Errorf("live record replaced: got hash %q, want %q", got.RequestHash, rec.RequestHash)
+	}
+
+	// An expired record is replaced.
+	other.CreatedAt = createdAt.Add(2 * time.Hour)
+	if err := testDB.SaveIdempotencyRecord(ctx, &other, other.CreatedAt.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	got, err = testDB.GetIdempotencyRecord(ctx, rec.AppPackageName, rec.CertificateHash, rec.Key, createdAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RequestHash != other.RequestHash {
+		t.Errorf("expired record kept: got hash %q, want %q", got.RequestHash, other.RequestHash)
+	}
+
+	n, err := testDB.DeleteIdempotencyRecords(ctx, createdAt.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("DeleteIdempotencyRecords: deleted %d, want 1", n)
+	}
+}
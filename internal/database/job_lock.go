@@ -0,0 +1,152 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// ErrLockLost indicates that a job lock was lost while it was held: its
+// connection broke.
+var ErrLockLost = errors.New("job lock lost")
+
+// jobLockSpace is the first key of the advisory locks of jobs, which keeps
+// them apart from advisory locks taken by anything else.
+const jobLockSpace = 0x454e4a42 // "ENJB"
+
+// JobLock is an exclusive lock on a scheduled job, so that only one instance
+// runs it at a time. It must be renewed while the job runs, and released when
+// the job is done.
+//
+// It is an advisory lock, held by a connection reserved for it, which is
+// released by the database if the process dies.
+type JobLock struct {
+	name string
+
+	// conn holds the advisory lock.
+	conn *pgxpool.Conn
+}
+
+// AcquireJobLock takes the lock of the named job. It returns ErrAlreadyLocked
+// if another process holds it. The lock has no TTL: it is held until it is
+// released or its connection breaks.
+func (db *DB) AcquireJobLock(ctx context.Context, name string) (*JobLock, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	var locked bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1, hashtext($2))`, jobLockSpace, name).Scan(&locked); err != nil {
+		conn.Release()
+		return nil, fmt.Errorf("taking advisory lock: %w", err)
+	}
+	if !locked {
+		conn.Release()
+		return nil, ErrAlreadyLocked
+	}
+	return &JobLock{name: name, conn: conn}, nil
+}
+
+// Renew checks, within ttl, that the lock is still held. It returns
+// ErrLockLost if it isn't, in which case the job must stop.
+func (l *JobLock) Renew(ctx context.Context, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, ttl)
+	defer cancel()
+
+	// The advisory lock is held as long as its session lives.
+	if err := l.conn.Conn().Ping(ctx); err != nil {
+		return fmt.Errorf("%w: %v", ErrLockLost, err)
+	}
+	return nil
+}
+
+// Release releases the lock.
+func (l *JobLock) Release(ctx context.Context) error {
+	defer l.conn.Release()
+	var unlocked bool
+	if err := l.conn.QueryRow(ctx, `SELECT pg_advisory_unlock($1, hashtext($2))`, jobLockSpace, l.name).Scan(&unlocked); err != nil {
+		// Closing the session releases its locks.
+		l.conn.Conn().Close(ctx)
+		return fmt.Errorf("releasing advisory lock: %w", err)
+	}
+	if !unlocked {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// LastJobStart returns when the named job last started, or the zero time if
+// it never ran.
+func (db *DB) LastJobStart(ctx context.Context, name string) (time.Time, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	var started time.Time
+	err = conn.QueryRow(ctx, `SELECT last_started FROM ScheduledJob WHERE job_name = $1`, name).Scan(&started)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("reading last start of job %v: %w", name, err)
+	}
+	return started, nil
+}
+
+// RecordJobStart records that the named job started at started. It should
+// only be called while holding the job's lock.
+func (db *DB) RecordJobStart(ctx context.Context, name string, started time.Time) error {
+	return db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO ScheduledJob
+				(job_name, last_started)
+			VALUES
+				($1, $2)
+			ON CONFLICT (job_name) DO UPDATE
+				SET last_started = $2, last_finished = NULL, last_result = NULL
+			`, name, started)
+		if err != nil {
+			return fmt.Errorf("recording start of job %v: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// RecordJobFinish records that the named job finished with result, such as
+// "success" or "failure".
+func (db *DB) RecordJobFinish(ctx context.Context, name string, result string) error {
+	return db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			UPDATE
+				ScheduledJob
+			SET
+				last_finished = NOW(), last_result = $2
+			WHERE
+				job_name = $1
+			`, name, result)
+		if err != nil {
+			return fmt.Errorf("recording finish of job %v: %w", name, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJobLock(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	lock, err := testDB.AcquireJobLock(ctx, "batcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fail to take a held lock.
+	if _, err := testDB.AcquireJobLock(ctx, "batcher"); !errors.Is(err, ErrAlreadyLocked) {
+		t.Fatalf("got %v, wanted ErrAlreadyLocked", err)
+	}
+
+	// Other jobs have locks of their own.
+	other, err := testDB.AcquireJobLock(ctx, "cleanup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lock.Renew(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lock is free once released.
+	lock, err = testDB.AcquireJobLock(ctx, "batcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobRuns(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	got, err := testDB.LastJobStart(ctx, "batcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsZero() {
+		t.Errorf("got last start %v for a job that never ran, want zero", got)
+	}
+
+	for _, started := range []time.Time{
+		time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2020, 9, 1, 12, 5, 0, 0, time.UTC),
+	} {
+		if err := testDB.RecordJobStart(ctx, "batcher", started); err != nil {
+			t.Fatal(err)
+		}
+		if err := testDB.RecordJobFinish(ctx, "batcher", "success"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := testDB.LastJobStart(ctx, "batcher")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(started) {
+			t.Errorf("got last start %v, want %v", got, started)
+		}
+	}
+}
@@ -0,0 +1,700 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+const (
+	// encryptedKeyPrefix marks an exposure_key value that is encrypted. It
+	// can't occur in a plain base64 key.
+	encryptedKeyPrefix = "enc:"
+
+	// dataKeySize is the size of a data encryption key: an AES-256 key
+	// followed by an HMAC-SHA256 key used to derive nonces.
+	dataKeySize = 64
+
+	// hashKeySize is the size of the HMAC-SHA256 key of exposure_key_hash.
+	hashKeySize = 32
+
+	// dataKeyRefreshPeriod is how often the active data key is reloaded, so
+	// that every server picks up a rotation.
+	dataKeyRefreshPeriod = 5 * time.Minute
+)
+
+// dataKeyAAD is the additional authenticated data used when wrapping data
+// keys with the KMS.
+var dataKeyAAD = []byte("exposure-key-encryption-key")
+
+// hashKeyAAD is the additional authenticated data used when wrapping the hash
+// key with the KMS.
+var hashKeyAAD = []byte("exposure-key-hash-key")
+
+// KeyWrapper encrypts and decrypts small secrets with a key held in a key
+// management system. signing.GCPKMS implements it.
+type KeyWrapper interface {
+	Encrypt(ctx context.Context, keyID string, plaintext, aad []byte) ([]byte, error)
+	Decrypt(ctx context.Context, keyID string, ciphertext, aad []byte) ([]byte, error)
+}
+
+// keyCrypter envelope encrypts exposure keys. Each exposure key is encrypted
+// with a data key, and the data keys are stored in the database wrapped by a
+// key in the KMS.
+//
+// Encryption is deterministic, with the nonce derived from the plaintext, but
+// the same exposure key encrypted with different data keys differs, so
+// exposures are deduplicated by exposure_key_hash, an HMAC of the plain key
+// under a hash key that is never rotated.
+type keyCrypter struct {
+	wrapper  KeyWrapper
+	kmsKeyID string
+	hashKey  []byte
+
+	mu       sync.RWMutex
+	active   *dataKey
+	loadedAt time.Time
+	keys     map[int64]*dataKey
+
+	// hashed is set once every exposure has an exposure_key_hash, and
+	// hashCheckedAt is when that was last checked.
+	hashed        bool
+	hashCheckedAt time.Time
+}
+
+type dataKey struct {
+	id    int64
+	aead  cipher.AEAD
+	nonce []byte // HMAC key for nonce derivation
+}
+
+func newDataKey(id int64, raw []byte) (*dataKey, error) {
+	if len(raw) != dataKeySize {
+		return nil, fmt.Errorf("data key %d has length %d, want %d", id, len(raw), dataKeySize)
+	}
+	block, err := aes.NewCipher(raw[:32])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &dataKey{id: id, aead: aead, nonce: raw[32:]}, nil
+}
+
+func (k *dataKey) seal(plaintext []byte) string {
+	mac := hmac.New(sha256.New, k.nonce)
+	mac.Write(plaintext)
+	nonce := mac.Sum(nil)[:k.aead.NonceSize()]
+	sealed := k.aead.Seal(nonce, nonce, plaintext, nil)
+	return encryptedKeyPrefix + strconv.FormatInt(k.id, 10) + ":" + base64.StdEncoding.EncodeToString(sealed)
+}
+
+func (k *dataKey) open(ciphertext []byte) ([]byte, error) {
+	n := k.aead.NonceSize()
+	if len(ciphertext) < n {
+		return nil, errors.New("encrypted exposure key is too short")
+	}
+	return k.aead.Open(nil, ciphertext[:n], ciphertext[n:], nil)
+}
+
+// parseEncryptedKey splits an encrypted exposure_key value into its data key
+// ID and ciphertext.
+func parseEncryptedKey(encoded string) (int64, []byte, error) {
+	parts := strings.SplitN(strings.TrimPrefix(encoded, encryptedKeyPrefix), ":", 2)
+	if len(parts) != 2 {
+		return 0, nil, errors.New("malformed encrypted exposure key")
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("malformed encrypted exposure key: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, nil, fmt.Errorf("malformed encrypted exposure key: %w", err)
+	}
+	return id, ciphertext, nil
+}
+
+// EnableExposureKeyEncryption turns on application-layer encryption of
+// exposure keys, using data keys wrapped by kmsKeyID. A data key is created
+// if none is active. Exposures written before encryption was enabled remain
+// readable, and are encrypted by ReencryptExposures.
+func (db *DB) EnableExposureKeyEncryption(ctx context.Context, wrapper KeyWrapper, kmsKeyID string) error {
+	kc := &keyCrypter{wrapper: wrapper, kmsKeyID: kmsKeyID}
+	if err := db.loadHashKey(ctx, kc); err != nil {
+		return err
+	}
+	if err := db.loadDataKeys(ctx, kc); err != nil {
+		return err
+	}
+	if kc.active == nil {
+		if err := db.rotateDataKey(ctx, kc); err != nil {
+			return err
+		}
+	}
+	db.keys = kc
+
+	// Each residency database wraps its own data keys with the same KMS key.
+	for _, rdb := range db.residencyDBs {
+		if err := rdb.EnableExposureKeyEncryption(ctx, wrapper, kmsKeyID); err != nil {
+			return fmt.Errorf("residency database %v: %w", rdb.residencyHost, err)
+		}
+	}
+	return nil
+}
+
+// ExposureKeyEncryptionEnabled reports whether exposure keys are encrypted.
+func (db *DB) ExposureKeyEncryptionEnabled() bool {
+	return db.keys != nil
+}
+
+// loadHashKey reads and unwraps the hash key into kc, creating it if it
+// doesn't exist yet.
+func (db *DB) loadHashKey(ctx context.Context, kc *keyCrypter) error {
+	kmsKeyID, wrapped, err := db.readHashKey(ctx)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if err := db.createHashKey(ctx, kc); err != nil {
+			return err
+		}
+		kmsKeyID, wrapped, err = db.readHashKey(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("reading hash key: %w", err)
+	}
+
+	raw, err := kc.wrapper.Decrypt(ctx, kmsKeyID, wrapped, hashKeyAAD)
+	if err != nil {
+		return fmt.Errorf("unwrapping hash key: %w", err)
+	}
+	if len(raw) != hashKeySize {
+		return fmt.Errorf("hash key has length %d, want %d", len(raw), hashKeySize)
+	}
+	kc.hashKey = raw
+	return nil
+}
+
+func (db *DB) readHashKey(ctx context.Context) (string, []byte, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	var (
+		kmsKeyID string
+		wrapped  []byte
+	)
+	row := conn.QueryRow(ctx, `
+		SELECT
+			kms_key_id, wrapped_key
+		FROM
+			ExposureKeyHashKey
+		WHERE
+			id = 1
+		`)
+	if err := row.Scan(&kmsKeyID, &wrapped); err != nil {
+		return "", nil, err
+	}
+	return kmsKeyID, wrapped, nil
+}
+
+// createHashKey stores a new hash key, unless another server stored one
+// first.
+func (db *DB) createHashKey(ctx context.Context, kc *keyCrypter) error {
+	raw := make([]byte, hashKeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return fmt.Errorf("generating hash key: %w", err)
+	}
+	wrapped, err := kc.wrapper.Encrypt(ctx, kc.kmsKeyID, raw, hashKeyAAD)
+	if err != nil {
+		return fmt.Errorf("wrapping hash key: %w", err)
+	}
+
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO
+			ExposureKeyHashKey
+			(id, kms_key_id, wrapped_key, created_at)
+		VALUES
+			(1, $1, $2, $3)
+		ON CONFLICT (id) DO NOTHING
+		`, kc.kmsKeyID, wrapped, time.Now().UTC()); err != nil {
+		return fmt.Errorf("inserting hash key: %w", err)
+	}
+	return nil
+}
+
+// loadDataKeys reads and unwraps every data key into kc.
+func (db *DB) loadDataKeys(ctx context.Context, kc *keyCrypter) error {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			id, kms_key_id, wrapped_key, active
+		FROM
+			ExposureKeyEncryptionKey
+		`)
+	if err != nil {
+		return fmt.Errorf("querying data keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make(map[int64]*dataKey)
+	var active *dataKey
+	for rows.Next() {
+		var (
+			id       int64
+			kmsKeyID string
+			wrapped  []byte
+			isActive bool
+		)
+		if err := rows.Scan(&id, &kmsKeyID, &wrapped, &isActive); err != nil {
+			return err
+		}
+		raw, err := kc.wrapper.Decrypt(ctx, kmsKeyID, wrapped, dataKeyAAD)
+		if err != nil {
+			return fmt.Errorf("unwrapping data key %d: %w", id, err)
+		}
+		k, err := newDataKey(id, raw)
+		if err != nil {
+			return err
+		}
+		keys[id] = k
+		if isActive {
+			active = k
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	kc.keys = keys
+	kc.active = active
+	kc.loadedAt = time.Now()
+	return nil
+}
+
+// activeDataKey returns the data key new exposures are encrypted with,
+// reloading the keys if they may be stale.
+func (db *DB) activeDataKey(ctx context.Context) (*dataKey, error) {
+	kc := db.keys
+	kc.mu.RLock()
+	active, loadedAt := kc.active, kc.loadedAt
+	kc.mu.RUnlock()
+
+	if active == nil || time.Since(loadedAt) > dataKeyRefreshPeriod {
+		if err := db.loadDataKeys(ctx, kc); err != nil {
+			if active != nil {
+				// Keep encrypting with the previous key; it is still valid.
+				logging.FromContext(ctx).Errorf("reloading data keys: %v", err)
+				return active, nil
+			}
+			return nil, err
+		}
+		kc.mu.RLock()
+		active = kc.active
+		kc.mu.RUnlock()
+	}
+	if active == nil {
+		return nil, errors.New("no active exposure key encryption key")
+	}
+	return active, nil
+}
+
+// dataKey returns the data key with the given ID, reloading the keys if it
+// isn't known yet.
+func (db *DB) dataKey(ctx context.Context, id int64) (*dataKey, error) {
+	kc := db.keys
+	kc.mu.RLock()
+	k, ok := kc.keys[id]
+	kc.mu.RUnlock()
+	if ok {
+		return k, nil
+	}
+
+	if err := db.loadDataKeys(ctx, kc); err != nil {
+		return nil, err
+	}
+	kc.mu.RLock()
+	k, ok = kc.keys[id]
+	kc.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown exposure key encryption key %d", id)
+	}
+	return k, nil
+}
+
+// sealExposureKey returns the value stored in exposure_key for key.
+func (db *DB) sealExposureKey(ctx context.Context, key []byte) (string, error) {
+	if db.keys == nil {
+		return encodeExposureKey(key), nil
+	}
+	k, err := db.activeDataKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	return k.seal(key), nil
+}
+
+// hashExposureKey returns the value stored in exposure_key_hash for key, or
+// nil if encryption is not enabled.
+func (db *DB) hashExposureKey(key []byte) *string {
+	if db.keys == nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, db.keys.hashKey)
+	mac.Write(key)
+	h := hex.EncodeToString(mac.Sum(nil))
+	return &h
+}
+
+// exposureKeyColumn returns the unique column that identifies an exposure by
+// its key: exposure_key_hash when keys are encrypted, and exposure_key
+// otherwise.
+func (db *DB) exposureKeyColumn() string {
+	if db.keys == nil {
+		return "exposure_key"
+	}
+	return "exposure_key_hash"
+}
+
+// openExposureKey returns the exposure key stored in exposure_key, which
+// may or may not be encrypted.
+func (db *DB) openExposureKey(ctx context.Context, encoded string) ([]byte, error) {
+	if !strings.HasPrefix(encoded, encryptedKeyPrefix) {
+		return decodeExposureKey(encoded)
+	}
+	if db.keys == nil {
+		return nil, errors.New("exposure key is encrypted but encryption is not enabled")
+	}
+	id, ciphertext, err := parseEncryptedKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	k, err := db.dataKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	key, err := k.open(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting exposure key: %w", err)
+	}
+	return key, nil
+}
+
+// exposureKeyCandidates returns every value exposure_key may hold for key:
+// the plain encoding and, if encryption is enabled, its encryption under each
+// known data key.
+func (db *DB) exposureKeyCandidates(key []byte) []string {
+	candidates := []string{encodeExposureKey(key)}
+	if db.keys == nil {
+		return candidates
+	}
+	db.keys.mu.RLock()
+	defer db.keys.mu.RUnlock()
+	for _, k := range db.keys.keys {
+		candidates = append(candidates, k.seal(key))
+	}
+	return candidates
+}
+
+// hashLegacyExposures sets exposure_key_hash on the exposures written before
+// encryption was enabled that have the same keys as exposures, so that
+// inserting exposures within tx conflicts with them. It is a no-op once
+// ReencryptExposures has hashed every exposure.
+func (db *DB) hashLegacyExposures(ctx context.Context, tx pgx.Tx, exposures []*Exposure) error {
+	if db.keys == nil || len(exposures) == 0 {
+		return nil
+	}
+	hashed, err := db.legacyExposuresHashed(ctx, tx)
+	if err != nil || hashed {
+		return err
+	}
+
+	keys := make([]string, 0, len(exposures))
+	hashes := make([]string, 0, len(exposures))
+	for _, e := range exposures {
+		keys = append(keys, encodeExposureKey(e.ExposureKey))
+		hashes = append(hashes, *db.hashExposureKey(e.ExposureKey))
+	}
+	// A hash already taken belongs to a republished copy, which
+	// ReencryptExposures deletes the legacy exposure in favor of.
+	if _, err := tx.Exec(ctx, `
+		UPDATE
+			Exposure
+		SET
+			exposure_key_hash = k.hash
+		FROM
+			unnest($1::text[], $2::text[]) AS k(exposure_key, hash)
+		WHERE
+			Exposure.exposure_key = k.exposure_key AND
+			Exposure.exposure_key_hash IS NULL AND
+			NOT EXISTS (SELECT 1 FROM Exposure e WHERE e.exposure_key_hash = k.hash)
+		`, keys, hashes); err != nil {
+		return fmt.Errorf("hashing existing exposures: %w", err)
+	}
+	return nil
+}
+
+// legacyExposuresHashed reports whether every exposure has an
+// exposure_key_hash, checking at most every dataKeyRefreshPeriod until one
+// does.
+func (db *DB) legacyExposuresHashed(ctx context.Context, tx pgx.Tx) (bool, error) {
+	kc := db.keys
+	kc.mu.RLock()
+	hashed, checkedAt := kc.hashed, kc.hashCheckedAt
+	kc.mu.RUnlock()
+	if hashed || time.Since(checkedAt) < dataKeyRefreshPeriod {
+		return hashed, nil
+	}
+
+	row := tx.QueryRow(ctx, `
+		SELECT NOT EXISTS (
+			SELECT 1 FROM Exposure WHERE exposure_key_hash IS NULL
+		)
+		`)
+	if err := row.Scan(&hashed); err != nil {
+		return false, fmt.Errorf("checking for unhashed exposures: %w", err)
+	}
+	kc.mu.Lock()
+	kc.hashed = kc.hashed || hashed
+	kc.hashCheckedAt = time.Now()
+	kc.mu.Unlock()
+	return hashed, nil
+}
+
+// RotateExposureKeyEncryption creates a new data key, wrapped by the current
+// version of the KMS key, and makes it the active key. Existing exposures keep
+// their old data key until ReencryptExposures rewrites them.
+func (db *DB) RotateExposureKeyEncryption(ctx context.Context) error {
+	if db.keys == nil {
+		return errors.New("exposure key encryption is not enabled")
+	}
+	return db.rotateDataKey(ctx, db.keys)
+}
+
+func (db *DB) rotateDataKey(ctx context.Context, kc *keyCrypter) error {
+	raw := make([]byte, dataKeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return fmt.Errorf("generating data key: %w", err)
+	}
+	wrapped, err := kc.wrapper.Encrypt(ctx, kc.kmsKeyID, raw, dataKeyAAD)
+	if err != nil {
+		return fmt.Errorf("wrapping data key: %w", err)
+	}
+
+	err = db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			UPDATE
+				ExposureKeyEncryptionKey
+			SET
+				active = false, retired_at = $1
+			WHERE
+				active
+			`, time.Now().UTC()); err != nil {
+			return fmt.Errorf("retiring data key: %w", err)
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO
+				ExposureKeyEncryptionKey
+				(kms_key_id, wrapped_key, active, created_at)
+			VALUES
+				($1, $2, true, $3)
+			`, kc.kmsKeyID, wrapped, time.Now().UTC()); err != nil {
+			return fmt.Errorf("inserting data key: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return db.loadDataKeys(ctx, kc)
+}
+
+// ActiveExposureKeyEncryptionCreatedAt returns when the active data key was
+// created.
+func (db *DB) ActiveExposureKeyEncryptionCreatedAt(ctx context.Context) (time.Time, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	var createdAt time.Time
+	row := conn.QueryRow(ctx, `
+		SELECT
+			created_at
+		FROM
+			ExposureKeyEncryptionKey
+		WHERE
+			active
+		`)
+	if err := row.Scan(&createdAt); err != nil {
+		if err == pgx.ErrNoRows {
+			return time.Time{}, ErrNotFound
+		}
+		return time.Time{}, err
+	}
+	return createdAt, nil
+}
+
+// ReencryptExposures rewrites up to batchSize exposures that aren't encrypted
+// with the active data key or have no exposure_key_hash, including exposures
+// written before encryption was enabled. It returns the number of exposures
+// rewritten; fewer than batchSize means none remain.
+func (db *DB) ReencryptExposures(ctx context.Context, batchSize int) (int, error) {
+	if db.keys == nil {
+		return 0, errors.New("exposure key encryption is not enabled")
+	}
+	active, err := db.activeDataKey(ctx)
+	if err != nil {
+		return 0, err
+	}
+	activePrefix := encryptedKeyPrefix + strconv.FormatInt(active.id, 10) + ":"
+
+	count := 0
+	err = db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		count = 0
+		rows, err := tx.Query(ctx, `
+			SELECT
+				exposure_key
+			FROM
+				Exposure
+			WHERE
+				exposure_key NOT LIKE $1 OR exposure_key_hash IS NULL
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+			`, activePrefix+"%", batchSize)
+		if err != nil {
+			return fmt.Errorf("selecting exposures: %w", err)
+		}
+		var stale []string
+		for rows.Next() {
+			var encoded string
+			if err := rows.Scan(&encoded); err != nil {
+				rows.Close()
+				return err
+			}
+			stale = append(stale, encoded)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, old := range stale {
+			key, err := db.openExposureKey(ctx, old)
+			if err != nil {
+				return err
+			}
+			// If the key was republished since, the old row is a duplicate.
+			result, err := tx.Exec(ctx, `
+				UPDATE
+					Exposure
+				SET
+					exposure_key = $2, exposure_key_hash = $3
+				WHERE
+					exposure_key = $1 AND
+					NOT EXISTS (
+						SELECT 1 FROM Exposure
+						WHERE (exposure_key = $2 OR exposure_key_hash = $3) AND exposure_key <> $1
+					)
+				`, old, active.seal(key), db.hashExposureKey(key))
+			if err != nil {
+				return fmt.Errorf("reencrypting exposure: %w", err)
+			}
+			if result.RowsAffected() == 0 {
+				if _, err := tx.Exec(ctx, `
+					DELETE FROM
+						Exposure
+					WHERE
+						exposure_key = $1
+					`, old); err != nil {
+					return fmt.Errorf("deleting duplicate exposure: %w", err)
+				}
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if count < batchSize {
+		db.keys.mu.Lock()
+		db.keys.hashed = true
+		db.keys.mu.Unlock()
+	}
+	return count, nil
+}
+
+// DeleteRetiredExposureKeyEncryptionKeys deletes data keys that were retired
+// before the given time and no longer encrypt any exposure. Servers may keep
+// encrypting with a retired key until they next reload keys, so retiredBefore
+// should allow for that. It returns the number of keys deleted.
+func (db *DB) DeleteRetiredExposureKeyEncryptionKeys(ctx context.Context, retiredBefore time.Time) (int64, error) {
+	var count int64
+	err := db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				ExposureKeyEncryptionKey k
+			WHERE
+				NOT k.active AND k.retired_at < $1 AND
+				NOT EXISTS (
+					SELECT 1 FROM Exposure
+					WHERE exposure_key LIKE $2 || k.id || ':%'
+				)
+			`, retiredBefore, encryptedKeyPrefix)
+		if err != nil {
+			return fmt.Errorf("deleting data keys: %w", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
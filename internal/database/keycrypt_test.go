@@ -0,0 +1,289 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeKeyWrapper "wraps" keys with a fixed AES-GCM key, standing in for a
+// KMS.
+type fakeKeyWrapper struct{}
+
+func (fakeKeyWrapper) aead() cipher.AEAD {
+	block, _ := aes.NewCipher(bytes.Repeat([]byte{7}, 32))
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+func (w fakeKeyWrapper) Encrypt(_ context.Context, keyID string, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, w.aead().NonceSize())
+	return w.aead().Seal(nonce, nonce, plaintext, append([]byte(keyID), aad...)), nil
+}
+
+func (w fakeKeyWrapper) Decrypt(_ context.Context, keyID string, ciphertext, aad []byte) ([]byte, error) {
+	n := w.aead().NonceSize()
+	if len(ciphertext) < n {
+		return nil, errors.New("short ciphertext")
+	}
+	return w.aead().Open(nil, ciphertext[:n], ciphertext[n:], append([]byte(keyID), aad...))
+}
+
+func TestDataKeySeal(t *testing.T) {
+	k1, err := newDataKey(1, bytes.Repeat([]byte{1}, dataKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := newDataKey(2, bytes.Repeat([]byte{2}, dataKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("0123456789abcdef")
+
+	sealed := k1.seal(key)
+	if !strings.HasPrefix(sealed, "enc:1:") {
+		t.Errorf("sealed key %q doesn't name its data key", sealed)
+	}
+	if len(sealed) > 100 {
+		t.Errorf("sealed key is %d characters, longer than the exposure_key column", len(sealed))
+	}
+	if got := k1.seal(key); got != sealed {
+		t.Errorf("seal is not deterministic: %q != %q", got, sealed)
+	}
+	if k2.seal(key) == sealed {
+		t.Errorf("different data keys produced the same ciphertext")
+	}
+
+	id, ciphertext, err := parseEncryptedKey(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 1 {
+		t.Errorf("parsed data key ID %d, want 1", id)
+	}
+	got, err := k1.open(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(key, got); diff != "" {
+		t.Errorf("open mismatch (-want, +got):\n%s", diff)
+	}
+	if _, err := k2.open(ciphertext); err == nil {
+		t.Errorf("expected error opening with the wrong data key")
+	}
+}
+
+func TestNewDataKeyInvalidSize(t *testing.T) {
+	if _, err := newDataKey(1, make([]byte, 32)); err == nil {
+		t.Errorf("expected error for short data key")
+	}
+}
+
+func TestParseEncryptedKeyInvalid(t *testing.T) {
+	for _, s := range []string{"enc:", "enc:x:AAAA", "enc:1:not base64!"} {
+		if _, _, err := parseEncryptedKey(s); err == nil {
+			t.Errorf("parseEncryptedKey(%q) expected error", s)
+		}
+	}
+}
+
+func TestExposureKeyEncryption(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	plainExposure := &Exposure{
+		ExposureKey:    []byte("0123456789abcdef"),
+		Regions:        []string{"US"},
+		IntervalNumber: 100,
+		IntervalCount:  144,
+		CreatedAt:      createdAt,
+	}
+	// Written before encryption is enabled.
+	if err := testDB.InsertExposures(ctx, []*Exposure{plainExposure}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Use a separate DB so that other tests aren't encrypted.
+	db := &DB{Pool: testDB.Pool}
+	if err := db.EnableExposureKeyEncryption(ctx, fakeKeyWrapper{}, "test-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedExposure := &Exposure{
+		ExposureKey:    []byte("fedcba9876543210"),
+		Regions:        []string{"US"},
+		IntervalNumber: 100,
+		IntervalCount:  144,
+		CreatedAt:      createdAt.Add(time.Hour),
+	}
+	if err := db.InsertExposures(ctx, []*Exposure{encryptedExposure, encryptedExposure}); err != nil {
+		t.Fatal(err)
+	}
+
+	storedKeys := func() []string {
+		t.Helper()
+		rows, err := testDB.Pool.Query(ctx, `SELECT exposure_key FROM Exposure ORDER BY exposure_key`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var keys []string
+		for rows.Next() {
+			var k string
+			if err := rows.Scan(&k); err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, k)
+		}
+		return keys
+	}
+
+	readKeys := func() [][]byte {
+		t.Helper()
+		var got [][]byte
+		if _, err := db.IterateExposures(ctx, IterateExposuresCriteria{}, func(e *Exposure) error {
+			got = append(got, e.ExposureKey)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	want := [][]byte{plainExposure.ExposureKey, encryptedExposure.ExposureKey}
+
+	if diff := cmp.Diff(want, readKeys()); diff != "" {
+		t.Errorf("IterateExposures mismatch (-want, +got):\n%s", diff)
+	}
+	if keys := storedKeys(); len(keys) != 2 || !strings.HasPrefix(keys[1], encryptedKeyPrefix) {
+		t.Errorf("stored keys %q, want one plain and one encrypted", keys)
+	}
+
+	// Rotate and re-encrypt everything with the new data key.
+	if err := db.RotateExposureKeyEncryption(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Republishing under the new data key is still a duplicate.
+	if err := db.InsertExposures(ctx, []*Exposure{encryptedExposure}); err != nil {
+		t.Fatal(err)
+	}
+	results, err := db.InsertExposuresDedupe(ctx, []*Exposure{encryptedExposure})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]InsertResult{InsertDuplicate}, results); diff != "" {
+		t.Errorf("InsertExposuresDedupe after rotation mismatch (-want, +got):\n%s", diff)
+	}
+	if keys := storedKeys(); len(keys) != 2 {
+		t.Errorf("stored %d exposures after republishing, want 2", len(keys))
+	}
+
+	n, err := db.ReencryptExposures(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("ReencryptExposures rewrote %d exposures, want 2", n)
+	}
+	active, err := db.activeDataKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range storedKeys() {
+		if id, _, err := parseEncryptedKey(k); err != nil || id != active.id {
+			t.Errorf("stored key %q is not encrypted with active data key %d", k, active.id)
+		}
+	}
+	if diff := cmp.Diff(want, readKeys()); diff != "" {
+		t.Errorf("IterateExposures after rotation mismatch (-want, +got):\n%s", diff)
+	}
+
+	deleted, err := db.DeleteRetiredExposureKeyEncryptionKeys(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted %d retired data keys, want 1", deleted)
+	}
+}
+
+func TestRepublishUnhashedExposure(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	exposure := &Exposure{
+		ExposureKey:    []byte("0123456789abcdef"),
+		Regions:        []string{"US"},
+		IntervalNumber: 100,
+		IntervalCount:  144,
+		CreatedAt:      time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+	// Written before encryption is enabled, so without an exposure_key_hash.
+	if err := testDB.InsertExposures(ctx, []*Exposure{exposure}); err != nil {
+		t.Fatal(err)
+	}
+
+	db := &DB{Pool: testDB.Pool}
+	if err := db.EnableExposureKeyEncryption(ctx, fakeKeyWrapper{}, "test-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func() int {
+		t.Helper()
+		var n int
+		if err := testDB.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM Exposure`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if err := db.InsertExposures(ctx, []*Exposure{exposure}); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("InsertExposures stored %d exposures, want 1", n)
+	}
+
+	results, err := db.InsertExposuresDedupe(ctx, []*Exposure{exposure})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]InsertResult{InsertDuplicate}, results); diff != "" {
+		t.Errorf("InsertExposuresDedupe mismatch (-want, +got):\n%s", diff)
+	}
+
+	if err := db.BulkInsertExposures(ctx, []*Exposure{exposure}); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("stored %d exposures after republishing, want 1", n)
+	}
+}
@@ -37,6 +37,16 @@ type UnlockFn func() error
 
 // Lock acquires lock with given name that times out after ttl. Returns an UnlockFn that can be used to unlock the lock. ErrAlreadyLocked will be returned if there is already a lock in use.
 func (db *DB) Lock(ctx context.Context, lockID string, ttl time.Duration) (UnlockFn, error) {
+	expires, err := db.acquireLock(ctx, lockID, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return makeUnlockFn(ctx, db, lockID, expires), nil
+}
+
+// acquireLock takes the lock with the given name for ttl and returns its
+// expiry, which identifies this holder of the lock.
+func (db *DB) acquireLock(ctx context.Context, lockID string, ttl time.Duration) (time.Time, error) {
 	var expires time.Time
 	err := db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
 		row := tx.QueryRow(ctx, `
@@ -51,10 +61,10 @@ func (db *DB) Lock(ctx context.Context, lockID string, ttl time.Duration) (Unloc
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return time.Time{}, err
 	}
 	logging.FromContext(ctx).Debugf("Acquired lock %q", lockID)
-	return makeUnlockFn(ctx, db, lockID, expires), nil
+	return expires, nil
 }
 
 func makeUnlockFn(ctx context.Context, db *DB, lockID string, expires time.Time) UnlockFn {
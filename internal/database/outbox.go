@@ -0,0 +1,212 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// OutboxEvent records that a batch of exposures was written. Events are
+// written in the same transaction as the exposures, so consumers polling the
+// outbox never miss a batch.
+type OutboxEvent struct {
+	ID             int64
+	CreatedAt      time.Time
+	ExposureCount  int
+	Regions        []string
+	FirstCreatedAt time.Time
+	LastCreatedAt  time.Time
+	Attempts       int
+}
+
+// writeOutboxEvent records an outbox event for exposures within tx. written is
+// the number of exposures that were actually inserted or updated; nothing is
+// recorded if it is zero.
+func writeOutboxEvent(ctx context.Context, tx pgx.Tx, exposures []*Exposure, written int64) error {
+	if written == 0 || len(exposures) == 0 {
+		return nil
+	}
+
+	regionSet := make(map[string]struct{})
+	first, last := exposures[0].CreatedAt, exposures[0].CreatedAt
+	for _, exp := range exposures {
+		for _, r := range exp.Regions {
+			regionSet[r] = struct{}{}
+		}
+		if exp.CreatedAt.Before(first) {
+			first = exp.CreatedAt
+		}
+		if exp.CreatedAt.After(last) {
+			last = exp.CreatedAt
+		}
+	}
+	regions := make([]string, 0, len(regionSet))
+	for r := range regionSet {
+		regions = append(regions, r)
+	}
+	sort.Strings(regions)
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO
+			ExposureOutbox
+			(exposure_count, regions, first_created_at, last_created_at)
+		VALUES
+			($1, $2, $3, $4)
+		`, written, regions, first, last)
+	if err != nil {
+		return fmt.Errorf("writing outbox event: %w", err)
+	}
+	return nil
+}
+
+// ClaimOutboxEvents leases up to limit undelivered outbox events, oldest
+// first. Claimed events are not returned by other calls until the lease
+// expires. Events that are not acknowledged with AckOutboxEvents before their
+// lease expires are delivered again.
+func (db *DB) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEvent, error) {
+	var events []*OutboxEvent
+	err := db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		events = nil
+		now := time.Now().UTC()
+		rows, err := tx.Query(ctx, `
+			SELECT
+				id, created_at, exposure_count, regions, first_created_at, last_created_at, attempts
+			FROM
+				ExposureOutbox
+			WHERE
+				delivered_at IS NULL AND (lease_expires IS NULL OR lease_expires < $1)
+			ORDER BY
+				id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+			`, now, limit)
+		if err != nil {
+			return fmt.Errorf("querying outbox: %w", err)
+		}
+		defer rows.Close()
+
+		var ids []int64
+		for rows.Next() {
+			var e OutboxEvent
+			if err := rows.Scan(&e.ID, &e.CreatedAt, &e.ExposureCount, &e.Regions, &e.FirstCreatedAt, &e.LastCreatedAt, &e.Attempts); err != nil {
+				return fmt.Errorf("scanning outbox event: %w", err)
+			}
+			e.Attempts++
+			events = append(events, &e)
+			ids = append(ids, e.ID)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		if len(ids) == 0 {
+			return nil
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE
+				ExposureOutbox
+			SET
+				lease_expires = $2, attempts = attempts + 1
+			WHERE
+				id = ANY($1)
+			`, ids, now.Add(lease))
+		if err != nil {
+			return fmt.Errorf("leasing outbox events: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// AckOutboxEvents marks the given outbox events as delivered.
+func (db *DB) AckOutboxEvents(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			UPDATE
+				ExposureOutbox
+			SET
+				delivered_at = $2, lease_expires = NULL
+			WHERE
+				id = ANY($1) AND delivered_at IS NULL
+			`, ids, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("acknowledging outbox events: %w", err)
+		}
+		return nil
+	})
+}
+
+// PollOutbox claims up to limit outbox events and calls f on each of them,
+// acknowledging the events for which f succeeds. Events for which f fails are
+// delivered again once their lease expires, so f must be idempotent. It
+// returns the number of events delivered.
+func (db *DB) PollOutbox(ctx context.Context, limit int, lease time.Duration, f func(*OutboxEvent) error) (int, error) {
+	logger := logging.FromContext(ctx)
+
+	events, err := db.ClaimOutboxEvents(ctx, limit, lease)
+	if err != nil {
+		return 0, err
+	}
+
+	var delivered []int64
+	for _, e := range events {
+		if err := f(e); err != nil {
+			logger.Errorf("delivering outbox event %d (attempt %d): %v", e.ID, e.Attempts, err)
+			continue
+		}
+		delivered = append(delivered, e.ID)
+	}
+	if err := db.AckOutboxEvents(ctx, delivered); err != nil {
+		return 0, err
+	}
+	return len(delivered), nil
+}
+
+// DeleteDeliveredOutboxEvents deletes outbox events delivered before the
+// given time and returns the number deleted.
+func (db *DB) DeleteDeliveredOutboxEvents(ctx context.Context, before time.Time) (int64, error) {
+	var count int64
+	err := db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				ExposureOutbox
+			WHERE
+				delivered_at < $1
+			`, before)
+		if err != nil {
+			return fmt.Errorf("deleting outbox events: %w", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
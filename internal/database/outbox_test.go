@@ -0,0 +1,97 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestOutbox(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	createdAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	exposures := []*Exposure{
+		{ExposureKey: []byte("ABC"), Regions: []string{"US"}, IntervalNumber: 1, IntervalCount: 144, CreatedAt: createdAt},
+		{ExposureKey: []byte("DEF"), Regions: []string{"CA", "US"}, IntervalNumber: 1, IntervalCount: 144, CreatedAt: createdAt.Add(time.Hour)},
+	}
+	if err := testDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+	// Inserting the same exposures again writes nothing, so records no event.
+	if err := testDB.InsertExposures(ctx, exposures); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := testDB.ClaimOutboxEvents(ctx, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*OutboxEvent{{
+		ExposureCount:  2,
+		Regions:        []string{"CA", "US"},
+		FirstCreatedAt: createdAt,
+		LastCreatedAt:  createdAt.Add(time.Hour),
+		Attempts:       1,
+	}}
+	opts := cmpopts.IgnoreFields(OutboxEvent{}, "ID", "CreatedAt")
+	if diff := cmp.Diff(want, events, opts); diff != "" {
+		t.Fatalf("ClaimOutboxEvents mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Leased events are not claimed again.
+	if again, err := testDB.ClaimOutboxEvents(ctx, 10, time.Hour); err != nil || len(again) != 0 {
+		t.Fatalf("ClaimOutboxEvents: got %d events, %v, want none", len(again), err)
+	}
+
+	// Failed deliveries are retried once the lease expires.
+	if _, err := testDB.Pool.Exec(ctx, `UPDATE ExposureOutbox SET lease_expires = NULL`); err != nil {
+		t.Fatal(err)
+	}
+	n, err := testDB.PollOutbox(ctx, 10, 0, func(*OutboxEvent) error { return errors.New("boom") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("PollOutbox: delivered %d, want 0", n)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	var got []*OutboxEvent
+	n, err = testDB.PollOutbox(ctx, 10, time.Hour, func(e *OutboxEvent) error { got = append(got, e); return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(got) != 1 || got[0].Attempts != 3 {
+		t.Fatalf("PollOutbox: delivered %d events %+v, want 1 event on attempt 3", n, got)
+	}
+
+	// Delivered events are never delivered again.
+	if again, err := testDB.ClaimOutboxEvents(ctx, 10, 0); err != nil || len(again) != 0 {
+		t.Fatalf("ClaimOutboxEvents: got %d events, %v, want none", len(again), err)
+	}
+	if n, err := testDB.DeleteDeliveredOutboxEvents(ctx, time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Errorf("DeleteDeliveredOutboxEvents: got %d, %v, want 1, nil", n, err)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	poolLabels = []string{"pool"}
+
+	poolAcquiredConnsDesc = prometheus.NewDesc("en_db_pool_acquired_conns",
+		"Connections currently in use.", poolLabels, nil)
+	poolIdleConnsDesc = prometheus.NewDesc("en_db_pool_idle_conns",
+		"Idle connections in the pool.", poolLabels, nil)
+	poolTotalConnsDesc = prometheus.NewDesc("en_db_pool_total_conns",
+		"Connections in the pool, including those being established.", poolLabels, nil)
+	poolConstructingConnsDesc = prometheus.NewDesc("en_db_pool_constructing_conns",
+		"Connections being established.", poolLabels, nil)
+	poolMaxConnsDesc = prometheus.NewDesc("en_db_pool_max_conns",
+		"Maximum size of the pool.", poolLabels, nil)
+	poolAcquiresDesc = prometheus.NewDesc("en_db_pool_acquires_total",
+		"Connections acquired from the pool.", poolLabels, nil)
+	poolEmptyAcquiresDesc = prometheus.NewDesc("en_db_pool_empty_acquires_total",
+		"Acquires that waited for a connection because the pool had none idle.", poolLabels, nil)
+	poolCanceledAcquiresDesc = prometheus.NewDesc("en_db_pool_canceled_acquires_total",
+		"Acquires that were canceled before a connection was available.", poolLabels, nil)
+	poolAcquireSecondsDesc = prometheus.NewDesc("en_db_pool_acquire_seconds_total",
+		"Total time spent acquiring connections, including waits for one to be available.", poolLabels, nil)
+	poolSlowQueriesDesc = prometheus.NewDesc("en_db_pool_slow_queries_total",
+		"Statements that took longer than DB_SLOW_QUERY_THRESHOLD.", poolLabels, nil)
+)
+
+// Compile-time check to verify implements interface.
+var _ prometheus.Collector = (*poolCollector)(nil)
+
+// poolCollector exports the statistics of the database's connection pools.
+type poolCollector struct {
+	db *DB
+}
+
+// PoolCollector returns a Prometheus collector of the statistics of the
+// primary connection pool and, if configured, the read replica pool and the
+// pools of the residency databases.
+func (db *DB) PoolCollector() prometheus.Collector {
+	return &poolCollector{db: db}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredConnsDesc
+	ch <- poolIdleConnsDesc
+	ch <- poolTotalConnsDesc
+	ch <- poolConstructingConnsDesc
+	ch <- poolMaxConnsDesc
+	ch <- poolAcquiresDesc
+	ch <- poolEmptyAcquiresDesc
+	ch <- poolCanceledAcquiresDesc
+	ch <- poolAcquireSecondsDesc
+	ch <- poolSlowQueriesDesc
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	collectPool(ch, "primary", c.db.Pool, c.db.slowQueries)
+	if c.db.replica != nil {
+		collectPool(ch, "replica", c.db.replica, c.db.replicaSlowQueries)
+	}
+	for _, rdb := range c.db.residencyDBs {
+		collectPool(ch, "residency:"+rdb.residencyHost, rdb.Pool, rdb.slowQueries)
+	}
+}
+
+func collectPool(ch chan<- prometheus.Metric, name string, pool *pgxpool.Pool, slow *slowQueryLog) {
+	if pool == nil {
+		return
+	}
+	stat := pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConnsDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()), name)
+	ch <- prometheus.MustNewConstMetric(poolIdleConnsDesc, prometheus.GaugeValue, float64(stat.IdleConns()), name)
+	ch <- prometheus.MustNewConstMetric(poolTotalConnsDesc, prometheus.GaugeValue, float64(stat.TotalConns()), name)
+	ch <- prometheus.MustNewConstMetric(poolConstructingConnsDesc, prometheus.GaugeValue, float64(stat.ConstructingConns()), name)
+	ch <- prometheus.MustNewConstMetric(poolMaxConnsDesc, prometheus.GaugeValue, float64(stat.MaxConns()), name)
+	ch <- prometheus.MustNewConstMetric(poolAcquiresDesc, prometheus.CounterValue, float64(stat.AcquireCount()), name)
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquiresDesc, prometheus.CounterValue, float64(stat.EmptyAcquireCount()), name)
+	ch <- prometheus.MustNewConstMetric(poolCanceledAcquiresDesc, prometheus.CounterValue, float64(stat.CanceledAcquireCount()), name)
+	ch <- prometheus.MustNewConstMetric(poolAcquireSecondsDesc, prometheus.CounterValue, stat.AcquireDuration().Seconds(), name)
+	ch <- prometheus.MustNewConstMetric(poolSlowQueriesDesc, prometheus.CounterValue, float64(slow.slowQueries()), name)
+}
+
+// AcquireStats returns the number of connections acquired so far from the
+// pools of the databases that store exposures, and the total time spent
+// acquiring them, including waits for one to be available.
+func (db *DB) AcquireStats() (int64, time.Duration) {
+	var acquires int64
+	var wait time.Duration
+	for _, rdb := range db.Residencies() {
+		if rdb.Pool == nil {
+			continue
+		}
+		stat := rdb.Pool.Stat()
+		acquires += stat.AcquireCount()
+		wait += stat.AcquireDuration()
+	}
+	return acquires, wait
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// ReadPreference determines where reads are served from.
+type ReadPreference int
+
+const (
+	// ReadPrimary reads from the primary database.
+	ReadPrimary ReadPreference = iota
+
+	// ReadReplica reads from the read replica if one is configured and it has
+	// replicated every exposure in the requested time range, and from the
+	// primary otherwise.
+	ReadReplica
+)
+
+// readPool returns the pool that exposures matching criteria should be read
+// from.
+func (db *DB) readPool(ctx context.Context, criteria IterateExposuresCriteria) *pgxpool.Pool {
+	if criteria.ReadPreference != ReadReplica || db.replica == nil {
+		return db.Pool
+	}
+
+	logger := logging.FromContext(ctx)
+	caughtUp, err := db.replicaCaughtUp(ctx, replicaBoundary(criteria, time.Now()))
+	if err != nil {
+		logger.Warnf("checking replica lag, reading from primary: %v", err)
+		return db.Pool
+	}
+	if !caughtUp {
+		logger.Infof("replica is behind the requested time range, reading from primary")
+		return db.Pool
+	}
+	return db.replica
+}
+
+// replicaBoundary returns the time the replica must have replicated up to in
+// order to serve criteria.
+func replicaBoundary(criteria IterateExposuresCriteria, now time.Time) time.Time {
+	if !criteria.UntilTimestamp.IsZero() && criteria.UntilTimestamp.Before(now) {
+		return criteria.UntilTimestamp
+	}
+	if !criteria.SinceTimestamp.IsZero() && criteria.SinceTimestamp.Before(now) {
+		return criteria.SinceTimestamp
+	}
+	return now
+}
+
+// replicaCaughtUp returns true if the replica has replayed every transaction
+// it has received and that was committed before boundary. A replica that has
+// replayed everything it received is considered caught up regardless of
+// boundary, since an idle primary produces no newer transactions.
+func (db *DB) replicaCaughtUp(ctx context.Context, boundary time.Time) (bool, error) {
+	conn, err := db.replica.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Release()
+
+	var caughtUp bool
+	row := conn.QueryRow(ctx, `
+		SELECT
+			COALESCE(pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn(), false)
+			OR COALESCE(pg_last_xact_replay_timestamp() >= $1, false)
+		`, boundary)
+	if err := row.Scan(&caughtUp); err != nil {
+		return false, err
+	}
+	return caughtUp, nil
+}
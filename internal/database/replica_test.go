@@ -0,0 +1,50 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReplicaBoundary(t *testing.T) {
+	now := time.Date(2020, 5, 10, 12, 0, 0, 0, time.UTC)
+	since := now.Add(-4 * time.Hour)
+	until := now.Add(-2 * time.Hour)
+
+	for _, test := range []struct {
+		name     string
+		criteria IterateExposuresCriteria
+		want     time.Time
+	}{
+		{"open ended", IterateExposuresCriteria{}, now},
+		{"since only", IterateExposuresCriteria{SinceTimestamp: since}, since},
+		{"until", IterateExposuresCriteria{SinceTimestamp: since, UntilTimestamp: until}, until},
+		{"until in future", IterateExposuresCriteria{SinceTimestamp: since, UntilTimestamp: now.Add(time.Hour)}, since},
+		{"since in future", IterateExposuresCriteria{SinceTimestamp: now.Add(time.Hour)}, now},
+	} {
+		if got := replicaBoundary(test.criteria, now); !got.Equal(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReadPoolWithoutReplica(t *testing.T) {
+	db := &DB{}
+	if got := db.readPool(context.Background(), IterateExposuresCriteria{ReadPreference: ReadReplica}); got != db.Pool {
+		t.Errorf("readPool without replica should return the primary pool")
+	}
+}
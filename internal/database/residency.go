@@ -0,0 +1,123 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrMixedResidency indicates that the regions of an exposure are stored in
+// different databases, so it can't be stored in one of them.
+var ErrMixedResidency = errors.New("regions are stored in different databases")
+
+// ResidencyConfigs returns the configs of the databases of
+// DB_RESIDENCY_HOSTS, keyed by host. Each is a copy of c that connects to the
+// host, without residency hosts or a read replica of its own.
+func (c *Config) ResidencyConfigs() map[string]*Config {
+	configs := make(map[string]*Config)
+	for _, host := range c.ResidencyHosts {
+		if _, ok := configs[host]; ok {
+			continue
+		}
+		rc := *c
+		rc.Host = host
+		rc.ResidencyHosts = nil
+		rc.ReplicaHost = ""
+		rc.ReplicaPort = ""
+		configs[host] = &rc
+	}
+	return configs
+}
+
+// connectResidencies opens the databases of config's residency hosts and
+// routes their regions to them.
+func (db *DB) connectResidencies(ctx context.Context, config *Config) error {
+	byHost := make(map[string]*DB)
+	for host, rc := range config.ResidencyConfigs() {
+		rdb, err := NewFromEnv(ctx, rc)
+		if err != nil {
+			return fmt.Errorf("residency database %v: %w", host, err)
+		}
+		rdb.residencyHost = host
+		byHost[host] = rdb
+		db.residencyDBs = append(db.residencyDBs, rdb)
+	}
+	sort.Slice(db.residencyDBs, func(i, j int) bool {
+		return db.residencyDBs[i].residencyHost < db.residencyDBs[j].residencyHost
+	})
+
+	db.residency = make(map[string]*DB, len(config.ResidencyHosts))
+	for region, host := range config.ResidencyHosts {
+		db.residency[strings.ToUpper(region)] = byHost[host]
+	}
+	return nil
+}
+
+// ForRegion returns the database that stores the exposures of region: the
+// database of its DB_RESIDENCY_HOSTS entry, or db itself.
+func (db *DB) ForRegion(region string) *DB {
+	if rdb, ok := db.residency[strings.ToUpper(region)]; ok {
+		return rdb
+	}
+	return db
+}
+
+// ForRegions returns the database that stores exposures of regions. It
+// returns ErrMixedResidency if the regions are stored in different databases.
+func (db *DB) ForRegions(regions []string) (*DB, error) {
+	if len(db.residency) == 0 || len(regions) == 0 {
+		return db, nil
+	}
+	target := db.ForRegion(regions[0])
+	for _, region := range regions[1:] {
+		if db.ForRegion(region) != target {
+			return nil, fmt.Errorf("%w: %v", ErrMixedResidency, strings.Join(regions, ", "))
+		}
+	}
+	return target, nil
+}
+
+// Residencies returns every database that stores exposures: db itself,
+// followed by the databases of DB_RESIDENCY_HOSTS.
+func (db *DB) Residencies() []*DB {
+	return append([]*DB{db}, db.residencyDBs...)
+}
+
+// ResidencyName names the database among Residencies, for logs and metrics:
+// "main", or the host of a residency database.
+func (db *DB) ResidencyName() string {
+	if db.residencyHost == "" {
+		return "main"
+	}
+	return db.residencyHost
+}
+
+// ForExposures returns the database that stores exposures, which must all be
+// stored in the same one. It returns ErrMixedResidency if their regions are
+// stored in different databases.
+func (db *DB) ForExposures(exposures []*Exposure) (*DB, error) {
+	if len(db.residency) == 0 {
+		return db, nil
+	}
+	var regions []string
+	for _, exp := range exposures {
+		regions = append(regions, exp.Regions...)
+	}
+	return db.ForRegions(regions)
+}
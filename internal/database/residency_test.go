@@ -0,0 +1,85 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResidencyConfigs(t *testing.T) {
+	config := &Config{
+		Host:           "db-main",
+		Name:           "en",
+		ReplicaHost:    "db-replica",
+		ResidencyHosts: map[string]string{"DE": "db-eu", "FR": "db-eu", "CA": "db-ca"},
+	}
+	got := config.ResidencyConfigs()
+	want := map[string]*Config{
+		"db-eu": {Host: "db-eu", Name: "en"},
+		"db-ca": {Host: "db-ca", Name: "en"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestResidencyRouting(t *testing.T) {
+	eu := &DB{residencyHost: "db-eu"}
+	ca := &DB{residencyHost: "db-ca"}
+	db := &DB{
+		residency:    map[string]*DB{"DE": eu, "FR": eu, "CA": ca},
+		residencyDBs: []*DB{ca, eu},
+	}
+
+	for region, want := range map[string]*DB{"DE": eu, "fr": eu, "CA": ca, "US": db} {
+		if got := db.ForRegion(region); got != want {
+			t.Errorf("ForRegion(%q): got %v database, want %v", region, got.ResidencyName(), want.ResidencyName())
+		}
+	}
+
+	cases := []struct {
+		name    string
+		regions []string
+		want    *DB
+		wantErr error
+	}{
+		{name: "none", want: db},
+		{name: "main", regions: []string{"US", "MX"}, want: db},
+		{name: "same residency", regions: []string{"DE", "FR"}, want: eu},
+		{name: "mixed", regions: []string{"DE", "US"}, wantErr: ErrMixedResidency},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := db.ForExposures([]*Exposure{{Regions: c.regions}})
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("got error %v, want %v", err, c.wantErr)
+			}
+			if got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	var names []string
+	for _, rdb := range db.Residencies() {
+		names = append(names, rdb.ResidencyName())
+	}
+	if diff := cmp.Diff([]string{"main", "db-ca", "db-eu"}, names); diff != "" {
+		t.Errorf("Residencies mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+const (
+	// pgSerializationFailure and pgDeadlockDetected are the Postgres error
+	// codes for transaction conflicts that succeed when retried.
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+// retryPolicy determines how many times and how quickly failed transactions
+// are retried.
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+// backoff returns how long to wait before retrying after the given attempt,
+// counting from zero. It uses exponential backoff with full jitter.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	if p.baseDelay <= 0 {
+		return 0
+	}
+	ceiling := p.baseDelay
+	for i := 0; i < attempt && (p.maxDelay <= 0 || ceiling < p.maxDelay); i++ {
+		ceiling *= 2
+	}
+	if p.maxDelay > 0 && ceiling > p.maxDelay {
+		ceiling = p.maxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// isRetryable returns true if err is a transaction conflict that may succeed
+// if the transaction is retried.
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := retryPolicy{maxRetries: 5, baseDelay: 10 * time.Millisecond, maxDelay: 50 * time.Millisecond}
+	for attempt, ceiling := range []time.Duration{10, 20, 40, 50, 50, 50} {
+		ceiling *= time.Millisecond
+		for i := 0; i < 100; i++ {
+			if got := p.backoff(attempt); got < 0 || got > ceiling {
+				t.Fatalf("backoff(%d) = %v, want between 0 and %v", attempt, got, ceiling)
+			}
+		}
+	}
+
+	if got := (retryPolicy{}).backoff(3); got != 0 {
+		t.Errorf("zero policy backoff = %v, want 0", got)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&pgconn.PgError{Code: pgSerializationFailure}, true},
+		{&pgconn.PgError{Code: pgDeadlockDetected}, true},
+		{fmt.Errorf("committing transaction: %w", &pgconn.PgError{Code: pgSerializationFailure}), true},
+		{&pgconn.PgError{Code: pgUniqueViolation}, false},
+		{errors.New("serialization failure"), false},
+	} {
+		if got := isRetryable(test.err); got != test.want {
+			t.Errorf("isRetryable(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
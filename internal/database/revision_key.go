@@ -0,0 +1,128 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// RevisionKey is a key that encrypts revision tokens, wrapped by a key in the
+// KMS.
+type RevisionKey struct {
+	KeyID      int64
+	KMSKeyID   string
+	WrappedKey []byte
+	Active     bool
+	CreatedAt  time.Time
+	RetiredAt  *time.Time
+}
+
+// ListRevisionKeys returns every revision key, including retired keys.
+func (db *DB) ListRevisionKeys(ctx context.Context) ([]*RevisionKey, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `
+		SELECT
+			id, kms_key_id, wrapped_key, active, created_at, retired_at
+		FROM
+			RevisionTokenKey
+		ORDER BY
+			id
+		`)
+	if err != nil {
+		return nil, fmt.Errorf("querying revision keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*RevisionKey
+	for rows.Next() {
+		var k RevisionKey
+		if err := rows.Scan(&k.KeyID, &k.KMSKeyID, &k.WrappedKey, &k.Active, &k.CreatedAt, &k.RetiredAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, &k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// CreateRevisionKey stores a new wrapped revision key and makes it the active
+// key, retiring the previously active key. It returns the new key's ID.
+func (db *DB) CreateRevisionKey(ctx context.Context, kmsKeyID string, wrappedKey []byte) (int64, error) {
+	var id int64
+	err := db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		now := time.Now().UTC()
+		if _, err := tx.Exec(ctx, `
+			UPDATE
+				RevisionTokenKey
+			SET
+				active = false, retired_at = $1
+			WHERE
+				active
+			`, now); err != nil {
+			return fmt.Errorf("retiring revision key: %w", err)
+		}
+		row := tx.QueryRow(ctx, `
+			INSERT INTO
+				RevisionTokenKey
+				(kms_key_id, wrapped_key, active, created_at)
+			VALUES
+				($1, $2, true, $3)
+			RETURNING id
+			`, kmsKeyID, wrappedKey, now)
+		if err := row.Scan(&id); err != nil {
+			return fmt.Errorf("inserting revision key: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// DeleteRetiredRevisionKeys deletes revision keys that were retired before
+// the given time. Tokens encrypted with them can no longer be read. It returns
+// the number of keys deleted.
+func (db *DB) DeleteRetiredRevisionKeys(ctx context.Context, retiredBefore time.Time) (int64, error) {
+	var count int64
+	err := db.inTx(ctx, pgx.ReadCommitted, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			DELETE FROM
+				RevisionTokenKey
+			WHERE
+				NOT active AND retired_at < $1
+			`, retiredBefore)
+		if err != nil {
+			return fmt.Errorf("deleting revision keys: %w", err)
+		}
+		count = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
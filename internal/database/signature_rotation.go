@@ -0,0 +1,150 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// ErrAlreadyRotated is returned by RotateSignatureInfo if the signature info
+// was already rotated.
+var ErrAlreadyRotated = errors.New("signature info already rotated")
+
+// ListSignatureInfos returns all signature infos, ordered by ID.
+func (db *DB) ListSignatureInfos(ctx context.Context) ([]*SignatureInfo, error) {
+	return db.listSignatureInfos(ctx, `
+    SELECT
+      id, signing_key, app_package_name, bundle_id, signing_key_version, signing_key_id,
+      from_timestamp, thru_timestamp, auto_rotate, created_at, COALESCE(rotated_to_id, 0)
+    FROM
+      SignatureInfo
+    ORDER BY
+      id
+  `)
+}
+
+// ListSignatureInfosToRotate returns the signature infos marked for automatic
+// rotation that haven't been rotated or retired yet, and that have been in use
+// since before the given time. A key is in use from its start time, or from
+// when it was added if it has none.
+func (db *DB) ListSignatureInfosToRotate(ctx context.Context, inUseBefore, now time.Time) ([]*SignatureInfo, error) {
+	return db.listSignatureInfos(ctx, `
+    SELECT
+      id, signing_key, app_package_name, bundle_id, signing_key_version, signing_key_id,
+      from_timestamp, thru_timestamp, auto_rotate, created_at, COALESCE(rotated_to_id, 0)
+    FROM
+      SignatureInfo
+    WHERE
+      auto_rotate AND
+      rotated_to_id IS NULL AND
+      (thru_timestamp IS NULL OR thru_timestamp > $2) AND
+      COALESCE(from_timestamp, created_at) <= $1
+    ORDER BY
+      id
+  `, inUseBefore, now)
+}
+
+func (db *DB) listSignatureInfos(ctx context.Context, query string, args ...interface{}) ([]*SignatureInfo, error) {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sigInfos []*SignatureInfo
+	for rows.Next() {
+		var info SignatureInfo
+		var from, thru *time.Time
+		if err := rows.Scan(&info.ID, &info.SigningKey, &info.AppPackageName, &info.BundleID, &info.SigningKeyVersion, &info.SigningKeyID,
+			&from, &thru, &info.AutoRotate, &info.CreatedAt, &info.RotatedToID); err != nil {
+			return nil, err
+		}
+		if from != nil {
+			info.StartTimestamp = *from
+		}
+		if thru != nil {
+			info.EndTimestamp = *thru
+		}
+		sigInfos = append(sigInfos, &info)
+	}
+	return sigInfos, rows.Err()
+}
+
+// RotateSignatureInfo replaces the signature info oldID with next, the
+// signature info of a new version of its key. next is added to every export
+// config that signs with oldID, so files are signed by both keys until the old
+// key expires at retireAt, unless it was already set to expire earlier. It
+// returns ErrAlreadyRotated, and changes nothing, if oldID was already
+// rotated.
+func (db *DB) RotateSignatureInfo(ctx context.Context, oldID int64, next *SignatureInfo, retireAt time.Time) error {
+	return db.inTx(ctx, pgx.Serializable, func(tx pgx.Tx) error {
+		var rotatedTo *int64
+		if err := tx.QueryRow(ctx, `
+      SELECT
+        rotated_to_id
+      FROM
+        SignatureInfo
+      WHERE
+        id = $1
+      FOR UPDATE
+    `, oldID).Scan(&rotatedTo); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+			return fmt.Errorf("locking signature info: %w", err)
+		}
+		if rotatedTo != nil {
+			return ErrAlreadyRotated
+		}
+
+		if err := addSignatureInfo(ctx, tx, next); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `
+      UPDATE
+        SignatureInfo
+      SET
+        thru_timestamp = LEAST(thru_timestamp, $2), rotated_to_id = $3
+      WHERE
+        id = $1
+    `, oldID, retireAt, next.ID); err != nil {
+			return fmt.Errorf("retiring signature info: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, `
+      UPDATE
+        ExportConfig
+      SET
+        signature_info_ids = array_append(signature_info_ids, $2::INT)
+      WHERE
+        $1::INT = any(signature_info_ids) AND NOT ($2::INT = any(signature_info_ids))
+    `, oldID, next.ID); err != nil {
+			return fmt.Errorf("updating export configs: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestAutoRotateSignatureInfo(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer ResetTestDB(t, testDB)
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Microsecond)
+
+	oldKey := &SignatureInfo{
+		SigningKey:        "/kms/project/key/cryptoKeyVersions/1",
+		SigningKeyID:      "310",
+		SigningKeyVersion: "v1",
+		StartTimestamp:    now.Add(-100 * 24 * time.Hour),
+		AutoRotate:        true,
+	}
+	manualKey := &SignatureInfo{
+		SigningKey:     "/kms/project/other/cryptoKeyVersions/1",
+		StartTimestamp: now.Add(-100 * 24 * time.Hour),
+	}
+	recentKey := &SignatureInfo{
+		SigningKey:     "/kms/project/recent/cryptoKeyVersions/1",
+		StartTimestamp: now.Add(-24 * time.Hour),
+		AutoRotate:     true,
+	}
+	for _, si := range []*SignatureInfo{oldKey, manualKey, recentKey} {
+		if err := testDB.AddSignatureInfo(ctx, si); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var configs []*ExportConfig
+	for i, ids := range [][]int64{{oldKey.ID}, {manualKey.ID}} {
+		ec := &ExportConfig{
+			BucketName:       "bucket",
+			FilenameRoot:     []string{"root-a", "root-b"}[i],
+			Period:           24 * time.Hour,
+			Region:           "US",
+			From:             now.Add(-time.Hour),
+			SignatureInfoIDs: ids,
+		}
+		if err := testDB.AddExportConfig(ctx, ec); err != nil {
+			t.Fatal(err)
+		}
+		configs = append(configs, ec)
+	}
+
+	// Only the old key marked for rotation is due.
+	rotationPeriod := 90 * 24 * time.Hour
+	due, err := testDB.ListSignatureInfosToRotate(ctx, now.Add(-rotationPeriod), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].ID != oldKey.ID {
+		t.Fatalf("got signature infos to rotate %+v, want only %d", due, oldKey.ID)
+	}
+
+	next := &SignatureInfo{
+		SigningKey:        "/kms/project/key/cryptoKeyVersions/2",
+		SigningKeyID:      "310",
+		SigningKeyVersion: "v2",
+		StartTimestamp:    now.Add(24 * time.Hour),
+		AutoRotate:        true,
+	}
+	retireAt := now.Add(31 * 24 * time.Hour)
+	if err := testDB.RotateSignatureInfo(ctx, oldKey.ID, next, retireAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.RotateSignatureInfo(ctx, oldKey.ID, &SignatureInfo{SigningKey: "other"}, retireAt); !errors.Is(err, ErrAlreadyRotated) {
+		t.Errorf("rotating twice: got %v, want %v", err, ErrAlreadyRotated)
+	}
+
+	got, err := testDB.ListSignatureInfos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey.EndTimestamp = retireAt
+	oldKey.RotatedToID = next.ID
+	want := []*SignatureInfo{oldKey, manualKey, recentKey, next}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(SignatureInfo{}, "CreatedAt")); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The new key signs the old key's export configs only.
+	gotIDs := make(map[int64][]int64)
+	if err := testDB.IterateExportConfigs(ctx, now, func(ec *ExportConfig) error {
+		gotIDs[ec.ConfigID] = ec.SignatureInfoIDs
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	wantIDs := map[int64][]int64{
+		configs[0].ConfigID: {oldKey.ID, next.ID},
+		configs[1].ConfigID: {manualKey.ID},
+	}
+	if diff := cmp.Diff(wantIDs, gotIDs); diff != "" {
+		t.Errorf("signature info IDs mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Nothing is due anymore.
+	due, err = testDB.ListSignatureInfosToRotate(ctx, now.Add(-rotationPeriod), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 0 {
+		t.Errorf("got %d signature infos to rotate after rotation, want 0", len(due))
+	}
+}
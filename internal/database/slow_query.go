@@ -0,0 +1,86 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// maxLoggedSQLLength bounds the SQL of slow queries in logs.
+const maxLoggedSQLLength = 1000
+
+// Compile-time check to verify implements interface.
+var _ pgx.Logger = (*slowQueryLog)(nil)
+
+// slowQueryLog logs the statements of a connection pool that take longer than
+// DB_SLOW_QUERY_THRESHOLD, and counts them for the pool metrics. Their
+// arguments, which may hold exposure keys, are never logged.
+type slowQueryLog struct {
+	threshold time.Duration
+	count     int64
+}
+
+// newSlowQueryLog returns a slowQueryLog for threshold, or nil if threshold
+// isn't positive.
+func newSlowQueryLog(threshold time.Duration) *slowQueryLog {
+	if threshold <= 0 {
+		return nil
+	}
+	return &slowQueryLog{threshold: threshold}
+}
+
+// configure makes the connections of config report their statements to l.
+func (l *slowQueryLog) configure(config *pgx.ConnConfig) {
+	if l == nil {
+		return
+	}
+	config.Logger = l
+	config.LogLevel = pgx.LogLevelInfo
+}
+
+// Log implements pgx.Logger. pgx logs each statement once it's done, with its
+// duration, which for queries includes the time spent reading their rows.
+func (l *slowQueryLog) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	d, ok := data["time"].(time.Duration)
+	if !ok || d < l.threshold {
+		return
+	}
+	atomic.AddInt64(&l.count, 1)
+
+	sql, _ := data["sql"].(string)
+	sql = strings.Join(strings.Fields(sql), " ")
+	if len(sql) > maxLoggedSQLLength {
+		sql = sql[:maxLoggedSQLLength] + "..."
+	}
+	logging.FromContext(ctx).Warnw("slow database statement",
+		"statement", msg,
+		"duration", d.String(),
+		"sql", sql)
+}
+
+// slowQueries returns the number of slow statements logged so far.
+func (l *slowQueryLog) slowQueries() int64 {
+	if l == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&l.count)
+}
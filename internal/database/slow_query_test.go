@@ -0,0 +1,52 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	if l := newSlowQueryLog(0); l != nil {
+		t.Fatalf("newSlowQueryLog(0): got %v, want nil", l)
+	}
+	var disabled *slowQueryLog
+	config := &pgx.ConnConfig{}
+	disabled.configure(config)
+	if config.Logger != nil {
+		t.Errorf("disabled log configured a logger")
+	}
+	if got := disabled.slowQueries(); got != 0 {
+		t.Errorf("disabled log counted %d slow queries", got)
+	}
+
+	ctx := context.Background()
+	l := newSlowQueryLog(time.Second)
+	l.configure(config)
+	if config.Logger != l || config.LogLevel != pgx.LogLevelInfo {
+		t.Errorf("configure: got logger %v at level %v", config.Logger, config.LogLevel)
+	}
+	l.Log(ctx, pgx.LogLevelInfo, "Query", map[string]interface{}{"sql": "SELECT 1", "time": 10 * time.Millisecond})
+	l.Log(ctx, pgx.LogLevelInfo, "Query", map[string]interface{}{"sql": "SELECT\n\t2", "time": 2 * time.Second})
+	l.Log(ctx, pgx.LogLevelInfo, "Exec", map[string]interface{}{"sql": "DELETE FROM Exposure", "time": time.Second})
+	l.Log(ctx, pgx.LogLevelInfo, "Dialing PostgreSQL server", map[string]interface{}{"host": "localhost"})
+	if got, want := l.slowQueries(), int64(2); got != want {
+		t.Errorf("slow queries: got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// TransformStage adjusts the exposures that a Transformer built from a
+// publish request, before they are checked for overlaps and stored. Stages
+// run in order, each seeing the changes of the ones before it. A stage that
+// returns an error rejects the whole publish.
+type TransformStage func(inData *Publish, exposures []*Exposure) error
+
+// Names of the built-in transform stages.
+const (
+	TransformStageRegions        = "regions"
+	TransformStageReportTypeRisk = "report-type-risk"
+	TransformStageOnsetRisk      = "onset-risk"
+)
+
+// DefaultTransformStages are the stages a Transformer runs unless configured
+// with WithTransformStages.
+var DefaultTransformStages = []string{TransformStageRegions, TransformStageReportTypeRisk}
+
+var (
+	transformStagesMu sync.RWMutex
+	transformStages   = map[string]TransformStage{
+		TransformStageRegions:        NormalizeRegions,
+		TransformStageReportTypeRisk: ReportTypeRisk,
+		TransformStageOnsetRisk:      OnsetRisk,
+	}
+)
+
+// RegisterTransformStage makes a stage available by name to
+// TransformStagesByName, so that deployments can add their own stages, such
+// as national risk scoring, and enable them in configuration. It is meant to
+// be called from an init function and panics if the name is already taken.
+func RegisterTransformStage(name string, stage TransformStage) {
+	transformStagesMu.Lock()
+	defer transformStagesMu.Unlock()
+
+	if _, ok := transformStages[name]; ok {
+		panic(fmt.Sprintf("transform stage %q is already registered", name))
+	}
+	transformStages[name] = stage
+}
+
+// TransformStagesByName returns the registered stages with the given names,
+// in order.
+func TransformStagesByName(names []string) ([]TransformStage, error) {
+	transformStagesMu.RLock()
+	defer transformStagesMu.RUnlock()
+
+	stages := make([]TransformStage, 0, len(names))
+	for _, name := range names {
+		stage, ok := transformStages[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform stage %q", name)
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+// NormalizeRegions uppercases the regions of the exposures for storage. There
+// is no set of "valid" regions overall, but it is defined elsewhere by what
+// regions an authorized application may write to. See `authorizedapp.Config`
+func NormalizeRegions(inData *Publish, exposures []*Exposure) error {
+	upcaseRegions := make([]string, len(inData.Regions))
+	for i, r := range inData.Regions {
+		upcaseRegions[i] = strings.ToUpper(r)
+	}
+	for _, exp := range exposures {
+		exp.Regions = upcaseRegions
+	}
+	return nil
+}
+
+// ReportTypeRisk derives the transmission risk of exposures that were
+// published without one from the report type. See ReportTypeTransmissionRisk.
+func ReportTypeRisk(inData *Publish, exposures []*Exposure) error {
+	for _, exp := range exposures {
+		exp.TransmissionRisk = ReportTypeTransmissionRisk(exp.ReportType, exp.TransmissionRisk)
+	}
+	return nil
+}
+
+// Transmission risks assigned by OnsetRisk. As with the report type risks,
+// lower values mean the key's owner was more likely to be infectious.
+const (
+	TransmissionRiskOnsetPeak    = 1
+	TransmissionRiskOnsetNear    = 3
+	TransmissionRiskOnsetDistant = 5
+)
+
+// OnsetRisk derives the transmission risk of exposures that were published
+// without one from the days between symptom onset and the key's interval:
+// keys from around symptom onset get the highest risk. Exposures without a
+// symptom onset are left unchanged. It must run before ReportTypeRisk for the
+// onset to take precedence over the report type.
+func OnsetRisk(inData *Publish, exposures []*Exposure) error {
+	for _, exp := range exposures {
+		ds := exp.DaysSinceSymptomOnset
+		if ds == nil || exp.TransmissionRisk != 0 {
+			continue
+		}
+		switch {
+		case *ds >= -2 && *ds <= 2:
+			exp.TransmissionRisk = TransmissionRiskOnsetPeak
+		case *ds >= -5 && *ds <= 7:
+			exp.TransmissionRisk = TransmissionRiskOnsetNear
+		default:
+			exp.TransmissionRisk = TransmissionRiskOnsetDistant
+		}
+	}
+	return nil
+}
+
+// OnsetRiskTable returns a stage that sets the transmission risk of exposures
+// published without one from table, which maps days since symptom onset to a
+// risk. Exposures without a symptom onset, or whose onset isn't in the table,
+// are left unchanged.
+func OnsetRiskTable(table map[int32]int) TransformStage {
+	return func(inData *Publish, exposures []*Exposure) error {
+		for _, exp := range exposures {
+			ds := exp.DaysSinceSymptomOnset
+			if ds == nil || exp.TransmissionRisk != 0 {
+				continue
+			}
+			if risk, ok := table[*ds]; ok {
+				exp.TransmissionRisk = risk
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTransformStages(t *testing.T) {
+	batchTime := time.Date(2020, 2, 29, 11, 15, 1, 0, time.UTC)
+	today := IntervalNumber(time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC))
+	onset := int32(1)
+	source := &Publish{
+		Keys: []ExposureKey{
+			{
+				Key:                      encodeKey(generateKey(t)),
+				IntervalNumber:           today - 2*MaxIntervalCount,
+				IntervalCount:            MaxIntervalCount,
+				DaysSinceOnsetOfSymptoms: &onset,
+			},
+			{
+				Key:            encodeKey(generateKey(t)),
+				IntervalNumber: today - MaxIntervalCount,
+				IntervalCount:  MaxIntervalCount,
+			},
+		},
+		Regions:        []string{"us"},
+		AppPackageName: "com.google",
+		ReportType:     ReportTypeLikely,
+	}
+
+	// A custom stage sees the changes of the stages before it.
+	var seen []string
+	custom := func(inData *Publish, exposures []*Exposure) error {
+		for _, exp := range exposures {
+			seen = append(seen, exp.Regions[0])
+			exp.TransmissionRisk++
+		}
+		return nil
+	}
+
+	cases := []struct {
+		name     string
+		stages   []TransformStage
+		wantRisk []int
+		regions  []string
+	}{
+		{
+			name:     "default",
+			wantRisk: []int{TransmissionRiskLikely, TransmissionRiskLikely},
+			regions:  []string{"US"},
+		},
+		{
+			name:     "onset first",
+			stages:   []TransformStage{NormalizeRegions, OnsetRisk, ReportTypeRisk},
+			wantRisk: []int{TransmissionRiskOnsetPeak, TransmissionRiskLikely},
+			regions:  []string{"US"},
+		},
+		{
+			name:     "custom",
+			stages:   []TransformStage{NormalizeRegions, ReportTypeRisk, custom},
+			wantRisk: []int{TransmissionRiskLikely + 1, TransmissionRiskLikely + 1},
+			regions:  []string{"US"},
+		},
+		{
+			name:     "no regions stage",
+			stages:   []TransformStage{ReportTypeRisk},
+			wantRisk: []int{TransmissionRiskLikely, TransmissionRiskLikely},
+			regions:  []string{"us"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			transformer, err := NewTransformer(10, 14*24*time.Hour, time.Hour, WithTransformStages(c.stages...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := transformer.TransformPublish(source, batchTime)
+			if err != nil {
+				t.Fatalf("TransformPublish returned unexpected error: %v", err)
+			}
+			for i, exp := range got {
+				if exp.TransmissionRisk != c.wantRisk[i] {
+					t.Errorf("key %d: got transmission risk %d, want %d", i, exp.TransmissionRisk, c.wantRisk[i])
+				}
+				if diff := cmp.Diff(c.regions, exp.Regions); diff != "" {
+					t.Errorf("key %d: regions mismatch (-want, +got):\n%s", i, diff)
+				}
+			}
+		})
+	}
+	if diff := cmp.Diff([]string{"US", "US"}, seen); diff != "" {
+		t.Errorf("custom stage regions mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestTransformStageError(t *testing.T) {
+	errRejected := errors.New("rejected")
+	reject := func(inData *Publish, exposures []*Exposure) error {
+		return errRejected
+	}
+	transformer, err := NewTransformer(10, 14*24*time.Hour, time.Hour, WithTransformStages(reject))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &Publish{
+		Keys: []ExposureKey{
+			{
+				Key:            encodeKey(generateKey(t)),
+				IntervalNumber: IntervalNumber(time.Date(2020, 2, 28, 0, 0, 0, 0, time.UTC)),
+				IntervalCount:  MaxIntervalCount,
+			},
+		},
+	}
+	if _, err := transformer.TransformPublish(source, time.Date(2020, 2, 29, 11, 15, 1, 0, time.UTC)); err == nil {
+		t.Errorf("expected the stage to reject the publish")
+	}
+}
+
+func TestOnsetRisk(t *testing.T) {
+	cases := []struct {
+		onset    *int32
+		provided int
+		want     int
+	}{
+		{nil, 0, 0},
+		{int32Ptr(0), 0, TransmissionRiskOnsetPeak},
+		{int32Ptr(-2), 0, TransmissionRiskOnsetPeak},
+		{int32Ptr(2), 0, TransmissionRiskOnsetPeak},
+		{int32Ptr(-5), 0, TransmissionRiskOnsetNear},
+		{int32Ptr(7), 0, TransmissionRiskOnsetNear},
+		{int32Ptr(-6), 0, TransmissionRiskOnsetDistant},
+		{int32Ptr(14), 0, TransmissionRiskOnsetDistant},
+		{int32Ptr(0), 6, 6},
+	}
+	for _, c := range cases {
+		exp := &Exposure{TransmissionRisk: c.provided, DaysSinceSymptomOnset: c.onset}
+		if err := OnsetRisk(&Publish{}, []*Exposure{exp}); err != nil {
+			t.Fatal(err)
+		}
+		if exp.TransmissionRisk != c.want {
+			t.Errorf("OnsetRisk(onset %v, risk %d) = %d, want %d", c.onset, c.provided, exp.TransmissionRisk, c.want)
+		}
+	}
+}
+
+func TestOnsetRiskTable(t *testing.T) {
+	stage := OnsetRiskTable(map[int32]int{-2: 1, 0: 1, 5: 4})
+	cases := []struct {
+		onset    *int32
+		provided int
+		want     int
+	}{
+		{nil, 0, 0},
+		{int32Ptr(0), 0, 1},
+		{int32Ptr(5), 0, 4},
+		{int32Ptr(3), 0, 0},
+		{int32Ptr(0), 6, 6},
+	}
+	for _, c := range cases {
+		exp := &Exposure{TransmissionRisk: c.provided, DaysSinceSymptomOnset: c.onset}
+		if err := stage(&Publish{}, []*Exposure{exp}); err != nil {
+			t.Fatal(err)
+		}
+		if exp.TransmissionRisk != c.want {
+			t.Errorf("OnsetRiskTable(onset %v, risk %d) = %d, want %d", c.onset, c.provided, exp.TransmissionRisk, c.want)
+		}
+	}
+
+	// Extra stages run before the transformer's own, so the table takes
+	// precedence over the report type.
+	batchTime := time.Date(2020, 2, 29, 11, 15, 1, 0, time.UTC)
+	today := IntervalNumber(time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC))
+	source := &Publish{
+		Keys: []ExposureKey{
+			{
+				Key:            encodeKey(generateKey(t)),
+				IntervalNumber: today - MaxIntervalCount,
+				IntervalCount:  MaxIntervalCount,
+			},
+		},
+		Regions:              []string{"US"},
+		ReportType:           ReportTypeConfirmed,
+		SymptomOnsetInterval: today - MaxIntervalCount,
+	}
+	transformer, err := NewTransformer(10, 14*24*time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := transformer.TransformPublish(source, batchTime, stage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].TransmissionRisk != 1 {
+		t.Errorf("got transmission risk %d, want 1", got[0].TransmissionRisk)
+	}
+}
+
+func TestTransformStagesByName(t *testing.T) {
+	stages, err := TransformStagesByName(DefaultTransformStages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stages) != len(DefaultTransformStages) {
+		t.Errorf("got %d stages, want %d", len(stages), len(DefaultTransformStages))
+	}
+
+	if _, err := TransformStagesByName([]string{"no-such-stage"}); err == nil {
+		t.Errorf("expected unknown stage to be rejected")
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package efgs
+
+import (
+	"fmt"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// OriginPrefix prefixes the origin country of keys downloaded from the
+// gateway to form their exposure's origin.
+const OriginPrefix = "efgs:"
+
+// ReportType is the gateway's report type of a key.
+type ReportType int32
+
+// Report types defined by the gateway.
+const (
+	ReportTypeUnknown                    ReportType = 0
+	ReportTypeConfirmedTest              ReportType = 1
+	ReportTypeConfirmedClinicalDiagnosis ReportType = 2
+	ReportTypeSelfReport                 ReportType = 3
+	ReportTypeRecursive                  ReportType = 4
+	ReportTypeRevoked                    ReportType = 5
+)
+
+// DiagnosisKey is a key as exchanged with the gateway.
+type DiagnosisKey struct {
+	KeyData                    []byte
+	RollingStartIntervalNumber uint32
+	RollingPeriod              uint32
+	TransmissionRiskLevel      int32
+	VisitedCountries           []string
+	Origin                     string
+	ReportType                 ReportType
+	DaysSinceOnsetOfSymptoms   int32
+}
+
+// DiagnosisKeyBatch is the body of gateway uploads and downloads.
+type DiagnosisKeyBatch struct {
+	Keys []*DiagnosisKey
+}
+
+// Field numbers of the gateway's protobuf messages.
+const (
+	batchKeysField = 1
+
+	keyDataField          = 1
+	rollingStartField     = 2
+	rollingPeriodField    = 3
+	transmissionRiskField = 4
+	visitedCountriesField = 5
+	originField           = 6
+	reportTypeField       = 7
+	daysSinceOnsetField   = 8
+)
+
+// Marshal encodes the batch in the gateway's protobuf wire format.
+func (b *DiagnosisKeyBatch) Marshal() []byte {
+	var out []byte
+	for _, k := range b.Keys {
+		out = protowire.AppendTag(out, batchKeysField, protowire.BytesType)
+		out = protowire.AppendBytes(out, k.marshal())
+	}
+	return out
+}
+
+func (k *DiagnosisKey) marshal() []byte {
+	var out []byte
+	if len(k.KeyData) > 0 {
+		out = protowire.AppendTag(out, keyDataField, protowire.BytesType)
+		out = protowire.AppendBytes(out, k.KeyData)
+	}
+	out = appendVarint(out, rollingStartField, uint64(k.RollingStartIntervalNumber))
+	out = appendVarint(out, rollingPeriodField, uint64(k.RollingPeriod))
+	out = appendVarint(out, transmissionRiskField, uint64(int64(k.TransmissionRiskLevel)))
+	for _, c := range k.VisitedCountries {
+		out = protowire.AppendTag(out, visitedCountriesField, protowire.BytesType)
+		out = protowire.AppendString(out, c)
+	}
+	if k.Origin != "" {
+		out = protowire.AppendTag(out, originField, protowire.BytesType)
+		out = protowire.AppendString(out, k.Origin)
+	}
+	out = appendVarint(out, reportTypeField, uint64(int64(k.ReportType)))
+	out = appendVarint(out, daysSinceOnsetField, protowire.EncodeZigZag(int64(k.DaysSinceOnsetOfSymptoms)))
+	return out
+}
+
+// appendVarint appends a varint field, omitting it when zero as proto3 does.
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// UnmarshalBatch decodes a batch in the gateway's protobuf wire format.
+// Unknown fields are skipped.
+func UnmarshalBatch(data []byte) (*DiagnosisKeyBatch, error) {
+	batch := &DiagnosisKeyBatch{}
+	err := parseFields(data, func(num protowire.Number, typ protowire.Type, v []byte) (int, error) {
+		if num != batchKeysField || typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, v), nil
+		}
+		msg, n := protowire.ConsumeBytes(v)
+		if n < 0 {
+			return n, nil
+		}
+		key, err := unmarshalKey(msg)
+		if err != nil {
+			return 0, err
+		}
+		batch.Keys = append(batch.Keys, key)
+		return n, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid batch: %w", err)
+	}
+	return batch, nil
+}
+
+func unmarshalKey(data []byte) (*DiagnosisKey, error) {
+	k := &DiagnosisKey{}
+	err := parseFields(data, func(num protowire.Number, typ protowire.Type, v []byte) (int, error) {
+		switch {
+		case typ == protowire.BytesType && num == keyDataField:
+			b, n := protowire.ConsumeBytes(v)
+			k.KeyData = append([]byte(nil), b...)
+			return n, nil
+		case typ == protowire.BytesType && num == visitedCountriesField:
+			b, n := protowire.ConsumeBytes(v)
+			k.VisitedCountries = append(k.VisitedCountries, string(b))
+			return n, nil
+		case typ == protowire.BytesType && num == originField:
+			b, n := protowire.ConsumeBytes(v)
+			k.Origin = string(b)
+			return n, nil
+		case typ == protowire.VarintType:
+			x, n := protowire.ConsumeVarint(v)
+			switch num {
+			case rollingStartField:
+				k.RollingStartIntervalNumber = uint32(x)
+			case rollingPeriodField:
+				k.RollingPeriod = uint32(x)
+			case transmissionRiskField:
+				k.TransmissionRiskLevel = int32(x)
+			case reportTypeField:
+				k.ReportType = ReportType(int32(x))
+			case daysSinceOnsetField:
+				k.DaysSinceOnsetOfSymptoms = int32(protowire.DecodeZigZag(x))
+			}
+			return n, nil
+		default:
+			return protowire.ConsumeFieldValue(num, typ, v), nil
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	return k, nil
+}
+
+// parseFields calls f with the value of each field in data. f returns the
+// length of the value it consumed, or a negative protowire error code.
+func parseFields(data []byte, f func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		n, err := f(num, typ, data)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// KeyFromExposure converts a local exposure into a gateway key originating
+// from country.
+func KeyFromExposure(e *database.Exposure, country string) *DiagnosisKey {
+	k := &DiagnosisKey{
+		KeyData:                    e.ExposureKey,
+		RollingStartIntervalNumber: uint32(e.IntervalNumber),
+		RollingPeriod:              uint32(e.IntervalCount),
+		TransmissionRiskLevel:      int32(e.TransmissionRisk),
+		Origin:                     country,
+		ReportType:                 ReportTypeConfirmedTest,
+	}
+	for _, region := range e.Regions {
+		if region != country {
+			k.VisitedCountries = append(k.VisitedCountries, region)
+		}
+	}
+	switch e.ReportType {
+	case database.ReportTypeLikely:
+		k.ReportType = ReportTypeConfirmedClinicalDiagnosis
+	case database.ReportTypeNegative:
+		k.ReportType = ReportTypeRevoked
+	}
+	if e.DaysSinceSymptomOnset != nil {
+		k.DaysSinceOnsetOfSymptoms = *e.DaysSinceSymptomOnset
+	}
+	return k
+}
+
+// Exposure converts a gateway key into an exposure that isn't of local
+// provenance. Its regions are the key's origin and visited countries, and its
+// origin is the key's origin country, prefixed with OriginPrefix. Keys with an
+// invalid transmission risk or that were revoked return nil.
+func (k *DiagnosisKey) Exposure() *database.Exposure {
+	if k.TransmissionRiskLevel < database.MinTransmissionRisk || k.TransmissionRiskLevel > database.MaxTransmissionRisk {
+		return nil
+	}
+
+	e := &database.Exposure{
+		ExposureKey:      k.KeyData,
+		TransmissionRisk: int(k.TransmissionRiskLevel),
+		IntervalNumber:   int32(k.RollingStartIntervalNumber),
+		IntervalCount:    int32(k.RollingPeriod),
+		LocalProvenance:  false,
+		Origin:           OriginPrefix + k.Origin,
+	}
+	switch k.ReportType {
+	case ReportTypeConfirmedTest:
+		e.ReportType = database.ReportTypeConfirmed
+	case ReportTypeConfirmedClinicalDiagnosis, ReportTypeSelfReport:
+		e.ReportType = database.ReportTypeLikely
+	case ReportTypeRevoked:
+		return nil
+	}
+	// The gateway encodes unknown or symptom-free onsets as large offsets,
+	// which have no local equivalent.
+	if d := k.DaysSinceOnsetOfSymptoms; d >= database.MinDaysSinceSymptomOnset && d <= database.MaxDaysSinceSymptomOnset {
+		e.DaysSinceSymptomOnset = &d
+	}
+
+	seen := map[string]struct{}{}
+	for _, c := range append([]string{k.Origin}, k.VisitedCountries...) {
+		if _, ok := seen[c]; ok || c == "" {
+			continue
+		}
+		seen[c] = struct{}{}
+		e.Regions = append(e.Regions, c)
+	}
+	e.Traveler = len(k.VisitedCountries) > 0
+	return e
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package efgs
+
+import (
+	"testing"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBatchRoundTrip(t *testing.T) {
+	batch := &DiagnosisKeyBatch{
+		Keys: []*DiagnosisKey{
+			{
+				KeyData:                    []byte("0123456789abcdef"),
+				RollingStartIntervalNumber: 2650000,
+				RollingPeriod:              144,
+				TransmissionRiskLevel:      3,
+				VisitedCountries:           []string{"FR", "IT"},
+				Origin:                     "DE",
+				ReportType:                 ReportTypeConfirmedTest,
+				DaysSinceOnsetOfSymptoms:   -3,
+			},
+			{
+				KeyData:                    []byte("fedcba9876543210"),
+				RollingStartIntervalNumber: 2650144,
+				RollingPeriod:              144,
+				Origin:                     "NL",
+				ReportType:                 ReportTypeRevoked,
+			},
+		},
+	}
+
+	got, err := UnmarshalBatch(batch.Marshal())
+	if err != nil {
+		t.Fatalf("UnmarshalBatch: %v", err)
+	}
+	if diff := cmp.Diff(batch, got); diff != "" {
+		t.Errorf("batch mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := UnmarshalBatch([]byte{0x0a, 0x05, 0x01}); err == nil {
+		t.Errorf("UnmarshalBatch of truncated data: expected error")
+	}
+}
+
+func TestExposureConversion(t *testing.T) {
+	onset := int32(2)
+
+	cases := []struct {
+		name string
+		key  *DiagnosisKey
+		want *database.Exposure
+	}{
+		{
+			name: "visited countries",
+			key: &DiagnosisKey{
+				KeyData:                    []byte("key"),
+				RollingStartIntervalNumber: 100,
+				RollingPeriod:              144,
+				TransmissionRiskLevel:      4,
+				VisitedCountries:           []string{"FR", "DE"},
+				Origin:                     "DE",
+				ReportType:                 ReportTypeConfirmedClinicalDiagnosis,
+				DaysSinceOnsetOfSymptoms:   2,
+			},
+			want: &database.Exposure{
+				ExposureKey:           []byte("key"),
+				TransmissionRisk:      4,
+				IntervalNumber:        100,
+				IntervalCount:         144,
+				Regions:               []string{"DE", "FR"},
+				Traveler:              true,
+				ReportType:            database.ReportTypeLikely,
+				DaysSinceSymptomOnset: &onset,
+				Origin:                "efgs:DE",
+			},
+		},
+		{
+			name: "unknown onset",
+			key: &DiagnosisKey{
+				KeyData:                  []byte("key"),
+				RollingPeriod:            144,
+				TransmissionRiskLevel:    1,
+				Origin:                   "IT",
+				ReportType:               ReportTypeConfirmedTest,
+				DaysSinceOnsetOfSymptoms: 4000,
+			},
+			want: &database.Exposure{
+				ExposureKey:      []byte("key"),
+				TransmissionRisk: 1,
+				IntervalCount:    144,
+				Regions:          []string{"IT"},
+				ReportType:       database.ReportTypeConfirmed,
+				Origin:           "efgs:IT",
+			},
+		},
+		{
+			name: "revoked",
+			key:  &DiagnosisKey{KeyData: []byte("key"), TransmissionRiskLevel: 1, Origin: "IT", ReportType: ReportTypeRevoked},
+		},
+		{
+			name: "invalid transmission risk",
+			key:  &DiagnosisKey{KeyData: []byte("key"), TransmissionRiskLevel: 100, Origin: "IT"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, tc.key.Exposure()); diff != "" {
+				t.Errorf("Exposure mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestKeyFromExposure(t *testing.T) {
+	onset := int32(-1)
+	e := &database.Exposure{
+		ExposureKey:           []byte("key"),
+		TransmissionRisk:      2,
+		IntervalNumber:        100,
+		IntervalCount:         144,
+		Regions:               []string{"DE", "FR"},
+		ReportType:            database.ReportTypeConfirmed,
+		DaysSinceSymptomOnset: &onset,
+	}
+	want := &DiagnosisKey{
+		KeyData:                    []byte("key"),
+		RollingStartIntervalNumber: 100,
+		RollingPeriod:              144,
+		TransmissionRiskLevel:      2,
+		VisitedCountries:           []string{"FR"},
+		Origin:                     "DE",
+		ReportType:                 ReportTypeConfirmedTest,
+		DaysSinceOnsetOfSymptoms:   -1,
+	}
+	if diff := cmp.Diff(want, KeyFromExposure(e, "DE")); diff != "" {
+		t.Errorf("KeyFromExposure mismatch (-want +got):\n%s", diff)
+	}
+}
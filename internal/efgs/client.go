@@ -0,0 +1,222 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package efgs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Headers of the gateway protocol.
+	BatchTagHeader       = "batchTag"
+	NextBatchTagHeader   = "nextBatchTag"
+	BatchSignatureHeader = "batchSignature"
+
+	// ContentType is the content type of protobuf uploads and downloads.
+	ContentType = "application/protobuf; version=1.0"
+
+	// noBatchTag is the value of nextBatchTag on the last batch of a day.
+	noBatchTag = "null"
+
+	// dateFormat is the format of dates in gateway paths.
+	dateFormat = "2006-01-02"
+
+	// maxErrorBodySize bounds how much of a failed response is kept.
+	maxErrorBodySize = 1024
+)
+
+// ErrNoBatch is returned by Download when the gateway has no batch for the
+// requested date or tag.
+var ErrNoBatch = errors.New("no batch")
+
+// Client calls the gateway's REST API. The gateway authenticates national
+// backends with mutual TLS, so the HTTP client must present the backend's
+// authentication certificate.
+type Client struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewClient returns a client for the gateway at baseURL.
+func NewClient(baseURL string, client *http.Client) *Client {
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), client: client}
+}
+
+// Upload uploads a signed batch under batchTag, which must be unique for the
+// country. Uploading a tag the gateway already has is not an error, so
+// retries of a batch are safe.
+func (c *Client) Upload(ctx context.Context, batchTag string, batch *DiagnosisKeyBatch, signature []byte) error {
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/diagnosiskeys/upload", bytes.NewReader(batch.Marshal()))
+	if err != nil {
+		return fmt.Errorf("building request: %w", err)
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set(BatchTagHeader, batchTag)
+	req.Header.Set(BatchSignatureHeader, base64.StdEncoding.EncodeToString(signature))
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("uploading batch: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusConflict:
+		return nil
+	case http.StatusMultiStatus:
+		// Some keys were rejected, usually because the gateway already has
+		// them. The others were accepted.
+		return nil
+	default:
+		return statusError(resp)
+	}
+}
+
+// Download returns the batch with batchTag uploaded on date, or the day's
+// first batch if batchTag is empty, along with its tag and the tag of the
+// day's next batch. The next tag is empty after the day's last batch.
+func (c *Client) Download(ctx context.Context, date time.Time, batchTag string) (batch *DiagnosisKeyBatch, tag, next string, err error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/diagnosiskeys/download/"+date.UTC().Format(dateFormat), nil)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("building request: %w", err)
+	}
+	req.Header.Set("Accept", ContentType)
+	if batchTag != "" {
+		req.Header.Set(BatchTagHeader, batchTag)
+	}
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("downloading batch: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return nil, "", "", ErrNoBatch
+	default:
+		return nil, "", "", statusError(resp)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("reading batch: %w", err)
+	}
+	if batch, err = UnmarshalBatch(b); err != nil {
+		return nil, "", "", err
+	}
+	tag = resp.Header.Get(BatchTagHeader)
+	if next = resp.Header.Get(NextBatchTagHeader); next == noBatchTag {
+		next = ""
+	}
+	return batch, tag, next, nil
+}
+
+// AuditEntry describes one upload that contributed to a downloaded batch,
+// with the certificates and signatures needed to check it.
+type AuditEntry struct {
+	Country                             string `json:"country"`
+	Amount                              int    `json:"amount"`
+	UploadedTime                        string `json:"uploadedTime"`
+	UploaderThumbprint                  string `json:"uploaderThumbprint"`
+	UploaderCertificate                 string `json:"uploaderCertificate"`
+	UploaderOperatorSignature           string `json:"uploaderOperatorSignature"`
+	UploaderSigningThumbprint           string `json:"uploaderSigningThumbprint"`
+	SigningCertificate                  string `json:"signingCertificate"`
+	SigningCertificateOperatorSignature string `json:"signingCertificateOperatorSignature"`
+	BatchSignature                      string `json:"batchSignature"`
+}
+
+// Audit returns the uploads that make up the batch with batchTag downloaded
+// on date.
+func (c *Client) Audit(ctx context.Context, date time.Time, batchTag string) ([]*AuditEntry, error) {
+	path := fmt.Sprintf("/diagnosiskeys/audit/download/%s/%s", date.UTC().Format(dateFormat), url.PathEscape(batchTag))
+	var entries []*AuditEntry
+	if err := c.doJSON(ctx, http.MethodGet, path, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Callback is a URL the gateway calls when a new batch is available.
+type Callback struct {
+	CallbackID string `json:"callbackId"`
+	URL        string `json:"url"`
+}
+
+// ListCallbacks returns the callbacks registered for this country.
+func (c *Client) ListCallbacks(ctx context.Context) ([]*Callback, error) {
+	var callbacks []*Callback
+	if err := c.doJSON(ctx, http.MethodGet, "/diagnosiskeys/callback", &callbacks); err != nil {
+		return nil, err
+	}
+	return callbacks, nil
+}
+
+// RegisterCallback registers, or replaces, the callback with the given ID.
+// The gateway calls callbackURL with the batchTag and date query parameters
+// of each new batch.
+func (c *Client) RegisterCallback(ctx context.Context, callbackID, callbackURL string) error {
+	path := fmt.Sprintf("/diagnosiskeys/callback/%s?url=%s", url.PathEscape(callbackID), url.QueryEscape(callbackURL))
+	return c.doJSON(ctx, http.MethodPut, path, nil)
+}
+
+// DeleteCallback removes the callback with the given ID.
+func (c *Client) DeleteCallback(ctx context.Context, callbackID string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/diagnosiskeys/callback/"+url.PathEscape(callbackID), nil)
+}
+
+// doJSON sends a request without a body and decodes a JSON response into
+// out, unless out is nil.
+func (c *Client) doJSON(ctx context.Context, method, path string, out interface{}) error {
+	req, err := http.NewRequest(method, c.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("building request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+func statusError(resp *http.Response) error {
+	b, _ := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxErrorBodySize))
+	return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(b))
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package efgs
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	batch := &DiagnosisKeyBatch{Keys: []*DiagnosisKey{testKey(1, "DE", "FR")}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/diagnosiskeys/upload", func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get(BatchTagHeader), "tag-1"; got != want {
+			t.Errorf("got batch tag %q, want %q", got, want)
+		}
+		if got, want := r.Header.Get(BatchSignatureHeader), base64.StdEncoding.EncodeToString([]byte("sig")); got != want {
+			t.Errorf("got batch signature %q, want %q", got, want)
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading body: %v", err)
+			return
+		}
+		got, err := UnmarshalBatch(b)
+		if err != nil {
+			t.Errorf("UnmarshalBatch: %v", err)
+			return
+		}
+		if diff := cmp.Diff(batch, got); diff != "" {
+			t.Errorf("uploaded batch mismatch (-want +got):\n%s", diff)
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/diagnosiskeys/download/2020-10-01", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get(BatchTagHeader) {
+		case "":
+			w.Header().Set(BatchTagHeader, "b1")
+			w.Header().Set(NextBatchTagHeader, "b2")
+		case "b2":
+			w.Header().Set(BatchTagHeader, "b2")
+			w.Header().Set(NextBatchTagHeader, noBatchTag)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(batch.Marshal())
+	})
+	mux.HandleFunc("/diagnosiskeys/audit/download/2020-10-01/b1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"country":"DE","amount":1,"batchSignature":"c2ln"}]`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewClient(srv.URL+"/", srv.Client())
+	date := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := client.Upload(ctx, "tag-1", batch, []byte("sig")); err != nil {
+		t.Errorf("Upload: %v", err)
+	}
+
+	got, tag, next, err := client.Download(ctx, date, "")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if diff := cmp.Diff(batch, got); diff != "" {
+		t.Errorf("downloaded batch mismatch (-want +got):\n%s", diff)
+	}
+	if tag != "b1" || next != "b2" {
+		t.Errorf("Download: got tags %q, %q, want b1, b2", tag, next)
+	}
+	if _, _, next, err := client.Download(ctx, date, "b2"); err != nil || next != "" {
+		t.Errorf("Download of last batch: got next %q, error %v, want no next", next, err)
+	}
+	if _, _, _, err := client.Download(ctx, date, "b3"); !errors.Is(err, ErrNoBatch) {
+		t.Errorf("Download of unknown batch: got error %v, want %v", err, ErrNoBatch)
+	}
+
+	entries, err := client.Audit(ctx, date, "b1")
+	if err != nil {
+		t.Fatalf("Audit: %v", err)
+	}
+	want := []*AuditEntry{{Country: "DE", Amount: 1, BatchSignature: "c2ln"}}
+	if diff := cmp.Diff(want, entries); diff != "" {
+		t.Errorf("audit mismatch (-want +got):\n%s", diff)
+	}
+}
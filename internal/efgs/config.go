@@ -0,0 +1,97 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package efgs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/observability"
+	"github.com/google/exposure-notifications-server/internal/setup"
+)
+
+// Compile-time check to assert this config matches requirements.
+var _ setup.DBConfigProvider = (*Config)(nil)
+
+// Config is the configuration for downloading keys from the EU federation gateway.
+type Config struct {
+	Database       *database.Config
+	Port           string        `envconfig:"PORT" default:"8080"`
+	Timeout        time.Duration `envconfig:"RPC_TIMEOUT" default:"10m"`
+	TruncateWindow time.Duration `envconfig:"TRUNCATE_WINDOW" default:"1h"`
+
+	// URL is the base URL of the gateway's API.
+	URL string `envconfig:"EFGS_URL"`
+
+	// Country is this backend's country code in the gateway. Keys that
+	// originate from it are not downloaded back.
+	Country string `envconfig:"EFGS_COUNTRY"`
+
+	// QueryID names the FederationInQuery that downloads are recorded under.
+	// Its regions and region map apply to the countries of downloaded keys.
+	QueryID string `envconfig:"EFGS_QUERY_ID" default:"efgs"`
+
+	// TLSSkipVerify, if set to true, causes the server certificate to not be verified.
+	// This is typically used when testing locally with self-signed certificates.
+	TLSSkipVerify bool `envconfig:"TLS_SKIP_VERIFY" default:"false"`
+
+	// TLSCertFile points to an optional cert file that will be appended to the system certificates.
+	TLSCertFile string `envconfig:"TLS_CERT_FILE"`
+
+	// TLSClientCertFile and TLSClientKeyFile hold the backend's authentication
+	// certificate and its key, which the gateway requires.
+	TLSClientCertFile string `envconfig:"TLS_CLIENT_CERT_FILE"`
+	TLSClientKeyFile  string `envconfig:"TLS_CLIENT_KEY_FILE"`
+}
+
+// DB returns the database config.
+func (c *Config) DB() *database.Config {
+	return c.Database
+}
+
+// HTTPClient returns an HTTP client that authenticates to the gateway with
+// the given client certificate. certFile optionally points to certificates
+// that are trusted in addition to the system ones.
+func HTTPClient(certFile, clientCertFile, clientKeyFile string, skipVerify bool) (*http.Client, error) {
+	cp, err := x509.SystemCertPool()
+	if err != nil {
+		return nil, fmt.Errorf("accessing system cert pool: %w", err)
+	}
+	if certFile != "" {
+		b, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading cert file %q: %w", certFile, err)
+		}
+		if !cp.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %q", certFile)
+		}
+	}
+	cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading client certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:            cp,
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: skipVerify,
+	}
+	return &http.Client{Transport: observability.HTTPTransport(&http.Transport{TLSClientConfig: tlsConfig})}, nil
+}
@@ -0,0 +1,289 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package efgs connects this server to the EU federation gateway (EFGS). It
+// downloads the keys other countries upload to the gateway; uploads are made
+// by the federationpush service.
+package efgs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/federationin"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+)
+
+const (
+	dateParam     = "date"
+	batchTagParam = "batchTag"
+)
+
+type (
+	downloadFn            func(ctx context.Context, date time.Time, batchTag string) (*DiagnosisKeyBatch, string, string, error)
+	lastDownloadFn        func(ctx context.Context, date time.Time) (*database.EFGSDownload, error)
+	addDownloadFn         func(ctx context.Context, d *database.EFGSDownload) error
+	insertExposuresFn     func(context.Context, []*database.Exposure) error
+	startFederationSyncFn func(context.Context, *database.FederationInQuery, time.Time) (int64, database.FinalizeSyncFn, error)
+)
+
+type downloadDependencies struct {
+	download            downloadFn
+	lastDownload        lastDownloadFn
+	addDownload         addDownloadFn
+	insertExposures     insertExposuresFn
+	startFederationSync startFederationSyncFn
+}
+
+// NewHandler returns a handler that downloads new batches from the gateway.
+// The date query parameter selects the day to download; without it, the
+// handler downloads yesterday's and today's batches. The gateway's callbacks
+// can be pointed at the handler, since they carry the date of the new batch.
+func NewHandler(env *serverenv.ServerEnv, config *Config) (http.Handler, error) {
+	if env.Database() == nil {
+		return nil, fmt.Errorf("missing database in server environment")
+	}
+	if config.URL == "" {
+		return nil, fmt.Errorf("EFGS_URL is required")
+	}
+	if config.Country == "" {
+		return nil, fmt.Errorf("EFGS_COUNTRY is required")
+	}
+	client, err := HTTPClient(config.TLSCertFile, config.TLSClientCertFile, config.TLSClientKeyFile, config.TLSSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	return &handler{
+		env:    env,
+		db:     env.Database(),
+		config: config,
+		client: NewClient(config.URL, client),
+	}, nil
+}
+
+type handler struct {
+	env    *serverenv.ServerEnv
+	db     *database.DB
+	config *Config
+	client *Client
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.Timeout)
+	defer cancel()
+	logger := logging.FromContext(ctx)
+	metrics := h.env.MetricsExporter(ctx)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	dates := []time.Time{today.AddDate(0, 0, -1), today}
+	if v := r.URL.Query().Get(dateParam); v != "" {
+		date, err := time.Parse(dateFormat, v)
+		if err != nil {
+			metrics.WriteInt("efgs-download-invalid-request", true, 1)
+			msg := fmt.Sprintf("invalid %s %q, want YYYY-MM-DD", dateParam, v)
+			logger.Debug(msg)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		dates = []time.Time{date}
+	}
+	if tag := r.URL.Query().Get(batchTagParam); tag != "" {
+		logger.Infof("Gateway announced batch %s", tag)
+	}
+
+	query, err := h.db.GetFederationInQuery(ctx, h.config.QueryID)
+	if err != nil {
+		logger.Errorf("Failed getting query %q: %v", h.config.QueryID, err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	deps := downloadDependencies{
+		download:            h.client.Download,
+		lastDownload:        h.db.LastEFGSDownload,
+		addDownload:         h.db.AddEFGSDownload,
+		insertExposures:     h.db.BulkInsertExposures,
+		startFederationSync: h.db.StartFederationInSync,
+	}
+	for _, date := range dates {
+		day := date.Format(dateFormat)
+
+		// Batches of a day are downloaded in order, so only one download of a
+		// day may run at a time.
+		lock := "efgs_" + day
+		unlockFn, err := h.db.Lock(ctx, lock, h.config.Timeout)
+		if err != nil {
+			if errors.Is(err, database.ErrAlreadyLocked) {
+				metrics.WriteInt("efgs-download-lock-contention", true, 1)
+				logger.Infof("Lock %s already in use. No work will be performed.", lock)
+				continue
+			}
+			logger.Errorf("Could not acquire lock %s: %v", lock, err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		total, err := download(ctx, metrics, deps, query, h.config, date)
+		unlockFn()
+		recordSync(total, err)
+		if err != nil {
+			metrics.WriteInt("efgs-download-failed", true, 1)
+			logger.Errorf("Downloading batches of %s failed: %v", day, err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		logger.Infof("Downloaded %d keys uploaded on %s", total, day)
+	}
+}
+
+// recordSync counts a finished download in the Prometheus metrics.
+func recordSync(keys int, err error) {
+	metrics.FederationSyncs.WithLabelValues("efgs", metrics.Result(err)).Inc()
+	metrics.FederationKeys.WithLabelValues("efgs").Add(float64(keys))
+}
+
+// download follows the chain of the day's batches from the last one
+// downloaded, and inserts the keys of each new batch. It returns the number of
+// keys inserted. It stops early, without error, when the context is done,
+// leaving the rest for the next run.
+func download(ctx context.Context, metrics metrics.Exporter, deps downloadDependencies, q *database.FederationInQuery, config *Config, date time.Time) (int, error) {
+	logger := logging.FromContext(ctx)
+
+	// Resume after the last batch downloaded. If it was the day's last batch
+	// at the time, download it again to learn whether more were uploaded
+	// since, without inserting its keys twice.
+	tag, seen := "", false
+	last, err := deps.lastDownload(ctx, date)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+	case err != nil:
+		return 0, fmt.Errorf("reading last download: %w", err)
+	case last.NextBatchTag != "":
+		tag = last.NextBatchTag
+	default:
+		tag, seen = last.BatchTag, true
+	}
+
+	total := 0
+	for {
+		if ctx.Err() != nil {
+			logger.Infof("Gateway download timed out before fetching all batches.")
+			return total, nil
+		}
+
+		batch, batchTag, next, err := deps.download(ctx, date, tag)
+		if err != nil {
+			if errors.Is(err, ErrNoBatch) {
+				return total, nil
+			}
+			return total, fmt.Errorf("downloading batch %q: %w", tag, err)
+		}
+
+		record := &database.EFGSDownload{
+			BatchTag:     batchTag,
+			BatchDate:    date,
+			NextBatchTag: next,
+			DownloadedAt: time.Now(),
+		}
+		if !seen {
+			n, syncID, err := ingest(ctx, metrics, deps, q, config, batch)
+			if err != nil {
+				return total, fmt.Errorf("inserting batch %s: %w", batchTag, err)
+			}
+			record.SyncID = syncID
+			record.Keys = n
+			total += n
+		}
+		if err := deps.addDownload(ctx, record); err != nil {
+			return total, fmt.Errorf("recording batch %s: %w", batchTag, err)
+		}
+
+		if next == "" {
+			return total, nil
+		}
+		tag, seen = next, false
+	}
+}
+
+// ingest inserts the keys of a batch under a new federation sync of the
+// query, and returns their number and the sync ID. Keys this country
+// uploaded, revoked keys and keys with no allowed country are dropped.
+func ingest(ctx context.Context, metrics metrics.Exporter, deps downloadDependencies, q *database.FederationInQuery, config *Config, batch *DiagnosisKeyBatch) (int, int64, error) {
+	logger := logging.FromContext(ctx)
+
+	batchStart := time.Now()
+	syncID, finalizeFn, err := deps.startFederationSync(ctx, q, batchStart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("starting federation sync for query %s: %w", q.QueryID, err)
+	}
+
+	createdAt := database.TruncateWindow(batchStart, config.TruncateWindow)
+	total := 0
+	var exposures []*database.Exposure
+	flush := func() error {
+		if len(exposures) == 0 {
+			return nil
+		}
+		if err := deps.insertExposures(ctx, exposures); err != nil {
+			return fmt.Errorf("inserting %d exposures: %w", len(exposures), err)
+		}
+		metrics.WriteInt("efgs-download-keys", false, len(exposures))
+		total += len(exposures)
+		exposures = nil
+		return nil
+	}
+
+	for _, k := range batch.Keys {
+		if k.Origin == config.Country {
+			continue
+		}
+		e := k.Exposure()
+		if e == nil {
+			logger.Debugf("Dropping invalid or revoked key from %s", k.Origin)
+			continue
+		}
+		if !database.FederationReportAllowed(q.ReportTypes, q.MinTransmissionRisk, e.ReportType, e.TransmissionRisk) {
+			metrics.WriteInt("efgs-download-filtered-reports", false, 1)
+			continue
+		}
+		if e.Regions = federationin.IngestRegions(e.Regions, q); len(e.Regions) == 0 {
+			metrics.WriteInt("efgs-download-disallowed-regions", true, 1)
+			continue
+		}
+		e.FederationSyncID = syncID
+		e.CreatedAt = createdAt
+		exposures = append(exposures, e)
+
+		if len(exposures) == database.InsertExposuresBatchSize {
+			if err := flush(); err != nil {
+				return total, syncID, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return total, syncID, err
+	}
+
+	if err := finalizeFn(batchStart, total); err != nil {
+		return total, syncID, fmt.Errorf("finalizing federation sync for query %s: %w", q.QueryID, err)
+	}
+	return total, syncID, nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package efgs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// gateway mocks the gateway's chain of batches for a day. The first batch has
+// tag "b1".
+type gateway struct {
+	batches map[string]*DiagnosisKeyBatch
+	next    map[string]string
+}
+
+func (g *gateway) download(ctx context.Context, date time.Time, tag string) (*DiagnosisKeyBatch, string, string, error) {
+	if tag == "" {
+		tag = "b1"
+	}
+	batch, ok := g.batches[tag]
+	if !ok {
+		return nil, "", "", ErrNoBatch
+	}
+	return batch, tag, g.next[tag], nil
+}
+
+func testKey(b byte, origin string, visited ...string) *DiagnosisKey {
+	return &DiagnosisKey{
+		KeyData:                    []byte{b},
+		RollingStartIntervalNumber: uint32(b),
+		RollingPeriod:              144,
+		TransmissionRiskLevel:      1,
+		Origin:                     origin,
+		VisitedCountries:           visited,
+		ReportType:                 ReportTypeConfirmedTest,
+	}
+}
+
+func TestDownload(t *testing.T) {
+	selfReport := testKey(5, "IT")
+	selfReport.ReportType = ReportTypeSelfReport
+	g := &gateway{
+		batches: map[string]*DiagnosisKeyBatch{
+			"b1": {Keys: []*DiagnosisKey{testKey(1, "DE"), testKey(2, "NL", "EL"), testKey(3, "US")}},
+			"b2": {Keys: []*DiagnosisKey{testKey(4, "XX"), selfReport}},
+			"b3": {Keys: []*DiagnosisKey{testKey(6, "FR")}},
+		},
+		next: map[string]string{"b1": "b2", "b2": "b3"},
+	}
+	query := &database.FederationInQuery{
+		QueryID:        "efgs",
+		ExcludeRegions: []string{"XX"},
+		RegionMap:      map[string]string{"EL": "GR"},
+	}
+	date := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name        string
+		last        *database.EFGSDownload
+		reportTypes []string
+		wantKeys    [][]string
+		wantRecords []string
+		wantTotal   int
+	}{
+		{
+			name:        "whole day",
+			wantKeys:    [][]string{{"DE"}, {"GR", "NL"}, {"IT"}, {"FR"}},
+			wantRecords: []string{"b1:b2:2", "b2:b3:1", "b3::1"},
+			wantTotal:   4,
+		},
+		{
+			name:        "resumes at next batch",
+			last:        &database.EFGSDownload{BatchTag: "b1", NextBatchTag: "b2"},
+			wantKeys:    [][]string{{"IT"}, {"FR"}},
+			wantRecords: []string{"b2:b3:1", "b3::1"},
+			wantTotal:   2,
+		},
+		{
+			name:        "rechecks last batch of the day",
+			last:        &database.EFGSDownload{BatchTag: "b2"},
+			wantKeys:    [][]string{{"FR"}},
+			wantRecords: []string{"b2:b3:0", "b3::1"},
+			wantTotal:   1,
+		},
+		{
+			name:        "filters report types",
+			reportTypes: []string{database.ReportTypeConfirmed},
+			wantKeys:    [][]string{{"DE"}, {"GR", "NL"}, {"FR"}},
+			wantRecords: []string{"b1:b2:2", "b2:b3:0", "b3::1"},
+			wantTotal:   3,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			var (
+				gotKeys    [][]string
+				gotRecords []string
+			)
+			deps := downloadDependencies{
+				download: g.download,
+				lastDownload: func(ctx context.Context, date time.Time) (*database.EFGSDownload, error) {
+					if tc.last == nil {
+						return nil, database.ErrNotFound
+					}
+					return tc.last, nil
+				},
+				addDownload: func(ctx context.Context, d *database.EFGSDownload) error {
+					if d.Keys > 0 && d.SyncID != 7 {
+						t.Errorf("batch %s: got sync ID %d, want 7", d.BatchTag, d.SyncID)
+					}
+					gotRecords = append(gotRecords, fmt.Sprintf("%s:%s:%d", d.BatchTag, d.NextBatchTag, d.Keys))
+					return nil
+				},
+				insertExposures: func(ctx context.Context, exposures []*database.Exposure) error {
+					for _, e := range exposures {
+						if e.LocalProvenance || e.FederationSyncID != 7 {
+							t.Errorf("got exposure with local provenance %t and sync ID %d", e.LocalProvenance, e.FederationSyncID)
+						}
+						gotKeys = append(gotKeys, e.Regions)
+					}
+					return nil
+				},
+				startFederationSync: func(ctx context.Context, q *database.FederationInQuery, started time.Time) (int64, database.FinalizeSyncFn, error) {
+					return 7, func(time.Time, int) error { return nil }, nil
+				},
+			}
+			config := &Config{Country: "US", TruncateWindow: time.Hour}
+			q := *query
+			q.ReportTypes = tc.reportTypes
+
+			total, err := download(ctx, metrics.NewLogsBasedFromContext(ctx), deps, &q, config, date)
+			if err != nil {
+				t.Fatalf("download: %v", err)
+			}
+			if total != tc.wantTotal {
+				t.Errorf("download: got total %d, want %d", total, tc.wantTotal)
+			}
+			if diff := cmp.Diff(tc.wantKeys, gotKeys); diff != "" {
+				t.Errorf("keys mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantRecords, gotRecords); diff != "" {
+				t.Errorf("records mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
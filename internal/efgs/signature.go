@@ -0,0 +1,288 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package efgs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA256WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidECDSAWithSHA  = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// CMS structures, see RFC 5652. Only what a detached signature with a single
+// signer needs is modelled.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// BatchSigner signs upload batches with the national backend's signing
+// certificate.
+type BatchSigner struct {
+	cert   *x509.Certificate
+	signer crypto.Signer
+
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// NewBatchSigner returns a BatchSigner for the signing certificate cert,
+// whose private key is held by signer.
+func NewBatchSigner(cert *x509.Certificate, signer crypto.Signer) (*BatchSigner, error) {
+	switch signer.Public().(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", signer.Public())
+	}
+	return &BatchSigner{cert: cert, signer: signer, now: time.Now}, nil
+}
+
+// Sign returns the batch signature, a detached CMS SignedData over the
+// batch's signature data. Uploads carry it base64 encoded in the
+// batchSignature header.
+func (s *BatchSigner) Sign(batch *DiagnosisKeyBatch) ([]byte, error) {
+	digest := sha256.Sum256(signatureData(batch))
+	attrs, err := signedAttributes(digest[:], s.now())
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature covers the DER encoding of the attributes as a SET.
+	signed := sha256.Sum256(encodeSet(attrs))
+	sig, err := s.signer.Sign(rand.Reader, signed[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("signing batch: %w", err)
+	}
+
+	sigAlg := oidSHA256WithRSA
+	if _, ok := s.signer.Public().(*ecdsa.PublicKey); ok {
+		sigAlg = oidECDSAWithSHA
+	}
+
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: encapContentInfo{EContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: s.cert.Raw},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			SID: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: s.cert.RawIssuer},
+				SerialNumber: s.cert.SerialNumber,
+			},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: sigAlg},
+			Signature:          sig,
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding signed data: %w", err)
+	}
+	out, err := asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding content info: %w", err)
+	}
+	return out, nil
+}
+
+// signedAttributes returns the content of the signed attributes SET: the
+// content type, message digest and signing time, in DER order.
+func signedAttributes(digest []byte, signingTime time.Time) ([]byte, error) {
+	values := []struct {
+		typ asn1.ObjectIdentifier
+		val interface{}
+	}{
+		{oidContentType, oidData},
+		{oidMessageDigest, digest},
+		{oidSigningTime, signingTime.UTC()},
+	}
+
+	var encoded [][]byte
+	for _, v := range values {
+		val, err := asn1.Marshal(v.val)
+		if err != nil {
+			return nil, fmt.Errorf("encoding attribute %v: %w", v.typ, err)
+		}
+		attr, err := asn1.Marshal(attribute{Type: v.typ, Values: []asn1.RawValue{{FullBytes: val}}})
+		if err != nil {
+			return nil, fmt.Errorf("encoding attribute %v: %w", v.typ, err)
+		}
+		encoded = append(encoded, attr)
+	}
+	// DER sorts the elements of a SET OF by their encoding.
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	return bytes.Join(encoded, nil), nil
+}
+
+// encodeSet returns the DER encoding of a SET with the given content.
+func encodeSet(content []byte) []byte {
+	b, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: content})
+	return b
+}
+
+// VerifyBatchSignature checks that sig is a valid batch signature of batch,
+// made with the key of the signing certificate cert. The signer's certificate
+// embedded in sig is not trusted; it must match cert.
+func VerifyBatchSignature(batch *DiagnosisKeyBatch, sig []byte, cert *x509.Certificate) error {
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(sig, &ci); err != nil || len(rest) > 0 {
+		return fmt.Errorf("invalid content info: %v", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return fmt.Errorf("content type %v is not signed data", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return fmt.Errorf("invalid signed data: %w", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return fmt.Errorf("got %d signers, want 1", len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+	if !bytes.Equal(si.SID.Issuer.FullBytes, cert.RawIssuer) || si.SID.SerialNumber == nil || si.SID.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		return fmt.Errorf("batch was not signed by the given certificate")
+	}
+	if !si.DigestAlgorithm.Algorithm.Equal(oidSHA256) {
+		return fmt.Errorf("unsupported digest algorithm %v", si.DigestAlgorithm.Algorithm)
+	}
+	if si.SignedAttrs.Class != asn1.ClassContextSpecific || si.SignedAttrs.Tag != 0 || len(si.SignedAttrs.FullBytes) == 0 {
+		return fmt.Errorf("signature has no signed attributes")
+	}
+
+	// The signed attributes must carry the digest of the batch.
+	var digest []byte
+	attrs := si.SignedAttrs.Bytes
+	for len(attrs) > 0 {
+		var attr attribute
+		rest, err := asn1.Unmarshal(attrs, &attr)
+		if err != nil {
+			return fmt.Errorf("invalid signed attribute: %w", err)
+		}
+		attrs = rest
+		if attr.Type.Equal(oidMessageDigest) && len(attr.Values) == 1 {
+			if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &digest); err != nil {
+				return fmt.Errorf("invalid message digest: %w", err)
+			}
+		}
+	}
+	want := sha256.Sum256(signatureData(batch))
+	if !bytes.Equal(digest, want[:]) {
+		return fmt.Errorf("signature does not match the batch")
+	}
+
+	var alg x509.SignatureAlgorithm
+	switch {
+	case si.SignatureAlgorithm.Algorithm.Equal(oidECDSAWithSHA):
+		alg = x509.ECDSAWithSHA256
+	case si.SignatureAlgorithm.Algorithm.Equal(oidSHA256WithRSA), si.SignatureAlgorithm.Algorithm.Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}):
+		alg = x509.SHA256WithRSA
+	default:
+		return fmt.Errorf("unsupported signature algorithm %v", si.SignatureAlgorithm.Algorithm)
+	}
+	if err := cert.CheckSignature(alg, encodeSet(si.SignedAttrs.Bytes), si.Signature); err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	return nil
+}
+
+// signatureData returns the bytes a batch signature covers, in the gateway's
+// format. Each field of a key is base64 encoded and followed by a '.':
+// integers as 4 big-endian bytes, and visited countries joined by commas. The
+// keys' strings are then sorted and concatenated, so the signature doesn't
+// depend on the order of the keys.
+func signatureData(batch *DiagnosisKeyBatch) []byte {
+	records := make([]string, 0, len(batch.Keys))
+	for _, k := range batch.Keys {
+		var sb strings.Builder
+		field := func(b []byte) {
+			sb.WriteString(base64.StdEncoding.EncodeToString(b))
+			sb.WriteByte('.')
+		}
+		integer := func(v uint32) {
+			var b [4]byte
+			binary.BigEndian.PutUint32(b[:], v)
+			field(b[:])
+		}
+		field(k.KeyData)
+		integer(k.RollingStartIntervalNumber)
+		integer(k.RollingPeriod)
+		integer(uint32(k.TransmissionRiskLevel))
+		field([]byte(strings.Join(k.VisitedCountries, ",")))
+		field([]byte(k.Origin))
+		integer(uint32(k.ReportType))
+		integer(uint32(k.DaysSinceOnsetOfSymptoms))
+		records = append(records, sb.String())
+	}
+	sort.Strings(records)
+	return []byte(strings.Join(records, ""))
+}
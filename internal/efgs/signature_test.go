@@ -0,0 +1,93 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package efgs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newSigningCert(t *testing.T, serial int64) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "signing", Country: []string{"DE"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestBatchSignature(t *testing.T) {
+	cert, key := newSigningCert(t, 1)
+	otherCert, _ := newSigningCert(t, 2)
+
+	signer, err := NewBatchSigner(cert, key)
+	if err != nil {
+		t.Fatalf("NewBatchSigner: %v", err)
+	}
+
+	k1 := &DiagnosisKey{KeyData: []byte("key-1"), RollingStartIntervalNumber: 100, RollingPeriod: 144, TransmissionRiskLevel: 2, Origin: "DE", ReportType: ReportTypeConfirmedTest}
+	k2 := &DiagnosisKey{KeyData: []byte("key-2"), RollingStartIntervalNumber: 244, RollingPeriod: 144, TransmissionRiskLevel: 3, VisitedCountries: []string{"FR", "IT"}, Origin: "DE", ReportType: ReportTypeConfirmedTest}
+	batch := &DiagnosisKeyBatch{Keys: []*DiagnosisKey{k1, k2}}
+
+	sig, err := signer.Sign(batch)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	if err := VerifyBatchSignature(batch, sig, cert); err != nil {
+		t.Errorf("VerifyBatchSignature: %v", err)
+	}
+
+	// The order of keys doesn't matter.
+	reordered := &DiagnosisKeyBatch{Keys: []*DiagnosisKey{k2, k1}}
+	if err := VerifyBatchSignature(reordered, sig, cert); err != nil {
+		t.Errorf("VerifyBatchSignature of reordered batch: %v", err)
+	}
+
+	tampered := *k2
+	tampered.TransmissionRiskLevel = 8
+	if err := VerifyBatchSignature(&DiagnosisKeyBatch{Keys: []*DiagnosisKey{k1, &tampered}}, sig, cert); err == nil {
+		t.Errorf("VerifyBatchSignature of tampered batch: expected error")
+	}
+
+	if err := VerifyBatchSignature(batch, sig, otherCert); err == nil {
+		t.Errorf("VerifyBatchSignature with other certificate: expected error")
+	}
+
+	if err := VerifyBatchSignature(batch, []byte("not a signature"), cert); err == nil {
+		t.Errorf("VerifyBatchSignature of garbage: expected error")
+	}
+}
@@ -29,6 +29,9 @@
 // secret value is written to SECRETS_DIR and the environment variable is
 // updated to be the local path to that file.
 //
+// The references of secrets that were resolved to their value are kept, see
+// SecretRef, so that servers can follow rotations of those secrets.
+//
 // This can be used with any secret manager that implements the
 // 'secrets.SecretManager' interface.
 package envconfig
@@ -41,6 +44,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/google/exposure-notifications-server/internal/logging"
 	"github.com/google/exposure-notifications-server/internal/secrets"
@@ -57,6 +61,24 @@ const (
 	FileSuffix = "?target=file"
 )
 
+var (
+	// secretRefs maps the names of environment variables to the secret
+	// references they were resolved from.
+	secretRefs      = make(map[string]string)
+	secretRefsMutex sync.Mutex
+)
+
+// SecretRef returns the secret reference, without the "secret://" prefix, that
+// the environment variable name was resolved from by Process. Variables that
+// weren't secret references, or whose secret was written to a file, return
+// false.
+func SecretRef(name string) (string, bool) {
+	secretRefsMutex.Lock()
+	defer secretRefsMutex.Unlock()
+	ref, ok := secretRefs[name]
+	return ref, ok
+}
+
 // BaseConfig is the default base configuration.
 type BaseConfig struct {
 	// SecretsDir is the base directory where secrets are stored.
@@ -137,6 +159,10 @@ func resolveSecrets(ctx context.Context, sm secrets.SecretManager, dir string) e
 
 				logger.Infof("wrote secret file for %v", envName)
 				secretVal = secretFilePath
+			} else {
+				secretRefsMutex.Lock()
+				secretRefs[envName] = secretRef
+				secretRefsMutex.Unlock()
 			}
 
 			// Replace the value of the environment variable with the either the resolved secret value
@@ -148,6 +148,12 @@ func TestResolveSecretEnv(t *testing.T) {
 		} else if c.want != env.Food {
 			t.Errorf("%v process want '%v' got '%v'", c.name, c.want, env.Food)
 		}
+
+		if c.wantError == "" && c.secretPath != "" {
+			if ref, ok := SecretRef(testEnvVar); !ok || ref != c.secretPath {
+				t.Errorf("%v SecretRef want '%v' got '%v' (%t)", c.name, c.secretPath, ref, ok)
+			}
+		}
 	}
 }
 
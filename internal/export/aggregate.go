@@ -0,0 +1,252 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+)
+
+// Formats of aggregate stats dumps.
+const (
+	aggregateFormatCSV   = "csv"
+	aggregateFormatJSONL = "jsonl"
+)
+
+// dateLayout is the layout of the dates of aggregate stats requests.
+const dateLayout = "2006-01-02"
+
+// aggregateColumns are the columns of CSV dumps, in the order of
+// aggregateRow.
+var aggregateColumns = []string{
+	"day", "uploads", "keys",
+	"export_batches", "exported_keys", "export_bytes",
+	"federation_in_syncs", "federation_in_keys",
+	"federation_out_requests", "federation_out_keys",
+}
+
+// AggregateStatsRequest selects the UTC days [From, Thru) to dump, and the
+// format of the dump.
+type AggregateStatsRequest struct {
+	From   time.Time
+	Thru   time.Time
+	Format string
+}
+
+// AggregateStatsResponse names the objects written by AggregateStatsHandler.
+type AggregateStatsResponse struct {
+	Bucket    string `json:"bucket"`
+	Object    string `json:"object"`
+	Signature string `json:"signature"`
+	Days      int    `json:"days"`
+}
+
+// AggregateStatsHandler writes a dump of the daily aggregate stats of a date
+// range to the blobstore, for compliance reporting: uploads and keys
+// published, export batches, and federation volumes. The dump holds counts
+// only, never keys or other data of individual uploads. The days are selected
+// with the from and thru query parameters, dates such as 2020-09-01 that
+// default to yesterday and today, and the format parameter selects csv, the
+// default, or jsonl.
+//
+// The dump is written to AGGREGATE_STATS_BUCKET, along with a detached
+// signature of it by AGGREGATE_STATS_SIGNING_KEY: an ASN.1 ECDSA signature of
+// its SHA-256 digest, in an object of the same name ending in ".sig".
+func (s *Server) AggregateStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.config.AggregateStatsBucket == "" || s.config.AggregateStatsSigningKey == "" {
+		http.Error(w, "aggregate stats are not configured", http.StatusNotFound)
+		return
+	}
+
+	req, err := parseAggregateStatsRequest(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Each residency database holds the stats of the exposures it stores.
+	var stats []*database.DailyStats
+	for _, db := range s.db.Residencies() {
+		dbStats, err := db.ListDailyStats(ctx, req.From, req.Thru)
+		if err != nil {
+			logger.Errorf("Failed to list daily stats of the %v database: %v", db.ResidencyName(), err)
+			http.Error(w, "Failed to list stats, check logs.", http.StatusInternalServerError)
+			return
+		}
+		stats = addDailyStats(stats, dbStats)
+	}
+
+	var buf bytes.Buffer
+	if err := writeAggregateStats(&buf, req.Format, stats); err != nil {
+		logger.Errorf("Failed to write aggregate stats: %v", err)
+		http.Error(w, "Failed to write stats, check logs.", http.StatusInternalServerError)
+		return
+	}
+	data := buf.Bytes()
+
+	signer, err := s.env.GetSignerForKey(ctx, s.config.AggregateStatsSigningKey)
+	if err != nil {
+		logger.Errorf("Failed to get signer for aggregate stats: %v", err)
+		http.Error(w, "Failed to sign stats, check logs.", http.StatusInternalServerError)
+		return
+	}
+	sig, err := generateSignature(data, signer)
+	if err != nil {
+		logger.Errorf("Failed to sign aggregate stats: %v", err)
+		http.Error(w, "Failed to sign stats, check logs.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := &AggregateStatsResponse{
+		Bucket: s.config.AggregateStatsBucket,
+		Object: aggregateStatsObjectName(req),
+		Days:   len(stats),
+	}
+	resp.Signature = resp.Object + ".sig"
+	blobstore := s.env.Blobstore()
+	if err := blobstore.CreateObject(ctx, resp.Bucket, resp.Object, data, false); err != nil {
+		logger.Errorf("Failed to write aggregate stats to %s/%s: %v", resp.Bucket, resp.Object, err)
+		http.Error(w, "Failed to write stats, check logs.", http.StatusInternalServerError)
+		return
+	}
+	if err := blobstore.CreateObject(ctx, resp.Bucket, resp.Signature, sig, false); err != nil {
+		logger.Errorf("Failed to write aggregate stats signature to %s/%s: %v", resp.Bucket, resp.Signature, err)
+		http.Error(w, "Failed to write stats, check logs.", http.StatusInternalServerError)
+		return
+	}
+	logger.Infof("Wrote aggregate stats of %d days to %s/%s", resp.Days, resp.Bucket, resp.Object)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf("Failed to write response: %v", err)
+	}
+}
+
+// parseAggregateStatsRequest parses the query parameters of an aggregate stats
+// request, defaulting to the day before now's.
+func parseAggregateStatsRequest(q url.Values, now time.Time) (*AggregateStatsRequest, error) {
+	req := &AggregateStatsRequest{
+		Thru:   now.UTC().Truncate(24 * time.Hour),
+		Format: aggregateFormatCSV,
+	}
+	if v := q.Get("thru"); v != "" {
+		t, err := time.Parse(dateLayout, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid thru %q, want a date such as 2020-09-01", v)
+		}
+		req.Thru = t
+	}
+	req.From = req.Thru.AddDate(0, 0, -1)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(dateLayout, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from %q, want a date such as 2020-09-01", v)
+		}
+		req.From = t
+	}
+	if !req.From.Before(req.Thru) {
+		return nil, fmt.Errorf("from must be before thru")
+	}
+	if v := q.Get("format"); v != "" {
+		if v != aggregateFormatCSV && v != aggregateFormatJSONL {
+			return nil, fmt.Errorf("invalid format %q, want %s or %s", v, aggregateFormatCSV, aggregateFormatJSONL)
+		}
+		req.Format = v
+	}
+	return req, nil
+}
+
+// aggregateStatsObjectName returns the name of the dump of req, such as
+// aggregate-stats/2020-09-01_2020-09-08.csv for the week from September 1st.
+func aggregateStatsObjectName(req *AggregateStatsRequest) string {
+	return path.Join("aggregate-stats", req.From.Format(dateLayout)+"_"+req.Thru.Format(dateLayout)+"."+req.Format)
+}
+
+// addDailyStats adds the counts of b to those of a, which list the stats of
+// the same days, and returns a. If a is empty, it returns b.
+func addDailyStats(a, b []*database.DailyStats) []*database.DailyStats {
+	if len(a) == 0 {
+		return b
+	}
+	for i, s := range b {
+		a[i].Uploads += s.Uploads
+		a[i].Keys += s.Keys
+		a[i].ExportBatches += s.ExportBatches
+		a[i].ExportedKeys += s.ExportedKeys
+		a[i].ExportBytes += s.ExportBytes
+		a[i].FederationInSyncs += s.FederationInSyncs
+		a[i].FederationInKeys += s.FederationInKeys
+		a[i].FederationOutRequests += s.FederationOutRequests
+		a[i].FederationOutKeys += s.FederationOutKeys
+	}
+	return a
+}
+
+// writeAggregateStats writes stats to w in format, one line per day.
+func writeAggregateStats(w io.Writer, format string, stats []*database.DailyStats) error {
+	if format == aggregateFormatJSONL {
+		enc := json.NewEncoder(w)
+		for _, s := range stats {
+			if err := enc.Encode(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(aggregateColumns); err != nil {
+		return err
+	}
+	for _, s := range stats {
+		if err := cw.Write(aggregateRow(s)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// aggregateRow returns the CSV fields of s, in the order of aggregateColumns.
+func aggregateRow(s *database.DailyStats) []string {
+	row := []string{s.Day.Format(dateLayout)}
+	for _, n := range []int64{
+		s.Uploads, s.Keys,
+		s.ExportBatches, s.ExportedKeys, s.ExportBytes,
+		s.FederationInSyncs, s.FederationInKeys,
+		s.FederationOutRequests, s.FederationOutKeys,
+	} {
+		row = append(row, strconv.FormatInt(n, 10))
+	}
+	return row
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseAggregateStatsRequest(t *testing.T) {
+	now := time.Date(2020, 9, 8, 13, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2020, 9, d, 0, 0, 0, 0, time.UTC) }
+
+	cases := []struct {
+		name    string
+		query   string
+		want    *AggregateStatsRequest
+		wantErr bool
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			want:  &AggregateStatsRequest{From: day(7), Thru: day(8), Format: aggregateFormatCSV},
+		},
+		{
+			name:  "range",
+			query: "from=2020-09-01&thru=2020-09-08&format=jsonl",
+			want:  &AggregateStatsRequest{From: day(1), Thru: day(8), Format: aggregateFormatJSONL},
+		},
+		{name: "invalid from", query: "from=2020-09-01T00:00:00Z", wantErr: true},
+		{name: "invalid thru", query: "thru=yesterday", wantErr: true},
+		{name: "empty range", query: "from=2020-09-08&thru=2020-09-08", wantErr: true},
+		{name: "invalid format", query: "format=xml", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			q, err := url.ParseQuery(c.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseAggregateStatsRequest(q, now)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, c.wantErr)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	req := &AggregateStatsRequest{From: day(1), Thru: day(8), Format: aggregateFormatCSV}
+	if got, want := aggregateStatsObjectName(req), "aggregate-stats/2020-09-01_2020-09-08.csv"; got != want {
+		t.Errorf("object name: got %q, want %q", got, want)
+	}
+}
+
+func TestWriteAggregateStats(t *testing.T) {
+	stats := []*database.DailyStats{
+		{Day: time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC), Uploads: 2, Keys: 28, ExportBatches: 1, ExportedKeys: 28, ExportBytes: 1024},
+		{Day: time.Date(2020, 9, 2, 0, 0, 0, 0, time.UTC), FederationInSyncs: 3, FederationInKeys: 50, FederationOutRequests: 4, FederationOutKeys: 80},
+	}
+
+	cases := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: aggregateFormatCSV,
+			want: "day,uploads,keys,export_batches,exported_keys,export_bytes,federation_in_syncs,federation_in_keys,federation_out_requests,federation_out_keys\n" +
+				"2020-09-01,2,28,1,28,1024,0,0,0,0\n" +
+				"2020-09-02,0,0,0,0,0,3,50,4,80\n",
+		},
+		{
+			format: aggregateFormatJSONL,
+			want: `{"day":"2020-09-01T00:00:00Z","uploads":2,"keys":28,"exportBatches":1,"exportedKeys":28,"exportBytes":1024,"federationInSyncs":0,"federationInKeys":0,"federationOutRequests":0,"federationOutKeys":0}` + "\n" +
+				`{"day":"2020-09-02T00:00:00Z","uploads":0,"keys":0,"exportBatches":0,"exportedKeys":0,"exportBytes":0,"federationInSyncs":3,"federationInKeys":50,"federationOutRequests":4,"federationOutKeys":80}` + "\n",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeAggregateStats(&buf, c.format, stats); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.want, buf.String()); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -26,31 +26,24 @@ import (
 	"github.com/google/exposure-notifications-server/internal/logging"
 )
 
-// CreateBatchesHandler is a handler to iterate the rows of ExportConfig and
-// create entries in ExportBatchJob as appropriate.
+// CreateBatchesHandler is a handler that runs CreateBatches.
 func (s *Server) CreateBatchesHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), s.config.CreateTimeout)
+	if err := s.CreateBatches(r.Context()); err != nil {
+		http.Error(w, "Failed to create batches, check logs.", http.StatusInternalServerError)
+	}
+}
+
+// CreateBatches iterates the rows of ExportConfig and creates entries in
+// ExportBatchJob as appropriate. Each config is locked while its batches are
+// created, so that several batchers can run at once without creating the same
+// batches twice. Running out of time isn't an error, as batch creation
+// continues on the next run.
+func (s *Server) CreateBatches(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.CreateTimeout)
 	defer cancel()
 	logger := logging.FromContext(ctx)
 	metrics := s.env.MetricsExporter(ctx)
 
-	// Obtain lock to make sure there are no other processes working to create batches.
-	lock := "create_batches"
-	unlockFn, err := s.db.Lock(ctx, lock, s.config.CreateTimeout)
-	if err != nil {
-		if errors.Is(err, database.ErrAlreadyLocked) {
-			metrics.WriteInt("export-batcher-lock-contention", true, 1)
-			msg := fmt.Sprintf("Lock %s already in use, no work will be performed", lock)
-			logger.Infof(msg)
-			w.Write([]byte(msg)) // We return status 200 here so that Cloud Scheduler does not retry.
-			return
-		}
-		logger.Errorf("Could not acquire lock %s: %v", lock, err)
-		http.Error(w, fmt.Sprintf("Could not acquire lock %s, check logs.", lock), http.StatusInternalServerError)
-		return
-	}
-	defer unlockFn()
-
 	totalConfigs := 0
 	totalBatches := 0
 	totalConfigsWithBatches := 0
@@ -59,9 +52,9 @@ func (s *Server) CreateBatchesHandler(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	effectiveTime := time.Now().Add(-1 * s.config.MinWindowAge)
-	err = s.db.IterateExportConfigs(ctx, effectiveTime, func(ec *database.ExportConfig) error {
+	err := s.db.IterateExportConfigs(ctx, effectiveTime, func(ec *database.ExportConfig) error {
 		totalConfigs++
-		if batchesCreated, err := s.maybeCreateBatches(ctx, ec, effectiveTime); err != nil {
+		if batchesCreated, err := s.maybeCreateBatchesLocked(ctx, ec, effectiveTime); err != nil {
 			logger.Errorf("Failed to create batches for config %d: %v, continuing to next config", ec.ConfigID, err)
 		} else {
 			totalBatches += batchesCreated
@@ -77,17 +70,44 @@ func (s *Server) CreateBatchesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	switch {
 	case err == nil:
-		return
+		return nil
 	case errors.Is(err, context.DeadlineExceeded):
 		logger.Infof("Timed out creating batches, batch creation will continue on next invocation")
+		return nil
 	case errors.Is(err, context.Canceled):
 		logger.Infof("Canceled while creating batches, batch creation will continue on next invocation")
+		return nil
 	default:
 		logger.Errorf("creating batches: %v", err)
-		http.Error(w, "Failed to create batches, check logs.", http.StatusInternalServerError)
+		return err
 	}
 }
 
+// maybeCreateBatchesLocked creates the batches of the config while holding
+// the config's lock. If another batcher holds the lock, it does nothing.
+func (s *Server) maybeCreateBatchesLocked(ctx context.Context, ec *database.ExportConfig, now time.Time) (int, error) {
+	logger := logging.FromContext(ctx)
+	metrics := s.env.MetricsExporter(ctx)
+
+	lock := fmt.Sprintf("create_batches_%d", ec.ConfigID)
+	unlockFn, err := s.db.Lock(ctx, lock, s.config.CreateTimeout)
+	if err != nil {
+		if errors.Is(err, database.ErrAlreadyLocked) {
+			metrics.WriteInt("export-batcher-lock-contention", true, 1)
+			logger.Infof("Lock %s already in use, skipping config %d", lock, ec.ConfigID)
+			return 0, nil
+		}
+		return 0, fmt.Errorf("acquiring lock %s: %w", lock, err)
+	}
+	defer func() {
+		if err := unlockFn(); err != nil {
+			logger.Errorf("Releasing lock %s: %v", lock, err)
+		}
+	}()
+
+	return s.maybeCreateBatches(ctx, ec, now)
+}
+
 func (s *Server) maybeCreateBatches(ctx context.Context, ec *database.ExportConfig, now time.Time) (int, error) {
 	logger := logging.FromContext(ctx)
 	metrics := s.env.MetricsExporter(ctx)
@@ -109,18 +129,30 @@ func (s *Server) maybeCreateBatches(ctx context.Context, ec *database.ExportConf
 		infoIds := make([]int64, len(ec.SignatureInfoIDs))
 		copy(infoIds, ec.SignatureInfoIDs)
 		batches = append(batches, &database.ExportBatch{
-			ConfigID:         ec.ConfigID,
-			BucketName:       ec.BucketName,
-			FilenameRoot:     ec.FilenameRoot,
-			StartTimestamp:   br.start,
-			EndTimestamp:     br.end,
-			Region:           ec.Region,
-			Status:           database.ExportBatchOpen,
-			SignatureInfoIDs: infoIds,
+			ConfigID:            ec.ConfigID,
+			BucketName:          ec.BucketName,
+			FilenameRoot:        ec.FilenameRoot,
+			StartTimestamp:      br.start,
+			EndTimestamp:        br.end,
+			Region:              ec.Region,
+			Status:              database.ExportBatchOpen,
+			SignatureInfoIDs:    infoIds,
+			HealthAuthorityID:   ec.HealthAuthorityID,
+			IncludeTravelers:    ec.IncludeTravelers,
+			IncludeReportTypes:  ec.IncludeReportTypes,
+			MinTransmissionRisk: ec.MinTransmissionRisk,
+			DeltaOfConfigID:     ec.DeltaOfConfigID,
 		})
 	}
 
-	if err := s.db.AddExportBatches(ctx, batches); err != nil {
+	// The lock may have expired while the batches were computed, so the
+	// batches are only added if no other batcher added some meanwhile.
+	if err := s.db.AddExportBatchesAfter(ctx, ec, latestEnd, batches); err != nil {
+		if errors.Is(err, database.ErrExportBatchesExist) {
+			metrics.WriteInt("export-batcher-conflict", true, 1)
+			logger.Infof("Batches for config %d were created by another batcher, skipping", ec.ConfigID)
+			return 0, nil
+		}
 		return 0, fmt.Errorf("creating export batches for config %d: %w", ec.ConfigID, err)
 	}
 
@@ -164,8 +196,6 @@ func makeBatchRanges(period time.Duration, latestEnd, now time.Time, truncateWin
 	start := end.Add(-period)
 
 	// Build up a list of batches until we reach that latestEnd.
-	// Allow for overlap so we don't miss keys; this might happen in the event that
-	// an ExportConfig was edited and the new settings don't quite align.
 	ranges := []batchRange{}
 	for end.After(latestEnd) {
 		// If the batch's end is after the publish window, don't add this range.
@@ -175,5 +205,13 @@ func makeBatchRanges(period time.Duration, latestEnd, now time.Time, truncateWin
 		start = start.Add(-period)
 		end = end.Add(-period)
 	}
+
+	// If the previous batch doesn't end on a period boundary, which happens
+	// when an ExportConfig was edited and the new settings don't quite align,
+	// the first batch starts where it ended, so batches never overlap and no
+	// key is skipped.
+	if len(ranges) > 0 && ranges[0].start.Before(latestEnd) {
+		ranges[0].start = latestEnd
+	}
 	return ranges
 }
@@ -84,10 +84,16 @@ func TestMakeBatchRanges(t *testing.T) {
 			want:      []simpleBatchRange{{"12-09 00:00", "12-10 00:00"}},
 		},
 		{
-			name:      "new batch overlaps with previous if misaligned",
+			name:      "new batch starts at end of previous if misaligned",
 			period:    1 * time.Hour,
 			latestEnd: "12-10 09:15",
-			want:      []simpleBatchRange{{"12-10 09:00", "12-10 10:00"}},
+			want:      []simpleBatchRange{{"12-10 09:15", "12-10 10:00"}},
+		},
+		{
+			name:      "only first batch is shortened if misaligned",
+			period:    1 * time.Hour,
+			latestEnd: "12-10 07:45",
+			want:      []simpleBatchRange{{"12-10 07:45", "12-10 08:00"}, {"12-10 08:00", "12-10 09:00"}, {"12-10 09:00", "12-10 10:00"}},
 		},
 		{
 			name:      "small export window doesn't overlap open publish window",
@@ -124,7 +130,8 @@ func TestMakeBatchRanges(t *testing.T) {
 				if !got[i].end.Equal(wantEndT) {
 					t.Errorf("unexpected end time for index %d, got %v, want %v", i, toSimpleTime(t, got[i].end), toSimpleTime(t, wantEndT))
 				}
-				if got[i].end.Sub(got[i].start) != tc.period {
+				// A batch following a misaligned one is shortened to start where it ended.
+				if got[i].end.Sub(got[i].start) != tc.period && !got[i].start.Equal(latestEndT) {
 					t.Errorf("unexpected range difference between start and end for index %d, got %v, want %v", i, got[i].end.Sub(got[i].start), tc.period)
 				}
 			}
@@ -133,6 +140,52 @@ func TestMakeBatchRanges(t *testing.T) {
 	}
 }
 
+// TestMakeBatchRangesCadences simulates batchers running every few minutes for
+// several days, across both DST transitions, and checks that configs with
+// different periods each get contiguous, non-overlapping batches aligned on
+// their period.
+func TestMakeBatchRangesCadences(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, period := range []time.Duration{time.Hour, 4 * time.Hour, 24 * time.Hour} {
+		for _, days := range []struct {
+			name       string
+			start, end time.Time
+		}{
+			{"spring forward", time.Date(2020, 3, 6, 0, 0, 0, 0, loc), time.Date(2020, 3, 10, 0, 0, 0, 0, loc)},
+			{"fall back", time.Date(2020, 10, 30, 0, 0, 0, 0, loc), time.Date(2020, 11, 3, 0, 0, 0, 0, loc)},
+		} {
+			var latestEnd time.Time
+			var all []batchRange
+			for now := days.start; now.Before(days.end); now = now.Add(7 * time.Minute) {
+				ranges := makeBatchRanges(period, latestEnd, now, time.Hour)
+				if len(ranges) > 0 {
+					all = append(all, ranges...)
+					latestEnd = ranges[len(ranges)-1].end
+				}
+			}
+
+			if len(all) == 0 {
+				t.Fatalf("%v %s: no batches created", period, days.name)
+			}
+			for i, br := range all {
+				if got := br.end.Sub(br.start); got != period {
+					t.Errorf("%v %s: batch %d [%v, %v) is %v long, want %v", period, days.name, i, br.start.UTC(), br.end.UTC(), got, period)
+				}
+				if !br.start.Equal(br.start.Truncate(period)) {
+					t.Errorf("%v %s: batch %d starts at %v, not aligned on period", period, days.name, i, br.start.UTC())
+				}
+				if i > 0 && !br.start.Equal(all[i-1].end) {
+					t.Errorf("%v %s: batch %d starts at %v, want end of previous batch %v", period, days.name, i, br.start.UTC(), all[i-1].end.UTC())
+				}
+			}
+		}
+	}
+}
+
 func fromSimpleTime(t *testing.T, s string) time.Time {
 	t.Helper()
 	if s == "" {
@@ -17,12 +17,17 @@ package export
 import (
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/cache"
 	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/scheduler"
 	"github.com/google/exposure-notifications-server/internal/setup"
+	"github.com/google/exposure-notifications-server/internal/signing"
+	"github.com/google/exposure-notifications-server/internal/storage"
 )
 
 // Compile-time check to assert this config matches requirements.
 var _ setup.KeyManagerProvider = (*Config)(nil)
+var _ setup.KeyManagerConfigProvider = (*Config)(nil)
 var _ setup.BlobStorageConfigProvider = (*Config)(nil)
 var _ setup.DBConfigProvider = (*Config)(nil)
 
@@ -30,6 +35,8 @@ var _ setup.DBConfigProvider = (*Config)(nil)
 // the export components.
 type Config struct {
 	Database       *database.Config
+	Storage        *storage.Config
+	Signing        *signing.Config
 	Port           string        `envconfig:"PORT" default:"8080"`
 	CreateTimeout  time.Duration `envconfig:"CREATE_BATCHES_TIMEOUT" default:"5m"`
 	WorkerTimeout  time.Duration `envconfig:"WORKER_TIMEOUT" default:"5m"`
@@ -38,6 +45,59 @@ type Config struct {
 	MaxRecords     int           `envconfig:"EXPORT_FILE_MAX_RECORDS" default:"30000"`
 	TruncateWindow time.Duration `envconfig:"TRUNCATE_WINDOW" default:"1h"`
 	MinWindowAge   time.Duration `envconfig:"MIN_WINDOW_AGE" default:"2h"`
+
+	// DownloadPort, if set, is the port that export files are served on for
+	// deployments without a CDN, see DownloadHandler. Port serves the batch
+	// and signing key handlers, which aren't authenticated, so only
+	// DownloadPort may be exposed to the internet.
+	DownloadPort string `envconfig:"EXPORT_DOWNLOAD_PORT"`
+
+	// PaddingBucket, if set, pads the last file of a batch with fake keys
+	// until its number of keys is a multiple of PaddingBucket, after padding
+	// to MinRecords. This hides the exact number of keys that were uploaded.
+	PaddingBucket int `envconfig:"EXPORT_FILE_PADDING_BUCKET" default:"0"`
+
+	// LookbackWindow extends each batch backwards, so that keys that arrive
+	// after the previous batch was exported, but are stamped with a time in
+	// it, are still exported. Only keys the previous batch didn't read are
+	// looked back at, so keys aren't exported twice.
+	LookbackWindow time.Duration `envconfig:"EXPORT_LOOKBACK_WINDOW" default:"0"`
+
+	// KeyRotationPeriod is how long a signing key marked for automatic
+	// rotation signs before a new version of it is created. Zero disables
+	// automatic rotation. The new version starts signing KeyActivationDelay
+	// after it is created, and both versions sign for KeyRotationOverlap, so
+	// devices receive the new public key, before the old one is retired.
+	KeyRotationPeriod  time.Duration `envconfig:"SIGNING_KEY_ROTATION_PERIOD" default:"0"`
+	KeyActivationDelay time.Duration `envconfig:"SIGNING_KEY_ACTIVATION_DELAY" default:"24h"`
+	KeyRotationOverlap time.Duration `envconfig:"SIGNING_KEY_ROTATION_OVERLAP" default:"720h"`
+
+	// CreateBatchesInterval, WorkerInterval and RotateKeysInterval, if not
+	// zero, make batch creation, batch export and signing key rotation run on
+	// their own every interval, in addition to when their handlers are called.
+	// Scheduler configures how instances share the runs.
+	CreateBatchesInterval time.Duration `envconfig:"CREATE_BATCHES_INTERVAL" default:"0"`
+	WorkerInterval        time.Duration `envconfig:"EXPORT_WORKER_INTERVAL" default:"0"`
+	RotateKeysInterval    time.Duration `envconfig:"ROTATE_KEYS_INTERVAL" default:"0"`
+	Scheduler             *scheduler.Config
+
+	// RegionBuckets maps regions with data residency to the only bucket their
+	// export files may be written to, such as "DE:exports-eu". Batches of
+	// those regions with another bucket fail. The exposures of every region
+	// are read from its database, see DB_RESIDENCY_HOSTS.
+	RegionBuckets map[string]string `envconfig:"EXPORT_REGION_BUCKETS"`
+
+	// AggregateStatsBucket is the bucket that AggregateStatsHandler writes
+	// dumps of aggregate stats to, signed by AggregateStatsSigningKey. The
+	// handler is disabled unless both are set.
+	AggregateStatsBucket     string `envconfig:"AGGREGATE_STATS_BUCKET"`
+	AggregateStatsSigningKey string `envconfig:"AGGREGATE_STATS_SIGNING_KEY"`
+
+	// ConfigCacheDuration is how long the export configs that downloads of
+	// indexes look up are cached, in memory and in the cache shared through
+	// Cache. Zero disables caching. Changes to export configs drop the cache.
+	ConfigCacheDuration time.Duration `envconfig:"EXPORT_CONFIG_CACHE_DURATION" default:"5m"`
+	Cache               *cache.Config
 }
 
 // DB returns the database config.
@@ -50,7 +110,12 @@ func (c *Config) KeyManager() bool {
 	return true
 }
 
-// BlobStorage returns the BlobStorage configuration.
-func (c *Config) BlobStorage() bool {
-	return true
+// KeyManagerConfig returns the KeyManager configuration.
+func (c *Config) KeyManagerConfig() *signing.Config {
+	return c.Signing
+}
+
+// BlobStorageConfig returns the BlobStorage configuration.
+func (c *Config) BlobStorageConfig() *storage.Config {
+	return c.Storage
 }
@@ -0,0 +1,139 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/storage"
+)
+
+// download is an export file or index that DownloadHandler serves.
+type download struct {
+	bucket      string
+	contentType string
+	cacheable   bool
+}
+
+// DownloadHandler serves export files and index files straight from the
+// blobstore, for deployments without a CDN in front of their bucket. The
+// object name is the request path, such as "root/index.txt", so the handler is
+// mounted with http.StripPrefix. Only the files of complete batches and the
+// index files of existing export configs are served. Responses carry an ETag
+// and support conditional and range requests.
+func (s *Server) DownloadHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	dl, err := s.lookupDownload(ctx, name)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		logger.Errorf("Failed to look up download %q: %v", name, err)
+		http.Error(w, "Failed to look up file, check logs.", http.StatusInternalServerError)
+		return
+	}
+
+	blobCtx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
+	defer cancel()
+	data, err := s.env.Blobstore().GetObject(blobCtx, dl.bucket, name)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		logger.Errorf("Failed to read download %q: %v", name, err)
+		http.Error(w, "Failed to read file, check logs.", http.StatusInternalServerError)
+		return
+	}
+
+	serveDownload(w, r, name, dl, data)
+}
+
+// lookupDownload returns where the object name is stored, or
+// database.ErrNotFound if it isn't an object that may be downloaded.
+func (s *Server) lookupDownload(ctx context.Context, name string) (*download, error) {
+	switch base := path.Base(name); {
+	case base == indexFilename || base == headFilename:
+		bucket, err := s.lookupBucket(ctx, path.Dir(name))
+		if err != nil {
+			return nil, err
+		}
+		contentType := "text/plain; charset=utf-8"
+		if base == headFilename {
+			contentType = "application/json"
+		}
+		return &download{bucket: bucket, contentType: contentType}, nil
+
+	case strings.HasSuffix(base, filenameSuffix):
+		ef, err := s.db.LookupExportFile(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if ef.Status != database.ExportBatchComplete {
+			return nil, database.ErrNotFound
+		}
+		// Files change if their batch is re-exported, so they are validated by
+		// their ETag alone: a Last-Modified would answer If-Modified-Since with
+		// the old file.
+		return &download{
+			bucket:      ef.BucketName,
+			contentType: "application/zip",
+			cacheable:   true,
+		}, nil
+	}
+	return nil, database.ErrNotFound
+}
+
+// lookupBucket returns the bucket of the export configs writing files under
+// filenameRoot, through the config cache if there is one.
+func (s *Server) lookupBucket(ctx context.Context, filenameRoot string) (string, error) {
+	if s.configCache == nil {
+		return s.db.LookupExportConfigBucket(ctx, filenameRoot)
+	}
+	bucket, err := s.configCache.Get(ctx, "bucket:"+filenameRoot, func(ctx context.Context) ([]byte, error) {
+		bucket, err := s.db.LookupExportConfigBucket(ctx, filenameRoot)
+		return []byte(bucket), err
+	})
+	return string(bucket), err
+}
+
+// serveDownload writes data with the headers of dl. http.ServeContent answers
+// If-None-Match and Range requests.
+func serveDownload(w http.ResponseWriter, r *http.Request, name string, dl *download, data []byte) {
+	sum := sha256.Sum256(data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	w.Header().Set("Cache-Control", storage.CacheControl(dl.cacheable))
+	w.Header().Set("Content-Type", dl.contentType)
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
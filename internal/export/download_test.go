@@ -0,0 +1,111 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeDownload(t *testing.T) {
+	data := []byte("0123456789")
+	etag := `"84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882"`
+	file := &download{contentType: "application/zip", cacheable: true}
+	index := &download{contentType: "text/plain; charset=utf-8"}
+
+	cases := []struct {
+		name       string
+		dl         *download
+		headers    map[string]string
+		wantStatus int
+		wantBody   string
+		wantCache  string
+	}{
+		{
+			name:       "file",
+			dl:         file,
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+			wantCache:  "public, max-age=86400",
+		},
+		{
+			name:       "index",
+			dl:         index,
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+			wantCache:  "no-cache, max-age=0",
+		},
+		{
+			name:       "etag matches",
+			dl:         index,
+			headers:    map[string]string{"If-None-Match": etag},
+			wantStatus: http.StatusNotModified,
+			wantCache:  "no-cache, max-age=0",
+		},
+		{
+			name:       "etag changed",
+			dl:         index,
+			headers:    map[string]string{"If-None-Match": `"stale"`},
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+			wantCache:  "no-cache, max-age=0",
+		},
+		{
+			// A re-exported file must not be answered from its date.
+			name:       "modified since ignored",
+			dl:         file,
+			headers:    map[string]string{"If-Modified-Since": "Sat, 02 May 2099 00:00:00 GMT"},
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+			wantCache:  "public, max-age=86400",
+		},
+		{
+			name:       "range",
+			dl:         file,
+			headers:    map[string]string{"Range": "bytes=2-5"},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "2345",
+			wantCache:  "public, max-age=86400",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/root/file.zip", nil)
+			for k, v := range c.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			serveDownload(w, r, "root/file.zip", c.dl, data)
+
+			if w.Code != c.wantStatus {
+				t.Errorf("status: got %d, want %d", w.Code, c.wantStatus)
+			}
+			if got := w.Body.String(); got != c.wantBody {
+				t.Errorf("body: got %q, want %q", got, c.wantBody)
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag: got %q, want %q", got, etag)
+			}
+			if got := w.Header().Get("Cache-Control"); got != c.wantCache {
+				t.Errorf("Cache-Control: got %q, want %q", got, c.wantCache)
+			}
+			if got := w.Header().Get("Last-Modified"); got != "" {
+				t.Errorf("Last-Modified: got %q, want none", got)
+			}
+		})
+	}
+}
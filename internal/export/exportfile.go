@@ -18,9 +18,15 @@ import (
 	"archive/zip"
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	mathrand "math/rand"
 	"sort"
 
 	"github.com/google/exposure-notifications-server/internal/database"
@@ -31,6 +37,7 @@ import (
 
 const (
 	fixedHeaderWidth     = 16
+	exportHeader         = "EK Export v1    "
 	exportBinaryName     = "export.bin"
 	exportSignatureName  = "export.sig"
 	defaultIntervalCount = 144
@@ -82,19 +89,143 @@ func MarshalExportFile(eb *database.ExportBatch, exposures []*database.Exposure,
 	return buf.Bytes(), nil
 }
 
+// UnmarshalExportFile parses an export file created by MarshalExportFile into
+// the contents of its export.bin, without the header, and export.sig.
+func UnmarshalExportFile(data []byte) (*export.TemporaryExposureKeyExport, *export.TEKSignatureList, error) {
+	bin, sig, err := readExportArchive(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return unmarshalExportArchive(bin, sig)
+}
+
+func unmarshalExportArchive(bin, sig []byte) (*export.TemporaryExposureKeyExport, *export.TEKSignatureList, error) {
+	var contents export.TemporaryExposureKeyExport
+	if err := proto.Unmarshal(bin[fixedHeaderWidth:], &contents); err != nil {
+		return nil, nil, fmt.Errorf("unable to unmarshal %v: %w", exportBinaryName, err)
+	}
+	var signatures export.TEKSignatureList
+	if err := proto.Unmarshal(sig, &signatures); err != nil {
+		return nil, nil, fmt.Errorf("unable to unmarshal %v: %w", exportSignatureName, err)
+	}
+	return &contents, &signatures, nil
+}
+
+// readExportArchive returns the raw export.bin, with its header checked, and
+// export.sig of an export file.
+func readExportArchive(data []byte) ([]byte, []byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read archive: %w", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to open %v: %w", f.Name, err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read %v: %w", f.Name, err)
+		}
+		files[f.Name] = b
+	}
+
+	bin, ok := files[exportBinaryName]
+	if !ok {
+		return nil, nil, fmt.Errorf("archive has no %v", exportBinaryName)
+	}
+	if len(bin) < fixedHeaderWidth || string(bin[:fixedHeaderWidth]) != exportHeader {
+		return nil, nil, fmt.Errorf("%v has an invalid header", exportBinaryName)
+	}
+	sig, ok := files[exportSignatureName]
+	if !ok {
+		return nil, nil, fmt.Errorf("archive has no %v", exportSignatureName)
+	}
+	return bin, sig, nil
+}
+
+// VerifyExportFile checks that data is a well formed export file of eb with
+// numKeys keys, and that it carries a valid signature by each of the signers,
+// in order. The worker verifies every file before uploading it, so signing or
+// serialization bugs are caught before clients download the file.
+func VerifyExportFile(data []byte, eb *database.ExportBatch, numKeys, batchNum, batchSize int, signers []ExportSigners) error {
+	bin, sig, err := readExportArchive(data)
+	if err != nil {
+		return err
+	}
+	contents, signatures, err := unmarshalExportArchive(bin, sig)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case contents.GetRegion() != eb.Region:
+		return fmt.Errorf("region is %q, want %q", contents.GetRegion(), eb.Region)
+	case contents.GetStartTimestamp() != uint64(eb.StartTimestamp.Unix()):
+		return fmt.Errorf("start timestamp is %d, want %d", contents.GetStartTimestamp(), eb.StartTimestamp.Unix())
+	case contents.GetEndTimestamp() != uint64(eb.EndTimestamp.Unix()):
+		return fmt.Errorf("end timestamp is %d, want %d", contents.GetEndTimestamp(), eb.EndTimestamp.Unix())
+	case int(contents.GetBatchNum()) != batchNum || int(contents.GetBatchSize()) != batchSize:
+		return fmt.Errorf("batch is %d of %d, want %d of %d", contents.GetBatchNum(), contents.GetBatchSize(), batchNum, batchSize)
+	case len(contents.Keys) != numKeys:
+		return fmt.Errorf("file has %d keys, want %d", len(contents.Keys), numKeys)
+	case len(signatures.Signatures) != len(signers):
+		return fmt.Errorf("file has %d signatures, want %d", len(signatures.Signatures), len(signers))
+	}
+
+	digest := sha256.Sum256(bin)
+	for i, s := range signers {
+		sig := signatures.Signatures[i]
+		if !proto.Equal(sig.SignatureInfo, createSignatureInfo(s.SignatureInfo)) {
+			return fmt.Errorf("signature %d has signature info %v, want %v", i, sig.SignatureInfo, createSignatureInfo(s.SignatureInfo))
+		}
+		if int(sig.GetBatchNum()) != batchNum || int(sig.GetBatchSize()) != batchSize {
+			return fmt.Errorf("signature %d is for batch %d of %d, want %d of %d", i, sig.GetBatchNum(), sig.GetBatchSize(), batchNum, batchSize)
+		}
+		pub, ok := s.Signer.Public().(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("signature %d: signing key is %T, not ECDSA", i, s.Signer.Public())
+		}
+		if err := verifyECDSASignature(pub, digest[:], sig.Signature); err != nil {
+			return fmt.Errorf("signature %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// verifyECDSASignature checks an ASN.1 DER encoded ECDSA signature of digest.
+func verifyECDSASignature(pub *ecdsa.PublicKey, digest, sig []byte) error {
+	var esig struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(sig, &esig); err != nil || len(rest) != 0 {
+		return fmt.Errorf("not a valid ECDSA signature")
+	}
+	if !ecdsa.Verify(pub, digest, esig.R, esig.S) {
+		return fmt.Errorf("does not verify")
+	}
+	return nil
+}
+
+// exportReportTypes maps stored report types to their export representation.
+// Exposures without a report type are exported without one.
+var exportReportTypes = map[string]export.TemporaryExposureKey_ReportType{
+	database.ReportTypeConfirmed: export.TemporaryExposureKey_CONFIRMED_TEST,
+	database.ReportTypeLikely:    export.TemporaryExposureKey_CONFIRMED_CLINICAL_DIAGNOSIS,
+	database.ReportTypeNegative:  export.TemporaryExposureKey_REVOKED,
+}
+
 func marshalContents(eb *database.ExportBatch, exposures []*database.Exposure, batchNum int32, batchSize int32, signers []ExportSigners) ([]byte, error) {
-	exportBytes := []byte("EK Export v1    ")
+	exportBytes := []byte(exportHeader)
 	if len(exportBytes) != fixedHeaderWidth {
 		return nil, fmt.Errorf("incorrect header length: %d", len(exportBytes))
 	}
-	// We want to scramble keys to ensure no associations, so arbitrarily sort them.
-	// This could be done at the db layer but doing it here makes it explicit that its
-	// important to the serialization
-	sort.Slice(exposures, func(i, j int) bool {
-		return bytes.Compare(exposures[i].ExposureKey, exposures[j].ExposureKey) < 0
-	})
+	// We want to scramble keys to ensure no associations with upload order or
+	// time. This could be done at the db layer but doing it here makes it
+	// explicit that its important to the serialization.
+	shuffled := shuffleExposures(exposures)
 	var pbeks []*export.TemporaryExposureKey
-	for _, exp := range exposures {
+	for _, exp := range shuffled {
 		pbek := export.TemporaryExposureKey{
 			KeyData:               exp.ExposureKey,
 			TransmissionRiskLevel: proto.Int32(int32(exp.TransmissionRisk)),
@@ -105,6 +236,12 @@ func marshalContents(eb *database.ExportBatch, exposures []*database.Exposure, b
 		if exp.IntervalCount != defaultIntervalCount {
 			pbek.RollingPeriod = proto.Int32(exp.IntervalCount)
 		}
+		if rt, ok := exportReportTypes[exp.ReportType]; ok {
+			pbek.ReportType = rt.Enum()
+		}
+		if exp.DaysSinceSymptomOnset != nil {
+			pbek.DaysSinceOnsetOfSymptoms = proto.Int32(*exp.DaysSinceSymptomOnset)
+		}
 		pbeks = append(pbeks, &pbek)
 	}
 
@@ -129,6 +266,35 @@ func marshalContents(eb *database.ExportBatch, exposures []*database.Exposure, b
 	return append(exportBytes, protoBytes...), nil
 }
 
+// shuffleExposures returns a copy of exposures in a random order. The keys are
+// put in a canonical order first and then shuffled with a seed derived from
+// the keys themselves, so the order carries no information about how the
+// exposures were uploaded, and the same exposures always produce the same
+// export.bin.
+func shuffleExposures(exposures []*database.Exposure) []*database.Exposure {
+	shuffled := make([]*database.Exposure, len(exposures))
+	copy(shuffled, exposures)
+	sort.Slice(shuffled, func(i, j int) bool {
+		if c := bytes.Compare(shuffled[i].ExposureKey, shuffled[j].ExposureKey); c != 0 {
+			return c < 0
+		}
+		return shuffled[i].IntervalNumber < shuffled[j].IntervalNumber
+	})
+
+	h := sha256.New()
+	for _, exp := range shuffled {
+		h.Write(exp.ExposureKey)
+		binary.Write(h, binary.BigEndian, exp.IntervalNumber)
+	}
+	seed := int64(binary.BigEndian.Uint64(h.Sum(nil)))
+
+	r := mathrand.New(mathrand.NewSource(seed))
+	r.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
+
 func createSignatureInfo(si *database.SignatureInfo) *export.SignatureInfo {
 	sigInfo := &export.SignatureInfo{SignatureAlgorithm: proto.String(algorithm)}
 	if si.AppPackageName != "" {
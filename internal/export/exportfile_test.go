@@ -0,0 +1,274 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/pb/export"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMarshalExportFile(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers := []ExportSigners{{
+		SignatureInfo: &database.SignatureInfo{
+			AppPackageName:    "com.example.app",
+			SigningKeyVersion: "v1",
+			SigningKeyID:      "310",
+		},
+		Signer: key,
+	}}
+
+	eb := &database.ExportBatch{
+		StartTimestamp: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		EndTimestamp:   time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC),
+		Region:         "US",
+	}
+	onset := int32(2)
+	exposures := []*database.Exposure{
+		{ExposureKey: bytes.Repeat([]byte{3}, 16), IntervalNumber: 2649024, IntervalCount: 144, TransmissionRisk: 2, ReportType: database.ReportTypeConfirmed},
+		{ExposureKey: bytes.Repeat([]byte{1}, 16), IntervalNumber: 2649168, IntervalCount: 100, TransmissionRisk: 4, DaysSinceSymptomOnset: &onset},
+		{ExposureKey: bytes.Repeat([]byte{2}, 16), IntervalNumber: 2649312, IntervalCount: 144, TransmissionRisk: 6, ReportType: database.ReportTypeNegative},
+	}
+	input := make([]*database.Exposure, len(exposures))
+	copy(input, exposures)
+
+	data, err := MarshalExportFile(eb, exposures, 1, 2, signers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(input, exposures); diff != "" {
+		t.Errorf("exposures were reordered (-want, +got):\n%s", diff)
+	}
+
+	// The archive holds export.bin, then export.sig.
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if diff := cmp.Diff([]string{exportBinaryName, exportSignatureName}, names); diff != "" {
+		t.Errorf("archive entries mismatch (-want, +got):\n%s", diff)
+	}
+
+	contents, signatures, err := UnmarshalExportFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigInfo := &export.SignatureInfo{
+		AndroidPackage:         proto.String("com.example.app"),
+		VerificationKeyVersion: proto.String("v1"),
+		VerificationKeyId:      proto.String("310"),
+		SignatureAlgorithm:     proto.String(algorithm),
+	}
+	want := &export.TemporaryExposureKeyExport{
+		StartTimestamp: proto.Uint64(uint64(eb.StartTimestamp.Unix())),
+		EndTimestamp:   proto.Uint64(uint64(eb.EndTimestamp.Unix())),
+		Region:         proto.String("US"),
+		BatchNum:       proto.Int32(1),
+		BatchSize:      proto.Int32(2),
+		SignatureInfos: []*export.SignatureInfo{sigInfo},
+	}
+	// Keys are listed in the order of shuffleExposures.
+	wantKeys := map[byte]*export.TemporaryExposureKey{
+		1: {
+			KeyData:                    bytes.Repeat([]byte{1}, 16),
+			TransmissionRiskLevel:      proto.Int32(4),
+			RollingStartIntervalNumber: proto.Int32(2649168),
+			RollingPeriod:              proto.Int32(100),
+			DaysSinceOnsetOfSymptoms:   proto.Int32(2),
+		},
+		2: {
+			KeyData:                    bytes.Repeat([]byte{2}, 16),
+			TransmissionRiskLevel:      proto.Int32(6),
+			RollingStartIntervalNumber: proto.Int32(2649312),
+			ReportType:                 export.TemporaryExposureKey_REVOKED.Enum(),
+		},
+		3: {
+			KeyData:                    bytes.Repeat([]byte{3}, 16),
+			TransmissionRiskLevel:      proto.Int32(2),
+			RollingStartIntervalNumber: proto.Int32(2649024),
+			ReportType:                 export.TemporaryExposureKey_CONFIRMED_TEST.Enum(),
+		},
+	}
+	for _, exp := range shuffleExposures(exposures) {
+		want.Keys = append(want.Keys, wantKeys[exp.ExposureKey[0]])
+	}
+	if !proto.Equal(want, contents) {
+		t.Errorf("export.bin mismatch:\ngot:  %v\nwant: %v", contents, want)
+	}
+
+	if n := len(signatures.Signatures); n != 1 {
+		t.Fatalf("got %d signatures, want 1", n)
+	}
+	sig := signatures.Signatures[0]
+	if !proto.Equal(sigInfo, sig.SignatureInfo) || sig.GetBatchNum() != 1 || sig.GetBatchSize() != 2 {
+		t.Errorf("export.sig mismatch: got %v", sig)
+	}
+
+	// The signature covers the whole of export.bin, header included.
+	bin, err := marshalContents(eb, exposures, 1, 2, signers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var esig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(sig.Signature, &esig); err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(bin)
+	if !ecdsa.Verify(&key.PublicKey, digest[:], esig.R, esig.S) {
+		t.Errorf("signature does not verify")
+	}
+
+	// The same exposures in any order produce the same export.bin.
+	reversed := []*database.Exposure{exposures[2], exposures[1], exposures[0]}
+	again, err := marshalContents(eb, reversed, 1, 2, signers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bin, again) {
+		t.Errorf("export.bin is not reproducible")
+	}
+}
+
+func TestUnmarshalExportFileInvalid(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	zf, err := zw.Create(exportBinaryName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zf.Write([]byte("not an export file")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := UnmarshalExportFile(buf.Bytes()); err == nil {
+		t.Errorf("expected invalid header to be rejected")
+	}
+}
+
+func TestVerifyExportFile(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigInfo := &database.SignatureInfo{AppPackageName: "com.example.app", SigningKeyVersion: "v1", SigningKeyID: "310"}
+	signers := []ExportSigners{{SignatureInfo: sigInfo, Signer: key}}
+
+	eb := &database.ExportBatch{
+		StartTimestamp: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		EndTimestamp:   time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC),
+		Region:         "US",
+	}
+	exposures := []*database.Exposure{
+		{ExposureKey: bytes.Repeat([]byte{1}, 16), IntervalNumber: 2649024, IntervalCount: 144, TransmissionRisk: 2},
+		{ExposureKey: bytes.Repeat([]byte{2}, 16), IntervalNumber: 2649168, IntervalCount: 144, TransmissionRisk: 4},
+	}
+	data, err := MarshalExportFile(eb, exposures, 1, 2, signers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherRegion := *eb
+	otherRegion.Region = "CA"
+
+	cases := []struct {
+		name      string
+		data      []byte
+		eb        *database.ExportBatch
+		numKeys   int
+		batchNum  int
+		signers   []ExportSigners
+		wantError bool
+	}{
+		{name: "valid", data: data, eb: eb, numKeys: 2, batchNum: 1, signers: signers},
+		{name: "not a zip", data: []byte("nope"), eb: eb, numKeys: 2, batchNum: 1, signers: signers, wantError: true},
+		{name: "wrong region", data: data, eb: &otherRegion, numKeys: 2, batchNum: 1, signers: signers, wantError: true},
+		{name: "wrong key count", data: data, eb: eb, numKeys: 3, batchNum: 1, signers: signers, wantError: true},
+		{name: "wrong batch number", data: data, eb: eb, numKeys: 2, batchNum: 2, signers: signers, wantError: true},
+		{name: "missing signature", data: data, eb: eb, numKeys: 2, batchNum: 1, signers: append(signers, signers...), wantError: true},
+		{name: "wrong signing key", data: data, eb: eb, numKeys: 2, batchNum: 1, signers: []ExportSigners{{SignatureInfo: sigInfo, Signer: otherKey}}, wantError: true},
+	}
+	for _, c := range cases {
+		err := VerifyExportFile(c.data, c.eb, c.numKeys, c.batchNum, 2, c.signers)
+		if gotError := err != nil; gotError != c.wantError {
+			t.Errorf("%s: got error %v, want error: %t", c.name, err, c.wantError)
+		}
+	}
+}
+
+func TestShuffleExposures(t *testing.T) {
+	var exposures []*database.Exposure
+	for i := 0; i < 100; i++ {
+		key := make([]byte, database.KeyLength)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		exposures = append(exposures, &database.Exposure{ExposureKey: key, IntervalNumber: int32(i)})
+	}
+	reversed := make([]*database.Exposure, len(exposures))
+	for i, exp := range exposures {
+		reversed[len(exposures)-1-i] = exp
+	}
+
+	got := shuffleExposures(exposures)
+	if diff := cmp.Diff(got, shuffleExposures(reversed)); diff != "" {
+		t.Errorf("order depends on the input order (-first, +second):\n%s", diff)
+	}
+
+	// The result is a permutation of the input.
+	seen := make(map[*database.Exposure]bool)
+	for _, exp := range got {
+		seen[exp] = true
+	}
+	for _, exp := range exposures {
+		if !seen[exp] {
+			t.Fatalf("exposure %x missing from the shuffled exposures", exp.ExposureKey)
+		}
+	}
+
+	// And it is neither the input order nor sorted by key.
+	sorted := make([]*database.Exposure, len(exposures))
+	copy(sorted, exposures)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].ExposureKey, sorted[j].ExposureKey) < 0 })
+	if cmp.Equal(got, exposures) || cmp.Equal(got, sorted) {
+		t.Errorf("exposures were not shuffled")
+	}
+}
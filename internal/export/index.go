@@ -0,0 +1,226 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/storage"
+)
+
+const (
+	indexFilename  = "index.txt"
+	headFilename   = "head.json"
+	indexLockSleep = 10 * time.Second
+)
+
+// WithIndexLock runs f while holding the lock on the index file of an export
+// config. The index lists the files of every batch of the config, so anything
+// that rewrites it, or deletes files it lists, must hold this lock. It waits
+// for the lock until ctx is done.
+func WithIndexLock(ctx context.Context, db *database.DB, configID int64, ttl time.Duration, f func() error) error {
+	logger := logging.FromContext(ctx)
+
+	lockID := fmt.Sprintf("export-index-%d", configID)
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("acquiring lock %s: %w", lockID, err)
+		}
+
+		unlock, err := db.Lock(ctx, lockID, ttl)
+		if err != nil {
+			if errors.Is(err, database.ErrAlreadyLocked) {
+				logger.Debugf("Lock %s is locked; sleeping %v and will try again", lockID, indexLockSleep)
+				time.Sleep(indexLockSleep)
+				continue
+			}
+			return err
+		}
+
+		if err := f(); err != nil {
+			if err1 := unlock(); err1 != nil {
+				return fmt.Errorf("releasing lock: %v (original error: %w)", err1, err)
+			}
+			return err
+		}
+		if err := unlock(); err != nil {
+			return fmt.Errorf("releasing lock: %w", err)
+		}
+		return nil
+	}
+}
+
+// WriteIndex replaces the index file under filenameRoot with the filenames of
+// the given entries, and then the head file that summarizes it. Each is written
+// in a single object write, so clients see either the old or the new list, and
+// neither may be cached, so clients always see the latest one.
+func WriteIndex(ctx context.Context, blobstore storage.Blobstore, bucketName, filenameRoot string, entries []*database.ExportIndexEntry) (string, error) {
+	filenames := make([]string, 0, len(entries))
+	for _, e := range entries {
+		filenames = append(filenames, e.Filename)
+	}
+	data := []byte(strings.Join(filenames, "\n"))
+
+	indexObjectName := exportIndexFilename(filenameRoot)
+	head, err := json.Marshal(buildIndexHead(indexObjectName, data, entries))
+	if err != nil {
+		return "", fmt.Errorf("marshalling head file: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
+	defer cancel()
+	if err := blobstore.CreateObject(ctx, bucketName, indexObjectName, data, false); err != nil {
+		return "", fmt.Errorf("creating file %s in bucket %s: %w", indexObjectName, bucketName, err)
+	}
+	// The head is written after the index, so a client that sees a new head
+	// also sees the index it describes.
+	headObjectName := exportHeadFilename(filenameRoot)
+	if err := blobstore.CreateObject(ctx, bucketName, headObjectName, head, false); err != nil {
+		return "", fmt.Errorf("creating file %s in bucket %s: %w", headObjectName, bucketName, err)
+	}
+	return indexObjectName, nil
+}
+
+// indexHead is the contents of the head file. It is small and only changes when
+// the index does, so clients can poll it, or send its ETag, to cheaply find out
+// whether there are new files.
+type indexHead struct {
+	Index string `json:"index"`
+	// SHA256 is the hex encoded digest of the index file.
+	SHA256 string `json:"sha256"`
+	Files  int    `json:"files"`
+	// LatestFile and LatestBatchEnd describe the last file of the index and
+	// the end of its batch, as a Unix timestamp. They are empty if the index
+	// is.
+	LatestFile     string `json:"latestFile,omitempty"`
+	LatestBatchEnd int64  `json:"latestBatchEnd,omitempty"`
+}
+
+func buildIndexHead(indexObjectName string, index []byte, entries []*database.ExportIndexEntry) *indexHead {
+	digest := sha256.Sum256(index)
+	head := &indexHead{
+		Index:  indexObjectName,
+		SHA256: hex.EncodeToString(digest[:]),
+		Files:  len(entries),
+	}
+	for _, e := range entries {
+		if end := e.BatchEnd.Unix(); end > head.LatestBatchEnd {
+			head.LatestBatchEnd = end
+		}
+	}
+	if len(entries) > 0 {
+		head.LatestFile = entries[len(entries)-1].Filename
+	}
+	return head
+}
+
+// retryingCreateIndex create the index file. The index file includes _all_ batches for an ExportConfig,
+// so multiple workers may be racing to update it. We use a lock to make them line up after one another.
+func (s *Server) retryingCreateIndex(ctx context.Context, eb *database.ExportBatch, objectNames []string) error {
+	logger := logging.FromContext(ctx)
+
+	err := WithIndexLock(ctx, s.db, eb.ConfigID, time.Minute, func() error {
+		indexName, entries, err := s.createIndex(ctx, eb, objectNames)
+		if err != nil {
+			return fmt.Errorf("creating index file for batch %d: %w", eb.BatchID, err)
+		}
+		logger.Infof("Wrote index file %q with %d entries (triggered by batch %d)", indexName, entries, eb.BatchID)
+		return nil
+	})
+	if err != nil && ctx.Err() != nil {
+		logger.Infof("Timed out acquiring index file lock for batch %d, the entire batch will be retried once the batch lease expires on %v", eb.BatchID, eb.LeaseExpires)
+		return nil
+	}
+	return err
+}
+
+// createIndex writes the index file of the batch's export config. It is only
+// called once every file of the batch has been uploaded, so the index never
+// references a file that does not exist yet.
+func (s *Server) createIndex(ctx context.Context, eb *database.ExportBatch, newObjectNames []string) (string, int, error) {
+	entries, err := s.db.LookupExportIndexEntries(ctx, eb.ConfigID)
+	if err != nil {
+		return "", 0, fmt.Errorf("lookup existing export files for batch %d: %w", eb.BatchID, err)
+	}
+
+	objects := buildIndex(entries, eb, newObjectNames)
+	indexObjectName, err := WriteIndex(ctx, s.env.Blobstore(), eb.BucketName, eb.FilenameRoot, objects)
+	if err != nil {
+		return "", 0, err
+	}
+	return indexObjectName, len(objects), nil
+}
+
+// buildIndex returns the entries of the index: the existing entries plus the
+// new files of eb, which haven't been committed to the database yet. If eb is
+// being re-exported, its new files replace the ones it had before. Files are
+// ordered by the start of their batch and then their number within the batch,
+// so clients can process them in order, and each file is listed once.
+func buildIndex(entries []*database.ExportIndexEntry, eb *database.ExportBatch, newObjectNames []string) []*database.ExportIndexEntry {
+	all := make([]*database.ExportIndexEntry, 0, len(entries)+len(newObjectNames))
+	for _, e := range entries {
+		if e.BatchID != eb.BatchID {
+			all = append(all, e)
+		}
+	}
+	for i, name := range newObjectNames {
+		all = append(all, &database.ExportIndexEntry{
+			Filename:   name,
+			BatchID:    eb.BatchID,
+			BatchStart: eb.StartTimestamp,
+			BatchEnd:   eb.EndTimestamp,
+			BatchNum:   i + 1,
+		})
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		if !all[i].BatchStart.Equal(all[j].BatchStart) {
+			return all[i].BatchStart.Before(all[j].BatchStart)
+		}
+		if all[i].BatchNum != all[j].BatchNum {
+			return all[i].BatchNum < all[j].BatchNum
+		}
+		return all[i].Filename < all[j].Filename
+	})
+
+	seen := make(map[string]struct{}, len(all))
+	objects := make([]*database.ExportIndexEntry, 0, len(all))
+	for _, e := range all {
+		if _, ok := seen[e.Filename]; ok {
+			continue
+		}
+		seen[e.Filename] = struct{}{}
+		objects = append(objects, e)
+	}
+	return objects
+}
+
+func exportIndexFilename(filenameRoot string) string {
+	return fmt.Sprintf("%s/%s", filenameRoot, indexFilename)
+}
+
+func exportHeadFilename(filenameRoot string) string {
+	return fmt.Sprintf("%s/%s", filenameRoot, headFilename)
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildIndex(t *testing.T) {
+	day1 := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+	day3 := day2.Add(24 * time.Hour)
+
+	existing := []*database.ExportIndexEntry{
+		{Filename: "root/1588291200-00001.zip", BatchID: 1, BatchStart: day1, BatchNum: 1},
+		{Filename: "root/US-1588464000-00001-of-00002.zip", BatchID: 3, BatchStart: day3, BatchNum: 1},
+		{Filename: "root/US-1588464000-00002-of-00002.zip", BatchID: 3, BatchStart: day3, BatchNum: 2},
+	}
+
+	cases := []struct {
+		name    string
+		batchID int64
+		start   time.Time
+		names   []string
+		want    []string
+	}{
+		{
+			name:    "empty batch",
+			batchID: 4,
+			start:   day3.Add(24 * time.Hour),
+			want: []string{
+				"root/1588291200-00001.zip",
+				"root/US-1588464000-00001-of-00002.zip",
+				"root/US-1588464000-00002-of-00002.zip",
+			},
+		},
+		{
+			name:    "batch completed out of order",
+			batchID: 2,
+			start:   day2,
+			names:   []string{"root/US-1588377600-00001-of-00002.zip", "root/US-1588377600-00002-of-00002.zip"},
+			want: []string{
+				"root/1588291200-00001.zip",
+				"root/US-1588377600-00001-of-00002.zip",
+				"root/US-1588377600-00002-of-00002.zip",
+				"root/US-1588464000-00001-of-00002.zip",
+				"root/US-1588464000-00002-of-00002.zip",
+			},
+		},
+		{
+			name:    "retried batch",
+			batchID: 3,
+			start:   day3,
+			names:   []string{"root/US-1588464000-00001-of-00002.zip", "root/US-1588464000-00002-of-00002.zip"},
+			want: []string{
+				"root/1588291200-00001.zip",
+				"root/US-1588464000-00001-of-00002.zip",
+				"root/US-1588464000-00002-of-00002.zip",
+			},
+		},
+		{
+			name:    "re-exported batch with fewer files",
+			batchID: 3,
+			start:   day3,
+			names:   []string{"root/US-1588464000-00001-of-00001.zip"},
+			want: []string{
+				"root/1588291200-00001.zip",
+				"root/US-1588464000-00001-of-00001.zip",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			eb := &database.ExportBatch{BatchID: c.batchID, StartTimestamp: c.start}
+			var got []string
+			for _, e := range buildIndex(existing, eb, c.names) {
+				got = append(got, e.Filename)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBuildIndexHead(t *testing.T) {
+	day1 := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+
+	entries := []*database.ExportIndexEntry{
+		{Filename: "root/US-1588291200-00001-of-00001.zip", BatchStart: day1, BatchEnd: day2},
+		{Filename: "root/US-1588377600-00001-of-00002.zip", BatchStart: day2, BatchEnd: day2.Add(24 * time.Hour)},
+		{Filename: "root/US-1588377600-00002-of-00002.zip", BatchStart: day2, BatchEnd: day2.Add(24 * time.Hour)},
+	}
+
+	got := buildIndexHead("root/index.txt", []byte("index"), entries)
+	want := &indexHead{
+		Index:          "root/index.txt",
+		SHA256:         "1bc04b5291c26a46d918139138b992d2de976d6851d0893b0476b85bfbdfc6e6",
+		Files:          3,
+		LatestFile:     "root/US-1588377600-00002-of-00002.zip",
+		LatestBatchEnd: 1588464000,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	got = buildIndexHead("root/index.txt", nil, nil)
+	want = &indexHead{
+		Index:  "root/index.txt",
+		SHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("empty index mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestWriteIndex(t *testing.T) {
+	ctx := context.Background()
+	blobstore, err := storage.NewMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := blobstore.(*storage.Memory)
+
+	day := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	entries := []*database.ExportIndexEntry{
+		{Filename: "root/US-1588291200-00001-of-00002.zip", BatchStart: day, BatchEnd: day.Add(24 * time.Hour)},
+		{Filename: "root/US-1588291200-00002-of-00002.zip", BatchStart: day, BatchEnd: day.Add(24 * time.Hour)},
+	}
+	name, err := WriteIndex(ctx, blobstore, "bucket", "root", entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "root/index.txt" {
+		t.Errorf("got index name %q, want root/index.txt", name)
+	}
+
+	index, err := mem.Object("bucket", "root/index.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &storage.MemoryObject{Contents: []byte("root/US-1588291200-00001-of-00002.zip\nroot/US-1588291200-00002-of-00002.zip")}
+	if diff := cmp.Diff(want, index); diff != "" {
+		t.Errorf("index mismatch (-want, +got):\n%s", diff)
+	}
+
+	obj, err := mem.Object("bucket", "root/head.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Cacheable {
+		t.Errorf("head file must not be cacheable")
+	}
+	var head indexHead
+	if err := json.Unmarshal(obj.Contents, &head); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(buildIndexHead(name, index.Contents, entries), &head); diff != "" {
+		t.Errorf("head mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/jsonutil"
+	"github.com/google/exposure-notifications-server/internal/logging"
+)
+
+// ReexportRequest selects the completed export batches to regenerate, either
+// by ID or as the batches of an export config within a time range.
+type ReexportRequest struct {
+	BatchIDs []int64   `json:"batchIds,omitempty"`
+	ConfigID int64     `json:"configId,omitempty"`
+	From     time.Time `json:"from,omitempty"`
+	Thru     time.Time `json:"thru,omitempty"`
+}
+
+// ReexportResponse lists the batches that were reopened.
+type ReexportResponse struct {
+	BatchIDs []int64 `json:"batchIds"`
+}
+
+// ReexportHandler reopens completed export batches so that the worker
+// generates their files again, signed with the current keys of their export
+// config. The regenerated files overwrite the old ones, and the index is
+// rewritten once all of a batch's files are uploaded. Use it when a signing
+// key is compromised or a bug produced bad files.
+func (s *Server) ReexportHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	metrics := s.env.MetricsExporter(ctx)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReexportRequest
+	if code, err := jsonutil.Unmarshal(w, r, &req); err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	batchIDs := req.BatchIDs
+	if req.ConfigID != 0 {
+		var err error
+		batchIDs, err = s.db.LookupCompletedExportBatchIDs(ctx, req.ConfigID, req.From, req.Thru)
+		if err != nil {
+			logger.Errorf("Failed to look up export batches of config %d: %v", req.ConfigID, err)
+			metrics.WriteInt("export-reexport-failed", true, 1)
+			http.Error(w, "Failed to look up batches, check logs.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	reopened, err := s.db.ReexportBatches(ctx, batchIDs)
+	if err != nil {
+		logger.Errorf("Failed to reopen export batches %v: %v", batchIDs, err)
+		metrics.WriteInt("export-reexport-failed", true, 1)
+		http.Error(w, "Failed to reopen batches, check logs.", http.StatusInternalServerError)
+		return
+	}
+	if len(reopened) < len(batchIDs) {
+		logger.Warnf("Only %d of %d requested export batches were complete and reopened", len(reopened), len(batchIDs))
+	}
+	logger.Infof("Reopened export batches %v for re-export", reopened)
+	metrics.WriteInt("export-reexport-batches", true, len(reopened))
+
+	if reopened == nil {
+		reopened = []int64{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&ReexportResponse{BatchIDs: reopened}); err != nil {
+		logger.Errorf("Failed to write response: %v", err)
+	}
+}
+
+// validate checks that the request selects batches either by ID or by config,
+// and defaults Thru to now.
+func (req *ReexportRequest) validate() error {
+	switch {
+	case len(req.BatchIDs) > 0 && req.ConfigID != 0:
+		return fmt.Errorf("only one of batchIds and configId may be set")
+	case len(req.BatchIDs) > 0:
+		return nil
+	case req.ConfigID != 0:
+		if req.Thru.IsZero() {
+			req.Thru = time.Now()
+		}
+		if req.Thru.Before(req.From) {
+			return fmt.Errorf("thru must not be before from")
+		}
+		return nil
+	default:
+		return fmt.Errorf("one of batchIds and configId is required")
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReexportRequestValidate(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name string
+		req  ReexportRequest
+		err  bool
+	}{
+		{name: "batch ids", req: ReexportRequest{BatchIDs: []int64{1, 2}}},
+		{name: "config", req: ReexportRequest{ConfigID: 1, From: now.Add(-time.Hour), Thru: now}},
+		{name: "config without thru", req: ReexportRequest{ConfigID: 1, From: now.Add(-time.Hour)}},
+		{name: "empty", req: ReexportRequest{}, err: true},
+		{name: "both", req: ReexportRequest{BatchIDs: []int64{1}, ConfigID: 1}, err: true},
+		{name: "thru before from", req: ReexportRequest{ConfigID: 1, From: now, Thru: now.Add(-time.Hour)}, err: true},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			err := c.req.validate()
+			if (err != nil) != c.err {
+				t.Fatalf("validate() = %v, want error %v", err, c.err)
+			}
+			if err == nil && c.req.ConfigID != 0 && c.req.Thru.IsZero() {
+				t.Errorf("thru was not defaulted")
+			}
+		})
+	}
+}
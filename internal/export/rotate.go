@@ -0,0 +1,254 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/audit"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/signing"
+)
+
+// Signing key states reported by SigningKeysHandler.
+const (
+	signingKeyPending  = "pending"
+	signingKeyActive   = "active"
+	signingKeyRetiring = "retiring"
+	signingKeyRetired  = "retired"
+)
+
+// RotatedKey describes a signing key rotated by RotateKeysHandler.
+type RotatedKey struct {
+	OldSignatureInfoID int64     `json:"oldSignatureInfoId"`
+	NewSignatureInfoID int64     `json:"newSignatureInfoId"`
+	NewSigningKey      string    `json:"newSigningKey"`
+	NewKeyVersion      string    `json:"newKeyVersion"`
+	ActiveFrom         time.Time `json:"activeFrom"`
+	OldRetiresAt       time.Time `json:"oldRetiresAt"`
+}
+
+// RotateKeysResponse lists the keys rotated by RotateKeysHandler, and the
+// errors of the keys that couldn't be rotated.
+type RotateKeysResponse struct {
+	Rotated []*RotatedKey `json:"rotated"`
+	Errors  []string      `json:"errors,omitempty"`
+}
+
+// SigningKey is the state of a signing key, as reported by SigningKeysHandler.
+type SigningKey struct {
+	SignatureInfoID int64      `json:"signatureInfoId"`
+	SigningKey      string     `json:"signingKey"`
+	KeyID           string     `json:"keyId"`
+	KeyVersion      string     `json:"keyVersion"`
+	From            *time.Time `json:"from,omitempty"`
+	Thru            *time.Time `json:"thru,omitempty"`
+	AutoRotate      bool       `json:"autoRotate"`
+	RotatedToID     int64      `json:"rotatedToId,omitempty"`
+	State           string     `json:"state"`
+}
+
+// RotateKeysHandler rotates the signing keys that are marked for automatic
+// rotation and have signed for SIGNING_KEY_ROTATION_PERIOD. It is meant to be
+// called periodically by a scheduler. For each key, it creates a new key
+// version in the key manager, and registers it as a new signature info of the
+// same export configs that starts signing after SIGNING_KEY_ACTIVATION_DELAY.
+// Both keys sign for SIGNING_KEY_ROTATION_OVERLAP, after which the old key is
+// retired.
+func (s *Server) RotateKeysHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+
+	if s.config.KeyRotationPeriod == 0 {
+		msg := "Automatic signing key rotation is disabled, set SIGNING_KEY_ROTATION_PERIOD to enable it."
+		logger.Info(msg)
+		fmt.Fprintln(w, msg)
+		return
+	}
+
+	resp, err := s.RotateKeys(ctx)
+	if err != nil {
+		http.Error(w, "Failed to list signing keys, check logs.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(resp.Errors) > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf("Failed to write response: %v", err)
+	}
+}
+
+// RotateKeys rotates the signing keys that are due, see RotateKeysHandler. The
+// keys that failed to rotate are listed in the response's Errors. It does
+// nothing if SIGNING_KEY_ROTATION_PERIOD is zero.
+func (s *Server) RotateKeys(ctx context.Context) (*RotateKeysResponse, error) {
+	ctx = audit.WithActor(ctx, audit.SystemActor("export"))
+	logger := logging.FromContext(ctx)
+
+	resp := &RotateKeysResponse{Rotated: []*RotatedKey{}}
+	if s.config.KeyRotationPeriod == 0 {
+		return resp, nil
+	}
+
+	now := time.Now()
+	sigInfos, err := s.db.ListSignatureInfosToRotate(ctx, now.Add(-s.config.KeyRotationPeriod), now)
+	if err != nil {
+		logger.Errorf("Failed to list signing keys to rotate: %v", err)
+		return nil, fmt.Errorf("listing signing keys to rotate: %w", err)
+	}
+
+	for _, si := range sigInfos {
+		rk, err := s.rotateKey(ctx, si, now)
+		if err != nil {
+			logger.Errorf("Failed to rotate signing key of signature info %d: %v", si.ID, err)
+			s.env.MetricsExporter(ctx).WriteInt("export-key-rotation-failed", true, 1)
+			resp.Errors = append(resp.Errors, fmt.Sprintf("signature info %d: %v", si.ID, err))
+			continue
+		}
+		logger.Infof("Rotated signing key of signature info %d to signature info %d", rk.OldSignatureInfoID, rk.NewSignatureInfoID)
+		s.env.MetricsExporter(ctx).WriteInt("export-key-rotated", true, 1)
+		audit.Record(ctx, s.db, audit.ActionRotate, audit.ResourceSignatureInfo, strconv.FormatInt(rk.OldSignatureInfoID, 10), rk)
+		resp.Rotated = append(resp.Rotated, rk)
+	}
+	return resp, nil
+}
+
+// rotateKey creates a new version of the signing key of si and registers it.
+func (s *Server) rotateKey(ctx context.Context, si *database.SignatureInfo, now time.Time) (*RotatedKey, error) {
+	km, keyID, err := s.env.KeyManagerForKey(ctx, si.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	creator, ok := km.(signing.KeyVersionCreator)
+	if !ok {
+		return nil, fmt.Errorf("key manager %T cannot create key versions", km)
+	}
+	newKeyID, err := creator.CreateKeyVersion(ctx, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("creating key version: %w", err)
+	}
+
+	next := &database.SignatureInfo{
+		// Keep the key manager prefix of the signing key, if any.
+		SigningKey:        strings.TrimSuffix(si.SigningKey, keyID) + newKeyID,
+		AppPackageName:    si.AppPackageName,
+		BundleID:          si.BundleID,
+		SigningKeyID:      si.SigningKeyID,
+		SigningKeyVersion: nextKeyVersion(si.SigningKeyVersion),
+		StartTimestamp:    now.Add(s.config.KeyActivationDelay),
+		AutoRotate:        true,
+	}
+	retireAt := next.StartTimestamp.Add(s.config.KeyRotationOverlap)
+	if err := s.db.RotateSignatureInfo(ctx, si.ID, next, retireAt); err != nil {
+		return nil, fmt.Errorf("registering new key version %v, which is unused: %w", newKeyID, err)
+	}
+
+	return &RotatedKey{
+		OldSignatureInfoID: si.ID,
+		NewSignatureInfoID: next.ID,
+		NewSigningKey:      next.SigningKey,
+		NewKeyVersion:      next.SigningKeyVersion,
+		ActiveFrom:         next.StartTimestamp,
+		OldRetiresAt:       retireAt,
+	}, nil
+}
+
+var trailingNumber = regexp.MustCompile(`\d+$`)
+
+// nextKeyVersion returns the verification key version of the next version of
+// a key, such as "v2" after "v1". Versions that don't end in a number get a
+// "2" appended.
+func nextKeyVersion(version string) string {
+	loc := trailingNumber.FindStringIndex(version)
+	if loc == nil {
+		return version + "2"
+	}
+	n, err := strconv.Atoi(version[loc[0]:])
+	if err != nil {
+		return version + "2"
+	}
+	return version[:loc[0]] + strconv.Itoa(n+1)
+}
+
+// SigningKeysHandler lists every signing key and whether it is pending,
+// active, retiring, or retired, so admins can follow key rotations.
+func (s *Server) SigningKeysHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sigInfos, err := s.db.ListSignatureInfos(ctx)
+	if err != nil {
+		logger.Errorf("Failed to list signing keys: %v", err)
+		http.Error(w, "Failed to list signing keys, check logs.", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	keys := make([]*SigningKey, 0, len(sigInfos))
+	for _, si := range sigInfos {
+		keys = append(keys, newSigningKey(si, now))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		logger.Errorf("Failed to write response: %v", err)
+	}
+}
+
+// newSigningKey returns the state of the key of si at now.
+func newSigningKey(si *database.SignatureInfo, now time.Time) *SigningKey {
+	k := &SigningKey{
+		SignatureInfoID: si.ID,
+		SigningKey:      si.SigningKey,
+		KeyID:           si.SigningKeyID,
+		KeyVersion:      si.SigningKeyVersion,
+		AutoRotate:      si.AutoRotate,
+		RotatedToID:     si.RotatedToID,
+		State:           signingKeyActive,
+	}
+	if !si.StartTimestamp.IsZero() {
+		from := si.StartTimestamp
+		k.From = &from
+	}
+	if !si.EndTimestamp.IsZero() {
+		thru := si.EndTimestamp
+		k.Thru = &thru
+	}
+
+	switch {
+	case k.From != nil && now.Before(*k.From):
+		k.State = signingKeyPending
+	case k.Thru != nil && now.After(*k.Thru):
+		k.State = signingKeyRetired
+	case k.Thru != nil:
+		k.State = signingKeyRetiring
+	}
+	return k
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+)
+
+func TestNextKeyVersion(t *testing.T) {
+	cases := map[string]string{
+		"v1":      "v2",
+		"v9":      "v10",
+		"7":       "8",
+		"2020-v3": "2020-v4",
+		"":        "2",
+		"main":    "main2",
+	}
+	for version, want := range cases {
+		if got := nextKeyVersion(version); got != want {
+			t.Errorf("nextKeyVersion(%q) = %q, want %q", version, got, want)
+		}
+	}
+}
+
+func TestNewSigningKeyState(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	cases := []struct {
+		name string
+		si   *database.SignatureInfo
+		want string
+	}{
+		{name: "no range", si: &database.SignatureInfo{}, want: signingKeyActive},
+		{name: "started", si: &database.SignatureInfo{StartTimestamp: now.Add(-day)}, want: signingKeyActive},
+		{name: "not started", si: &database.SignatureInfo{StartTimestamp: now.Add(day)}, want: signingKeyPending},
+		{name: "expiring", si: &database.SignatureInfo{EndTimestamp: now.Add(day)}, want: signingKeyRetiring},
+		{name: "expires now", si: &database.SignatureInfo{EndTimestamp: now}, want: signingKeyRetiring},
+		{name: "expired", si: &database.SignatureInfo{EndTimestamp: now.Add(-day)}, want: signingKeyRetired},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := newSigningKey(c.si, now).State; got != c.want {
+				t.Errorf("got state %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -15,9 +15,14 @@
 package export
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 
+	"github.com/google/exposure-notifications-server/internal/cache"
 	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/scheduler"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
 )
 
@@ -33,12 +38,33 @@ func NewServer(config *Config, env *serverenv.ServerEnv) (*Server, error) {
 	if config.MinWindowAge < 0 {
 		return nil, fmt.Errorf("MIN_WINDOW_AGE must be a duration of >= 0")
 	}
+	if config.LookbackWindow < 0 {
+		return nil, fmt.Errorf("EXPORT_LOOKBACK_WINDOW must be a duration of >= 0")
+	}
+	if config.KeyRotationPeriod < 0 || config.KeyActivationDelay < 0 || config.KeyRotationOverlap < 0 {
+		return nil, fmt.Errorf("SIGNING_KEY_ROTATION_PERIOD, SIGNING_KEY_ACTIVATION_DELAY and SIGNING_KEY_ROTATION_OVERLAP must be durations of >= 0")
+	}
+	if config.MaxRecords <= 0 {
+		return nil, fmt.Errorf("EXPORT_FILE_MAX_RECORDS must be > 0")
+	}
+	if config.MinRecords+config.PaddingRange > config.MaxRecords {
+		return nil, fmt.Errorf("EXPORT_FILE_MIN_RECORDS + EXPORT_FILE_PADDING_RANGE must be <= EXPORT_FILE_MAX_RECORDS, so padded files stay within the limit")
+	}
 
-	return &Server{
+	s := &Server{
 		db:     env.Database(),
 		config: config,
 		env:    env,
-	}, nil
+	}
+	if config.ConfigCacheDuration > 0 {
+		s.configCache = cache.New(config.ConfigCacheDuration, 0, cache.NewShared(config.Cache, "exportconfig"))
+		env.RuntimeConfig().OnChange(database.ConfigTableExportConfig, func(ctx context.Context) {
+			if err := s.configCache.InvalidateAll(ctx); err != nil {
+				logging.FromContext(ctx).Errorf("Failed to invalidate export configs: %v", err)
+			}
+		})
+	}
+	return s, nil
 }
 
 // Server hosts end points to manage export batches.
@@ -46,4 +72,50 @@ type Server struct {
 	db     *database.DB
 	config *Config
 	env    *serverenv.ServerEnv
+
+	// configCache, if set, caches the export config lookups of downloads.
+	configCache *cache.Cache
+}
+
+// Jobs returns the scheduler jobs of the server whose intervals are set, for
+// deployments without a scheduler to call their handlers: batch creation every
+// CREATE_BATCHES_INTERVAL, batch export every EXPORT_WORKER_INTERVAL, and
+// signing key rotation every ROTATE_KEYS_INTERVAL if automatic rotation is
+// enabled.
+func (s *Server) Jobs() []*scheduler.Job {
+	var jobs []*scheduler.Job
+	if s.config.CreateBatchesInterval > 0 {
+		jobs = append(jobs, &scheduler.Job{
+			Name:     "export-create-batches",
+			Interval: s.config.CreateBatchesInterval,
+			Run:      s.CreateBatches,
+		})
+	}
+	if s.config.WorkerInterval > 0 {
+		jobs = append(jobs, &scheduler.Job{
+			Name:     "export-worker",
+			Interval: s.config.WorkerInterval,
+			Run: func(ctx context.Context) error {
+				s.doWork(ctx, ioutil.Discard)
+				return nil
+			},
+		})
+	}
+	if s.config.RotateKeysInterval > 0 && s.config.KeyRotationPeriod > 0 {
+		jobs = append(jobs, &scheduler.Job{
+			Name:     "export-rotate-keys",
+			Interval: s.config.RotateKeysInterval,
+			Run: func(ctx context.Context) error {
+				resp, err := s.RotateKeys(ctx)
+				if err != nil {
+					return err
+				}
+				if len(resp.Errors) > 0 {
+					return fmt.Errorf("failed to rotate %d signing keys", len(resp.Errors))
+				}
+				return nil
+			},
+		})
+	}
+	return jobs
 }
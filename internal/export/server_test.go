@@ -18,11 +18,13 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
 	"github.com/google/exposure-notifications-server/internal/signing"
 	"github.com/google/exposure-notifications-server/internal/storage"
+	"github.com/google/go-cmp/cmp"
 )
 
 // TestNewServer tests NewServer().
@@ -32,10 +34,13 @@ func TestNewServer(t *testing.T) {
 	emptyDB := &database.DB{}
 	ctx := context.Background()
 
+	fullEnv := serverenv.New(ctx, serverenv.WithBlobStorage(emptyStorage), serverenv.WithKeyManager(emptyKMS), serverenv.WithDatabase(emptyDB))
+
 	testCases := []struct {
-		name string
-		env  *serverenv.ServerEnv
-		err  error
+		name   string
+		env    *serverenv.ServerEnv
+		config *Config
+		err    error
 	}{
 		{
 			name: "nil Blobstore",
@@ -47,16 +52,38 @@ func TestNewServer(t *testing.T) {
 			env:  serverenv.New(ctx, serverenv.WithBlobStorage(emptyStorage)),
 			err:  fmt.Errorf("export.NewBatchServer requires KeyManager present in the ServerEnv"),
 		},
+		{
+			name:   "negative lookback",
+			env:    fullEnv,
+			config: &Config{LookbackWindow: -time.Hour, MaxRecords: 30000},
+			err:    fmt.Errorf("EXPORT_LOOKBACK_WINDOW must be a duration of >= 0"),
+		},
+		{
+			name:   "no max records",
+			env:    fullEnv,
+			config: &Config{},
+			err:    fmt.Errorf("EXPORT_FILE_MAX_RECORDS must be > 0"),
+		},
+		{
+			name:   "padding exceeds max records",
+			env:    fullEnv,
+			config: &Config{MinRecords: 1000, PaddingRange: 100, MaxRecords: 1050},
+			err:    fmt.Errorf("EXPORT_FILE_MIN_RECORDS + EXPORT_FILE_PADDING_RANGE must be <= EXPORT_FILE_MAX_RECORDS, so padded files stay within the limit"),
+		},
 		{
 			name: "Fully Specified",
-			env:  serverenv.New(ctx, serverenv.WithBlobStorage(emptyStorage), serverenv.WithKeyManager(emptyKMS), serverenv.WithDatabase(emptyDB)),
+			env:  fullEnv,
 			err:  nil,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			got, err := NewServer(&Config{}, tc.env)
+			config := tc.config
+			if config == nil {
+				config = &Config{MinRecords: 1000, PaddingRange: 100, MaxRecords: 30000}
+			}
+			got, err := NewServer(config, tc.env)
 			if tc.err != nil {
 				if err.Error() != tc.err.Error() {
 					t.Fatalf("got %+v: want %v", err, tc.err)
@@ -69,3 +96,39 @@ func TestNewServer(t *testing.T) {
 		})
 	}
 }
+
+func TestJobs(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *Config
+		want   []string
+	}{
+		{name: "none", config: &Config{}},
+		{
+			name:   "batcher and worker",
+			config: &Config{CreateBatchesInterval: time.Minute, WorkerInterval: time.Minute},
+			want:   []string{"export-create-batches", "export-worker"},
+		},
+		{
+			name:   "rotation disabled",
+			config: &Config{RotateKeysInterval: time.Hour},
+		},
+		{
+			name:   "rotation",
+			config: &Config{RotateKeysInterval: time.Hour, KeyRotationPeriod: 720 * time.Hour},
+			want:   []string{"export-rotate-keys"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &Server{config: c.config}
+			var got []string
+			for _, job := range s.Jobs() {
+				got = append(got, job.Name)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
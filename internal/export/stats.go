@@ -0,0 +1,112 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+)
+
+// defaultStatsRange is how far back StatsHandler reports when no start is
+// given.
+const defaultStatsRange = 7 * 24 * time.Hour
+
+// StatsRequest selects the export batches to report on: those that start in
+// [From, Thru), optionally only those of one export config.
+type StatsRequest struct {
+	ConfigID int64
+	From     time.Time
+	Thru     time.Time
+}
+
+// StatsResponse lists the stats of the selected export batches.
+type StatsResponse struct {
+	Batches []*database.ExportBatchStats `json:"batches"`
+}
+
+// StatsHandler reports what was published in each export batch: the number of
+// keys by report type, the keys dropped by the export config's filters, the
+// padding added, and the size of the files. The batches are selected with the
+// configId, from and thru query parameters; from and thru are RFC3339
+// timestamps that default to a week ago and now.
+func (s *Server) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := parseStatsRequest(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := s.db.ListExportBatchStats(ctx, req.ConfigID, req.From, req.Thru)
+	if err != nil {
+		logger.Errorf("Failed to list export batch stats: %v", err)
+		http.Error(w, "Failed to list stats, check logs.", http.StatusInternalServerError)
+		return
+	}
+
+	if stats == nil {
+		stats = []*database.ExportBatchStats{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&StatsResponse{Batches: stats}); err != nil {
+		logger.Errorf("Failed to write response: %v", err)
+	}
+}
+
+// parseStatsRequest parses the query parameters of a stats request, defaulting
+// the time range to the week before now.
+func parseStatsRequest(q url.Values, now time.Time) (*StatsRequest, error) {
+	req := &StatsRequest{Thru: now}
+	if v := q.Get("configId"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid configId %q", v)
+		}
+		req.ConfigID = id
+	}
+	if v := q.Get("thru"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid thru %q: %w", v, err)
+		}
+		req.Thru = t
+	}
+	req.From = req.Thru.Add(-defaultStatsRange)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from %q: %w", v, err)
+		}
+		req.From = t
+	}
+	if req.Thru.Before(req.From) {
+		return nil, fmt.Errorf("thru must not be before from")
+	}
+	return req, nil
+}
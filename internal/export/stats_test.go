@@ -0,0 +1,77 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseStatsRequest(t *testing.T) {
+	now := time.Date(2020, 5, 8, 12, 0, 0, 0, time.UTC)
+	day := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name    string
+		query   string
+		want    *StatsRequest
+		wantErr bool
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			want:  &StatsRequest{From: now.Add(-7 * 24 * time.Hour), Thru: now},
+		},
+		{
+			name:  "config and range",
+			query: "configId=3&from=2020-05-01T00:00:00Z&thru=2020-05-02T00:00:00Z",
+			want:  &StatsRequest{ConfigID: 3, From: day, Thru: day.Add(24 * time.Hour)},
+		},
+		{
+			name:  "thru only",
+			query: "thru=2020-05-02T00:00:00Z",
+			want:  &StatsRequest{From: day.Add(-6 * 24 * time.Hour), Thru: day.Add(24 * time.Hour)},
+		},
+		{name: "invalid config", query: "configId=abc", wantErr: true},
+		{name: "negative config", query: "configId=-1", wantErr: true},
+		{name: "invalid from", query: "from=yesterday", wantErr: true},
+		{name: "thru before from", query: "from=2020-05-02T00:00:00Z&thru=2020-05-01T00:00:00Z", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			q, err := url.ParseQuery(c.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseStatsRequest(q, now)
+			if c.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
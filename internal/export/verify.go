@@ -0,0 +1,129 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnverifiedExport is returned by VerifyExportSignatures if a signature of
+// an export file is missing, is by an unknown key, or doesn't verify.
+var ErrUnverifiedExport = errors.New("export file signatures do not verify")
+
+// VerificationKey is a public key that export files are verified against. It
+// is identified like in the files' signature infos, by the key ID and
+// version that devices use to look it up.
+type VerificationKey struct {
+	KeyID string
+	// KeyVersion, if empty, matches signatures of any version of the key.
+	KeyVersion string
+	PublicKey  *ecdsa.PublicKey
+}
+
+// VerifiedExport describes a published export file and the verification of
+// each of its signatures.
+type VerifiedExport struct {
+	Region         string
+	StartTimestamp time.Time
+	EndTimestamp   time.Time
+	BatchNum       int
+	BatchSize      int
+	NumKeys        int
+	Signatures     []*VerifiedSignature
+}
+
+// VerifiedSignature is the outcome of verifying one signature of an export
+// file.
+type VerifiedSignature struct {
+	KeyID      string
+	KeyVersion string
+	Verified   bool
+	// Reason explains why the signature isn't verified.
+	Reason string
+}
+
+// VerifyExportSignatures parses a published export file, and verifies each of
+// its signatures against the key with the same key ID and version. It is meant
+// for auditing what the server published, without access to its database or
+// key manager. The description of the file is returned even if a signature
+// doesn't verify, along with ErrUnverifiedExport; an error is returned alone
+// if the file can't be parsed.
+func VerifyExportSignatures(data []byte, keys []*VerificationKey) (*VerifiedExport, error) {
+	bin, sig, err := readExportArchive(data)
+	if err != nil {
+		return nil, err
+	}
+	contents, signatures, err := unmarshalExportArchive(bin, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	ve := &VerifiedExport{
+		Region:         contents.GetRegion(),
+		StartTimestamp: time.Unix(int64(contents.GetStartTimestamp()), 0).UTC(),
+		EndTimestamp:   time.Unix(int64(contents.GetEndTimestamp()), 0).UTC(),
+		BatchNum:       int(contents.GetBatchNum()),
+		BatchSize:      int(contents.GetBatchSize()),
+		NumKeys:        len(contents.Keys),
+	}
+
+	digest := sha256.Sum256(bin)
+	unverified := len(signatures.Signatures) == 0
+	for _, s := range signatures.Signatures {
+		vs := &VerifiedSignature{
+			KeyID:      s.GetSignatureInfo().GetVerificationKeyId(),
+			KeyVersion: s.GetSignatureInfo().GetVerificationKeyVersion(),
+		}
+		ve.Signatures = append(ve.Signatures, vs)
+
+		key := findVerificationKey(keys, vs.KeyID, vs.KeyVersion)
+		switch {
+		case key == nil:
+			vs.Reason = "no public key for this key ID and version"
+		case s.GetSignatureInfo().GetSignatureAlgorithm() != algorithm:
+			vs.Reason = fmt.Sprintf("unsupported signature algorithm %q", s.GetSignatureInfo().GetSignatureAlgorithm())
+		case s.GetBatchNum() != contents.GetBatchNum() || s.GetBatchSize() != contents.GetBatchSize():
+			vs.Reason = fmt.Sprintf("signature is for batch %d of %d", s.GetBatchNum(), s.GetBatchSize())
+		default:
+			if err := verifyECDSASignature(key.PublicKey, digest[:], s.Signature); err != nil {
+				vs.Reason = fmt.Sprintf("signature %v", err)
+			} else {
+				vs.Verified = true
+			}
+		}
+		if !vs.Verified {
+			unverified = true
+		}
+	}
+
+	if unverified {
+		return ve, ErrUnverifiedExport
+	}
+	return ve, nil
+}
+
+// findVerificationKey returns the key with the given ID and version, or nil.
+func findVerificationKey(keys []*VerificationKey, keyID, keyVersion string) *VerificationKey {
+	for _, k := range keys {
+		if k.KeyID == keyID && (k.KeyVersion == "" || k.KeyVersion == keyVersion) {
+			return k
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestVerifyExportSignatures(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers := []ExportSigners{
+		{SignatureInfo: &database.SignatureInfo{SigningKeyID: "310", SigningKeyVersion: "v1"}, Signer: key},
+		{SignatureInfo: &database.SignatureInfo{SigningKeyID: "310", SigningKeyVersion: "v2"}, Signer: newKey},
+	}
+
+	eb := &database.ExportBatch{
+		StartTimestamp: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+		EndTimestamp:   time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC),
+		Region:         "US",
+	}
+	exposures := []*database.Exposure{
+		{ExposureKey: bytes.Repeat([]byte{1}, 16), IntervalNumber: 2649024, IntervalCount: 144, TransmissionRisk: 2},
+	}
+	data, err := MarshalExportFile(eb, exposures, 1, 1, signers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := VerifiedExport{
+		Region:         "US",
+		StartTimestamp: eb.StartTimestamp,
+		EndTimestamp:   eb.EndTimestamp,
+		BatchNum:       1,
+		BatchSize:      1,
+		NumKeys:        1,
+	}
+	withSignatures := func(sigs ...*VerifiedSignature) *VerifiedExport {
+		ve := file
+		ve.Signatures = sigs
+		return &ve
+	}
+	v1 := &VerificationKey{KeyID: "310", KeyVersion: "v1", PublicKey: &key.PublicKey}
+	v2 := &VerificationKey{KeyID: "310", KeyVersion: "v2", PublicKey: &newKey.PublicKey}
+
+	cases := []struct {
+		name    string
+		keys    []*VerificationKey
+		want    *VerifiedExport
+		wantErr error
+	}{
+		{
+			name: "all keys",
+			keys: []*VerificationKey{v1, v2},
+			want: withSignatures(
+				&VerifiedSignature{KeyID: "310", KeyVersion: "v1", Verified: true},
+				&VerifiedSignature{KeyID: "310", KeyVersion: "v2", Verified: true},
+			),
+		},
+		{
+			name: "missing key",
+			keys: []*VerificationKey{v1},
+			want: withSignatures(
+				&VerifiedSignature{KeyID: "310", KeyVersion: "v1", Verified: true},
+				&VerifiedSignature{KeyID: "310", KeyVersion: "v2", Reason: "no public key for this key ID and version"},
+			),
+			wantErr: ErrUnverifiedExport,
+		},
+		{
+			name: "any version",
+			keys: []*VerificationKey{{KeyID: "310", PublicKey: &key.PublicKey}},
+			want: withSignatures(
+				&VerifiedSignature{KeyID: "310", KeyVersion: "v1", Verified: true},
+				&VerifiedSignature{KeyID: "310", KeyVersion: "v2", Reason: "signature does not verify"},
+			),
+			wantErr: ErrUnverifiedExport,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := VerifyExportSignatures(data, c.keys)
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("got error %v, want %v", err, c.wantErr)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := VerifyExportSignatures([]byte("nope"), []*VerificationKey{v1}); err == nil || errors.Is(err, ErrUnverifiedExport) {
+		t.Errorf("expected parse error, got %v", err)
+	}
+}
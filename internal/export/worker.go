@@ -17,16 +17,24 @@ package export
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"math/big"
 	"net/http"
-	"sort"
-	"strings"
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/observability"
+	"github.com/google/exposure-notifications-server/internal/server"
+	"github.com/google/exposure-notifications-server/internal/storage"
 	"github.com/google/exposure-notifications-server/internal/util"
+
+	"go.opencensus.io/trace"
 )
 
 const (
@@ -36,7 +44,20 @@ const (
 
 // WorkerHandler is a handler to iterate the rows of ExportBatch, and creates GCS files.
 func (s *Server) WorkerHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), s.config.WorkerTimeout)
+	s.doWork(r.Context(), w)
+}
+
+// RunWorker exports the batches that are ready, as WorkerHandler does, for
+// callers outside of a server.
+func (s *Server) RunWorker(ctx context.Context, w io.Writer) {
+	s.doWork(ctx, w)
+}
+
+// doWork exports the batches that are ready until there are none left or
+// WORKER_TIMEOUT passes, and writes its progress to w. A batch that fails is
+// left for the next run.
+func (s *Server) doWork(ctx context.Context, w io.Writer) {
+	ctx, cancel := context.WithTimeout(ctx, s.config.WorkerTimeout)
 	defer cancel()
 	logger := logging.FromContext(ctx)
 
@@ -48,24 +69,37 @@ func (s *Server) WorkerHandler(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintln(w, msg)
 			return
 		}
+		if server.IsDraining(ctx) {
+			// The batches done so far are complete, the rest are claimed by
+			// the next invocation.
+			msg := "Shutting down. Will continue on next invocation."
+			logger.Info(msg)
+			fmt.Fprintln(w, msg)
+			return
+		}
 
 		// Only consider batches that closed a few minutes ago to allow the publish windows to close properly.
 		minutesAgo := time.Now().Add(-5 * time.Minute)
 
-		// Check for a batch and obtain a lease for it.
-		batch, err := s.db.LeaseBatch(ctx, s.config.WorkerTimeout, minutesAgo)
+		// Check for a batch and obtain a lease for it. If this worker gets
+		// stuck, the lease expires and another worker claims the batch.
+		lease, err := s.db.ClaimBatch(ctx, s.config.WorkerTimeout, minutesAgo)
 		if err != nil {
 			logger.Errorf("Failed to lease batch: %v", err)
 			continue
 		}
-		if batch == nil {
+		if lease == nil {
 			msg := "No more work to do"
 			logger.Info(msg)
 			fmt.Fprintln(w, msg)
 			return
 		}
 
-		if err = s.exportBatch(ctx, batch, emitIndexForEmptyBatch); err != nil {
+		batch := lease.Batch
+		start := time.Now()
+		err = s.exportBatch(ctx, lease, emitIndexForEmptyBatch)
+		metrics.ExportBatchDuration.WithLabelValues(metrics.Result(err)).Observe(time.Since(start).Seconds())
+		if err != nil {
 			logger.Errorf("Failed to create files for batch: %v.", err)
 			continue
 		}
@@ -79,25 +113,64 @@ func (s *Server) WorkerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) exportBatch(ctx context.Context, eb *database.ExportBatch, emitIndexForEmptyBatch bool) error {
+// maxRecords returns the maximum number of keys in an export file. It may be
+// changed at runtime with EXPORT_FILE_MAX_RECORDS, as long as padded files
+// stay within it.
+func (s *Server) maxRecords(ctx context.Context) int {
+	max := s.env.RuntimeConfig().Int("EXPORT_FILE_MAX_RECORDS", s.config.MaxRecords)
+	if max != s.config.MaxRecords && (max <= 0 || s.config.MinRecords+s.config.PaddingRange > max) {
+		logging.FromContext(ctx).Warnf("Ignoring runtime EXPORT_FILE_MAX_RECORDS %d, must be >= EXPORT_FILE_MIN_RECORDS + EXPORT_FILE_PADDING_RANGE", max)
+		return s.config.MaxRecords
+	}
+	return max
+}
+
+func (s *Server) exportBatch(ctx context.Context, lease *database.BatchLease, emitIndexForEmptyBatch bool) (err error) {
+	ctx, span := observability.StartSpan(ctx, "export.exportBatch")
+	defer observability.EndSpan(span, &err)
+
 	logger := logging.FromContext(ctx)
-	logger.Infof("Processing export batch %d (root: %q, region: %s), max records per file %d", eb.BatchID, eb.FilenameRoot, eb.Region, s.config.MaxRecords)
+	eb := lease.Batch
+	span.AddAttributes(
+		trace.Int64Attribute("batch_id", eb.BatchID),
+		trace.StringAttribute("region", eb.Region))
+	maxRecords := s.maxRecords(ctx)
+	logger.Infof("Processing export batch %d (root: %q, region: %s), max records per file %d", eb.BatchID, eb.FilenameRoot, eb.Region, maxRecords)
+
+	// The exposures of a region with data residency are read from its own
+	// database, and may only be written to its own bucket.
+	if bucket, ok := s.config.RegionBuckets[eb.Region]; ok && bucket != eb.BucketName {
+		s.env.MetricsExporter(ctx).WriteInt("export-worker-residency-violation", true, 1)
+		return fmt.Errorf("batch %d of region %s writes to bucket %q, but EXPORT_REGION_BUCKETS requires %q", eb.BatchID, eb.Region, eb.BucketName, bucket)
+	}
+	store := s.db.ForRegion(eb.Region)
 
-	criteria := database.IterateExposuresCriteria{
-		SinceTimestamp:      eb.StartTimestamp,
-		UntilTimestamp:      eb.EndTimestamp,
-		IncludeRegions:      []string{eb.Region},
-		OnlyLocalProvenance: false, // include federated ids
+	var horizon int64
+	if s.config.LookbackWindow > 0 && eb.DeltaOfConfigID == 0 {
+		if horizon, err = s.db.PreviousExportBatchHorizon(ctx, eb); err != nil {
+			return fmt.Errorf("looking up the previous batch of batch %d: %w", eb.BatchID, err)
+		}
 	}
+	criteria := exportCriteria(eb, s.config.LookbackWindow, horizon)
 
 	// Build up groups of exposures in memory. We need to use memory so we can determine the
 	// total number of groups (which is embedded in each export file). This technique avoids
 	// SELECT COUNT which would lock the database slowing new uploads.
 	var groups [][]*database.Exposure
 	var exposures []*database.Exposure
-	_, err := s.db.IterateExposures(ctx, criteria, func(exp *database.Exposure) error {
+	stats := &database.ExportBatchStats{
+		BatchID:          eb.BatchID,
+		ConfigID:         eb.ConfigID,
+		Region:           eb.Region,
+		StartTimestamp:   eb.StartTimestamp,
+		EndTimestamp:     eb.EndTimestamp,
+		KeysByReportType: make(map[string]int),
+	}
+	eb.ExposureHorizon, err = store.IterateExposuresWithHorizon(ctx, criteria, func(exp *database.Exposure) error {
+		stats.Keys++
+		stats.KeysByReportType[exp.ReportType]++
 		exposures = append(exposures, exp)
-		if len(exposures) == s.config.MaxRecords {
+		if len(exposures) == maxRecords {
 			groups = append(groups, exposures)
 			exposures = nil
 		}
@@ -113,22 +186,42 @@ func (s *Server) exportBatch(ctx context.Context, eb *database.ExportBatch, emit
 
 	if len(groups) == 0 {
 		logger.Infof("No records for export batch %d", eb.BatchID)
+	} else {
+		// Only the last file can hold fewer than MaxRecords keys, so it is the one
+		// that gets padded.
+		last := len(groups) - 1
+		groups[last], err = ensureMinNumExposures(groups[last], eb.Region, s.config.MinRecords, s.config.PaddingRange)
+		if err != nil {
+			return fmt.Errorf("ensureMinNumExposures: %w", err)
+		}
+		groups[last], err = quantizeExposures(groups[last], eb.Region, s.config.PaddingBucket, maxRecords)
+		if err != nil {
+			return fmt.Errorf("quantizeExposures: %w", err)
+		}
 	}
 
-	exposures, err = ensureMinNumExposures(exposures, eb.Region, s.config.MinRecords, s.config.PaddingRange)
-	if err != nil {
-		return fmt.Errorf("ensureMinNumExposures: %w", err)
+	for _, group := range groups {
+		stats.PaddingKeys += len(group)
 	}
+	stats.PaddingKeys -= stats.Keys
 
-	// Load the non-expired signature infos associated with this export batch.
+	// Load the signature infos associated with this export batch that are
+	// active now. During a key rotation this includes both the old and the new
+	// key, and each export file is signed by all of them.
 	sigInfos, err := s.db.LookupSignatureInfos(ctx, eb.SignatureInfoIDs, time.Now())
 	if err != nil {
 		return fmt.Errorf("error loading signature info for batch %d, %w", eb.BatchID, err)
 	}
+	if len(sigInfos) == 0 {
+		logger.Warnf("No active signature infos for batch %d, export files will be unsigned", eb.BatchID)
+		s.env.MetricsExporter(ctx).WriteInt("export-worker-unsigned-batch", true, 1)
+	}
 
 	// Create the export files.
 	batchSize := len(groups)
 	var objectNames []string
+	var objectSizes []int64
+	var objectChecksums []string
 	for i, exposures := range groups {
 		if ctx.Err() != nil {
 			logger.Infof("Timed out writing export files for batch %s, the entire batch will be retried once the batch lease expires on %v", eb.BatchID, eb.LeaseExpires)
@@ -136,10 +229,10 @@ func (s *Server) exportBatch(ctx context.Context, eb *database.ExportBatch, emit
 		}
 
 		// TODO(squee1945): Uploading in parallel (to a point) probably makes better use of network.
-		objectName, err := s.createFile(ctx,
+		file, err := s.createFile(ctx,
 			createFileInfo{
 				exposures:      exposures,
-				exportBatch:    eb,
+				lease:          lease,
 				signatureInfos: sigInfos,
 				batchNum:       i + 1,
 				batchSize:      batchSize,
@@ -147,138 +240,220 @@ func (s *Server) exportBatch(ctx context.Context, eb *database.ExportBatch, emit
 		if err != nil {
 			return fmt.Errorf("creating export file %d for batch %d: %w", i+1, eb.BatchID, err)
 		}
-		logger.Infof("Wrote export file %q for batch %d", objectName, eb.BatchID)
-		objectNames = append(objectNames, objectName)
+		logger.Infof("Wrote export file %q for batch %d", file.name, eb.BatchID)
+		objectNames = append(objectNames, file.name)
+		objectSizes = append(objectSizes, file.sizeBytes)
+		objectChecksums = append(objectChecksums, file.sha256)
+	}
+
+	// A re-exported batch already has files. They stay listed in the index until
+	// it is rewritten with the new ones.
+	previousFiles, err := s.db.LookupBatchExportFiles(ctx, eb.BatchID)
+	if err != nil {
+		return fmt.Errorf("looking up previous files of batch %d: %w", eb.BatchID, err)
 	}
 
 	// Emit the index file if needed.
-	if batchSize > 0 || emitIndexForEmptyBatch {
+	if batchSize > 0 || emitIndexForEmptyBatch || len(previousFiles) > 0 {
 		if err := s.retryingCreateIndex(ctx, eb, objectNames); err != nil {
 			return err
 		}
 	}
 
 	// Write the files records in database and complete the batch.
-	if err := s.db.FinalizeBatch(ctx, eb, objectNames, batchSize); err != nil {
+	if err := s.db.FinalizeClaimedBatch(ctx, lease, objectNames, objectSizes, objectChecksums, batchSize); err != nil {
+		if errors.Is(err, database.ErrLeaseLost) {
+			s.env.MetricsExporter(ctx).WriteInt("export-worker-lease-lost", true, 1)
+			return fmt.Errorf("completing batch %d: lease expired and the batch was claimed by another worker: %w", eb.BatchID, err)
+		}
 		return fmt.Errorf("completing batch: %w", err)
 	}
 	logger.Infof("Batch %d completed", eb.BatchID)
+
+	stats.Files = batchSize
+	for _, size := range objectSizes {
+		stats.SizeBytes += size
+	}
+	s.saveStats(ctx, criteria, stats)
+
+	if len(previousFiles) > 0 {
+		s.deleteReplacedFiles(ctx, eb, previousFiles, objectNames)
+	}
 	return nil
 }
 
-type createFileInfo struct {
-	exposures      []*database.Exposure
-	exportBatch    *database.ExportBatch
-	signatureInfos []*database.SignatureInfo
-	batchNum       int
-	batchSize      int
+// exportCriteria returns the criteria of the exposures exported in eb. Keys
+// that arrive after their batch was exported are picked up by looking back
+// lookback before eb's start, but only those that weren't visible to the
+// previous batch's read, whose change horizon is horizon, so that no key is
+// exported twice. If horizon is unknown, all keys in the lookback window are
+// exported again. Delta batches only contain the keys added since the
+// previous batch, so they don't look back.
+func exportCriteria(eb *database.ExportBatch, lookback time.Duration, horizon int64) database.IterateExposuresCriteria {
+	criteria := database.IterateExposuresCriteria{
+		SinceTimestamp:      eb.StartTimestamp,
+		UntilTimestamp:      eb.EndTimestamp,
+		IncludeRegions:      []string{eb.Region},
+		OnlyLocalProvenance: false, // include federated ids
+		ReadPreference:      database.ReadReplica,
+		HealthAuthorityID:   eb.HealthAuthorityID,
+		IncludeTravelers:    eb.IncludeTravelers,
+		IncludeReportTypes:  eb.IncludeReportTypes,
+		MinTransmissionRisk: eb.MinTransmissionRisk,
+	}
+	if lookback > 0 && eb.DeltaOfConfigID == 0 {
+		criteria.SinceTimestamp = eb.StartTimestamp.Add(-lookback)
+		if horizon > 0 {
+			criteria.LookbackUntil = eb.StartTimestamp
+			criteria.LookbackHorizon = horizon
+		}
+	}
+	return criteria
 }
 
-func (s *Server) createFile(ctx context.Context, cfi createFileInfo) (string, error) {
+// saveStats records the stats of a completed batch. The keys that the export
+// config's filters dropped are only counted if it has any filters. Failures
+// are only logged, as the batch is already complete.
+func (s *Server) saveStats(ctx context.Context, criteria database.IterateExposuresCriteria, stats *database.ExportBatchStats) {
 	logger := logging.FromContext(ctx)
 
-	var signers []ExportSigners
-	for _, si := range cfi.signatureInfos {
-		signer, err := s.env.GetSignerForKey(ctx, si.SigningKey)
+	if criteria.HealthAuthorityID != "" || len(criteria.IncludeReportTypes) > 0 || criteria.MinTransmissionRisk > 0 {
+		unfiltered := criteria
+		unfiltered.HealthAuthorityID = ""
+		unfiltered.IncludeTravelers = false
+		unfiltered.IncludeReportTypes = nil
+		unfiltered.MinTransmissionRisk = 0
+		total, err := s.db.ForRegion(stats.Region).CountExposures(ctx, unfiltered)
 		if err != nil {
-			return "", fmt.Errorf("unable to get signer for key %v: %w", si.SigningKey, err)
+			logger.Errorf("Failed to count the keys dropped from batch %d: %v", stats.BatchID, err)
+			s.env.MetricsExporter(ctx).WriteInt("export-worker-stats-failed", true, 1)
+			return
 		}
-		signers = append(signers, ExportSigners{SignatureInfo: si, Signer: signer})
-	}
-
-	// Generate exposure key export file.
-	data, err := MarshalExportFile(cfi.exportBatch, cfi.exposures, cfi.batchNum, cfi.batchSize, signers)
-	if err != nil {
-		return "", fmt.Errorf("marshalling export file: %w", err)
+		stats.DroppedKeys = int(total) - stats.Keys
 	}
 
-	// Write to GCS.
-	objectName := exportFilename(cfi.exportBatch, cfi.batchNum)
-	logger.Infof("Created file %v, signed with %v keys", objectName, len(signers))
-	ctx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
-	defer cancel()
-	if err := s.env.Blobstore().CreateObject(ctx, cfi.exportBatch.BucketName, objectName, data); err != nil {
-		return "", fmt.Errorf("creating file %s in bucket %s: %w", objectName, cfi.exportBatch.BucketName, err)
+	stats.UpdatedAt = time.Now()
+	if err := s.db.SaveExportBatchStats(ctx, stats); err != nil {
+		logger.Errorf("Failed to save stats of batch %d: %v", stats.BatchID, err)
+		s.env.MetricsExporter(ctx).WriteInt("export-worker-stats-failed", true, 1)
 	}
-	return objectName, nil
 }
 
-// retryingCreateIndex create the index file. The index file includes _all_ batches for an ExportConfig,
-// so multiple workers may be racing to update it. We use a lock to make them line up after one another.
-func (s *Server) retryingCreateIndex(ctx context.Context, eb *database.ExportBatch, objectNames []string) error {
+// deleteReplacedFiles deletes the files that a re-exported batch had before
+// and that weren't overwritten by its new files. The index no longer lists
+// them. Failures are only logged, as the batch is already complete.
+func (s *Server) deleteReplacedFiles(ctx context.Context, eb *database.ExportBatch, previousFiles, objectNames []string) {
 	logger := logging.FromContext(ctx)
 
-	lockID := fmt.Sprintf("export-batch-%d", eb.BatchID)
-	sleep := 10 * time.Second
-	for {
-		if ctx.Err() != nil {
-			logger.Infof("Timed out acquiring index file lock for batch %s, the entire batch will be retried once the batch lease expires on %v", eb.BatchID, eb.LeaseExpires)
-			return nil
+	current := make(map[string]struct{}, len(objectNames))
+	for _, name := range objectNames {
+		current[name] = struct{}{}
+	}
+	for _, name := range previousFiles {
+		if _, ok := current[name]; ok {
+			continue
 		}
-
-		unlock, err := s.db.Lock(ctx, lockID, time.Minute)
+		blobCtx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
+		err := s.env.Blobstore().DeleteObject(blobCtx, eb.BucketName, name)
+		cancel()
 		if err != nil {
-			if err == database.ErrAlreadyLocked {
-				logger.Debugf("Lock %s is locked; sleeping %v and will try again", lockID, sleep)
-				time.Sleep(sleep)
-				continue
-			}
-			return err
+			logger.Errorf("Failed to delete replaced export file %q of batch %d: %v", name, eb.BatchID, err)
+			continue
 		}
+		logger.Infof("Deleted replaced export file %q of batch %d", name, eb.BatchID)
+	}
+}
 
-		indexName, entries, err := s.createIndex(ctx, eb, objectNames)
+type createFileInfo struct {
+	exposures      []*database.Exposure
+	lease          *database.BatchLease
+	signatureInfos []*database.SignatureInfo
+	batchNum       int
+	batchSize      int
+}
+
+// createdFile is an export file written by createFile.
+type createdFile struct {
+	name      string
+	sizeBytes int64
+	sha256    string // hex encoded
+}
+
+// createFile writes an export file and reads it back to check that it was
+// stored intact.
+func (s *Server) createFile(ctx context.Context, cfi createFileInfo) (_ *createdFile, err error) {
+	ctx, span := observability.StartSpan(ctx, "export.createFile")
+	defer observability.EndSpan(span, &err)
+
+	logger := logging.FromContext(ctx)
+	eb := cfi.lease.Batch
+
+	var signers []ExportSigners
+	for _, si := range cfi.signatureInfos {
+		signer, err := s.env.GetSignerForKey(ctx, si.SigningKey)
 		if err != nil {
-			if err1 := unlock(); err1 != nil {
-				return fmt.Errorf("releasing lock: %v (original error: %w)", err1, err)
-			}
-			return fmt.Errorf("creating index file for batch %d: %w", eb.BatchID, err)
-		}
-		logger.Infof("Wrote index file %q with %d entries (triggered by batch %d)", indexName, entries, eb.BatchID)
-		if err := unlock(); err != nil {
-			return fmt.Errorf("releasing lock: %w", err)
+			return nil, fmt.Errorf("unable to get signer for key %v: %w", si.SigningKey, err)
 		}
-		break
+		signers = append(signers, ExportSigners{SignatureInfo: si, Signer: signer})
 	}
-	return nil
-}
 
-func (s *Server) createIndex(ctx context.Context, eb *database.ExportBatch, newObjectNames []string) (string, int, error) {
-	objects, err := s.db.LookupExportFiles(ctx, eb.ConfigID)
+	// Generate exposure key export file.
+	data, err := MarshalExportFile(eb, cfi.exposures, cfi.batchNum, cfi.batchSize, signers)
 	if err != nil {
-		return "", 0, fmt.Errorf("lookup existing export files for batch %d: %w", eb.BatchID, err)
+		return nil, fmt.Errorf("marshalling export file: %w", err)
 	}
 
-	// Add the new objects (they haven't been committed to the database yet).
-	objects = append(objects, newObjectNames...)
-
-	// Remove duplicates, sort.
-	m := map[string]struct{}{}
-	for _, o := range objects {
-		m[o] = struct{}{}
+	if err := VerifyExportFile(data, eb, len(cfi.exposures), cfi.batchNum, cfi.batchSize, signers); err != nil {
+		s.env.MetricsExporter(ctx).WriteInt("export-worker-verify-failed", true, 1)
+		return nil, fmt.Errorf("verifying export file: %w", err)
 	}
-	objects = nil
-	for k := range m {
-		objects = append(objects, k)
+	sum := sha256.Sum256(data)
+	file := &createdFile{
+		name:      exportFilename(eb, cfi.batchNum, cfi.batchSize),
+		sizeBytes: int64(len(data)),
+		sha256:    hex.EncodeToString(sum[:]),
 	}
-	sort.Strings(objects)
 
-	data := []byte(strings.Join(objects, "\n"))
-
-	indexObjectName := exportIndexFilename(eb)
+	// Write to GCS. The file may be cached, since its name is unique to its
+	// batch and it is only rewritten when the batch is re-exported.
+	logger.Infof("Created file %v, signed with %v keys", file.name, len(signers))
 	ctx, cancel := context.WithTimeout(ctx, blobOperationTimeout)
 	defer cancel()
-	if err := s.env.Blobstore().CreateObject(ctx, eb.BucketName, indexObjectName, data); err != nil {
-		return "", 0, fmt.Errorf("creating file %s in bucket %s: %w", indexObjectName, eb.BucketName, err)
+	blobstore := s.env.Blobstore()
+	err = blobstore.CreateObjectIfNotExists(ctx, eb.BucketName, file.name, data, true)
+	if errors.Is(err, storage.ErrObjectExists) {
+		// The file was written by an earlier attempt at this batch, or by a
+		// worker that still believes it holds the batch. Only overwrite it while
+		// this worker holds the lease.
+		if err := s.db.RenewBatchLease(ctx, cfi.lease, s.config.WorkerTimeout); err != nil {
+			if errors.Is(err, database.ErrLeaseLost) {
+				s.env.MetricsExporter(ctx).WriteInt("export-worker-lease-lost", true, 1)
+			}
+			return nil, fmt.Errorf("file %s already exists, checking batch lease: %w", file.name, err)
+		}
+		logger.Infof("Overwriting existing file %v", file.name)
+		err = blobstore.CreateObject(ctx, eb.BucketName, file.name, data, true)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating file %s in bucket %s: %w", file.name, eb.BucketName, err)
 	}
-	return indexObjectName, len(objects), nil
-}
 
-func exportFilename(eb *database.ExportBatch, batchNum int) string {
-	return fmt.Sprintf("%s/%d-%05d%s", eb.FilenameRoot, eb.StartTimestamp.Unix(), batchNum, filenameSuffix)
+	// Check that the stored file is the one that was verified above.
+	stored, err := blobstore.GetObject(ctx, eb.BucketName, file.name)
+	if err != nil {
+		return nil, fmt.Errorf("reading back file %s in bucket %s: %w", file.name, eb.BucketName, err)
+	}
+	if storedSum := sha256.Sum256(stored); storedSum != sum {
+		s.env.MetricsExporter(ctx).WriteInt("export-worker-checksum-mismatch", true, 1)
+		return nil, fmt.Errorf("file %s in bucket %s has SHA-256 %x, want %s", file.name, eb.BucketName, storedSum, file.sha256)
+	}
+	return file, nil
 }
 
-func exportIndexFilename(eb *database.ExportBatch) string {
-	return fmt.Sprintf("%s/index.txt", eb.FilenameRoot)
+// exportFilename returns the name of file batchNum of the batchSize files that
+// an export batch is split into, such as "root/US-1589490000-00001-of-00004.zip".
+func exportFilename(eb *database.ExportBatch, batchNum, batchSize int) string {
+	return fmt.Sprintf("%s/%s-%d-%05d-of-%05d%s", eb.FilenameRoot, eb.Region, eb.StartTimestamp.Unix(), batchNum, batchSize, filenameSuffix)
 }
 
 // randomInt is inclusive, [min:max]
@@ -296,8 +471,29 @@ func ensureMinNumExposures(exposures []*database.Exposure, region string, minLen
 	}
 
 	extra, _ := randomInt(0, jitter)
-	target := minLength + extra
+	return padExposures(exposures, region, minLength+extra)
+}
+
+// quantizeExposures pads exposures with fake keys until their number is a
+// multiple of bucket, so the size of a file only reveals the number of real
+// keys to within bucket. Files are never padded beyond maxLength.
+func quantizeExposures(exposures []*database.Exposure, region string, bucket, maxLength int) ([]*database.Exposure, error) {
+	if len(exposures) == 0 || bucket <= 0 {
+		return exposures, nil
+	}
+
+	target := (len(exposures) + bucket - 1) / bucket * bucket
+	if maxLength > 0 && target > maxLength {
+		target = maxLength
+	}
+	return padExposures(exposures, region, target)
+}
 
+// padExposures appends fake keys to exposures until there are target of them.
+// The fake keys look like the real ones: their interval numbers and counts are
+// taken from random real keys, their report type and days since symptom onset
+// together from another, and their transmission risk is random.
+func padExposures(exposures []*database.Exposure, region string, target int) ([]*database.Exposure, error) {
 	for len(exposures) < target {
 		// Pieces needed are
 		// (1) exposure key, (2) interval number, (3) transmission risk
@@ -327,13 +523,28 @@ func ensureMinNumExposures(exposures []*database.Exposure, region string, minLen
 			return nil, fmt.Errorf("randomInt: %w", err)
 		}
 		intervalCount := exposures[fromIdx].IntervalCount
+		// The report type and days since symptom onset are also written to
+		// the file, so they are copied too, from the same key so that the
+		// pair stays plausible.
+		fromIdx, err = randomInt(0, len(exposures)-1)
+		if err != nil {
+			return nil, fmt.Errorf("randomInt: %w", err)
+		}
+		reportType := exposures[fromIdx].ReportType
+		var daysSinceOnset *int32
+		if d := exposures[fromIdx].DaysSinceSymptomOnset; d != nil {
+			v := *d
+			daysSinceOnset = &v
+		}
 
 		ek := &database.Exposure{
-			ExposureKey:      eKey,
-			TransmissionRisk: transmissionRisk,
-			Regions:          []string{region},
-			IntervalNumber:   intervalNumber,
-			IntervalCount:    intervalCount,
+			ExposureKey:           eKey,
+			TransmissionRisk:      transmissionRisk,
+			Regions:               []string{region},
+			IntervalNumber:        intervalNumber,
+			IntervalCount:         intervalCount,
+			ReportType:            reportType,
+			DaysSinceSymptomOnset: daysSinceOnset,
 			// The rest of the database.Exposure fields are not used in the export file.
 		}
 		exposures = append(exposures, ek)
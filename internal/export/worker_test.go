@@ -18,6 +18,8 @@ import (
 	"crypto/rand"
 	"fmt"
 	"testing"
+	"time"
+	_ "time/tzdata" // DST boundary tests need America/New_York.
 
 	"github.com/google/exposure-notifications-server/internal/database"
 )
@@ -56,6 +58,44 @@ func TestDoNotPadZeroLength(t *testing.T) {
 	}
 }
 
+func TestQuantizeExposures(t *testing.T) {
+	cases := []struct {
+		name   string
+		count  int
+		bucket int
+		max    int
+		want   int
+	}{
+		{name: "disabled", count: 1001, bucket: 0, max: 30000, want: 1001},
+		{name: "empty", count: 0, bucket: 500, max: 30000, want: 0},
+		{name: "round up", count: 1001, bucket: 500, max: 30000, want: 1500},
+		{name: "already a multiple", count: 1500, bucket: 500, max: 30000, want: 1500},
+		{name: "capped at max", count: 1001, bucket: 500, max: 1200, want: 1200},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var exposures []*database.Exposure
+			for i := 0; i < c.count; i++ {
+				exposures = addExposure(t, exposures, 123456, 144, 0)
+			}
+			got, err := quantizeExposures(exposures, "US", c.bucket, c.max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != c.want {
+				t.Errorf("got %d exposures, want %d", len(got), c.want)
+			}
+			for _, exp := range got[c.count:] {
+				if exp.IntervalNumber != 123456 || exp.IntervalCount != 144 {
+					t.Errorf("padded key has interval %d/%d, want 123456/144", exp.IntervalNumber, exp.IntervalCount)
+				}
+			}
+		})
+	}
+}
+
 func addExposure(t *testing.T, exposures []*database.Exposure, interval, count int32, risk int) []*database.Exposure {
 	key := make([]byte, database.KeyLength)
 	_, err := rand.Read(key)
@@ -112,3 +152,192 @@ func TestEnsureMinExposures(t *testing.T) {
 		}
 	}
 }
+
+func TestPaddingFieldsIndistinguishable(t *testing.T) {
+	// fields lists which of the fields written to export files a key sets.
+	type fields struct {
+		reportType     string
+		daysSinceOnset bool
+	}
+	fieldsOf := func(e *database.Exposure) fields {
+		return fields{reportType: e.ReportType, daysSinceOnset: e.DaysSinceSymptomOnset != nil}
+	}
+
+	var exposures []*database.Exposure
+	for i, rt := range []string{database.ReportTypeConfirmed, database.ReportTypeLikely, database.ReportTypeConfirmed} {
+		exposures = addExposure(t, exposures, 123456, 144, 5)
+		days := int32(i - 1)
+		exposures[i].ReportType = rt
+		exposures[i].DaysSinceSymptomOnset = &days
+	}
+	realFields := make(map[fields]bool)
+	realDays := make(map[int32]bool)
+	for _, e := range exposures {
+		realFields[fieldsOf(e)] = true
+		realDays[*e.DaysSinceSymptomOnset] = true
+	}
+
+	padded, err := ensureMinNumExposures(exposures, "US", 1000, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range padded[len(exposures):] {
+		if !realFields[fieldsOf(e)] {
+			t.Fatalf("padded key %d sets %+v, which no real key does", i, fieldsOf(e))
+		}
+		if !realDays[*e.DaysSinceSymptomOnset] {
+			t.Errorf("padded key %d has days since onset %d, which no real key has", i, *e.DaysSinceSymptomOnset)
+		}
+	}
+	// The copied value must not alias the real key's.
+	for _, e := range padded[len(exposures):] {
+		for _, r := range exposures {
+			if e.DaysSinceSymptomOnset == r.DaysSinceSymptomOnset {
+				t.Fatalf("padded key shares days since onset with a real key")
+			}
+		}
+	}
+}
+
+func TestExportFilename(t *testing.T) {
+	eb := &database.ExportBatch{
+		FilenameRoot:   "exposureKeyExport-US",
+		Region:         "US",
+		StartTimestamp: time.Unix(1589490000, 0),
+	}
+	cases := []struct {
+		batchNum, batchSize int
+		want                string
+	}{
+		{1, 1, "exposureKeyExport-US/US-1589490000-00001-of-00001.zip"},
+		{1, 4, "exposureKeyExport-US/US-1589490000-00001-of-00004.zip"},
+		{4, 4, "exposureKeyExport-US/US-1589490000-00004-of-00004.zip"},
+	}
+	for _, c := range cases {
+		if got := exportFilename(eb, c.batchNum, c.batchSize); got != c.want {
+			t.Errorf("exportFilename(%d, %d) = %q, want %q", c.batchNum, c.batchSize, got, c.want)
+		}
+	}
+}
+
+func TestExportCriteria(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// In 2020 clocks sprang forward at 2am on March 8 and fell back at 2am on
+	// November 1, so 1am on November 1 happened twice.
+	springForward := time.Date(2020, 3, 8, 3, 0, 0, 0, loc)
+	fallBack := time.Date(2020, 11, 1, 1, 0, 0, 0, loc).Add(time.Hour)
+
+	cases := []struct {
+		name        string
+		start, end  time.Time
+		delta       bool
+		lookback    time.Duration
+		horizon     int64
+		wantSince   time.Time
+		wantHorizon int64
+	}{
+		{
+			name:  "hourly after spring forward",
+			start: springForward, end: springForward.Add(time.Hour),
+			lookback: 2 * time.Hour, horizon: 42,
+			wantSince: time.Date(2020, 3, 8, 0, 0, 0, 0, loc), wantHorizon: 42,
+		},
+		{
+			name:  "hourly in repeated hour after fall back",
+			start: fallBack, end: fallBack.Add(time.Hour),
+			lookback: time.Hour, horizon: 42,
+			wantSince: time.Date(2020, 11, 1, 1, 0, 0, 0, loc), wantHorizon: 42,
+		},
+		{
+			name:  "daily across spring forward",
+			start: time.Date(2020, 3, 8, 0, 0, 0, 0, time.UTC), end: time.Date(2020, 3, 9, 0, 0, 0, 0, time.UTC),
+			lookback: 24 * time.Hour, horizon: 42,
+			wantSince: time.Date(2020, 3, 7, 0, 0, 0, 0, time.UTC), wantHorizon: 42,
+		},
+		{
+			name:  "daily across fall back with unknown horizon",
+			start: time.Date(2020, 11, 1, 0, 0, 0, 0, time.UTC), end: time.Date(2020, 11, 2, 0, 0, 0, 0, time.UTC),
+			lookback:  24 * time.Hour,
+			wantSince: time.Date(2020, 10, 31, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "no lookback",
+			start: springForward, end: springForward.Add(time.Hour),
+			horizon:   42,
+			wantSince: springForward,
+		},
+		{
+			name:  "delta batch",
+			start: springForward, end: springForward.Add(time.Hour),
+			delta: true, lookback: time.Hour, horizon: 42,
+			wantSince: springForward,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			eb := &database.ExportBatch{Region: "US", StartTimestamp: c.start, EndTimestamp: c.end}
+			if c.delta {
+				eb.DeltaOfConfigID = 1
+			}
+			got := exportCriteria(eb, c.lookback, c.horizon)
+			if !got.SinceTimestamp.Equal(c.wantSince) {
+				t.Errorf("since %v, want %v", got.SinceTimestamp.UTC(), c.wantSince.UTC())
+			}
+			if elapsed := c.start.Sub(got.SinceTimestamp); !c.delta && c.lookback > 0 && elapsed != c.lookback {
+				t.Errorf("looked back %v, want %v", elapsed, c.lookback)
+			}
+			if !got.UntilTimestamp.Equal(c.end) {
+				t.Errorf("until %v, want %v", got.UntilTimestamp.UTC(), c.end.UTC())
+			}
+			if got.LookbackHorizon != c.wantHorizon {
+				t.Errorf("lookback horizon %d, want %d", got.LookbackHorizon, c.wantHorizon)
+			}
+			if c.wantHorizon > 0 && !got.LookbackUntil.Equal(c.start) {
+				t.Errorf("lookback until %v, want batch start %v", got.LookbackUntil.UTC(), c.start.UTC())
+			}
+		})
+	}
+}
+
+// TestExportCriteriaLookbackCovered checks, across both DST transitions, that
+// the lookback of each batch lies within what the previous batch read, so
+// that skipping the keys the previous batch saw never skips a key it didn't
+// export.
+func TestExportCriteriaLookbackCovered(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, period := range []time.Duration{time.Hour, 4 * time.Hour, 24 * time.Hour} {
+		for _, lookback := range []time.Duration{30 * time.Minute, 6 * time.Hour, 36 * time.Hour} {
+			for _, days := range []struct {
+				name       string
+				start, end time.Time
+			}{
+				{"spring forward", time.Date(2020, 3, 6, 0, 0, 0, 0, loc), time.Date(2020, 3, 10, 0, 0, 0, 0, loc)},
+				{"fall back", time.Date(2020, 10, 30, 0, 0, 0, 0, loc), time.Date(2020, 11, 3, 0, 0, 0, 0, loc)},
+			} {
+				var previous *database.IterateExposuresCriteria
+				var latestEnd time.Time
+				for now := days.start; now.Before(days.end); now = now.Add(13 * time.Minute) {
+					for _, br := range makeBatchRanges(period, latestEnd, now, time.Hour) {
+						latestEnd = br.end
+						criteria := exportCriteria(&database.ExportBatch{StartTimestamp: br.start, EndTimestamp: br.end}, lookback, 42)
+						if previous != nil && (criteria.SinceTimestamp.Before(previous.SinceTimestamp) || criteria.LookbackUntil.After(previous.UntilTimestamp)) {
+							t.Errorf("%v period, %v lookback, %s: lookback [%v, %v) isn't within previous batch [%v, %v)", period, lookback, days.name,
+								criteria.SinceTimestamp.UTC(), criteria.LookbackUntil.UTC(), previous.SinceTimestamp.UTC(), previous.UntilTimestamp.UTC())
+						}
+						previous = &criteria
+					}
+				}
+				if previous == nil {
+					t.Fatalf("%v period, %s: no batches created", period, days.name)
+				}
+			}
+		}
+	}
+}
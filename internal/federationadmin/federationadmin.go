@@ -0,0 +1,380 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package federationadmin is an authenticated API for managing federation
+// partners and checking on their syncs, in place of editing the federation
+// tables by hand.
+package federationadmin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/audit"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/federationin"
+	"github.com/google/exposure-notifications-server/internal/handlers"
+	"github.com/google/exposure-notifications-server/internal/jsonutil"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+)
+
+const (
+	queryIDParam  = "query-id"
+	issuerParam   = "issuer"
+	subjectParam  = "subject"
+	targetIDParam = "target-id"
+)
+
+var (
+	validIDStr    = `\A[a-z][a-z0-9-_]*[a-z0-9]\z`
+	validIDRegexp = regexp.MustCompile(validIDStr)
+
+	validServerAddrStr    = `\A[a-z0-9.-]+(:\d+)?\z`
+	validServerAddrRegexp = regexp.MustCompile(validServerAddrStr)
+)
+
+// adminDB is the part of the database used by the admin API.
+type adminDB interface {
+	handlers.APIKeyGetter
+
+	ListFederationInStatus(ctx context.Context) ([]*database.FederationInStatus, error)
+	ListFederationInQueries(ctx context.Context) ([]*database.FederationInQuery, error)
+	GetFederationInQuery(ctx context.Context, queryID string) (*database.FederationInQuery, error)
+	AddFederationInQuery(ctx context.Context, q *database.FederationInQuery) error
+	DeleteFederationInQuery(ctx context.Context, queryID string) error
+
+	ListFederationOutAuthorizations(ctx context.Context) ([]*database.FederationOutAuthorization, error)
+	AddFederationOutAuthorization(ctx context.Context, auth *database.FederationOutAuthorization) error
+	DeleteFederationOutAuthorization(ctx context.Context, issuer, subject string) error
+
+	ListFederationPushTargets(ctx context.Context) ([]*database.FederationPushTarget, error)
+	AddFederationPushTarget(ctx context.Context, t *database.FederationPushTarget) error
+	DeleteFederationPushTarget(ctx context.Context, targetID string) error
+
+	AddAuditEntry(ctx context.Context, e *database.AuditEntry) error
+}
+
+// NewHandler returns the federation admin API. Requests must carry an API key
+// with the federation_admin permission as a bearer token. The API serves:
+//
+//	GET /status                  sync status of every partner
+//	GET, PUT /queries            federation queries that pull from partners
+//	DELETE /queries?query-id=
+//	GET, PUT /authorizations     partners allowed to pull from this server
+//	DELETE /authorizations?issuer=&subject=
+//	GET, PUT /push-targets       partners that keys are pushed to
+//	DELETE /push-targets?target-id=
+func NewHandler(env *serverenv.ServerEnv, config *Config) (http.Handler, error) {
+	if env.Database() == nil {
+		return nil, fmt.Errorf("missing database in server environment")
+	}
+	return &handler{
+		env:    env,
+		db:     env.Database(),
+		config: config,
+	}, nil
+}
+
+type handler struct {
+	env    *serverenv.ServerEnv
+	db     adminDB
+	config *Config
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.Timeout)
+	defer cancel()
+	logger := logging.FromContext(ctx)
+	metrics := h.env.MetricsExporter(ctx)
+
+	apiKey, err := handlers.AuthenticateAPIKey(ctx, h.db, r, database.PermissionFederationAdmin)
+	if err != nil {
+		metrics.WriteInt("federation-admin-unauthorized", true, 1)
+		handlers.WriteError(ctx, w, "Federation admin request", err)
+		return
+	}
+	ctx = audit.WithActor(ctx, audit.APIKeyActor(apiKey))
+
+	var resp interface{}
+	switch r.URL.Path {
+	case "/status":
+		if r.Method != http.MethodGet {
+			err = &handlers.Error{Status: http.StatusMethodNotAllowed, Message: http.StatusText(http.StatusMethodNotAllowed)}
+			break
+		}
+		resp, err = h.status(ctx)
+	case "/queries":
+		resp, err = h.queries(ctx, w, r)
+	case "/authorizations":
+		resp, err = h.authorizations(ctx, w, r)
+	case "/push-targets":
+		resp, err = h.pushTargets(ctx, w, r)
+	default:
+		err = &handlers.Error{Status: http.StatusNotFound, Message: http.StatusText(http.StatusNotFound)}
+	}
+	if err != nil {
+		handlers.WriteError(ctx, w, "Federation admin request", err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		metrics.WriteInt("federation-admin-changes", true, 1)
+		logger.Infof("API key %v: %s %s?%s", apiKey.Name, r.Method, r.URL.Path, r.URL.RawQuery)
+	}
+
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf("Failed writing response: %v", err)
+	}
+}
+
+// status reports the sync state of every query and push target.
+func (h *handler) status(ctx context.Context) (*Status, error) {
+	statuses, err := h.db.ListFederationInStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	queries, err := h.db.ListFederationInQueries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make(map[string]string, len(queries))
+	for _, q := range queries {
+		addrs[q.QueryID] = q.ServerAddr
+	}
+	targets, err := h.db.ListFederationPushTargets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &Status{
+		Queries:     make([]*QueryStatus, 0, len(statuses)),
+		PushTargets: make([]*PushStatus, 0, len(targets)),
+	}
+	for _, s := range statuses {
+		resp.Queries = append(resp.Queries, queryStatus(s, addrs[s.QueryID]))
+	}
+	for _, t := range targets {
+		resp.PushTargets = append(resp.PushTargets, pushStatus(t))
+	}
+	return resp, nil
+}
+
+func (h *handler) queries(ctx context.Context, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		queries, err := h.db.ListFederationInQueries(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp := make([]*Query, 0, len(queries))
+		for _, q := range queries {
+			resp = append(resp, queryFromDB(q))
+		}
+		return resp, nil
+
+	case http.MethodPut:
+		var q Query
+		if err := unmarshal(w, r, &q); err != nil {
+			return nil, err
+		}
+		dbq, err := q.toDB()
+		if err != nil {
+			return nil, err
+		}
+		// Updating a query without a last timestamp keeps its place.
+		if q.LastTimestamp == nil {
+			existing, err := h.db.GetFederationInQuery(ctx, dbq.QueryID)
+			switch {
+			case err == nil:
+				dbq.LastTimestamp = existing.LastTimestamp
+			case !errors.Is(err, database.ErrNotFound):
+				return nil, err
+			}
+		}
+		if err := h.db.AddFederationInQuery(ctx, dbq); err != nil {
+			return nil, err
+		}
+		resp := queryFromDB(dbq)
+		audit.Record(ctx, h.db, audit.ActionSave, audit.ResourceFederationQuery, dbq.QueryID, resp)
+		return resp, nil
+
+	case http.MethodDelete:
+		queryID := r.URL.Query().Get(queryIDParam)
+		err := h.db.DeleteFederationInQuery(ctx, queryID)
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			return nil, handlers.Errorf(http.StatusNotFound, "unknown %s %q", queryIDParam, queryID)
+		case errors.Is(err, database.ErrFederationInQueryInUse):
+			return nil, handlers.Errorf(http.StatusConflict, "query %q has synced and cannot be deleted; remove its regions or tombstone its keys instead", queryID)
+		case err == nil:
+			audit.Record(ctx, h.db, audit.ActionDelete, audit.ResourceFederationQuery, queryID, nil)
+		}
+		return nil, err
+	}
+	return nil, &handlers.Error{Status: http.StatusMethodNotAllowed, Message: http.StatusText(http.StatusMethodNotAllowed)}
+}
+
+func (h *handler) authorizations(ctx context.Context, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		auths, err := h.db.ListFederationOutAuthorizations(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp := make([]*Authorization, 0, len(auths))
+		for _, a := range auths {
+			resp = append(resp, authorizationFromDB(a))
+		}
+		return resp, nil
+
+	case http.MethodPut:
+		var a Authorization
+		if err := unmarshal(w, r, &a); err != nil {
+			return nil, err
+		}
+		dba, err := a.toDB()
+		if err != nil {
+			return nil, err
+		}
+		if err := h.db.AddFederationOutAuthorization(ctx, dba); err != nil {
+			return nil, err
+		}
+		resp := authorizationFromDB(dba)
+		audit.Record(ctx, h.db, audit.ActionSave, audit.ResourceFederationAuthorization, dba.Issuer+" "+dba.Subject, resp)
+		return resp, nil
+
+	case http.MethodDelete:
+		issuer, subject := r.URL.Query().Get(issuerParam), r.URL.Query().Get(subjectParam)
+		err := h.db.DeleteFederationOutAuthorization(ctx, issuer, subject)
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, handlers.Errorf(http.StatusNotFound, "unknown authorization %q %q", issuer, subject)
+		}
+		if err == nil {
+			audit.Record(ctx, h.db, audit.ActionDelete, audit.ResourceFederationAuthorization, issuer+" "+subject, nil)
+		}
+		return nil, err
+	}
+	return nil, &handlers.Error{Status: http.StatusMethodNotAllowed, Message: http.StatusText(http.StatusMethodNotAllowed)}
+}
+
+func (h *handler) pushTargets(ctx context.Context, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		targets, err := h.db.ListFederationPushTargets(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp := make([]*PushTarget, 0, len(targets))
+		for _, t := range targets {
+			resp = append(resp, pushTargetFromDB(t))
+		}
+		return resp, nil
+
+	case http.MethodPut:
+		var t PushTarget
+		if err := unmarshal(w, r, &t); err != nil {
+			return nil, err
+		}
+		dbt, err := t.toDB()
+		if err != nil {
+			return nil, err
+		}
+		if err := h.db.AddFederationPushTarget(ctx, dbt); err != nil {
+			return nil, err
+		}
+		resp := pushTargetFromDB(dbt)
+		audit.Record(ctx, h.db, audit.ActionSave, audit.ResourceFederationPushTarget, dbt.TargetID, resp)
+		return resp, nil
+
+	case http.MethodDelete:
+		targetID := r.URL.Query().Get(targetIDParam)
+		err := h.db.DeleteFederationPushTarget(ctx, targetID)
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, handlers.Errorf(http.StatusNotFound, "unknown %s %q", targetIDParam, targetID)
+		}
+		if err == nil {
+			audit.Record(ctx, h.db, audit.ActionDelete, audit.ResourceFederationPushTarget, targetID, nil)
+		}
+		return nil, err
+	}
+	return nil, &handlers.Error{Status: http.StatusMethodNotAllowed, Message: http.StatusText(http.StatusMethodNotAllowed)}
+}
+
+func unmarshal(w http.ResponseWriter, r *http.Request, data interface{}) error {
+	code, err := jsonutil.Unmarshal(w, r, data)
+	if err != nil {
+		return &handlers.Error{Status: code, Message: err.Error()}
+	}
+	return nil
+}
+
+// upperRegions returns the regions upper-cased, as they are stored.
+func upperRegions(regions []string) []string {
+	if len(regions) == 0 {
+		return nil
+	}
+	upper := make([]string, 0, len(regions))
+	for _, r := range regions {
+		upper = append(upper, strings.ToUpper(strings.TrimSpace(r)))
+	}
+	return upper
+}
+
+// reportTypesToDB returns the report types lower-cased, as they are stored.
+func reportTypesToDB(reportTypes []string) ([]string, error) {
+	if len(reportTypes) == 0 {
+		return nil, nil
+	}
+	lower := make([]string, 0, len(reportTypes))
+	for _, t := range reportTypes {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || !database.ValidReportType(t) {
+			return nil, handlers.BadRequest("invalid report type %q", t)
+		}
+		lower = append(lower, t)
+	}
+	return lower, nil
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// validateQuery applies the checks of the federationin-query tool.
+func validateQuery(q *Query) error {
+	if !validIDRegexp.MatchString(q.QueryID) {
+		return handlers.BadRequest("queryId %q must match %s", q.QueryID, validIDStr)
+	}
+	if !validServerAddrRegexp.MatchString(q.ServerAddr) {
+		return handlers.BadRequest("serverAddr %q must match %s", q.ServerAddr, validServerAddrStr)
+	}
+	if !federationin.ValidAudienceRegexp.MatchString(q.Audience) {
+		return handlers.BadRequest("audience %q must match %s", q.Audience, federationin.ValidAudienceStr)
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationadmin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/go-cmp/cmp"
+)
+
+const (
+	adminKey   = "admin-key"
+	publishKey = "publish-key"
+)
+
+// fakeDB keeps federation partners in memory.
+type fakeDB struct {
+	queries  map[string]*database.FederationInQuery
+	statuses []*database.FederationInStatus
+	auths    map[string]*database.FederationOutAuthorization
+	targets  []*database.FederationPushTarget
+	audit    []*database.AuditEntry
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		queries: make(map[string]*database.FederationInQuery),
+		auths:   make(map[string]*database.FederationOutAuthorization),
+	}
+}
+
+func (f *fakeDB) GetAPIKey(ctx context.Context, rawKey string) (*database.APIKey, error) {
+	switch rawKey {
+	case adminKey:
+		return &database.APIKey{Name: "admin", Permissions: []string{database.PermissionFederationAdmin}}, nil
+	case publishKey:
+		return &database.APIKey{Name: "publisher", Permissions: []string{database.PermissionBatchPublish}}, nil
+	}
+	return nil, database.ErrNotFound
+}
+
+func (f *fakeDB) ListFederationInStatus(ctx context.Context) ([]*database.FederationInStatus, error) {
+	return f.statuses, nil
+}
+
+func (f *fakeDB) ListFederationInQueries(ctx context.Context) ([]*database.FederationInQuery, error) {
+	var queries []*database.FederationInQuery
+	for _, q := range f.queries {
+		queries = append(queries, q)
+	}
+	return queries, nil
+}
+
+func (f *fakeDB) GetFederationInQuery(ctx context.Context, queryID string) (*database.FederationInQuery, error) {
+	if q, ok := f.queries[queryID]; ok {
+		return q, nil
+	}
+	return nil, database.ErrNotFound
+}
+
+func (f *fakeDB) AddFederationInQuery(ctx context.Context, q *database.FederationInQuery) error {
+	f.queries[q.QueryID] = q
+	return nil
+}
+
+func (f *fakeDB) DeleteFederationInQuery(ctx context.Context, queryID string) error {
+	if _, ok := f.queries[queryID]; !ok {
+		return database.ErrNotFound
+	}
+	for _, s := range f.statuses {
+		if s.QueryID == queryID && s.LastSync != nil {
+			return database.ErrFederationInQueryInUse
+		}
+	}
+	delete(f.queries, queryID)
+	return nil
+}
+
+func (f *fakeDB) ListFederationOutAuthorizations(ctx context.Context) ([]*database.FederationOutAuthorization, error) {
+	var auths []*database.FederationOutAuthorization
+	for _, a := range f.auths {
+		auths = append(auths, a)
+	}
+	return auths, nil
+}
+
+func (f *fakeDB) AddFederationOutAuthorization(ctx context.Context, auth *database.FederationOutAuthorization) error {
+	f.auths[auth.Issuer+" "+auth.Subject] = auth
+	return nil
+}
+
+func (f *fakeDB) DeleteFederationOutAuthorization(ctx context.Context, issuer, subject string) error {
+	if _, ok := f.auths[issuer+" "+subject]; !ok {
+		return database.ErrNotFound
+	}
+	delete(f.auths, issuer+" "+subject)
+	return nil
+}
+
+func (f *fakeDB) ListFederationPushTargets(ctx context.Context) ([]*database.FederationPushTarget, error) {
+	return f.targets, nil
+}
+
+func (f *fakeDB) AddFederationPushTarget(ctx context.Context, t *database.FederationPushTarget) error {
+	f.targets = append(f.targets, t)
+	return nil
+}
+
+func (f *fakeDB) AddAuditEntry(ctx context.Context, e *database.AuditEntry) error {
+	f.audit = append(f.audit, e)
+	return nil
+}
+
+func (f *fakeDB) DeleteFederationPushTarget(ctx context.Context, targetID string) error {
+	for i, t := range f.targets {
+		if t.TargetID == targetID {
+			f.targets = append(f.targets[:i], f.targets[i+1:]...)
+			return nil
+		}
+	}
+	return database.ErrNotFound
+}
+
+func newTestHandler(db adminDB) *handler {
+	ctx := context.Background()
+	return &handler{
+		env:    serverenv.New(ctx, serverenv.WithMetricsExporter(metrics.NewLogsBasedFromContext)),
+		db:     db,
+		config: &Config{Timeout: time.Minute},
+	}
+}
+
+func serve(h http.Handler, key, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuthentication(t *testing.T) {
+	h := newTestHandler(newFakeDB())
+
+	cases := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{name: "missing key", want: http.StatusUnauthorized},
+		{name: "unknown key", key: "nope", want: http.StatusUnauthorized},
+		{name: "no permission", key: publishKey, want: http.StatusForbidden},
+		{name: "admin", key: adminKey, want: http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if w := serve(h, tc.key, http.MethodGet, "/status", ""); w.Code != tc.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestQueries(t *testing.T) {
+	db := newFakeDB()
+	h := newTestHandler(db)
+	ts := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	w := serve(h, adminKey, http.MethodPut, "/queries",
+		`{"queryId": "partner", "serverAddr": "partner.example.com:443", "audience": "https://partner.example.com", "includeRegions": ["gb"], "regionMap": {"uk": "gb"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT: got status %d: %s", w.Code, w.Body.String())
+	}
+	want := &database.FederationInQuery{
+		QueryID:        "partner",
+		ServerAddr:     "partner.example.com:443",
+		Audience:       "https://partner.example.com",
+		IncludeRegions: []string{"GB"},
+		RegionMap:      map[string]string{"UK": "GB"},
+	}
+	if diff := cmp.Diff(want, db.queries["partner"]); diff != "" {
+		t.Errorf("stored query mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Updating without a last timestamp keeps the query's place.
+	db.queries["partner"].LastTimestamp = ts
+	w = serve(h, adminKey, http.MethodPut, "/queries",
+		`{"queryId": "partner", "serverAddr": "partner2.example.com", "audience": "https://partner.example.com"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT: got status %d: %s", w.Code, w.Body.String())
+	}
+	if got := db.queries["partner"]; got.ServerAddr != "partner2.example.com" || !got.LastTimestamp.Equal(ts) {
+		t.Errorf("updated query: got %+v, want last timestamp %v", got, ts)
+	}
+
+	var got []*Query
+	w = serve(h, adminKey, http.MethodGet, "/queries", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].QueryID != "partner" || got[0].LastTimestamp == nil {
+		t.Errorf("GET: got %s", w.Body.String())
+	}
+
+	// Invalid queries are rejected.
+	w = serve(h, adminKey, http.MethodPut, "/queries", `{"queryId": "Bad ID", "serverAddr": "x", "audience": "y"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid PUT: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// A query that has synced cannot be deleted.
+	db.statuses = []*database.FederationInStatus{{QueryID: "partner", LastSync: &database.FederationInSync{SyncID: 1}}}
+	if w := serve(h, adminKey, http.MethodDelete, "/queries?query-id=partner", ""); w.Code != http.StatusConflict {
+		t.Errorf("DELETE synced: got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	db.statuses = nil
+	if w := serve(h, adminKey, http.MethodDelete, "/queries?query-id=partner", ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE: got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := serve(h, adminKey, http.MethodDelete, "/queries?query-id=partner", ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE twice: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAuthorizations(t *testing.T) {
+	db := newFakeDB()
+	h := newTestHandler(db)
+
+	if w := serve(h, adminKey, http.MethodPut, "/authorizations", `{"subject": "sub"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT without issuer: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := serve(h, adminKey, http.MethodPut, "/authorizations", `{"issuer": "https://accounts.google.com", "subject": "sub", "reportTypes": ["rumor"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT with bad report type: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := serve(h, adminKey, http.MethodPut, "/authorizations", `{"issuer": "https://accounts.google.com", "subject": "sub", "excludeRegions": ["ca"], "reportTypes": ["Confirmed"], "minTransmissionRisk": 2}`); w.Code != http.StatusOK {
+		t.Fatalf("PUT: got status %d: %s", w.Code, w.Body.String())
+	}
+	want := &database.FederationOutAuthorization{
+		Issuer:              "https://accounts.google.com",
+		Subject:             "sub",
+		ExcludeRegions:      []string{"CA"},
+		ReportTypes:         []string{database.ReportTypeConfirmed},
+		MinTransmissionRisk: 2,
+	}
+	if diff := cmp.Diff(want, db.auths["https://accounts.google.com sub"]); diff != "" {
+		t.Errorf("stored authorization mismatch (-want, +got):\n%s", diff)
+	}
+	if w := serve(h, adminKey, http.MethodDelete, "/authorizations?issuer=https%3A%2F%2Faccounts.google.com&subject=sub", ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE: got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if len(db.auths) != 0 {
+		t.Errorf("authorization not deleted: %v", db.auths)
+	}
+}
+
+func TestPushTargets(t *testing.T) {
+	db := newFakeDB()
+	h := newTestHandler(db)
+
+	if w := serve(h, adminKey, http.MethodPut, "/push-targets", `{"targetId": "eu", "protocol": "FTP", "endpoint": "x"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT with bad protocol: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := serve(h, adminKey, http.MethodPut, "/push-targets", `{"targetId": "eu", "protocol": "EFGS", "endpoint": "https://efgs.example.com", "startChangeId": 7}`); w.Code != http.StatusOK {
+		t.Fatalf("PUT: got status %d: %s", w.Code, w.Body.String())
+	}
+	want := []*database.FederationPushTarget{{TargetID: "eu", Protocol: "EFGS", Endpoint: "https://efgs.example.com", AckedChangeID: 7}}
+	if diff := cmp.Diff(want, db.targets); diff != "" {
+		t.Errorf("stored targets mismatch (-want, +got):\n%s", diff)
+	}
+	if w := serve(h, adminKey, http.MethodDelete, "/push-targets?target-id=eu", ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE: got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := serve(h, adminKey, http.MethodDelete, "/push-targets?target-id=eu", ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE twice: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// Only the changes that were made are audited.
+	var audited []string
+	for _, e := range db.audit {
+		audited = append(audited, e.Actor+" "+e.Action+" "+e.Resource+" "+e.ResourceID)
+	}
+	wantAudited := []string{
+		"api-key:admin save federation_push_target eu",
+		"api-key:admin delete federation_push_target eu",
+	}
+	if diff := cmp.Diff(wantAudited, audited); diff != "" {
+		t.Errorf("audit mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	now := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	db := newFakeDB()
+	db.queries["new"] = &database.FederationInQuery{QueryID: "new", ServerAddr: "new.example.com"}
+	db.queries["paused"] = &database.FederationInQuery{QueryID: "paused", ServerAddr: "paused.example.com"}
+	db.statuses = []*database.FederationInStatus{
+		{QueryID: "new"},
+		{
+			QueryID:       "paused",
+			LastSync:      &database.FederationInSync{SyncID: 2, Started: now, Insertions: 3},
+			LastCompleted: &database.FederationInSync{SyncID: 1, Started: now.Add(-time.Hour), Completed: now.Add(-time.Hour), Insertions: 5, MaxTimestamp: now.Add(-2 * time.Hour)},
+			KeysPulled:    8,
+		},
+	}
+	db.targets = []*database.FederationPushTarget{
+		{TargetID: "down", Protocol: "REST", Endpoint: "https://down.example.com", AckedChangeID: 9, KeysPushed: 20, LastSuccessAt: now.Add(-time.Hour), LastFailureAt: now, Failures: 2, LastError: "unavailable"},
+		{TargetID: "up", Protocol: "GRPC", Endpoint: "up.example.com:443", AckedChangeID: 12, KeysPushed: 30, LastSuccessAt: now},
+	}
+	h := newTestHandler(db)
+
+	w := serve(h, adminKey, http.MethodGet, "/status", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	var got Status
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	completed, maxTimestamp, success, failure := now.Add(-time.Hour), now.Add(-2*time.Hour), now.Add(-time.Hour), now
+	want := Status{
+		Queries: []*QueryStatus{
+			{QueryID: "new", ServerAddr: "new.example.com", State: StateNever},
+			{
+				QueryID:       "paused",
+				ServerAddr:    "paused.example.com",
+				State:         StateIncomplete,
+				LastSync:      &SyncStatus{SyncID: 2, Started: now, Insertions: 3},
+				LastCompleted: &SyncStatus{SyncID: 1, Started: now.Add(-time.Hour), Completed: &completed, Insertions: 5, MaxTimestamp: &maxTimestamp},
+				KeysPulled:    8,
+			},
+		},
+		PushTargets: []*PushStatus{
+			{TargetID: "down", Protocol: "REST", Endpoint: "https://down.example.com", State: StateFailing, AckedChangeID: 9, KeysPushed: 20, LastSuccessAt: &success, LastFailureAt: &failure, Failures: 2, LastError: "unavailable"},
+			{TargetID: "up", Protocol: "GRPC", Endpoint: "up.example.com:443", State: StateOK, AckedChangeID: 12, KeysPushed: 30, LastSuccessAt: &now},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
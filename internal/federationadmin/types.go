@@ -0,0 +1,292 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationadmin
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/handlers"
+)
+
+// Sync states reported by the status endpoint.
+const (
+	// StateNever means the partner has not been synced with yet.
+	StateNever = "never"
+	// StateOK means the latest sync with the partner succeeded.
+	StateOK = "ok"
+	// StateIncomplete means the latest pull from the partner has not
+	// completed: it is running, or it failed and the next pull resumes it.
+	StateIncomplete = "incomplete"
+	// StateFailing means the latest push to the partner failed.
+	StateFailing = "failing"
+)
+
+// Query is a federation query that pulls keys from a partner.
+type Query struct {
+	QueryID        string            `json:"queryId"`
+	ServerAddr     string            `json:"serverAddr"`
+	Audience       string            `json:"audience"`
+	IncludeRegions []string          `json:"includeRegions,omitempty"`
+	ExcludeRegions []string          `json:"excludeRegions,omitempty"`
+	RegionMap      map[string]string `json:"regionMap,omitempty"`
+	// ReportTypes and MinTransmissionRisk limit the keys accepted from the
+	// partner. No report types accepts them all.
+	ReportTypes         []string `json:"reportTypes,omitempty"`
+	MinTransmissionRisk int      `json:"minTransmissionRisk,omitempty"`
+	// LastTimestamp is where the next pull starts. Updating an existing query
+	// without it keeps the query's place.
+	LastTimestamp *time.Time `json:"lastTimestamp,omitempty"`
+}
+
+func queryFromDB(q *database.FederationInQuery) *Query {
+	return &Query{
+		QueryID:             q.QueryID,
+		ServerAddr:          q.ServerAddr,
+		Audience:            q.Audience,
+		IncludeRegions:      q.IncludeRegions,
+		ExcludeRegions:      q.ExcludeRegions,
+		RegionMap:           q.RegionMap,
+		ReportTypes:         q.ReportTypes,
+		MinTransmissionRisk: q.MinTransmissionRisk,
+		LastTimestamp:       timePtr(q.LastTimestamp),
+	}
+}
+
+func (q *Query) toDB() (*database.FederationInQuery, error) {
+	if err := validateQuery(q); err != nil {
+		return nil, err
+	}
+	reportTypes, err := reportTypesToDB(q.ReportTypes)
+	if err != nil {
+		return nil, err
+	}
+	dbq := &database.FederationInQuery{
+		QueryID:             q.QueryID,
+		ServerAddr:          q.ServerAddr,
+		Audience:            q.Audience,
+		IncludeRegions:      upperRegions(q.IncludeRegions),
+		ExcludeRegions:      upperRegions(q.ExcludeRegions),
+		ReportTypes:         reportTypes,
+		MinTransmissionRisk: q.MinTransmissionRisk,
+	}
+	if len(q.RegionMap) > 0 {
+		dbq.RegionMap = make(map[string]string, len(q.RegionMap))
+		for from, to := range q.RegionMap {
+			dbq.RegionMap[strings.ToUpper(from)] = strings.ToUpper(to)
+		}
+	}
+	if q.LastTimestamp != nil {
+		dbq.LastTimestamp = q.LastTimestamp.UTC()
+	}
+	return dbq, nil
+}
+
+// Authorization allows a partner to pull keys from this server.
+type Authorization struct {
+	Issuer         string   `json:"issuer"`
+	Subject        string   `json:"subject"`
+	Audience       string   `json:"audience,omitempty"`
+	Note           string   `json:"note,omitempty"`
+	IncludeRegions []string `json:"includeRegions,omitempty"`
+	ExcludeRegions []string `json:"excludeRegions,omitempty"`
+	// RequestsPerMinute and KeysPerDay override the server's quotas for the
+	// partner. Zero uses the server default, and a negative value lifts the
+	// quota.
+	RequestsPerMinute int `json:"requestsPerMinute,omitempty"`
+	KeysPerDay        int `json:"keysPerDay,omitempty"`
+	// ReportTypes and MinTransmissionRisk limit the keys served to the
+	// partner. No report types serves them all.
+	ReportTypes         []string `json:"reportTypes,omitempty"`
+	MinTransmissionRisk int      `json:"minTransmissionRisk,omitempty"`
+}
+
+func authorizationFromDB(a *database.FederationOutAuthorization) *Authorization {
+	return &Authorization{
+		Issuer:              a.Issuer,
+		Subject:             a.Subject,
+		Audience:            a.Audience,
+		Note:                a.Note,
+		IncludeRegions:      a.IncludeRegions,
+		ExcludeRegions:      a.ExcludeRegions,
+		RequestsPerMinute:   a.RequestsPerMinute,
+		KeysPerDay:          a.KeysPerDay,
+		ReportTypes:         a.ReportTypes,
+		MinTransmissionRisk: a.MinTransmissionRisk,
+	}
+}
+
+func (a *Authorization) toDB() (*database.FederationOutAuthorization, error) {
+	if a.Issuer == "" || a.Subject == "" {
+		return nil, handlers.BadRequest("issuer and subject are required")
+	}
+	reportTypes, err := reportTypesToDB(a.ReportTypes)
+	if err != nil {
+		return nil, err
+	}
+	return &database.FederationOutAuthorization{
+		Issuer:              a.Issuer,
+		Subject:             a.Subject,
+		Audience:            a.Audience,
+		Note:                a.Note,
+		IncludeRegions:      upperRegions(a.IncludeRegions),
+		ExcludeRegions:      upperRegions(a.ExcludeRegions),
+		RequestsPerMinute:   a.RequestsPerMinute,
+		KeysPerDay:          a.KeysPerDay,
+		ReportTypes:         reportTypes,
+		MinTransmissionRisk: a.MinTransmissionRisk,
+	}, nil
+}
+
+// PushTarget is a partner that local keys are pushed to.
+type PushTarget struct {
+	TargetID       string   `json:"targetId"`
+	Protocol       string   `json:"protocol"`
+	Endpoint       string   `json:"endpoint"`
+	Audience       string   `json:"audience,omitempty"`
+	IncludeRegions []string `json:"includeRegions,omitempty"`
+	ExcludeRegions []string `json:"excludeRegions,omitempty"`
+	// StartChangeID is, for a new target, the exposure change ID to start
+	// pushing after. It is ignored when updating a target.
+	StartChangeID int64 `json:"startChangeId,omitempty"`
+}
+
+func pushTargetFromDB(t *database.FederationPushTarget) *PushTarget {
+	return &PushTarget{
+		TargetID:       t.TargetID,
+		Protocol:       t.Protocol,
+		Endpoint:       t.Endpoint,
+		Audience:       t.Audience,
+		IncludeRegions: t.IncludeRegions,
+		ExcludeRegions: t.ExcludeRegions,
+		StartChangeID:  t.AckedChangeID,
+	}
+}
+
+func (t *PushTarget) toDB() (*database.FederationPushTarget, error) {
+	if !validIDRegexp.MatchString(t.TargetID) {
+		return nil, handlers.BadRequest("targetId %q must match %s", t.TargetID, validIDStr)
+	}
+	switch t.Protocol {
+	case database.FederationPushGRPC, database.FederationPushREST, database.FederationPushEFGS:
+	default:
+		return nil, handlers.BadRequest("protocol must be %s, %s or %s", database.FederationPushGRPC, database.FederationPushREST, database.FederationPushEFGS)
+	}
+	if t.Endpoint == "" {
+		return nil, handlers.BadRequest("endpoint is required")
+	}
+	return &database.FederationPushTarget{
+		TargetID:       t.TargetID,
+		Protocol:       t.Protocol,
+		Endpoint:       t.Endpoint,
+		Audience:       t.Audience,
+		IncludeRegions: upperRegions(t.IncludeRegions),
+		ExcludeRegions: upperRegions(t.ExcludeRegions),
+		AckedChangeID:  t.StartChangeID,
+	}, nil
+}
+
+// Status is the sync status of every federation partner.
+type Status struct {
+	Queries     []*QueryStatus `json:"queries"`
+	PushTargets []*PushStatus  `json:"pushTargets"`
+}
+
+// QueryStatus is the pull status of a federation query.
+type QueryStatus struct {
+	QueryID       string      `json:"queryId"`
+	ServerAddr    string      `json:"serverAddr"`
+	State         string      `json:"state"`
+	LastSync      *SyncStatus `json:"lastSync,omitempty"`
+	LastCompleted *SyncStatus `json:"lastCompleted,omitempty"`
+	KeysPulled    int64       `json:"keysPulled"`
+}
+
+// SyncStatus describes one pull of a federation query.
+type SyncStatus struct {
+	SyncID       int64      `json:"syncId"`
+	Started      time.Time  `json:"started"`
+	Completed    *time.Time `json:"completed,omitempty"`
+	Insertions   int        `json:"insertions"`
+	MaxTimestamp *time.Time `json:"maxTimestamp,omitempty"`
+}
+
+func queryStatus(s *database.FederationInStatus, serverAddr string) *QueryStatus {
+	qs := &QueryStatus{
+		QueryID:       s.QueryID,
+		ServerAddr:    serverAddr,
+		State:         StateNever,
+		LastSync:      syncStatus(s.LastSync),
+		LastCompleted: syncStatus(s.LastCompleted),
+		KeysPulled:    s.KeysPulled,
+	}
+	if s.LastSync != nil {
+		qs.State = StateOK
+		if s.LastSync.Completed.IsZero() {
+			qs.State = StateIncomplete
+		}
+	}
+	return qs
+}
+
+func syncStatus(s *database.FederationInSync) *SyncStatus {
+	if s == nil {
+		return nil
+	}
+	return &SyncStatus{
+		SyncID:       s.SyncID,
+		Started:      s.Started,
+		Completed:    timePtr(s.Completed),
+		Insertions:   s.Insertions,
+		MaxTimestamp: timePtr(s.MaxTimestamp),
+	}
+}
+
+// PushStatus is the push status of a push target.
+type PushStatus struct {
+	TargetID      string     `json:"targetId"`
+	Protocol      string     `json:"protocol"`
+	Endpoint      string     `json:"endpoint"`
+	State         string     `json:"state"`
+	AckedChangeID int64      `json:"ackedChangeId"`
+	KeysPushed    int64      `json:"keysPushed"`
+	LastSuccessAt *time.Time `json:"lastSuccessAt,omitempty"`
+	LastFailureAt *time.Time `json:"lastFailureAt,omitempty"`
+	Failures      int        `json:"failures,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+}
+
+func pushStatus(t *database.FederationPushTarget) *PushStatus {
+	ps := &PushStatus{
+		TargetID:      t.TargetID,
+		Protocol:      t.Protocol,
+		Endpoint:      t.Endpoint,
+		State:         StateNever,
+		AckedChangeID: t.AckedChangeID,
+		KeysPushed:    t.KeysPushed,
+		LastSuccessAt: timePtr(t.LastSuccessAt),
+		LastFailureAt: timePtr(t.LastFailureAt),
+		Failures:      t.Failures,
+		LastError:     t.LastError,
+	}
+	switch {
+	case t.Failures > 0:
+		ps.State = StateFailing
+	case !t.LastSuccessAt.IsZero():
+		ps.State = StateOK
+	}
+	return ps
+}
@@ -19,12 +19,26 @@ import (
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/scheduler"
 	"github.com/google/exposure-notifications-server/internal/setup"
 )
 
 const (
 	// DefaultAudience is the default OIDC audience.
 	DefaultAudience = "https://exposure-notifications-server/federation"
+
+	// OriginHeader is the gRPC response header in which a federation server
+	// sends its server ID. Servers only serve keys published to them, so it
+	// is the origin of every key in the response.
+	OriginHeader = "federation-origin"
+
+	// ReportTypesHeader and MinTransmissionRiskHeader are gRPC request headers
+	// that restrict the keys a fetch returns, as the fetch request has no
+	// fields for them. ReportTypesHeader is a comma-separated list of report
+	// types. A server that applied the report types echoes them in a
+	// ReportTypesHeader response header.
+	ReportTypesHeader         = "federation-report-types"
+	MinTransmissionRiskHeader = "federation-min-transmission-risk"
 )
 
 var (
@@ -44,6 +58,17 @@ type Config struct {
 	Timeout        time.Duration `envconfig:"RPC_TIMEOUT" default:"10m"`
 	TruncateWindow time.Duration `envconfig:"TRUNCATE_WINDOW" default:"1h"`
 
+	// PullInterval, if not zero, makes every federation query get pulled on
+	// its own every PullInterval, in addition to when the handler is called.
+	// Scheduler configures how instances share the runs.
+	PullInterval time.Duration `envconfig:"FEDERATION_PULL_INTERVAL" default:"0"`
+	Scheduler    *scheduler.Config
+
+	// ServerID identifies this server to federation partners; see the
+	// federationout server's FEDERATION_SERVER_ID. Responses from a partner
+	// that claims this ID are dropped, since the keys in them started here.
+	ServerID string `envconfig:"FEDERATION_SERVER_ID"`
+
 	// TLSSkipVerify, if set to true, causes the server certificate to not be verified.
 	// This is typically used when testing locally with self-signed certificates.
 	TLSSkipVerify bool `envconfig:"TLS_SKIP_VERIFY" default:"false"`
@@ -51,6 +76,12 @@ type Config struct {
 	// TLSCertFile points to an optional cert file that will be appended to the system certificates.
 	TLSCertFile string `envconfig:"TLS_CERT_FILE"`
 
+	// TLSClientCertFile and TLSClientKeyFile hold an optional client certificate
+	// and its key, presented to partners that require mutual TLS. Both must be
+	// set to use it.
+	TLSClientCertFile string `envconfig:"TLS_CLIENT_CERT_FILE"`
+	TLSClientKeyFile  string `envconfig:"TLS_CLIENT_KEY_FILE"`
+
 	// CredentialsFile points to a JSON credentials file. If running on Managed Cloud Run,
 	// or if using $GOOGLE_APPLICATION_CREDENTIALS, leave this value empty.
 	CredentialsFile string `envconfig:"CREDENTIALS_FILE"`
@@ -24,19 +24,24 @@ import (
 	"io/ioutil"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/logging"
 	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/observability"
 	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/scheduler"
+	"github.com/google/exposure-notifications-server/internal/server"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
 
 	"google.golang.org/api/idtoken"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/oauth"
+	"google.golang.org/grpc/metadata"
 )
 
 const (
@@ -48,34 +53,40 @@ var (
 )
 
 type (
-	fetchFn               func(context.Context, *pb.FederationFetchRequest, ...grpc.CallOption) (*pb.FederationFetchResponse, error)
-	insertExposuresFn     func(context.Context, []*database.Exposure) error
-	startFederationSyncFn func(context.Context, *database.FederationInQuery, time.Time) (int64, database.FinalizeSyncFn, error)
+	fetchFn                    func(context.Context, *pb.FederationFetchRequest, ...grpc.CallOption) (*pb.FederationFetchResponse, error)
+	insertExposuresFn          func(context.Context, []*database.Exposure) error
+	startFederationSyncFn      func(context.Context, *database.FederationInQuery, time.Time) (int64, database.FinalizeSyncFn, error)
+	resumableFederationSyncFn  func(context.Context, string) (*database.FederationInSync, error)
+	checkpointFederationSyncFn func(ctx context.Context, syncID int64, nextFetchToken string, maxTimestamp time.Time, totalInserted int) error
 )
 
 type pullDependencies struct {
-	fetch               fetchFn
-	insertExposures     insertExposuresFn
-	startFederationSync startFederationSyncFn
+	fetch                    fetchFn
+	insertExposures          insertExposuresFn
+	startFederationSync      startFederationSyncFn
+	resumableFederationSync  resumableFederationSyncFn
+	checkpointFederationSync checkpointFederationSyncFn
 }
 
 // NewHandler returns a handler that will fetch server-to-server
 // federation results for a single federation query.
-func NewHandler(env *serverenv.ServerEnv, config *Config) http.Handler {
-	return &handler{
+func NewHandler(env *serverenv.ServerEnv, config *Config) *Handler {
+	return &Handler{
 		env:    env,
 		db:     env.Database(),
 		config: config,
 	}
 }
 
-type handler struct {
+// Handler pulls the query named by its request or, see Jobs, every query on
+// a schedule of its own.
+type Handler struct {
 	env    *serverenv.ServerEnv
 	db     *database.DB
 	config *Config
 }
 
-func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	logger := logging.FromContext(ctx)
 	metrics := h.env.MetricsExporter(ctx)
@@ -98,52 +109,124 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Obtain lock to make sure there are no other processes working on this batch.
+	err := h.pullLocked(ctx, queryID)
+	switch {
+	case err == nil:
+	case errors.Is(err, database.ErrAlreadyLocked):
+		msg := fmt.Sprintf("Query %s is already being pulled. No work will be performed.", queryID)
+		logger.Infof(msg)
+		w.Write([]byte(msg)) // We return status 200 here so that Cloud Scheduler does not retry.
+	case errors.Is(err, database.ErrNotFound):
+		badRequestf(ctx, w, "unknown %s", queryParam)
+	default:
+		internalErrorf(ctx, w, "Federation query %q failed: %v", queryID, err)
+	}
+}
+
+// Jobs returns the scheduler job that pulls every federation query every
+// FEDERATION_PULL_INTERVAL, for deployments without a scheduler to call the
+// handler, or no job if FEDERATION_PULL_INTERVAL is zero.
+func (h *Handler) Jobs() []*scheduler.Job {
+	if h.config.PullInterval <= 0 {
+		return nil
+	}
+	return []*scheduler.Job{{
+		Name:     "federation-in",
+		Interval: h.config.PullInterval,
+		Run:      h.pullAll,
+	}}
+}
+
+// pullAll pulls every federation query. Queries that are being pulled
+// elsewhere are skipped, and a failed query doesn't stop the others.
+func (h *Handler) pullAll(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+
+	queries, err := h.db.ListFederationInQueries(ctx)
+	if err != nil {
+		return fmt.Errorf("listing federation queries: %w", err)
+	}
+	failed := 0
+	for _, query := range queries {
+		err := h.pullLocked(ctx, query.QueryID)
+		switch {
+		case err == nil:
+		case errors.Is(err, database.ErrAlreadyLocked):
+			logger.Infof("Query %s is already being pulled, skipping it", query.QueryID)
+		default:
+			logger.Errorf("Federation query %q failed: %v", query.QueryID, err)
+			failed++
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if server.IsDraining(ctx) {
+			logger.Infof("Shutting down, leaving the remaining queries for the next pull")
+			break
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d federation queries failed", failed, len(queries))
+	}
+	return nil
+}
+
+// pullLocked pulls the query while holding its lock, so that no other process
+// pulls it at the same time. It returns database.ErrAlreadyLocked if another
+// process holds the lock.
+func (h *Handler) pullLocked(ctx context.Context, queryID string) error {
 	lock := "query_" + queryID
 	unlockFn, err := h.db.Lock(ctx, lock, h.config.Timeout)
 	if err != nil {
 		if errors.Is(err, database.ErrAlreadyLocked) {
-			metrics.WriteInt("federation-pull-lock-contention", true, 1)
-			msg := fmt.Sprintf("Lock %s already in use. No work will be performed.", lock)
-			logger.Infof(msg)
-			w.Write([]byte(msg)) // We return status 200 here so that Cloud Scheduler does not retry.
-			return
+			h.env.MetricsExporter(ctx).WriteInt("federation-pull-lock-contention", true, 1)
+			return err
 		}
-		internalErrorf(ctx, w, "Could not acquire lock %s for query %s: %v", lock, queryID, err)
-		return
+		return fmt.Errorf("acquiring lock %s: %w", lock, err)
 	}
 	defer unlockFn()
 
+	return h.pullQuery(ctx, queryID)
+}
+
+// pullQuery dials the partner of the query and pulls its new keys. It returns
+// database.ErrNotFound if there is no such query.
+func (h *Handler) pullQuery(ctx context.Context, queryID string) error {
+	logger := logging.FromContext(ctx)
+	metrics := h.env.MetricsExporter(ctx)
+
 	query, err := h.db.GetFederationInQuery(ctx, queryID)
 	if err != nil {
 		if errors.Is(err, database.ErrNotFound) {
-			badRequestf(ctx, w, "unknown %s", queryParam)
-			return
+			return err
 		}
-		internalErrorf(ctx, w, "Failed getting query %q: %v", queryID, err)
-		return
+		return fmt.Errorf("getting query: %w", err)
 	}
 
 	cp, err := x509.SystemCertPool()
 	if err != nil {
-		internalErrorf(ctx, w, "Failed to access system cert pool: %v", err)
-		return
+		return fmt.Errorf("accessing system cert pool: %w", err)
 	}
 
 	if h.config.TLSCertFile != "" {
 		b, err := ioutil.ReadFile(h.config.TLSCertFile)
 		if err != nil {
-			internalErrorf(ctx, w, "Failed to read cert file %q: %v", h.config.TLSCertFile, err)
-			return
+			return fmt.Errorf("reading cert file %q: %w", h.config.TLSCertFile, err)
 		}
 		if !cp.AppendCertsFromPEM(b) {
-			internalErrorf(ctx, w, "Failed to append credentials")
-			return
+			return fmt.Errorf("failed to append credentials")
 		}
 	}
 
 	tlsConfig := &tls.Config{RootCAs: cp, InsecureSkipVerify: h.config.TLSSkipVerify}
-	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))}
+	if h.config.TLSClientCertFile != "" || h.config.TLSClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(h.config.TLSClientCertFile, h.config.TLSClientKeyFile)
+		if err != nil {
+			return fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)), observability.GRPCDialOption()}
 
 	var clientOpts []idtoken.ClientOption
 	if h.config.CredentialsFile != "" {
@@ -151,16 +234,14 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	ts, err := idtoken.NewTokenSource(ctx, query.Audience, clientOpts...)
 	if err != nil {
-		internalErrorf(ctx, w, "Failed to create token source: %v", err)
-		return
+		return fmt.Errorf("creating token source: %w", err)
 	}
 	dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(oauth.TokenSource{ts}))
 
 	logger.Infof("Dialing %s", query.ServerAddr)
 	conn, err := grpc.Dial(query.ServerAddr, dialOpts...)
 	if err != nil {
-		internalErrorf(ctx, w, "Failed to dial for query %q %s: %v", queryID, query.ServerAddr, err)
-		return
+		return fmt.Errorf("dialing %s: %w", query.ServerAddr, err)
 	}
 	defer conn.Close()
 	client := pb.NewFederationClient(conn)
@@ -169,22 +250,29 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	deps := pullDependencies{
-		fetch:               client.Fetch,
-		insertExposures:     h.db.InsertExposures,
-		startFederationSync: h.db.StartFederationInSync,
+		fetch:                    client.Fetch,
+		insertExposures:          h.db.BulkInsertExposures,
+		startFederationSync:      h.db.StartFederationInSync,
+		resumableFederationSync:  h.db.ResumableFederationInSync,
+		checkpointFederationSync: h.db.CheckpointFederationInSync,
 	}
 	batchStart := time.Now()
-	if err := pull(timeoutContext, metrics, deps, query, batchStart, h.config.TruncateWindow); err != nil {
-		internalErrorf(ctx, w, "Federation query %q failed: %v", queryID, err)
-		return
+	if err := pull(timeoutContext, metrics, deps, query, h.config.ServerID, batchStart, h.config.TruncateWindow); err != nil {
+		return err
 	}
 
 	if timeoutContext.Err() != nil && timeoutContext.Err() == context.DeadlineExceeded {
 		logger.Infof("Federation puller timed out at %v before fetching entire set.", h.config.Timeout)
 	}
+	return nil
 }
 
-func pull(ctx context.Context, metrics metrics.Exporter, deps pullDependencies, q *database.FederationInQuery, batchStart time.Time, truncateWindow time.Duration) error {
+// pull fetches the query's new keys from the partner and inserts them. Each
+// key is recorded with the partner's server ID as its origin, or the partner's
+// address if it doesn't send one. A response whose origin is serverID holds
+// keys that started on this server and is dropped, which breaks loops between
+// partners that federate with each other.
+func pull(ctx context.Context, metrics metrics.Exporter, deps pullDependencies, q *database.FederationInQuery, serverID string, batchStart time.Time, truncateWindow time.Duration) (err error) {
 	logger := logging.FromContext(ctx)
 	logger.Infof("Processing query %q", q.QueryID)
 
@@ -194,6 +282,13 @@ func pull(ctx context.Context, metrics metrics.Exporter, deps pullDependencies,
 		LastFetchResponseKeyTimestamp: q.LastTimestamp.Unix(),
 	}
 
+	// If the previous pull of this query failed midway, resume from the last
+	// page it finished instead of fetching the whole window again.
+	resumed, err := deps.resumableFederationSync(ctx, q.QueryID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return fmt.Errorf("reading resumable federation sync for query %s: %w", q.QueryID, err)
+	}
+
 	syncID, finalizeFn, err := deps.startFederationSync(ctx, q, batchStart)
 	if err != nil {
 		return fmt.Errorf("starting federation sync for query %s: %w", q.QueryID, err)
@@ -203,19 +298,68 @@ func pull(ctx context.Context, metrics metrics.Exporter, deps pullDependencies,
 	total := 0
 	defer func() {
 		logger.Infof("Inserted %d keys", total)
+		recordSync(total, err)
 	}()
 
+	if resumed != nil {
+		logger.Infof("Resuming query %q from unfinished sync %d", q.QueryID, resumed.SyncID)
+		metrics.WriteInt("federation-pull-resumed", true, 1)
+		request.NextFetchToken = resumed.NextFetchToken
+		maxTimestamp = resumed.MaxTimestamp
+		// Hand the token on at once, so that it isn't lost if this sync also fails
+		// before its first checkpoint.
+		if err := deps.checkpointFederationSync(ctx, syncID, request.NextFetchToken, maxTimestamp, 0); err != nil {
+			return fmt.Errorf("checkpointing federation sync for query %s: %w", q.QueryID, err)
+		}
+	}
+
+	// Ask the partner to filter the keys as the query does.
+	fetchCtx := ctx
+	if len(q.ReportTypes) > 0 {
+		fetchCtx = metadata.AppendToOutgoingContext(fetchCtx, ReportTypesHeader, strings.Join(q.ReportTypes, ","))
+	}
+	if q.MinTransmissionRisk > 0 {
+		fetchCtx = metadata.AppendToOutgoingContext(fetchCtx, MinTransmissionRiskHeader, strconv.Itoa(q.MinTransmissionRisk))
+	}
+
 	createdAt := database.TruncateWindow(batchStart, truncateWindow)
 	partial := true
 	for partial {
 
-		// TODO(squee1945): react to the context timeout and complete a chunk of work so next invocation can pick up where left off.
+		// Leave the sync unfinished when out of time; the next pull resumes from
+		// its last checkpoint.
+		if ctx.Err() != nil {
+			logger.Infof("Query %q ran out of time, leaving the rest for the next pull.", q.QueryID)
+			return nil
+		}
+		if server.IsDraining(ctx) {
+			logger.Infof("Query %q interrupted by shutdown, leaving the rest for the next pull.", q.QueryID)
+			return nil
+		}
 
-		response, err := deps.fetch(ctx, request)
+		var header metadata.MD
+		response, err := deps.fetch(fetchCtx, request, grpc.Header(&header))
 		if err != nil {
 			return fmt.Errorf("fetching query %s: %w", q.QueryID, err)
 		}
 
+		origin := q.ServerAddr
+		if v := header.Get(OriginHeader); len(v) > 0 && v[0] != "" {
+			origin = v[0]
+		}
+		if serverID != "" && origin == serverID {
+			logger.Warnf("Query %s returned keys that originated on this server, dropping them.", q.QueryID)
+			metrics.WriteInt("federation-pull-loop-detected", true, 1)
+			response.Response = nil
+		}
+		// Report types aren't sent with the keys, so only a partner that
+		// confirms it applied the query's report types can be trusted.
+		if len(q.ReportTypes) > 0 && !reportTypesApplied(header.Get(ReportTypesHeader), q.ReportTypes) {
+			logger.Warnf("Query %s partner did not apply report types %v, dropping its keys.", q.QueryID, q.ReportTypes)
+			metrics.WriteInt("federation-pull-report-types-unfiltered", true, 1)
+			response.Response = nil
+		}
+
 		responseTimestamp := time.Unix(response.FetchResponseKeyTimestamp, 0)
 		if responseTimestamp.After(maxTimestamp) {
 			maxTimestamp = responseTimestamp
@@ -225,13 +369,19 @@ func pull(ctx context.Context, metrics metrics.Exporter, deps pullDependencies,
 		var exposures []*database.Exposure
 		for _, ctr := range response.Response {
 
-			var upperRegions []string
-			for _, region := range ctr.RegionIdentifiers {
-				upperRegions = append(upperRegions, strings.ToUpper(strings.TrimSpace(region)))
+			upperRegions := IngestRegions(ctr.RegionIdentifiers, q)
+			if len(upperRegions) == 0 {
+				logger.Infof("Dropping keys in disallowed regions %v", ctr.RegionIdentifiers)
+				metrics.WriteInt("federation-pull-disallowed-regions", true, 1)
+				continue
 			}
-			sort.Strings(upperRegions)
 
 			for _, cti := range ctr.ContactTracingInfo {
+				if int(cti.TransmissionRisk) < q.MinTransmissionRisk {
+					metrics.WriteInt("federation-pull-below-min-risk", false, len(cti.ExposureKeys))
+					continue
+				}
+
 				for _, key := range cti.ExposureKeys {
 
 					if cti.TransmissionRisk < database.MinTransmissionRisk || cti.TransmissionRisk > database.MaxTransmissionRisk {
@@ -244,6 +394,7 @@ func pull(ctx context.Context, metrics metrics.Exporter, deps pullDependencies,
 						ExposureKey:      key.ExposureKey,
 						Regions:          upperRegions,
 						FederationSyncID: syncID,
+						Origin:           origin,
 						IntervalNumber:   key.IntervalNumber,
 						IntervalCount:    key.IntervalCount,
 						CreatedAt:        createdAt,
@@ -271,6 +422,13 @@ func pull(ctx context.Context, metrics metrics.Exporter, deps pullDependencies,
 
 		partial = response.PartialResponse
 		request.NextFetchToken = response.NextFetchToken
+
+		// Every key of the page is stored, so a later pull can resume after it.
+		if partial {
+			if err := deps.checkpointFederationSync(ctx, syncID, request.NextFetchToken, maxTimestamp, total); err != nil {
+				return fmt.Errorf("checkpointing federation sync for query %s: %w", q.QueryID, err)
+			}
+		}
 	}
 
 	if err := finalizeFn(maxTimestamp, total); err != nil {
@@ -281,6 +439,77 @@ func pull(ctx context.Context, metrics metrics.Exporter, deps pullDependencies,
 	return nil
 }
 
+// recordSync counts a finished pull that inserted keys in the Prometheus
+// metrics.
+func recordSync(keys int, err error) {
+	metrics.FederationSyncs.WithLabelValues("in", metrics.Result(err)).Inc()
+	metrics.FederationKeys.WithLabelValues("in").Add(float64(keys))
+}
+
+// reportTypesApplied reports whether the report types a partner echoed are
+// all among the wanted ones. Nothing echoed means the partner didn't filter.
+func reportTypesApplied(echoed []string, want []string) bool {
+	if len(echoed) == 0 || echoed[0] == "" {
+		return false
+	}
+	for _, t := range strings.Split(echoed[0], ",") {
+		found := false
+		for _, w := range want {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// IngestRegions upper-cases the partner's region identifiers, drops those the
+// query doesn't allow and translates the rest through the query's region map.
+// The allowed regions are in the partner's terms, as they are sent in the
+// fetch request. The result is sorted and free of the duplicates that mapping
+// can introduce.
+func IngestRegions(regions []string, q *database.FederationInQuery) []string {
+	seen := make(map[string]struct{}, len(regions))
+	var result []string
+	for _, region := range regions {
+		region = strings.ToUpper(strings.TrimSpace(region))
+		if !regionAllowed(region, q) {
+			continue
+		}
+		if local, ok := q.RegionMap[region]; ok {
+			region = local
+		}
+		if _, ok := seen[region]; ok {
+			continue
+		}
+		seen[region] = struct{}{}
+		result = append(result, region)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func regionAllowed(region string, q *database.FederationInQuery) bool {
+	for _, r := range q.ExcludeRegions {
+		if r == region {
+			return false
+		}
+	}
+	if len(q.IncludeRegions) == 0 {
+		return true
+	}
+	for _, r := range q.IncludeRegions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
 func badRequestf(ctx context.Context, w http.ResponseWriter, format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
 	logging.FromContext(ctx).Debug(msg)
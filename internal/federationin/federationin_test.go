@@ -16,6 +16,7 @@ package federationin
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -26,6 +27,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 var (
@@ -45,15 +47,37 @@ func makeRemoteExposure(diagKey *pb.ExposureKey, diagStatus int, regions ...stri
 	return inf
 }
 
+func withOrigin(e *database.Exposure, origin string) *database.Exposure {
+	e.Origin = origin
+	return e
+}
+
 // remoteFetchServer mocks responses from the remote federation server.
 type remoteFetchServer struct {
 	responses []*pb.FederationFetchResponse
+	origin    string
 	gotTokens []string
 	index     int
+
+	// applyReportTypes echoes the requested report types, as a server that
+	// filters by them does.
+	applyReportTypes bool
 }
 
 func (r *remoteFetchServer) fetch(ctx context.Context, req *pb.FederationFetchRequest, opts ...grpc.CallOption) (*pb.FederationFetchResponse, error) {
 	r.gotTokens = append(r.gotTokens, req.NextFetchToken)
+	header := metadata.MD{}
+	if r.origin != "" {
+		header.Set(OriginHeader, r.origin)
+	}
+	if out, ok := metadata.FromOutgoingContext(ctx); ok && r.applyReportTypes {
+		header.Set(ReportTypesHeader, out.Get(ReportTypesHeader)...)
+	}
+	for _, opt := range opts {
+		if h, ok := opt.(grpc.HeaderCallOption); ok {
+			*h.HeaderAddr = header
+		}
+	}
 	if r.responses == nil || r.index > len(r.responses) {
 		return &pb.FederationFetchResponse{}, nil
 	}
@@ -79,6 +103,24 @@ type syncDB struct {
 	completed     time.Time
 	maxTimestamp  time.Time
 	totalInserted int
+
+	// resumable is the unfinished sync to resume, if any; checkpoints records
+	// the checkpointed fetch tokens.
+	resumable   *database.FederationInSync
+	checkpoints []string
+}
+
+func (sdb *syncDB) resumableFederationSync(ctx context.Context, queryID string) (*database.FederationInSync, error) {
+	if sdb.resumable == nil {
+		return nil, database.ErrNotFound
+	}
+	return sdb.resumable, nil
+}
+
+func (sdb *syncDB) checkpointFederationSync(ctx context.Context, syncID int64, nextFetchToken string, maxTimestamp time.Time, totalInserted int) error {
+	sdb.checkpoints = append(sdb.checkpoints, nextFetchToken)
+	sdb.maxTimestamp = maxTimestamp
+	return nil
 }
 
 func (sdb *syncDB) startFederationSync(ctx context.Context, query *database.FederationInQuery, start time.Time) (int64, database.FinalizeSyncFn, error) {
@@ -98,6 +140,14 @@ func TestFederationPull(t *testing.T) {
 	testCases := []struct {
 		name             string
 		batchSize        int
+		origin           string
+		serverID         string
+		regionMap        map[string]string
+		includeRegions   []string
+		excludeRegions   []string
+		reportTypes      []string
+		minRisk          int
+		applyReportTypes bool
 		fetchResponses   []*pb.FederationFetchResponse
 		wantExposures    []*database.Exposure
 		wantTokens       []string
@@ -221,6 +271,78 @@ func TestFederationPull(t *testing.T) {
 			wantTokens:       []string{"", "abcdef"},
 			wantMaxTimestamp: time.Unix(400, 0),
 		},
+		{
+			name:      "mapped regions",
+			regionMap: map[string]string{"UK": "GB", "GBR": "GB"},
+			fetchResponses: []*pb.FederationFetchResponse{
+				{
+					Response: []*pb.ContactTracingResponse{
+						{
+							ContactTracingInfo: []*pb.ContactTracingInfo{
+								{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+							},
+							RegionIdentifiers: []string{"uk"},
+						},
+						{
+							ContactTracingInfo: []*pb.ContactTracingInfo{
+								{TransmissionRisk: 2, ExposureKeys: []*pb.ExposureKey{bbb}},
+							},
+							RegionIdentifiers: []string{"UK", "GBR", "IE"},
+						},
+					},
+					FetchResponseKeyTimestamp: 200,
+				},
+			},
+			wantExposures: []*database.Exposure{
+				makeRemoteExposure(aaa, 1, "GB"),
+				makeRemoteExposure(bbb, 2, "GB", "IE"),
+			},
+			wantTokens:       []string{""},
+			wantMaxTimestamp: time.Unix(200, 0),
+		},
+		{
+			name:           "disallowed regions",
+			includeRegions: []string{"UK", "IE", "FR"},
+			excludeRegions: []string{"FR"},
+			regionMap:      map[string]string{"UK": "GB"},
+			fetchResponses: []*pb.FederationFetchResponse{
+				{
+					Response: []*pb.ContactTracingResponse{
+						{
+							ContactTracingInfo: []*pb.ContactTracingInfo{
+								{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+							},
+							RegionIdentifiers: []string{"UK", "US"},
+						},
+						{
+							ContactTracingInfo: []*pb.ContactTracingInfo{
+								{TransmissionRisk: 2, ExposureKeys: []*pb.ExposureKey{bbb}},
+							},
+							RegionIdentifiers: []string{"FR"},
+						},
+						{
+							ContactTracingInfo: []*pb.ContactTracingInfo{
+								{TransmissionRisk: 3, ExposureKeys: []*pb.ExposureKey{ccc}},
+							},
+							RegionIdentifiers: []string{"US", "CA"},
+						},
+						{
+							ContactTracingInfo: []*pb.ContactTracingInfo{
+								{TransmissionRisk: 4, ExposureKeys: []*pb.ExposureKey{ddd}},
+							},
+							RegionIdentifiers: []string{"IE", "FR"},
+						},
+					},
+					FetchResponseKeyTimestamp: 400,
+				},
+			},
+			wantExposures: []*database.Exposure{
+				makeRemoteExposure(aaa, 1, "GB"),
+				makeRemoteExposure(ddd, 4, "IE"),
+			},
+			wantTokens:       []string{""},
+			wantMaxTimestamp: time.Unix(400, 0),
+		},
 		{
 			name:      "too large for batch",
 			batchSize: 2,
@@ -258,13 +380,129 @@ func TestFederationPull(t *testing.T) {
 			wantTokens:       []string{""},
 			wantMaxTimestamp: time.Unix(400, 0),
 		},
+		{
+			name:   "records origin",
+			origin: "partner",
+			fetchResponses: []*pb.FederationFetchResponse{
+				{
+					Response: []*pb.ContactTracingResponse{
+						{
+							ContactTracingInfo: []*pb.ContactTracingInfo{
+								{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+							},
+							RegionIdentifiers: []string{"US"},
+						},
+					},
+					FetchResponseKeyTimestamp: 400,
+				},
+			},
+			wantExposures: []*database.Exposure{
+				withOrigin(makeRemoteExposure(aaa, 1, "US"), "partner"),
+			},
+			wantTokens:       []string{""},
+			wantMaxTimestamp: time.Unix(400, 0),
+		},
+		{
+			name:     "drops keys that originated here",
+			origin:   "us",
+			serverID: "us",
+			fetchResponses: []*pb.FederationFetchResponse{
+				{
+					Response: []*pb.ContactTracingResponse{
+						{
+							ContactTracingInfo: []*pb.ContactTracingInfo{
+								{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+							},
+							RegionIdentifiers: []string{"US"},
+						},
+					},
+					FetchResponseKeyTimestamp: 400,
+				},
+			},
+			wantTokens:       []string{""},
+			wantMaxTimestamp: time.Unix(400, 0),
+		},
+		{
+			name:    "drops keys below minimum risk",
+			minRisk: 2,
+			fetchResponses: []*pb.FederationFetchResponse{
+				{
+					Response: []*pb.ContactTracingResponse{
+						{
+							ContactTracingInfo: []*pb.ContactTracingInfo{
+								{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+								{TransmissionRisk: 3, ExposureKeys: []*pb.ExposureKey{bbb}},
+							},
+							RegionIdentifiers: []string{"US"},
+						},
+					},
+					FetchResponseKeyTimestamp: 400,
+				},
+			},
+			wantExposures: []*database.Exposure{
+				makeRemoteExposure(bbb, 3, "US"),
+			},
+			wantTokens:       []string{""},
+			wantMaxTimestamp: time.Unix(400, 0),
+		},
+		{
+			name:             "partner applied report types",
+			reportTypes:      []string{database.ReportTypeConfirmed},
+			applyReportTypes: true,
+			fetchResponses: []*pb.FederationFetchResponse{
+				{
+					Response: []*pb.ContactTracingResponse{
+						{
+							ContactTracingInfo: []*pb.ContactTracingInfo{
+								{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+								{TransmissionRisk: 3, ExposureKeys: []*pb.ExposureKey{bbb}},
+							},
+							RegionIdentifiers: []string{"US"},
+						},
+					},
+					FetchResponseKeyTimestamp: 400,
+				},
+			},
+			wantExposures: []*database.Exposure{
+				makeRemoteExposure(aaa, 1, "US"),
+				makeRemoteExposure(bbb, 3, "US"),
+			},
+			wantTokens:       []string{""},
+			wantMaxTimestamp: time.Unix(400, 0),
+		},
+		{
+			name:        "partner ignored report types",
+			reportTypes: []string{database.ReportTypeConfirmed},
+			fetchResponses: []*pb.FederationFetchResponse{
+				{
+					Response: []*pb.ContactTracingResponse{
+						{
+							ContactTracingInfo: []*pb.ContactTracingInfo{
+								{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+								{TransmissionRisk: 3, ExposureKeys: []*pb.ExposureKey{bbb}},
+							},
+							RegionIdentifiers: []string{"US"},
+						},
+					},
+					FetchResponseKeyTimestamp: 400,
+				},
+			},
+			wantTokens:       []string{""},
+			wantMaxTimestamp: time.Unix(400, 0),
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			ctx := context.Background()
-			query := &database.FederationInQuery{}
-			remote := remoteFetchServer{responses: tc.fetchResponses}
+			query := &database.FederationInQuery{
+				IncludeRegions:      tc.includeRegions,
+				ExcludeRegions:      tc.excludeRegions,
+				RegionMap:           tc.regionMap,
+				ReportTypes:         tc.reportTypes,
+				MinTransmissionRisk: tc.minRisk,
+			}
+			remote := remoteFetchServer{responses: tc.fetchResponses, origin: tc.origin, applyReportTypes: tc.applyReportTypes}
 			idb := exposureDB{}
 			sdb := syncDB{}
 			batchStart := time.Now()
@@ -274,12 +512,14 @@ func TestFederationPull(t *testing.T) {
 				defer func() { fetchBatchSize = oldBatchSize }()
 			}
 			deps := pullDependencies{
-				fetch:               remote.fetch,
-				insertExposures:     idb.insertExposures,
-				startFederationSync: sdb.startFederationSync,
+				fetch:                    remote.fetch,
+				insertExposures:          idb.insertExposures,
+				startFederationSync:      sdb.startFederationSync,
+				resumableFederationSync:  sdb.resumableFederationSync,
+				checkpointFederationSync: sdb.checkpointFederationSync,
 			}
 
-			err := pull(ctx, metrics.NewLogsBasedFromContext(ctx), deps, query, batchStart, time.Hour)
+			err := pull(ctx, metrics.NewLogsBasedFromContext(ctx), deps, query, tc.serverID, batchStart, time.Hour)
 			if err != nil {
 				t.Fatalf("pull returned err=%v, want err=nil", err)
 			}
@@ -309,6 +549,97 @@ func TestFederationPull(t *testing.T) {
 	}
 }
 
+// pagedFetchServer mocks a remote federation server that serves a page per
+// fetch token, and drops the stream once when a token in fail is fetched.
+type pagedFetchServer struct {
+	pages     map[string]*pb.FederationFetchResponse
+	fail      map[string]bool
+	gotTokens []string
+}
+
+func (p *pagedFetchServer) fetch(ctx context.Context, req *pb.FederationFetchRequest, opts ...grpc.CallOption) (*pb.FederationFetchResponse, error) {
+	p.gotTokens = append(p.gotTokens, req.NextFetchToken)
+	if p.fail[req.NextFetchToken] {
+		delete(p.fail, req.NextFetchToken)
+		return nil, errors.New("stream reset")
+	}
+	return p.pages[req.NextFetchToken], nil
+}
+
+// TestFederationPullResume kills a pull midway and checks that the next pull
+// resumes after the last page that was stored.
+func TestFederationPullResume(t *testing.T) {
+	ctx := context.Background()
+	page := func(key *pb.ExposureKey, ts int64, next string) *pb.FederationFetchResponse {
+		return &pb.FederationFetchResponse{
+			Response: []*pb.ContactTracingResponse{{
+				ContactTracingInfo: []*pb.ContactTracingInfo{{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{key}}},
+				RegionIdentifiers:  []string{"US"},
+			}},
+			PartialResponse:           next != "",
+			NextFetchToken:            next,
+			FetchResponseKeyTimestamp: ts,
+		}
+	}
+	remote := &pagedFetchServer{
+		pages: map[string]*pb.FederationFetchResponse{
+			"":   page(aaa, 100, "t1"),
+			"t1": page(bbb, 200, "t2"),
+			"t2": page(ccc, 300, ""),
+		},
+		fail: map[string]bool{"t2": true},
+	}
+	query := &database.FederationInQuery{QueryID: "partner"}
+	idb := exposureDB{}
+
+	// The first pull stores two pages, then the stream dies.
+	first := &syncDB{}
+	deps := pullDependencies{
+		fetch:                    remote.fetch,
+		insertExposures:          idb.insertExposures,
+		startFederationSync:      first.startFederationSync,
+		resumableFederationSync:  first.resumableFederationSync,
+		checkpointFederationSync: first.checkpointFederationSync,
+	}
+	if err := pull(ctx, metrics.NewLogsBasedFromContext(ctx), deps, query, "", time.Now(), time.Hour); err == nil {
+		t.Fatalf("pull: expected error")
+	}
+	if first.syncCompleted {
+		t.Errorf("failed sync was completed")
+	}
+	if diff := cmp.Diff([]string{"t1", "t2"}, first.checkpoints); diff != "" {
+		t.Errorf("checkpoints mismatch (-want +got):\n%s", diff)
+	}
+
+	// The second pull resumes from the last checkpoint.
+	second := &syncDB{
+		resumable: &database.FederationInSync{SyncID: syncID, NextFetchToken: "t2", MaxTimestamp: time.Unix(200, 0)},
+	}
+	deps.startFederationSync = second.startFederationSync
+	deps.resumableFederationSync = second.resumableFederationSync
+	deps.checkpointFederationSync = second.checkpointFederationSync
+	if err := pull(ctx, metrics.NewLogsBasedFromContext(ctx), deps, query, "", time.Now(), time.Hour); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	if diff := cmp.Diff([]string{"", "t1", "t2", "t2"}, remote.gotTokens); diff != "" {
+		t.Errorf("tokens mismatch (-want +got):\n%s", diff)
+	}
+	if !second.syncCompleted || second.totalInserted != 1 {
+		t.Errorf("resumed sync: got completed %t with %d keys, want completed with 1 key", second.syncCompleted, second.totalInserted)
+	}
+	if want := time.Unix(300, 0); !second.maxTimestamp.Equal(want) {
+		t.Errorf("resumed sync: got max timestamp %v, want %v", second.maxTimestamp, want)
+	}
+
+	var got []int32
+	for _, e := range idb.exposures {
+		got = append(got, e.IntervalNumber)
+	}
+	if diff := cmp.Diff([]int32{1, 2, 3}, got); diff != "" {
+		t.Errorf("inserted keys mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func makeExposure(diagKey *pb.ExposureKey, diagStatus int, regions ...string) *database.Exposure {
 	return &database.Exposure{
 		Regions:          regions,
@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/ratelimit"
 	"github.com/google/exposure-notifications-server/internal/setup"
 )
 
@@ -31,6 +32,34 @@ type Config struct {
 	Timeout        time.Duration `envconfig:"RPC_TIMEOUT" default:"5m"`
 	TruncateWindow time.Duration `envconfig:"TRUNCATE_WINDOW" default:"1h"`
 
+	// HealthPort is the port that the gRPC server serves /healthz and /readyz
+	// on over HTTP.
+	HealthPort string `envconfig:"HEALTH_PORT" default:"8081"`
+
+	// ServerID identifies this server to federation partners. If set, it is
+	// sent with each fetch response, and partners record it as the origin of
+	// the keys they pull.
+	ServerID string `envconfig:"FEDERATION_SERVER_ID"`
+
+	// MaxResponseBytes bounds the approximate encoded size of a single fetch
+	// response. Once a response would grow past it, the remaining keys are
+	// left for the next page and the client is handed a fetch token. It should
+	// stay below the clients' gRPC receive limit, which is 4MB by default. Zero
+	// disables the limit.
+	MaxResponseBytes int `envconfig:"MAX_RESPONSE_BYTES" default:"2097152"`
+
+	// QuotaRequestsPerMinute and QuotaKeysPerDay are the default quotas of
+	// each partner: at most QuotaRequestsPerMinute fetch requests in any
+	// minute, and QuotaKeysPerDay keys served per UTC day. Partners over a
+	// quota get RESOURCE_EXHAUSTED. A partner's authorization can override
+	// either. Zero disables the quota.
+	QuotaRequestsPerMinute int `envconfig:"QUOTA_REQUESTS_PER_MINUTE" default:"0"`
+	QuotaKeysPerDay        int `envconfig:"QUOTA_KEYS_PER_DAY" default:"0"`
+
+	// RateLimit selects where request rates are tracked. Only its type and
+	// Redis settings are used; the limits are the quotas above.
+	RateLimit *ratelimit.Config
+
 	// AllowAnyClient, if true, removes authentication requirements on the federation endpoint.
 	// In practise, this is only useful in local testing.
 	AllowAnyClient bool `envconfig:"ALLOW_ANY_CLIENT" default:"false"`
@@ -40,6 +69,24 @@ type Config struct {
 	// Managed Cloud Run where the TLS termination is handled by the environment.
 	TLSCertFile string `envconfig:"TLS_CERT_FILE"`
 	TLSKeyFile  string `envconfig:"TLS_KEY_FILE"`
+
+	// TLSClientCAFile holds the pinned certificate authorities of partners that
+	// authenticate with mutual TLS. If set, client certificates signed by these
+	// CAs identify the partner, and TLSCertFile and TLSKeyFile must also be set.
+	// Partners without a certificate can still authenticate with an ID token.
+	TLSClientCAFile string `envconfig:"TLS_CLIENT_CA_FILE"`
+
+	// OIDCIssuers are the issuers whose ID tokens are accepted. Their signing
+	// keys are found through OpenID Connect discovery.
+	OIDCIssuers []string `envconfig:"OIDC_ISSUERS" default:"https://accounts.google.com,accounts.google.com"`
+
+	// OIDCAudiences, if set, restricts ID tokens to those issued for one of
+	// these audiences, in addition to any audience set on the partner's
+	// authorization.
+	OIDCAudiences []string `envconfig:"OIDC_AUDIENCES"`
+
+	// OIDCKeysCacheDuration is how long the issuers' signing keys are cached.
+	OIDCKeysCacheDuration time.Duration `envconfig:"OIDC_KEYS_CACHE_DURATION" default:"1h"`
 }
 
 // DB returns the database config.
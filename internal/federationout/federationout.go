@@ -17,46 +17,79 @@ package federationout
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/federationin"
 	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
 	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/ratelimit"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
-	"google.golang.org/api/idtoken"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 const (
 	authHeader = "authorization"
 	bearer     = "Bearer"
+
+	x509IssuerPrefix = "x509:"
 )
 
+// errResponseFull is returned from the iteration callback to stop once the
+// response has reached the configured size, or the partner's remaining keys.
+var errResponseFull = errors.New("response size limit reached")
+
 // Compile time assert that this server implements the required grpc interface.
 var _ pb.FederationServer = (*Server)(nil)
 
-type iterateExposuresFunc func(context.Context, database.IterateExposuresCriteria, func(*database.Exposure) error) (string, error)
+type (
+	iterateExposuresFunc func(context.Context, database.IterateExposuresCriteria, func(*database.Exposure) error) (string, error)
+	keysServedFunc       func(ctx context.Context, issuer, subject string, day time.Time) (int64, error)
+)
 
 // NewServer builds a new FederationServer.
-func NewServer(env *serverenv.ServerEnv, config *Config) pb.FederationServer {
-	return &Server{
-		env:    env,
-		db:     env.Database(),
-		config: config,
+func NewServer(env *serverenv.ServerEnv, config *Config) (pb.FederationServer, error) {
+	// Responses are paged with cursors that partners hand back.
+	if !env.Database().SignsCursors() {
+		return nil, fmt.Errorf("DB_CURSOR_SECRET is required to sign fetch tokens")
+	}
+
+	var limiter ratelimit.Store = ratelimit.NewMemoryStore()
+	if config.RateLimit != nil {
+		var err error
+		if limiter, err = ratelimit.New(context.Background(), config.RateLimit); err != nil {
+			return nil, fmt.Errorf("ratelimit.New: %w", err)
+		}
 	}
+	return &Server{
+		env:     env,
+		db:      env.Database(),
+		config:  config,
+		tokens:  newTokenVerifier(config.OIDCIssuers, config.OIDCAudiences, config.OIDCKeysCacheDuration),
+		limiter: limiter,
+	}, nil
 }
 
 type Server struct {
-	env    *serverenv.ServerEnv
-	db     *database.DB
-	config *Config
+	env     *serverenv.ServerEnv
+	db      *database.DB
+	config  *Config
+	tokens  *tokenVerifier
+	limiter ratelimit.Store
 }
 
 type authKey struct{}
@@ -66,16 +99,115 @@ func (s Server) Fetch(ctx context.Context, req *pb.FederationFetchRequest) (*pb.
 	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
 	defer cancel()
 	logger := logging.FromContext(ctx)
-	response, err := s.fetch(ctx, req, s.db.IterateExposures, database.TruncateWindow(time.Now(), s.config.TruncateWindow)) // Don't fetch the current window, which isn't complete yet. TODO(squee1945): should I double this for safety?
+	if s.config.ServerID != "" {
+		if err := grpc.SetHeader(ctx, metadata.Pairs(federationin.OriginHeader, s.config.ServerID)); err != nil {
+			logger.Errorf("Failed to set origin header: %v", err)
+		}
+	}
+
+	now := time.Now()
+	auth, _ := ctx.Value(authKey{}).(*database.FederationOutAuthorization)
+	maxKeys := 0
+	if auth != nil {
+		var err error
+		if maxKeys, err = s.checkQuota(ctx, auth, s.db.FederationOutKeysServed, now); err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := s.fetch(ctx, req, s.db.IterateExposures, database.TruncateWindow(now, s.config.TruncateWindow), maxKeys) // Don't fetch the current window, which isn't complete yet. TODO(squee1945): should I double this for safety?
+	metrics.FederationSyncs.WithLabelValues("out", metrics.Result(err)).Inc()
 	if err != nil {
 		s.env.MetricsExporter(ctx).WriteInt("federation-fetch-failed", true, 1)
 		logger.Errorf("Fetch error: %v", err)
 		return nil, errors.New("internal error")
 	}
+
+	metrics.FederationKeys.WithLabelValues("out").Add(float64(responseKeys(response)))
+
+	if auth != nil {
+		if err := s.db.RecordFederationOutUsage(ctx, auth.Issuer, auth.Subject, now, responseKeys(response)); err != nil {
+			logger.Errorf("Failed to record usage (issuer %q, subject %s): %v", auth.Issuer, auth.Subject, err)
+		}
+	}
 	return response, nil
 }
 
-func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, itFunc iterateExposuresFunc, fetchUntil time.Time) (*pb.FederationFetchResponse, error) {
+// checkQuota enforces the partner's quotas before a fetch. It returns
+// RESOURCE_EXHAUSTED, with a retry-after trailer, once the partner has made
+// too many requests in the last minute or been served its keys for the UTC
+// day. Otherwise it returns the number of keys the partner may still be
+// served today, or 0 if that is unlimited. Requests are allowed if the
+// request rate can't be checked.
+func (s Server) checkQuota(ctx context.Context, auth *database.FederationOutAuthorization, keysServed keysServedFunc, now time.Time) (int, error) {
+	logger := logging.FromContext(ctx)
+	metrics := s.env.MetricsExporter(ctx)
+
+	if rpm := quota(auth.RequestsPerMinute, s.config.QuotaRequestsPerMinute); rpm > 0 {
+		limit := ratelimit.Limit{Tokens: rpm, Interval: time.Minute}
+		ok, wait, err := s.limiter.Take(ctx, auth.Issuer+"|"+auth.Subject, limit)
+		if err != nil {
+			logger.Errorf("Unable to check request quota: %v", err)
+			metrics.WriteInt("federation-fetch-quota-error", true, 1)
+		} else if !ok {
+			logger.Infof("Request quota exhausted (issuer %q, subject %s)", auth.Issuer, auth.Subject)
+			metrics.WriteInt("federation-fetch-rate-limited", true, 1)
+			return 0, resourceExhausted(ctx, wait, "Request quota exhausted")
+		}
+	}
+
+	keysPerDay := quota(auth.KeysPerDay, s.config.QuotaKeysPerDay)
+	if keysPerDay <= 0 {
+		return 0, nil
+	}
+	served, err := keysServed(ctx, auth.Issuer, auth.Subject, now)
+	if err != nil {
+		logger.Errorf("Failed to read usage (issuer %q, subject %s): %v", auth.Issuer, auth.Subject, err)
+		metrics.WriteInt("federation-fetch-internal-error", true, 1)
+		return 0, status.Errorf(codes.Internal, "Internal error")
+	}
+	if served >= int64(keysPerDay) {
+		logger.Infof("Daily key quota exhausted (issuer %q, subject %s)", auth.Issuer, auth.Subject)
+		metrics.WriteInt("federation-fetch-key-quota-exhausted", true, 1)
+		tomorrow := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return 0, resourceExhausted(ctx, tomorrow.Sub(now), "Daily key quota exhausted")
+	}
+	return keysPerDay - int(served), nil
+}
+
+// quota returns the partner's override of a quota if it has one, and the
+// server default otherwise. Zero or less means no quota.
+func quota(override, def int) int {
+	if override != 0 {
+		return override
+	}
+	return def
+}
+
+// resourceExhausted returns a RESOURCE_EXHAUSTED error and sets a trailer
+// telling the partner when to retry.
+func resourceExhausted(ctx context.Context, wait time.Duration, msg string) error {
+	if err := grpc.SetTrailer(ctx, metadata.Pairs("retry-after", ratelimit.RetryAfter(wait))); err != nil {
+		logging.FromContext(ctx).Errorf("Failed to set trailer: %v", err)
+	}
+	return status.Error(codes.ResourceExhausted, msg)
+}
+
+// responseKeys returns the number of keys in a fetch response.
+func responseKeys(response *pb.FederationFetchResponse) int {
+	n := 0
+	for _, ctr := range response.Response {
+		for _, cti := range ctr.ContactTracingInfo {
+			n += len(cti.ExposureKeys)
+		}
+	}
+	return n
+}
+
+// fetch reads the keys for a fetch request. If maxKeys is positive, the
+// response holds at most that many keys, and the rest are left for the next
+// page.
+func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, itFunc iterateExposuresFunc, fetchUntil time.Time, maxKeys int) (*pb.FederationFetchResponse, error) {
 	logger := logging.FromContext(ctx)
 	metrics := s.env.MetricsExporter(ctx)
 
@@ -90,12 +222,35 @@ func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, itFun
 
 	logger.Infof("Processing client request %#v", req)
 
+	// The partner may ask for only some report types and transmission risks.
+	// Echo the report types to confirm they are applied, since the keys in
+	// the response don't carry them.
+	reportTypes, minRisk := requestedReportFilter(ctx)
+	if len(reportTypes) > 0 {
+		if err := grpc.SetHeader(ctx, metadata.Pairs(federationin.ReportTypesHeader, strings.Join(reportTypes, ","))); err != nil {
+			logger.Errorf("Failed to set report types header: %v", err)
+		}
+	}
+
 	// If there is a FederationAuthorization on the context, set the query to operate within its limits.
 	if auth, ok := ctx.Value(authKey{}).(*database.FederationOutAuthorization); ok {
 		// For included regions, we INTERSECT the requested included regions with the configured included regions.
 		req.RegionIdentifiers = intersect(req.RegionIdentifiers, auth.IncludeRegions)
 		// For excluded regions, we UNION the the requested excluded regions with the configured excluded regions.
 		req.ExcludeRegionIdentifiers = union(req.ExcludeRegionIdentifiers, auth.ExcludeRegions)
+
+		// Report types are likewise limited to those both requested and authorized.
+		if len(auth.ReportTypes) > 0 {
+			if len(reportTypes) == 0 {
+				reportTypes = auth.ReportTypes
+			} else if reportTypes = intersect(reportTypes, auth.ReportTypes); len(reportTypes) == 0 {
+				logger.Infof("No requested report type is authorized, returning no keys.")
+				return &pb.FederationFetchResponse{}, nil
+			}
+		}
+		if auth.MinTransmissionRisk > minRisk {
+			minRisk = auth.MinTransmissionRisk
+		}
 	}
 
 	criteria := database.IterateExposuresCriteria{
@@ -104,7 +259,10 @@ func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, itFun
 		SinceTimestamp:      time.Unix(req.LastFetchResponseKeyTimestamp, 0),
 		UntilTimestamp:      fetchUntil,
 		LastCursor:          req.NextFetchToken,
-		OnlyLocalProvenance: true, // Do not return results that came from other federation partners.
+		ExcludeFederated:    true, // Do not return results that came from other federation partners.
+		IncludeReportTypes:  reportTypes,
+		MinTransmissionRisk: minRisk,
+		ReadPreference:      database.ReadReplica,
 	}
 
 	logger.Infof("Query criteria: %#v", criteria)
@@ -125,6 +283,7 @@ func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, itFun
 	ctiMap := map[string]*pb.ContactTracingInfo{}     // local index into the response being assembled; keys on unique set of (ctrMap key, transmissionRisk, verificationAuthorityName)
 	response := &pb.FederationFetchResponse{}
 	count := 0
+	responseBytes := 0
 	cursor, err := itFunc(ctx, criteria, func(inf *database.Exposure) error {
 		// If the diagnosis key is empty, it's malformed, so skip it.
 		if len(inf.ExposureKey) == 0 {
@@ -138,10 +297,17 @@ func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, itFun
 			return nil
 		}
 
-		// Filter out non-LocalProvenance results; we should not re-federate.
+		// Filter out federated results; we should not re-federate.
 		// This may already be handled by the database query and is included here for completeness.
-		if !inf.LocalProvenance {
-			logger.Debugf("Exposure %s not LocalProvenance, skipping.", inf.ExposureKey)
+		if inf.Federated() {
+			logger.Debugf("Exposure %s arrived through federation, skipping.", inf.ExposureKey)
+			return nil
+		}
+
+		// Filter on report type and transmission risk.
+		// This is handled by the database query and is included here for completeness.
+		if !database.FederationReportAllowed(reportTypes, minRisk, inf.ReportType, inf.TransmissionRisk) {
+			logger.Debugf("Exposure %s has a filtered report type or transmission risk, skipping.", inf.ExposureKey)
 			return nil
 		}
 
@@ -174,9 +340,37 @@ func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, itFun
 			}
 		}
 
-		// Find, or create, the ContactTracingResponse based on the unique set of regions.
 		sort.Strings(inf.Regions)
 		ctrKey := strings.Join(inf.Regions, "::")
+		ctiKey := fmt.Sprintf("%s::%d", ctrKey, inf.TransmissionRisk)
+		key := &pb.ExposureKey{
+			ExposureKey:    inf.ExposureKey,
+			IntervalNumber: inf.IntervalNumber,
+			IntervalCount:  inf.IntervalCount,
+		}
+
+		// Stop once the partner's remaining daily keys have been added.
+		if maxKeys > 0 && count >= maxKeys {
+			return errResponseFull
+		}
+
+		// Stop once the key would push the response past the size limit. At
+		// least one key is always sent so that the client makes progress.
+		size := embeddedSize(proto.Size(key))
+		if _, ok := ctiMap[ctiKey]; !ok {
+			size += embeddedSize(protowire.SizeVarint(uint64(inf.TransmissionRisk)) + 1)
+		}
+		if _, ok := ctrMap[ctrKey]; !ok {
+			for _, region := range inf.Regions {
+				size += embeddedSize(len(region))
+			}
+		}
+		if max := s.config.MaxResponseBytes; max > 0 && count > 0 && responseBytes+size > max {
+			return errResponseFull
+		}
+		responseBytes += size
+
+		// Find, or create, the ContactTracingResponse based on the unique set of regions.
 		ctr := ctrMap[ctrKey]
 		if ctr == nil {
 			ctr = &pb.ContactTracingResponse{RegionIdentifiers: inf.Regions}
@@ -185,7 +379,6 @@ func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, itFun
 		}
 
 		// Find, or create, the ContactTracingInfo for (ctrKey, transmissionRisk).
-		ctiKey := fmt.Sprintf("%s::%d", ctrKey, inf.TransmissionRisk)
 		cti := ctiMap[ctiKey]
 		if cti == nil {
 			cti = &pb.ContactTracingInfo{TransmissionRisk: int32(inf.TransmissionRisk)}
@@ -194,11 +387,7 @@ func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, itFun
 		}
 
 		// Add the key to the ContactTracingInfo.
-		cti.ExposureKeys = append(cti.ExposureKeys, &pb.ExposureKey{
-			ExposureKey:    inf.ExposureKey,
-			IntervalNumber: inf.IntervalNumber,
-			IntervalCount:  inf.IntervalCount,
-		})
+		cti.ExposureKeys = append(cti.ExposureKeys, key)
 
 		created := inf.CreatedAt.Unix()
 		if created > response.FetchResponseKeyTimestamp {
@@ -208,7 +397,12 @@ func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, itFun
 		count++
 		return nil
 	})
-	if err != nil {
+	if errors.Is(err, errResponseFull) {
+		logger.Infof("Fetch response reached %d keys, %d bytes, returning partial response.", count, responseBytes)
+		metrics.WriteInt("federation-fetch-response-full", true, 1)
+		response.PartialResponse = true
+		response.NextFetchToken = cursor
+	} else if err != nil {
 		metrics.WriteInt("federation-fetch-error", true, 1)
 		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
 			logger.Infof("Fetch request reached time out, returning partial response.")
@@ -223,18 +417,73 @@ func (s Server) fetch(ctx context.Context, req *pb.FederationFetchRequest, itFun
 	return response, nil
 }
 
-// AuthInterceptor validates incoming OIDC bearer token and adds corresponding FederationAuthorization record to the context.
+// requestedReportFilter returns the report types and minimum transmission
+// risk the partner asked for in its request headers. Unknown report types
+// are ignored.
+func requestedReportFilter(ctx context.Context) ([]string, int) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, 0
+	}
+	var reportTypes []string
+	for _, v := range md.Get(federationin.ReportTypesHeader) {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.ToLower(strings.TrimSpace(t))
+			if t != "" && database.ValidReportType(t) {
+				reportTypes = append(reportTypes, t)
+			}
+		}
+	}
+	minRisk := 0
+	if v := md.Get(federationin.MinTransmissionRiskHeader); len(v) > 0 {
+		if risk, err := strconv.Atoi(v[0]); err == nil {
+			minRisk = risk
+		}
+	}
+	return reportTypes, minRisk
+}
+
+// AuthInterceptor authenticates the caller and adds the corresponding FederationAuthorization record to the context.
+// Callers present either a client certificate signed by a pinned partner CA or an OIDC bearer token.
 func (s Server) AuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	auth, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store the FederationAuthorization on the context.
+	logging.FromContext(ctx).Infof("Caller: issuer %q subject %q", auth.Issuer, auth.Subject)
+	ctx = context.WithValue(ctx, authKey{}, auth)
+	return handler(ctx, req)
+}
+
+func (s Server) authenticate(ctx context.Context) (*database.FederationOutAuthorization, error) {
 	logger := logging.FromContext(ctx)
 	metrics := s.env.MetricsExporter(ctx)
 
+	// A verified client certificate identifies the partner by its issuer and subject.
+	if cert, ok := peerCertificate(ctx); ok {
+		issuer, subject := CertificateIdentity(cert)
+		auth, err := s.db.GetFederationOutAuthorization(ctx, issuer, subject)
+		if err == nil {
+			return auth, nil
+		}
+		if !errors.Is(err, database.ErrNotFound) {
+			logger.Errorf("Failed to fetch authorization (issuer %q, subject %s): %v", issuer, subject, err)
+			metrics.WriteInt("federation-fetch-internal-error", true, 1)
+			return nil, status.Errorf(codes.Internal, "Internal error")
+		}
+		// Fall back to the bearer token for partners not registered by certificate.
+		logger.Infof("Authorization not found for client certificate (issuer %q, subject %s)", issuer, subject)
+	}
+
 	raw, err := rawToken(ctx)
 	if err != nil {
 		logger.Infof("Invalid headers: %v", err)
 		return nil, err
 	}
 
-	token, err := idtoken.Validate(ctx, raw, "")
+	token, err := s.tokens.verify(ctx, raw)
 	if err != nil {
 		logger.Infof("Invalid token: %v", err)
 		metrics.WriteInt("federation-fetch-invalid-auth-token", true, 1)
@@ -253,16 +502,34 @@ func (s Server) AuthInterceptor(ctx context.Context, req interface{}, info *grpc
 		return nil, status.Errorf(codes.Internal, "Internal error")
 	}
 
-	if auth.Audience != "" && auth.Audience != token.Audience {
+	if auth.Audience != "" && !token.Audience.contains(auth.Audience) {
 		metrics.WriteInt("federation-fetch-invalid-audience", true, 1)
 		logger.Infof("Invalid audience, got %q, want %q", token.Audience, auth.Audience)
 		return nil, status.Errorf(codes.Unauthenticated, "Invalid audience")
 	}
+	return auth, nil
+}
 
-	// Store the FederationAuthorization on the context.
-	logger.Infof("Caller: issuer %q subject %q", auth.Issuer, auth.Subject)
-	ctx = context.WithValue(ctx, authKey{}, auth)
-	return handler(ctx, req)
+// CertificateIdentity returns the issuer and subject under which a partner
+// authenticating with the client certificate cert is authorized. The issuer is
+// the certificate's issuer name prefixed with "x509:", which keeps it apart
+// from OIDC issuers.
+func CertificateIdentity(cert *x509.Certificate) (issuer, subject string) {
+	return x509IssuerPrefix + cert.Issuer.String(), cert.Subject.String()
+}
+
+// peerCertificate returns the caller's client certificate, if it presented
+// one that verified against the pinned CAs.
+func peerCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	return info.State.VerifiedChains[0][0], true
 }
 
 func rawToken(ctx context.Context) (string, error) {
@@ -288,6 +555,12 @@ func rawToken(ctx context.Context) (string, error) {
 	return rawToken, nil
 }
 
+// embeddedSize returns the encoded size of a length-delimited field holding n
+// bytes, such as a string or an embedded message.
+func embeddedSize(n int) int {
+	return protowire.SizeTag(1) + protowire.SizeBytes(n)
+}
+
 func intersect(aa, bb []string) []string {
 	if len(aa) == 0 || len(bb) == 0 {
 		return nil
@@ -21,9 +21,13 @@ import (
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/federationin"
 	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/ratelimit"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/protobuf/testing/protocmp"
@@ -56,6 +60,12 @@ func makeExposure(diagKey *pb.ExposureKey, diagStatus int, regions ...string) *d
 	}
 }
 
+// withReportType sets the report type on a mock database.Exposure.
+func withReportType(e *database.Exposure, reportType string) *database.Exposure {
+	e.ReportType = reportType
+	return e
+}
+
 // timeout is used by testIterator to indicate that a timeout signal should be sent.
 type timeout struct{}
 
@@ -83,10 +93,14 @@ func iterFunc(elements []interface{}) iterateExposuresFunc {
 // TestFetch tests the fetch() function.
 func TestFetch(t *testing.T) {
 	testCases := []struct {
-		name           string
-		excludeRegions []string
-		iterations     []interface{}
-		want           pb.FederationFetchResponse
+		name             string
+		excludeRegions   []string
+		maxResponseBytes int
+		maxKeys          int
+		reportTypes      string
+		auth             *database.FederationOutAuthorization
+		iterations       []interface{}
+		want             pb.FederationFetchResponse
 	}{
 		{
 			name: "no results",
@@ -239,15 +253,140 @@ func TestFetch(t *testing.T) {
 				NextFetchToken:            "bbb_cursor",
 			},
 		},
+		{
+			name:             "response size limit",
+			maxResponseBytes: 20,
+			iterations: []interface{}{
+				makeExposure(aaa, 1, "US"),
+				makeExposure(bbb, 1, "US"),
+				makeExposure(ccc, 1, "US"),
+			},
+			want: pb.FederationFetchResponse{
+				Response: []*pb.ContactTracingResponse{
+					{
+						RegionIdentifiers: []string{"US"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+						},
+					},
+				},
+				PartialResponse:           true,
+				FetchResponseKeyTimestamp: 100,
+				NextFetchToken:            "bbb_cursor",
+			},
+		},
+		{
+			name:    "remaining daily keys",
+			maxKeys: 2,
+			iterations: []interface{}{
+				makeExposure(aaa, 1, "US"),
+				makeExposure(bbb, 1, "US"),
+				makeExposure(ccc, 1, "US"),
+			},
+			want: pb.FederationFetchResponse{
+				Response: []*pb.ContactTracingResponse{
+					{
+						RegionIdentifiers: []string{"US"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa, bbb}},
+						},
+					},
+				},
+				PartialResponse:           true,
+				FetchResponseKeyTimestamp: 200,
+				NextFetchToken:            "ccc_cursor",
+			},
+		},
+		{
+			name:        "requested report types",
+			reportTypes: "confirmed",
+			iterations: []interface{}{
+				withReportType(makeExposure(aaa, 1, "US"), database.ReportTypeConfirmed),
+				withReportType(makeExposure(bbb, 1, "US"), database.ReportTypeLikely),
+				makeExposure(ccc, 1, "US"),
+			},
+			want: pb.FederationFetchResponse{
+				Response: []*pb.ContactTracingResponse{
+					{
+						RegionIdentifiers: []string{"US"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+						},
+					},
+				},
+				FetchResponseKeyTimestamp: 100,
+			},
+		},
+		{
+			name: "authorized report types and risk",
+			auth: &database.FederationOutAuthorization{
+				ReportTypes:         []string{database.ReportTypeConfirmed, database.ReportTypeLikely},
+				MinTransmissionRisk: 3,
+			},
+			iterations: []interface{}{
+				withReportType(makeExposure(aaa, 4, "US"), database.ReportTypeConfirmed),
+				withReportType(makeExposure(bbb, 2, "US"), database.ReportTypeConfirmed),
+				withReportType(makeExposure(ccc, 5, "US"), database.ReportTypeLikely),
+				withReportType(makeExposure(ddd, 6, "US"), database.ReportTypeNegative),
+			},
+			want: pb.FederationFetchResponse{
+				Response: []*pb.ContactTracingResponse{
+					{
+						RegionIdentifiers: []string{"US"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 4, ExposureKeys: []*pb.ExposureKey{aaa}},
+							{TransmissionRisk: 5, ExposureKeys: []*pb.ExposureKey{ccc}},
+						},
+					},
+				},
+				FetchResponseKeyTimestamp: 300,
+			},
+		},
+		{
+			name:        "requested report types not authorized",
+			reportTypes: "likely",
+			auth: &database.FederationOutAuthorization{
+				ReportTypes: []string{database.ReportTypeConfirmed},
+			},
+			iterations: []interface{}{
+				withReportType(makeExposure(aaa, 1, "US"), database.ReportTypeLikely),
+			},
+			want: pb.FederationFetchResponse{},
+		},
+		{
+			name:             "first key exceeds response size limit",
+			maxResponseBytes: 1,
+			iterations: []interface{}{
+				makeExposure(aaa, 1, "US"),
+			},
+			want: pb.FederationFetchResponse{
+				Response: []*pb.ContactTracingResponse{
+					{
+						RegionIdentifiers: []string{"US"},
+						ContactTracingInfo: []*pb.ContactTracingInfo{
+							{TransmissionRisk: 1, ExposureKeys: []*pb.ExposureKey{aaa}},
+						},
+					},
+				},
+				FetchResponseKeyTimestamp: 100,
+			},
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			ctx := context.Background()
 			env := serverenv.New(ctx)
-			server := Server{env: env}
+			server := Server{env: env, config: &Config{MaxResponseBytes: tc.maxResponseBytes}}
 			req := pb.FederationFetchRequest{ExcludeRegionIdentifiers: tc.excludeRegions}
-			got, err := server.fetch(context.Background(), &req, iterFunc(tc.iterations), time.Now())
+			fetchCtx := context.Background()
+			if tc.reportTypes != "" {
+				fetchCtx = metadata.NewIncomingContext(fetchCtx, metadata.Pairs(federationin.ReportTypesHeader, tc.reportTypes))
+			}
+			if tc.auth != nil {
+				fetchCtx = context.WithValue(fetchCtx, authKey{}, tc.auth)
+			}
+			got, err := server.fetch(fetchCtx, &req, iterFunc(tc.iterations), time.Now(), tc.maxKeys)
 			if err != nil {
 				t.Fatalf("fetch() returned err=%v, want err=nil", err)
 			}
@@ -258,6 +397,106 @@ func TestFetch(t *testing.T) {
 	}
 }
 
+// TestCheckQuota tests checkQuota().
+func TestCheckQuota(t *testing.T) {
+	now := time.Date(2020, 9, 1, 18, 0, 0, 0, time.UTC)
+	keysServed := func(served int64) keysServedFunc {
+		return func(context.Context, string, string, time.Time) (int64, error) {
+			return served, nil
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		config        Config
+		auth          database.FederationOutAuthorization
+		served        int64
+		requests      int
+		wantMaxKeys   int
+		wantExhausted bool
+	}{
+		{
+			name:     "no quotas",
+			requests: 10,
+		},
+		{
+			name:        "remaining keys",
+			config:      Config{QuotaKeysPerDay: 100},
+			served:      60,
+			requests:    1,
+			wantMaxKeys: 40,
+		},
+		{
+			name:          "keys exhausted",
+			config:        Config{QuotaKeysPerDay: 100},
+			served:        100,
+			requests:      1,
+			wantExhausted: true,
+		},
+		{
+			name:        "partner key override",
+			config:      Config{QuotaKeysPerDay: 100},
+			auth:        database.FederationOutAuthorization{KeysPerDay: 500},
+			served:      100,
+			requests:    1,
+			wantMaxKeys: 400,
+		},
+		{
+			name:     "partner without key quota",
+			config:   Config{QuotaKeysPerDay: 100},
+			auth:     database.FederationOutAuthorization{KeysPerDay: -1},
+			served:   100,
+			requests: 1,
+		},
+		{
+			name:     "requests within quota",
+			config:   Config{QuotaRequestsPerMinute: 3},
+			requests: 3,
+		},
+		{
+			name:          "requests over quota",
+			config:        Config{QuotaRequestsPerMinute: 3},
+			requests:      4,
+			wantExhausted: true,
+		},
+		{
+			name:     "partner request override",
+			config:   Config{QuotaRequestsPerMinute: 3},
+			auth:     database.FederationOutAuthorization{RequestsPerMinute: 5},
+			requests: 5,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			server := Server{env: serverenv.New(ctx), config: &tc.config, limiter: ratelimit.NewMemoryStore()}
+			auth := tc.auth
+			auth.Issuer, auth.Subject = "iss", "sub"
+
+			var (
+				maxKeys int
+				err     error
+			)
+			for i := 0; i < tc.requests; i++ {
+				maxKeys, err = server.checkQuota(ctx, &auth, keysServed(tc.served), now)
+			}
+			if tc.wantExhausted {
+				if status.Code(err) != codes.ResourceExhausted {
+					t.Fatalf("checkQuota: got error %v, want RESOURCE_EXHAUSTED", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkQuota: %v", err)
+			}
+			if maxKeys != tc.wantMaxKeys {
+				t.Errorf("checkQuota: got %d keys, want %d", maxKeys, tc.wantMaxKeys)
+			}
+		})
+	}
+}
+
 // TestRawToken tests rawToken().
 func TestRawToken(t *testing.T) {
 	want := "Abc123"
@@ -0,0 +1,90 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/google/exposure-notifications-server/internal/observability"
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// NewGRPCServer returns a gRPC server that serves federation fetches, with the
+// TLS settings of config, request logging, and the authentication of partners
+// unless ALLOW_ANY_CLIENT is set.
+func NewGRPCServer(env *serverenv.ServerEnv, config *Config) (*grpc.Server, error) {
+	server, err := NewServer(env, config)
+	if err != nil {
+		return nil, err
+	}
+
+	sopts := []grpc.ServerOption{observability.GRPCServerOption()}
+	if config.TLSClientCAFile != "" {
+		if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		creds, err := mutualTLSCredentials(config.TLSCertFile, config.TLSKeyFile, config.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate credentials: %w", err)
+		}
+		sopts = append(sopts, grpc.Creds(creds))
+	} else if config.TLSCertFile != "" && config.TLSKeyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate credentials: %w", err)
+		}
+		sopts = append(sopts, grpc.Creds(creds))
+	}
+
+	interceptors := []grpc.UnaryServerInterceptor{env.RequestLogger().UnaryInterceptor}
+	if !config.AllowAnyClient {
+		interceptors = append(interceptors, server.(*Server).AuthInterceptor)
+	}
+	sopts = append(sopts, grpc.ChainUnaryInterceptor(interceptors...))
+
+	grpcServer := grpc.NewServer(sopts...)
+	pb.RegisterFederationServer(grpcServer, server)
+	return grpcServer, nil
+}
+
+// mutualTLSCredentials returns server credentials that verify any client
+// certificate against the pinned partner CAs in caFile. Clients without a
+// certificate are still accepted, so that they can authenticate with a token.
+func mutualTLSCredentials(certFile, keyFile, caFile string) (credentials.TransportCredentials, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading server certificate: %w", err)
+	}
+	b, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA file %q: %w", caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in client CA file %q", caFile)
+	}
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}), nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/verification"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// maxDiscoverySize bounds the size of a downloaded OpenID discovery document.
+	maxDiscoverySize = 1 << 20
+
+	// clockSkew is the leeway allowed when checking token lifetimes.
+	clockSkew = time.Minute
+)
+
+// audience is the aud claim of an ID token, which may be a single string or a
+// list of strings.
+type audience []string
+
+// UnmarshalJSON accepts either form of the aud claim.
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return fmt.Errorf("aud must be a string or a list of strings: %w", err)
+	}
+	*a = l
+	return nil
+}
+
+func (a audience) contains(want string) bool {
+	for _, v := range a {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+// idTokenClaims are the claims of an OIDC ID token that federation partners
+// authenticate with.
+type idTokenClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf"`
+
+	// now is the time the claims are validated at.
+	now time.Time
+}
+
+// Valid checks the lifetime of the token. It implements jwt.Claims.
+func (c *idTokenClaims) Valid() error {
+	now := c.now.Unix()
+	skew := int64(clockSkew / time.Second)
+	if c.ExpiresAt == 0 {
+		return fmt.Errorf("token has no expiry")
+	}
+	if now > c.ExpiresAt+skew {
+		return fmt.Errorf("token expired at %v", time.Unix(c.ExpiresAt, 0).UTC())
+	}
+	if c.IssuedAt != 0 && now < c.IssuedAt-skew {
+		return fmt.Errorf("token issued in the future")
+	}
+	if c.NotBefore != 0 && now < c.NotBefore-skew {
+		return fmt.Errorf("token not valid before %v", time.Unix(c.NotBefore, 0).UTC())
+	}
+	return nil
+}
+
+// tokenVerifier validates OIDC ID tokens from a fixed set of issuers. The
+// signing keys of each issuer are found through OpenID Connect discovery.
+type tokenVerifier struct {
+	issuers   map[string]struct{}
+	audiences []string
+	keys      *verification.KeySet
+	client    *http.Client
+
+	// now is replaced in tests.
+	now func() time.Time
+
+	mu       sync.Mutex
+	jwksURIs map[string]string
+}
+
+func newTokenVerifier(issuers, audiences []string, keysCacheDuration time.Duration) *tokenVerifier {
+	v := &tokenVerifier{
+		issuers:   make(map[string]struct{}, len(issuers)),
+		audiences: audiences,
+		keys:      verification.NewKeySet(keysCacheDuration),
+		client:    &http.Client{Timeout: 10 * time.Second},
+		now:       time.Now,
+		jwksURIs:  make(map[string]string),
+	}
+	for _, iss := range issuers {
+		v.issuers[iss] = struct{}{}
+	}
+	return v
+}
+
+// verify checks the signature, issuer, lifetime and audience of a raw ID
+// token and returns its claims.
+func (v *tokenVerifier) verify(ctx context.Context, raw string) (*idTokenClaims, error) {
+	claims := &idTokenClaims{now: v.now()}
+	_, err := jwt.ParseWithClaims(raw, claims, func(tok *jwt.Token) (interface{}, error) {
+		switch tok.Method.(type) {
+		case *jwt.SigningMethodECDSA, *jwt.SigningMethodRSA:
+		default:
+			return nil, fmt.Errorf("unsupported signing method %v", tok.Header["alg"])
+		}
+		if _, ok := v.issuers[claims.Issuer]; !ok {
+			return nil, fmt.Errorf("issuer %q is not trusted", claims.Issuer)
+		}
+		kid, ok := tok.Header["kid"].(string)
+		if !ok || kid == "" {
+			return nil, fmt.Errorf("missing kid header")
+		}
+		uri, err := v.jwksURI(ctx, claims.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		return v.keys.Key(ctx, uri, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	if len(v.audiences) > 0 {
+		ok := false
+		for _, aud := range v.audiences {
+			if claims.Audience.contains(aud) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("token audience %q is not one of %q", claims.Audience, v.audiences)
+		}
+	}
+	return claims, nil
+}
+
+// jwksURI returns the location of the issuer's key set from its OpenID
+// discovery document, which is cached once found.
+func (v *tokenVerifier) jwksURI(ctx context.Context, issuer string) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if uri, ok := v.jwksURIs[issuer]; ok {
+		return uri, nil
+	}
+
+	// Google issues tokens with a scheme-less issuer as well as the URL.
+	base := issuer
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	discovery := strings.TrimSuffix(base, "/") + "/.well-known/openid-configuration"
+
+	req, err := http.NewRequest(http.MethodGet, discovery, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build discovery request: %w", err)
+	}
+	resp, err := v.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("fetching discovery document for %v: %w", issuer, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching discovery document for %v: unexpected status %d", issuer, resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxDiscoverySize))
+	if err != nil {
+		return "", fmt.Errorf("reading discovery document for %v: %w", issuer, err)
+	}
+
+	var doc struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", fmt.Errorf("invalid discovery document for %v: %w", issuer, err)
+	}
+	if doc.JWKSURI == "" {
+		return "", fmt.Errorf("discovery document for %v has no jwks_uri", issuer)
+	}
+	v.jwksURIs[issuer] = doc.JWKSURI
+	return doc.JWKSURI, nil
+}
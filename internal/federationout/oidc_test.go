@@ -0,0 +1,139 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationout
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestTokenVerifier(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"jwks_uri": srv.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "EC",
+				"kid": "v1",
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+				"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+			}},
+		})
+	})
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": srv.URL,
+			"sub": "partner",
+			"aud": "https://federation.example.com",
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	cases := []struct {
+		name      string
+		claims    func(c jwt.MapClaims)
+		audiences []string
+		err       string
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name:      "allowed_audience",
+			audiences: []string{"https://other.example.com", "https://federation.example.com"},
+		},
+		{
+			name:      "audience_list",
+			claims:    func(c jwt.MapClaims) { c["aud"] = []string{"a", "https://federation.example.com"} },
+			audiences: []string{"https://federation.example.com"},
+		},
+		{
+			name:      "wrong_audience",
+			audiences: []string{"https://other.example.com"},
+			err:       "token audience",
+		},
+		{
+			name:   "untrusted_issuer",
+			claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+			err:    "is not trusted",
+		},
+		{
+			name:   "expired",
+			claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+			err:    "token expired",
+		},
+		{
+			name:   "no_expiry",
+			claims: func(c jwt.MapClaims) { delete(c, "exp") },
+			err:    "no expiry",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			claims := validClaims()
+			if tc.claims != nil {
+				tc.claims(claims)
+			}
+			tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+			tok.Header["kid"] = "v1"
+			raw, err := tok.SignedString(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v := newTokenVerifier([]string{srv.URL}, tc.audiences, time.Hour)
+			got, err := v.verify(ctx, raw)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("verify: %v", err)
+				}
+				if got.Issuer != srv.URL || got.Subject != "partner" {
+					t.Errorf("got issuer %q subject %q, want %q %q", got.Issuer, got.Subject, srv.URL, "partner")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
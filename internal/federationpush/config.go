@@ -0,0 +1,94 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationpush
+
+import (
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/scheduler"
+	"github.com/google/exposure-notifications-server/internal/setup"
+	"github.com/google/exposure-notifications-server/internal/signing"
+)
+
+// Compile-time check to assert this config matches requirements.
+var _ setup.DBConfigProvider = (*Config)(nil)
+var _ setup.KeyManagerProvider = (*Config)(nil)
+var _ setup.KeyManagerConfigProvider = (*Config)(nil)
+
+// Config is the configuration for the federation push components (data pushed to other servers).
+type Config struct {
+	Database *database.Config
+	Signing  *signing.Config
+	Port     string        `envconfig:"PORT" default:"8080"`
+	Timeout  time.Duration `envconfig:"RPC_TIMEOUT" default:"10m"`
+
+	// BatchSize is the maximum number of keys pushed in one request.
+	BatchSize int `envconfig:"PUSH_BATCH_SIZE" default:"5000"`
+
+	// MaxAttempts is the number of times a batch is sent before the push to
+	// that partner is abandoned until the next run. RetryBackoff is the wait
+	// before the first retry, and doubles with each further retry.
+	MaxAttempts  int           `envconfig:"PUSH_MAX_ATTEMPTS" default:"3"`
+	RetryBackoff time.Duration `envconfig:"PUSH_RETRY_BACKOFF" default:"5s"`
+
+	// Interval, if not zero, makes keys get pushed to every partner on their
+	// own every Interval, in addition to when the handler is called.
+	// Scheduler configures how instances share the runs.
+	Interval  time.Duration `envconfig:"PUSH_INTERVAL" default:"0"`
+	Scheduler *scheduler.Config
+
+	// TLSSkipVerify, if set to true, causes the server certificate to not be verified.
+	// This is typically used when testing locally with self-signed certificates.
+	TLSSkipVerify bool `envconfig:"TLS_SKIP_VERIFY" default:"false"`
+
+	// TLSCertFile points to an optional cert file that will be appended to the system certificates.
+	TLSCertFile string `envconfig:"TLS_CERT_FILE"`
+
+	// TLSClientCertFile and TLSClientKeyFile hold an optional client certificate
+	// and its key, presented to partners that require mutual TLS. Both must be
+	// set to use it.
+	TLSClientCertFile string `envconfig:"TLS_CLIENT_CERT_FILE"`
+	TLSClientKeyFile  string `envconfig:"TLS_CLIENT_KEY_FILE"`
+
+	// CredentialsFile points to a JSON credentials file. If running on Managed Cloud Run,
+	// or if using $GOOGLE_APPLICATION_CREDENTIALS, leave this value empty.
+	CredentialsFile string `envconfig:"CREDENTIALS_FILE"`
+
+	// EFGSCountry is this backend's country code in the EU federation gateway,
+	// and the origin of the keys uploaded to EFGS targets. Uploads are signed
+	// with EFGSSigningKey, a key in the key manager, and identified by the
+	// PEM signing certificate in EFGSSigningCertFile that the gateway has on
+	// record. EFGS targets also need the TLS client certificate.
+	EFGSCountry         string `envconfig:"EFGS_COUNTRY"`
+	EFGSSigningCertFile string `envconfig:"EFGS_SIGNING_CERT_FILE"`
+	EFGSSigningKey      string `envconfig:"EFGS_SIGNING_KEY"`
+}
+
+// DB returns the database config.
+func (c *Config) DB() *database.Config {
+	return c.Database
+}
+
+// KeyManager reports whether the KeyManager is needed, which is only to sign
+// gateway uploads.
+func (c *Config) KeyManager() bool {
+	return c.EFGSSigningKey != ""
+}
+
+// KeyManagerConfig returns the KeyManager configuration.
+func (c *Config) KeyManagerConfig() *signing.Config {
+	return c.Signing
+}
@@ -0,0 +1,336 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package federationpush pushes local keys to federation partners that
+// require uploads instead of pulling from the federationout server.
+package federationpush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/pb"
+	"github.com/google/exposure-notifications-server/internal/scheduler"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+)
+
+const (
+	targetParam = "target-id"
+)
+
+type (
+	syncExposuresFn func(context.Context, database.SyncExposuresCriteria, func(*database.Exposure) error) (int64, error)
+	sendFn          func(ctx context.Context, batchTag string, exposures []*database.Exposure) error
+	ackFn           func(ctx context.Context, targetID string, from, to int64, keys int) error
+)
+
+type pushDependencies struct {
+	syncExposures syncExposuresFn
+	send          sendFn
+	ack           ackFn
+}
+
+// NewHandler returns a handler that pushes new local keys to federation
+// partners. It pushes to every partner, or only to the one named by the
+// target-id query parameter.
+func NewHandler(env *serverenv.ServerEnv, config *Config) (*Handler, error) {
+	if env.Database() == nil {
+		return nil, fmt.Errorf("missing database in server environment")
+	}
+	if config.BatchSize <= 0 || config.BatchSize > database.MaxPageSize {
+		return nil, fmt.Errorf("PUSH_BATCH_SIZE must be > 0 and <= %d, got %d", database.MaxPageSize, config.BatchSize)
+	}
+	if config.MaxAttempts < 1 {
+		return nil, fmt.Errorf("PUSH_MAX_ATTEMPTS must be at least 1, got %d", config.MaxAttempts)
+	}
+
+	return &Handler{
+		env:    env,
+		db:     env.Database(),
+		config: config,
+	}, nil
+}
+
+// Handler pushes keys to partners when it is called or, see Jobs, on a
+// schedule of its own.
+type Handler struct {
+	env    *serverenv.ServerEnv
+	db     *database.DB
+	config *Config
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.Timeout)
+	defer cancel()
+	logger := logging.FromContext(ctx)
+
+	var targets []*database.FederationPushTarget
+	if targetID := r.URL.Query().Get(targetParam); targetID != "" {
+		target, err := h.db.GetFederationPushTarget(ctx, targetID)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				msg := fmt.Sprintf("unknown %s", targetParam)
+				logger.Debug(msg)
+				http.Error(w, msg, http.StatusBadRequest)
+				return
+			}
+			logger.Errorf("Failed getting push target %q: %v", targetID, err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		targets = append(targets, target)
+	} else {
+		var err error
+		if targets, err = h.db.ListFederationPushTargets(ctx); err != nil {
+			logger.Errorf("Failed listing push targets: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := h.pushTargets(ctx, targets); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "pushed to %d partners", len(targets))
+}
+
+// Jobs returns the scheduler job that pushes to every partner every
+// PUSH_INTERVAL, for deployments without a scheduler to call the handler, or
+// no job if PUSH_INTERVAL is zero.
+func (h *Handler) Jobs() []*scheduler.Job {
+	if h.config.Interval <= 0 {
+		return nil
+	}
+	return []*scheduler.Job{{
+		Name:     "federation-push",
+		Interval: h.config.Interval,
+		Run: func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, h.config.Timeout)
+			defer cancel()
+			targets, err := h.db.ListFederationPushTargets(ctx)
+			if err != nil {
+				return fmt.Errorf("listing push targets: %w", err)
+			}
+			return h.pushTargets(ctx, targets)
+		},
+	}}
+}
+
+// pushTargets pushes to each of targets. A failed push doesn't stop the
+// others.
+func (h *Handler) pushTargets(ctx context.Context, targets []*database.FederationPushTarget) error {
+	logger := logging.FromContext(ctx)
+
+	failed := 0
+	for _, target := range targets {
+		if err := h.pushTarget(ctx, target); err != nil {
+			logger.Errorf("Federation push to %q failed: %v", target.TargetID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d pushes failed", failed, len(targets))
+	}
+	return nil
+}
+
+// pushTarget pushes to a single partner while holding its lock, and records
+// the outcome on the target.
+func (h *Handler) pushTarget(ctx context.Context, target *database.FederationPushTarget) error {
+	logger := logging.FromContext(ctx)
+	metrics := h.env.MetricsExporter(ctx)
+
+	lock := "push_" + target.TargetID
+	unlockFn, err := h.db.Lock(ctx, lock, h.config.Timeout)
+	if err != nil {
+		if errors.Is(err, database.ErrAlreadyLocked) {
+			metrics.WriteInt("federation-push-lock-contention", true, 1)
+			logger.Infof("Lock %s already in use. No work will be performed.", lock)
+			return nil
+		}
+		return fmt.Errorf("acquiring lock %s: %w", lock, err)
+	}
+	defer unlockFn()
+
+	var total int
+	send, closeFn, err := newSender(ctx, h.env, h.config, target)
+	if err == nil {
+		defer closeFn()
+		deps := pushDependencies{
+			syncExposures: h.db.SyncExposures,
+			send:          send,
+			ack:           h.db.AckFederationPush,
+		}
+		total, err = push(ctx, metrics, deps, target, h.config)
+		logger.Infof("Pushed %d keys to %q", total, target.TargetID)
+	}
+	recordSync(total, err)
+	if err != nil {
+		metrics.WriteInt("federation-push-failed", true, 1)
+		if rerr := h.db.RecordFederationPushFailure(ctx, target.TargetID, err); rerr != nil {
+			logger.Errorf("Failed to record push failure for %q: %v", target.TargetID, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// recordSync counts a finished push in the Prometheus metrics.
+func recordSync(keys int, err error) {
+	metrics.FederationSyncs.WithLabelValues("push", metrics.Result(err)).Inc()
+	metrics.FederationKeys.WithLabelValues("push").Add(float64(keys))
+}
+
+// push sends the local keys changed since the target's acknowledged change ID
+// in batches, and acknowledges each batch the partner accepts. Keys only
+// received through federation are never pushed. It stops early, without
+// error, when the context is done, leaving the rest for the next run.
+func push(ctx context.Context, metrics metrics.Exporter, deps pushDependencies, t *database.FederationPushTarget, config *Config) (int, error) {
+	logger := logging.FromContext(ctx)
+
+	total := 0
+	since := t.AckedChangeID
+	for {
+		if ctx.Err() != nil {
+			logger.Infof("Federation push to %q timed out before pushing all keys.", t.TargetID)
+			return total, nil
+		}
+
+		var exposures []*database.Exposure
+		criteria := database.SyncExposuresCriteria{
+			SinceChangeID:  since,
+			Limit:          config.BatchSize,
+			ReadPreference: database.ReadReplica,
+		}
+		mark, err := deps.syncExposures(ctx, criteria, func(e *database.Exposure) error {
+			if !e.Federated() && regionsAllowed(e.Regions, t) {
+				exposures = append(exposures, e)
+			}
+			return nil
+		})
+		if err != nil {
+			return total, fmt.Errorf("reading exposures since change %d: %w", since, err)
+		}
+		if mark == since {
+			return total, nil
+		}
+
+		if len(exposures) > 0 {
+			// Retries of a batch reuse its tag, so that partners can drop duplicates.
+			tag := fmt.Sprintf("%s-%d-%d", t.TargetID, since, mark)
+			if err := sendWithRetry(ctx, deps.send, tag, exposures, config.MaxAttempts, config.RetryBackoff); err != nil {
+				return total, fmt.Errorf("pushing batch %s: %w", tag, err)
+			}
+		}
+		if err := deps.ack(ctx, t.TargetID, since, mark, len(exposures)); err != nil {
+			return total, fmt.Errorf("acknowledging change %d: %w", mark, err)
+		}
+		metrics.WriteInt("federation-push-keys", false, len(exposures))
+		total += len(exposures)
+		since = mark
+	}
+}
+
+// sendWithRetry sends the exposures up to attempts times, doubling the wait
+// between attempts from backoff.
+func sendWithRetry(ctx context.Context, send sendFn, tag string, exposures []*database.Exposure, attempts int, backoff time.Duration) error {
+	logger := logging.FromContext(ctx)
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			logger.Infof("Retrying batch %s after error: %v", tag, err)
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%v: %w", err, ctx.Err())
+			case <-time.After(backoff << uint(i-1)):
+			}
+		}
+		if err = send(ctx, tag, exposures); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// regionsAllowed reports whether an exposure with the given regions may be
+// pushed to the target: at least one region must not be excluded and, if the
+// target lists regions, at least one must be listed.
+func regionsAllowed(regions []string, t *database.FederationPushTarget) bool {
+	excluded := make(map[string]struct{}, len(t.ExcludeRegions))
+	for _, r := range t.ExcludeRegions {
+		excluded[r] = struct{}{}
+	}
+	included := make(map[string]struct{}, len(t.IncludeRegions))
+	for _, r := range t.IncludeRegions {
+		included[r] = struct{}{}
+	}
+
+	for _, region := range regions {
+		if _, ok := excluded[region]; ok {
+			continue
+		}
+		if _, ok := included[region]; ok || len(included) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// buildBatch groups exposures the same way the federationout server does:
+// by their set of regions, then by transmission risk.
+func buildBatch(exposures []*database.Exposure) *pb.FederationFetchResponse {
+	batch := &pb.FederationFetchResponse{}
+	ctrMap := map[string]*pb.ContactTracingResponse{}
+	ctiMap := map[string]*pb.ContactTracingInfo{}
+	for _, e := range exposures {
+		regions := append([]string(nil), e.Regions...)
+		sort.Strings(regions)
+		ctrKey := strings.Join(regions, "::")
+		ctr := ctrMap[ctrKey]
+		if ctr == nil {
+			ctr = &pb.ContactTracingResponse{RegionIdentifiers: regions}
+			ctrMap[ctrKey] = ctr
+			batch.Response = append(batch.Response, ctr)
+		}
+
+		ctiKey := fmt.Sprintf("%s::%d", ctrKey, e.TransmissionRisk)
+		cti := ctiMap[ctiKey]
+		if cti == nil {
+			cti = &pb.ContactTracingInfo{TransmissionRisk: int32(e.TransmissionRisk)}
+			ctiMap[ctiKey] = cti
+			ctr.ContactTracingInfo = append(ctr.ContactTracingInfo, cti)
+		}
+
+		cti.ExposureKeys = append(cti.ExposureKeys, &pb.ExposureKey{
+			ExposureKey:    e.ExposureKey,
+			IntervalNumber: e.IntervalNumber,
+			IntervalCount:  e.IntervalCount,
+		})
+
+		if created := e.CreatedAt.Unix(); created > batch.FetchResponseKeyTimestamp {
+			batch.FetchResponseKeyTimestamp = created
+		}
+	}
+	return batch
+}
@@ -0,0 +1,252 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationpush
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/pb"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func makeExposure(changeID int64, local bool, regions ...string) *database.Exposure {
+	return &database.Exposure{
+		ExposureKey:      []byte{byte(changeID)},
+		TransmissionRisk: 1,
+		Regions:          regions,
+		IntervalNumber:   int32(changeID),
+		IntervalCount:    144,
+		CreatedAt:        time.Unix(changeID*100, 0),
+		LocalProvenance:  local,
+		ChangeID:         changeID,
+	}
+}
+
+// exposureLog mocks SyncExposures over a fixed list of exposures in change order.
+type exposureLog []*database.Exposure
+
+func (l exposureLog) syncExposures(ctx context.Context, criteria database.SyncExposuresCriteria, f func(*database.Exposure) error) (int64, error) {
+	mark := criteria.SinceChangeID
+	n := 0
+	for _, e := range l {
+		if e.ChangeID <= criteria.SinceChangeID {
+			continue
+		}
+		if n == criteria.Limit {
+			break
+		}
+		if err := f(e); err != nil {
+			return mark, err
+		}
+		mark = e.ChangeID
+		n++
+	}
+	return mark, nil
+}
+
+// partner mocks the receiving partner, failing the first failures sends.
+type partner struct {
+	failures int
+	tags     []string
+	keys     [][]int64
+}
+
+func (p *partner) send(ctx context.Context, tag string, exposures []*database.Exposure) error {
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("unavailable")
+	}
+	p.tags = append(p.tags, tag)
+	var keys []int64
+	for _, e := range exposures {
+		keys = append(keys, int64(e.IntervalNumber))
+	}
+	p.keys = append(p.keys, keys)
+	return nil
+}
+
+func TestPush(t *testing.T) {
+	log := exposureLog{
+		makeExposure(1, true, "US"),
+		makeExposure(2, false, "US"),
+		makeExposure(3, true, "CA"),
+		makeExposure(4, true, "US", "CA"),
+		makeExposure(5, true, "MX"),
+	}
+
+	cases := []struct {
+		name      string
+		target    database.FederationPushTarget
+		failures  int
+		wantTags  []string
+		wantKeys  [][]int64
+		wantAcks  []int64
+		wantTotal int
+		wantErr   bool
+	}{
+		{
+			name:      "all local keys",
+			target:    database.FederationPushTarget{TargetID: "p"},
+			wantTags:  []string{"p-0-2", "p-2-4", "p-4-5"},
+			wantKeys:  [][]int64{{1}, {3, 4}, {5}},
+			wantAcks:  []int64{2, 4, 5},
+			wantTotal: 4,
+		},
+		{
+			name:      "resumes from acknowledged change",
+			target:    database.FederationPushTarget{TargetID: "p", AckedChangeID: 3},
+			wantTags:  []string{"p-3-5"},
+			wantKeys:  [][]int64{{4, 5}},
+			wantAcks:  []int64{5},
+			wantTotal: 2,
+		},
+		{
+			name:      "filtered regions are acknowledged without sending",
+			target:    database.FederationPushTarget{TargetID: "p", IncludeRegions: []string{"US", "CA"}, ExcludeRegions: []string{"CA"}},
+			wantTags:  []string{"p-0-2", "p-2-4"},
+			wantKeys:  [][]int64{{1}, {4}},
+			wantAcks:  []int64{2, 4, 5},
+			wantTotal: 2,
+		},
+		{
+			name:      "retries",
+			target:    database.FederationPushTarget{TargetID: "p", AckedChangeID: 3},
+			failures:  2,
+			wantTags:  []string{"p-3-5"},
+			wantKeys:  [][]int64{{4, 5}},
+			wantAcks:  []int64{5},
+			wantTotal: 2,
+		},
+		{
+			name:     "gives up",
+			target:   database.FederationPushTarget{TargetID: "p", AckedChangeID: 3},
+			failures: 3,
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			p := &partner{failures: tc.failures}
+			var acks []int64
+			deps := pushDependencies{
+				syncExposures: log.syncExposures,
+				send:          p.send,
+				ack: func(ctx context.Context, targetID string, from, to int64, keys int) error {
+					acks = append(acks, to)
+					return nil
+				},
+			}
+			config := &Config{BatchSize: 2, MaxAttempts: 3, RetryBackoff: time.Millisecond}
+
+			total, err := push(ctx, metrics.NewLogsBasedFromContext(ctx), deps, &tc.target, config)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("push: got error %v, want error %t", err, tc.wantErr)
+			}
+			if total != tc.wantTotal {
+				t.Errorf("push: got total %d, want %d", total, tc.wantTotal)
+			}
+			if diff := cmp.Diff(tc.wantTags, p.tags); diff != "" {
+				t.Errorf("tags mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantKeys, p.keys); diff != "" {
+				t.Errorf("keys mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantAcks, acks); diff != "" {
+				t.Errorf("acks mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRESTSender(t *testing.T) {
+	ctx := context.Background()
+	exposures := []*database.Exposure{makeExposure(1, true, "US")}
+	batch := buildBatch(exposures)
+
+	cases := []struct {
+		name    string
+		status  int
+		wantErr string
+	}{
+		{name: "created", status: http.StatusCreated},
+		{name: "duplicate", status: http.StatusConflict},
+		{name: "rejected", status: http.StatusBadRequest, wantErr: "unexpected status 400: bad batch"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got, want := r.Header.Get("Content-Type"), restContentType; got != want {
+					t.Errorf("got content type %q, want %q", got, want)
+				}
+				if got, want := r.Header.Get(BatchTagHeader), "p-0-1"; got != want {
+					t.Errorf("got batch tag %q, want %q", got, want)
+				}
+				if got, want := r.Header.Get("Authorization"), "Bearer token"; got != want {
+					t.Errorf("got authorization %q, want %q", got, want)
+				}
+				b, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					t.Errorf("reading body: %v", err)
+					return
+				}
+				var got pb.FederationFetchResponse
+				if err := proto.Unmarshal(b, &got); err != nil {
+					t.Errorf("unmarshalling body: %v", err)
+					return
+				}
+				if diff := cmp.Diff(batch, &got, protocmp.Transform()); diff != "" {
+					t.Errorf("batch mismatch (-want +got):\n%s", diff)
+				}
+				if tc.status >= 400 && tc.status != http.StatusConflict {
+					http.Error(w, "bad batch", tc.status)
+					return
+				}
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			token := func() (string, error) { return "token", nil }
+			err := restSender(srv.Client(), srv.URL, token)(ctx, "p-0-1", exposures)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("send: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got error %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
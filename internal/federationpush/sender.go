@@ -0,0 +1,231 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federationpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/efgs"
+	"github.com/google/exposure-notifications-server/internal/observability"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/api/idtoken"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/oauth"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// PushMethod is the gRPC method that partners using the GRPC protocol
+	// serve to receive keys. The request is a FederationFetchResponse holding
+	// the keys, and the reply is a google.protobuf.Empty.
+	PushMethod = "/Federation/Push"
+
+	// BatchTagHeader carries the batch tag, in the gRPC metadata or as an HTTP
+	// header. Retries of a batch have the same tag.
+	BatchTagHeader = "batchTag"
+
+	// restContentType is the content type of pushed REST batches.
+	restContentType = "application/protobuf; version=1.0"
+
+	// maxErrorBodySize bounds how much of a failed response is kept.
+	maxErrorBodySize = 1024
+)
+
+// newSender returns a function that sends batches to the target using its
+// protocol, and a function that releases the connection.
+func newSender(ctx context.Context, env *serverenv.ServerEnv, config *Config, t *database.FederationPushTarget) (sendFn, func() error, error) {
+	tlsConfig, err := clientTLSConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		token    func() (string, error)
+		rpcCreds credentials.PerRPCCredentials
+	)
+	if t.Audience != "" {
+		var clientOpts []idtoken.ClientOption
+		if config.CredentialsFile != "" {
+			clientOpts = append(clientOpts, idtoken.WithCredentialsFile(config.CredentialsFile))
+		}
+		ts, err := idtoken.NewTokenSource(ctx, t.Audience, clientOpts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating token source: %w", err)
+		}
+		rpcCreds = oauth.TokenSource{TokenSource: ts}
+		token = func() (string, error) {
+			tok, err := ts.Token()
+			if err != nil {
+				return "", err
+			}
+			return tok.AccessToken, nil
+		}
+	}
+
+	switch t.Protocol {
+	case database.FederationPushGRPC:
+		dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)), observability.GRPCDialOption()}
+		if rpcCreds != nil {
+			dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(rpcCreds))
+		}
+		conn, err := grpc.Dial(t.Endpoint, dialOpts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("dialing %s: %w", t.Endpoint, err)
+		}
+		return grpcSender(conn), conn.Close, nil
+	case database.FederationPushREST:
+		client := &http.Client{Transport: observability.HTTPTransport(&http.Transport{TLSClientConfig: tlsConfig})}
+		return restSender(client, t.Endpoint, token), func() error { return nil }, nil
+	case database.FederationPushEFGS:
+		signer, err := efgsBatchSigner(ctx, env, config)
+		if err != nil {
+			return nil, nil, err
+		}
+		client := &http.Client{Transport: observability.HTTPTransport(&http.Transport{TLSClientConfig: tlsConfig})}
+		return efgsSender(efgs.NewClient(t.Endpoint, client), config.EFGSCountry, signer), func() error { return nil }, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown push protocol %q", t.Protocol)
+	}
+}
+
+func clientTLSConfig(config *Config) (*tls.Config, error) {
+	cp, err := x509.SystemCertPool()
+	if err != nil {
+		return nil, fmt.Errorf("accessing system cert pool: %w", err)
+	}
+	if config.TLSCertFile != "" {
+		b, err := ioutil.ReadFile(config.TLSCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading cert file %q: %w", config.TLSCertFile, err)
+		}
+		if !cp.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %q", config.TLSCertFile)
+		}
+	}
+
+	tlsConfig := &tls.Config{RootCAs: cp, InsecureSkipVerify: config.TLSSkipVerify}
+	if config.TLSClientCertFile != "" || config.TLSClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSClientCertFile, config.TLSClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// grpcSender sends batches with the partner's Push RPC.
+func grpcSender(conn *grpc.ClientConn) sendFn {
+	return func(ctx context.Context, batchTag string, exposures []*database.Exposure) error {
+		batch := buildBatch(exposures)
+		ctx = metadata.AppendToOutgoingContext(ctx, BatchTagHeader, batchTag)
+		if err := conn.Invoke(ctx, PushMethod, batch, &empty.Empty{}); err != nil {
+			return fmt.Errorf("calling %s: %w", PushMethod, err)
+		}
+		return nil
+	}
+}
+
+// restSender POSTs batches to the partner's upload endpoint as protobuf. A
+// 409 Conflict means the partner already has the batch, so it counts as
+// accepted.
+func restSender(client *http.Client, endpoint string, token func() (string, error)) sendFn {
+	return func(ctx context.Context, batchTag string, exposures []*database.Exposure) error {
+		body, err := proto.Marshal(buildBatch(exposures))
+		if err != nil {
+			return fmt.Errorf("marshalling batch: %w", err)
+		}
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("building request: %w", err)
+		}
+		req.Header.Set("Content-Type", restContentType)
+		req.Header.Set(BatchTagHeader, batchTag)
+		if token != nil {
+			tok, err := token()
+			if err != nil {
+				return fmt.Errorf("getting token: %w", err)
+			}
+			req.Header.Set("Authorization", "Bearer "+tok)
+		}
+
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("posting batch: %w", err)
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusConflict:
+			return nil
+		default:
+			b, _ := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxErrorBodySize))
+			return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(b))
+		}
+	}
+}
+
+// efgsSender uploads batches to the EU federation gateway, signed with the
+// backend's signing certificate. The keys originate from country.
+func efgsSender(client *efgs.Client, country string, signer *efgs.BatchSigner) sendFn {
+	return func(ctx context.Context, batchTag string, exposures []*database.Exposure) error {
+		batch := &efgs.DiagnosisKeyBatch{}
+		for _, e := range exposures {
+			batch.Keys = append(batch.Keys, efgs.KeyFromExposure(e, country))
+		}
+		sig, err := signer.Sign(batch)
+		if err != nil {
+			return err
+		}
+		return client.Upload(ctx, batchTag, batch, sig)
+	}
+}
+
+// efgsBatchSigner returns the signer of gateway uploads, whose key is held by
+// the key manager.
+func efgsBatchSigner(ctx context.Context, env *serverenv.ServerEnv, config *Config) (*efgs.BatchSigner, error) {
+	if config.EFGSCountry == "" || config.EFGSSigningCertFile == "" || config.EFGSSigningKey == "" {
+		return nil, fmt.Errorf("EFGS_COUNTRY, EFGS_SIGNING_CERT_FILE and EFGS_SIGNING_KEY are required to push to the gateway")
+	}
+	b, err := ioutil.ReadFile(config.EFGSSigningCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing certificate %q: %w", config.EFGSSigningCertFile, err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no certificate found in %q", config.EFGSSigningCertFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing signing certificate: %w", err)
+	}
+	signer, err := env.GetSignerForKey(ctx, config.EFGSSigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("getting signer for %q: %w", config.EFGSSigningKey, err)
+	}
+	return efgs.NewBatchSigner(cert, signer)
+}
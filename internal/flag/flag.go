@@ -17,6 +17,8 @@ package flag
 import (
 	"fmt"
 	"strings"
+
+	"github.com/google/exposure-notifications-server/internal/database"
 )
 
 // RegionListVar is a list of upper-cased, unique regions derived from a comma-separated list.
@@ -42,3 +44,62 @@ func (l *RegionListVar) Set(val string) error {
 	}
 	return nil
 }
+
+// RegionMapVar maps upper-cased regions to other regions, derived from a
+// comma-separated list of FROM=TO pairs.
+type RegionMapVar map[string]string
+
+func (m *RegionMapVar) String() string {
+	return fmt.Sprint(*m)
+}
+
+// Set parses the flag value into the final result.
+func (m *RegionMapVar) Set(val string) error {
+	if len(*m) > 0 {
+		return fmt.Errorf("already set")
+	}
+
+	result := map[string]string{}
+	for _, v := range strings.Split(val, ",") {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%q must be in the form FROM=TO", v)
+		}
+		from := strings.ToUpper(strings.TrimSpace(parts[0]))
+		to := strings.ToUpper(strings.TrimSpace(parts[1]))
+		if from == "" || to == "" {
+			return fmt.Errorf("%q must be in the form FROM=TO", v)
+		}
+		result[from] = to
+	}
+	*m = result
+	return nil
+}
+
+// ReportTypeListVar is a list of lower-cased, unique, valid report types
+// derived from a comma-separated list.
+type ReportTypeListVar []string
+
+func (l *ReportTypeListVar) String() string {
+	return fmt.Sprint(*l)
+}
+
+// Set parses the flag value into the final result.
+func (l *ReportTypeListVar) Set(val string) error {
+	if len(*l) > 0 {
+		return fmt.Errorf("already set")
+	}
+
+	unique := map[string]struct{}{}
+	for _, v := range strings.Split(val, ",") {
+		vf := strings.ToLower(strings.TrimSpace(v))
+		if vf == "" || !database.ValidReportType(vf) {
+			return fmt.Errorf("invalid report type %q", v)
+		}
+		if _, seen := unique[vf]; !seen {
+			*l = append(*l, vf)
+			unique[vf] = struct{}{}
+		}
+	}
+	return nil
+}
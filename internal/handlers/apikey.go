@@ -0,0 +1,64 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+)
+
+const bearer = "Bearer "
+
+var (
+	// ErrMissingAPIKey is returned by AuthenticateAPIKey if the request has
+	// no bearer token.
+	ErrMissingAPIKey = &Error{Status: http.StatusUnauthorized, Message: "missing API key"}
+
+	// ErrInvalidAPIKey is returned by AuthenticateAPIKey if the bearer token
+	// isn't a known API key.
+	ErrInvalidAPIKey = &Error{Status: http.StatusUnauthorized, Message: "invalid API key"}
+)
+
+// APIKeyGetter looks up API keys, as database.DB does.
+type APIKeyGetter interface {
+	GetAPIKey(ctx context.Context, rawKey string) (*database.APIKey, error)
+}
+
+// AuthenticateAPIKey loads the API key presented as a bearer token and checks
+// that it has permission. A caller that may not proceed gets an Error:
+// ErrMissingAPIKey, ErrInvalidAPIKey, or one with status 403. Other errors
+// are failures to load the key.
+func AuthenticateAPIKey(ctx context.Context, db APIKeyGetter, r *http.Request, permission string) (*database.APIKey, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, bearer) {
+		return nil, ErrMissingAPIKey
+	}
+	apiKey, err := db.GetAPIKey(ctx, strings.TrimPrefix(auth, bearer))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, fmt.Errorf("loading API key: %w", err)
+	}
+	if !apiKey.HasPermission(permission) {
+		return nil, Errorf(http.StatusForbidden, "API key %v does not have the %v permission", apiKey.Name, permission)
+	}
+	return apiKey, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+)
+
+type fakeKeys map[string]*database.APIKey
+
+func (f fakeKeys) GetAPIKey(ctx context.Context, rawKey string) (*database.APIKey, error) {
+	if rawKey == "broken" {
+		return nil, errors.New("connection refused")
+	}
+	if k, ok := f[rawKey]; ok {
+		return k, nil
+	}
+	return nil, database.ErrNotFound
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	keys := fakeKeys{
+		"auditor": {Name: "auditor", Permissions: []string{database.PermissionAuditRead}},
+		"admin":   {Name: "admin", Permissions: []string{database.PermissionAppAdmin}},
+	}
+
+	cases := []struct {
+		name       string
+		auth       string
+		wantStatus int
+	}{
+		{name: "allowed", auth: "Bearer auditor"},
+		{name: "missing", auth: "", wantStatus: http.StatusUnauthorized},
+		{name: "not bearer", auth: "Basic auditor", wantStatus: http.StatusUnauthorized},
+		{name: "unknown", auth: "Bearer nobody", wantStatus: http.StatusUnauthorized},
+		{name: "no permission", auth: "Bearer admin", wantStatus: http.StatusForbidden},
+		{name: "lookup failed", auth: "Bearer broken", wantStatus: http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if c.auth != "" {
+				r.Header.Set("Authorization", c.auth)
+			}
+			apiKey, err := AuthenticateAPIKey(ctx, keys, r, database.PermissionAuditRead)
+			if c.wantStatus == 0 {
+				if err != nil || apiKey.Name != "auditor" {
+					t.Fatalf("got %v, %v, want the auditor key", apiKey, err)
+				}
+				return
+			}
+
+			w := httptest.NewRecorder()
+			WriteError(ctx, w, "Test request", err)
+			if w.Code != c.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, c.wantStatus)
+			}
+		})
+	}
+}
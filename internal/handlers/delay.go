@@ -12,14 +12,17 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package handlers provide common utilities for wrapping HTTP handlers.
+// Package handlers provide common utilities for wrapping HTTP handlers and
+// gRPC calls.
 package handlers
 
 import (
+	"context"
 	"net/http"
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/logging"
+	"google.golang.org/grpc"
 )
 
 // WithMinimumLatency wraps the passed in http handler func and ensures a minimum target duration is reached.
@@ -40,3 +43,22 @@ func WithMinimumLatency(target time.Duration, h http.Handler) http.HandlerFunc {
 		}
 	}
 }
+
+// UnaryWithMinimumLatency is a gRPC interceptor that, like WithMinimumLatency,
+// ensures that each unary call takes at least the target duration, whether it
+// succeeds or fails.
+func UnaryWithMinimumLatency(target time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		targetTime := time.Now().Add(target)
+		resp, err := handler(ctx, req)
+
+		if wait := time.Until(targetTime); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				logging.FromContext(ctx).Errorf("context cancelled before response could be sent")
+			}
+		}
+		return resp, err
+	}
+}
@@ -15,10 +15,14 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
+
+	"google.golang.org/grpc"
 )
 
 type th struct{}
@@ -49,3 +53,37 @@ func TestDelayedReturn(t *testing.T) {
 		}
 	}
 }
+
+func TestUnaryWithMinimumLatency(t *testing.T) {
+	const target = 100 * time.Millisecond
+	interceptor := UnaryWithMinimumLatency(target)
+	info := &grpc.UnaryServerInfo{FullMethod: "/Publish/Publish"}
+
+	// Failed calls, such as rejected chaff or invalid requests, must take as
+	// long as successful ones.
+	for _, wantErr := range []error{nil, errors.New("invalid request")} {
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "response", wantErr
+		}
+		st := time.Now()
+		resp, err := interceptor(context.Background(), "request", info, handler)
+		if elapsed := time.Since(st); elapsed < target {
+			t.Errorf("error %v: latency too low, got %v, want > %v", wantErr, elapsed, target)
+		}
+		if resp != "response" || err != wantErr {
+			t.Errorf("got %v, %v, want the handler's response, %v", resp, err, wantErr)
+		}
+	}
+
+	// A canceled call returns without waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+	st := time.Now()
+	interceptor(ctx, "request", info, handler)
+	if elapsed := time.Since(st); elapsed >= target {
+		t.Errorf("canceled call took %v, want < %v", elapsed, target)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+)
+
+// Error is an error with the HTTP status to respond with. Its message is
+// shown to the caller.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf returns an Error with status and a formatted message.
+func Errorf(status int, format string, args ...interface{}) error {
+	return &Error{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// BadRequest returns an Error with status 400 and a formatted message.
+func BadRequest(format string, args ...interface{}) error {
+	return Errorf(http.StatusBadRequest, format, args...)
+}
+
+// WriteError responds with the status and message of err if it is an Error.
+// Other errors are logged, prefixed by what failed, and answered with a
+// generic internal error.
+func WriteError(ctx context.Context, w http.ResponseWriter, what string, err error) {
+	var e *Error
+	if errors.As(err, &e) {
+		logging.FromContext(ctx).Debug(e.Message)
+		http.Error(w, e.Message, e.Status)
+		return
+	}
+	logging.FromContext(ctx).Errorf("%s failed: %v", what, err)
+	http.Error(w, "Internal error", http.StatusInternalServerError)
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+)
+
+// PaddingHeader is the response header that carries padding.
+const PaddingHeader = "X-Padding"
+
+// WithPadding wraps the passed in http handler and pads every response with
+// the PaddingHeader header, so that its status line, headers and body add up
+// to a multiple of bucketBytes. Responses that fit in the same bucket are
+// then the same size on the wire, and network observers can't distinguish
+// them. The response is buffered, so h can't stream. A bucketBytes of zero or
+// less disables padding.
+func WithPadding(bucketBytes int, h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if bucketBytes <= 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		pw := &paddingWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(pw, r)
+
+		header := w.Header()
+		header.Del(PaddingHeader)
+		header.Set("Content-Length", strconv.Itoa(pw.body.Len()))
+		if header.Get("Content-Type") == "" {
+			// Set what the server would sniff, so that it's counted.
+			header.Set("Content-Type", http.DetectContentType(pw.body.Bytes()))
+		}
+
+		// The padding header's own name and separators count too.
+		size := responseSize(pw.status, header, pw.body.Len()) + len(PaddingHeader) + len(": \r\n")
+		padding, err := RandomPadding(PaddedSize(size, bucketBytes) - size)
+		if err != nil {
+			logging.FromContext(r.Context()).Errorf("failed to generate response padding: %v", err)
+		} else {
+			header.Set(PaddingHeader, padding)
+		}
+
+		w.WriteHeader(pw.status)
+		if _, err := w.Write(pw.body.Bytes()); err != nil {
+			logging.FromContext(r.Context()).Errorf("failed to write padded response: %v", err)
+		}
+	}
+}
+
+// paddingWriter buffers a response until it can be padded.
+type paddingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *paddingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+}
+
+func (w *paddingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.body.Write(b)
+}
+
+// responseSize returns the number of bytes of an HTTP/1.1 response that vary
+// between responses: the status text, the headers and the body.
+func responseSize(status int, header http.Header, bodyLen int) int {
+	size := len(http.StatusText(status)) + bodyLen
+	for k, vs := range header {
+		for _, v := range vs {
+			size += len(k) + len(": \r\n") + len(v)
+		}
+	}
+	return size
+}
+
+// PaddedSize returns size rounded up to a multiple of bucketBytes.
+func PaddedSize(size, bucketBytes int) int {
+	if bucketBytes <= 0 {
+		return size
+	}
+	return (size + bucketBytes - 1) / bucketBytes * bucketBytes
+}
+
+// RandomPadding returns a random string of n characters.
+func RandomPadding(n int) (string, error) {
+	if n <= 0 {
+		return "", nil
+	}
+	// Base64 encodes 3 bytes in 4 characters.
+	b := make([]byte, n*3/4+3)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b)[:n], nil
+}
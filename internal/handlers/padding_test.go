@@ -0,0 +1,101 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithPadding(t *testing.T) {
+	const bucketBytes = 1024
+
+	responses := []struct {
+		status int
+		header string
+		body   string
+	}{
+		{http.StatusOK, "", ""},
+		{http.StatusOK, "application/json", `{"revisionToken":"` + strings.Repeat("a", 300) + `"}`},
+		{http.StatusBadRequest, "application/json", `{"error":"bad request","code":"bad_request"}`},
+		{http.StatusTooManyRequests, "text/plain", strings.Repeat("x", 700)},
+	}
+
+	var sizes []int
+	for _, resp := range responses {
+		resp := resp
+		srv := httptest.NewServer(WithPadding(bucketBytes, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if resp.header != "" {
+				w.Header().Set("Content-Type", resp.header)
+			}
+			w.WriteHeader(resp.status)
+			fmt.Fprint(w, resp.body)
+		})))
+
+		// Read the raw response, to measure what a network observer sees.
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		raw, err := ioutil.ReadAll(conn)
+		conn.Close()
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(string(raw), "\r\n\r\n"+resp.body) {
+			t.Errorf("response %q doesn't end with body %q", raw, resp.body)
+		}
+		sizes = append(sizes, len(raw))
+	}
+
+	for i, size := range sizes {
+		if size != sizes[0] {
+			t.Errorf("response %d is %d bytes, response 0 is %d bytes", i, size, sizes[0])
+		}
+	}
+}
+
+func TestWithPaddingDisabled(t *testing.T) {
+	handler := WithPadding(0, &th{})
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/", nil))
+	if got := w.Header().Get(PaddingHeader); got != "" {
+		t.Errorf("got %d bytes of padding, want none", len(got))
+	}
+}
+
+func TestPaddedSize(t *testing.T) {
+	cases := []struct {
+		size, bucket, want int
+	}{
+		{0, 1024, 0},
+		{1, 1024, 1024},
+		{1024, 1024, 1024},
+		{1025, 1024, 2048},
+		{10, 0, 10},
+	}
+	for _, c := range cases {
+		if got := PaddedSize(c.size, c.bucket); got != c.want {
+			t.Errorf("PaddedSize(%d, %d) = %d, want %d", c.size, c.bucket, got, c.want)
+		}
+	}
+}
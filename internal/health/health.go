@@ -0,0 +1,263 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health serves the liveness and readiness endpoints of the servers,
+// for load balancers and Kubernetes probes.
+//
+// /healthz reports that the process is up and serving. It doesn't check any
+// dependency, so that an outage of a shared dependency doesn't restart every
+// server at once.
+//
+// /readyz runs the checks of the server's dependencies, such as the database,
+// the schema version, the blobstore and the signing keys, and reports the
+// status of each as JSON. It responds 200 if all checks pass, and 503
+// otherwise.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/migrate"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+)
+
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+
+	// defaultTimeout bounds each check.
+	defaultTimeout = 5 * time.Second
+
+	// probeObject is read from buckets to check that they are reachable. It
+	// normally doesn't exist.
+	probeObject = "healthz-probe"
+)
+
+// Check checks a single dependency and returns an error if it is unavailable.
+type Check func(ctx context.Context) error
+
+// Response is the JSON body of /healthz and /readyz.
+type Response struct {
+	Status string                  `json:"status"`
+	Checks map[string]*CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the outcome of a single check.
+type CheckResult struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Latency string `json:"latency"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the readiness checks of a server.
+type Checker struct {
+	env     *serverenv.ServerEnv
+	timeout time.Duration
+
+	mu     sync.Mutex
+	checks []namedCheck
+}
+
+// New creates a Checker with the checks every server needs: that the database
+// accepts queries, and that its schema isn't older than the migrations of
+// this binary. Servers add checks for their other dependencies with Add.
+func New(env *serverenv.ServerEnv) *Checker {
+	c := &Checker{
+		env:     env,
+		timeout: defaultTimeout,
+	}
+	if db := env.Database(); db != nil {
+		c.Add("database", db.Ping)
+		c.Add("migrations", c.checkMigrations)
+	}
+	return c
+}
+
+// Add adds a readiness check named name.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// AddBlobstore adds a check that the buckets of the export configs can be
+// read from the blobstore.
+func (c *Checker) AddBlobstore() {
+	c.Add("blobstore", c.checkBlobstore)
+}
+
+// AddSigners adds a check that a signer can be created for every signing key
+// that hasn't ended, which verifies that the key manager is reachable and
+// holds the keys.
+func (c *Checker) AddSigners() {
+	c.Add("signers", c.checkSigners)
+}
+
+// HandleHealthz returns the handler of /healthz.
+func (c *Checker) HandleHealthz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(r.Context(), w, http.StatusOK, &Response{Status: StatusOK})
+	})
+}
+
+// HandleReadyz returns the handler of /readyz.
+func (c *Checker) HandleReadyz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := c.Run(r.Context())
+		code := http.StatusOK
+		if resp.Status != StatusOK {
+			code = http.StatusServiceUnavailable
+		}
+		writeResponse(r.Context(), w, code, resp)
+	})
+}
+
+// Run runs all checks concurrently and returns their results.
+func (c *Checker) Run(ctx context.Context) *Response {
+	logger := logging.FromContext(ctx)
+
+	c.mu.Lock()
+	checks := append([]namedCheck(nil), c.checks...)
+	c.mu.Unlock()
+
+	results := make([]*CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func(i int, nc namedCheck) {
+			defer wg.Done()
+			results[i] = c.runCheck(ctx, nc.check)
+		}(i, nc)
+	}
+	wg.Wait()
+
+	resp := &Response{
+		Status: StatusOK,
+		Checks: make(map[string]*CheckResult, len(checks)),
+	}
+	for i, nc := range checks {
+		resp.Checks[nc.name] = results[i]
+		if results[i].Status != StatusOK {
+			resp.Status = StatusFailed
+			logger.Warnf("health check %v failed: %v", nc.name, results[i].Error)
+		}
+	}
+	return resp
+}
+
+func (c *Checker) runCheck(ctx context.Context, check Check) *CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := &CheckResult{
+		Status:  StatusOK,
+		Latency: time.Since(start).Round(time.Millisecond).String(),
+	}
+	if err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (c *Checker) checkMigrations(ctx context.Context) error {
+	version, dirty, err := c.env.Database().SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty", version)
+	}
+	latest, err := migrate.LatestVersion()
+	if err != nil {
+		return err
+	}
+	// A newer schema is expected while a deployment rolls out.
+	if version < latest {
+		return fmt.Errorf("schema version %d is older than %d", version, latest)
+	}
+	return nil
+}
+
+func (c *Checker) checkBlobstore(ctx context.Context) error {
+	blobstore := c.env.Blobstore()
+	if blobstore == nil {
+		return fmt.Errorf("no blobstore is configured")
+	}
+	configs, err := c.env.Database().ListExportConfigs(ctx)
+	if err != nil {
+		return fmt.Errorf("listing export configs: %w", err)
+	}
+
+	buckets := make(map[string]struct{})
+	for _, ec := range configs {
+		buckets[ec.BucketName] = struct{}{}
+	}
+	names := make([]string, 0, len(buckets))
+	for name := range buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, bucket := range names {
+		_, err := blobstore.GetObject(ctx, bucket, probeObject)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+			return fmt.Errorf("bucket %v: %w", bucket, err)
+		}
+	}
+	return nil
+}
+
+func (c *Checker) checkSigners(ctx context.Context) error {
+	infos, err := c.env.Database().ListSignatureInfos(ctx)
+	if err != nil {
+		return fmt.Errorf("listing signature infos: %w", err)
+	}
+
+	now := time.Now()
+	for _, si := range infos {
+		if !si.EndTimestamp.IsZero() && si.EndTimestamp.Before(now) {
+			continue
+		}
+		if _, err := c.env.GetSignerForKey(ctx, si.SigningKey); err != nil {
+			return fmt.Errorf("signature info %d: %w", si.ID, err)
+		}
+	}
+	return nil
+}
+
+func writeResponse(ctx context.Context, w http.ResponseWriter, code int, resp *Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logging.FromContext(ctx).Errorf("Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHealthz(t *testing.T) {
+	c := New(serverenv.New(context.Background()))
+	c.Add("broken", func(context.Context) error { return errors.New("down") })
+
+	w := httptest.NewRecorder()
+	c.HandleHealthz().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var got Response
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Response{Status: StatusOK}, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	cases := []struct {
+		name     string
+		checks   map[string]Check
+		wantCode int
+		want     map[string]string
+	}{
+		{
+			name:     "no checks",
+			wantCode: http.StatusOK,
+			want:     map[string]string{},
+		},
+		{
+			name: "all pass",
+			checks: map[string]Check{
+				"a": func(context.Context) error { return nil },
+				"b": func(context.Context) error { return nil },
+			},
+			wantCode: http.StatusOK,
+			want:     map[string]string{"a": StatusOK, "b": StatusOK},
+		},
+		{
+			name: "one fails",
+			checks: map[string]Check{
+				"a": func(context.Context) error { return nil },
+				"b": func(context.Context) error { return errors.New("down") },
+			},
+			wantCode: http.StatusServiceUnavailable,
+			want:     map[string]string{"a": StatusOK, "b": StatusFailed},
+		},
+		{
+			name: "times out",
+			checks: map[string]Check{
+				"slow": func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			},
+			wantCode: http.StatusServiceUnavailable,
+			want:     map[string]string{"slow": StatusFailed},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := New(serverenv.New(context.Background()))
+			c.timeout = 10 * time.Millisecond
+			for name, check := range tc.checks {
+				c.Add(name, check)
+			}
+
+			w := httptest.NewRecorder()
+			c.HandleReadyz().ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+			if w.Code != tc.wantCode {
+				t.Errorf("got status %d, want %d", w.Code, tc.wantCode)
+			}
+
+			var resp Response
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for name, result := range resp.Checks {
+				got[name] = result.Status
+				if result.Status == StatusFailed && result.Error == "" {
+					t.Errorf("check %v failed without an error", name)
+				}
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package integration holds end-to-end tests that run the services together
+// against a real database: keys are published, batched, exported, verified
+// and cleaned up as one flow. Blobs are kept in memory and export files are
+// signed by a key generated for the test.
+//
+// The tests only build with the integration tag:
+//
+//	go test -tags=integration ./internal/integration/...
+//
+// They use the database of the DB_ environment variables if DB_USER is set,
+// such as one started by scripts/dev dbstart. Otherwise they start a Postgres
+// container with docker, and are skipped if docker isn't available.
+package integration
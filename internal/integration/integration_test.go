@@ -0,0 +1,149 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/signing"
+)
+
+// postgresImage is the image of the Postgres container, the same one that
+// scripts/dev starts.
+const postgresImage = "registry.hub.docker.com/library/postgres:12-alpine"
+
+var testDB *database.DB
+
+func TestMain(m *testing.M) {
+	ctx := context.Background()
+
+	if os.Getenv("DB_USER") == "" {
+		stop, err := startPostgres(ctx)
+		if err != nil {
+			log.Printf("Skipping integration tests, no database: %v", err)
+			os.Exit(m.Run())
+		}
+		code := runWithDB(ctx, m)
+		stop()
+		os.Exit(code)
+	}
+	os.Exit(runWithDB(ctx, m))
+}
+
+// runWithDB creates the test database, waiting for the server to accept
+// connections, and runs the tests.
+func runWithDB(ctx context.Context, m *testing.M) int {
+	deadline := time.Now().Add(time.Minute)
+	for {
+		var err error
+		testDB, err = database.CreateTestDB(ctx)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			log.Printf("creating test DB: %v", err)
+			return 1
+		}
+		time.Sleep(time.Second)
+	}
+	defer testDB.Close(ctx)
+	return m.Run()
+}
+
+// startPostgres starts a throwaway Postgres container and points the DB_
+// environment variables at it. The returned function removes the container.
+func startPostgres(ctx context.Context) (func(), error) {
+	const user, password = "en-server", "integration"
+
+	out, err := exec.CommandContext(ctx, "docker", "run", "--detach", "--rm",
+		"--env", "POSTGRES_USER="+user,
+		"--env", "POSTGRES_PASSWORD="+password,
+		"--publish", "127.0.0.1::5432",
+		postgresImage).Output()
+	if err != nil {
+		return nil, fmt.Errorf("starting postgres container: %w", err)
+	}
+	id := strings.TrimSpace(string(out))
+	stop := func() {
+		if err := exec.Command("docker", "rm", "--force", id).Run(); err != nil {
+			log.Printf("removing postgres container %v: %v", id, err)
+		}
+	}
+
+	out, err = exec.CommandContext(ctx, "docker", "port", id, "5432/tcp").Output()
+	if err != nil {
+		stop()
+		return nil, fmt.Errorf("reading postgres port: %w", err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		stop()
+		return nil, fmt.Errorf("postgres container %v publishes no port", id)
+	}
+	host, port, err := net.SplitHostPort(fields[0])
+	if err != nil {
+		stop()
+		return nil, fmt.Errorf("parsing postgres address %q: %w", out, err)
+	}
+
+	for k, v := range map[string]string{
+		"DB_HOST":     host,
+		"DB_PORT":     port,
+		"DB_USER":     user,
+		"DB_PASSWORD": password,
+		"DB_NAME":     "postgres",
+		"DB_SSLMODE":  "disable",
+	} {
+		os.Setenv(k, v)
+	}
+	return stop, nil
+}
+
+// fakeKeyManager signs with one key generated for the test, whatever key it
+// is asked for, standing in for a KMS.
+type fakeKeyManager struct {
+	key *ecdsa.PrivateKey
+}
+
+func newFakeKeyManager(t *testing.T) *fakeKeyManager {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeKeyManager{key: key}
+}
+
+var _ signing.KeyManager = (*fakeKeyManager)(nil)
+
+func (km *fakeKeyManager) NewSigner(context.Context, string) (crypto.Signer, error) {
+	return km.key, nil
+}
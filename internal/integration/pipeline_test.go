@@ -0,0 +1,265 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/cleanup"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/envconfig"
+	"github.com/google/exposure-notifications-server/internal/export"
+	"github.com/google/exposure-notifications-server/internal/publish"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/storage"
+)
+
+const (
+	appPackageName = "com.example.integration"
+	exportBucket   = "exports"
+)
+
+// TestPipeline publishes keys, exports them to signed files, verifies the
+// files, and cleans up the keys and files once they expire. The clock is
+// moved forward by moving the timestamps of the rows back.
+func TestPipeline(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	database.ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	apps := &authorizedapp.MemoryProvider{Data: map[string]*model.AuthorizedApp{
+		appPackageName: {
+			AppPackageName:    appPackageName,
+			Platform:          "android",
+			AllowedRegions:    map[string]struct{}{"US": {}},
+			SafetyNetDisabled: true,
+		},
+	}}
+	blobstore, err := storage.NewMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyManager := newFakeKeyManager(t)
+	env := serverenv.New(ctx,
+		serverenv.WithDatabase(testDB),
+		serverenv.WithAuthorizedAppProvider(apps),
+		serverenv.WithBlobStorage(blobstore),
+		serverenv.WithKeyManager(keyManager))
+
+	si := &database.SignatureInfo{
+		SigningKey:        "integration-key",
+		AppPackageName:    appPackageName,
+		SigningKeyID:      "310",
+		SigningKeyVersion: "v1",
+	}
+	if err := testDB.AddSignatureInfo(ctx, si); err != nil {
+		t.Fatal(err)
+	}
+	ec := &database.ExportConfig{
+		BucketName:       exportBucket,
+		FilenameRoot:     "us",
+		Period:           24 * time.Hour,
+		Region:           "US",
+		From:             time.Now().Add(-72 * time.Hour),
+		SignatureInfoIDs: []int64{si.ID},
+	}
+	if err := testDB.AddExportConfig(ctx, ec); err != nil {
+		t.Fatal(err)
+	}
+
+	// Publish.
+	var publishConfig publish.Config
+	processConfig(t, &publishConfig)
+	publishConfig.DebugAPIResponses = true
+	publishHandler, err := publish.NewHandler(ctx, &publishConfig, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := newKeys(t, 3)
+	body, err := json.Marshal(&database.Publish{
+		Keys:           keys,
+		Regions:        []string{"US"},
+		AppPackageName: appPackageName,
+		Platform:       "android",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	publishHandler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("publish: got status %d: %s", w.Code, w.Body)
+	}
+	if got := countRows(t, "Exposure"); got != len(keys) {
+		t.Fatalf("after publish: got %d exposures, want %d", got, len(keys))
+	}
+
+	// A day later, the keys are in a closed batch.
+	shiftTimestamps(t, 24*time.Hour)
+
+	var exportConfig export.Config
+	processConfig(t, &exportConfig)
+	exportConfig.MinWindowAge = 0
+	exportConfig.MinRecords = 0
+	exportConfig.PaddingRange = 0
+	exportServer, err := export.NewServer(&exportConfig, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exportServer.CreateBatches(ctx); err != nil {
+		t.Fatalf("CreateBatches: %v", err)
+	}
+	if got := countRows(t, "ExportBatch"); got != 1 {
+		t.Fatalf("got %d export batches, want 1", got)
+	}
+
+	// Export.
+	w = httptest.NewRecorder()
+	exportServer.WorkerHandler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("export worker: got status %d: %s", w.Code, w.Body)
+	}
+	files := exportFiles(t, blobstore)
+	if len(files) != 1 {
+		t.Fatalf("got export files %v, want 1", files)
+	}
+
+	// Verify.
+	data, err := blobstore.GetObject(ctx, exportBucket, files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err := export.VerifyExportSignatures(data, []*export.VerificationKey{{
+		KeyID:      si.SigningKeyID,
+		KeyVersion: si.SigningKeyVersion,
+		PublicKey:  &keyManager.key.PublicKey,
+	}})
+	if err != nil {
+		t.Fatalf("VerifyExportSignatures: %v", err)
+	}
+	if verified.Region != "US" || verified.NumKeys != len(keys) || len(verified.Signatures) != 1 {
+		t.Errorf("got export of %d keys for %s with %d signatures, want %d keys for US with 1 signature",
+			verified.NumKeys, verified.Region, len(verified.Signatures), len(keys))
+	}
+
+	// Past the TTL, the keys and files are deleted.
+	var cleanupConfig cleanup.Config
+	processConfig(t, &cleanupConfig)
+	shiftTimestamps(t, cleanupConfig.TTL+24*time.Hour)
+
+	exposureCleanup, err := cleanup.NewExposureHandler(&cleanupConfig, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportCleanup, err := cleanup.NewExportHandler(&cleanupConfig, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, h := range map[string]http.Handler{"exposures": exposureCleanup, "exports": exportCleanup} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("cleanup %s: got status %d: %s", name, w.Code, w.Body)
+		}
+	}
+	if got := countRows(t, "Exposure"); got != 0 {
+		t.Errorf("after cleanup: got %d exposures, want 0", got)
+	}
+	if files := exportFiles(t, blobstore); len(files) != 0 {
+		t.Errorf("after cleanup: got export files %v, want none", files)
+	}
+}
+
+// processConfig fills config from the environment and its defaults.
+func processConfig(t *testing.T, config interface{}) {
+	t.Helper()
+	if err := envconfig.Process(context.Background(), config, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newKeys returns n random keys that expired on the n days before today.
+func newKeys(t *testing.T, n int) []database.ExposureKey {
+	t.Helper()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	var keys []database.ExposureKey
+	for i := 1; i <= n; i++ {
+		key := make([]byte, database.KeyLength)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, database.ExposureKey{
+			Key:              base64.StdEncoding.EncodeToString(key),
+			IntervalNumber:   int32(today.AddDate(0, 0, -i).Unix() / 600),
+			IntervalCount:    144,
+			TransmissionRisk: 5,
+		})
+	}
+	return keys
+}
+
+// shiftTimestamps moves the exposures and export batches back by d, as if d
+// had passed.
+func shiftTimestamps(t *testing.T, d time.Duration) {
+	t.Helper()
+	ctx := context.Background()
+	seconds := int(d.Seconds())
+	for _, stmt := range []string{
+		`UPDATE Exposure SET created_at = created_at -  * INTERVAL '1 second'`,
+		`UPDATE ExportBatch SET start_timestamp = start_timestamp -  * INTERVAL '1 second', end_timestamp = end_timestamp -  * INTERVAL '1 second'`,
+	} {
+		if _, err := testDB.Pool.Exec(ctx, stmt, seconds); err != nil {
+			t.Fatalf("shifting timestamps: %v", err)
+		}
+	}
+}
+
+// countRows returns the number of rows of table.
+func countRows(t *testing.T, table string) int {
+	t.Helper()
+	var n int
+	if err := testDB.Pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+		t.Fatalf("counting %s: %v", table, err)
+	}
+	return n
+}
+
+// exportFiles returns the names of the export files in the blobstore.
+func exportFiles(t *testing.T, blobstore storage.Blobstore) []string {
+	t.Helper()
+	var files []string
+	for _, name := range blobstore.(*storage.Memory).ListObjects(exportBucket) {
+		if strings.HasSuffix(name, ".zip") {
+			files = append(files, name)
+		}
+	}
+	return files
+}
@@ -28,6 +28,8 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/observability"
+
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 )
@@ -85,7 +87,7 @@ func ValidateDeviceToken(ctx context.Context, deviceToken string, opts *VerifyOp
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", signedJwt))
 
 	// Call Apple's API.
-	client := &http.Client{Timeout: httpTimeout}
+	client := &http.Client{Timeout: httpTimeout, Transport: observability.HTTPTransport(nil)}
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to make request: %w", err)
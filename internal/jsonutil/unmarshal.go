@@ -25,28 +25,52 @@ import (
 )
 
 const (
-	// Max request size of 64KB. None of the current API requests for this
-	// server are near that limit. Prevents us from unnecessarily parsing JSON
-	// payloads that are much large than we anticipate.
-	maxBodyBytes = 64_000
+	// DefaultMaxBodyBytes is a max request size of 64KB. None of the current
+	// API requests for this server are near that limit. Prevents us from
+	// unnecessarily parsing JSON payloads that are much large than we
+	// anticipate.
+	DefaultMaxBodyBytes = 64_000
+
+	// DefaultMaxDepth is the deepest nesting of objects and arrays allowed in a
+	// request. The deepest API request nests three levels.
+	DefaultMaxDepth = 8
 )
 
+// ErrTooDeep is returned while reading JSON that nests objects and arrays
+// deeper than allowed.
+var ErrTooDeep = errors.New("json is nested too deeply")
+
 // Unmarshal provides a common implementation of JSON unmarshalling with well defined error handling.
 func Unmarshal(w http.ResponseWriter, r *http.Request, data interface{}) (int, error) {
+	return UnmarshalLimited(w, r, data, DefaultMaxBodyBytes, DefaultMaxDepth)
+}
+
+// UnmarshalLimited is like Unmarshal, but rejects bodies larger than
+// maxBodyBytes or nested deeper than maxDepth. Both limits are enforced while
+// the body is read, so an oversized or deeply nested body is rejected before
+// it has been buffered in full.
+func UnmarshalLimited(w http.ResponseWriter, r *http.Request, data interface{}, maxBodyBytes int64, maxDepth int) (int, error) {
 	if t := r.Header.Get("Content-type"); t != "application/json" {
 		return http.StatusUnsupportedMediaType, fmt.Errorf("content-type is not application/json")
 	}
 
 	defer r.Body.Close()
+	if r.ContentLength > maxBodyBytes {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("http: request body too large")
+	}
 	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
 
-	d := json.NewDecoder(r.Body)
+	body := &depthLimitReader{r: r.Body, maxDepth: maxDepth}
+	d := json.NewDecoder(body)
 	d.DisallowUnknownFields()
 
 	if err := d.Decode(&data); err != nil {
 		var syntaxErr *json.SyntaxError
 		var unmarshalError *json.UnmarshalTypeError
 		switch {
+		case body.err != nil:
+			// The decoder may report this as an unexpected end of input.
+			return http.StatusBadRequest, body.err
 		case errors.As(err, &syntaxErr):
 			return http.StatusBadRequest, fmt.Errorf("malformed json at position %v", syntaxErr.Offset)
 		case errors.Is(err, io.ErrUnexpectedEOF):
@@ -70,3 +94,46 @@ func Unmarshal(w http.ResponseWriter, r *http.Request, data interface{}) (int, e
 
 	return http.StatusOK, nil
 }
+
+// depthLimitReader fails once the JSON read through it nests objects and
+// arrays deeper than maxDepth. It tracks strings so that brackets inside them
+// aren't counted.
+type depthLimitReader struct {
+	r        io.Reader
+	maxDepth int
+
+	depth    int
+	inString bool
+	escaped  bool
+	err      error
+}
+
+func (d *depthLimitReader) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	n, err := d.r.Read(p)
+	for i, c := range p[:n] {
+		switch {
+		case d.escaped:
+			d.escaped = false
+		case d.inString:
+			if c == '\\' {
+				d.escaped = true
+			} else if c == '"' {
+				d.inString = false
+			}
+		case c == '"':
+			d.inString = true
+		case c == '{' || c == '[':
+			d.depth++
+			if d.depth > d.maxDepth {
+				d.err = ErrTooDeep
+				return i, d.err
+			}
+		case c == '}' || c == ']':
+			d.depth--
+		}
+	}
+	return n, err
+}
@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -111,6 +112,55 @@ func TestInvalidStructure(t *testing.T) {
 	unmarshalTestHelper(t, invalidJSON, errors, http.StatusBadRequest)
 }
 
+func TestTooDeep(t *testing.T) {
+	invalidJSON := []string{
+		`{"regions": [[[[[[[[["us"]]]]]]]]]}`,
+		`[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[`,
+	}
+	errors := []string{
+		`json is nested too deeply`,
+		`json is nested too deeply`,
+	}
+	unmarshalTestHelper(t, invalidJSON, errors, http.StatusBadRequest)
+}
+
+func TestBracketsInStrings(t *testing.T) {
+	validJSON := []string{
+		`{"appPackageName": "[[[[[[[[[[{{{{{{{{{{\\\"[[[[[[[[["}`,
+	}
+	errors := []string{
+		"",
+	}
+	unmarshalTestHelper(t, validJSON, errors, http.StatusOK)
+}
+
+func TestTooLarge(t *testing.T) {
+	body := `{"appPackageName": "` + strings.Repeat("a", 100) + `"}`
+
+	cases := []struct {
+		name          string
+		contentLength int64
+	}{
+		{name: "content_length", contentLength: int64(len(body))},
+		{name: "streamed", contentLength: -1},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+			r.Header.Set("content-type", "application/json")
+			r.ContentLength = c.contentLength
+
+			w := httptest.NewRecorder()
+			code, err := UnmarshalLimited(w, r, &database.Publish{}, 50, DefaultMaxDepth)
+			if code != http.StatusRequestEntityTooLarge {
+				t.Errorf("unmarshal wanted %v response code, got %v (%v)", http.StatusRequestEntityTooLarge, code, err)
+			}
+		})
+	}
+}
+
 func TestValidPublishMessage(t *testing.T) {
 	intervalNumber := int32(time.Date(2020, 04, 17, 20, 04, 01, 1, time.UTC).Unix() / 600)
 	json := `{"temporaryExposureKeys": [
@@ -0,0 +1,50 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyrefresh
+
+import (
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/scheduler"
+	"github.com/google/exposure-notifications-server/internal/setup"
+)
+
+// Compile-time check to assert this config matches requirements.
+var _ setup.DBConfigProvider = (*Config)(nil)
+
+// Config represents the configuration and associated environment variables for
+// the health authority key refresh.
+type Config struct {
+	Database *database.Config
+	Port     string        `envconfig:"PORT" default:"8080"`
+	Timeout  time.Duration `envconfig:"KEY_REFRESH_TIMEOUT" default:"5m"`
+
+	// GracePeriod is how long a key that was removed from a health
+	// authority's key set is still accepted, so that certificates issued
+	// before the rotation can still be used.
+	GracePeriod time.Duration `envconfig:"KEY_REFRESH_GRACE_PERIOD" default:"24h"`
+
+	// Interval, if not zero, makes the keys get refreshed on their own every
+	// Interval, in addition to when the handler is called. Scheduler
+	// configures how instances share the runs.
+	Interval  time.Duration `envconfig:"KEY_REFRESH_INTERVAL" default:"0"`
+	Scheduler *scheduler.Config
+}
+
+// DB returns the database configuration.
+func (c *Config) DB() *database.Config {
+	return c.Database
+}
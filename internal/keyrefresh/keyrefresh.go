@@ -0,0 +1,182 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keyrefresh refreshes the keys of health authorities that publish the
+// keys of their verification certificates as a JSON Web Key Set.
+package keyrefresh
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/scheduler"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/verification"
+)
+
+// keyDB is the part of the database that stores health authority keys.
+type keyDB interface {
+	ListHealthAuthorities(ctx context.Context) ([]*database.HealthAuthority, error)
+	SyncHealthAuthorityKeys(ctx context.Context, healthAuthorityID string, keySet map[string]string, now, graceEnd time.Time) (int, int, error)
+}
+
+// keyFetcher downloads key sets.
+type keyFetcher interface {
+	Fetch(ctx context.Context, uri string) (map[string]crypto.PublicKey, error)
+}
+
+// NewHandler creates a http.Handler that refreshes the keys of every health
+// authority with a JWKS URI. It is meant to be run periodically, like the
+// cleanup jobs.
+func NewHandler(config *Config, env *serverenv.ServerEnv) (*Handler, error) {
+	if env.Database() == nil {
+		return nil, fmt.Errorf("missing database in server environment")
+	}
+
+	return &Handler{
+		config: config,
+		env:    env,
+		db:     env.Database(),
+		keys:   verification.NewKeySet(0),
+	}, nil
+}
+
+// Handler refreshes the keys of health authorities when it is called or, see
+// Jobs, on a schedule of its own.
+type Handler struct {
+	config *Config
+	env    *serverenv.ServerEnv
+	db     keyDB
+	keys   keyFetcher
+}
+
+// RefreshResponse is the response of a key refresh.
+type RefreshResponse struct {
+	Refreshed []*RefreshedKeys `json:"refreshed"`
+	Errors    []string         `json:"errors,omitempty"`
+}
+
+// RefreshedKeys counts the keys of a health authority that a refresh added and
+// ended.
+type RefreshedKeys struct {
+	HealthAuthorityID string `json:"healthAuthorityId"`
+	Added             int    `json:"added"`
+	Ended             int    `json:"ended"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+
+	resp, err := h.refreshAll(ctx)
+	if err != nil {
+		http.Error(w, "Failed to list health authorities, check logs.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(resp.Errors) > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf("Failed to write response: %v", err)
+	}
+}
+
+// Jobs returns the scheduler job that refreshes keys every
+// KEY_REFRESH_INTERVAL, for deployments without a scheduler to call the
+// handler, or no job if KEY_REFRESH_INTERVAL is zero.
+func (h *Handler) Jobs() []*scheduler.Job {
+	if h.config.Interval <= 0 {
+		return nil
+	}
+	return []*scheduler.Job{{
+		Name:     "key-refresh",
+		Interval: h.config.Interval,
+		Run: func(ctx context.Context) error {
+			resp, err := h.refreshAll(ctx)
+			if err != nil {
+				return err
+			}
+			if len(resp.Errors) > 0 {
+				return fmt.Errorf("failed to refresh the keys of %d health authorities", len(resp.Errors))
+			}
+			return nil
+		},
+	}}
+}
+
+// refreshAll refreshes the keys of every health authority with a key set URI.
+// The health authorities that failed are listed in the response's Errors.
+func (h *Handler) refreshAll(ctx context.Context) (*RefreshResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.config.Timeout)
+	defer cancel()
+	logger := logging.FromContext(ctx)
+	metrics := h.env.MetricsExporter(ctx)
+
+	has, err := h.db.ListHealthAuthorities(ctx)
+	if err != nil {
+		logger.Errorf("Failed to list health authorities: %v", err)
+		return nil, fmt.Errorf("listing health authorities: %w", err)
+	}
+
+	resp := &RefreshResponse{Refreshed: []*RefreshedKeys{}}
+	for _, ha := range has {
+		if ha.JWKSURI == "" {
+			continue
+		}
+		rk, err := h.refresh(ctx, ha, time.Now())
+		if err != nil {
+			// The health authority's keys are left as they are, so a key set
+			// that can't be downloaded doesn't end any key.
+			logger.Errorf("Failed to refresh keys of health authority %v: %v", ha.ID, err)
+			metrics.WriteInt("key-refresh-failed", true, 1)
+			resp.Errors = append(resp.Errors, fmt.Sprintf("health authority %v: %v", ha.ID, err))
+			continue
+		}
+		if rk.Added > 0 || rk.Ended > 0 {
+			logger.Infof("Refreshed keys of health authority %v: %d added, %d ended", ha.ID, rk.Added, rk.Ended)
+		}
+		metrics.WriteInt("key-refresh-keys-added", true, rk.Added)
+		metrics.WriteInt("key-refresh-keys-ended", true, rk.Ended)
+		resp.Refreshed = append(resp.Refreshed, rk)
+	}
+	return resp, nil
+}
+
+// refresh downloads the health authority's key set and syncs its keys with it.
+func (h *Handler) refresh(ctx context.Context, ha *database.HealthAuthority, now time.Time) (*RefreshedKeys, error) {
+	keys, err := h.keys.Fetch(ctx, ha.JWKSURI)
+	if err != nil {
+		return nil, fmt.Errorf("fetching key set: %w", err)
+	}
+	keySet := make(map[string]string, len(keys))
+	for kid, key := range keys {
+		if keySet[kid], err = verification.EncodePublicKeyPEM(key); err != nil {
+			return nil, fmt.Errorf("key %q: %w", kid, err)
+		}
+	}
+
+	added, ended, err := h.db.SyncHealthAuthorityKeys(ctx, ha.ID, keySet, now, now.Add(h.config.GracePeriod))
+	if err != nil {
+		return nil, fmt.Errorf("syncing keys: %w", err)
+	}
+	return &RefreshedKeys{HealthAuthorityID: ha.ID, Added: added, Ended: ended}, nil
+}
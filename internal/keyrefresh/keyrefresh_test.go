@@ -0,0 +1,116 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyrefresh
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/verification"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeDB struct {
+	has []*database.HealthAuthority
+
+	// synced is the key set each health authority was synced with.
+	synced   map[string]map[string]string
+	graceEnd time.Time
+}
+
+func (f *fakeDB) ListHealthAuthorities(ctx context.Context) ([]*database.HealthAuthority, error) {
+	return f.has, nil
+}
+
+func (f *fakeDB) SyncHealthAuthorityKeys(ctx context.Context, healthAuthorityID string, keySet map[string]string, now, graceEnd time.Time) (int, int, error) {
+	f.synced[healthAuthorityID] = keySet
+	f.graceEnd = graceEnd
+	return len(keySet), 1, nil
+}
+
+// fakeFetcher serves key sets by URI.
+type fakeFetcher map[string]map[string]crypto.PublicKey
+
+func (f fakeFetcher) Fetch(ctx context.Context, uri string) (map[string]crypto.PublicKey, error) {
+	keys, ok := f[uri]
+	if !ok {
+		return nil, errors.New("unexpected status 404")
+	}
+	return keys, nil
+}
+
+func TestRefresh(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem, err := verification.EncodePublicKeyPEM(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &fakeDB{
+		has: []*database.HealthAuthority{
+			{ID: "manual"},
+			{ID: "ok", JWKSURI: "https://ok.example/jwks"},
+			{ID: "down", JWKSURI: "https://down.example/jwks"},
+		},
+		synced: make(map[string]map[string]string),
+	}
+	h := &Handler{
+		config: &Config{Timeout: time.Minute, GracePeriod: 24 * time.Hour},
+		env:    serverenv.New(context.Background(), serverenv.WithMetricsExporter(metrics.NewLogsBasedFromContext)),
+		db:     db,
+		keys:   fakeFetcher{"https://ok.example/jwks": {"v1": key.Public()}},
+	}
+
+	w := httptest.NewRecorder()
+	start := time.Now()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	var got RefreshResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := RefreshResponse{
+		Refreshed: []*RefreshedKeys{{HealthAuthorityID: "ok", Added: 1, Ended: 1}},
+		Errors:    []string{"health authority down: fetching key set: unexpected status 404"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("response mismatch (-want, +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(map[string]map[string]string{"ok": {"v1": pem}}, db.synced); diff != "" {
+		t.Errorf("synced key sets mismatch (-want, +got):\n%s", diff)
+	}
+	if db.graceEnd.Before(start.Add(24 * time.Hour)) {
+		t.Errorf("got grace end %v, want after %v", db.graceEnd, start.Add(24*time.Hour))
+	}
+}
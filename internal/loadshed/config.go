@@ -0,0 +1,41 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loadshed
+
+import (
+	"time"
+)
+
+// Config configures load shedding. It is disabled unless MaxInFlight or
+// MaxDBWait is set.
+type Config struct {
+	// MaxInFlight is the most requests a server handles at once. Zero means no
+	// limit.
+	MaxInFlight int `envconfig:"LOAD_SHED_MAX_IN_FLIGHT"`
+
+	// MaxDBWait is the longest that acquiring a database connection may take
+	// on average over Window. Zero means no limit.
+	MaxDBWait time.Duration `envconfig:"LOAD_SHED_MAX_DB_WAIT"`
+	Window    time.Duration `envconfig:"LOAD_SHED_WINDOW" default:"10s"`
+
+	// RetryAfter is how long rejected clients are asked to wait before they
+	// try again.
+	RetryAfter time.Duration `envconfig:"LOAD_SHED_RETRY_AFTER" default:"60s"`
+}
+
+// Enabled returns true if a limit is set.
+func (c *Config) Enabled() bool {
+	return c != nil && (c.MaxInFlight > 0 || c.MaxDBWait > 0)
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package loadshed rejects requests while a server is overloaded, before
+// they do any work, so that a surge of requests slows clients down rather
+// than bringing the server and its database down.
+package loadshed
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/ratelimit"
+)
+
+// Reasons that requests are rejected, the labels of metrics.LoadShedRequests.
+const (
+	reasonInFlight = "in-flight"
+	reasonDBWait   = "db-wait"
+)
+
+// PoolStats returns the number of database connections acquired so far and
+// the total time spent acquiring them, as database.DB's AcquireStats does.
+type PoolStats func() (acquires int64, wait time.Duration)
+
+// Shedder tracks the load of a server. A nil Shedder never sheds load.
+type Shedder struct {
+	config *Config
+	stats  PoolStats
+
+	// inFlight counts the requests being handled. It is updated atomically.
+	inFlight int64
+
+	mu sync.Mutex
+	// sampled is when stats was last read, and acquires and wait were its
+	// results.
+	sampled  time.Time
+	acquires int64
+	wait     time.Duration
+	// dbWait is the average wait for a connection between the last two
+	// samples.
+	dbWait time.Duration
+}
+
+// New returns a Shedder that enforces the limits of config, reading the
+// connection pool statistics from stats. It returns nil if config sets no
+// limit.
+func New(config *Config, stats PoolStats) *Shedder {
+	if !config.Enabled() {
+		return nil
+	}
+	return &Shedder{config: config, stats: stats}
+}
+
+// Handler wraps h, rejecting requests with 503 Service Unavailable and a
+// Retry-After header while the server is overloaded.
+func (s *Shedder) Handler(h http.Handler) http.Handler {
+	if s == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&s.inFlight, 1)
+		defer atomic.AddInt64(&s.inFlight, -1)
+
+		if reason := s.overloaded(n, time.Now()); reason != "" {
+			metrics.LoadShedRequests.WithLabelValues(reason).Inc()
+			w.Header().Set("Retry-After", ratelimit.RetryAfter(s.config.RetryAfter))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// overloaded returns why a request should be rejected at now, while
+// inFlight requests, including it, are being handled, or an empty string if
+// it should be handled.
+func (s *Shedder) overloaded(inFlight int64, now time.Time) string {
+	if s.config.MaxInFlight > 0 && inFlight > int64(s.config.MaxInFlight) {
+		return reasonInFlight
+	}
+	if s.config.MaxDBWait > 0 && s.stats != nil && s.averageDBWait(now) > s.config.MaxDBWait {
+		return reasonDBWait
+	}
+	return ""
+}
+
+// averageDBWait returns the average time that acquiring a database
+// connection took over the last window, sampling the pool statistics at
+// most once per window.
+func (s *Shedder) averageDBWait(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.sampled) < s.config.Window {
+		return s.dbWait
+	}
+	acquires, wait := s.stats()
+	s.dbWait = 0
+	if !s.sampled.IsZero() && acquires > s.acquires {
+		s.dbWait = (wait - s.wait) / time.Duration(acquires-s.acquires)
+	}
+	s.sampled, s.acquires, s.wait = now, acquires, wait
+	return s.dbWait
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loadshed
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	if s := New(&Config{Window: time.Second}, nil); s != nil {
+		t.Errorf("without limits: got %v, want nil", s)
+	}
+
+	// A nil Shedder passes every request through.
+	var s *Shedder
+	h := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	w := httptest.NewRecorder()
+	s.Handler(h).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s := New(&Config{MaxInFlight: 1, RetryAfter: 90 * time.Second}, nil)
+
+	var nested *httptest.ResponseRecorder
+	h := s.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// While this request is in flight, another one is rejected.
+		if nested == nil {
+			nested = httptest.NewRecorder()
+			s.Handler(nil).ServeHTTP(nested, r)
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if nested.Code != http.StatusServiceUnavailable {
+		t.Errorf("nested request: got status %d, want %d", nested.Code, http.StatusServiceUnavailable)
+	}
+	if got, want := nested.Header().Get("Retry-After"), "90"; got != want {
+		t.Errorf("nested request: got Retry-After %q, want %q", got, want)
+	}
+}
+
+func TestOverloaded(t *testing.T) {
+	var acquires int64
+	var wait time.Duration
+	stats := func() (int64, time.Duration) { return acquires, wait }
+	s := New(&Config{MaxInFlight: 10, MaxDBWait: 100 * time.Millisecond, Window: 10 * time.Second}, stats)
+
+	start := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		inFlight int64
+		after    time.Duration
+		acquires int64
+		wait     time.Duration
+		want     string
+	}{
+		{name: "first sample", inFlight: 1, acquires: 100, wait: time.Minute},
+		{name: "in flight", inFlight: 11, want: reasonInFlight},
+		{name: "fast acquires", inFlight: 1, after: 10 * time.Second, acquires: 200, wait: time.Minute + time.Second},
+		{name: "slow acquires", inFlight: 1, after: 20 * time.Second, acquires: 300, wait: time.Minute + 21*time.Second, want: reasonDBWait},
+		{name: "within window", inFlight: 1, after: 25 * time.Second, acquires: 300, wait: time.Minute + 21*time.Second, want: reasonDBWait},
+		{name: "no acquires", inFlight: 1, after: 30 * time.Second, acquires: 300, wait: time.Minute + 21*time.Second},
+	}
+	for _, c := range cases {
+		acquires, wait = c.acquires, c.wait
+		if got := s.overloaded(c.inFlight, start.Add(c.after)); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
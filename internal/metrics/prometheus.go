@@ -0,0 +1,240 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the names of all Prometheus metrics of the servers.
+const namespace = "en"
+
+// Registry holds the Prometheus metrics of this process, which Handler
+// serves. It includes the Go runtime and process metrics.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+}
+
+// Handler serves the metrics in Registry in the Prometheus exposition format,
+// to be installed at /metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Compile-time check to verify implements interface.
+var _ Exporter = (*PrometheusExporter)(nil)
+
+// PrometheusExporter is an Exporter that records the metrics written to it in
+// a Prometheus registry. Cumulative values are added to a counter named after
+// the metric with a "_total" suffix, other values set a gauge, and
+// distributions are observed by a histogram. A metric named
+// "publish-exposures-written" is exported as "en_publish_exposures_written".
+//
+// Metrics are created the first time they are written, so only metrics that
+// were written since the process started are served.
+type PrometheusExporter struct {
+	registerer prometheus.Registerer
+
+	mu         sync.Mutex
+	counters   map[string]prometheus.Counter
+	gauges     map[string]prometheus.Gauge
+	histograms map[string]prometheus.Histogram
+}
+
+// NewPrometheusExporter creates an exporter that registers its metrics with
+// registerer.
+func NewPrometheusExporter(registerer prometheus.Registerer) *PrometheusExporter {
+	return &PrometheusExporter{
+		registerer: registerer,
+		counters:   make(map[string]prometheus.Counter),
+		gauges:     make(map[string]prometheus.Gauge),
+		histograms: make(map[string]prometheus.Histogram),
+	}
+}
+
+func (e *PrometheusExporter) WriteBool(name string, value bool) {
+	v := 0.0
+	if value {
+		v = 1
+	}
+	e.write(name, false, v)
+}
+
+func (e *PrometheusExporter) WriteInt(name string, cumulative bool, value int) {
+	e.write(name, cumulative, float64(value))
+}
+
+func (e *PrometheusExporter) WriteInt64(name string, cumulative bool, value int64) {
+	e.write(name, cumulative, float64(value))
+}
+
+func (e *PrometheusExporter) WriteIntDistribution(name string, cumulative bool, values []int) {
+	h := e.histogram(name)
+	for _, v := range values {
+		h.Observe(float64(v))
+	}
+}
+
+func (e *PrometheusExporter) WriteFloat64(name string, cumulative bool, value float64) {
+	e.write(name, cumulative, value)
+}
+
+func (e *PrometheusExporter) WriteFloat64Distribution(name string, cumulative bool, values []float64) {
+	h := e.histogram(name)
+	for _, v := range values {
+		h.Observe(v)
+	}
+}
+
+func (e *PrometheusExporter) write(name string, cumulative bool, value float64) {
+	if !cumulative {
+		e.gauge(name).Set(value)
+		return
+	}
+	// Counters can't decrease.
+	if value < 0 {
+		return
+	}
+	e.counter(name).Add(value)
+}
+
+func (e *PrometheusExporter) counter(name string) prometheus.Counter {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c, ok := e.counters[name]; ok {
+		return c
+	}
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      metricName(name) + "_total",
+		Help:      "Cumulative value of the " + name + " metric.",
+	})
+	c = e.register(c).(prometheus.Counter)
+	e.counters[name] = c
+	return c
+}
+
+func (e *PrometheusExporter) gauge(name string) prometheus.Gauge {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if g, ok := e.gauges[name]; ok {
+		return g
+	}
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      metricName(name),
+		Help:      "Latest value of the " + name + " metric.",
+	})
+	g = e.register(g).(prometheus.Gauge)
+	e.gauges[name] = g
+	return g
+}
+
+func (e *PrometheusExporter) histogram(name string) prometheus.Histogram {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if h, ok := e.histograms[name]; ok {
+		return h
+	}
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      metricName(name) + "_distribution",
+		Help:      "Distribution of the " + name + " metric.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	})
+	h = e.register(h).(prometheus.Histogram)
+	e.histograms[name] = h
+	return h
+}
+
+// register registers c, returning the collector that is already registered
+// under the same name if there is one. If c can't be registered, for example
+// because another metric of a different type has its name, c is returned and
+// its values are not exported.
+func (e *PrometheusExporter) register(c prometheus.Collector) prometheus.Collector {
+	if err := e.registerer.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+	}
+	return c
+}
+
+// metricName converts a metric name like "publish-exposures-written" to a
+// valid Prometheus name.
+func metricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// multiExporter writes metrics to several exporters.
+type multiExporter []Exporter
+
+// NewMultiExporter creates an exporter that writes every metric to each of
+// exporters.
+func NewMultiExporter(exporters ...Exporter) Exporter {
+	return multiExporter(exporters)
+}
+
+func (m multiExporter) WriteBool(name string, value bool) {
+	for _, e := range m {
+		e.WriteBool(name, value)
+	}
+}
+
+func (m multiExporter) WriteInt(name string, cumulative bool, value int) {
+	for _, e := range m {
+		e.WriteInt(name, cumulative, value)
+	}
+}
+
+func (m multiExporter) WriteInt64(name string, cumulative bool, value int64) {
+	for _, e := range m {
+		e.WriteInt64(name, cumulative, value)
+	}
+}
+
+func (m multiExporter) WriteIntDistribution(name string, cumulative bool, values []int) {
+	for _, e := range m {
+		e.WriteIntDistribution(name, cumulative, values)
+	}
+}
+
+func (m multiExporter) WriteFloat64(name string, cumulative bool, value float64) {
+	for _, e := range m {
+		e.WriteFloat64(name, cumulative, value)
+	}
+}
+
+func (m multiExporter) WriteFloat64Distribution(name string, cumulative bool, values []float64) {
+	for _, e := range m {
+		e.WriteFloat64Distribution(name, cumulative, values)
+	}
+}
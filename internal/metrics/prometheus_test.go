@@ -0,0 +1,93 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrometheusExporter(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	e := NewPrometheusExporter(registry)
+
+	e.WriteInt("publish-exposures-written", true, 3)
+	e.WriteInt64("publish-exposures-written", true, 4)
+	e.WriteInt("federation-fetch-count", false, 10)
+	e.WriteInt("federation-fetch-count", false, 7)
+	e.WriteBool("test/bool", true)
+	e.WriteIntDistribution("test-distribution", true, []int{1, 2, 3})
+
+	if got, want := testutil.ToFloat64(e.counters["publish-exposures-written"]), 7.0; got != want {
+		t.Errorf("counter: got %v, want %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(e.gauges["federation-fetch-count"]), 7.0; got != want {
+		t.Errorf("gauge: got %v, want %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(e.gauges["test/bool"]), 1.0; got != want {
+		t.Errorf("bool: got %v, want %v", got, want)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range families {
+		names = append(names, f.GetName())
+	}
+	want := []string{
+		"en_federation_fetch_count",
+		"en_publish_exposures_written_total",
+		"en_test_bool",
+		"en_test_distribution_distribution",
+	}
+	if got := strings.Join(names, ","); got != strings.Join(want, ",") {
+		t.Errorf("registered metrics: got %v, want %v", got, want)
+	}
+}
+
+func TestPrometheusExporterSharedRegistry(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	first := NewPrometheusExporter(registry)
+	second := NewPrometheusExporter(registry)
+
+	first.WriteInt("shared", true, 1)
+	second.WriteInt("shared", true, 2)
+
+	if got, want := testutil.ToFloat64(first.counters["shared"]), 3.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	PublishRequests.WithLabelValues("200", "OK").Inc()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `en_publish_requests_total{code="OK",status="200"}`; !strings.Contains(string(body), want) {
+		t.Errorf("response doesn't contain %s:\n%s", want, body)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus metrics with labels, which the name based Exporter can't express.
+// They are registered with Registry.
+var (
+	// PublishRequests counts publish requests by HTTP status and error code,
+	// which is "OK" for successful requests.
+	PublishRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "publish",
+		Name:      "requests_total",
+		Help:      "Publish requests by HTTP status and error code.",
+	}, []string{"status", "code"})
+
+	// PublishKeysInserted counts the keys that publish requests inserted.
+	PublishKeysInserted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "publish",
+		Name:      "keys_inserted_total",
+		Help:      "Keys inserted by publish requests.",
+	})
+
+	// PublishBufferFlushKeys observes the keys that each flush of the publish
+	// write buffer inserted, by result, which is "success" or "failure".
+	PublishBufferFlushKeys = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "publish",
+		Name:      "buffer_flush_keys",
+		Help:      "Keys per flush of the publish write buffer by result.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+	}, []string{"result"})
+
+	// LoadShedRequests counts the requests rejected because the server was
+	// overloaded, by reason, which is "in-flight" or "db-wait".
+	LoadShedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "publish",
+		Name:      "load_shed_requests_total",
+		Help:      "Publish requests rejected because the server was overloaded, by reason.",
+	}, []string{"reason"})
+
+	// ExportBatchDuration observes the seconds it takes to export a batch, by
+	// result, which is "success" or "failure".
+	ExportBatchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "export",
+		Name:      "batch_duration_seconds",
+		Help:      "Duration of export batches by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"result"})
+
+	// FederationSyncs counts federation syncs by direction, which is "in",
+	// "out", "push" or "efgs", and result, which is "success" or "failure".
+	FederationSyncs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "federation",
+		Name:      "syncs_total",
+		Help:      "Federation syncs by direction and result.",
+	}, []string{"direction", "result"})
+
+	// FederationKeys counts the keys synced by federation, by direction.
+	FederationKeys = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "federation",
+		Name:      "keys_total",
+		Help:      "Keys synced by federation by direction.",
+	}, []string{"direction"})
+
+	// CleanupDeletions counts the records deleted by cleanup, by kind, such as
+	// "exposures" or "exports".
+	CleanupDeletions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cleanup",
+		Name:      "deletions_total",
+		Help:      "Records deleted by cleanup by kind.",
+	}, []string{"kind"})
+
+	// SchedulerRuns counts the runs of scheduled jobs, by job and result,
+	// which is "success", "failure", "lock_lost" if the job lost its lock
+	// while it ran, or "skipped" if another instance held the lock or had
+	// just run the job.
+	SchedulerRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scheduler",
+		Name:      "runs_total",
+		Help:      "Runs of scheduled jobs by job and result.",
+	}, []string{"job", "result"})
+
+	// SchedulerRunDuration observes the seconds scheduled jobs run for, by
+	// job. Skipped runs aren't observed.
+	SchedulerRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "scheduler",
+		Name:      "run_duration_seconds",
+		Help:      "Duration of scheduled job runs by job.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"job"})
+
+	// SchedulerLastSuccess is the Unix time at which each scheduled job last
+	// succeeded on this instance.
+	SchedulerLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "scheduler",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful run of scheduled jobs by job.",
+	}, []string{"job"})
+)
+
+func init() {
+	Registry.MustRegister(
+		PublishRequests,
+		PublishKeysInserted,
+		PublishBufferFlushKeys,
+		LoadShedRequests,
+		ExportBatchDuration,
+		FederationSyncs,
+		FederationKeys,
+		CleanupDeletions,
+		SchedulerRuns,
+		SchedulerRunDuration,
+		SchedulerLastSuccess)
+}
+
+// Result returns the result label of an operation that returned err.
+func Result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
@@ -0,0 +1,3380 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by gen.go; DO NOT EDIT.
+
+package migrate
+
+// files holds the contents of the migrations directory, keyed by file name.
+var files = map[string]string{
+	"000001_initial.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE
+  APIConfig,
+  ExportBatch,
+  ExportConfig,
+  ExportFile,
+  FederationQuery,
+  FederationSync,
+  Infection,
+  Lock
+;
+
+DROP TYPE ExportBatchStatus;
+
+END;
+`,
+	"000001_initial.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+CREATE TABLE FederationQuery (
+	query_id VARCHAR(50) PRIMARY KEY,
+	server_addr VARCHAR(100) NOT NULL,
+	include_regions VARCHAR(5) [],
+	exclude_regions VARCHAR(5) [],
+	last_timestamp TIMESTAMP
+);
+
+CREATE TABLE FederationSync (
+	sync_id SERIAL PRIMARY KEY,
+	query_id VARCHAR(50) NOT NULL REFERENCES FederationQuery (query_id),
+	started TIMESTAMP NOT NULL,
+	completed TIMESTAMP,
+	insertions INT,
+	max_timestamp TIMESTAMP
+);
+
+CREATE TABLE Infection (
+	exposure_key VARCHAR(30) PRIMARY KEY,
+	transmission_risk INT NOT NULL,
+	app_package_name VARCHAR(100),
+	regions VARCHAR(5) [],
+	interval_number INT NOT NULL,
+	interval_count INT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	local_provenance BOOLEAN NOT NULL,
+	verification_authority_name VARCHAR(100),
+	sync_id INT REFERENCES FederationSync (sync_id)
+);
+
+-- ExportConfig stores a list of batches to create on an ongoing basis. The /create-batches endpoint will iterate over this
+-- table and create rows in the ExportBatchJob table.
+CREATE TABLE ExportConfig (
+	config_id SERIAL PRIMARY KEY,
+	filename_root VARCHAR(100) NOT NULL,
+	period_seconds INT NOT NULL,
+	include_regions VARCHAR(5) [],
+	exclude_regions VARCHAR(5) [],
+	from_timestamp TIMESTAMP NOT NULL,
+	thru_timestamp TIMESTAMP
+);
+
+CREATE TYPE ExportBatchStatus AS ENUM ('OPEN', 'PENDING', 'COMPLETE', 'DELETED');
+CREATE TABLE ExportBatch (
+	batch_id SERIAL PRIMARY KEY,
+	config_id INT NOT NULL REFERENCES ExportConfig(config_id),
+	filename_root VARCHAR(100) NOT NULL,
+	start_timestamp TIMESTAMP NOT NULL,
+	end_timestamp TIMESTAMP NOT NULL,
+	include_regions VARCHAR(5) [],
+	exclude_regions VARCHAR(5) [],
+	status ExportBatchStatus NOT NULL DEFAULT 'OPEN',
+	lease_expires TIMESTAMP
+);
+
+CREATE TABLE ExportFile (
+	filename VARCHAR(200) PRIMARY KEY,
+	batch_id INT REFERENCES ExportBatch(batch_id),
+	region VARCHAR(5),
+	batch_num INT,
+	batch_size INT,
+	status VARCHAR(10)
+);
+
+CREATE TABLE Lock (
+	lock_id VARCHAR(100) PRIMARY KEY,
+	expires TIMESTAMP NOT NULL
+);
+
+CREATE TABLE APIConfig (
+	app_package_name VARCHAR(1000) PRIMARY KEY,
+	platform VARCHAR(10) NOT NULL,
+	apk_digest VARCHAR(64),
+	enforce_apk_digest BOOLEAN NOT NULL,
+	cts_profile_match BOOLEAN NOT NULL,
+	basic_integrity BOOLEAN NOT NULL,
+	allowed_past_seconds INT,
+	allowed_future_seconds INT,
+	allowed_regions VARCHAR(5) [] NOT NULL,
+	all_regions bool NOT NULL,
+	bypass_safetynet bool NOT NULL
+);
+
+END;
+`,
+	"000002_infection-exposure.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE exposure RENAME TO infection; 
+
+END;
+`,
+	"000002_infection-exposure.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE infection RENAME TO exposure; 
+
+END;
+`,
+	"000003_locking_procedures.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP FUNCTION AcquireLock;
+DROP FUNCTION ReleaseLock;
+
+END;
+`,
+	"000003_locking_procedures.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+CREATE OR REPLACE FUNCTION AcquireLock(VARCHAR(100), INT) RETURNS TIMESTAMP AS $$
+	DECLARE
+		nowT TIMESTAMP;
+		expiresT TIMESTAMP;
+	BEGIN
+		nowT := CURRENT_TIMESTAMP;
+		expiresT := nowT + '1 SECOND'::interval * $2;
+
+		IF EXISTS (SELECT lock_id FROM Lock WHERE lock_id = $1 AND expires > nowT) THEN
+			RETURN to_timestamp(0); -- Special value indicating no lock acquired.
+		END IF;
+
+		IF EXISTS (SELECT lock_id FROM Lock WHERE lock_id = $1) THEN
+			UPDATE Lock SET expires = expiresT WHERE lock_id = $1;
+			RETURN expiresT;
+		END IF;
+
+		INSERT INTO Lock (lock_id, expires) VALUES ($1, expiresT);
+		RETURN expiresT;
+	END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION ReleaseLock(VARCHAR(100), TIMESTAMP) RETURNS BOOLEAN AS $$
+	BEGIN
+		IF NOT EXISTS (SELECT lock_id FROM Lock WHERE lock_id = $1 AND expires = $2) THEN
+			RETURN FALSE; -- Another process acquired an expired lock
+		END IF;
+
+		DELETE FROM Lock WHERE lock_id = $1;
+		RETURN TRUE;
+	END
+$$ LANGUAGE plpgsql;
+
+END;
+`,
+	"000004_add_time_zone.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+ALTER TABLE FederationQuery
+	ALTER COLUMN last_timestamp type TIMESTAMP;
+
+ALTER TABLE FederationSync
+	ALTER COLUMN started type TIMESTAMP,
+	ALTER COLUMN completed type TIMESTAMP,
+	ALTER COLUMN max_timestamp type TIMESTAMP;
+
+ALTER TABLE Exposure
+	ALTER COLUMN created_at type TIMESTAMP;
+
+ALTER TABLE ExportConfig
+	ALTER COLUMN from_timestamp type TIMESTAMP,
+	ALTER COLUMN thru_timestamp type TIMESTAMP;
+
+ALTER TABLE ExportBatch
+	ALTER COLUMN start_timestamp type TIMESTAMP,
+	ALTER COLUMN end_timestamp type TIMESTAMP,
+	ALTER COLUMN lease_expires type TIMESTAMP;
+
+ALTER TABLE Lock
+	ALTER COLUMN expires type TIMESTAMP;
+`,
+	"000004_add_time_zone.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+-- Change every TIMESTAMP type to TIMESTAMPTZ, preserving data.
+
+-- This migration does not need to be run in a transaction.
+-- Each ALTER TABLE happens atomically, and is idempotent.
+
+ALTER TABLE FederationQuery
+	ALTER COLUMN last_timestamp type TIMESTAMPTZ USING last_timestamp AT TIME ZONE 'UTC';
+
+ALTER TABLE FederationSync
+	ALTER COLUMN started type TIMESTAMPTZ USING started AT TIME ZONE 'UTC',
+	ALTER COLUMN completed type TIMESTAMPTZ USING completed AT TIME ZONE 'UTC',
+	ALTER COLUMN max_timestamp type TIMESTAMPTZ USING max_timestamp AT TIME ZONE 'UTC';
+
+ALTER TABLE Exposure
+	ALTER COLUMN created_at type TIMESTAMPTZ USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE ExportConfig
+	ALTER COLUMN from_timestamp type TIMESTAMPTZ USING from_timestamp AT TIME ZONE 'UTC',
+	ALTER COLUMN thru_timestamp type TIMESTAMPTZ USING thru_timestamp AT TIME ZONE 'UTC';
+
+ALTER TABLE ExportBatch
+	ALTER COLUMN start_timestamp type TIMESTAMPTZ USING start_timestamp AT TIME ZONE 'UTC',
+	ALTER COLUMN end_timestamp type TIMESTAMPTZ USING end_timestamp AT TIME ZONE 'UTC',
+	ALTER COLUMN lease_expires type TIMESTAMPTZ USING lease_expires AT TIME ZONE 'UTC';
+
+ALTER TABLE Lock
+	ALTER COLUMN expires type TIMESTAMPTZ USING expires AT TIME ZONE 'UTC';
+`,
+	"000005_export_file_regions.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE ExportConfig DROP COLUMN region;
+ALTER TABLE ExportBatch DROP COLUMN region;
+
+ALTER TABLE ExportConfig ADD COLUMN include_regions VARCHAR(5) [];
+ALTER TABLE ExportConfig ADD COLUMN exclude_regions VARCHAR(5) [];
+ALTER TABLE ExportBatch ADD COLUMN include_regions VARCHAR(5) [];
+ALTER TABLE ExportBatch ADD COLUMN exclude_regions VARCHAR(5) [];
+
+END;
+`,
+	"000005_export_file_regions.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE ExportConfig DROP COLUMN include_regions;
+ALTER TABLE ExportConfig DROP COLUMN exclude_regions;
+ALTER TABLE ExportBatch DROP COLUMN include_regions;
+ALTER TABLE ExportBatch DROP COLUMN exclude_regions;
+
+ALTER TABLE ExportConfig ADD COLUMN region VARCHAR(5);
+ALTER TABLE ExportBatch ADD COLUMN region VARCHAR(5);
+
+END;
+`,
+	"000006_export_signing.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE ExportConfig DROP COLUMN signing_key;
+
+ALTER TABLE ExportBatch DROP COLUMN signing_key;
+
+END;
+`,
+	"000006_export_signing.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Add a field to ExportConfig to indicate which signing key to use.
+
+ALTER TABLE ExportConfig ADD COLUMN signing_key VARCHAR(500);
+
+ALTER TABLE ExportBatch ADD COLUMN signing_key VARCHAR(500);
+
+END;
+`,
+	"000007_drop_bypass_safetynet.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE APIConfig
+  ADD COLUMN bypass_safetynet bool DEFAULT false;
+
+END;
+`,
+	"000007_drop_bypass_safetynet.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Drop bypass_safetynet field from persistence
+
+ALTER TABLE APIConfig
+  DROP COLUMN bypass_safetynet;
+
+END;
+`,
+	"000008_remove_apk_validation_options.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE APIConfig ADD COLUMN enforce_apk_digest VARCHAR(64);
+
+END;
+`,
+	"000008_remove_apk_validation_options.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE APIConfig DROP COLUMN enforce_apk_digest;
+
+END;
+`,
+	"000009_export_bucket_name.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+
+ALTER TABLE ExportConfig DROP COLUMN bucket_name;
+ALTER TABLE ExportBatch DROP COLUMN bucket_name;
+ALTER TABLE ExportFile DROP COLUMN bucket_name;
+
+ALTER TABLE ExportConfig DROP CONSTRAINT filename_root_unique;
+
+END;
+`,
+	"000009_export_bucket_name.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Add a column for bucket_name of export configurations.
+
+ALTER TABLE ExportConfig ADD COLUMN bucket_name VARCHAR(64) NOT NULL;
+ALTER TABLE ExportBatch ADD COLUMN bucket_name VARCHAR(64) NOT NULL;
+ALTER TABLE ExportFile ADD COLUMN bucket_name VARCHAR(64) NOT NULL;
+
+-- Ensure filename_root is unique to avoid collisions if multiple configs
+ALTER TABLE ExportConfig ADD CONSTRAINT filename_root_unique UNIQUE (filename_root);
+
+END;
+`,
+	"000010_federation_client.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE FederationAuthorization;
+
+END;
+`,
+	"000010_federation_client.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+CREATE TABLE FederationAuthorization (
+	oidc_issuer VARCHAR(1000) NOT NULL,
+	oidc_subject VARCHAR(1000) NOT NULL,
+	oidc_audience VARCHAR(1000),
+	note VARCHAR(100),
+	include_regions VARCHAR(5) [],
+	exclude_regions VARCHAR(5) [],
+	CONSTRAINT federation_authorization_pk PRIMARY KEY(oidc_issuer, oidc_subject)
+);
+
+END;
+`,
+	"000011_multiple_apk_hashes.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- When run, will take the first value from the array and make it the only value.
+ALTER TABLE APIConfig
+  ALTER COLUMN apk_digest type VARCHAR(64) USING COALESCE(apk_digest[1],'');
+
+END;
+`,
+	"000011_multiple_apk_hashes.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Turns the apk_digest column from a single value to an array of values.
+ALTER TABLE APIConfig
+  ALTER COLUMN apk_digest type varchar(64)[] USING array[apk_digest];
+
+END;
+`,
+	"000012_federation_audience.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE FederationQuery DROP COLUMN oidc_audience;
+
+END;
+`,
+	"000012_federation_audience.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE FederationQuery ADD COLUMN
+	oidc_audience VARCHAR(1000) NOT NULL DEFAULT 'https://exposure-notifications-server/federation';
+
+END;
+`,
+	"000013_adjust_federation_naming.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE FederationInQuery RENAME TO FederationQuery; 
+ALTER TABLE FederationInSync RENAME TO FederationSync; 
+ALTER TABLE FederationOutAuthorization RENAME TO FederationAuthorization; 
+
+END;
+`,
+	"000013_adjust_federation_naming.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE FederationQuery RENAME TO FederationInQuery; 
+ALTER TABLE FederationSync RENAME TO FederationInSync; 
+ALTER TABLE FederationAuthorization RENAME TO FederationOutAuthorization; 
+
+END;
+`,
+	"000014_ios_per_app_config.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE APIConfig
+  DROP COLUMN ios_devicecheck_team_id_secret,
+  DROP COLUMN ios_devicecheck_key_id_secret,
+  DROP COLUMN ios_devicecheck_private_key_secret;
+
+END;
+`,
+	"000014_ios_per_app_config.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE APIConfig
+  ADD COLUMN ios_devicecheck_team_id_secret VARCHAR(255),
+  ADD COLUMN ios_devicecheck_key_id_secret VARCHAR(255),
+  ADD COLUMN ios_devicecheck_private_key_secret VARCHAR(255);
+
+END;
+`,
+	"000015_cts_integ_default.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE APIConfig ALTER COLUMN cts_profile_match DROP DEFAULT;
+ALTER TABLE APIConfig ALTER COLUMN cts_profile_match SET NOT NULL;
+
+ALTER TABLE APIConfig ALTER COLUMN basic_integrity DROP DEFAULT;
+ALTER TABLE APIConfig ALTER COLUMN basic_integrity SET NOT NULL;
+
+ALTER TABLE APIConfig ALTER COLUMN all_regions DROP DEFAULT;
+ALTER TABLE APIConfig ALTER COLUMN all_regions SET NOT NULL;
+
+END;
+`,
+	"000015_cts_integ_default.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE APIConfig ALTER COLUMN cts_profile_match SET DEFAULT true;
+ALTER TABLE APIConfig ALTER COLUMN cts_profile_match DROP NOT NULL;
+
+ALTER TABLE APIConfig ALTER COLUMN basic_integrity SET DEFAULT true;
+ALTER TABLE APIConfig ALTER COLUMN basic_integrity DROP NOT NULL;
+
+ALTER TABLE APIConfig ALTER COLUMN all_regions SET DEFAULT false;
+ALTER TABLE APIConfig ALTER COLUMN all_regions DROP NOT NULL;
+
+END;
+`,
+	"000016_idx_exposure_created_at.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP INDEX exposure_created_at_idx;
+
+END;
+`,
+	"000016_idx_exposure_created_at.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+CREATE INDEX exposure_created_at_idx ON exposure USING BRIN(created_at);
+
+END;
+`,
+	"000017_vacuum.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE APIConfig SET (autovacuum_enabled = false);
+ALTER TABLE ExportBatch SET (autovacuum_enabled = false);
+ALTER TABLE ExportConfig SET (autovacuum_enabled = false);
+ALTER TABLE ExportFile SET (autovacuum_enabled = false);
+ALTER TABLE Exposure SET (autovacuum_enabled = false);
+ALTER TABLE FederationInQuery SET (autovacuum_enabled = false);
+ALTER TABLE FederationInSync SET (autovacuum_enabled = false);
+ALTER TABLE FederationOutAuthorization SET (autovacuum_enabled = false);
+ALTER TABLE Lock SET (autovacuum_enabled = false);
+
+
+END;
+`,
+	"000017_vacuum.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE APIConfig SET (autovacuum_enabled = true);
+ALTER TABLE ExportBatch SET (autovacuum_enabled = true);
+ALTER TABLE ExportConfig SET (autovacuum_enabled = true);
+ALTER TABLE ExportFile SET (autovacuum_enabled = true);
+ALTER TABLE Exposure SET (autovacuum_enabled = true);
+ALTER TABLE FederationInQuery SET (autovacuum_enabled = true);
+ALTER TABLE FederationInSync SET (autovacuum_enabled = true);
+ALTER TABLE FederationOutAuthorization SET (autovacuum_enabled = true);
+ALTER TABLE Lock SET (autovacuum_enabled = true);
+
+END;
+`,
+	"000018_idx_app_package_name.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP INDEX apiconfig_app_package_name_idx;
+
+END;
+`,
+	"000018_idx_app_package_name.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+CREATE INDEX apiconfig_app_package_name_idx ON APIConfig(app_package_name);
+
+END;
+`,
+	"000019_app_to_exportconfig.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE ExportBatch
+  DROP COLUMN signature_info_ids,
+  ADD COLUMN signing_key VARCHAR(500);
+
+ALTER TABLE ExportConfig
+  DROP COLUMN signature_info_ids,
+  ADD COLUMN signing_key VARCHAR(500);
+
+DROP TABLE SignatureInfo;
+
+END;
+`,
+	"000019_app_to_exportconfig.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- This migration is destructive to any existing data.
+
+CREATE TABLE SignatureInfo (
+  id SERIAL PRIMARY KEY,
+  signing_key VARCHAR(500) NOT NULL,
+  app_package_name VARCHAR(1000) NOT NULL DEFAULT '',
+  bundle_id VARCHAR(1000) NOT NULL DEFAULT '',
+  signing_key_version VARCHAR(100) NOT NULL DEFAULT '',
+  signing_key_id VARCHAR(50) NOT NULL DEFAULT '',
+	thru_timestamp TIMESTAMPTZ
+);
+
+ALTER TABLE SignatureInfo SET (autovacuum_enabled = true);
+
+ALTER TABLE ExportConfig
+  ADD COLUMN signature_info_ids INT [],
+  DROP COLUMN signing_key;
+
+ALTER TABLE ExportBatch
+  ADD COLUMN signature_info_ids INT [],
+  DROP COLUMN signing_key;
+
+END;
+`,
+	"000020_rename_apiconfig_to_authorized_app.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE AuthorizedApp RENAME TO APIConfig;
+ALTER INDEX authorized_app_pkey RENAME TO apiconfig_pkey;
+ALTER INDEX authorized_app_app_package_name_idx RENAME TO apiconfig_app_package_name_idx;
+
+
+END;
+`,
+	"000020_rename_apiconfig_to_authorized_app.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE APIConfig RENAME TO AuthorizedApp;
+ALTER INDEX apiconfig_pkey RENAME TO authorized_app_pkey;
+ALTER INDEX apiconfig_app_package_name_idx RENAME TO authorized_app_app_package_name_idx;
+
+END;
+`,
+	"000021_remove_verificaiton_authority_name.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE Exposure ADD COLUMN verification_authority_name VARCHAR(100) DEFAULT '';
+
+END;
+`,
+	"000021_remove_verificaiton_authority_name.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE Exposure DROP COLUMN verification_authority_name;
+
+END;
+`,
+	"000022_authorized_app_prefixes.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE AuthorizedApp RENAME COLUMN safetynet_apk_digest TO apk_digest;
+ALTER TABLE AuthorizedApp RENAME COLUMN safetynet_cts_profile_match TO cts_profile_match;
+ALTER TABLE AuthorizedApp RENAME COLUMN safetynet_basic_integrity TO basic_integrity;
+ALTER TABLE AuthorizedApp RENAME COLUMN safetynet_past_seconds TO allowed_past_seconds;
+ALTER TABLE AuthorizedApp RENAME COLUMN safetynet_future_seconds TO allowed_future_seconds;
+ALTER TABLE AuthorizedApp RENAME COLUMN devicecheck_team_id_secret TO ios_devicecheck_team_id_secret;
+ALTER TABLE AuthorizedApp RENAME COLUMN devicecheck_key_id_secret TO ios_devicecheck_key_id_secret;
+ALTER TABLE AuthorizedApp RENAME COLUMN devicecheck_private_key_secret TO ios_devicecheck_private_key_secret;
+
+-- Update the semantic meaning of all_regions
+ALTER TABLE AuthorizedApp ADD COLUMN all_regions bool DEFAULT false;
+UPDATE AuthorizedApp SET all_regions = true
+WHERE allowed_regions = '{}';
+
+CREATE INDEX authorized_app_app_package_name_idx ON AuthorizedApp(app_package_name);
+
+END;
+`,
+	"000022_authorized_app_prefixes.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Update the semantic meaning of all_regions
+UPDATE AuthorizedApp SET allowed_regions = ARRAY[]::VARCHAR[]
+WHERE all_regions = true;
+
+ALTER TABLE AuthorizedApp RENAME COLUMN apk_digest TO safetynet_apk_digest;
+ALTER TABLE AuthorizedApp RENAME COLUMN cts_profile_match TO safetynet_cts_profile_match;
+ALTER TABLE AuthorizedApp RENAME COLUMN basic_integrity TO safetynet_basic_integrity;
+ALTER TABLE AuthorizedApp RENAME COLUMN allowed_past_seconds TO safetynet_past_seconds;
+ALTER TABLE AuthorizedApp RENAME COLUMN allowed_future_seconds TO safetynet_future_seconds;
+ALTER TABLE AuthorizedApp RENAME COLUMN ios_devicecheck_team_id_secret TO devicecheck_team_id_secret;
+ALTER TABLE AuthorizedApp RENAME COLUMN ios_devicecheck_key_id_secret TO devicecheck_key_id_secret;
+ALTER TABLE AuthorizedApp RENAME COLUMN ios_devicecheck_private_key_secret TO devicecheck_private_key_secret;
+ALTER TABLE AuthorizedApp DROP COLUMN all_regions;
+DROP INDEX authorized_app_app_package_name_idx;
+
+END;
+`,
+	"000023_dc_less_secrets.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE AuthorizedApp RENAME COLUMN devicecheck_team_id TO devicecheck_team_id_secret;
+ALTER TABLE AuthorizedApp RENAME COLUMN devicecheck_key_id TO devicecheck_key_id_secret;
+
+END;
+`,
+	"000023_dc_less_secrets.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE AuthorizedApp RENAME COLUMN devicecheck_team_id_secret TO devicecheck_team_id;
+ALTER TABLE AuthorizedApp RENAME COLUMN devicecheck_key_id_secret TO devicecheck_key_id;
+
+END;
+`,
+	"000024_configurable_checks.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE AuthorizedApp
+  DROP COLUMN safetynet_disabled,
+  DROP COLUMN devicecheck_disabled;
+
+END;
+`,
+	"000024_configurable_checks.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE AuthorizedApp
+  ADD COLUMN safetynet_disabled bool DEFAULT false NOT NULL,
+  ADD COLUMN devicecheck_disabled bool DEFAULT false NOT NULL;
+
+END;
+`,
+	"000025_exposure_revision.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+
+BEGIN;
+
+ALTER TABLE Exposure
+  DROP COLUMN report_type,
+  DROP COLUMN revision_token,
+  DROP COLUMN revised_at,
+  DROP COLUMN revised_from;
+
+END;
+`,
+	"000025_exposure_revision.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+
+BEGIN;
+
+ALTER TABLE Exposure
+  ADD COLUMN report_type VARCHAR(20) DEFAULT '' NOT NULL,
+  ADD COLUMN revision_token VARCHAR(64),
+  ADD COLUMN revised_at TIMESTAMPTZ,
+  ADD COLUMN revised_from JSONB;
+
+END;
+`,
+	"000026_exposure_days_since_onset.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+
+BEGIN;
+
+ALTER TABLE Exposure
+  DROP COLUMN days_since_onset;
+
+END;
+`,
+	"000026_exposure_days_since_onset.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+
+BEGIN;
+
+ALTER TABLE Exposure
+  ADD COLUMN days_since_onset INT;
+
+END;
+`,
+	"000027_exposure_tombstones.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+
+BEGIN;
+
+DROP INDEX IF EXISTS exposure_deleted_at_idx;
+
+ALTER TABLE Exposure
+  DROP COLUMN deleted_at;
+
+END;
+`,
+	"000027_exposure_tombstones.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+
+BEGIN;
+
+ALTER TABLE Exposure
+  ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE INDEX exposure_deleted_at_idx ON Exposure (deleted_at) WHERE deleted_at IS NOT NULL;
+
+END;
+`,
+	"000028_exposure_outbox.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+
+BEGIN;
+
+DROP TABLE IF EXISTS ExposureOutbox;
+
+END;
+`,
+	"000028_exposure_outbox.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+
+BEGIN;
+
+CREATE TABLE ExposureOutbox (
+  id BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  exposure_count INT NOT NULL,
+  regions VARCHAR(5)[] NOT NULL,
+  first_created_at TIMESTAMPTZ NOT NULL,
+  last_created_at TIMESTAMPTZ NOT NULL,
+  attempts INT NOT NULL DEFAULT 0,
+  lease_expires TIMESTAMPTZ,
+  delivered_at TIMESTAMPTZ
+);
+
+CREATE INDEX exposure_outbox_pending_idx ON ExposureOutbox (id) WHERE delivered_at IS NULL;
+
+END;
+`,
+	"000029_exposure_key_encryption.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE Exposure ALTER COLUMN exposure_key TYPE VARCHAR(30);
+
+DROP TABLE ExposureKeyEncryptionKey;
+
+END;
+`,
+	"000029_exposure_key_encryption.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+CREATE TABLE ExposureKeyEncryptionKey (
+	id SERIAL PRIMARY KEY,
+	kms_key_id VARCHAR(500) NOT NULL,
+	wrapped_key BYTEA NOT NULL,
+	active BOOLEAN NOT NULL DEFAULT false,
+	created_at TIMESTAMPTZ NOT NULL,
+	retired_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX exposure_key_encryption_key_active ON ExposureKeyEncryptionKey (active) WHERE active;
+
+-- Encrypted exposure keys are longer than their base64 encoding.
+ALTER TABLE Exposure ALTER COLUMN exposure_key TYPE VARCHAR(100);
+
+END;
+`,
+	"000030_exposure_change_id.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TRIGGER exposure_change_id ON Exposure;
+DROP FUNCTION SetExposureChangeID;
+DROP SEQUENCE exposure_change_seq;
+ALTER TABLE Exposure DROP COLUMN change_id;
+
+END;
+`,
+	"000030_exposure_change_id.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- change_id orders exposures by when they were last inserted or revised. The
+-- high bits hold the writing transaction's ID and the low 20 bits a sequence
+-- number, so that readers can tell which changes are final.
+ALTER TABLE Exposure ADD COLUMN change_id BIGINT;
+
+-- Existing exposures sort before any new change.
+UPDATE Exposure SET change_id = ordered.n
+FROM (
+	SELECT exposure_key, row_number() OVER (ORDER BY created_at, exposure_key) AS n
+	FROM Exposure
+) AS ordered
+WHERE Exposure.exposure_key = ordered.exposure_key;
+
+ALTER TABLE Exposure ALTER COLUMN change_id SET NOT NULL;
+CREATE INDEX exposure_change_id ON Exposure (change_id);
+
+CREATE SEQUENCE exposure_change_seq;
+
+CREATE OR REPLACE FUNCTION SetExposureChangeID() RETURNS TRIGGER AS $$
+	BEGIN
+		NEW.change_id := (txid_current() << 20) | (nextval('exposure_change_seq') & 1048575);
+		RETURN NEW;
+	END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER exposure_change_id
+	BEFORE INSERT OR UPDATE OF
+		transmission_risk, regions, interval_number, interval_count, report_type, days_since_onset, revised_at
+	ON Exposure
+	FOR EACH ROW EXECUTE PROCEDURE SetExposureChangeID();
+
+END;
+`,
+	"000031_health_authority.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP INDEX IF EXISTS exposure_health_authority_id;
+
+ALTER TABLE ExportBatch DROP COLUMN health_authority_id;
+ALTER TABLE ExportConfig DROP COLUMN health_authority_id;
+ALTER TABLE Exposure DROP COLUMN health_authority_id;
+ALTER TABLE AuthorizedApp DROP COLUMN health_authority_id;
+
+END;
+`,
+	"000031_health_authority.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- An empty health_authority_id means the row isn't scoped to a health
+-- authority.
+ALTER TABLE AuthorizedApp ADD COLUMN health_authority_id VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE Exposure ADD COLUMN health_authority_id VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE ExportConfig ADD COLUMN health_authority_id VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE ExportBatch ADD COLUMN health_authority_id VARCHAR(100) NOT NULL DEFAULT '';
+
+CREATE INDEX exposure_health_authority_id ON Exposure (health_authority_id, created_at);
+
+END;
+`,
+	"000032_authorized_app_certificate.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE AuthorizedApp DROP COLUMN certificate_jwks_uri;
+ALTER TABLE AuthorizedApp DROP COLUMN certificate_audience;
+ALTER TABLE AuthorizedApp DROP COLUMN certificate_issuer;
+
+END;
+`,
+	"000032_authorized_app_certificate.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Apps with a certificate_issuer require publish requests to carry a
+-- verification certificate signed by their health authority.
+ALTER TABLE AuthorizedApp ADD COLUMN certificate_issuer VARCHAR(255);
+ALTER TABLE AuthorizedApp ADD COLUMN certificate_audience VARCHAR(255);
+ALTER TABLE AuthorizedApp ADD COLUMN certificate_jwks_uri VARCHAR(1000);
+
+END;
+`,
+	"000033_revision_token_key.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE IF EXISTS RevisionTokenKey;
+
+END;
+`,
+	"000033_revision_token_key.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Keys that encrypt revision tokens, wrapped by a key in the KMS. Retired
+-- keys are kept so that tokens issued with them can still be read.
+CREATE TABLE RevisionTokenKey (
+	id SERIAL PRIMARY KEY,
+	kms_key_id VARCHAR(500) NOT NULL,
+	wrapped_key BYTEA NOT NULL,
+	active BOOLEAN NOT NULL DEFAULT false,
+	created_at TIMESTAMPTZ NOT NULL,
+	retired_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX revision_token_key_active ON RevisionTokenKey (active) WHERE active;
+
+END;
+`,
+	"000034_authorized_app_rate_limit.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE AuthorizedApp DROP COLUMN rate_limit_interval_seconds;
+ALTER TABLE AuthorizedApp DROP COLUMN rate_limit_tokens;
+
+END;
+`,
+	"000034_authorized_app_rate_limit.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Overrides of the default rate limit for publish requests from an app. The
+-- app may make rate_limit_tokens requests per client in any
+-- rate_limit_interval_seconds.
+ALTER TABLE AuthorizedApp ADD COLUMN rate_limit_tokens INT;
+ALTER TABLE AuthorizedApp ADD COLUMN rate_limit_interval_seconds INT;
+
+END;
+`,
+	"000035_health_authority_embargo.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE IF EXISTS HealthAuthority;
+
+END;
+`,
+	"000035_health_authority_embargo.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Settings of a health authority, shared by all of its apps. A health
+-- authority without a row uses the defaults.
+CREATE TABLE HealthAuthority (
+	health_authority_id VARCHAR(100) PRIMARY KEY,
+	-- If true, apps of the health authority accept keys that are still valid,
+	-- and exports hold them back until they expire.
+	embargo_same_day_keys BOOLEAN NOT NULL DEFAULT false
+);
+
+END;
+`,
+	"000036_authorized_app_max_keys_per_day.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE AuthorizedApp DROP COLUMN max_keys_per_day;
+
+END;
+`,
+	"000036_authorized_app_max_keys_per_day.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Overrides the server's limit on the keys in a publish that start on the
+-- same UTC day.
+ALTER TABLE AuthorizedApp ADD COLUMN max_keys_per_day INT;
+
+END;
+`,
+	"000037_exposure_traveler.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE ExportBatch DROP COLUMN include_travelers;
+ALTER TABLE ExportConfig DROP COLUMN include_travelers;
+ALTER TABLE HealthAuthority DROP COLUMN allow_travelers;
+ALTER TABLE Exposure DROP COLUMN traveler;
+
+END;
+`,
+	"000037_exposure_traveler.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Travelers' keys may be published to the regions they visited, and exports
+-- may include them regardless of the health authority that published them.
+ALTER TABLE Exposure ADD COLUMN traveler BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE HealthAuthority ADD COLUMN allow_travelers BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE ExportConfig ADD COLUMN include_travelers BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE ExportBatch ADD COLUMN include_travelers BOOLEAN NOT NULL DEFAULT false;
+
+END;
+`,
+	"000038_publish_idempotency.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE PublishIdempotency;
+
+END;
+`,
+	"000038_publish_idempotency.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Responses to publish requests that carried an Idempotency-Key header, so
+-- a retried request gets the original response instead of being processed
+-- again.
+CREATE TABLE PublishIdempotency (
+	app_package_name VARCHAR(1000) NOT NULL,
+	idempotency_key VARCHAR(255) NOT NULL,
+	request_hash VARCHAR(64) NOT NULL,
+	response BYTEA NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (app_package_name, idempotency_key)
+);
+
+CREATE INDEX publish_idempotency_created_at ON PublishIdempotency (created_at);
+
+END;
+`,
+	"000039_authorized_app_grpc_api_key.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE AuthorizedApp DROP COLUMN grpc_api_key_sha256;
+
+END;
+`,
+	"000039_authorized_app_grpc_api_key.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Hex encoded SHA-256 hash of the API key the app presents to the gRPC
+-- publish API. Apps without one can only use the HTTP API.
+ALTER TABLE AuthorizedApp ADD COLUMN grpc_api_key_sha256 VARCHAR(64);
+
+END;
+`,
+	"000040_api_key.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE APIKey;
+
+END;
+`,
+	"000040_api_key.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- API keys that health authorities use for server to server requests. Only
+-- a hash of each key is stored. Permissions name the requests a key may make,
+-- and allowed_regions the regions it may publish keys to.
+CREATE TABLE APIKey (
+	key_sha256 VARCHAR(64) PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	health_authority_id VARCHAR(100) NOT NULL,
+	permissions TEXT[] NOT NULL,
+	allowed_regions TEXT[] NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	revoked_at TIMESTAMPTZ
+);
+
+END;
+`,
+	"000041_health_authority_onset_risk.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE HealthAuthority DROP COLUMN onset_transmission_risk;
+
+END;
+`,
+	"000041_health_authority_onset_risk.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Maps days since symptom onset to the transmission risk of keys that are
+-- published without one, as a JSON object such as {"-2": 1, "0": 1}.
+ALTER TABLE HealthAuthority ADD COLUMN onset_transmission_risk JSONB;
+
+END;
+`,
+	"000042_verification_certificate_use.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE VerificationCertificateUse;
+
+END;
+`,
+	"000042_verification_certificate_use.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- The IDs (jti claims) of verification certificates that have been used to
+-- publish, so that a certificate can't be replayed. Rows are kept until the
+-- certificate expires.
+CREATE TABLE VerificationCertificateUse (
+	issuer VARCHAR(255) NOT NULL,
+	jti VARCHAR(255) NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (issuer, jti)
+);
+
+CREATE INDEX verification_certificate_use_expires_at ON VerificationCertificateUse (expires_at);
+
+END;
+`,
+	"000043_export_batch_lease.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE ExportBatchLease;
+
+END;
+`,
+	"000043_export_batch_lease.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- The lease an export worker holds on the batch it is exporting. The token
+-- identifies the worker's claim, so that a worker whose lease expired and was
+-- claimed by another worker can't complete the batch.
+CREATE TABLE ExportBatchLease (
+	batch_id INT PRIMARY KEY REFERENCES ExportBatch(batch_id) ON DELETE CASCADE,
+	lease_token VARCHAR(64) NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+END;
+`,
+	"000044_signature_info_from.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE SignatureInfo DROP COLUMN from_timestamp;
+
+END;
+`,
+	"000044_signature_info_from.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Signature infos become active at from_timestamp and expire at
+-- thru_timestamp, so that an export config can list both the old and the new
+-- signing key while a key is rotated.
+ALTER TABLE SignatureInfo ADD COLUMN from_timestamp TIMESTAMPTZ;
+
+END;
+`,
+	"000045_export_file_size.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE ExportFile DROP COLUMN size_bytes;
+
+END;
+`,
+	"000045_export_file_size.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- The size of each export file, so that cleanup can report how much storage
+-- it frees. Files written before this column was added have no size.
+ALTER TABLE ExportFile ADD COLUMN size_bytes BIGINT;
+
+END;
+`,
+	"000046_export_config_filters.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE ExportBatch DROP COLUMN min_transmission_risk;
+ALTER TABLE ExportBatch DROP COLUMN include_report_types;
+ALTER TABLE ExportConfig DROP COLUMN min_transmission_risk;
+ALTER TABLE ExportConfig DROP COLUMN include_report_types;
+
+END;
+`,
+	"000046_export_config_filters.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE ExportConfig ADD COLUMN include_report_types VARCHAR(20)[];
+ALTER TABLE ExportConfig ADD COLUMN min_transmission_risk INT NOT NULL DEFAULT 0;
+ALTER TABLE ExportBatch ADD COLUMN include_report_types VARCHAR(20)[];
+ALTER TABLE ExportBatch ADD COLUMN min_transmission_risk INT NOT NULL DEFAULT 0;
+
+END;
+`,
+	"000047_export_delta.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE ExportBatch DROP COLUMN delta_of_config_id;
+ALTER TABLE ExportConfig DROP COLUMN delta_of_config_id;
+
+END;
+`,
+	"000047_export_delta.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE ExportConfig ADD COLUMN delta_of_config_id INT REFERENCES ExportConfig(config_id);
+ALTER TABLE ExportBatch ADD COLUMN delta_of_config_id INT;
+
+END;
+`,
+	"000048_export_batch_stats.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE ExportBatchStats;
+
+END;
+`,
+	"000048_export_batch_stats.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+CREATE TABLE ExportBatchStats (
+	batch_id INT PRIMARY KEY REFERENCES ExportBatch(batch_id),
+	config_id INT NOT NULL REFERENCES ExportConfig(config_id),
+	region VARCHAR(5) NOT NULL,
+	start_timestamp TIMESTAMPTZ NOT NULL,
+	end_timestamp TIMESTAMPTZ NOT NULL,
+	keys INT NOT NULL,
+	-- Maps report types to the number of keys with them, as a JSON object such
+	-- as {"confirmed": 10, "": 2}.
+	keys_by_report_type JSONB NOT NULL,
+	dropped_keys INT NOT NULL,
+	padding_keys INT NOT NULL,
+	files INT NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX export_batch_stats_config_start ON ExportBatchStats (config_id, start_timestamp);
+
+END;
+`,
+	"000049_export_file_sha256.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE ExportFile DROP COLUMN sha256;
+
+END;
+`,
+	"000049_export_file_sha256.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Hex encoded SHA-256 of the contents of each export file, so the uploaded
+-- objects can be checked against what the worker wrote. NULL for files
+-- written before checksums were recorded.
+ALTER TABLE ExportFile ADD COLUMN sha256 VARCHAR(64);
+
+END;
+`,
+	"000050_signature_info_rotation.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE SignatureInfo
+	DROP COLUMN auto_rotate,
+	DROP COLUMN created_at,
+	DROP COLUMN rotated_to_id;
+
+END;
+`,
+	"000050_signature_info_rotation.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- auto_rotate marks the signing keys that the export server rotates. When a
+-- key is rotated, rotated_to_id points to the signature info of its new key
+-- version.
+ALTER TABLE SignatureInfo
+	ADD COLUMN auto_rotate BOOLEAN NOT NULL DEFAULT false,
+	ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	ADD COLUMN rotated_to_id INT REFERENCES SignatureInfo(id);
+
+END;
+`,
+	"000051_federation_in_region_map.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE FederationInQuery DROP COLUMN region_map;
+
+END;
+`,
+	"000051_federation_in_region_map.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Maps the region identifiers used by a federation partner to local ones, as a
+-- JSON object such as {"UK": "GB"}.
+ALTER TABLE FederationInQuery ADD COLUMN region_map JSONB;
+
+END;
+`,
+	"000052_federation_push.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE FederationPushTarget;
+
+END;
+`,
+	"000052_federation_push.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Partners that this server pushes local keys to. acked_change_id is the
+-- Exposure change_id high-water mark the partner has acknowledged.
+CREATE TABLE FederationPushTarget (
+	target_id VARCHAR(50) PRIMARY KEY,
+	protocol VARCHAR(10) NOT NULL,
+	endpoint VARCHAR(1000) NOT NULL,
+	oidc_audience VARCHAR(1000),
+	include_regions VARCHAR(5) [],
+	exclude_regions VARCHAR(5) [],
+	acked_change_id BIGINT NOT NULL DEFAULT 0,
+	last_success_at TIMESTAMPTZ,
+	last_failure_at TIMESTAMPTZ,
+	failures INT NOT NULL DEFAULT 0,
+	last_error VARCHAR(1000)
+);
+
+END;
+`,
+	"000053_efgs_download.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE EFGSDownload;
+
+END;
+`,
+	"000053_efgs_download.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Batches downloaded from the EU federation gateway. Each day's batches form
+-- a chain through next_batch_tag, which downloads resume from.
+CREATE TABLE EFGSDownload (
+	batch_tag VARCHAR(100) PRIMARY KEY,
+	batch_date DATE NOT NULL,
+	next_batch_tag VARCHAR(100),
+	sync_id INT REFERENCES FederationInSync (sync_id),
+	keys INT NOT NULL DEFAULT 0,
+	downloaded_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX efgs_download_batch_date ON EFGSDownload (batch_date, downloaded_at);
+
+END;
+`,
+	"000054_exposure_origin.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE Exposure DROP COLUMN origin;
+
+END;
+`,
+	"000054_exposure_origin.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- The ID of the server an exposure was first published to, for exposures that
+-- arrived through federation. NULL for exposures published to this server.
+ALTER TABLE Exposure ADD COLUMN origin VARCHAR(100);
+
+END;
+`,
+	"000055_federation_in_sync_cursor.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE FederationInSync DROP COLUMN next_fetch_token;
+
+END;
+`,
+	"000055_federation_in_sync_cursor.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- The fetch token of the next page of an unfinished sync, so that a pull that
+-- fails midway resumes from it instead of starting over.
+ALTER TABLE FederationInSync ADD COLUMN next_fetch_token TEXT;
+
+END;
+`,
+	"000056_federation_push_keys.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE FederationPushTarget DROP COLUMN keys_pushed;
+
+END;
+`,
+	"000056_federation_push_keys.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- The number of keys each push target has acknowledged, for the federation
+-- admin status.
+ALTER TABLE FederationPushTarget ADD COLUMN keys_pushed BIGINT NOT NULL DEFAULT 0;
+
+END;
+`,
+	"000057_federation_out_quota.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE FederationOutUsage;
+ALTER TABLE FederationOutAuthorization DROP COLUMN keys_per_day;
+ALTER TABLE FederationOutAuthorization DROP COLUMN requests_per_minute;
+
+END;
+`,
+	"000057_federation_out_quota.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Overrides of the server's quotas for a partner pulling from this server.
+-- NULL uses the server default, and a negative value lifts the quota.
+ALTER TABLE FederationOutAuthorization ADD COLUMN requests_per_minute INT;
+ALTER TABLE FederationOutAuthorization ADD COLUMN keys_per_day INT;
+
+-- The fetch requests and keys served to each partner per UTC day, which the
+-- daily key quota is checked against.
+CREATE TABLE FederationOutUsage (
+	oidc_issuer VARCHAR(1000) NOT NULL,
+	oidc_subject VARCHAR(1000) NOT NULL,
+	day DATE NOT NULL,
+	requests INT NOT NULL DEFAULT 0,
+	keys BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (oidc_issuer, oidc_subject, day),
+	FOREIGN KEY (oidc_issuer, oidc_subject) REFERENCES FederationOutAuthorization (oidc_issuer, oidc_subject) ON DELETE CASCADE
+);
+
+END;
+`,
+	"000058_federation_report_filter.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE FederationOutAuthorization DROP COLUMN min_transmission_risk;
+ALTER TABLE FederationOutAuthorization DROP COLUMN report_types;
+ALTER TABLE FederationInQuery DROP COLUMN min_transmission_risk;
+ALTER TABLE FederationInQuery DROP COLUMN report_types;
+
+END;
+`,
+	"000058_federation_report_filter.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Filters on the keys exchanged with a partner. Only keys with one of the
+-- report types are exchanged, unless report_types is empty, and keys below
+-- min_transmission_risk never are.
+ALTER TABLE FederationInQuery ADD COLUMN report_types VARCHAR(20) [];
+ALTER TABLE FederationInQuery ADD COLUMN min_transmission_risk INT;
+ALTER TABLE FederationOutAuthorization ADD COLUMN report_types VARCHAR(20) [];
+ALTER TABLE FederationOutAuthorization ADD COLUMN min_transmission_risk INT;
+
+END;
+`,
+	"000059_health_authority_keys.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE HealthAuthorityKey;
+
+ALTER TABLE HealthAuthority
+	DROP COLUMN jwks_uri,
+	DROP COLUMN jwks_refreshed_at;
+
+END;
+`,
+	"000059_health_authority_keys.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- If jwks_uri is set, the health authority's keys are refreshed from the JSON
+-- Web Key Set published there. Otherwise they are added by hand.
+ALTER TABLE HealthAuthority
+	ADD COLUMN jwks_uri VARCHAR(1000) NOT NULL DEFAULT '',
+	ADD COLUMN jwks_refreshed_at TIMESTAMPTZ;
+
+-- The public keys that verification certificates of a health authority are
+-- signed with. version is the key ID in the certificates' kid header. A key is
+-- valid from from_timestamp until thru_timestamp, if set.
+CREATE TABLE HealthAuthorityKey (
+	health_authority_id VARCHAR(100) NOT NULL REFERENCES HealthAuthority (health_authority_id) ON DELETE CASCADE,
+	version VARCHAR(100) NOT NULL,
+	from_timestamp TIMESTAMPTZ NOT NULL,
+	thru_timestamp TIMESTAMPTZ,
+	public_key TEXT NOT NULL,
+	PRIMARY KEY (health_authority_id, version)
+);
+
+END;
+`,
+	"000060_runtime_config.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TRIGGER signature_info_version ON SignatureInfo;
+DROP TRIGGER export_config_version ON ExportConfig;
+DROP TRIGGER health_authority_key_version ON HealthAuthorityKey;
+DROP TRIGGER health_authority_version ON HealthAuthority;
+DROP TRIGGER authorized_app_version ON AuthorizedApp;
+DROP TRIGGER config_setting_version ON ConfigSetting;
+DROP FUNCTION BumpConfigVersion;
+DROP TABLE ConfigVersion;
+DROP TABLE ConfigSetting;
+
+END;
+`,
+	"000060_runtime_config.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Settings that servers reload while they run. name is the setting's
+-- environment variable, and value is parsed like the variable's value.
+CREATE TABLE ConfigSetting (
+	name VARCHAR(100) PRIMARY KEY,
+	value TEXT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Counts the changes to each table of configuration, so servers can cheaply
+-- poll for them. table_name is the lower case name of the table.
+CREATE TABLE ConfigVersion (
+	table_name VARCHAR(100) PRIMARY KEY,
+	version BIGINT NOT NULL,
+	changed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION BumpConfigVersion() RETURNS TRIGGER AS $$
+	BEGIN
+		INSERT INTO ConfigVersion (table_name, version, changed_at)
+		VALUES (TG_TABLE_NAME, 1, NOW())
+		ON CONFLICT (table_name) DO UPDATE
+			SET version = ConfigVersion.version + 1, changed_at = NOW();
+		RETURN NULL;
+	END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER config_setting_version
+	AFTER INSERT OR UPDATE OR DELETE ON ConfigSetting
+	FOR EACH STATEMENT EXECUTE PROCEDURE BumpConfigVersion();
+CREATE TRIGGER authorized_app_version
+	AFTER INSERT OR UPDATE OR DELETE ON AuthorizedApp
+	FOR EACH STATEMENT EXECUTE PROCEDURE BumpConfigVersion();
+CREATE TRIGGER health_authority_version
+	AFTER INSERT OR UPDATE OR DELETE ON HealthAuthority
+	FOR EACH STATEMENT EXECUTE PROCEDURE BumpConfigVersion();
+CREATE TRIGGER health_authority_key_version
+	AFTER INSERT OR UPDATE OR DELETE ON HealthAuthorityKey
+	FOR EACH STATEMENT EXECUTE PROCEDURE BumpConfigVersion();
+CREATE TRIGGER export_config_version
+	AFTER INSERT OR UPDATE OR DELETE ON ExportConfig
+	FOR EACH STATEMENT EXECUTE PROCEDURE BumpConfigVersion();
+CREATE TRIGGER signature_info_version
+	AFTER INSERT OR UPDATE OR DELETE ON SignatureInfo
+	FOR EACH STATEMENT EXECUTE PROCEDURE BumpConfigVersion();
+
+END;
+`,
+	"000061_audit_log.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE AuditLog;
+
+END;
+`,
+	"000061_audit_log.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Records who changed configuration or deleted data, and when. actor is the
+-- operator's email, "api-key:NAME" for admin API keys, or "system:SERVICE"
+-- for jobs. details holds the changed values as JSON.
+CREATE TABLE AuditLog (
+	audit_id BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	actor VARCHAR(255) NOT NULL,
+	action VARCHAR(50) NOT NULL,
+	resource VARCHAR(50) NOT NULL,
+	resource_id VARCHAR(255) NOT NULL DEFAULT '',
+	details JSONB
+);
+
+CREATE INDEX audit_log_created_at ON AuditLog (created_at);
+CREATE INDEX audit_log_resource ON AuditLog (resource, resource_id, created_at);
+
+END;
+`,
+	"000062_scheduled_job.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TABLE ScheduledJob;
+
+END;
+`,
+	"000062_scheduled_job.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Records when each scheduled job last started, so that instances running
+-- the scheduler run each job once per interval between them.
+CREATE TABLE ScheduledJob (
+	job_name VARCHAR(100) PRIMARY KEY,
+	last_started TIMESTAMPTZ NOT NULL,
+	last_finished TIMESTAMPTZ,
+	last_result VARCHAR(20)
+);
+
+END;
+`,
+	"000063_exposure_key_hash.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP INDEX exposure_key_hash;
+ALTER TABLE Exposure DROP COLUMN exposure_key_hash;
+DROP TABLE ExposureKeyHashKey;
+
+END;
+`,
+	"000063_exposure_key_hash.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- The key exposure_key_hash is computed with, wrapped by the KMS like the
+-- data keys. Unlike them it is never rotated, so that hashes stay comparable.
+-- There is at most one.
+CREATE TABLE ExposureKeyHashKey (
+	id INT PRIMARY KEY CHECK (id = 1),
+	kms_key_id VARCHAR(500) NOT NULL,
+	wrapped_key BYTEA NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+-- A keyed hash of the plain exposure key, set when exposure keys are
+-- encrypted. The same key encrypted with different data keys has different
+-- exposure_key values, so encrypted exposures are deduplicated by this hash.
+ALTER TABLE Exposure ADD COLUMN exposure_key_hash VARCHAR(64);
+CREATE UNIQUE INDEX exposure_key_hash ON Exposure (exposure_key_hash);
+
+END;
+`,
+	"000064_exposure_key_hash_missing.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP INDEX exposure_key_hash_missing;
+
+END;
+`,
+	"000064_exposure_key_hash_missing.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Exposures written before exposure keys were hashed. Their hash can't be
+-- computed here, because the hash key is wrapped by the KMS, so inserts set
+-- it on the rows they would conflict with until ReencryptExposures has
+-- hashed them all. The index stays empty after that.
+CREATE INDEX exposure_key_hash_missing ON Exposure (exposure_key) WHERE exposure_key_hash IS NULL;
+
+END;
+`,
+	"000065_publish_idempotency_certificate.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DELETE FROM PublishIdempotency WHERE certificate_hash <> '';
+ALTER TABLE PublishIdempotency DROP CONSTRAINT publishidempotency_pkey;
+ALTER TABLE PublishIdempotency DROP COLUMN certificate_hash;
+ALTER TABLE PublishIdempotency ADD PRIMARY KEY (app_package_name, idempotency_key);
+
+END;
+`,
+	"000065_publish_idempotency_certificate.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Idempotency keys are scoped to the verification certificate of the request
+-- as well as its app, so that a stored response is only replayed to a client
+-- that holds the certificate it was published with. Existing records have
+-- no certificate hash and are no longer matched; they expire with
+-- IDEMPOTENCY_KEY_TTL.
+ALTER TABLE PublishIdempotency ADD COLUMN certificate_hash VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE PublishIdempotency DROP CONSTRAINT publishidempotency_pkey;
+ALTER TABLE PublishIdempotency ADD PRIMARY KEY (app_package_name, certificate_hash, idempotency_key);
+
+END;
+`,
+	"000066_export_batch_exposure_horizon.down.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+ALTER TABLE ExportBatch DROP COLUMN exposure_horizon;
+
+END;
+`,
+	"000066_export_batch_exposure_horizon.up.sql": `-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- The change horizon of the read that exported a batch: every exposure
+-- changed by a transaction with an ID below it was visible to the read. The
+-- config's next batch only looks back at exposures changed since.
+ALTER TABLE ExportBatch ADD COLUMN exposure_horizon BIGINT;
+
+END;
+`,
+}
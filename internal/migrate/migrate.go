@@ -0,0 +1,221 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package migrate applies the database schema migrations, which are embedded
+// in the binary so that servers and tools don't need the migrations directory
+// at runtime. Applied versions are tracked in the schema_migrations table,
+// compatible with the golang-migrate command line tool.
+package migrate
+
+//go:generate go run gen.go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"go.uber.org/zap"
+
+	// imported to register the postgres migration driver
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+)
+
+// Migration identifies a single schema migration.
+type Migration struct {
+	Version    uint
+	Identifier string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%06d_%s", m.Version, m.Identifier)
+}
+
+// Migrator applies embedded migrations to a database.
+type Migrator struct {
+	m      *migrate.Migrate
+	source *embeddedSource
+}
+
+// New creates a Migrator for the database described by config. The caller
+// must call Close when done.
+func New(ctx context.Context, config *database.Config) (*Migrator, error) {
+	src, err := newEmbeddedSource(files)
+	if err != nil {
+		return nil, fmt.Errorf("loading embedded migrations: %w", err)
+	}
+	m, err := migrate.NewWithSourceInstance("embedded", src, database.DbURI(config))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to database: %w", err)
+	}
+	m.Log = &logger{logging.FromContext(ctx)}
+	return &Migrator{m: m, source: src}, nil
+}
+
+// Close releases the database connection.
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+	if srcErr != nil {
+		return srcErr
+	}
+	return dbErr
+}
+
+// Version returns the currently applied version, which is zero if no
+// migrations have been applied. dirty is true if a migration failed part way
+// and the schema must be repaired by hand.
+func (m *Migrator) Version() (version uint, dirty bool, err error) {
+	version, dirty, err = m.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// Up applies all pending migrations and returns them in the order they were
+// applied. If dryRun is true, the pending migrations are returned without
+// being applied.
+func (m *Migrator) Up(dryRun bool) ([]Migration, error) {
+	current, err := m.cleanVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	var plan []Migration
+	for _, v := range m.source.versions() {
+		if v > current {
+			plan = append(plan, m.migration(v))
+		}
+	}
+	if dryRun || len(plan) == 0 {
+		return plan, nil
+	}
+
+	if err := m.m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return nil, fmt.Errorf("applying migrations: %w", err)
+	}
+	return plan, nil
+}
+
+// Down reverts up to steps applied migrations, newest first, and returns them
+// in the order they were reverted. If dryRun is true, the migrations are
+// returned without being reverted.
+func (m *Migrator) Down(steps int, dryRun bool) ([]Migration, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("steps must be positive, got %d", steps)
+	}
+	current, err := m.cleanVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := m.source.versions()
+	var plan []Migration
+	for i := len(versions) - 1; i >= 0 && len(plan) < steps; i-- {
+		if versions[i] <= current {
+			plan = append(plan, m.migration(versions[i]))
+		}
+	}
+	if dryRun || len(plan) == 0 {
+		return plan, nil
+	}
+
+	if err := m.m.Steps(-len(plan)); err != nil {
+		return nil, fmt.Errorf("reverting migrations: %w", err)
+	}
+	return plan, nil
+}
+
+// cleanVersion returns the current version, or an error if the database is
+// dirty.
+func (m *Migrator) cleanVersion() (uint, error) {
+	current, dirty, err := m.Version()
+	if err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	if dirty {
+		return 0, fmt.Errorf("schema version %d is dirty; repair the schema and force the version before migrating", current)
+	}
+	return current, nil
+}
+
+func (m *Migrator) migration(version uint) Migration {
+	return Migration{Version: version, Identifier: m.source.identifier(version)}
+}
+
+// LatestVersion returns the version of the newest migration embedded in the
+// binary, which is the schema version the binary expects.
+func LatestVersion() (uint, error) {
+	src, err := newEmbeddedSource(files)
+	if err != nil {
+		return 0, fmt.Errorf("loading embedded migrations: %w", err)
+	}
+	versions := src.versions()
+	if len(versions) == 0 {
+		return 0, nil
+	}
+	return versions[len(versions)-1], nil
+}
+
+// Run applies all pending migrations to the database described by config,
+// and to each of its residency databases.
+func Run(ctx context.Context, config *database.Config) error {
+	if err := run(ctx, config); err != nil {
+		return err
+	}
+	for host, rc := range config.ResidencyConfigs() {
+		if err := run(ctx, rc); err != nil {
+			return fmt.Errorf("residency database %v: %w", host, err)
+		}
+	}
+	return nil
+}
+
+func run(ctx context.Context, config *database.Config) error {
+	logger := logging.FromContext(ctx)
+
+	m, err := New(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := m.Close(); err != nil {
+			logger.Errorf("closing migrator: %v", err)
+		}
+	}()
+
+	applied, err := m.Up(false)
+	if err != nil {
+		return err
+	}
+	for _, a := range applied {
+		logger.Infof("Applied migration %v", a)
+	}
+	return nil
+}
+
+// logger adapts the application logger to migrate.Logger.
+type logger struct {
+	*zap.SugaredLogger
+}
+
+func (l *logger) Printf(format string, v ...interface{}) {
+	l.Debugf(format, v...)
+}
+
+func (l *logger) Verbose() bool {
+	return false
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEmbeddedFilesUpToDate(t *testing.T) {
+	paths, err := filepath.Glob("../../migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := make(map[string]string, len(paths))
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[filepath.Base(path)] = string(b)
+	}
+
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Errorf("embedded migrations are stale, run go generate (-want, +got):\n%s", diff)
+	}
+}
+
+func TestLatestVersion(t *testing.T) {
+	paths, err := filepath.Glob("../../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no migrations found")
+	}
+	// Migration file names start with their zero padded version, so the last
+	// one is the newest.
+	var want uint
+	if _, err := fmt.Sscanf(filepath.Base(paths[len(paths)-1]), "%d_", &want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LatestVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("LatestVersion() = %d, want %d", got, want)
+	}
+}
+
+func TestEmbeddedSource(t *testing.T) {
+	src, err := newEmbeddedSource(map[string]string{
+		"000001_first.up.sql":    "CREATE TABLE a();",
+		"000001_first.down.sql":  "DROP TABLE a;",
+		"000002_second.up.sql":   "CREATE TABLE b();",
+		"000002_second.down.sql": "DROP TABLE b;",
+		"000010_third.up.sql":    "CREATE TABLE c();",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]uint{1, 2, 10}, src.versions()); diff != "" {
+		t.Errorf("versions mismatch (-want, +got):\n%s", diff)
+	}
+
+	if v, err := src.Next(2); err != nil || v != 10 {
+		t.Errorf("Next(2) = %v, %v, want 10, nil", v, err)
+	}
+	if _, err := src.Next(10); err == nil {
+		t.Errorf("Next(10) expected error")
+	}
+	if v, err := src.Prev(2); err != nil || v != 1 {
+		t.Errorf("Prev(2) = %v, %v, want 1, nil", v, err)
+	}
+
+	r, id, err := src.ReadUp(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "second" || string(b) != "CREATE TABLE b();" {
+		t.Errorf("ReadUp(2) = %q, %q", id, b)
+	}
+
+	if _, _, err := src.ReadDown(10); err == nil {
+		t.Errorf("ReadDown(10) expected error")
+	}
+}
+
+func TestEmbeddedSourceInvalidName(t *testing.T) {
+	if _, err := newEmbeddedSource(map[string]string{"README.md": ""}); err == nil {
+		t.Errorf("expected error for invalid migration file name")
+	}
+}
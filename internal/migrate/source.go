@@ -0,0 +1,119 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// embeddedSource is a migrate source driver that reads the migrations
+// compiled into the binary instead of from disk.
+type embeddedSource struct {
+	migrations *source.Migrations
+}
+
+var _ source.Driver = (*embeddedSource)(nil)
+
+// newEmbeddedSource parses files, a map of file name to contents, into a
+// source driver.
+func newEmbeddedSource(files map[string]string) (*embeddedSource, error) {
+	migrations := source.NewMigrations()
+	for name, raw := range files {
+		m, err := source.DefaultParse(name)
+		if err != nil {
+			return nil, fmt.Errorf("parsing migration file name %q: %w", name, err)
+		}
+		m.Raw = raw
+		if !migrations.Append(m) {
+			return nil, fmt.Errorf("duplicate migration file %q", name)
+		}
+	}
+	return &embeddedSource{migrations: migrations}, nil
+}
+
+// Open is unused; the source is always constructed with newEmbeddedSource.
+func (s *embeddedSource) Open(url string) (source.Driver, error) {
+	return nil, fmt.Errorf("embedded source cannot be opened by URL")
+}
+
+func (s *embeddedSource) Close() error {
+	return nil
+}
+
+func (s *embeddedSource) First() (uint, error) {
+	v, ok := s.migrations.First()
+	if !ok {
+		return 0, &os.PathError{Op: "first", Path: "embedded", Err: os.ErrNotExist}
+	}
+	return v, nil
+}
+
+func (s *embeddedSource) Prev(version uint) (uint, error) {
+	v, ok := s.migrations.Prev(version)
+	if !ok {
+		return 0, &os.PathError{Op: fmt.Sprintf("prev for version %v", version), Path: "embedded", Err: os.ErrNotExist}
+	}
+	return v, nil
+}
+
+func (s *embeddedSource) Next(version uint) (uint, error) {
+	v, ok := s.migrations.Next(version)
+	if !ok {
+		return 0, &os.PathError{Op: fmt.Sprintf("next for version %v", version), Path: "embedded", Err: os.ErrNotExist}
+	}
+	return v, nil
+}
+
+func (s *embeddedSource) ReadUp(version uint) (io.ReadCloser, string, error) {
+	if m, ok := s.migrations.Up(version); ok {
+		return ioutil.NopCloser(strings.NewReader(m.Raw)), m.Identifier, nil
+	}
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read up for version %v", version), Path: "embedded", Err: os.ErrNotExist}
+}
+
+func (s *embeddedSource) ReadDown(version uint) (io.ReadCloser, string, error) {
+	if m, ok := s.migrations.Down(version); ok {
+		return ioutil.NopCloser(strings.NewReader(m.Raw)), m.Identifier, nil
+	}
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read down for version %v", version), Path: "embedded", Err: os.ErrNotExist}
+}
+
+// versions returns every migration version in ascending order.
+func (s *embeddedSource) versions() []uint {
+	var versions []uint
+	v, ok := s.migrations.First()
+	for ok {
+		versions = append(versions, v)
+		v, ok = s.migrations.Next(v)
+	}
+	return versions
+}
+
+// identifier returns the name of the migration with the given version.
+func (s *embeddedSource) identifier(version uint) string {
+	if m, ok := s.migrations.Up(version); ok {
+		return m.Identifier
+	}
+	if m, ok := s.migrations.Down(version); ok {
+		return m.Identifier
+	}
+	return ""
+}
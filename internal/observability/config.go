@@ -0,0 +1,67 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"fmt"
+
+	kenvconfig "github.com/kelseyhightower/envconfig"
+)
+
+// ExporterType defines a specific trace exporter.
+type ExporterType string
+
+const (
+	ExporterTypeJaeger      ExporterType = "JAEGER"
+	ExporterTypeNoop        ExporterType = "NOOP"
+	ExporterTypeOCAgent     ExporterType = "OCAGENT"
+	ExporterTypeStackdriver ExporterType = "STACKDRIVER"
+)
+
+// Config defines the configuration of tracing.
+type Config struct {
+	ExporterType ExporterType `envconfig:"TRACE_EXPORTER" default:"NOOP"`
+
+	// SampleRate is the fraction of traces that are started by this server
+	// and recorded. Traces continued from a caller follow the caller's
+	// sampling decision.
+	SampleRate float64 `envconfig:"TRACE_SAMPLE_RATE" default:"0.01"`
+
+	// ServiceName names this server in the traces. It defaults to the name of
+	// the binary.
+	ServiceName string `envconfig:"TRACE_SERVICE_NAME"`
+
+	// StackdriverProjectID is the Google Cloud project that STACKDRIVER
+	// exports to. It defaults to the project of the credentials.
+	StackdriverProjectID string `envconfig:"TRACE_STACKDRIVER_PROJECT_ID"`
+
+	// JaegerEndpoint is the HTTP endpoint of the Jaeger collector that
+	// JAEGER exports to.
+	JaegerEndpoint string `envconfig:"TRACE_JAEGER_ENDPOINT" default:"http://localhost:14268/api/traces"`
+
+	// OCAgentAddress is the address of the OpenCensus agent, or the
+	// OpenTelemetry Collector with an opencensus receiver, that OCAGENT
+	// exports to.
+	OCAgentAddress string `envconfig:"TRACE_OCAGENT_ADDRESS" default:"localhost:55678"`
+}
+
+// ConfigFromEnv reads the tracing configuration from the environment.
+func ConfigFromEnv() (*Config, error) {
+	var config Config
+	if err := kenvconfig.Process("", &config); err != nil {
+		return nil, fmt.Errorf("failed to process tracing config: %w", err)
+	}
+	return &config, nil
+}
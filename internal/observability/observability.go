@@ -0,0 +1,159 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package observability traces requests across the servers with OpenCensus.
+//
+// Incoming HTTP and gRPC requests start or continue a trace, using the W3C
+// Trace Context headers for HTTP, and outgoing requests propagate it. The
+// servers add spans for the steps of their work, such as the verification and
+// insert of a publish, and the database and blobstore add a span for each
+// operation. Spans are exported to the backend selected by Config.
+package observability
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+
+	"contrib.go.opencensus.io/exporter/jaeger"
+	"contrib.go.opencensus.io/exporter/ocagent"
+	"contrib.go.opencensus.io/exporter/stackdriver"
+	"go.opencensus.io/plugin/ocgrpc"
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/plugin/ochttp/propagation/tracecontext"
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc"
+)
+
+// exporter is a trace exporter that buffers spans.
+type exporter interface {
+	trace.Exporter
+	Flush()
+}
+
+// Start starts exporting the spans of this process as selected by config, and
+// returns a function that flushes and stops the exporter.
+func Start(ctx context.Context, config *Config) (func(), error) {
+	logger := logging.FromContext(ctx)
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = filepath.Base(os.Args[0])
+	}
+	onError := func(err error) {
+		logger.Errorf("exporting spans: %v", err)
+	}
+
+	var exp exporter
+	stop := func() {}
+	switch config.ExporterType {
+	case ExporterTypeNoop:
+		return func() {}, nil
+	case ExporterTypeJaeger:
+		e, err := jaeger.NewExporter(jaeger.Options{
+			CollectorEndpoint: config.JaegerEndpoint,
+			Process:           jaeger.Process{ServiceName: serviceName},
+			OnError:           onError,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating jaeger exporter: %w", err)
+		}
+		exp = e
+	case ExporterTypeOCAgent:
+		e, err := ocagent.NewExporter(
+			ocagent.WithInsecure(),
+			ocagent.WithAddress(config.OCAgentAddress),
+			ocagent.WithServiceName(serviceName))
+		if err != nil {
+			return nil, fmt.Errorf("creating ocagent exporter: %w", err)
+		}
+		exp = e
+		stop = func() {
+			if err := e.Stop(); err != nil {
+				logger.Errorf("stopping ocagent exporter: %v", err)
+			}
+		}
+	case ExporterTypeStackdriver:
+		e, err := stackdriver.NewExporter(stackdriver.Options{
+			ProjectID: config.StackdriverProjectID,
+			OnError:   onError,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating stackdriver exporter: %w", err)
+		}
+		exp = e
+	default:
+		return nil, fmt.Errorf("unknown trace exporter type: %v", config.ExporterType)
+	}
+
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(config.SampleRate)})
+	trace.RegisterExporter(exp)
+	logger.Infof("Exporting traces of %v to %v", serviceName, config.ExporterType)
+
+	return func() {
+		trace.UnregisterExporter(exp)
+		exp.Flush()
+		stop()
+	}, nil
+}
+
+// HTTPHandler traces the requests served by h.
+func HTTPHandler(h http.Handler) http.Handler {
+	return &ochttp.Handler{
+		Handler:     h,
+		Propagation: &tracecontext.HTTPFormat{},
+	}
+}
+
+// HTTPTransport traces the requests sent through base, and propagates the
+// trace to the server. If base is nil, http.DefaultTransport is used.
+func HTTPTransport(base http.RoundTripper) http.RoundTripper {
+	return &ochttp.Transport{
+		Base:        base,
+		Propagation: &tracecontext.HTTPFormat{},
+	}
+}
+
+// GRPCServerOption traces the RPCs served by a gRPC server.
+func GRPCServerOption() grpc.ServerOption {
+	return grpc.StatsHandler(&ocgrpc.ServerHandler{})
+}
+
+// GRPCDialOption traces the RPCs sent by a gRPC client, and propagates the
+// trace to the server.
+func GRPCDialOption() grpc.DialOption {
+	return grpc.WithStatsHandler(&ocgrpc.ClientHandler{})
+}
+
+// StartSpan starts a span named name as a child of the span in ctx, if any.
+// The caller must end the span, usually with EndSpan.
+func StartSpan(ctx context.Context, name string) (context.Context, *trace.Span) {
+	return trace.StartSpan(ctx, name)
+}
+
+// EndSpan ends span, marking it as failed if *errp is not nil. It is meant to
+// be deferred by functions with a named error result:
+//
+//     ctx, span := observability.StartSpan(ctx, "export.createFile")
+//     defer observability.EndSpan(span, &err)
+func EndSpan(span *trace.Span, errp *error) {
+	if errp != nil && *errp != nil {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: (*errp).Error()})
+	}
+	span.End()
+}
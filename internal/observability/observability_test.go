@@ -0,0 +1,113 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package observability
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opencensus.io/trace"
+)
+
+// recorder is a trace exporter that keeps the spans it is sent.
+type recorder struct {
+	spans []*trace.SpanData
+}
+
+func (r *recorder) ExportSpan(s *trace.SpanData) {
+	r.spans = append(r.spans, s)
+}
+
+func record(t *testing.T) *recorder {
+	t.Helper()
+	r := &recorder{}
+	trace.RegisterExporter(r)
+	t.Cleanup(func() { trace.UnregisterExporter(r) })
+	return r
+}
+
+func TestStart(t *testing.T) {
+	ctx := context.Background()
+
+	stop, err := Start(ctx, &Config{ExporterType: ExporterTypeNoop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+
+	if _, err := Start(ctx, &Config{ExporterType: "UNKNOWN"}); err == nil {
+		t.Errorf("Start with unknown exporter: got nil error")
+	}
+}
+
+func TestEndSpan(t *testing.T) {
+	r := record(t)
+
+	run := func(fail bool) (err error) {
+		_, span := trace.StartSpan(context.Background(), "test", trace.WithSampler(trace.AlwaysSample()))
+		defer EndSpan(span, &err)
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	}
+	run(false)
+	run(true)
+
+	if len(r.spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(r.spans))
+	}
+	if got := r.spans[0].Status.Code; got != trace.StatusCodeOK {
+		t.Errorf("successful span has status %d, want %d", got, trace.StatusCodeOK)
+	}
+	if got := r.spans[1].Status; got.Code != trace.StatusCodeUnknown || got.Message != "failed" {
+		t.Errorf("failed span has status %+v, want unknown with message %q", got, "failed")
+	}
+}
+
+func TestHTTPPropagation(t *testing.T) {
+	r := record(t)
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	t.Cleanup(func() { trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(1e-4)}) })
+
+	var serverTraceID trace.TraceID
+	srv := httptest.NewServer(HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverTraceID = trace.FromContext(r.Context()).SpanContext().TraceID
+	})))
+	defer srv.Close()
+
+	ctx, span := trace.StartSpan(context.Background(), "client")
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: HTTPTransport(nil)}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	span.End()
+
+	if want := span.SpanContext().TraceID; serverTraceID != want {
+		t.Errorf("server trace %v, want client trace %v", serverTraceID, want)
+	}
+	if len(r.spans) == 0 {
+		t.Errorf("no spans were exported")
+	}
+}
@@ -40,6 +40,74 @@ const (
 // of the legacy proto package is being used.
 const _ = proto.ProtoPackageIsVersion4
 
+type TemporaryExposureKey_ReportType int32
+
+const (
+	TemporaryExposureKey_UNKNOWN                      TemporaryExposureKey_ReportType = 0 // Never returned by the client API.
+	TemporaryExposureKey_CONFIRMED_TEST               TemporaryExposureKey_ReportType = 1
+	TemporaryExposureKey_CONFIRMED_CLINICAL_DIAGNOSIS TemporaryExposureKey_ReportType = 2
+	TemporaryExposureKey_SELF_REPORT                  TemporaryExposureKey_ReportType = 3
+	TemporaryExposureKey_RECURSIVE                    TemporaryExposureKey_ReportType = 4 // Reserved for future use.
+	TemporaryExposureKey_REVOKED                      TemporaryExposureKey_ReportType = 5 // Used to revoke a key, never returned by client API.
+)
+
+// Enum value maps for TemporaryExposureKey_ReportType.
+var (
+	TemporaryExposureKey_ReportType_name = map[int32]string{
+		0: "UNKNOWN",
+		1: "CONFIRMED_TEST",
+		2: "CONFIRMED_CLINICAL_DIAGNOSIS",
+		3: "SELF_REPORT",
+		4: "RECURSIVE",
+		5: "REVOKED",
+	}
+	TemporaryExposureKey_ReportType_value = map[string]int32{
+		"UNKNOWN":                      0,
+		"CONFIRMED_TEST":               1,
+		"CONFIRMED_CLINICAL_DIAGNOSIS": 2,
+		"SELF_REPORT":                  3,
+		"RECURSIVE":                    4,
+		"REVOKED":                      5,
+	}
+)
+
+func (x TemporaryExposureKey_ReportType) Enum() *TemporaryExposureKey_ReportType {
+	p := new(TemporaryExposureKey_ReportType)
+	*p = x
+	return p
+}
+
+func (x TemporaryExposureKey_ReportType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (TemporaryExposureKey_ReportType) Descriptor() protoreflect.EnumDescriptor {
+	return file_internal_pb_export_export_proto_enumTypes[0].Descriptor()
+}
+
+func (TemporaryExposureKey_ReportType) Type() protoreflect.EnumType {
+	return &file_internal_pb_export_export_proto_enumTypes[0]
+}
+
+func (x TemporaryExposureKey_ReportType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Do not use.
+func (x *TemporaryExposureKey_ReportType) UnmarshalJSON(b []byte) error {
+	num, err := protoimpl.X.UnmarshalJSONEnum(x.Descriptor(), b)
+	if err != nil {
+		return err
+	}
+	*x = TemporaryExposureKey_ReportType(num)
+	return nil
+}
+
+// Deprecated: Use TemporaryExposureKey_ReportType.Descriptor instead.
+func (TemporaryExposureKey_ReportType) EnumDescriptor() ([]byte, []int) {
+	return file_internal_pb_export_export_proto_rawDescGZIP(), []int{2, 0}
+}
+
 // Protobuf definition for exports of confirmed temporary exposure keys.
 //
 // The full file format is documented under "Exposure Key Export File Format
@@ -248,6 +316,11 @@ type TemporaryExposureKey struct {
 	RollingStartIntervalNumber *int32 `protobuf:"varint,3,opt,name=rolling_start_interval_number,json=rollingStartIntervalNumber" json:"rolling_start_interval_number,omitempty"`
 	// Increments of 10 minutes describing how long a key is valid
 	RollingPeriod *int32 `protobuf:"varint,4,opt,name=rolling_period,json=rollingPeriod,def=144" json:"rolling_period,omitempty"` // defaults to 24 hours
+	// Type of diagnosis associated with a key.
+	ReportType *TemporaryExposureKey_ReportType `protobuf:"varint,5,opt,name=report_type,json=reportType,enum=TemporaryExposureKey_ReportType" json:"report_type,omitempty"`
+	// Number of days elapsed between symptom onset and the TEK being used.
+	// E.g. 2 means TEK is 2 days after onset of symptoms.
+	DaysSinceOnsetOfSymptoms *int32 `protobuf:"zigzag32,6,opt,name=days_since_onset_of_symptoms,json=daysSinceOnsetOfSymptoms" json:"days_since_onset_of_symptoms,omitempty"`
 }
 
 // Default values for TemporaryExposureKey fields.
@@ -315,6 +388,20 @@ func (x *TemporaryExposureKey) GetRollingPeriod() int32 {
 	return Default_TemporaryExposureKey_RollingPeriod
 }
 
+func (x *TemporaryExposureKey) GetReportType() TemporaryExposureKey_ReportType {
+	if x != nil && x.ReportType != nil {
+		return *x.ReportType
+	}
+	return TemporaryExposureKey_UNKNOWN
+}
+
+func (x *TemporaryExposureKey) GetDaysSinceOnsetOfSymptoms() int32 {
+	if x != nil && x.DaysSinceOnsetOfSymptoms != nil {
+		return *x.DaysSinceOnsetOfSymptoms
+	}
+	return 0
+}
+
 type TEKSignatureList struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -475,7 +562,7 @@ var file_internal_pb_export_export_proto_rawDesc = []byte{
 	0x2f, 0x0a, 0x13, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x5f, 0x61, 0x6c, 0x67,
 	0x6f, 0x72, 0x69, 0x74, 0x68, 0x6d, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x12, 0x73, 0x69,
 	0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x41, 0x6c, 0x67, 0x6f, 0x72, 0x69, 0x74, 0x68, 0x6d,
-	0x22, 0xd8, 0x01, 0x0a, 0x14, 0x54, 0x65, 0x6d, 0x70, 0x6f, 0x72, 0x61, 0x72, 0x79, 0x45, 0x78,
+	0x22, 0xd9, 0x03, 0x0a, 0x14, 0x54, 0x65, 0x6d, 0x70, 0x6f, 0x72, 0x61, 0x72, 0x79, 0x45, 0x78,
 	0x70, 0x6f, 0x73, 0x75, 0x72, 0x65, 0x4b, 0x65, 0x79, 0x12, 0x19, 0x0a, 0x08, 0x6b, 0x65, 0x79,
 	0x5f, 0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x6b, 0x65, 0x79,
 	0x44, 0x61, 0x74, 0x61, 0x12, 0x36, 0x0a, 0x17, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69, 0x73,
@@ -488,23 +575,39 @@ var file_internal_pb_export_export_proto_rawDesc = []byte{
 	0x74, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x12,
 	0x2a, 0x0a, 0x0e, 0x72, 0x6f, 0x6c, 0x6c, 0x69, 0x6e, 0x67, 0x5f, 0x70, 0x65, 0x72, 0x69, 0x6f,
 	0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x05, 0x3a, 0x03, 0x31, 0x34, 0x34, 0x52, 0x0d, 0x72, 0x6f,
-	0x6c, 0x6c, 0x69, 0x6e, 0x67, 0x50, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x22, 0x41, 0x0a, 0x10, 0x54,
-	0x45, 0x4b, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x4c, 0x69, 0x73, 0x74, 0x12,
-	0x2d, 0x0a, 0x0a, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x73, 0x18, 0x01, 0x20,
-	0x03, 0x28, 0x0b, 0x32, 0x0d, 0x2e, 0x54, 0x45, 0x4b, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75,
-	0x72, 0x65, 0x52, 0x0a, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x73, 0x22, 0x9f,
-	0x01, 0x0a, 0x0c, 0x54, 0x45, 0x4b, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x12,
-	0x35, 0x0a, 0x0e, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x5f, 0x69, 0x6e, 0x66,
-	0x6f, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x74,
-	0x75, 0x72, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x0d, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75,
-	0x72, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x1b, 0x0a, 0x09, 0x62, 0x61, 0x74, 0x63, 0x68, 0x5f,
-	0x6e, 0x75, 0x6d, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x62, 0x61, 0x74, 0x63, 0x68,
-	0x4e, 0x75, 0x6d, 0x12, 0x1d, 0x0a, 0x0a, 0x62, 0x61, 0x74, 0x63, 0x68, 0x5f, 0x73, 0x69, 0x7a,
-	0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x62, 0x61, 0x74, 0x63, 0x68, 0x53, 0x69,
-	0x7a, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x18,
-	0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65,
-	0x42, 0x1b, 0x5a, 0x19, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x2f, 0x70, 0x62, 0x2f,
-	0x65, 0x78, 0x70, 0x6f, 0x72, 0x74, 0x3b, 0x65, 0x78, 0x70, 0x6f, 0x72, 0x74,
+	0x6c, 0x6c, 0x69, 0x6e, 0x67, 0x50, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x12, 0x41, 0x0a, 0x0b, 0x72,
+	0x65, 0x70, 0x6f, 0x72, 0x74, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0e,
+	0x32, 0x20, 0x2e, 0x54, 0x65, 0x6d, 0x70, 0x6f, 0x72, 0x61, 0x72, 0x79, 0x45, 0x78, 0x70, 0x6f,
+	0x73, 0x75, 0x72, 0x65, 0x4b, 0x65, 0x79, 0x2e, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x54, 0x79,
+	0x70, 0x65, 0x52, 0x0a, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x3e,
+	0x0a, 0x1c, 0x64, 0x61, 0x79, 0x73, 0x5f, 0x73, 0x69, 0x6e, 0x63, 0x65, 0x5f, 0x6f, 0x6e, 0x73,
+	0x65, 0x74, 0x5f, 0x6f, 0x66, 0x5f, 0x73, 0x79, 0x6d, 0x70, 0x74, 0x6f, 0x6d, 0x73, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x11, 0x52, 0x18, 0x64, 0x61, 0x79, 0x73, 0x53, 0x69, 0x6e, 0x63, 0x65, 0x4f,
+	0x6e, 0x73, 0x65, 0x74, 0x4f, 0x66, 0x53, 0x79, 0x6d, 0x70, 0x74, 0x6f, 0x6d, 0x73, 0x22, 0x7c,
+	0x0a, 0x0a, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0b, 0x0a, 0x07,
+	0x55, 0x4e, 0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x10, 0x00, 0x12, 0x12, 0x0a, 0x0e, 0x43, 0x4f, 0x4e,
+	0x46, 0x49, 0x52, 0x4d, 0x45, 0x44, 0x5f, 0x54, 0x45, 0x53, 0x54, 0x10, 0x01, 0x12, 0x20, 0x0a,
+	0x1c, 0x43, 0x4f, 0x4e, 0x46, 0x49, 0x52, 0x4d, 0x45, 0x44, 0x5f, 0x43, 0x4c, 0x49, 0x4e, 0x49,
+	0x43, 0x41, 0x4c, 0x5f, 0x44, 0x49, 0x41, 0x47, 0x4e, 0x4f, 0x53, 0x49, 0x53, 0x10, 0x02, 0x12,
+	0x0f, 0x0a, 0x0b, 0x53, 0x45, 0x4c, 0x46, 0x5f, 0x52, 0x45, 0x50, 0x4f, 0x52, 0x54, 0x10, 0x03,
+	0x12, 0x0d, 0x0a, 0x09, 0x52, 0x45, 0x43, 0x55, 0x52, 0x53, 0x49, 0x56, 0x45, 0x10, 0x04, 0x12,
+	0x0b, 0x0a, 0x07, 0x52, 0x45, 0x56, 0x4f, 0x4b, 0x45, 0x44, 0x10, 0x05, 0x22, 0x41, 0x0a, 0x10,
+	0x54, 0x45, 0x4b, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x4c, 0x69, 0x73, 0x74,
+	0x12, 0x2d, 0x0a, 0x0a, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x73, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x0d, 0x2e, 0x54, 0x45, 0x4b, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x74,
+	0x75, 0x72, 0x65, 0x52, 0x0a, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x73, 0x22,
+	0x9f, 0x01, 0x0a, 0x0c, 0x54, 0x45, 0x4b, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65,
+	0x12, 0x35, 0x0a, 0x0e, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x5f, 0x69, 0x6e,
+	0x66, 0x6f, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x53, 0x69, 0x67, 0x6e, 0x61,
+	0x74, 0x75, 0x72, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x0d, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74,
+	0x75, 0x72, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x1b, 0x0a, 0x09, 0x62, 0x61, 0x74, 0x63, 0x68,
+	0x5f, 0x6e, 0x75, 0x6d, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x62, 0x61, 0x74, 0x63,
+	0x68, 0x4e, 0x75, 0x6d, 0x12, 0x1d, 0x0a, 0x0a, 0x62, 0x61, 0x74, 0x63, 0x68, 0x5f, 0x73, 0x69,
+	0x7a, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x62, 0x61, 0x74, 0x63, 0x68, 0x53,
+	0x69, 0x7a, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x73, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72,
+	0x65, 0x42, 0x1b, 0x5a, 0x19, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x2f, 0x70, 0x62,
+	0x2f, 0x65, 0x78, 0x70, 0x6f, 0x72, 0x74, 0x3b, 0x65, 0x78, 0x70, 0x6f, 0x72, 0x74,
 }
 
 var (
@@ -519,24 +622,27 @@ func file_internal_pb_export_export_proto_rawDescGZIP() []byte {
 	return file_internal_pb_export_export_proto_rawDescData
 }
 
+var file_internal_pb_export_export_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
 var file_internal_pb_export_export_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_internal_pb_export_export_proto_goTypes = []interface{}{
-	(*TemporaryExposureKeyExport)(nil), // 0: TemporaryExposureKeyExport
-	(*SignatureInfo)(nil),              // 1: SignatureInfo
-	(*TemporaryExposureKey)(nil),       // 2: TemporaryExposureKey
-	(*TEKSignatureList)(nil),           // 3: TEKSignatureList
-	(*TEKSignature)(nil),               // 4: TEKSignature
+	(TemporaryExposureKey_ReportType)(0), // 0: TemporaryExposureKey.ReportType
+	(*TemporaryExposureKeyExport)(nil),   // 1: TemporaryExposureKeyExport
+	(*SignatureInfo)(nil),                // 2: SignatureInfo
+	(*TemporaryExposureKey)(nil),         // 3: TemporaryExposureKey
+	(*TEKSignatureList)(nil),             // 4: TEKSignatureList
+	(*TEKSignature)(nil),                 // 5: TEKSignature
 }
 var file_internal_pb_export_export_proto_depIdxs = []int32{
-	1, // 0: TemporaryExposureKeyExport.signature_infos:type_name -> SignatureInfo
-	2, // 1: TemporaryExposureKeyExport.keys:type_name -> TemporaryExposureKey
-	4, // 2: TEKSignatureList.signatures:type_name -> TEKSignature
-	1, // 3: TEKSignature.signature_info:type_name -> SignatureInfo
-	4, // [4:4] is the sub-list for method output_type
-	4, // [4:4] is the sub-list for method input_type
-	4, // [4:4] is the sub-list for extension type_name
-	4, // [4:4] is the sub-list for extension extendee
-	0, // [0:4] is the sub-list for field type_name
+	2, // 0: TemporaryExposureKeyExport.signature_infos:type_name -> SignatureInfo
+	3, // 1: TemporaryExposureKeyExport.keys:type_name -> TemporaryExposureKey
+	0, // 2: TemporaryExposureKey.report_type:type_name -> TemporaryExposureKey.ReportType
+	5, // 3: TEKSignatureList.signatures:type_name -> TEKSignature
+	2, // 4: TEKSignature.signature_info:type_name -> SignatureInfo
+	5, // [5:5] is the sub-list for method output_type
+	5, // [5:5] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_internal_pb_export_export_proto_init() }
@@ -611,13 +717,14 @@ func file_internal_pb_export_export_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_internal_pb_export_export_proto_rawDesc,
-			NumEnums:      0,
+			NumEnums:      1,
 			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
 		GoTypes:           file_internal_pb_export_export_proto_goTypes,
 		DependencyIndexes: file_internal_pb_export_export_proto_depIdxs,
+		EnumInfos:         file_internal_pb_export_export_proto_enumTypes,
 		MessageInfos:      file_internal_pb_export_export_proto_msgTypes,
 	}.Build()
 	File_internal_pb_export_export_proto = out.File
@@ -0,0 +1,673 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.22.0
+// 	protoc        v3.11.4
+// source: internal/pb/publish/publish.proto
+
+package publish
+
+import (
+	context "context"
+	reflect "reflect"
+	sync "sync"
+
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// This is a compile-time assertion that a sufficiently up-to-date version
+// of the legacy proto package is being used.
+const _ = proto.ProtoPackageIsVersion4
+
+// PublishRequest is a request to publish temporary exposure keys. It has the
+// same fields as the JSON body of the HTTP publish API, and is verified the
+// same way.
+type PublishRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	TemporaryExposureKeys     []*TemporaryExposureKey `protobuf:"bytes,1,rep,name=temporaryExposureKeys,proto3" json:"temporaryExposureKeys,omitempty"`
+	Regions                   []string                `protobuf:"bytes,2,rep,name=regions,proto3" json:"regions,omitempty"`
+	AppPackageName            string                  `protobuf:"bytes,3,opt,name=appPackageName,proto3" json:"appPackageName,omitempty"`
+	Platform                  string                  `protobuf:"bytes,4,opt,name=platform,proto3" json:"platform,omitempty"`
+	DeviceVerificationPayload string                  `protobuf:"bytes,5,opt,name=deviceVerificationPayload,proto3" json:"deviceVerificationPayload,omitempty"`
+	VerificationPayload       string                  `protobuf:"bytes,6,opt,name=verificationPayload,proto3" json:"verificationPayload,omitempty"`
+	ReportType                string                  `protobuf:"bytes,7,opt,name=reportType,proto3" json:"reportType,omitempty"`
+	RevisionToken             string                  `protobuf:"bytes,8,opt,name=revisionToken,proto3" json:"revisionToken,omitempty"`
+	Hmackey                   string                  `protobuf:"bytes,9,opt,name=hmackey,proto3" json:"hmackey,omitempty"`
+	Traveler                  bool                    `protobuf:"varint,10,opt,name=traveler,proto3" json:"traveler,omitempty"`
+	Padding                   string                  `protobuf:"bytes,11,opt,name=padding,proto3" json:"padding,omitempty"`
+	SymptomOnsetInterval      int32                   `protobuf:"varint,12,opt,name=symptomOnsetInterval,proto3" json:"symptomOnsetInterval,omitempty"` // 0 if unknown
+}
+
+func (x *PublishRequest) Reset() {
+	*x = PublishRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_publish_publish_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PublishRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishRequest) ProtoMessage() {}
+
+func (x *PublishRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_publish_publish_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishRequest.ProtoReflect.Descriptor instead.
+func (*PublishRequest) Descriptor() ([]byte, []int) {
+	return file_internal_pb_publish_publish_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PublishRequest) GetTemporaryExposureKeys() []*TemporaryExposureKey {
+	if x != nil {
+		return x.TemporaryExposureKeys
+	}
+	return nil
+}
+
+func (x *PublishRequest) GetRegions() []string {
+	if x != nil {
+		return x.Regions
+	}
+	return nil
+}
+
+func (x *PublishRequest) GetAppPackageName() string {
+	if x != nil {
+		return x.AppPackageName
+	}
+	return ""
+}
+
+func (x *PublishRequest) GetPlatform() string {
+	if x != nil {
+		return x.Platform
+	}
+	return ""
+}
+
+func (x *PublishRequest) GetDeviceVerificationPayload() string {
+	if x != nil {
+		return x.DeviceVerificationPayload
+	}
+	return ""
+}
+
+func (x *PublishRequest) GetVerificationPayload() string {
+	if x != nil {
+		return x.VerificationPayload
+	}
+	return ""
+}
+
+func (x *PublishRequest) GetReportType() string {
+	if x != nil {
+		return x.ReportType
+	}
+	return ""
+}
+
+func (x *PublishRequest) GetRevisionToken() string {
+	if x != nil {
+		return x.RevisionToken
+	}
+	return ""
+}
+
+func (x *PublishRequest) GetHmackey() string {
+	if x != nil {
+		return x.Hmackey
+	}
+	return ""
+}
+
+func (x *PublishRequest) GetTraveler() bool {
+	if x != nil {
+		return x.Traveler
+	}
+	return false
+}
+
+func (x *PublishRequest) GetPadding() string {
+	if x != nil {
+		return x.Padding
+	}
+	return ""
+}
+
+func (x *PublishRequest) GetSymptomOnsetInterval() int32 {
+	if x != nil {
+		return x.SymptomOnsetInterval
+	}
+	return 0
+}
+
+type TemporaryExposureKey struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key                         string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"` // base64 encoded, as in the attestation nonce
+	RollingStartNumber          int32  `protobuf:"varint,2,opt,name=rollingStartNumber,proto3" json:"rollingStartNumber,omitempty"`
+	RollingPeriod               int32  `protobuf:"varint,3,opt,name=rollingPeriod,proto3" json:"rollingPeriod,omitempty"`
+	TransmissionRisk            int32  `protobuf:"varint,4,opt,name=transmissionRisk,proto3" json:"transmissionRisk,omitempty"`
+	DaysSinceOnsetOfSymptoms    int32  `protobuf:"varint,5,opt,name=daysSinceOnsetOfSymptoms,proto3" json:"daysSinceOnsetOfSymptoms,omitempty"`
+	HasDaysSinceOnsetOfSymptoms bool   `protobuf:"varint,6,opt,name=hasDaysSinceOnsetOfSymptoms,proto3" json:"hasDaysSinceOnsetOfSymptoms,omitempty"` // daysSinceOnsetOfSymptoms is only read if set
+}
+
+func (x *TemporaryExposureKey) Reset() {
+	*x = TemporaryExposureKey{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_publish_publish_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TemporaryExposureKey) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TemporaryExposureKey) ProtoMessage() {}
+
+func (x *TemporaryExposureKey) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_publish_publish_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TemporaryExposureKey.ProtoReflect.Descriptor instead.
+func (*TemporaryExposureKey) Descriptor() ([]byte, []int) {
+	return file_internal_pb_publish_publish_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TemporaryExposureKey) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *TemporaryExposureKey) GetRollingStartNumber() int32 {
+	if x != nil {
+		return x.RollingStartNumber
+	}
+	return 0
+}
+
+func (x *TemporaryExposureKey) GetRollingPeriod() int32 {
+	if x != nil {
+		return x.RollingPeriod
+	}
+	return 0
+}
+
+func (x *TemporaryExposureKey) GetTransmissionRisk() int32 {
+	if x != nil {
+		return x.TransmissionRisk
+	}
+	return 0
+}
+
+func (x *TemporaryExposureKey) GetDaysSinceOnsetOfSymptoms() int32 {
+	if x != nil {
+		return x.DaysSinceOnsetOfSymptoms
+	}
+	return 0
+}
+
+func (x *TemporaryExposureKey) GetHasDaysSinceOnsetOfSymptoms() bool {
+	if x != nil {
+		return x.HasDaysSinceOnsetOfSymptoms
+	}
+	return false
+}
+
+// PublishResponse reports the outcome of each key, like the v2 HTTP API.
+// Errors that apply to the whole request are returned as a gRPC status, with
+// the error code in the "error-code" trailer.
+type PublishResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	RevisionToken string       `protobuf:"bytes,1,opt,name=revisionToken,proto3" json:"revisionToken,omitempty"`
+	Keys          []*KeyStatus `protobuf:"bytes,2,rep,name=keys,proto3" json:"keys,omitempty"`
+	Padding       string       `protobuf:"bytes,3,opt,name=padding,proto3" json:"padding,omitempty"`
+}
+
+func (x *PublishResponse) Reset() {
+	*x = PublishResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_publish_publish_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PublishResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishResponse) ProtoMessage() {}
+
+func (x *PublishResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_publish_publish_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishResponse.ProtoReflect.Descriptor instead.
+func (*PublishResponse) Descriptor() ([]byte, []int) {
+	return file_internal_pb_publish_publish_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PublishResponse) GetRevisionToken() string {
+	if x != nil {
+		return x.RevisionToken
+	}
+	return ""
+}
+
+func (x *PublishResponse) GetKeys() []*KeyStatus {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+func (x *PublishResponse) GetPadding() string {
+	if x != nil {
+		return x.Padding
+	}
+	return ""
+}
+
+type KeyStatus struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Index   int32  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Status  string `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	Code    string `protobuf:"bytes,3,opt,name=code,proto3" json:"code,omitempty"`
+	Message string `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (x *KeyStatus) Reset() {
+	*x = KeyStatus{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_internal_pb_publish_publish_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *KeyStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KeyStatus) ProtoMessage() {}
+
+func (x *KeyStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_pb_publish_publish_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KeyStatus.ProtoReflect.Descriptor instead.
+func (*KeyStatus) Descriptor() ([]byte, []int) {
+	return file_internal_pb_publish_publish_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *KeyStatus) GetIndex() int32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *KeyStatus) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *KeyStatus) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *KeyStatus) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+var File_internal_pb_publish_publish_proto protoreflect.FileDescriptor
+
+var file_internal_pb_publish_publish_proto_rawDesc = []byte{
+	0x0a, 0x21, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x2f, 0x70, 0x62, 0x2f, 0x70, 0x75,
+	0x62, 0x6c, 0x69, 0x73, 0x68, 0x2f, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x73, 0x68, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x12, 0x07, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x73, 0x68, 0x22, 0xfd, 0x03, 0x0a,
+	0x0e, 0x50, 0x75, 0x62, 0x6c, 0x69, 0x73, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
+	0x53, 0x0a, 0x15, 0x74, 0x65, 0x6d, 0x70, 0x6f, 0x72, 0x61, 0x72, 0x79, 0x45, 0x78, 0x70, 0x6f,
+	0x73, 0x75, 0x72, 0x65, 0x4b, 0x65, 0x79, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1d,
+	0x2e, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x73, 0x68, 0x2e, 0x54, 0x65, 0x6d, 0x70, 0x6f, 0x72, 0x61,
+	0x72, 0x79, 0x45, 0x78, 0x70, 0x6f, 0x73, 0x75, 0x72, 0x65, 0x4b, 0x65, 0x79, 0x52, 0x15, 0x74,
+	0x65, 0x6d, 0x70, 0x6f, 0x72, 0x61, 0x72, 0x79, 0x45, 0x78, 0x70, 0x6f, 0x73, 0x75, 0x72, 0x65,
+	0x4b, 0x65, 0x79, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x73, 0x18,
+	0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x26,
+	0x0a, 0x0e, 0x61, 0x70, 0x70, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x4e, 0x61, 0x6d, 0x65,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x61, 0x70, 0x70, 0x50, 0x61, 0x63, 0x6b, 0x61,
+	0x67, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x6c, 0x61, 0x74, 0x66, 0x6f,
+	0x72, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x70, 0x6c, 0x61, 0x74, 0x66, 0x6f,
+	0x72, 0x6d, 0x12, 0x3c, 0x0a, 0x19, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x56, 0x65, 0x72, 0x69,
+	0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x50, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x19, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x56, 0x65, 0x72,
+	0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x50, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64,
+	0x12, 0x30, 0x0a, 0x13, 0x76, 0x65, 0x72, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x50, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x13, 0x76,
+	0x65, 0x72, 0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x50, 0x61, 0x79, 0x6c, 0x6f,
+	0x61, 0x64, 0x12, 0x1e, 0x0a, 0x0a, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x54, 0x79, 0x70, 0x65,
+	0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x54, 0x79,
+	0x70, 0x65, 0x12, 0x24, 0x0a, 0x0d, 0x72, 0x65, 0x76, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x54, 0x6f,
+	0x6b, 0x65, 0x6e, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x72, 0x65, 0x76, 0x69, 0x73,
+	0x69, 0x6f, 0x6e, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x18, 0x0a, 0x07, 0x68, 0x6d, 0x61, 0x63,
+	0x6b, 0x65, 0x79, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x68, 0x6d, 0x61, 0x63, 0x6b,
+	0x65, 0x79, 0x12, 0x1a, 0x0a, 0x08, 0x74, 0x72, 0x61, 0x76, 0x65, 0x6c, 0x65, 0x72, 0x18, 0x0a,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x74, 0x72, 0x61, 0x76, 0x65, 0x6c, 0x65, 0x72, 0x12, 0x18,
+	0x0a, 0x07, 0x70, 0x61, 0x64, 0x64, 0x69, 0x6e, 0x67, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x07, 0x70, 0x61, 0x64, 0x64, 0x69, 0x6e, 0x67, 0x12, 0x32, 0x0a, 0x14, 0x73, 0x79, 0x6d, 0x70,
+	0x74, 0x6f, 0x6d, 0x4f, 0x6e, 0x73, 0x65, 0x74, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c,
+	0x18, 0x0c, 0x20, 0x01, 0x28, 0x05, 0x52, 0x14, 0x73, 0x79, 0x6d, 0x70, 0x74, 0x6f, 0x6d, 0x4f,
+	0x6e, 0x73, 0x65, 0x74, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x22, 0xa8, 0x02, 0x0a,
+	0x14, 0x54, 0x65, 0x6d, 0x70, 0x6f, 0x72, 0x61, 0x72, 0x79, 0x45, 0x78, 0x70, 0x6f, 0x73, 0x75,
+	0x72, 0x65, 0x4b, 0x65, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x2e, 0x0a, 0x12, 0x72, 0x6f, 0x6c, 0x6c, 0x69,
+	0x6e, 0x67, 0x53, 0x74, 0x61, 0x72, 0x74, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x12, 0x72, 0x6f, 0x6c, 0x6c, 0x69, 0x6e, 0x67, 0x53, 0x74, 0x61, 0x72,
+	0x74, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x12, 0x24, 0x0a, 0x0d, 0x72, 0x6f, 0x6c, 0x6c, 0x69,
+	0x6e, 0x67, 0x50, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0d,
+	0x72, 0x6f, 0x6c, 0x6c, 0x69, 0x6e, 0x67, 0x50, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x12, 0x2a, 0x0a,
+	0x10, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x69, 0x73,
+	0x6b, 0x18, 0x04, 0x20, 0x01, 0x28, 0x05, 0x52, 0x10, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69,
+	0x73, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x69, 0x73, 0x6b, 0x12, 0x3a, 0x0a, 0x18, 0x64, 0x61, 0x79,
+	0x73, 0x53, 0x69, 0x6e, 0x63, 0x65, 0x4f, 0x6e, 0x73, 0x65, 0x74, 0x4f, 0x66, 0x53, 0x79, 0x6d,
+	0x70, 0x74, 0x6f, 0x6d, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x18, 0x64, 0x61, 0x79,
+	0x73, 0x53, 0x69, 0x6e, 0x63, 0x65, 0x4f, 0x6e, 0x73, 0x65, 0x74, 0x4f, 0x66, 0x53, 0x79, 0x6d,
+	0x70, 0x74, 0x6f, 0x6d, 0x73, 0x12, 0x40, 0x0a, 0x1b, 0x68, 0x61, 0x73, 0x44, 0x61, 0x79, 0x73,
+	0x53, 0x69, 0x6e, 0x63, 0x65, 0x4f, 0x6e, 0x73, 0x65, 0x74, 0x4f, 0x66, 0x53, 0x79, 0x6d, 0x70,
+	0x74, 0x6f, 0x6d, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x1b, 0x68, 0x61, 0x73, 0x44,
+	0x61, 0x79, 0x73, 0x53, 0x69, 0x6e, 0x63, 0x65, 0x4f, 0x6e, 0x73, 0x65, 0x74, 0x4f, 0x66, 0x53,
+	0x79, 0x6d, 0x70, 0x74, 0x6f, 0x6d, 0x73, 0x22, 0x79, 0x0a, 0x0f, 0x50, 0x75, 0x62, 0x6c, 0x69,
+	0x73, 0x68, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x24, 0x0a, 0x0d, 0x72, 0x65,
+	0x76, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0d, 0x72, 0x65, 0x76, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x54, 0x6f, 0x6b, 0x65, 0x6e,
+	0x12, 0x26, 0x0a, 0x04, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12,
+	0x2e, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x73, 0x68, 0x2e, 0x4b, 0x65, 0x79, 0x53, 0x74, 0x61, 0x74,
+	0x75, 0x73, 0x52, 0x04, 0x6b, 0x65, 0x79, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x70, 0x61, 0x64, 0x64,
+	0x69, 0x6e, 0x67, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x70, 0x61, 0x64, 0x64, 0x69,
+	0x6e, 0x67, 0x22, 0x67, 0x0a, 0x09, 0x4b, 0x65, 0x79, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12,
+	0x14, 0x0a, 0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05,
+	0x69, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x12, 0x0a,
+	0x04, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x63, 0x6f, 0x64,
+	0x65, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x32, 0x49, 0x0a, 0x07, 0x50,
+	0x75, 0x62, 0x6c, 0x69, 0x73, 0x68, 0x12, 0x3e, 0x0a, 0x07, 0x50, 0x75, 0x62, 0x6c, 0x69, 0x73,
+	0x68, 0x12, 0x17, 0x2e, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x73, 0x68, 0x2e, 0x50, 0x75, 0x62, 0x6c,
+	0x69, 0x73, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x18, 0x2e, 0x70, 0x75, 0x62,
+	0x6c, 0x69, 0x73, 0x68, 0x2e, 0x50, 0x75, 0x62, 0x6c, 0x69, 0x73, 0x68, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x42, 0x1d, 0x5a, 0x1b, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x6e,
+	0x61, 0x6c, 0x2f, 0x70, 0x62, 0x2f, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x73, 0x68, 0x3b, 0x70, 0x75,
+	0x62, 0x6c, 0x69, 0x73, 0x68, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_internal_pb_publish_publish_proto_rawDescOnce sync.Once
+	file_internal_pb_publish_publish_proto_rawDescData = file_internal_pb_publish_publish_proto_rawDesc
+)
+
+func file_internal_pb_publish_publish_proto_rawDescGZIP() []byte {
+	file_internal_pb_publish_publish_proto_rawDescOnce.Do(func() {
+		file_internal_pb_publish_publish_proto_rawDescData = protoimpl.X.CompressGZIP(file_internal_pb_publish_publish_proto_rawDescData)
+	})
+	return file_internal_pb_publish_publish_proto_rawDescData
+}
+
+var file_internal_pb_publish_publish_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_internal_pb_publish_publish_proto_goTypes = []interface{}{
+	(*PublishRequest)(nil),       // 0: publish.PublishRequest
+	(*TemporaryExposureKey)(nil), // 1: publish.TemporaryExposureKey
+	(*PublishResponse)(nil),      // 2: publish.PublishResponse
+	(*KeyStatus)(nil),            // 3: publish.KeyStatus
+}
+var file_internal_pb_publish_publish_proto_depIdxs = []int32{
+	1, // 0: publish.PublishRequest.temporaryExposureKeys:type_name -> publish.TemporaryExposureKey
+	3, // 1: publish.PublishResponse.keys:type_name -> publish.KeyStatus
+	0, // 2: publish.Publish.Publish:input_type -> publish.PublishRequest
+	2, // 3: publish.Publish.Publish:output_type -> publish.PublishResponse
+	3, // [3:4] is the sub-list for method output_type
+	2, // [2:3] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_internal_pb_publish_publish_proto_init() }
+func file_internal_pb_publish_publish_proto_init() {
+	if File_internal_pb_publish_publish_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_internal_pb_publish_publish_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PublishRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_internal_pb_publish_publish_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TemporaryExposureKey); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_internal_pb_publish_publish_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PublishResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_internal_pb_publish_publish_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*KeyStatus); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_internal_pb_publish_publish_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_internal_pb_publish_publish_proto_goTypes,
+		DependencyIndexes: file_internal_pb_publish_publish_proto_depIdxs,
+		MessageInfos:      file_internal_pb_publish_publish_proto_msgTypes,
+	}.Build()
+	File_internal_pb_publish_publish_proto = out.File
+	file_internal_pb_publish_publish_proto_rawDesc = nil
+	file_internal_pb_publish_publish_proto_goTypes = nil
+	file_internal_pb_publish_publish_proto_depIdxs = nil
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConnInterface
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion6
+
+// PublishClient is the client API for Publish service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type PublishClient interface {
+	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error)
+}
+
+type publishClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPublishClient(cc grpc.ClientConnInterface) PublishClient {
+	return &publishClient{cc}
+}
+
+func (c *publishClient) Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error) {
+	out := new(PublishResponse)
+	err := c.cc.Invoke(ctx, "/publish.Publish/Publish", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PublishServer is the server API for Publish service.
+type PublishServer interface {
+	Publish(context.Context, *PublishRequest) (*PublishResponse, error)
+}
+
+// UnimplementedPublishServer can be embedded to have forward compatible implementations.
+type UnimplementedPublishServer struct {
+}
+
+func (*UnimplementedPublishServer) Publish(context.Context, *PublishRequest) (*PublishResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Publish not implemented")
+}
+
+func RegisterPublishServer(s *grpc.Server, srv PublishServer) {
+	s.RegisterService(&_Publish_serviceDesc, srv)
+}
+
+func _Publish_Publish_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PublishServer).Publish(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/publish.Publish/Publish",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PublishServer).Publish(ctx, req.(*PublishRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Publish_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "publish.Publish",
+	HandlerType: (*PublishServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Publish",
+			Handler:    _Publish_Publish_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "internal/pb/publish/publish.proto",
+}
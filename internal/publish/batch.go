@@ -0,0 +1,289 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/handlers"
+	"github.com/google/exposure-notifications-server/internal/jsonutil"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+)
+
+// BatchPublish is the body of a batch publish request. Each report holds the
+// keys of one person. All reports are published to the same regions.
+type BatchPublish struct {
+	Reports []BatchReport `json:"reports"`
+	Regions []string      `json:"regions"`
+}
+
+// BatchReport is the keys of one person in a batch publish request.
+type BatchReport struct {
+	Keys       []database.ExposureKey `json:"temporaryExposureKeys"`
+	ReportType string                 `json:"reportType"`
+}
+
+// BatchReportError lists why a report in a batch publish request was
+// rejected.
+type BatchReportError struct {
+	// Index is the position of the report in the request.
+	Index     int        `json:"index"`
+	Error     string     `json:"error,omitempty"`
+	KeyErrors []KeyError `json:"keyErrors,omitempty"`
+}
+
+// BatchPublishResponse is the body of a response from the batch publish API.
+type BatchPublishResponse struct {
+	InsertedKeys int                `json:"insertedKeys"`
+	Code         ErrorCode          `json:"code,omitempty"`
+	Error        string             `json:"error,omitempty"`
+	Reports      []BatchReportError `json:"reports,omitempty"`
+}
+
+// NewBatchHandler creates the HTTP handler for the batch publish API, which
+// health authorities use to upload keys from their back office. Requests are
+// authenticated with an API key that has the batch_publish permission instead
+// of a device attestation. A request is all-or-nothing: if any report is
+// rejected, no keys are stored.
+func NewBatchHandler(ctx context.Context, config *Config, env *serverenv.ServerEnv) (http.Handler, error) {
+	logger := logging.FromContext(ctx)
+
+	if env.Database() == nil {
+		return nil, fmt.Errorf("missing database in server environment")
+	}
+
+	transformer, err := newTransformer(config, config.MaxKeysOnPublish)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("max keys per batch upload: %v", config.MaxKeysOnBatchPublish)
+
+	return &batchHandler{
+		config:      config,
+		serverenv:   env,
+		transformer: transformer,
+		validator:   newValidator(config, env.RuntimeConfig()),
+		database:    env.Database(),
+	}, nil
+}
+
+type batchHandler struct {
+	config      *Config
+	serverenv   *serverenv.ServerEnv
+	transformer *database.Transformer
+	validator   *validator
+	database    *database.DB
+}
+
+type batchResponse struct {
+	status   int
+	code     ErrorCode
+	message  string
+	metric   string
+	count    int
+	inserted int
+	reports  []BatchReportError
+}
+
+func (h *batchHandler) handleRequest(w http.ResponseWriter, r *http.Request) batchResponse {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+
+	apiKey, resp := h.authenticate(ctx, r)
+	if resp != nil {
+		return *resp
+	}
+
+	maxBodyBytes := h.config.MaxBatchBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = jsonutil.DefaultMaxBodyBytes
+	}
+	maxDepth := h.config.MaxJSONDepth
+	if maxDepth <= 0 {
+		maxDepth = jsonutil.DefaultMaxDepth
+	}
+	var data BatchPublish
+	code, err := jsonutil.UnmarshalLimited(w, r, &data, maxBodyBytes, maxDepth)
+	if err != nil {
+		message := fmt.Sprintf("error unmarshalling API call, code: %v: %v", code, err)
+		logger.Error(message)
+		if code == http.StatusRequestEntityTooLarge {
+			return batchResponse{status: code, code: ErrorRequestTooLarge, message: message, metric: "publish-batch-body-too-large", count: 1}
+		}
+		return batchResponse{status: http.StatusBadRequest, code: ErrorBadRequest, message: message, metric: "publish-batch-bad-json", count: 1}
+	}
+
+	total := 0
+	for _, report := range data.Reports {
+		total += len(report.Keys)
+	}
+	if total == 0 || total > h.config.MaxKeysOnBatchPublish {
+		message := fmt.Sprintf("batch publish must contain between 1 and %v keys, got %v", h.config.MaxKeysOnBatchPublish, total)
+		logger.Error(message)
+		return batchResponse{status: http.StatusBadRequest, code: ErrorBadKeyCount, message: message, metric: "publish-batch-invalid-key-count", count: 1}
+	}
+
+	regions, err := allowedRegions(apiKey, data.Regions)
+	if err != nil {
+		message := fmt.Sprintf("verifying allowed regions: %v", err)
+		logger.Error(message)
+		return batchResponse{status: http.StatusForbidden, code: ErrorRegionNotAllowed, message: message, metric: "publish-batch-region-not-authorized", count: 1}
+	}
+
+	// The API key stands in for an authorized app, so the keys are validated
+	// with the defaults of the health authority's apps.
+	app := &model.AuthorizedApp{HealthAuthorityID: apiKey.HealthAuthorityID}
+	batchTime := time.Now()
+	var exposures []*database.Exposure
+	var reportErrors []BatchReportError
+	for i, report := range data.Reports {
+		pub := &database.Publish{
+			Keys:           report.Keys,
+			Regions:        regions,
+			AppPackageName: apiKey.Name,
+			ReportType:     report.ReportType,
+		}
+		if keyErrors := h.validator.validate(pub, batchTime, app); len(keyErrors) > 0 {
+			reportErrors = append(reportErrors, BatchReportError{Index: i, KeyErrors: keyErrors})
+			continue
+		}
+		reportExposures, err := h.transformer.TransformPublish(pub, batchTime)
+		if err != nil {
+			reportErrors = append(reportErrors, BatchReportError{Index: i, Error: err.Error()})
+			continue
+		}
+		for _, exp := range reportExposures {
+			exp.HealthAuthorityID = apiKey.HealthAuthorityID
+		}
+		exposures = append(exposures, reportExposures...)
+	}
+	if len(reportErrors) > 0 {
+		message := fmt.Sprintf("%d of %d reports were rejected", len(reportErrors), len(data.Reports))
+		logger.Error(message)
+		return batchResponse{status: http.StatusBadRequest, code: ErrorInvalidKeys, message: message, metric: "publish-batch-invalid-reports", count: len(reportErrors), reports: reportErrors}
+	}
+
+	// The reports share their regions, so they are stored in one database.
+	store, err := h.database.ForExposures(exposures)
+	if err != nil {
+		message := fmt.Sprintf("unable to store exposures: %v", err)
+		logger.Error(message)
+		return batchResponse{status: http.StatusBadRequest, code: ErrorRegionNotAllowed, message: message, metric: "publish-batch-mixed-residency", count: 1}
+	}
+
+	return h.insert(ctx, store, exposures, len(data.Reports), apiKey.Name)
+}
+
+// batchInserter stores the exposures of a batch publish request.
+type batchInserter interface {
+	BulkInsertExposuresInChunks(ctx context.Context, exposures []*database.Exposure, chunkSize int) error
+}
+
+// insert stores the exposures of a batch, BatchInsertSize at a time, in a
+// single transaction so that either all of them are stored or none are.
+func (h *batchHandler) insert(ctx context.Context, store batchInserter, exposures []*database.Exposure, reports int, name string) batchResponse {
+	logger := logging.FromContext(ctx)
+
+	if err := store.BulkInsertExposuresInChunks(ctx, exposures, h.config.BatchInsertSize); err != nil {
+		logger.Errorf("error writing exposure records: %v", err)
+		return batchResponse{status: http.StatusInternalServerError, code: ErrorInternal, message: http.StatusText(http.StatusInternalServerError), metric: "publish-batch-db-write-error", count: 1}
+	}
+
+	message := fmt.Sprintf("Inserted %d keys from %d reports for %v.", len(exposures), reports, name)
+	logger.Info(message)
+	return batchResponse{status: http.StatusOK, message: message, metric: "publish-batch-keys-inserted", count: len(exposures), inserted: len(exposures)}
+}
+
+// authenticate loads the API key presented as a bearer token and checks that
+// it may batch publish. On failure it returns the response to send.
+func (h *batchHandler) authenticate(ctx context.Context, r *http.Request) (*database.APIKey, *batchResponse) {
+	logger := logging.FromContext(ctx)
+
+	apiKey, err := handlers.AuthenticateAPIKey(ctx, h.database, r, database.PermissionBatchPublish)
+	if err == nil {
+		return apiKey, nil
+	}
+	var herr *handlers.Error
+	switch {
+	case errors.Is(err, handlers.ErrMissingAPIKey):
+		return nil, &batchResponse{status: http.StatusUnauthorized, code: ErrorBadAPIKey, message: err.Error(), metric: "publish-batch-missing-api-key", count: 1}
+	case errors.Is(err, handlers.ErrInvalidAPIKey):
+		return nil, &batchResponse{status: http.StatusUnauthorized, code: ErrorBadAPIKey, message: err.Error(), metric: "publish-batch-invalid-api-key", count: 1}
+	case errors.As(err, &herr):
+		logger.Error(herr.Message)
+		return nil, &batchResponse{status: herr.Status, code: ErrorPermissionDenied, message: herr.Message, metric: "publish-batch-permission-denied", count: 1}
+	}
+	logger.Errorf("error loading API key: %v", err)
+	return nil, &batchResponse{status: http.StatusInternalServerError, code: ErrorInternal, message: http.StatusText(http.StatusInternalServerError), metric: "publish-batch-api-key-error", count: 1}
+}
+
+// allowedRegions returns the uppercased regions of a batch publish request.
+// If the request lists no regions, the keys are published to all the regions
+// the API key allows.
+func allowedRegions(apiKey *database.APIKey, requested []string) ([]string, error) {
+	allowed := make(map[string]struct{}, len(apiKey.AllowedRegions))
+	for _, r := range apiKey.AllowedRegions {
+		allowed[strings.ToUpper(r)] = struct{}{}
+	}
+	if len(requested) == 0 {
+		if len(allowed) == 0 {
+			return nil, fmt.Errorf("no regions requested and API key %v allows none", apiKey.Name)
+		}
+		requested = apiKey.AllowedRegions
+	}
+
+	regions := make([]string, 0, len(requested))
+	for _, r := range requested {
+		r = strings.ToUpper(r)
+		if _, ok := allowed[r]; !ok {
+			return nil, fmt.Errorf("API key %v may not publish to region %v", apiKey.Name, r)
+		}
+		regions = append(regions, r)
+	}
+	return regions, nil
+}
+
+// ServeHTTP writes the result as JSON. Unlike the device facing APIs, errors
+// are always returned, since the caller is a health authority's server.
+func (h *batchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	response := h.handleRequest(w, r)
+
+	if response.metric != "" {
+		ctx := r.Context()
+		metrics := h.serverenv.MetricsExporter(ctx)
+		metrics.WriteInt(response.metric, true, response.count)
+	}
+
+	body := BatchPublishResponse{
+		InsertedKeys: response.inserted,
+		Reports:      response.reports,
+	}
+	if response.status != http.StatusOK {
+		body.Code = response.code
+		body.Error = response.message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAllowedRegions(t *testing.T) {
+	apiKey := &database.APIKey{Name: "back-office", AllowedRegions: []string{"US", "ca"}}
+
+	cases := []struct {
+		name      string
+		requested []string
+		want      []string
+		wantErr   bool
+	}{
+		{name: "uppercased", requested: []string{"us", "CA"}, want: []string{"US", "CA"}},
+		{name: "subset", requested: []string{"CA"}, want: []string{"CA"}},
+		{name: "not allowed", requested: []string{"US", "MX"}, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := allowedRegions(apiKey, c.requested)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("allowedRegions: got error %v, want error %v", err, c.wantErr)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	// The default regions are uppercased as well.
+	got, err := allowedRegions(apiKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"US", "CA"}, got); diff != "" {
+		t.Errorf("default regions mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestBatchRequiresAPIKey(t *testing.T) {
+	ctx := context.Background()
+
+	// The handler has no database, so the request must be rejected before
+	// the key is looked up.
+	h := &batchHandler{
+		config:    &Config{MaxKeysOnBatchPublish: 10000},
+		serverenv: serverenv.New(ctx, serverenv.WithMetricsExporter(metrics.NewLogsBasedFromContext)),
+	}
+	r := httptest.NewRequest(http.MethodPost, "/publish/batch", strings.NewReader(`{"reports": []}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	var got BatchPublishResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Code != ErrorBadAPIKey {
+		t.Errorf("got code %q, want %q", got.Code, ErrorBadAPIKey)
+	}
+}
+
+// fakeBatchInserter records the chunk size it is called with and fails if
+// err is set, storing nothing.
+type fakeBatchInserter struct {
+	chunkSize int
+	stored    int
+	err       error
+}
+
+func (f *fakeBatchInserter) BulkInsertExposuresInChunks(ctx context.Context, exposures []*database.Exposure, chunkSize int) error {
+	f.chunkSize = chunkSize
+	if f.err != nil {
+		return f.err
+	}
+	f.stored += len(exposures)
+	return nil
+}
+
+func TestBatchInsert(t *testing.T) {
+	ctx := context.Background()
+	h := &batchHandler{config: &Config{BatchInsertSize: 2}}
+	exposures := exposuresOf("a", "b", "c", "d", "e")
+
+	cases := []struct {
+		name         string
+		err          error
+		wantStatus   int
+		wantInserted int
+	}{
+		{name: "inserted", wantStatus: http.StatusOK, wantInserted: 5},
+		{name: "mid-batch failure", err: errors.New("insert failed"), wantStatus: http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store := &fakeBatchInserter{err: c.err}
+			got := h.insert(ctx, store, exposures, 2, "back-office")
+			if got.status != c.wantStatus {
+				t.Errorf("got status %d, want %d", got.status, c.wantStatus)
+			}
+			// A failed batch stores nothing, so it reports no inserted keys.
+			if got.inserted != c.wantInserted || store.stored != c.wantInserted {
+				t.Errorf("got %d inserted and %d stored, want %d", got.inserted, store.stored, c.wantInserted)
+			}
+			if store.chunkSize != 2 {
+				t.Errorf("got chunk size %d, want 2", store.chunkSize)
+			}
+		})
+	}
+}
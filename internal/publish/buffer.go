@@ -0,0 +1,196 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+)
+
+// errInsertAlone tells a buffered write to insert its exposures on its own,
+// because the buffer stopped or the flush it was part of failed.
+var errInsertAlone = errors.New("insert exposures on their own")
+
+// exposureInserter inserts the exposures of publish requests.
+type exposureInserter interface {
+	InsertExposuresDedupe(ctx context.Context, exposures []*database.Exposure) ([]database.InsertResult, error)
+}
+
+// writeBuffers groups the inserts of concurrent publish requests, with one
+// writeBuffer for each database that exposures are stored in. A nil
+// writeBuffers inserts each request's exposures synchronously.
+type writeBuffers struct {
+	ctx      context.Context
+	interval time.Duration
+	maxKeys  int
+
+	mu      sync.Mutex
+	buffers map[exposureInserter]*writeBuffer
+}
+
+// newWriteBuffers returns the write buffers of config, or nil if
+// PUBLISH_BUFFER_INTERVAL isn't set. The buffers flush until ctx is done.
+func newWriteBuffers(ctx context.Context, config *Config) *writeBuffers {
+	if config.BufferInterval <= 0 {
+		return nil
+	}
+	return &writeBuffers{
+		ctx:      ctx,
+		interval: config.BufferInterval,
+		maxKeys:  config.BufferMaxKeys,
+		buffers:  make(map[exposureInserter]*writeBuffer),
+	}
+}
+
+// insert inserts exposures into store, returning the outcome of each.
+func (b *writeBuffers) insert(ctx context.Context, store exposureInserter, exposures []*database.Exposure) ([]database.InsertResult, error) {
+	if b == nil {
+		return store.InsertExposuresDedupe(ctx, exposures)
+	}
+
+	b.mu.Lock()
+	buf, ok := b.buffers[store]
+	if !ok {
+		buf = newWriteBuffer(store, b.interval, b.maxKeys)
+		go buf.run(b.ctx)
+		b.buffers[store] = buf
+	}
+	b.mu.Unlock()
+	return buf.insert(ctx, exposures)
+}
+
+// writeBuffer accumulates the exposures of publish requests and inserts them
+// in one transaction, once interval has passed since the first of them was
+// buffered, or once there are maxKeys of them.
+//
+// A request waits for the transaction that inserts its exposures, so it only
+// succeeds once they are committed, as if it inserted them itself. Keys that
+// repeat within a flush are reported as duplicates or conflicts to the later
+// request, as they would be if the requests inserted them one after the
+// other. If a flush fails, each of its requests inserts its exposures on its
+// own, so that one request can't fail the others.
+type writeBuffer struct {
+	store    exposureInserter
+	interval time.Duration
+	maxKeys  int
+
+	writes  chan *bufferedWrite
+	stopped chan struct{}
+}
+
+// bufferedWrite is the insert of one publish request.
+type bufferedWrite struct {
+	exposures []*database.Exposure
+
+	// results and err are set before done is closed.
+	results []database.InsertResult
+	err     error
+	done    chan struct{}
+}
+
+func newWriteBuffer(store exposureInserter, interval time.Duration, maxKeys int) *writeBuffer {
+	return &writeBuffer{
+		store:    store,
+		interval: interval,
+		maxKeys:  maxKeys,
+		writes:   make(chan *bufferedWrite),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// insert buffers exposures and waits for them to be inserted. If ctx is done
+// first, it returns ctx.Err(), but the exposures may still be inserted.
+func (b *writeBuffer) insert(ctx context.Context, exposures []*database.Exposure) ([]database.InsertResult, error) {
+	w := &bufferedWrite{exposures: exposures, done: make(chan struct{})}
+	select {
+	case b.writes <- w:
+	case <-b.stopped:
+		return b.store.InsertExposuresDedupe(ctx, exposures)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if errors.Is(w.err, errInsertAlone) {
+		return b.store.InsertExposuresDedupe(ctx, exposures)
+	}
+	return w.results, w.err
+}
+
+// run flushes the buffer until ctx is done. Writes that are buffered when it
+// stops are inserted on their own.
+func (b *writeBuffer) run(ctx context.Context) {
+	defer close(b.stopped)
+
+	var pending []*bufferedWrite
+	var keys int
+	var flush <-chan time.Time
+	for {
+		select {
+		case w := <-b.writes:
+			if len(pending) == 0 {
+				flush = time.After(b.interval)
+			}
+			pending = append(pending, w)
+			keys += len(w.exposures)
+			if keys < b.maxKeys {
+				continue
+			}
+		case <-flush:
+		case <-ctx.Done():
+			for _, w := range pending {
+				w.err = errInsertAlone
+				close(w.done)
+			}
+			return
+		}
+
+		b.flush(ctx, pending, keys)
+		pending, keys, flush = nil, 0, nil
+	}
+}
+
+// flush inserts the exposures of writes in one transaction.
+func (b *writeBuffer) flush(ctx context.Context, writes []*bufferedWrite, keys int) {
+	exposures := make([]*database.Exposure, 0, keys)
+	for _, w := range writes {
+		exposures = append(exposures, w.exposures...)
+	}
+	results, err := b.store.InsertExposuresDedupe(ctx, exposures)
+	metrics.PublishBufferFlushKeys.WithLabelValues(metrics.Result(err)).Observe(float64(keys))
+	if err != nil {
+		logging.FromContext(ctx).Warnf("Failed to flush %d exposures of %d publish requests, inserting them one request at a time: %v", keys, len(writes), err)
+		for _, w := range writes {
+			w.err = errInsertAlone
+			close(w.done)
+		}
+		return
+	}
+
+	for _, w := range writes {
+		w.results, results = results[:len(w.exposures)], results[len(w.exposures):]
+		close(w.done)
+	}
+}
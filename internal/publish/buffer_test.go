@@ -0,0 +1,159 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeInserter accepts new keys and reports repeated ones as duplicates. It
+// fails calls with more than failOver exposures, if failOver is set.
+type fakeInserter struct {
+	mu       sync.Mutex
+	keys     map[string]bool
+	calls    []int
+	failOver int
+}
+
+func (f *fakeInserter) InsertExposuresDedupe(ctx context.Context, exposures []*database.Exposure) ([]database.InsertResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, len(exposures))
+	if f.failOver > 0 && len(exposures) > f.failOver {
+		return nil, errors.New("insert failed")
+	}
+	var results []database.InsertResult
+	for _, exp := range exposures {
+		key := string(exp.ExposureKey)
+		if f.keys[key] {
+			results = append(results, database.InsertDuplicate)
+			continue
+		}
+		f.keys[key] = true
+		results = append(results, database.InsertAccepted)
+	}
+	return results, nil
+}
+
+func exposuresOf(keys ...string) []*database.Exposure {
+	var exposures []*database.Exposure
+	for _, k := range keys {
+		exposures = append(exposures, &database.Exposure{ExposureKey: []byte(k)})
+	}
+	return exposures
+}
+
+func TestWriteBuffer(t *testing.T) {
+	cases := []struct {
+		name      string
+		maxKeys   int
+		failOver  int
+		wantCalls []int
+	}{
+		{name: "one flush", maxKeys: 4, wantCalls: []int{4}},
+		{name: "failed flush", maxKeys: 4, failOver: 2, wantCalls: []int{4, 2, 2}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			store := &fakeInserter{keys: map[string]bool{}, failOver: c.failOver}
+			// The interval is long enough that only maxKeys flushes.
+			buf := newWriteBuffer(store, time.Minute, c.maxKeys)
+			go buf.run(ctx)
+
+			requests := [][]string{{"a", "b"}, {"b", "c"}}
+			got := make([][]database.InsertResult, len(requests))
+			var wg sync.WaitGroup
+			for i, keys := range requests {
+				wg.Add(1)
+				go func(i int, keys []string) {
+					defer wg.Done()
+					results, err := buf.insert(ctx, exposuresOf(keys...))
+					if err != nil {
+						t.Errorf("insert %v: %v", keys, err)
+					}
+					got[i] = results
+				}(i, keys)
+			}
+			wg.Wait()
+
+			// Whichever request was buffered first accepted b.
+			accepted := 0
+			for _, results := range got {
+				for _, r := range results {
+					if r == database.InsertAccepted {
+						accepted++
+					}
+				}
+			}
+			if accepted != 3 {
+				t.Errorf("got %d accepted keys, want 3: %v", accepted, got)
+			}
+			if diff := cmp.Diff(c.wantCalls, store.calls); diff != "" {
+				t.Errorf("insert calls mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWriteBufferInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := &fakeInserter{keys: map[string]bool{}}
+	buf := newWriteBuffer(store, 10*time.Millisecond, 1000)
+	go buf.run(ctx)
+
+	results, err := buf.insert(ctx, exposuresOf("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]database.InsertResult{database.InsertAccepted}, results); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestWriteBufferStopped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := &fakeInserter{keys: map[string]bool{}}
+	buf := newWriteBuffer(store, time.Minute, 1000)
+	go buf.run(ctx)
+	cancel()
+	<-buf.stopped
+
+	// Once the buffer stopped, requests insert their exposures on their own.
+	results, err := buf.insert(context.Background(), exposuresOf("a", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]database.InsertResult{database.InsertAccepted, database.InsertDuplicate}, results); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	var unbuffered *writeBuffers
+	if _, err := unbuffered.insert(context.Background(), store, exposuresOf("b")); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int{2, 1}, store.calls); diff != "" {
+		t.Errorf("insert calls mismatch (-want, +got):\n%s", diff)
+	}
+}
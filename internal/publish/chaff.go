@@ -0,0 +1,74 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/revision"
+)
+
+// ChaffHeader is the request header that marks a publish as chaff. Clients
+// send chaff, decoy publishes with made-up keys, on a schedule so that an
+// observer of their traffic can't tell when they publish real keys. Any
+// non-empty value marks the request as chaff.
+//
+// Chaff is parsed and answered like a successful publish, including a
+// revision token, but nothing is written to the database. It is counted in
+// its own metrics.
+const ChaffHeader = "X-Chaff"
+
+// isChaff reports whether r is a chaff request.
+func isChaff(r *http.Request) bool {
+	return r.Header.Get(ChaffHeader) != ""
+}
+
+// handleChaff reads a chaff request the way a real publish is read and returns
+// the number of keys it contains and a revision token that looks like one
+// issued for a real publish. Errors are ignored, a client never learns
+// anything from chaff.
+func (h *publishHandler) handleChaff(w http.ResponseWriter, r *http.Request) (int, string) {
+	ctx := r.Context()
+
+	var data *database.Publish
+	if _, err := h.unmarshal(w, r, &data); err != nil || data == nil {
+		data = &database.Publish{}
+	}
+	return len(data.Keys), h.chaffRevisionToken(ctx, data)
+}
+
+// chaffRevisionToken returns a revision token for the keys in a chaff
+// request. It has the same size as a real token for as many keys, but its ID
+// doesn't refer to any exposures.
+func (h *publishHandler) chaffRevisionToken(ctx context.Context, data *database.Publish) string {
+	id, err := newRevisionTokenID()
+	if err != nil {
+		return ""
+	}
+	if h.revisionTokens == nil {
+		return id
+	}
+	token := &revision.Token{ID: id}
+	for _, k := range data.Keys {
+		token.Keys = append(token.Keys, revision.TokenKey{Key: k.Key, IntervalNumber: k.IntervalNumber, IntervalCount: k.IntervalCount})
+	}
+	encoded, err := h.revisionTokens.MakeRevisionToken(ctx, data.AppPackageName, token)
+	if err != nil {
+		return ""
+	}
+	return encoded
+}
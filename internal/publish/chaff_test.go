@@ -0,0 +1,82 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestChaff(t *testing.T) {
+	ctx := context.Background()
+
+	// The handler has no database, so any attempt to store chaff would panic.
+	h := &publishHandler{
+		config:    &Config{DebugAPIResponses: true},
+		serverenv: serverenv.New(ctx, serverenv.WithMetricsExporter(metrics.NewLogsBasedFromContext)),
+	}
+	body := `{"temporaryExposureKeys": [
+		{"key": "UW7pkDKfLbfLs8LveFyY3w==", "rollingStartNumber": 2649168},
+		{"key": "QLIvVheW9p6JiTx4pslesg==", "rollingStartNumber": 2649312}
+	], "appPackageName": "com.example.app"}`
+
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(ChaffHeader, "1")
+		return r
+	}
+
+	t.Run("v1", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newRequest())
+		if w.Code != http.StatusOK {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusOK)
+		}
+		if w.Header().Get(revisionTokenHeader) == "" {
+			t.Errorf("missing revision token")
+		}
+	})
+
+	t.Run("v2", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		(&publishV2Handler{h}).ServeHTTP(w, newRequest())
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+		}
+		var got PublishV2Response
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.RevisionToken == "" {
+			t.Errorf("missing revision token")
+		}
+		want := []KeyStatus{
+			{Index: 0, Status: StatusAccepted},
+			{Index: 1, Status: StatusAccepted},
+		}
+		if diff := cmp.Diff(want, got.Keys); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	})
+}
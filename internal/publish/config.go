@@ -20,12 +20,16 @@ import (
 
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
 	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/loadshed"
+	"github.com/google/exposure-notifications-server/internal/ratelimit"
+	"github.com/google/exposure-notifications-server/internal/revision"
 	"github.com/google/exposure-notifications-server/internal/setup"
 )
 
 // Compile-time check to assert this config matches requirements.
 var _ setup.AuthorizedAppConfigProvider = (*Config)(nil)
 var _ setup.DBConfigProvider = (*Config)(nil)
+var _ setup.KeyManagerProvider = (*Config)(nil)
 
 // Config represents the configuration and associated environment variables for
 // the publish components.
@@ -33,14 +37,100 @@ type Config struct {
 	Port               string        `envconfig:"PORT" default:"8080"`
 	MinRequestDuration time.Duration `envconfig:"TARGET_REQUEST_DURATION" default:"5s"`
 	MaxKeysOnPublish   int           `envconfig:"MAX_KEYS_ON_PUBLISH" default:"15"`
+	MaxKeysOnPublishV2 int           `envconfig:"MAX_KEYS_ON_PUBLISH_V2" default:"30"`
 	MaxIntervalAge     time.Duration `envconfig:"MAX_INTERVAL_AGE_ON_PUBLISH" default:"360h"`
 	TruncateWindow     time.Duration `envconfig:"TRUNCATE_WINDOW" default:"1h"`
 
+	// HealthPort is the port that the gRPC server serves /healthz and /readyz
+	// on over HTTP.
+	HealthPort string `envconfig:"HEALTH_PORT" default:"8081"`
+
+	// MaxKeysOnBatchPublish and MaxBatchBodyBytes limit the keys in, and size
+	// of, a batch publish request from a health authority. The keys are
+	// inserted BatchInsertSize at a time, all in one transaction.
+	MaxKeysOnBatchPublish int   `envconfig:"MAX_KEYS_ON_BATCH_PUBLISH" default:"10000"`
+	MaxBatchBodyBytes     int64 `envconfig:"MAX_BATCH_BODY_BYTES" default:"4000000"`
+	BatchInsertSize       int   `envconfig:"BATCH_INSERT_SIZE" default:"1000"`
+
+	// BufferInterval, if set, groups the inserts of concurrent publish
+	// requests: their exposures are inserted in one transaction once
+	// BufferInterval has passed since the first of them arrived, or once there
+	// are BufferMaxKeys of them. Each request still waits until its exposures
+	// are committed. Zero inserts each request's exposures on their own.
+	BufferInterval time.Duration `envconfig:"PUBLISH_BUFFER_INTERVAL"`
+	BufferMaxKeys  int           `envconfig:"PUBLISH_BUFFER_MAX_KEYS" default:"500"`
+
+	// TransformStages names, in order, the stages that adjust the exposures
+	// built from each publish request. Deployments can add their own with
+	// database.RegisterTransformStage.
+	TransformStages []string `envconfig:"TRANSFORM_STAGES" default:"regions,report-type-risk"`
+
+	// MaxBodyBytes and MaxJSONDepth limit the size of a publish request body
+	// and the nesting of the JSON in it. Requests that exceed either are
+	// rejected while the body is read.
+	MaxBodyBytes int64 `envconfig:"MAX_BODY_BYTES" default:"64000"`
+	MaxJSONDepth int   `envconfig:"MAX_JSON_DEPTH" default:"8"`
+
+	// DropUnauthorizedRegions selects how regions that an app may not publish
+	// to are handled. By default a publish that lists any such region is
+	// rejected. If true, the regions are dropped and logged, and the keys are
+	// published to the remaining regions.
+	DropUnauthorizedRegions bool `envconfig:"DROP_UNAUTHORIZED_REGIONS" default:"false"`
+
+	// ClockSkewTolerance allows keys that end up to this long after the time
+	// of the publish, for devices whose clocks run ahead of the server's.
+	ClockSkewTolerance time.Duration `envconfig:"CLOCK_SKEW_TOLERANCE" default:"0s"`
+
+	// RequireAlignedKeys rejects keys whose rolling start interval isn't at
+	// the start of a UTC day.
+	RequireAlignedKeys bool `envconfig:"REQUIRE_ALIGNED_KEYS" default:"true"`
+
+	// MaxKeysPerDay is the most keys a single publish may contain that start
+	// on the same UTC day. Authorized apps can override it. Zero means no
+	// limit.
+	MaxKeysPerDay int `envconfig:"MAX_KEYS_PER_DAY" default:"3"`
+
+	// SafetyNetRootsURL serves the PEM encoded root certificates that SafetyNet
+	// attestations must chain to. If empty, the system roots are used.
+	SafetyNetRootsURL           string        `envconfig:"SAFETYNET_ROOTS_URL" default:"https://pki.goog/roots.pem"`
+	SafetyNetRootsCacheDuration time.Duration `envconfig:"SAFETYNET_ROOTS_CACHE_DURATION" default:"24h"`
+
+	// CertificateKeysCacheDuration is how long the keys that health
+	// authorities sign verification certificates with are cached, whether
+	// stored for the health authority or read from an app's key set.
+	CertificateKeysCacheDuration time.Duration `envconfig:"CERTIFICATE_KEYS_CACHE_DURATION" default:"5m"`
+
+	// CertificateReplayProtection rejects verification certificates that have
+	// no ID (jti claim) or whose ID was already used for a publish.
+	CertificateReplayProtection bool `envconfig:"CERTIFICATE_REPLAY_PROTECTION" default:"true"`
+
+	// ResponsePaddingBucketBytes is the size every response is padded up to
+	// a multiple of, so that its size doesn't reveal whether a publish
+	// succeeded. Zero disables padding.
+	ResponsePaddingBucketBytes int `envconfig:"RESPONSE_PADDING_BUCKET_BYTES" default:"2048"`
+
+	// IdempotencyKeyTTL is how long the response to a publish request that
+	// carries an Idempotency-Key header is kept, so that a retry with the same
+	// key gets the same response. Zero disables idempotency keys.
+	IdempotencyKeyTTL time.Duration `envconfig:"IDEMPOTENCY_KEY_TTL" default:"24h"`
+
+	// TLSCertFile and TLSKeyFile enable TLS on the gRPC publish server. They
+	// should be left blank where TLS is terminated by the environment.
+	TLSCertFile string `envconfig:"TLS_CERT_FILE"`
+	TLSKeyFile  string `envconfig:"TLS_KEY_FILE"`
+
 	// Flags for local development and testing.
 	DebugAPIResponses bool `envconfig:"DEBUG_API_RESPONSES"`
 
 	AuthorizedApp *authorizedapp.Config
 	Database      *database.Config
+	RevisionToken *revision.Config
+
+	// RateLimit limits publish requests from each client of an app.
+	RateLimit *ratelimit.Config
+
+	// LoadShed rejects publish requests while the server is overloaded.
+	LoadShed *loadshed.Config
 }
 
 // AuthorizedApp returns the configuration for authorizedapp.
@@ -48,6 +138,12 @@ func (c *Config) AuthorizedAppConfig() *authorizedapp.Config {
 	return c.AuthorizedApp
 }
 
+// KeyManager returns true if the key manager is needed to encrypt revision
+// tokens.
+func (c *Config) KeyManager() bool {
+	return c.RevisionToken.Enabled()
+}
+
 // DB returns the configuration for the databse.
 func (c *Config) DB() *database.Config {
 	return c.Database
@@ -0,0 +1,104 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+// ErrorCode identifies why a publish request, or a key in it, was rejected.
+// Codes are part of the API: clients may branch on them, so existing codes
+// never change meaning and new codes may be added at any time. Clients should
+// treat an unknown code like ErrorInternal.
+type ErrorCode string
+
+// Codes for errors that apply to the whole request.
+const (
+	// ErrorBadRequest means the request body could not be parsed, for example
+	// because its JSON is malformed or nested too deeply.
+	ErrorBadRequest ErrorCode = "ERROR_BAD_REQUEST"
+
+	// ErrorRequestTooLarge means the request body is larger than allowed.
+	ErrorRequestTooLarge ErrorCode = "ERROR_REQUEST_TOO_LARGE"
+
+	// ErrorBadKeyCount means the request has no keys or too many keys.
+	ErrorBadKeyCount ErrorCode = "ERROR_BAD_KEY_COUNT"
+
+	// ErrorInvalidKeys means one or more keys were rejected. The error for each
+	// key is listed in the response.
+	ErrorInvalidKeys ErrorCode = "ERROR_INVALID_KEYS"
+
+	// ErrorUnknownApp means the app is not authorized to publish.
+	ErrorUnknownApp ErrorCode = "ERROR_UNKNOWN_APP"
+
+	// ErrorRegionNotAllowed means the app may not publish to a requested
+	// region.
+	ErrorRegionNotAllowed ErrorCode = "ERROR_REGION_NOT_ALLOWED"
+
+	// ErrorVerificationFailed means the device attestation or the verification
+	// certificate was rejected.
+	ErrorVerificationFailed ErrorCode = "ERROR_VERIFICATION_FAILED"
+
+	// ErrorCertificateReplayed means the verification certificate was already
+	// used for another publish. Each publish needs a new certificate.
+	ErrorCertificateReplayed ErrorCode = "ERROR_CERTIFICATE_REPLAYED"
+
+	// ErrorReportTypeMismatch means the report type in the request differs
+	// from the one in the verification certificate.
+	ErrorReportTypeMismatch ErrorCode = "ERROR_REPORT_TYPE_MISMATCH"
+
+	// ErrorBadRevisionToken means the revision token is invalid or doesn't
+	// allow the requested revision.
+	ErrorBadRevisionToken ErrorCode = "ERROR_BAD_REVISION_TOKEN"
+
+	// ErrorRateLimited means the client sent too many requests. It may retry
+	// after the time given in the Retry-After header.
+	ErrorRateLimited ErrorCode = "ERROR_RATE_LIMITED"
+
+	// ErrorBadIdempotencyKey means the Idempotency-Key header is too long.
+	ErrorBadIdempotencyKey ErrorCode = "ERROR_BAD_IDEMPOTENCY_KEY"
+
+	// ErrorIdempotencyKeyReused means the Idempotency-Key header was already
+	// used for a different request.
+	ErrorIdempotencyKeyReused ErrorCode = "ERROR_IDEMPOTENCY_KEY_REUSED"
+
+	// ErrorBadAPIKey means a batch publish request has no API key, or the key
+	// is unknown or revoked.
+	ErrorBadAPIKey ErrorCode = "ERROR_BAD_API_KEY"
+
+	// ErrorPermissionDenied means the API key may not make the request.
+	ErrorPermissionDenied ErrorCode = "ERROR_PERMISSION_DENIED"
+
+	// ErrorInternal means the server failed. The request may be retried.
+	ErrorInternal ErrorCode = "ERROR_INTERNAL"
+)
+
+// Codes for errors that apply to a single key.
+const (
+	ErrorBadKey              ErrorCode = "ERROR_BAD_KEY"
+	ErrorBadRollingPeriod    ErrorCode = "ERROR_BAD_ROLLING_PERIOD"
+	ErrorBadInterval         ErrorCode = "ERROR_BAD_INTERVAL"
+	ErrorIntervalTooOld      ErrorCode = "ERROR_INTERVAL_TOO_OLD"
+	ErrorIntervalInFuture    ErrorCode = "ERROR_INTERVAL_IN_FUTURE"
+	ErrorKeyStillValid       ErrorCode = "ERROR_KEY_STILL_VALID"
+	ErrorBadTransmissionRisk ErrorCode = "ERROR_BAD_TRANSMISSION_RISK"
+	ErrorBadDaysSinceOnset   ErrorCode = "ERROR_BAD_DAYS_SINCE_ONSET"
+	ErrorOverlappingInterval ErrorCode = "ERROR_OVERLAPPING_INTERVAL"
+	ErrorTooManyKeysPerDay   ErrorCode = "ERROR_TOO_MANY_KEYS_PER_DAY"
+	ErrorKeyConflict         ErrorCode = "ERROR_KEY_CONFLICT"
+)
+
+// ErrorResponse is the body of an error response from the v1 publish API.
+type ErrorResponse struct {
+	Code      ErrorCode  `json:"code"`
+	Error     string     `json:"error"`
+	KeyErrors []KeyError `json:"keyErrors,omitempty"`
+}
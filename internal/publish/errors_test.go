@@ -0,0 +1,109 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/exposure-notifications-server/internal/jsonutil"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+)
+
+func TestErrorCodes(t *testing.T) {
+	ctx := context.Background()
+
+	h := &publishHandler{
+		config:    &Config{DebugAPIResponses: true},
+		serverenv: serverenv.New(ctx, serverenv.WithMetricsExporter(metrics.NewLogsBasedFromContext)),
+		maxKeys:   2,
+	}
+
+	cases := []struct {
+		name    string
+		handler http.Handler
+		body    string
+		status  int
+		code    ErrorCode
+	}{
+		{
+			name:    "v1_bad_json",
+			handler: h,
+			body:    `{"temporaryExposureKeys": [`,
+			status:  http.StatusBadRequest,
+			code:    ErrorBadRequest,
+		},
+		{
+			name:    "v2_bad_json",
+			handler: &publishV2Handler{h},
+			body:    `{"temporaryExposureKeys": [`,
+			status:  http.StatusBadRequest,
+			code:    ErrorBadRequest,
+		},
+		{
+			name:    "v1_too_deep",
+			handler: h,
+			body:    `{"regions": [[[[[[[[["US"]]]]]]]]]}`,
+			status:  http.StatusBadRequest,
+			code:    ErrorBadRequest,
+		},
+		{
+			name:    "v2_too_large",
+			handler: &publishV2Handler{h},
+			body:    `{"padding": "` + strings.Repeat("a", jsonutil.DefaultMaxBodyBytes) + `"}`,
+			status:  http.StatusRequestEntityTooLarge,
+			code:    ErrorRequestTooLarge,
+		},
+		{
+			name:    "v2_no_keys",
+			handler: &publishV2Handler{h},
+			body:    `{"temporaryExposureKeys": [], "appPackageName": "com.example.app"}`,
+			status:  http.StatusBadRequest,
+			code:    ErrorBadKeyCount,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(c.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			c.handler.ServeHTTP(w, r)
+			if w.Code != c.status {
+				t.Errorf("got status %d, want %d", w.Code, c.status)
+			}
+
+			var got struct {
+				Code  ErrorCode `json:"code"`
+				Error string    `json:"error"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Code != c.code {
+				t.Errorf("got code %q, want %q", got.Code, c.code)
+			}
+			if got.Error == "" {
+				t.Errorf("missing error message")
+			}
+		})
+	}
+}
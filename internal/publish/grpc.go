@@ -0,0 +1,252 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/handlers"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	pb "github.com/google/exposure-notifications-server/internal/pb/publish"
+	"github.com/google/exposure-notifications-server/internal/ratelimit"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Metadata keys of the gRPC publish API. They mirror the headers of the HTTP
+// API.
+const (
+	grpcAuthHeader           = "authorization"
+	grpcIdempotencyKeyHeader = "idempotency-key"
+	grpcChaffHeader          = "x-chaff"
+
+	// GRPCErrorCodeTrailer is the trailer that carries the ErrorCode of a
+	// failed request.
+	GRPCErrorCodeTrailer = "error-code"
+
+	bearer = "Bearer "
+)
+
+// Compile-time check to assert implementation.
+var _ pb.PublishServer = (*GRPCServer)(nil)
+
+// GRPCServer implements the gRPC publish API. Requests are verified,
+// validated and stored by the same code as the v2 HTTP API, and like it the
+// response reports the status of each key.
+type GRPCServer struct {
+	h *publishV2Handler
+}
+
+// NewGRPCServer creates the server for the gRPC publish API.
+func NewGRPCServer(ctx context.Context, config *Config, env *serverenv.ServerEnv) (*GRPCServer, error) {
+	h, err := newPublishHandler(ctx, config, env, config.MaxKeysOnPublishV2)
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCServer{h: &publishV2Handler{h}}, nil
+}
+
+// Publish verifies and stores the keys in the request.
+func (s *GRPCServer) Publish(ctx context.Context, req *pb.PublishRequest) (*pb.PublishResponse, error) {
+	data := publishFromProto(req)
+
+	var resp response
+	if incomingHeader(ctx, grpcChaffHeader) != "" {
+		statuses := make([]KeyStatus, len(data.Keys))
+		for i := range statuses {
+			statuses[i] = KeyStatus{Index: i, Status: StatusAccepted}
+		}
+		resp = response{status: http.StatusOK, metric: "publish-grpc-chaff", count: 1, revisionToken: s.h.chaffRevisionToken(ctx, data), keyStatuses: statuses}
+	} else {
+		resp = s.h.publish(ctx, ratelimit.GRPCClientIP(ctx, s.h.trustedProxies), incomingHeader(ctx, grpcIdempotencyKeyHeader), data)
+	}
+
+	s.h.recordResponse(ctx, resp)
+
+	if resp.status != http.StatusOK {
+		// As with the HTTP API, most errors are hidden in production.
+		if !s.h.config.DebugAPIResponses && !resp.errorInProd {
+			return s.pad(ctx, &pb.PublishResponse{}), nil
+		}
+		trailer := metadata.Pairs(GRPCErrorCodeTrailer, string(resp.code))
+		if resp.retryAfter > 0 {
+			trailer.Set("retry-after", ratelimit.RetryAfter(resp.retryAfter))
+		}
+		if err := grpc.SetTrailer(ctx, trailer); err != nil {
+			logging.FromContext(ctx).Errorf("failed to set trailer: %v", err)
+		}
+		return nil, status.Error(grpcCode(resp.status), resp.message)
+	}
+
+	out := &pb.PublishResponse{
+		RevisionToken: resp.revisionToken,
+	}
+	for _, ks := range resp.keyStatuses {
+		out.Keys = append(out.Keys, &pb.KeyStatus{
+			Index:   int32(ks.Index),
+			Status:  ks.Status,
+			Code:    string(ks.Code),
+			Message: ks.Message,
+		})
+	}
+	return s.pad(ctx, out), nil
+}
+
+// pad sets the padding of out so that it encodes to a multiple of the
+// configured bucket size, like WithPadding does for HTTP responses.
+func (s *GRPCServer) pad(ctx context.Context, out *pb.PublishResponse) *pb.PublishResponse {
+	bucketBytes := s.h.config.ResponsePaddingBucketBytes
+	if bucketBytes <= 0 {
+		return out
+	}
+	out.Padding = ""
+	size := proto.Size(out)
+
+	// The padding field adds a tag byte and a length varint of one to three
+	// bytes, so try each until the padded size lands on a bucket boundary.
+	for target := handlers.PaddedSize(size+2, bucketBytes); ; target += bucketBytes {
+		padding, err := handlers.RandomPadding(target - size - 2)
+		if err != nil {
+			logging.FromContext(ctx).Errorf("failed to generate response padding: %v", err)
+			return out
+		}
+		for n := len(padding); n >= 0 && n >= len(padding)-2; n-- {
+			out.Padding = padding[:n]
+			if proto.Size(out) == target {
+				return out
+			}
+		}
+	}
+}
+
+// AuthInterceptor authenticates the app that sent a request. The app must
+// present its API key as a bearer token, and the request is rejected unless
+// the key's hash matches the one configured for the app.
+func (s *GRPCServer) AuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	logger := logging.FromContext(ctx)
+	metrics := s.h.serverenv.MetricsExporter(ctx)
+
+	r, ok := req.(*pb.PublishRequest)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unexpected request %T", req)
+	}
+
+	auth := incomingHeader(ctx, grpcAuthHeader)
+	if !strings.HasPrefix(auth, bearer) {
+		metrics.WriteInt("publish-grpc-missing-api-key", true, 1)
+		return nil, status.Errorf(codes.Unauthenticated, "missing API key")
+	}
+
+	app, err := s.h.authorizedAppProvider.AppConfig(ctx, r.AppPackageName)
+	if err != nil {
+		if err == authorizedapp.AppNotFound {
+			metrics.WriteInt("publish-grpc-app-not-authorized", true, 1)
+			return nil, status.Errorf(codes.Unauthenticated, "unauthorized app: %v", r.AppPackageName)
+		}
+		logger.Errorf("no AuthorizedApp: %v", err)
+		metrics.WriteInt("publish-grpc-auth-error", true, 1)
+		return nil, status.Errorf(codes.Internal, "internal error")
+	}
+
+	if !validAPIKey(strings.TrimPrefix(auth, bearer), app.GRPCAPIKeySHA256) {
+		logger.Infof("Invalid API key for %v", r.AppPackageName)
+		metrics.WriteInt("publish-grpc-invalid-api-key", true, 1)
+		return nil, status.Errorf(codes.Unauthenticated, "invalid API key")
+	}
+	return handler(ctx, req)
+}
+
+// validAPIKey returns true if key hashes to wantSHA256. No key is valid if
+// wantSHA256 is empty.
+func validAPIKey(key, wantSHA256 string) bool {
+	want, err := hex.DecodeString(wantSHA256)
+	if err != nil || len(want) != sha256.Size {
+		return false
+	}
+	got := sha256.Sum256([]byte(key))
+	return subtle.ConstantTimeCompare(got[:], want) == 1
+}
+
+// publishFromProto converts a gRPC publish request to the form the HTTP API
+// parses its JSON body into.
+func publishFromProto(req *pb.PublishRequest) *database.Publish {
+	data := &database.Publish{
+		Regions:                   req.Regions,
+		AppPackageName:            req.AppPackageName,
+		Platform:                  req.Platform,
+		DeviceVerificationPayload: req.DeviceVerificationPayload,
+		VerificationPayload:       req.VerificationPayload,
+		Padding:                   req.Padding,
+		ReportType:                req.ReportType,
+		RevisionToken:             req.RevisionToken,
+		HMACKey:                   req.Hmackey,
+		Traveler:                  req.Traveler,
+		SymptomOnsetInterval:      req.SymptomOnsetInterval,
+	}
+	for _, k := range req.TemporaryExposureKeys {
+		key := database.ExposureKey{
+			Key:              k.Key,
+			IntervalNumber:   k.RollingStartNumber,
+			IntervalCount:    k.RollingPeriod,
+			TransmissionRisk: int(k.TransmissionRisk),
+		}
+		if k.HasDaysSinceOnsetOfSymptoms {
+			ds := k.DaysSinceOnsetOfSymptoms
+			key.DaysSinceOnsetOfSymptoms = &ds
+		}
+		data.Keys = append(data.Keys, key)
+	}
+	return data
+}
+
+// grpcCode returns the gRPC status code for an HTTP status.
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusUnprocessableEntity:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	default:
+		return codes.Internal
+	}
+}
+
+// incomingHeader returns the first value of the metadata key, or an empty
+// string.
+func incomingHeader(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	pb "github.com/google/exposure-notifications-server/internal/pb/publish"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPublishFromProto(t *testing.T) {
+	req := &pb.PublishRequest{
+		TemporaryExposureKeys: []*pb.TemporaryExposureKey{
+			{Key: "UW7pkDKfLbfLs8LveFyY3w==", RollingStartNumber: 2649168, RollingPeriod: 144, TransmissionRisk: 3},
+			{Key: "QLIvVheW9p6JiTx4pslesg==", RollingStartNumber: 2649312, RollingPeriod: 144, DaysSinceOnsetOfSymptoms: -2, HasDaysSinceOnsetOfSymptoms: true},
+		},
+		Regions:              []string{"US"},
+		AppPackageName:       "com.example.app",
+		Platform:             "android",
+		ReportType:           "confirmed",
+		Hmackey:              "aG1hYw==",
+		Traveler:             true,
+		SymptomOnsetInterval: 2649024,
+	}
+	ds := int32(-2)
+	want := &database.Publish{
+		Keys: []database.ExposureKey{
+			{Key: "UW7pkDKfLbfLs8LveFyY3w==", IntervalNumber: 2649168, IntervalCount: 144, TransmissionRisk: 3},
+			{Key: "QLIvVheW9p6JiTx4pslesg==", IntervalNumber: 2649312, IntervalCount: 144, DaysSinceOnsetOfSymptoms: &ds},
+		},
+		Regions:              []string{"US"},
+		AppPackageName:       "com.example.app",
+		Platform:             "android",
+		ReportType:           "confirmed",
+		HMACKey:              "aG1hYw==",
+		Traveler:             true,
+		SymptomOnsetInterval: 2649024,
+	}
+	if diff := cmp.Diff(want, publishFromProto(req)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	ctx := context.Background()
+
+	provider, err := authorizedapp.NewMemoryProvider(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps := provider.(*authorizedapp.MemoryProvider).Data
+	// SHA-256 of "test".
+	apps["com.example.app"] = &model.AuthorizedApp{GRPCAPIKeySHA256: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}
+	apps["com.example.nokey"] = &model.AuthorizedApp{}
+
+	s := &GRPCServer{h: &publishV2Handler{&publishHandler{
+		serverenv:             serverenv.New(ctx, serverenv.WithMetricsExporter(metrics.NewLogsBasedFromContext)),
+		authorizedAppProvider: provider,
+	}}}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &pb.PublishResponse{}, nil
+	}
+
+	cases := []struct {
+		name string
+		app  string
+		auth string
+		code codes.Code
+	}{
+		{name: "valid", app: "com.example.app", auth: "Bearer test", code: codes.OK},
+		{name: "wrong_key", app: "com.example.app", auth: "Bearer nope", code: codes.Unauthenticated},
+		{name: "missing_key", app: "com.example.app", code: codes.Unauthenticated},
+		{name: "no_key_configured", app: "com.example.nokey", auth: "Bearer test", code: codes.Unauthenticated},
+		{name: "unknown_app", app: "com.example.unknown", auth: "Bearer test", code: codes.Unauthenticated},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			ctx := ctx
+			if c.auth != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(grpcAuthHeader, c.auth))
+			}
+			_, err := s.AuthInterceptor(ctx, &pb.PublishRequest{AppPackageName: c.app}, &grpc.UnaryServerInfo{}, handler)
+			if got := status.Code(err); got != c.code {
+				t.Errorf("got code %v, want %v (%v)", got, c.code, err)
+			}
+		})
+	}
+}
+
+func TestGRPCChaff(t *testing.T) {
+	ctx := context.Background()
+
+	// The server has no database, so any attempt to store chaff would panic.
+	s := &GRPCServer{h: &publishV2Handler{&publishHandler{
+		config:    &Config{ResponsePaddingBucketBytes: 256},
+		serverenv: serverenv.New(ctx, serverenv.WithMetricsExporter(metrics.NewLogsBasedFromContext)),
+	}}}
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(grpcChaffHeader, "1"))
+	resp, err := s.Publish(ctx, &pb.PublishRequest{
+		TemporaryExposureKeys: []*pb.TemporaryExposureKey{
+			{Key: "UW7pkDKfLbfLs8LveFyY3w==", RollingStartNumber: 2649168},
+		},
+		AppPackageName: "com.example.app",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.RevisionToken == "" {
+		t.Errorf("missing revision token")
+	}
+	if n := proto.Size(resp); n != 256 {
+		t.Errorf("got a %d byte response, want it padded to 256", n)
+	}
+	if len(resp.Keys) != 1 || resp.Keys[0].Status != StatusAccepted {
+		t.Errorf("got key statuses %v, want one accepted key", resp.Keys)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+)
+
+const (
+	// IdempotencyKeyHeader is the header that clients set to a unique value
+	// per upload. Retrying an upload with the same key returns the original
+	// response, including its revision token, rather than publishing again.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// storedResponse is the part of a response that is replayed for a request
+// with a known idempotency key.
+type storedResponse struct {
+	Status        int         `json:"status"`
+	Message       string      `json:"message"`
+	RevisionToken string      `json:"revisionToken,omitempty"`
+	KeyErrors     []KeyError  `json:"keyErrors,omitempty"`
+	KeyStatuses   []KeyStatus `json:"keyStatuses,omitempty"`
+}
+
+// idempotencyStore stores the responses to publish requests with idempotency
+// keys.
+type idempotencyStore interface {
+	GetIdempotencyRecord(ctx context.Context, appPackageName, certificateHash, key string, since time.Time) (*database.IdempotencyRecord, error)
+	SaveIdempotencyRecord(ctx context.Context, rec *database.IdempotencyRecord, expiredBefore time.Time) error
+}
+
+// idempotentRequest identifies a publish request that carried an idempotency
+// key.
+type idempotentRequest struct {
+	appPackageName  string
+	certificateHash string
+	key             string
+	requestHash     string
+}
+
+// newIdempotentRequest identifies data, a request with the given idempotency
+// key. It must be called before the request is authorized, which may modify
+// data. It returns nil if key is empty or idempotency keys are disabled. On
+// failure it returns the response to send.
+func (h *publishHandler) newIdempotentRequest(ctx context.Context, key string, data *database.Publish) (*idempotentRequest, *response) {
+	if key == "" || h.config.IdempotencyKeyTTL <= 0 {
+		return nil, nil
+	}
+
+	logger := logging.FromContext(ctx)
+	if len(key) > maxIdempotencyKeyLength {
+		message := fmt.Sprintf("%s header must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength)
+		logger.Error(message)
+		return nil, &response{status: http.StatusBadRequest, code: ErrorBadIdempotencyKey, message: message, metric: "publish-idempotency-key-invalid", count: 1, errorInProd: true}
+	}
+
+	hash, err := requestHash(data)
+	if err != nil {
+		logger.Errorf("error hashing request: %v", err)
+		return nil, &response{status: http.StatusInternalServerError, code: ErrorInternal, message: http.StatusText(http.StatusInternalServerError), metric: "publish-idempotency-error", count: 1, errorInProd: true}
+	}
+
+	req := &idempotentRequest{
+		appPackageName: data.AppPackageName,
+		key:            key,
+		requestHash:    hash,
+	}
+	if data.VerificationPayload != "" {
+		sum := sha256.Sum256([]byte(data.VerificationPayload))
+		req.certificateHash = hex.EncodeToString(sum[:])
+	}
+	return req, nil
+}
+
+// withIdempotency calls publish unless req is nil or was already published
+// with the same app, verification certificate and idempotency key, in which
+// case the original response is returned. It must only be called once the
+// request is authorized and rate limited, so that a response is only
+// replayed to a client that could have published the request itself. Only
+// successful responses are stored, so a failed request can be retried with
+// the same key.
+func (h *publishHandler) withIdempotency(ctx context.Context, req *idempotentRequest, publish func() response) response {
+	if req == nil {
+		return publish()
+	}
+
+	logger := logging.FromContext(ctx)
+	now := time.Now().UTC()
+	expiredBefore := now.Add(-h.config.IdempotencyKeyTTL)
+	rec, err := h.idempotency.GetIdempotencyRecord(ctx, req.appPackageName, req.certificateHash, req.key, expiredBefore)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+	case err != nil:
+		logger.Errorf("error reading idempotency record: %v", err)
+		return response{status: http.StatusInternalServerError, code: ErrorInternal, message: http.StatusText(http.StatusInternalServerError), metric: "publish-idempotency-error", count: 1, errorInProd: true}
+	case rec.RequestHash != req.requestHash:
+		message := fmt.Sprintf("%s was already used for a different request", IdempotencyKeyHeader)
+		logger.Error(message)
+		return response{status: http.StatusUnprocessableEntity, code: ErrorIdempotencyKeyReused, message: message, metric: "publish-idempotency-key-reused", count: 1, errorInProd: true}
+	default:
+		var stored storedResponse
+		if err := json.Unmarshal(rec.Response, &stored); err != nil {
+			logger.Errorf("error reading stored response: %v", err)
+			return response{status: http.StatusInternalServerError, code: ErrorInternal, message: http.StatusText(http.StatusInternalServerError), metric: "publish-idempotency-error", count: 1, errorInProd: true}
+		}
+		logger.Infof("Replaying response for %s %q", IdempotencyKeyHeader, req.key)
+		return response{
+			status:        stored.Status,
+			message:       stored.Message,
+			metric:        "publish-idempotent-replay",
+			count:         1,
+			revisionToken: stored.RevisionToken,
+			keyErrors:     stored.KeyErrors,
+			keyStatuses:   stored.KeyStatuses,
+		}
+	}
+
+	resp := publish()
+	if resp.status != http.StatusOK {
+		return resp
+	}
+
+	b, err := json.Marshal(storedResponse{
+		Status:        resp.status,
+		Message:       resp.message,
+		RevisionToken: resp.revisionToken,
+		KeyErrors:     resp.keyErrors,
+		KeyStatuses:   resp.keyStatuses,
+	})
+	if err == nil {
+		err = h.idempotency.SaveIdempotencyRecord(ctx, &database.IdempotencyRecord{
+			AppPackageName:  req.appPackageName,
+			CertificateHash: req.certificateHash,
+			Key:             req.key,
+			RequestHash:     req.requestHash,
+			Response:        b,
+			CreatedAt:       now,
+		}, expiredBefore)
+	}
+	if err != nil {
+		// The keys were published. A retry is published again, which the
+		// deduplication of exposures makes harmless.
+		logger.Errorf("error saving idempotency record: %v", err)
+		h.serverenv.MetricsExporter(ctx).WriteInt("publish-idempotency-save-failed", true, 1)
+	}
+	return resp
+}
+
+// requestHash returns a hash that identifies the content of a publish request.
+func requestHash(data *database.Publish) (string, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/ratelimit"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+)
+
+// fakeIdempotency stores idempotency records in memory.
+type fakeIdempotency struct {
+	records map[string]*database.IdempotencyRecord
+	reads   int
+}
+
+func (f *fakeIdempotency) GetIdempotencyRecord(ctx context.Context, appPackageName, certificateHash, key string, since time.Time) (*database.IdempotencyRecord, error) {
+	f.reads++
+	rec, ok := f.records[appPackageName+"|"+certificateHash+"|"+key]
+	if !ok || !rec.CreatedAt.After(since) {
+		return nil, database.ErrNotFound
+	}
+	return rec, nil
+}
+
+func (f *fakeIdempotency) SaveIdempotencyRecord(ctx context.Context, rec *database.IdempotencyRecord, expiredBefore time.Time) error {
+	f.records[rec.AppPackageName+"|"+rec.CertificateHash+"|"+rec.Key] = rec
+	return nil
+}
+
+// denyRateLimit is a rate limit store with no tokens.
+type denyRateLimit struct{}
+
+func (denyRateLimit) Take(ctx context.Context, key string, limit ratelimit.Limit) (bool, time.Duration, error) {
+	return false, time.Minute, nil
+}
+
+func TestIdempotencyAfterAuthorization(t *testing.T) {
+	ctx := context.Background()
+
+	data := &database.Publish{AppPackageName: "com.example.app", VerificationPayload: "certificate"}
+	store := &fakeIdempotency{records: make(map[string]*database.IdempotencyRecord)}
+	apps := &authorizedapp.MemoryProvider{Data: map[string]*model.AuthorizedApp{}}
+	h := &publishHandler{
+		config:                &Config{IdempotencyKeyTTL: time.Hour},
+		serverenv:             serverenv.New(ctx, serverenv.WithMetricsExporter(metrics.NewLogsBasedFromContext)),
+		idempotency:           store,
+		authorizedAppProvider: apps,
+		rateLimiter:           denyRateLimit{},
+	}
+
+	// Store a response for the request, as if it was published before.
+	req, resp := h.newIdempotentRequest(ctx, "key-1", data)
+	if resp != nil {
+		t.Fatalf("newIdempotentRequest: %+v", resp)
+	}
+	if got := h.withIdempotency(ctx, req, func() response { return response{status: http.StatusOK, revisionToken: "token"} }); got.revisionToken != "token" {
+		t.Fatalf("got revision token %q, want %q", got.revisionToken, "token")
+	}
+	store.reads = 0
+
+	// The stored response isn't replayed to an unauthorized app...
+	got := h.publish(ctx, "127.0.0.1", "key-1", data)
+	if got.code != ErrorUnknownApp {
+		t.Errorf("unknown app: got code %q, want %q", got.code, ErrorUnknownApp)
+	}
+
+	// ...or a rate limited client.
+	apps.Data[data.AppPackageName] = &model.AuthorizedApp{AppPackageName: data.AppPackageName}
+	got = h.publish(ctx, "127.0.0.1", "key-1", data)
+	if got.code != ErrorRateLimited {
+		t.Errorf("rate limited: got code %q, want %q", got.code, ErrorRateLimited)
+	}
+
+	if store.reads != 0 {
+		t.Errorf("idempotency records were read %d times before the request was authorized", store.reads)
+	}
+}
+
+func TestIdempotencyScopedToCertificate(t *testing.T) {
+	ctx := context.Background()
+
+	h := &publishHandler{
+		config:      &Config{IdempotencyKeyTTL: time.Hour},
+		serverenv:   serverenv.New(ctx, serverenv.WithMetricsExporter(metrics.NewLogsBasedFromContext)),
+		idempotency: &fakeIdempotency{records: make(map[string]*database.IdempotencyRecord)},
+	}
+
+	published := 0
+	publish := func() response {
+		published++
+		return response{status: http.StatusOK}
+	}
+	withKey := func(certificate string) response {
+		t.Helper()
+		data := &database.Publish{AppPackageName: "com.example.app", VerificationPayload: certificate}
+		req, resp := h.newIdempotentRequest(ctx, "key-1", data)
+		if resp != nil {
+			t.Fatalf("newIdempotentRequest: %+v", resp)
+		}
+		return h.withIdempotency(ctx, req, publish)
+	}
+
+	withKey("certificate-1")
+	// The same key with another certificate is a different request.
+	if got := withKey("certificate-2"); got.metric == "publish-idempotent-replay" {
+		t.Errorf("response for another certificate was replayed")
+	}
+	if got := withKey("certificate-1"); got.metric != "publish-idempotent-replay" {
+		t.Errorf("retry with the same certificate was not replayed")
+	}
+	if published != 2 {
+		t.Errorf("published %d times, want 2", published)
+	}
+}
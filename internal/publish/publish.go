@@ -17,20 +17,39 @@ package publish
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/google/exposure-notifications-server/internal/android"
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
+	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
 	"github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/jsonutil"
 	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/observability"
+	"github.com/google/exposure-notifications-server/internal/ratelimit"
+	"github.com/google/exposure-notifications-server/internal/requestlog"
+	"github.com/google/exposure-notifications-server/internal/revision"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
 	"github.com/google/exposure-notifications-server/internal/verification"
+
+	"go.opencensus.io/trace"
 )
 
 // NewHandler creates the HTTP handler for the TTK publishing API.
 func NewHandler(ctx context.Context, config *Config, env *serverenv.ServerEnv) (http.Handler, error) {
+	return newPublishHandler(ctx, config, env, config.MaxKeysOnPublish)
+}
+
+func newPublishHandler(ctx context.Context, config *Config, env *serverenv.ServerEnv, maxKeys int) (*publishHandler, error) {
 	logger := logging.FromContext(ctx)
 
 	if env.Database() == nil {
@@ -40,52 +59,369 @@ func NewHandler(ctx context.Context, config *Config, env *serverenv.ServerEnv) (
 		return nil, fmt.Errorf("missing AuthorizedApp provider in server environment")
 	}
 
-	transformer, err := database.NewTransformer(config.MaxKeysOnPublish, config.MaxIntervalAge, config.TruncateWindow)
+	transformer, err := newTransformer(config, maxKeys)
 	if err != nil {
-		return nil, fmt.Errorf("database.NewTransformer: %w", err)
+		return nil, err
 	}
-	logger.Infof("max keys per upload: %v", config.MaxKeysOnPublish)
+	logger.Infof("max keys per upload: %v", maxKeys)
 	logger.Infof("max interval start age: %v", config.MaxIntervalAge)
 	logger.Infof("truncate window: %v", config.TruncateWindow)
+	logger.Infof("clock skew tolerance: %v", config.ClockSkewTolerance)
+	logger.Infof("transform stages: %v", config.TransformStages)
+
+	var safetyNetRoots *android.RootCache
+	if config.SafetyNetRootsURL != "" {
+		safetyNetRoots = android.NewRootCache(config.SafetyNetRootsURL, config.SafetyNetRootsCacheDuration)
+		logger.Infof("safetynet roots: %v", config.SafetyNetRootsURL)
+	} else {
+		logger.Warnf("SAFETYNET_ROOTS_URL is not set, using system roots to verify SafetyNet attestations")
+	}
+
+	var revisionTokens *revision.TokenManager
+	if config.RevisionToken.Enabled() {
+		wrapper, ok := env.KeyManager().(database.KeyWrapper)
+		if !ok {
+			return nil, fmt.Errorf("key manager %T cannot wrap revision token keys", env.KeyManager())
+		}
+		revisionTokens, err = revision.New(ctx, env.Database(), wrapper, config.RevisionToken)
+		if err != nil {
+			return nil, fmt.Errorf("revision.New: %w", err)
+		}
+		logger.Infof("revision tokens are encrypted with keys wrapped by %v", config.RevisionToken.KeyID)
+	}
+
+	var rateLimiter ratelimit.Store
+	var rateLimit ratelimit.Limit
+	var trustedProxies int
+	if config.RateLimit != nil {
+		rateLimiter, err = ratelimit.New(ctx, config.RateLimit)
+		if err != nil {
+			return nil, fmt.Errorf("ratelimit.New: %w", err)
+		}
+		rateLimit = config.RateLimit.Limit()
+		trustedProxies = config.RateLimit.TrustedProxies
+		logger.Infof("rate limit: %v requests per %v (%v)", rateLimit.Tokens, rateLimit.Interval, config.RateLimit.Type)
+	}
+
+	buffers := newWriteBuffers(ctx, config)
+	if buffers != nil {
+		logger.Infof("publish inserts are buffered for up to %v or %d keys", config.BufferInterval, config.BufferMaxKeys)
+	}
+
+	certificateKeys := verification.NewHealthAuthorityKeys(env.Database(), config.CertificateKeysCacheDuration)
+	env.RuntimeConfig().OnChange(database.ConfigTableHealthAuthorityKey, func(context.Context) {
+		certificateKeys.InvalidateAll()
+	})
 
 	return &publishHandler{
 		serverenv:             env,
 		transformer:           transformer,
+		validator:             newValidator(config, env.RuntimeConfig()),
+		maxKeys:               maxKeys,
+		safetyNetRoots:        safetyNetRoots,
+		certificateKeys:       certificateKeys,
+		revisionTokens:        revisionTokens,
+		rateLimiter:           rateLimiter,
+		rateLimit:             rateLimit,
+		trustedProxies:        trustedProxies,
 		config:                config,
 		database:              env.Database(),
+		certificates:          env.Database(),
+		idempotency:           env.Database(),
+		buffers:               buffers,
 		authorizedAppProvider: env.AuthorizedAppProvider(),
 	}, nil
 }
 
+// newTransformer creates the transformer for publish requests with at most
+// maxKeys keys, running the configured transform stages.
+func newTransformer(config *Config, maxKeys int) (*database.Transformer, error) {
+	stages, err := database.TransformStagesByName(config.TransformStages)
+	if err != nil {
+		return nil, fmt.Errorf("database.TransformStagesByName: %w", err)
+	}
+	transformer, err := database.NewTransformer(maxKeys, config.MaxIntervalAge, config.TruncateWindow,
+		database.WithClockSkewTolerance(config.ClockSkewTolerance),
+		database.WithTransformStages(stages...))
+	if err != nil {
+		return nil, fmt.Errorf("database.NewTransformer: %w", err)
+	}
+	return transformer, nil
+}
+
 type publishHandler struct {
 	config                *Config
 	serverenv             *serverenv.ServerEnv
 	transformer           *database.Transformer
+	validator             *validator
+	maxKeys               int
+	safetyNetRoots        *android.RootCache
+	certificateKeys       verification.CertificateKeys
+	revisionTokens        *revision.TokenManager
+	rateLimiter           ratelimit.Store
+	rateLimit             ratelimit.Limit
+	trustedProxies        int
 	database              *database.DB
+	certificates          certificateClaimer
+	idempotency           idempotencyStore
+	buffers               *writeBuffers
 	authorizedAppProvider authorizedapp.Provider
 }
 
+// certificateClaimer records which verification certificates were used.
+type certificateClaimer interface {
+	ClaimCertificate(ctx context.Context, issuer, jti string, expiresAt time.Time) error
+	ReleaseCertificate(ctx context.Context, issuer, jti string) error
+}
+
+// certificateReleaseTimeout bounds the release of a certificate claim after a
+// publish fails.
+const certificateReleaseTimeout = 5 * time.Second
+
 type response struct {
-	status      int
-	message     string
-	metric      string
-	count       int // metricCount
-	errorInProd bool
+	status        int
+	code          ErrorCode
+	message       string
+	metric        string
+	count         int // metricCount
+	errorInProd   bool
+	revisionToken string
+	retryAfter    time.Duration
+	keyErrors     []KeyError
+	keyStatuses   []KeyStatus
+}
+
+// revisionTokenHeader is the response header that carries the revision token
+// a client must present to later revise the keys it published.
+const revisionTokenHeader = "X-Revision-Token"
+
+// newRevisionTokenID generates a random revision token ID.
+func newRevisionTokenID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newRevisionToken assigns a new revision token ID to exposures and returns
+// the token for the client. If revision tokens are encrypted, the token lists
+// the exposures' keys; otherwise the token is the ID itself.
+func (h *publishHandler) newRevisionToken(ctx context.Context, appPackageName string, exposures []*database.Exposure) (string, error) {
+	id, err := newRevisionTokenID()
+	if err != nil {
+		return "", err
+	}
+	for _, exp := range exposures {
+		exp.RevisionToken = id
+	}
+	return h.issueRevisionToken(ctx, appPackageName, &revision.Token{ID: id}, exposures)
+}
+
+// issueRevisionToken adds the keys of exposures to token and returns it in
+// the form sent to the client.
+func (h *publishHandler) issueRevisionToken(ctx context.Context, appPackageName string, token *revision.Token, exposures []*database.Exposure) (string, error) {
+	if h.revisionTokens == nil {
+		return token.ID, nil
+	}
+	token.AddKeys(exposures)
+	return h.revisionTokens.MakeRevisionToken(ctx, appPackageName, token)
+}
+
+// openRevisionToken returns the content of a revision token presented by a
+// client. Tokens issued while encryption was disabled are their own ID.
+func (h *publishHandler) openRevisionToken(ctx context.Context, appPackageName, encoded string) (*revision.Token, error) {
+	if h.revisionTokens == nil || !revision.IsEncrypted(encoded) {
+		return &revision.Token{ID: encoded}, nil
+	}
+	return h.revisionTokens.UnmarshalRevisionToken(ctx, encoded, appPackageName)
+}
+
+// reviseExposures revises exposures published with the revision token
+// encoded. It returns the number of existing exposures revised and a new token
+// that also covers any keys added by the revision. Errors caused by the client
+// wrap database.ErrInvalidRevisionToken or
+// database.ErrInvalidReportTypeTransition. The exposures are revised in store,
+// the database that stores them.
+func (h *publishHandler) reviseExposures(ctx context.Context, store *database.DB, appPackageName, encoded string, exposures []*database.Exposure) (int, string, error) {
+	token, err := h.openRevisionToken(ctx, appPackageName, encoded)
+	if err != nil {
+		return 0, "", err
+	}
+	revised, err := store.ReviseExposures(ctx, token.ID, exposures)
+	if err != nil {
+		return 0, "", err
+	}
+	reissued, err := h.issueRevisionToken(ctx, appPackageName, token, exposures)
+	if err != nil {
+		return 0, "", fmt.Errorf("issuing revision token: %w", err)
+	}
+	return revised, reissued, nil
 }
 
 func (h *publishHandler) handleRequest(w http.ResponseWriter, r *http.Request) response {
 	ctx := r.Context()
 	logger := logging.FromContext(ctx)
 
+	if isChaff(r) {
+		_, token := h.handleChaff(w, r)
+		return response{status: http.StatusOK, message: "Received chaff.", metric: "publish-chaff", count: 1, revisionToken: token}
+	}
+
 	var data *database.Publish
-	code, err := jsonutil.Unmarshal(w, r, &data)
+	code, err := h.unmarshal(w, r, &data)
 	if err != nil {
 		// Log the unparsable JSON, but return success to the client.
 		message := fmt.Sprintf("error unmarshalling API call, code: %v: %v", code, err)
 		logger.Error(message)
-		return response{status: http.StatusBadRequest, message: message, metric: "publish-bad-json", count: 1}
+		if code == http.StatusRequestEntityTooLarge {
+			return response{status: code, code: ErrorRequestTooLarge, message: message, metric: "publish-body-too-large", count: 1}
+		}
+		return response{status: http.StatusBadRequest, code: ErrorBadRequest, message: message, metric: "publish-bad-json", count: 1}
+	}
+
+	return h.publish(ctx, ratelimit.ClientIP(r, h.trustedProxies), r.Header.Get(IdempotencyKeyHeader), data)
+}
+
+// unmarshal parses the JSON body of a publish request, enforcing the
+// configured limits on its size and nesting.
+func (h *publishHandler) unmarshal(w http.ResponseWriter, r *http.Request, data interface{}) (int, error) {
+	maxBodyBytes, maxDepth := h.config.MaxBodyBytes, h.config.MaxJSONDepth
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = jsonutil.DefaultMaxBodyBytes
+	}
+	if maxDepth <= 0 {
+		maxDepth = jsonutil.DefaultMaxDepth
+	}
+	return jsonutil.UnmarshalLimited(w, r, data, maxBodyBytes, maxDepth)
+}
+
+// publish verifies and stores the keys of a parsed publish request, which
+// carried idempotencyKey if it isn't empty.
+func (h *publishHandler) publish(ctx context.Context, clientIP, idempotencyKey string, data *database.Publish) response {
+	ctx, span := observability.StartSpan(ctx, "publish.publish")
+	defer span.End()
+	span.AddAttributes(
+		trace.StringAttribute("app", data.AppPackageName),
+		trace.Int64Attribute("keys", int64(len(data.Keys))))
+
+	idempotent, resp := h.newIdempotentRequest(ctx, idempotencyKey, data)
+	if resp != nil {
+		return *resp
+	}
+	appConfig, claims, resp := h.authorize(ctx, data, clientIP)
+	if resp != nil {
+		span.SetStatus(trace.Status{Code: trace.StatusCodePermissionDenied, Message: resp.message})
+		return *resp
+	}
+
+	return h.withIdempotency(ctx, idempotent, func() response {
+		return h.withCertificateClaim(ctx, claims, func() response {
+			return h.publishKeys(ctx, appConfig, data)
+		})
+	})
+}
+
+// publishKeys validates and stores the keys of an authorized publish request.
+func (h *publishHandler) publishKeys(ctx context.Context, appConfig *model.AuthorizedApp, data *database.Publish) response {
+	logger := logging.FromContext(ctx)
+	span := trace.FromContext(ctx)
+
+	batchTime := time.Now()
+	_, validateSpan := observability.StartSpan(ctx, "publish.validate")
+	keyErrors := h.validator.validate(data, batchTime, appConfig)
+	validateSpan.End()
+	if len(keyErrors) > 0 {
+		message := fmt.Sprintf("%d of %d keys are invalid", len(keyErrors), len(data.Keys))
+		logger.Errorf("%s: %v", message, keyErrors)
+		span.SetStatus(trace.Status{Code: trace.StatusCodeInvalidArgument, Message: message})
+		return response{status: http.StatusBadRequest, code: ErrorInvalidKeys, message: message, metric: "publish-keys-invalid", count: len(keyErrors), keyErrors: keyErrors}
+	}
+
+	exposures, err := h.transform(appConfig, data, batchTime)
+	if err != nil {
+		message := fmt.Sprintf("unable to read request data: %v", err)
+		logger.Error(message)
+		return response{status: http.StatusBadRequest, code: ErrorBadRequest, message: message, metric: "publish-transform-fail", count: 1}
+	}
+	for _, exp := range exposures {
+		exp.HealthAuthorityID = appConfig.HealthAuthorityID
+	}
+
+	// Exposures are stored in the database of their regions' residency.
+	store, err := h.database.ForExposures(exposures)
+	if err != nil {
+		message := fmt.Sprintf("unable to store exposures: %v", err)
+		logger.Error(message)
+		return response{status: http.StatusBadRequest, code: ErrorRegionNotAllowed, message: message, metric: "publish-mixed-residency", count: 1}
+	}
+
+	if data.RevisionToken != "" {
+		return h.revise(ctx, store, data.AppPackageName, data.RevisionToken, exposures)
+	}
+
+	token, err := h.newRevisionToken(ctx, data.AppPackageName, exposures)
+	if err != nil {
+		logger.Errorf("error generating revision token: %v", err)
+		return response{status: http.StatusInternalServerError, code: ErrorInternal, message: http.StatusText(http.StatusInternalServerError), metric: "publish-revision-token-error", count: 1}
+	}
+
+	results, err := h.buffers.insert(ctx, store, exposures)
+	if err != nil {
+		logger.Errorf("error writing exposure record: %v", err)
+		return response{status: http.StatusInternalServerError, code: ErrorInternal, message: http.StatusText(http.StatusInternalServerError), metric: "publish-db-write-error", count: 1}
 	}
 
+	// Retried publishes resend keys that were already accepted, so duplicates
+	// are not an error.
+	counts := make(map[database.InsertResult]int)
+	for _, r := range results {
+		counts[r]++
+	}
+	if n := counts[database.InsertDuplicate]; n > 0 {
+		h.serverenv.MetricsExporter(ctx).WriteInt("publish-exposures-duplicate", true, n)
+	}
+	if n := counts[database.InsertConflict]; n > 0 {
+		h.serverenv.MetricsExporter(ctx).WriteInt("publish-exposures-conflict", true, n)
+	}
+
+	metrics.PublishKeysInserted.Add(float64(counts[database.InsertAccepted]))
+
+	message := fmt.Sprintf("Inserted %d exposures, skipped %d duplicates and %d conflicting keys.",
+		counts[database.InsertAccepted], counts[database.InsertDuplicate], counts[database.InsertConflict])
+	logger.Info(message)
+	return response{
+		status:        http.StatusOK,
+		message:       message,
+		metric:        "publish-exposures-written",
+		count:         counts[database.InsertAccepted],
+		revisionToken: token,
+	}
+}
+
+// transform converts the keys in data to exposures. If the app's health
+// authority embargoes same-day keys, keys that are still valid are accepted
+// and held back from exports until they expire. If it maps days since symptom
+// onset to transmission risk, that mapping takes precedence over the server's.
+func (h *publishHandler) transform(appConfig *model.AuthorizedApp, data *database.Publish, batchTime time.Time) ([]*database.Exposure, error) {
+	var stages []database.TransformStage
+	if len(appConfig.OnsetTransmissionRisk) > 0 {
+		stages = append(stages, database.OnsetRiskTable(appConfig.OnsetTransmissionRisk))
+	}
+	if appConfig.EmbargoSameDayKeys {
+		return h.transformer.TransformPublishEmbargoed(data, batchTime, stages...)
+	}
+	return h.transformer.TransformPublish(data, batchTime, stages...)
+}
+
+// authorize loads the AuthorizedApp for the publish request and verifies the
+// request from clientIP against it. It returns the claims of the request's
+// verification certificate, or nil if the app doesn't require one. On failure
+// it returns the response to send.
+func (h *publishHandler) authorize(ctx context.Context, data *database.Publish, clientIP string) (*model.AuthorizedApp, *verification.VerificationClaims, *response) {
+	logger := logging.FromContext(ctx)
+	requestlog.SetAppPackage(ctx, data.AppPackageName)
+
 	appConfig, err := h.authorizedAppProvider.AppConfig(ctx, data.AppPackageName)
 	if err != nil {
 		// Config loaded, but app with that name isn't registered. This can also
@@ -94,15 +430,16 @@ func (h *publishHandler) handleRequest(w http.ResponseWriter, r *http.Request) r
 		if err == authorizedapp.AppNotFound {
 			message := fmt.Sprintf("unauthorized app: %v", data.AppPackageName)
 			logger.Error(message)
-			return response{status: http.StatusUnauthorized, message: message, metric: "publish-app-not-authorized", count: 1}
+			return nil, nil, &response{status: http.StatusUnauthorized, code: ErrorUnknownApp, message: message, metric: "publish-app-not-authorized", count: 1}
 		}
 
 		// A higher-level configuration error occurred, likely while trying to read
 		// from the database. This is retryable, although won't succeed if the error
 		// isn't transient.
 		logger.Errorf("no AuthorizedApp, dropping data: %v", err)
-		return response{
+		return nil, nil, &response{
 			status:      http.StatusInternalServerError,
+			code:        ErrorInternal,
 			message:     http.StatusText(http.StatusInternalServerError),
 			metric:      "publish-error-loading-authorizedapp",
 			count:       1,
@@ -110,9 +447,31 @@ func (h *publishHandler) handleRequest(w http.ResponseWriter, r *http.Request) r
 		}
 	}
 
-	if err := verification.VerifyRegions(appConfig, data); err != nil {
+	if resp := h.checkRateLimit(ctx, appConfig, clientIP); resp != nil {
+		return nil, nil, resp
+	}
+
+	// The health authority decides whether its apps may publish travelers'
+	// keys to the regions they visited.
+	if data.Traveler && !appConfig.AllowTravelers {
+		logger.Infof("ignoring traveler flag from %v, travelers are not allowed", data.AppPackageName)
+		h.serverenv.MetricsExporter(ctx).WriteInt("publish-traveler-not-allowed", true, 1)
+		data.Traveler = false
+	}
+
+	// In lenient mode, unauthorized regions are dropped, but only once the
+	// device attestation, which covers the requested regions, is verified.
+	var allowedRegions, droppedRegions []string
+	if h.config.DropUnauthorizedRegions {
+		var err error
+		allowedRegions, droppedRegions, err = verification.FilterRegions(appConfig, data)
+		if err != nil {
+			message := fmt.Sprintf("verifying allowed regions: %v", err)
+			return nil, nil, &response{status: http.StatusUnauthorized, code: ErrorRegionNotAllowed, message: message, metric: "publish-region-not-authorized", count: 1}
+		}
+	} else if err := verification.VerifyRegions(appConfig, data); err != nil {
 		message := fmt.Sprintf("verifying allowed regions: %v", err)
-		return response{status: http.StatusUnauthorized, message: message, metric: "publish-region-not-authorized", count: 1}
+		return nil, nil, &response{status: http.StatusUnauthorized, code: ErrorRegionNotAllowed, message: message, metric: "publish-region-not-authorized", count: 1}
 	}
 
 	if appConfig.IsIOS() {
@@ -122,60 +481,217 @@ func (h *publishHandler) handleRequest(w http.ResponseWriter, r *http.Request) r
 		} else if err := verification.VerifyDeviceCheck(ctx, appConfig, data); err != nil {
 			message := fmt.Sprintf("unable to verify devicecheck payload: %v", err)
 			logger.Error(message)
-			return response{status: http.StatusUnauthorized, message: message, metric: "publish-devicecheck-invalid", count: 1}
+			return nil, nil, &response{status: http.StatusUnauthorized, code: ErrorVerificationFailed, message: message, metric: "publish-devicecheck-invalid", count: 1}
 		}
 	} else if appConfig.IsAndroid() {
 		if appConfig.SafetyNetDisabled {
 			logger.Errorf("skipping SafetyNet for %v (disabled)", data.AppPackageName)
 			h.serverenv.MetricsExporter(ctx).WriteInt("publish-safetynet-skip", true, 1)
-		} else if err := verification.VerifySafetyNet(ctx, time.Now(), appConfig, data); err != nil {
+		} else if roots, err := h.safetyNetRootPool(ctx); err != nil {
+			logger.Errorf("unable to load safetynet root certificates: %v", err)
+			return nil, nil, &response{
+				status:      http.StatusInternalServerError,
+				code:        ErrorInternal,
+				message:     http.StatusText(http.StatusInternalServerError),
+				metric:      "publish-safetynet-roots-error",
+				count:       1,
+				errorInProd: true,
+			}
+		} else if err := verification.VerifySafetyNet(ctx, time.Now(), appConfig, data, roots); err != nil {
 			message := fmt.Sprintf("unable to verify safetynet payload: %v", err)
 			logger.Error(message)
-			return response{status: http.StatusUnauthorized, message: message, metric: "publish-safetnet-invalid", count: 1}
+			return nil, nil, &response{status: http.StatusUnauthorized, code: ErrorVerificationFailed, message: message, metric: "publish-safetnet-invalid", count: 1}
 		}
 	} else {
 		message := fmt.Sprintf("invalid AuthorizedApp config %v: invalid platform %v", data.AppPackageName, data.Platform)
 		logger.Error(message)
-		return response{status: http.StatusInternalServerError, message: message, metric: "publish-authorizedapp-missing-platform", count: 1}
+		return nil, nil, &response{status: http.StatusInternalServerError, code: ErrorInternal, message: message, metric: "publish-authorizedapp-missing-platform", count: 1}
 	}
 
-	batchTime := time.Now()
-	exposures, err := h.transformer.TransformPublish(data, batchTime)
-	if err != nil {
-		message := fmt.Sprintf("unable to read request data: %v", err)
+	var claims *verification.VerificationClaims
+	if appConfig.RequiresCertificate() {
+		var err error
+		claims, err = verification.VerifyCertificate(ctx, appConfig, data, h.certificateKeys)
+		if err != nil {
+			message := fmt.Sprintf("unable to verify certificate: %v", err)
+			logger.Error(message)
+			return nil, nil, &response{status: http.StatusUnauthorized, code: ErrorVerificationFailed, message: message, metric: "publish-certificate-invalid", count: 1}
+		}
+		// The health authority, not the device, decides the report type.
+		if claims.ReportType != "" {
+			if data.ReportType != "" && data.ReportType != claims.ReportType {
+				message := fmt.Sprintf("report type %q does not match certificate report type %q", data.ReportType, claims.ReportType)
+				logger.Error(message)
+				return nil, nil, &response{status: http.StatusBadRequest, code: ErrorReportTypeMismatch, message: message, metric: "publish-certificate-report-type-mismatch", count: 1}
+			}
+			data.ReportType = claims.ReportType
+		}
+	}
+
+	if len(droppedRegions) > 0 {
+		logger.Infof("dropping unauthorized regions %v from publish by %v", droppedRegions, data.AppPackageName)
+		h.serverenv.MetricsExporter(ctx).WriteInt("publish-regions-dropped", true, len(droppedRegions))
+		data.Regions = allowedRegions
+	}
+
+	return appConfig, claims, nil
+}
+
+// withCertificateClaim calls publish once the verification certificate with
+// claims is recorded as used, so that it can't be used for another publish.
+// If the publish fails, the claim is released so that the client can retry
+// with the same certificate. claims is nil if the app doesn't require a
+// certificate.
+func (h *publishHandler) withCertificateClaim(ctx context.Context, claims *verification.VerificationClaims, publish func() response) response {
+	if claims == nil {
+		return publish()
+	}
+	if resp := h.claimCertificate(ctx, claims); resp != nil {
+		return *resp
+	}
+
+	resp := publish()
+	if resp.status != http.StatusOK && h.config.CertificateReplayProtection {
+		// Release the claim even if the request was cancelled.
+		releaseCtx, cancel := context.WithTimeout(context.Background(), certificateReleaseTimeout)
+		defer cancel()
+		if err := h.certificates.ReleaseCertificate(releaseCtx, claims.Issuer, claims.Id); err != nil {
+			logging.FromContext(ctx).Errorf("error releasing certificate %v from %v: %v", claims.Id, claims.Issuer, err)
+			h.serverenv.MetricsExporter(ctx).WriteInt("publish-certificate-release-error", true, 1)
+		}
+	}
+	return resp
+}
+
+// claimCertificate records the use of the verification certificate, so that it
+// can't be used for another publish. If replay protection is enabled, the
+// certificate must have an ID. On failure it returns the response to send.
+func (h *publishHandler) claimCertificate(ctx context.Context, claims *verification.VerificationClaims) *response {
+	if !h.config.CertificateReplayProtection {
+		return nil
+	}
+	logger := logging.FromContext(ctx)
+
+	if claims.Id == "" {
+		message := "certificate has no jti"
 		logger.Error(message)
-		return response{status: http.StatusBadRequest, message: message, metric: "publish-transform-fail", count: 1}
+		return &response{status: http.StatusUnauthorized, code: ErrorVerificationFailed, message: message, metric: "publish-certificate-missing-jti", count: 1}
+	}
+	err := h.certificates.ClaimCertificate(ctx, claims.Issuer, claims.Id, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		if errors.Is(err, database.ErrCertificateReplayed) {
+			message := fmt.Sprintf("certificate %v from %v was already used", claims.Id, claims.Issuer)
+			logger.Error(message)
+			return &response{status: http.StatusUnauthorized, code: ErrorCertificateReplayed, message: message, metric: "publish-certificate-replayed", count: 1}
+		}
+		logger.Errorf("error claiming certificate: %v", err)
+		return &response{
+			status:      http.StatusInternalServerError,
+			code:        ErrorInternal,
+			message:     http.StatusText(http.StatusInternalServerError),
+			metric:      "publish-certificate-claim-error",
+			count:       1,
+			errorInProd: true,
+		}
+	}
+	return nil
+}
+
+// checkRateLimit takes a token from the bucket of the client of the app. If
+// the client has made too many requests, it returns the response to send.
+// Requests are allowed if the rate limit can't be checked.
+func (h *publishHandler) checkRateLimit(ctx context.Context, appConfig *model.AuthorizedApp, clientIP string) *response {
+	if h.rateLimiter == nil {
+		return nil
 	}
 
-	err = h.database.InsertExposures(ctx, exposures)
+	limit := h.rateLimit
+	if appConfig.RateLimitTokens > 0 {
+		limit = ratelimit.Limit{Tokens: appConfig.RateLimitTokens, Interval: appConfig.RateLimitInterval}
+	}
+	ok, wait, err := h.rateLimiter.Take(ctx, appConfig.AppPackageName+"|"+clientIP, limit)
 	if err != nil {
-		logger.Errorf("error writing exposure record: %v", err)
-		return response{status: http.StatusInternalServerError, message: http.StatusText(http.StatusInternalServerError), metric: "publish-db-write-error", count: 1}
+		logging.FromContext(ctx).Errorf("unable to check rate limit: %v", err)
+		h.serverenv.MetricsExporter(ctx).WriteInt("publish-rate-limit-error", true, 1)
+		return nil
 	}
+	if !ok {
+		return &response{
+			status:      http.StatusTooManyRequests,
+			code:        ErrorRateLimited,
+			message:     http.StatusText(http.StatusTooManyRequests),
+			metric:      "publish-rate-limited",
+			count:       1,
+			errorInProd: true,
+			retryAfter:  wait,
+		}
+	}
+	return nil
+}
+
+// safetyNetRootPool returns the roots SafetyNet attestations must chain to, or
+// nil to use the system roots.
+func (h *publishHandler) safetyNetRootPool(ctx context.Context) (*x509.CertPool, error) {
+	if h.safetyNetRoots == nil {
+		return nil, nil
+	}
+	return h.safetyNetRoots.Pool(ctx)
+}
+
+// revise applies a revision to previously published exposures.
+func (h *publishHandler) revise(ctx context.Context, store *database.DB, appPackageName, encoded string, exposures []*database.Exposure) response {
+	logger := logging.FromContext(ctx)
 
-	message := fmt.Sprintf("Inserted %d exposures.", len(exposures))
+	revised, token, err := h.reviseExposures(ctx, store, appPackageName, encoded, exposures)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidRevisionToken) || errors.Is(err, database.ErrInvalidReportTypeTransition) {
+			message := fmt.Sprintf("unable to revise exposures: %v", err)
+			logger.Error(message)
+			return response{status: http.StatusBadRequest, code: ErrorBadRevisionToken, message: message, metric: "publish-revision-invalid", count: 1}
+		}
+		logger.Errorf("error revising exposure records: %v", err)
+		return response{status: http.StatusInternalServerError, code: ErrorInternal, message: http.StatusText(http.StatusInternalServerError), metric: "publish-db-write-error", count: 1}
+	}
+
+	message := fmt.Sprintf("Revised %d exposures.", revised)
 	logger.Info(message)
 	return response{
-		status:  http.StatusOK,
-		message: message,
-		metric:  "publish-exposures-written",
-		count:   len(exposures),
+		status:        http.StatusOK,
+		message:       message,
+		metric:        "publish-exposures-revised",
+		count:         revised,
+		revisionToken: token,
 	}
 }
 
+// recordResponse writes the metric of resp and counts the request by its
+// result.
+func (h *publishHandler) recordResponse(ctx context.Context, resp response) {
+	if resp.metric != "" {
+		h.serverenv.MetricsExporter(ctx).WriteInt(resp.metric, true, resp.count)
+	}
+	code := string(resp.code)
+	if code == "" {
+		code = "OK"
+	}
+	metrics.PublishRequests.WithLabelValues(strconv.Itoa(resp.status), code).Inc()
+}
+
 // There is a target normalized latency for this function. This is to help prevent
 // clients from being able to distinguish from successful or errored requests.
 func (h *publishHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	response := h.handleRequest(w, r)
+	h.recordResponse(r.Context(), response)
 
-	if response.metric != "" {
-		ctx := r.Context()
-		metrics := h.serverenv.MetricsExporter(ctx)
-		metrics.WriteInt(response.metric, true, response.count)
+	if response.retryAfter > 0 {
+		w.Header().Set("Retry-After", ratelimit.RetryAfter(response.retryAfter))
 	}
 
 	// Handle success. If debug enabled, write the message in the response.
 	if response.status == http.StatusOK {
+		if response.revisionToken != "" {
+			w.Header().Set(revisionTokenHeader, response.revisionToken)
+		}
 		if h.config.DebugAPIResponses {
 			w.Write([]byte(response.message))
 		} else {
@@ -187,8 +703,9 @@ func (h *publishHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// If this error is written in non-debug times or if debug is enabled, write
 	// out the error and status.
 	if h.config.DebugAPIResponses || response.errorInProd {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(response.status)
-		w.Write([]byte(response.message))
+		json.NewEncoder(w).Encode(ErrorResponse{Code: response.code, Error: response.message, KeyErrors: response.keyErrors})
 		return
 	}
 
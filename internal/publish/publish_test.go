@@ -0,0 +1,100 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+	"github.com/google/exposure-notifications-server/internal/verification"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestClaimCertificate(t *testing.T) {
+	ctx := context.Background()
+
+	// The handler has no database, so only certificates that are rejected, or
+	// not tracked, before the ID is stored can be tested here.
+	claims := &verification.VerificationClaims{
+		StandardClaims: jwt.StandardClaims{Issuer: "ha.example.com", ExpiresAt: 1588291200},
+	}
+
+	h := &publishHandler{config: &Config{CertificateReplayProtection: false}}
+	if resp := h.claimCertificate(ctx, claims); resp != nil {
+		t.Errorf("claimCertificate with replay protection disabled: got %+v, want nil", resp)
+	}
+
+	h = &publishHandler{config: &Config{CertificateReplayProtection: true}}
+	resp := h.claimCertificate(ctx, claims)
+	if resp == nil {
+		t.Fatalf("claimCertificate without jti: got nil response")
+	}
+	if resp.code != ErrorVerificationFailed {
+		t.Errorf("claimCertificate without jti: got code %q, want %q", resp.code, ErrorVerificationFailed)
+	}
+}
+
+// fakeCertificates records certificate uses in memory.
+type fakeCertificates struct {
+	used map[string]bool
+}
+
+func (f *fakeCertificates) ClaimCertificate(ctx context.Context, issuer, jti string, expiresAt time.Time) error {
+	if f.used[issuer+"|"+jti] {
+		return database.ErrCertificateReplayed
+	}
+	f.used[issuer+"|"+jti] = true
+	return nil
+}
+
+func (f *fakeCertificates) ReleaseCertificate(ctx context.Context, issuer, jti string) error {
+	delete(f.used, issuer+"|"+jti)
+	return nil
+}
+
+func TestWithCertificateClaim(t *testing.T) {
+	ctx := context.Background()
+
+	claims := &verification.VerificationClaims{
+		StandardClaims: jwt.StandardClaims{Issuer: "ha.example.com", Id: "jti-1", ExpiresAt: 1588291200},
+	}
+	h := &publishHandler{
+		config:       &Config{CertificateReplayProtection: true},
+		serverenv:    serverenv.New(ctx, serverenv.WithMetricsExporter(metrics.NewLogsBasedFromContext)),
+		certificates: &fakeCertificates{used: make(map[string]bool)},
+	}
+	failed := func() response { return response{status: http.StatusInternalServerError, code: ErrorInternal} }
+	published := func() response { return response{status: http.StatusOK} }
+
+	// A failed insert releases the certificate, so the retry is published.
+	if resp := h.withCertificateClaim(ctx, claims, failed); resp.code != ErrorInternal {
+		t.Errorf("failed publish: got code %q, want %q", resp.code, ErrorInternal)
+	}
+	if resp := h.withCertificateClaim(ctx, claims, published); resp.status != http.StatusOK {
+		t.Errorf("retried publish: got status %d, want %d", resp.status, http.StatusOK)
+	}
+
+	// Once the publish succeeds, the certificate can't be used again.
+	resp := h.withCertificateClaim(ctx, claims, published)
+	if resp.code != ErrorCertificateReplayed {
+		t.Errorf("replayed publish: got code %q, want %q", resp.code, ErrorCertificateReplayed)
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/ratelimit"
+	"github.com/google/exposure-notifications-server/internal/serverenv"
+)
+
+// Statuses of a single key in a v2 publish response.
+const (
+	// StatusAccepted means the key was stored.
+	StatusAccepted = "accepted"
+
+	// StatusDuplicate means the key was already stored by an earlier publish.
+	StatusDuplicate = "duplicate"
+
+	// StatusInvalid means the key was rejected and retrying it won't help.
+	StatusInvalid = "invalid"
+
+	// StatusEmbargoed means the key is still in use on the device. It can be
+	// published again once its rolling period has ended. Apps of health
+	// authorities that embargo same-day keys accept such keys instead.
+	StatusEmbargoed = "embargoed"
+)
+
+// KeyStatus is the outcome of publishing a single key with the v2 API.
+type KeyStatus struct {
+	// Index is the position of the key in the request.
+	Index   int       `json:"index"`
+	Status  string    `json:"status"`
+	Code    ErrorCode `json:"code,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// PublishV2Response is the body of a response from the v2 publish API.
+type PublishV2Response struct {
+	RevisionToken string      `json:"revisionToken,omitempty"`
+	Keys          []KeyStatus `json:"keys,omitempty"`
+	Code          ErrorCode   `json:"code,omitempty"`
+	Error         string      `json:"error,omitempty"`
+}
+
+// NewV2Handler creates the HTTP handler for the v2 publishing API. Unlike the
+// v1 API, a request is not all-or-nothing: each key is accepted or rejected
+// on its own, and the response reports the status of every key so a client
+// can retry only the keys that failed.
+func NewV2Handler(ctx context.Context, config *Config, env *serverenv.ServerEnv) (http.Handler, error) {
+	h, err := newPublishHandler(ctx, config, env, config.MaxKeysOnPublishV2)
+	if err != nil {
+		return nil, err
+	}
+	return &publishV2Handler{h}, nil
+}
+
+type publishV2Handler struct {
+	*publishHandler
+}
+
+func (h *publishV2Handler) handleRequest(w http.ResponseWriter, r *http.Request) response {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+
+	if isChaff(r) {
+		n, token := h.handleChaff(w, r)
+		statuses := make([]KeyStatus, n)
+		for i := range statuses {
+			statuses[i] = KeyStatus{Index: i, Status: StatusAccepted}
+		}
+		return response{status: http.StatusOK, message: "Received chaff.", metric: "publish-v2-chaff", count: 1, revisionToken: token, keyStatuses: statuses}
+	}
+
+	var data *database.Publish
+	code, err := h.unmarshal(w, r, &data)
+	if err != nil {
+		message := fmt.Sprintf("error unmarshalling API call, code: %v: %v", code, err)
+		logger.Error(message)
+		if code == http.StatusRequestEntityTooLarge {
+			return response{status: code, code: ErrorRequestTooLarge, message: message, metric: "publish-v2-body-too-large", count: 1}
+		}
+		return response{status: http.StatusBadRequest, code: ErrorBadRequest, message: message, metric: "publish-v2-bad-json", count: 1}
+	}
+
+	return h.publish(ctx, ratelimit.ClientIP(r, h.trustedProxies), r.Header.Get(IdempotencyKeyHeader), data)
+}
+
+// publish verifies and stores each key of a parsed publish request, which
+// carried idempotencyKey if it isn't empty.
+func (h *publishV2Handler) publish(ctx context.Context, clientIP, idempotencyKey string, data *database.Publish) response {
+	logger := logging.FromContext(ctx)
+
+	if n := len(data.Keys); n == 0 || n > h.maxKeys {
+		message := fmt.Sprintf("publish must contain between 1 and %v keys, got %v", h.maxKeys, n)
+		logger.Error(message)
+		return response{status: http.StatusBadRequest, code: ErrorBadKeyCount, message: message, metric: "publish-v2-invalid-key-count", count: 1}
+	}
+
+	idempotent, resp := h.newIdempotentRequest(ctx, idempotencyKey, data)
+	if resp != nil {
+		return *resp
+	}
+	appConfig, claims, resp := h.authorize(ctx, data, clientIP)
+	if resp != nil {
+		return *resp
+	}
+
+	return h.withIdempotency(ctx, idempotent, func() response {
+		return h.withCertificateClaim(ctx, claims, func() response {
+			return h.publishKeys(ctx, appConfig, data)
+		})
+	})
+}
+
+// publishKeys validates and stores each key of an authorized publish request.
+func (h *publishV2Handler) publishKeys(ctx context.Context, appConfig *model.AuthorizedApp, data *database.Publish) response {
+	logger := logging.FromContext(ctx)
+
+	batchTime := time.Now()
+	statuses := initialStatuses(data.Keys, h.validator.validate(data, batchTime, appConfig))
+
+	// Only the keys that passed validation are transformed and stored.
+	valid := *data
+	valid.Keys = nil
+	byInterval := make(map[int32]int)
+	for i, s := range statuses {
+		if s.Status == "" {
+			valid.Keys = append(valid.Keys, data.Keys[i])
+			byInterval[data.Keys[i].IntervalNumber] = i
+		}
+	}
+	if len(valid.Keys) == 0 {
+		return h.finish(ctx, statuses, "")
+	}
+
+	exposures, err := h.transform(appConfig, &valid, batchTime)
+	if err != nil {
+		message := fmt.Sprintf("unable to read request data: %v", err)
+		logger.Error(message)
+		return response{status: http.StatusBadRequest, code: ErrorBadRequest, message: message, metric: "publish-v2-transform-fail", count: 1}
+	}
+	for _, exp := range exposures {
+		exp.HealthAuthorityID = appConfig.HealthAuthorityID
+	}
+
+	store, err := h.database.ForExposures(exposures)
+	if err != nil {
+		message := fmt.Sprintf("unable to store exposures: %v", err)
+		logger.Error(message)
+		return response{status: http.StatusBadRequest, code: ErrorRegionNotAllowed, message: message, metric: "publish-v2-mixed-residency", count: 1}
+	}
+
+	if data.RevisionToken != "" {
+		_, token, err := h.reviseExposures(ctx, store, data.AppPackageName, data.RevisionToken, exposures)
+		if err != nil {
+			if errors.Is(err, database.ErrInvalidRevisionToken) || errors.Is(err, database.ErrInvalidReportTypeTransition) {
+				message := fmt.Sprintf("unable to revise exposures: %v", err)
+				logger.Error(message)
+				return response{status: http.StatusBadRequest, code: ErrorBadRevisionToken, message: message, metric: "publish-v2-revision-invalid", count: 1}
+			}
+			logger.Errorf("error revising exposure records: %v", err)
+			return response{status: http.StatusInternalServerError, code: ErrorInternal, message: http.StatusText(http.StatusInternalServerError), metric: "publish-v2-db-write-error", count: 1}
+		}
+		for _, i := range byInterval {
+			statuses[i].Status = StatusAccepted
+		}
+		return h.finish(ctx, statuses, token)
+	}
+
+	token, err := h.newRevisionToken(ctx, data.AppPackageName, exposures)
+	if err != nil {
+		logger.Errorf("error generating revision token: %v", err)
+		return response{status: http.StatusInternalServerError, code: ErrorInternal, message: http.StatusText(http.StatusInternalServerError), metric: "publish-v2-revision-token-error", count: 1}
+	}
+
+	results, err := h.buffers.insert(ctx, store, exposures)
+	if err != nil {
+		logger.Errorf("error writing exposure record: %v", err)
+		return response{status: http.StatusInternalServerError, code: ErrorInternal, message: http.StatusText(http.StatusInternalServerError), metric: "publish-v2-db-write-error", count: 1}
+	}
+
+	// TransformPublish sorts the exposures, but validation guarantees the
+	// interval numbers are distinct so they identify the key in the request.
+	for j, result := range results {
+		i := byInterval[exposures[j].IntervalNumber]
+		switch result {
+		case database.InsertAccepted:
+			statuses[i].Status = StatusAccepted
+		case database.InsertDuplicate:
+			statuses[i].Status = StatusDuplicate
+		default:
+			statuses[i].Status = StatusInvalid
+			statuses[i].Code = ErrorKeyConflict
+			statuses[i].Message = "key was already published with a different interval number"
+		}
+	}
+	return h.finish(ctx, statuses, token)
+}
+
+// finish records metrics for the key statuses and builds the response.
+func (h *publishV2Handler) finish(ctx context.Context, statuses []KeyStatus, token string) response {
+	counts := make(map[string]int)
+	for _, s := range statuses {
+		counts[s.Status]++
+	}
+	metrics := h.serverenv.MetricsExporter(ctx)
+	for _, status := range []string{StatusDuplicate, StatusInvalid, StatusEmbargoed} {
+		if n := counts[status]; n > 0 {
+			metrics.WriteInt("publish-v2-keys-"+status, true, n)
+		}
+	}
+
+	message := fmt.Sprintf("Accepted %d keys, %d duplicate, %d invalid and %d embargoed.",
+		counts[StatusAccepted], counts[StatusDuplicate], counts[StatusInvalid], counts[StatusEmbargoed])
+	logging.FromContext(ctx).Info(message)
+	if counts[StatusAccepted] == 0 {
+		// Nothing was stored, so there is nothing to revise later.
+		token = ""
+	}
+	return response{
+		status:        http.StatusOK,
+		message:       message,
+		metric:        "publish-v2-keys-accepted",
+		count:         counts[StatusAccepted],
+		revisionToken: token,
+		keyStatuses:   statuses,
+	}
+}
+
+// initialStatuses returns a status for each key from the result of
+// validation. Keys that failed validation are invalid, or embargoed if they
+// are still in use. Keys that may be stored are left with an empty status.
+func initialStatuses(keys []database.ExposureKey, keyErrors []KeyError) []KeyStatus {
+	statuses := make([]KeyStatus, len(keys))
+	for i := range statuses {
+		statuses[i].Index = i
+	}
+	for _, e := range keyErrors {
+		status := StatusInvalid
+		if e.Code == ErrorKeyStillValid {
+			status = StatusEmbargoed
+		}
+		statuses[e.Index] = KeyStatus{Index: e.Index, Status: status, Code: e.Code, Message: e.Message}
+	}
+	return statuses
+}
+
+// ServeHTTP writes the status of each key as JSON. Errors that apply to the
+// whole request are hidden in production, as they are for the v1 API.
+func (h *publishV2Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	response := h.handleRequest(w, r)
+	h.recordResponse(r.Context(), response)
+
+	if response.retryAfter > 0 {
+		w.Header().Set("Retry-After", ratelimit.RetryAfter(response.retryAfter))
+	}
+
+	if response.status != http.StatusOK && !h.config.DebugAPIResponses && !response.errorInProd {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	body := PublishV2Response{
+		RevisionToken: response.revisionToken,
+		Keys:          response.keyStatuses,
+	}
+	if response.status != http.StatusOK {
+		body.Code = response.code
+		body.Error = response.message
+	}
+	if response.revisionToken != "" {
+		w.Header().Set(revisionTokenHeader, response.revisionToken)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.status)
+	json.NewEncoder(w).Encode(body)
+}
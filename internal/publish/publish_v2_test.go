@@ -0,0 +1,49 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"testing"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInitialStatuses(t *testing.T) {
+	const day = intervalsPerDay
+	keys := []database.ExposureKey{
+		{IntervalNumber: 3 * day, IntervalCount: day},
+		{IntervalNumber: 1 * day, IntervalCount: day},
+		{IntervalNumber: 4 * day, IntervalCount: day},
+		{IntervalNumber: 3 * day, IntervalCount: day},
+		{IntervalNumber: 2 * day, IntervalCount: day},
+	}
+	keyErrors := []KeyError{
+		{Index: 2, Code: ErrorKeyStillValid, Message: "still valid"},
+		{Index: 3, Code: ErrorOverlappingInterval, Message: "overlaps"},
+		{Index: 4, Code: ErrorIntervalTooOld, Message: "too old"},
+	}
+
+	want := []KeyStatus{
+		{Index: 0},
+		{Index: 1},
+		{Index: 2, Status: StatusEmbargoed, Code: ErrorKeyStillValid, Message: "still valid"},
+		{Index: 3, Status: StatusInvalid, Code: ErrorOverlappingInterval, Message: "overlaps"},
+		{Index: 4, Status: StatusInvalid, Code: ErrorIntervalTooOld, Message: "too old"},
+	}
+	if diff := cmp.Diff(want, initialStatuses(keys, keyErrors)); diff != "" {
+		t.Errorf("initialStatuses mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/base64util"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/runtimeconfig"
+)
+
+// intervalsPerDay is the number of 10 minute intervals in a UTC day. Keys
+// start on a multiple of it.
+const intervalsPerDay = database.MaxIntervalCount
+
+// KeyError describes why a single key in a publish request was rejected.
+type KeyError struct {
+	// Index is the position of the key in the request.
+	Index   int       `json:"index"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func (e KeyError) Error() string {
+	return fmt.Sprintf("key %d: %s", e.Index, e.Message)
+}
+
+// validator checks each key in a publish request before it is transformed,
+// so that every invalid key can be reported instead of only the first.
+type validator struct {
+	maxIntervalAge time.Duration
+	clockSkew      time.Duration
+	requireAligned bool
+	maxKeysPerDay  int
+
+	// runtime may override maxKeysPerDay with MAX_KEYS_PER_DAY.
+	runtime *runtimeconfig.Watcher
+}
+
+func newValidator(config *Config, runtime *runtimeconfig.Watcher) *validator {
+	return &validator{
+		maxIntervalAge: config.MaxIntervalAge,
+		clockSkew:      config.ClockSkewTolerance,
+		requireAligned: config.RequireAlignedKeys,
+		maxKeysPerDay:  config.MaxKeysPerDay,
+		runtime:        runtime,
+	}
+}
+
+// validate returns an error for each invalid key in data, in request order.
+// Keys are checked on their own and then against the other valid keys, which
+// must not overlap and must not number more than the app's maximum per UTC
+// day. If the app's health authority embargoes same-day keys, keys that are
+// still valid are accepted, see
+// database.Transformer.TransformPublishEmbargoed.
+func (v *validator) validate(data *database.Publish, now time.Time, app *model.AuthorizedApp) []KeyError {
+	minIntervalNumber := database.IntervalNumber(now.Add(-v.maxIntervalAge))
+	maxIntervalNumber := database.IntervalNumber(now.Add(v.clockSkew))
+	maxEndIntervalNumber := maxIntervalNumber
+	if app.EmbargoSameDayKeys {
+		maxEndIntervalNumber += database.MaxIntervalCount
+	}
+
+	var errs []KeyError
+	var valid []int
+	for i, k := range data.Keys {
+		if code, msg := v.validateKey(k, minIntervalNumber, maxIntervalNumber, maxEndIntervalNumber); code != "" {
+			errs = append(errs, KeyError{Index: i, Code: code, Message: msg})
+			continue
+		}
+		valid = append(valid, i)
+	}
+
+	maxKeysPerDay := v.runtime.Int("MAX_KEYS_PER_DAY", v.maxKeysPerDay)
+	if app.MaxKeysPerDay > 0 {
+		maxKeysPerDay = app.MaxKeysPerDay
+	}
+	errs = append(errs, validateSet(data.Keys, valid, maxKeysPerDay)...)
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Index < errs[j].Index
+	})
+	return errs
+}
+
+// validateSet checks the keys at the given indexes against each other. A key
+// is invalid if it overlaps an earlier key, or if maxKeysPerDay is positive
+// and earlier keys already start on the same UTC day.
+func validateSet(keys []database.ExposureKey, indexes []int, maxKeysPerDay int) []KeyError {
+	sorted := make([]int, len(indexes))
+	copy(sorted, indexes)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return keys[sorted[a]].IntervalNumber < keys[sorted[b]].IntervalNumber
+	})
+
+	var errs []KeyError
+	var nextInterval int32
+	prev := -1
+	perDay := make(map[int32]int)
+	for _, i := range sorted {
+		k := keys[i]
+		if prev >= 0 && k.IntervalNumber < nextInterval {
+			errs = append(errs, KeyError{
+				Index:   i,
+				Code:    ErrorOverlappingInterval,
+				Message: fmt.Sprintf("key overlaps the key at index %d, which is valid until interval %v", prev, nextInterval),
+			})
+			continue
+		}
+
+		day := k.IntervalNumber / intervalsPerDay
+		if maxKeysPerDay > 0 && perDay[day] >= maxKeysPerDay {
+			errs = append(errs, KeyError{
+				Index:   i,
+				Code:    ErrorTooManyKeysPerDay,
+				Message: fmt.Sprintf("at most %d keys may start on %s", maxKeysPerDay, database.IntervalTime(day*intervalsPerDay).Format("2006-01-02")),
+			})
+			continue
+		}
+		perDay[day]++
+		prev = i
+		nextInterval = k.IntervalNumber + k.IntervalCount
+	}
+	return errs
+}
+
+// validateKey returns the code and message for the first problem with k, or
+// an empty code and message if k is valid.
+func (v *validator) validateKey(k database.ExposureKey, minIntervalNumber, maxIntervalNumber, maxEndIntervalNumber int32) (ErrorCode, string) {
+	binKey, err := base64util.DecodeString(k.Key)
+	if err != nil {
+		return ErrorBadKey, fmt.Sprintf("key is not valid base64: %v", err)
+	}
+	if len(binKey) != database.KeyLength {
+		return ErrorBadKey, fmt.Sprintf("key length is %v, must be %v", len(binKey), database.KeyLength)
+	}
+
+	if ic := k.IntervalCount; ic < database.MinIntervalCount || ic > database.MaxIntervalCount {
+		return ErrorBadRollingPeriod, fmt.Sprintf("rolling period %v must be >= %v and <= %v", ic, database.MinIntervalCount, database.MaxIntervalCount)
+	}
+	if v.requireAligned && k.IntervalNumber%intervalsPerDay != 0 {
+		return ErrorBadInterval, fmt.Sprintf("interval number %v is not at the start of a UTC day", k.IntervalNumber)
+	}
+
+	if k.IntervalNumber < minIntervalNumber {
+		return ErrorIntervalTooOld, fmt.Sprintf("interval number %v is too old, must be >= %v", k.IntervalNumber, minIntervalNumber)
+	}
+	if k.IntervalNumber >= maxIntervalNumber {
+		return ErrorIntervalInFuture, fmt.Sprintf("interval number %v is in the future, must be < %v", k.IntervalNumber, maxIntervalNumber)
+	}
+	if end := k.IntervalNumber + k.IntervalCount; end > maxEndIntervalNumber {
+		return ErrorKeyStillValid, fmt.Sprintf("key is still valid until interval %v, must end <= %v", end, maxEndIntervalNumber)
+	}
+
+	if tr := k.TransmissionRisk; tr < database.MinTransmissionRisk || tr > database.MaxTransmissionRisk {
+		return ErrorBadTransmissionRisk, fmt.Sprintf("transmission risk %v must be >= %v and <= %v", tr, database.MinTransmissionRisk, database.MaxTransmissionRisk)
+	}
+	if ds := k.DaysSinceOnsetOfSymptoms; ds != nil && (*ds < database.MinDaysSinceSymptomOnset || *ds > database.MaxDaysSinceSymptomOnset) {
+		return ErrorBadDaysSinceOnset, fmt.Sprintf("days since symptom onset %v must be >= %v and <= %v", *ds, database.MinDaysSinceSymptomOnset, database.MaxDaysSinceSymptomOnset)
+	}
+	return "", ""
+}
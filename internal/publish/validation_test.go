@@ -0,0 +1,221 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidate(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	today := database.IntervalNumber(now.Truncate(24 * time.Hour))
+	yesterday := today - intervalsPerDay
+	validKey := base64.StdEncoding.EncodeToString(make([]byte, database.KeyLength))
+	ds := func(v int32) *int32 { return &v }
+
+	v := &validator{
+		maxIntervalAge: 14 * 24 * time.Hour,
+		clockSkew:      0,
+		requireAligned: true,
+	}
+
+	cases := []struct {
+		name    string
+		key     database.ExposureKey
+		v       *validator
+		embargo bool
+		code    ErrorCode
+	}{
+		{
+			name: "valid",
+			key:  database.ExposureKey{Key: validKey, IntervalNumber: yesterday, IntervalCount: 144},
+		},
+		{
+			name: "bad_base64",
+			key:  database.ExposureKey{Key: "not base64!", IntervalNumber: yesterday, IntervalCount: 144},
+			code: ErrorBadKey,
+		},
+		{
+			name: "short_key",
+			key:  database.ExposureKey{Key: base64.StdEncoding.EncodeToString([]byte("short")), IntervalNumber: yesterday, IntervalCount: 144},
+			code: ErrorBadKey,
+		},
+		{
+			name: "rolling_period_too_long",
+			key:  database.ExposureKey{Key: validKey, IntervalNumber: yesterday, IntervalCount: 145},
+			code: ErrorBadRollingPeriod,
+		},
+		{
+			name: "rolling_period_zero",
+			key:  database.ExposureKey{Key: validKey, IntervalNumber: yesterday, IntervalCount: 0},
+			code: ErrorBadRollingPeriod,
+		},
+		{
+			name: "unaligned",
+			key:  database.ExposureKey{Key: validKey, IntervalNumber: yesterday + 6, IntervalCount: 138},
+			code: ErrorBadInterval,
+		},
+		{
+			name: "unaligned_allowed",
+			key:  database.ExposureKey{Key: validKey, IntervalNumber: yesterday + 6, IntervalCount: 138},
+			v:    &validator{maxIntervalAge: 14 * 24 * time.Hour},
+		},
+		{
+			name: "too_old",
+			key:  database.ExposureKey{Key: validKey, IntervalNumber: today - 20*intervalsPerDay, IntervalCount: 144},
+			code: ErrorIntervalTooOld,
+		},
+		{
+			name: "future",
+			key:  database.ExposureKey{Key: validKey, IntervalNumber: today + intervalsPerDay, IntervalCount: 144},
+			code: ErrorIntervalInFuture,
+		},
+		{
+			name: "still_valid",
+			key:  database.ExposureKey{Key: validKey, IntervalNumber: today, IntervalCount: 144},
+			code: ErrorKeyStillValid,
+		},
+		{
+			name:    "still_valid_embargoed",
+			key:     database.ExposureKey{Key: validKey, IntervalNumber: today, IntervalCount: 144},
+			embargo: true,
+		},
+		{
+			name:    "future_embargoed",
+			key:     database.ExposureKey{Key: validKey, IntervalNumber: today + intervalsPerDay, IntervalCount: 144},
+			embargo: true,
+			code:    ErrorIntervalInFuture,
+		},
+		{
+			name: "still_valid_within_skew",
+			key:  database.ExposureKey{Key: validKey, IntervalNumber: today, IntervalCount: 144},
+			v:    &validator{maxIntervalAge: 14 * 24 * time.Hour, clockSkew: 12 * time.Hour, requireAligned: true},
+		},
+		{
+			name: "transmission_risk",
+			key:  database.ExposureKey{Key: validKey, IntervalNumber: yesterday, IntervalCount: 144, TransmissionRisk: 9},
+			code: ErrorBadTransmissionRisk,
+		},
+		{
+			name: "days_since_onset",
+			key:  database.ExposureKey{Key: validKey, IntervalNumber: yesterday, IntervalCount: 144, DaysSinceOnsetOfSymptoms: ds(15)},
+			code: ErrorBadDaysSinceOnset,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			val := v
+			if c.v != nil {
+				val = c.v
+			}
+			app := &model.AuthorizedApp{EmbargoSameDayKeys: c.embargo}
+			errs := val.validate(&database.Publish{Keys: []database.ExposureKey{c.key}}, now, app)
+			var got ErrorCode
+			if len(errs) > 0 {
+				got = errs[0].Code
+			}
+			if got != c.code {
+				t.Errorf("validate() code = %q, want %q (errors: %v)", got, c.code, errs)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryKey(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	yesterday := database.IntervalNumber(now.Truncate(24*time.Hour)) - intervalsPerDay
+	validKey := base64.StdEncoding.EncodeToString(make([]byte, database.KeyLength))
+
+	v := &validator{maxIntervalAge: 14 * 24 * time.Hour, requireAligned: true}
+	data := &database.Publish{
+		Keys: []database.ExposureKey{
+			{Key: "bad", IntervalNumber: yesterday, IntervalCount: 144},
+			{Key: validKey, IntervalNumber: yesterday - intervalsPerDay, IntervalCount: 144},
+			{Key: validKey, IntervalNumber: yesterday + 1, IntervalCount: 143},
+		},
+	}
+
+	got := v.validate(data, now, &model.AuthorizedApp{})
+	for i := range got {
+		got[i].Message = ""
+	}
+	want := []KeyError{
+		{Index: 0, Code: ErrorBadKey},
+		{Index: 2, Code: ErrorBadInterval},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("validate() mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestValidateSet(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	today := database.IntervalNumber(now.Truncate(24 * time.Hour))
+	validKey := base64.StdEncoding.EncodeToString(make([]byte, database.KeyLength))
+
+	v := &validator{maxIntervalAge: 14 * 24 * time.Hour, maxKeysPerDay: 2}
+	data := &database.Publish{
+		Keys: []database.ExposureKey{
+			{Key: validKey, IntervalNumber: today - 144, IntervalCount: 48},
+			{Key: validKey, IntervalNumber: today - 96, IntervalCount: 48},
+			{Key: validKey, IntervalNumber: today - 48, IntervalCount: 48},
+			{Key: validKey, IntervalNumber: today - 60, IntervalCount: 12},
+			{Key: validKey, IntervalNumber: today - 288, IntervalCount: 144},
+		},
+	}
+
+	cases := []struct {
+		name string
+		app  *model.AuthorizedApp
+		want []KeyError
+	}{
+		{
+			name: "default",
+			app:  &model.AuthorizedApp{},
+			want: []KeyError{
+				{Index: 2, Code: ErrorTooManyKeysPerDay},
+				{Index: 3, Code: ErrorOverlappingInterval},
+			},
+		},
+		{
+			name: "app_override",
+			app:  &model.AuthorizedApp{MaxKeysPerDay: 3},
+			want: []KeyError{
+				{Index: 3, Code: ErrorOverlappingInterval},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			got := v.validate(data, now, c.app)
+			for i := range got {
+				got[i].Message = ""
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("validate() mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
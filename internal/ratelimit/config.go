@@ -0,0 +1,56 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"time"
+)
+
+// Types of rate limit store.
+const (
+	TypeNoop   = "NOOP"
+	TypeMemory = "MEMORY"
+	TypeRedis  = "REDIS"
+)
+
+// Config configures rate limiting.
+type Config struct {
+	// Type is the store that holds the rate limit state: NOOP disables rate
+	// limiting, MEMORY keeps it in each server instance and REDIS shares it
+	// between instances.
+	Type string `envconfig:"RATE_LIMIT_TYPE" default:"MEMORY"`
+
+	// Tokens and Interval are the default limit: at most Tokens requests from
+	// a client in any Interval. Authorized apps can override it.
+	Tokens   int           `envconfig:"RATE_LIMIT_TOKENS" default:"60"`
+	Interval time.Duration `envconfig:"RATE_LIMIT_INTERVAL" default:"1h"`
+
+	// TrustedProxies is the number of load balancers in front of the server
+	// that append to X-Forwarded-For, such as 1 on Cloud Run or behind a
+	// Google Cloud load balancer. Clients are told apart by the address the
+	// outermost of them received the request from. With zero, the default,
+	// X-Forwarded-For is ignored and clients are told apart by the address of
+	// their connection, as any client can set the header.
+	TrustedProxies int `envconfig:"TRUSTED_PROXIES" default:"0"`
+
+	// RedisAddress is the host:port of the Redis server when Type is REDIS.
+	RedisAddress  string `envconfig:"RATE_LIMIT_REDIS_ADDRESS"`
+	RedisPassword string `envconfig:"RATE_LIMIT_REDIS_PASSWORD"`
+}
+
+// Limit returns the default limit.
+func (c *Config) Limit() Limit {
+	return Limit{Tokens: c.Tokens, Interval: c.Interval}
+}
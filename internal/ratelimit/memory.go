@@ -0,0 +1,87 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the memory store forgets full buckets.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	available float64
+	updated   time.Time
+	interval  time.Duration
+}
+
+// MemoryStore keeps buckets in memory. Each server instance limits clients
+// on its own, so a client's effective limit grows with the number of
+// instances.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Take implements Store.
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{available: float64(limit.Tokens), updated: now}
+		s.buckets[key] = b
+	}
+	b.available = refill(b.available, now.Sub(b.updated), limit)
+	b.updated = now
+	b.interval = limit.Interval
+
+	if b.available < 1 {
+		return false, wait(b.available, limit), nil
+	}
+	b.available--
+	return true, 0, nil
+}
+
+// sweep deletes buckets that have been refilled completely, they are the same
+// as a new bucket.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) >= b.interval {
+			delete(s.buckets, key)
+		}
+	}
+}
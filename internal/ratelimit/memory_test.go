@@ -0,0 +1,88 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Tokens: 2, Interval: time.Minute}
+
+	now := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+
+	take := func(key string, wantOK bool, wantWait time.Duration) {
+		t.Helper()
+		ok, wait, err := s.Take(ctx, key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != wantOK || wait != wantWait {
+			t.Errorf("Take(%q) = %v, %v, want %v, %v", key, ok, wait, wantOK, wantWait)
+		}
+	}
+
+	take("a", true, 0)
+	take("a", true, 0)
+	take("a", false, 30*time.Second)
+	// Buckets are independent.
+	take("b", true, 0)
+
+	now = now.Add(10 * time.Second)
+	take("a", false, 20*time.Second)
+
+	now = now.Add(20 * time.Second)
+	take("a", true, 0)
+	take("a", false, 30*time.Second)
+
+	// Buckets never hold more than the limit.
+	now = now.Add(time.Hour)
+	take("a", true, 0)
+	take("a", true, 0)
+	take("a", false, 30*time.Second)
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	ctx := context.Background()
+	limit := Limit{Tokens: 1, Interval: time.Minute}
+
+	now := time.Now()
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+
+	if _, _, err := s.Take(ctx, "a", limit); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(30 * time.Second)
+	if _, _, err := s.Take(ctx, "b", limit); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(45 * time.Second)
+	if _, _, err := s.Take(ctx, "c", limit); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.buckets["a"]; ok {
+		t.Errorf("full bucket a was not swept")
+	}
+	if _, ok := s.buckets["b"]; !ok {
+		t.Errorf("bucket b was swept before it was full")
+	}
+}
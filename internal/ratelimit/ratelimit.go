@@ -0,0 +1,147 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit limits the rate of requests from a client with a token
+// bucket per client.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Limit is the number of requests a client may make in an interval. A client
+// may use all its tokens in a burst; they are refilled at a constant rate over
+// the interval.
+type Limit struct {
+	Tokens   int
+	Interval time.Duration
+}
+
+// Store takes tokens from the buckets of clients.
+type Store interface {
+	// Take takes a token from the bucket of key. If the bucket is empty, it
+	// returns false and the time until a token is available.
+	Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+// New creates the Store configured by config.
+func New(ctx context.Context, config *Config) (Store, error) {
+	switch config.Type {
+	case TypeNoop, "":
+		return noopStore{}, nil
+	case TypeMemory:
+		return NewMemoryStore(), nil
+	case TypeRedis:
+		if config.RedisAddress == "" {
+			return nil, fmt.Errorf("RATE_LIMIT_REDIS_ADDRESS is required for %v", TypeRedis)
+		}
+		return NewRedisStore(config.RedisAddress, config.RedisPassword), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit type %q", config.Type)
+	}
+}
+
+type noopStore struct{}
+
+func (noopStore) Take(context.Context, string, Limit) (bool, time.Duration, error) {
+	return true, 0, nil
+}
+
+// refill returns the tokens in a bucket that had available tokens elapsed ago.
+func refill(available float64, elapsed time.Duration, limit Limit) float64 {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	available += float64(elapsed) * float64(limit.Tokens) / float64(limit.Interval)
+	return math.Min(available, float64(limit.Tokens))
+}
+
+// wait returns the time until a bucket with available tokens has a whole one,
+// to the millisecond.
+func wait(available float64, limit Limit) time.Duration {
+	d := time.Duration((1 - available) * float64(limit.Interval) / float64(limit.Tokens))
+	return d.Round(time.Millisecond)
+}
+
+// ClientIP returns the IP address of the client that made r. Each of the
+// trustedProxies load balancers in front of the server appends the address it
+// received the request from to X-Forwarded-For, so the client's address is
+// that many entries from the end; earlier entries are set by the client and
+// can't be trusted. With no trusted proxies, or if X-Forwarded-For has fewer
+// entries than there are proxies, it is the address of the connection.
+func ClientIP(r *http.Request, trustedProxies int) string {
+	if ip := forwardedFor(r.Header.Values("X-Forwarded-For"), trustedProxies); ip != "" {
+		return ip
+	}
+	return hostOf(r.RemoteAddr)
+}
+
+// GRPCClientIP returns the address of the client of a gRPC request. Like
+// ClientIP, it only trusts the x-forwarded-for metadata set by trustedProxies
+// load balancers, and otherwise returns the address of the peer.
+func GRPCClientIP(ctx context.Context, trustedProxies int) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ip := forwardedFor(md.Get("x-forwarded-for"), trustedProxies); ip != "" {
+			return ip
+		}
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	return hostOf(p.Addr.String())
+}
+
+// forwardedFor returns the entry of the X-Forwarded-For values that was added
+// by the outermost of trustedProxies, or an empty string if there is none.
+func forwardedFor(values []string, trustedProxies int) string {
+	if trustedProxies <= 0 {
+		return ""
+	}
+	var entries []string
+	for _, v := range values {
+		for _, e := range strings.Split(v, ",") {
+			entries = append(entries, strings.TrimSpace(e))
+		}
+	}
+	if len(entries) < trustedProxies {
+		return ""
+	}
+	return entries[len(entries)-trustedProxies]
+}
+
+// hostOf returns the host of a host:port address, or addr if it has no port.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// RetryAfter returns the value of a Retry-After header for a wait of d, in
+// whole seconds.
+func RetryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
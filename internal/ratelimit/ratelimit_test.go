@@ -0,0 +1,75 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		name    string
+		xff     string
+		proxies int
+		want    string
+	}{
+		{name: "remote_addr", proxies: 1, want: "192.0.2.1"},
+		{name: "untrusted", xff: "198.51.100.1", want: "192.0.2.1"},
+		{name: "forwarded", xff: "198.51.100.1", proxies: 1, want: "198.51.100.1"},
+		{name: "spoofed", xff: "203.0.113.9, 198.51.100.1", proxies: 1, want: "198.51.100.1"},
+		{name: "two_proxies", xff: "203.0.113.9, 198.51.100.1, 198.51.100.2", proxies: 2, want: "198.51.100.1"},
+		{name: "too_few_entries", xff: "198.51.100.1", proxies: 2, want: "192.0.2.1"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", nil)
+			if tc.xff != "" {
+				r.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			if got := ClientIP(r, tc.proxies); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGRPCClientIP(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", "203.0.113.9, 198.51.100.1"))
+
+	if got, want := GRPCClientIP(ctx, 0), "192.0.2.1"; got != want {
+		t.Errorf("untrusted: got %q, want %q", got, want)
+	}
+	if got, want := GRPCClientIP(ctx, 1), "198.51.100.1"; got != want {
+		t.Errorf("one proxy: got %q, want %q", got, want)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if got, want := RetryAfter(1500*time.Millisecond), "2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
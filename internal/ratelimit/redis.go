@@ -0,0 +1,94 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/redis"
+)
+
+// takeScript takes a token from the bucket stored in the hash KEYS[1]. Its
+// arguments are the limit's tokens, its interval in milliseconds and the
+// current time in milliseconds. It returns whether a token was taken and
+// otherwise the milliseconds until one is available.
+const takeScript = `
+local tokens = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'available', 'updated')
+local available = tonumber(state[1])
+local updated = tonumber(state[2])
+if available == nil or updated == nil then
+  available = tokens
+  updated = now
+end
+available = math.min(tokens, available + math.max(0, now - updated) * tokens / interval)
+local allowed = 0
+local wait = 0
+if available >= 1 then
+  available = available - 1
+  allowed = 1
+else
+  wait = math.ceil((1 - available) * interval / tokens)
+end
+redis.call('HSET', KEYS[1], 'available', tostring(available), 'updated', tostring(now))
+redis.call('PEXPIRE', KEYS[1], interval)
+return {allowed, wait}
+`
+
+// RedisStore keeps buckets in Redis, so that every server instance shares
+// them. Buckets expire once they would be full.
+type RedisStore struct {
+	client *redis.Client
+	now    func() time.Time
+}
+
+// NewRedisStore creates a RedisStore for the Redis server at addr.
+// Connections are opened when needed.
+func NewRedisStore(addr, password string) *RedisStore {
+	return &RedisStore{
+		client: redis.New(addr, password),
+		now:    time.Now,
+	}
+}
+
+// Take implements Store.
+func (s *RedisStore) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	reply, err := s.client.Do(ctx, "EVAL", takeScript, "1", "ratelimit:"+key,
+		strconv.Itoa(limit.Tokens),
+		strconv.FormatInt(limit.Interval.Milliseconds(), 10),
+		strconv.FormatInt(s.now().UnixNano()/int64(time.Millisecond), 10))
+	if err != nil {
+		return false, 0, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	allowed, ok := values[0].(int64)
+	if !ok {
+		return false, 0, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	waitMillis, ok := values[1].(int64)
+	if !ok {
+		return false, 0, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	return allowed == 1, time.Duration(waitMillis) * time.Millisecond, nil
+}
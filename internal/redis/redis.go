@@ -0,0 +1,193 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redis is a minimal client of Redis, which shares state between the
+// instances of a service, such as rate limits and cached configuration.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	poolSize    = 16
+	dialTimeout = 5 * time.Second
+)
+
+// Error is an error reply from Redis.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Client sends commands to a Redis server over a small pool of connections.
+// It is safe for concurrent use.
+type Client struct {
+	addr     string
+	password string
+	pool     chan *conn
+}
+
+// New creates a Client of the Redis server at addr. Connections are opened
+// when needed.
+func New(addr, password string) *Client {
+	return &Client{
+		addr:     addr,
+		password: password,
+		pool:     make(chan *conn, poolSize),
+	}
+}
+
+// Do sends a command and returns its reply. Integers are returned as int64,
+// strings as string, arrays as []interface{} and nil bulk strings and arrays
+// as nil. An error reply is returned as an Error.
+func (c *Client) Do(ctx context.Context, args ...string) (interface{}, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		cn.SetDeadline(deadline)
+	} else {
+		cn.SetDeadline(time.Time{})
+	}
+	reply, err := cn.do(args...)
+	if err != nil {
+		// The connection can be reused after an error reply, but not after a
+		// network or protocol error.
+		if _, ok := err.(Error); ok {
+			c.put(cn)
+		} else {
+			cn.Close()
+		}
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	c.put(cn)
+	return reply, nil
+}
+
+// get returns a pooled connection or opens a new one.
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.pool:
+		return cn, nil
+	default:
+	}
+
+	d := net.Dialer{Timeout: dialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to redis: %w", err)
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if c.password != "" {
+		if _, err := cn.do("AUTH", c.password); err != nil {
+			cn.Close()
+			return nil, fmt.Errorf("authenticating to redis: %w", err)
+		}
+	}
+	return cn, nil
+}
+
+// put returns a connection to the pool, or closes it if the pool is full.
+func (c *Client) put(cn *conn) {
+	select {
+	case c.pool <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+// conn is a connection that speaks the Redis protocol, RESP.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// do sends a command and reads its reply.
+func (c *conn) do(args ...string) (interface{}, error) {
+	w := bufio.NewWriter(c.Conn)
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+// readReply reads a RESP reply. Integers are returned as int64, strings as
+// string, arrays as []interface{} and nil bulk strings and arrays as nil.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, Error(line)
+	case ':':
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed integer %q", line)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("malformed bulk string length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("malformed array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unknown reply type %q", kind)
+	}
+}
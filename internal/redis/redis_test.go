@@ -0,0 +1,62 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadReply(t *testing.T) {
+	cases := []struct {
+		name  string
+		reply string
+		want  interface{}
+		err   string
+	}{
+		{name: "simple_string", reply: "+OK\r\n", want: "OK"},
+		{name: "integer", reply: ":42\r\n", want: int64(42)},
+		{name: "bulk_string", reply: "$5\r\nhello\r\n", want: "hello"},
+		{name: "nil", reply: "$-1\r\n", want: nil},
+		{name: "array", reply: "*2\r\n:1\r\n:250\r\n", want: []interface{}{int64(1), int64(250)}},
+		{name: "error", reply: "-ERR wrong\r\n", err: "ERR wrong"},
+		{name: "unknown", reply: "?x\r\n", err: "unknown reply type"},
+		{name: "malformed", reply: "+OK\n", err: "malformed reply"},
+		{name: "truncated", reply: "$5\r\nhel", err: "EOF"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readReply(bufio.NewReader(strings.NewReader(tc.reply)))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package requestlog logs one line per request served by the servers.
+//
+// Requests to this system carry exposure keys, verification certificates and
+// the IP addresses of people who may have tested positive, so the log line
+// only holds the method, the path without its query, the status, the latency
+// and the app package named by the request. Bodies, headers and query values
+// are never logged, and the client's IP is left out or replaced by a keyed
+// hash, as selected by Config.
+package requestlog
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/ratelimit"
+
+	kenvconfig "github.com/kelseyhightower/envconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// IPMode selects how the client's IP address is logged.
+type IPMode string
+
+const (
+	// IPModeDrop leaves the client's IP out of the logs.
+	IPModeDrop IPMode = "DROP"
+	// IPModeHash logs a keyed hash of the client's IP, so the requests of a
+	// client can be correlated without logging its address.
+	IPModeHash IPMode = "HASH"
+)
+
+// Config defines the configuration of request logging.
+type Config struct {
+	IPMode IPMode `envconfig:"LOG_CLIENT_IP" default:"HASH"`
+
+	// IPHashKey is the key of the client IP hashes. If it is empty, every
+	// instance uses a random key, so hashes only match within an instance
+	// until it restarts.
+	IPHashKey string `envconfig:"LOG_CLIENT_IP_HASH_KEY"`
+
+	// TrustedProxies is the number of load balancers in front of the server
+	// whose X-Forwarded-For entries are trusted to find the client's IP, see
+	// ratelimit.ClientIP.
+	TrustedProxies int `envconfig:"TRUSTED_PROXIES" default:"0"`
+
+	// SkipPaths are paths whose requests aren't logged, such as probes.
+	SkipPaths []string `envconfig:"LOG_REQUEST_SKIP_PATHS" default:"/healthz,/readyz,/metrics"`
+}
+
+// ConfigFromEnv reads the request logging configuration from the environment.
+func ConfigFromEnv() (*Config, error) {
+	var config Config
+	if err := kenvconfig.Process("", &config); err != nil {
+		return nil, fmt.Errorf("failed to process request logging config: %w", err)
+	}
+	return &config, nil
+}
+
+// Logger logs requests. A nil Logger logs nothing.
+type Logger struct {
+	mode    IPMode
+	key     []byte
+	proxies int
+	skip    map[string]bool
+}
+
+// New creates a Logger from config.
+func New(config *Config) (*Logger, error) {
+	l := &Logger{
+		mode:    config.IPMode,
+		proxies: config.TrustedProxies,
+		skip:    make(map[string]bool, len(config.SkipPaths)),
+	}
+	for _, p := range config.SkipPaths {
+		l.skip[p] = true
+	}
+	switch config.IPMode {
+	case IPModeDrop:
+	case IPModeHash:
+		l.key = []byte(config.IPHashKey)
+		if len(l.key) == 0 {
+			l.key = make([]byte, 32)
+			if _, err := rand.Read(l.key); err != nil {
+				return nil, fmt.Errorf("generating client IP hash key: %w", err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown client IP mode: %v", config.IPMode)
+	}
+	return l, nil
+}
+
+type infoKey struct{}
+
+// requestInfo holds what handlers add to the log line of their request.
+type requestInfo struct {
+	mu         sync.Mutex
+	appPackage string
+}
+
+// SetAppPackage records the app package that ctx's request was made for, so
+// it is logged with the request. It does nothing outside of a logged request.
+func SetAppPackage(ctx context.Context, appPackage string) {
+	if info, ok := ctx.Value(infoKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		info.appPackage = appPackage
+		info.mu.Unlock()
+	}
+}
+
+// Handler logs the requests served by h.
+func (l *Logger) Handler(h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.skip[r.URL.Path] {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		info := &requestInfo{}
+		ctx := context.WithValue(r.Context(), infoKey{}, info)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+
+		l.log(ctx, info, start, ratelimit.ClientIP(r, l.proxies),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status)
+	})
+}
+
+// UnaryInterceptor logs the unary RPCs served by a gRPC server. The app
+// package is taken from requests with an app package name field.
+func (l *Logger) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if l == nil {
+		return handler(ctx, req)
+	}
+
+	start := time.Now()
+	ri := &requestInfo{}
+	if r, ok := req.(interface{ GetAppPackageName() string }); ok {
+		ri.appPackage = r.GetAppPackageName()
+	}
+	ctx = context.WithValue(ctx, infoKey{}, ri)
+	resp, err := handler(ctx, req)
+
+	l.log(ctx, ri, start, ratelimit.GRPCClientIP(ctx, l.proxies),
+		"method", info.FullMethod,
+		"code", status.Code(err).String())
+	return resp, err
+}
+
+func (l *Logger) log(ctx context.Context, info *requestInfo, start time.Time, clientIP string, keysAndValues ...interface{}) {
+	keysAndValues = append(keysAndValues, "latency", time.Since(start))
+	info.mu.Lock()
+	if info.appPackage != "" {
+		keysAndValues = append(keysAndValues, "appPackage", info.appPackage)
+	}
+	info.mu.Unlock()
+	if l.mode == IPModeHash && clientIP != "" {
+		keysAndValues = append(keysAndValues, "client", l.hashIP(clientIP))
+	}
+	logging.FromContext(ctx).Infow("request", keysAndValues...)
+}
+
+// hashIP returns the truncated HMAC-SHA256 of ip.
+func (l *Logger) hashIP(ip string) string {
+	mac := hmac.New(sha256.New, l.key)
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
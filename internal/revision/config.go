@@ -0,0 +1,35 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package revision
+
+import (
+	"time"
+)
+
+// Config configures the encryption of revision tokens.
+type Config struct {
+	// KeyID is the KMS key that wraps the keys revision tokens are encrypted
+	// with. If empty, revision tokens are random and not encrypted.
+	KeyID string `envconfig:"REVISION_TOKEN_KEY_ID"`
+
+	// KeyCacheDuration is how long unwrapped revision keys are cached before
+	// they are reloaded to pick up a rotation.
+	KeyCacheDuration time.Duration `envconfig:"REVISION_TOKEN_KEY_CACHE_DURATION" default:"5m"`
+}
+
+// Enabled returns true if revision tokens are encrypted.
+func (c *Config) Enabled() bool {
+	return c != nil && c.KeyID != ""
+}
@@ -0,0 +1,335 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package revision issues and reads revision tokens. A revision token is
+// returned to a device when it publishes keys, and must be presented to revise
+// those keys later. Tokens are encrypted with keys that are stored in the
+// database, wrapped by a KMS key, and rotated periodically.
+package revision
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+)
+
+// keySize is the size of an AES-256 revision key.
+const keySize = 32
+
+// keyAAD is the additional authenticated data used when wrapping revision
+// keys with the KMS.
+var keyAAD = []byte("revision-token-key")
+
+// TokenKey identifies an exposure key a revision token was issued for.
+type TokenKey struct {
+	Key            string `json:"k"`
+	IntervalNumber int32  `json:"i"`
+	IntervalCount  int32  `json:"c"`
+}
+
+// Token is the decrypted content of a revision token.
+type Token struct {
+	// ID identifies the publish the token was first issued for. The hash of
+	// the ID is stored with each exposure, and stays the same when a token is
+	// reissued after a revision.
+	ID string `json:"id"`
+
+	// Keys are the exposure keys the token was issued for.
+	Keys []TokenKey `json:"keys"`
+}
+
+// AddKeys adds the keys of exposures to the token, skipping keys that are
+// already listed.
+func (t *Token) AddKeys(exposures []*database.Exposure) {
+	seen := make(map[string]struct{}, len(t.Keys))
+	for _, k := range t.Keys {
+		seen[k.Key] = struct{}{}
+	}
+	for _, exp := range exposures {
+		key := base64.StdEncoding.EncodeToString(exp.ExposureKey)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		t.Keys = append(t.Keys, TokenKey{Key: key, IntervalNumber: exp.IntervalNumber, IntervalCount: exp.IntervalCount})
+	}
+}
+
+// IsEncrypted returns true if encoded has the format of an encrypted token,
+// rather than a random token issued while encryption was disabled.
+func IsEncrypted(encoded string) bool {
+	return strings.Contains(encoded, ".")
+}
+
+// TokenManager encrypts and decrypts revision tokens.
+type TokenManager struct {
+	db            *database.DB
+	wrapper       database.KeyWrapper
+	kmsKeyID      string
+	cacheDuration time.Duration
+
+	mu       sync.RWMutex
+	keys     map[int64]cipher.AEAD
+	active   int64
+	loadedAt time.Time
+}
+
+// New creates a TokenManager that wraps revision keys with the KMS key in
+// config. A revision key is created if none is active.
+func New(ctx context.Context, db *database.DB, wrapper database.KeyWrapper, config *Config) (*TokenManager, error) {
+	if !config.Enabled() {
+		return nil, errors.New("revision token key is not configured")
+	}
+	tm := &TokenManager{
+		db:            db,
+		wrapper:       wrapper,
+		kmsKeyID:      config.KeyID,
+		cacheDuration: config.KeyCacheDuration,
+	}
+	if err := tm.load(ctx); err != nil {
+		return nil, err
+	}
+	if tm.active == 0 {
+		if err := tm.Rotate(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return tm, nil
+}
+
+// load reads and unwraps every revision key.
+func (tm *TokenManager) load(ctx context.Context) error {
+	stored, err := tm.db.ListRevisionKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[int64]cipher.AEAD, len(stored))
+	var active int64
+	for _, k := range stored {
+		raw, err := tm.wrapper.Decrypt(ctx, k.KMSKeyID, k.WrappedKey, keyAAD)
+		if err != nil {
+			return fmt.Errorf("unwrapping revision key %d: %w", k.KeyID, err)
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return fmt.Errorf("revision key %d: %w", k.KeyID, err)
+		}
+		keys[k.KeyID] = aead
+		if k.Active {
+			active = k.KeyID
+		}
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.keys = keys
+	tm.active = active
+	tm.loadedAt = time.Now()
+	return nil
+}
+
+func newAEAD(raw []byte) (cipher.AEAD, error) {
+	if len(raw) != keySize {
+		return nil, fmt.Errorf("key has length %d, want %d", len(raw), keySize)
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// activeKey returns the key new tokens are encrypted with, reloading the keys
+// if they may be stale.
+func (tm *TokenManager) activeKey(ctx context.Context) (int64, cipher.AEAD, error) {
+	tm.mu.RLock()
+	id, loadedAt := tm.active, tm.loadedAt
+	tm.mu.RUnlock()
+
+	if id == 0 || time.Since(loadedAt) > tm.cacheDuration {
+		if err := tm.load(ctx); err != nil {
+			if id == 0 {
+				return 0, nil, err
+			}
+			// Keep encrypting with the previous key; it is still valid.
+			logging.FromContext(ctx).Errorf("reloading revision keys: %v", err)
+		}
+	}
+
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if tm.active == 0 {
+		return 0, nil, errors.New("no active revision key")
+	}
+	return tm.active, tm.keys[tm.active], nil
+}
+
+// key returns the key with the given ID, reloading the keys if it isn't known
+// yet.
+func (tm *TokenManager) key(ctx context.Context, id int64) (cipher.AEAD, error) {
+	tm.mu.RLock()
+	aead, ok := tm.keys[id]
+	tm.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	if err := tm.load(ctx); err != nil {
+		return nil, err
+	}
+	tm.mu.RLock()
+	aead, ok = tm.keys[id]
+	tm.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown revision key %d", database.ErrInvalidRevisionToken, id)
+	}
+	return aead, nil
+}
+
+// MakeRevisionToken encrypts token with the active key. The token can only be
+// decrypted for the same app.
+func (tm *TokenManager) MakeRevisionToken(ctx context.Context, appPackageName string, token *Token) (string, error) {
+	id, aead, err := tm.activeKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return "", fmt.Errorf("marshalling revision token: %w", err)
+	}
+	return seal(id, aead, []byte(appPackageName), plaintext)
+}
+
+// UnmarshalRevisionToken decrypts a token made by MakeRevisionToken for the
+// same app. Errors caused by an invalid token wrap
+// database.ErrInvalidRevisionToken.
+func (tm *TokenManager) UnmarshalRevisionToken(ctx context.Context, encoded, appPackageName string) (*Token, error) {
+	id, ciphertext, err := parseToken(encoded)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := tm.key(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(aead, []byte(appPackageName), ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	var token Token
+	if err := json.Unmarshal(plaintext, &token); err != nil {
+		return nil, fmt.Errorf("%w: %v", database.ErrInvalidRevisionToken, err)
+	}
+	if token.ID == "" {
+		return nil, fmt.Errorf("%w: missing id", database.ErrInvalidRevisionToken)
+	}
+	return &token, nil
+}
+
+func seal(id int64, aead cipher.AEAD, aad, plaintext []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generating nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, aad)
+	return strconv.FormatInt(id, 10) + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func open(aead cipher.AEAD, aad, ciphertext []byte) ([]byte, error) {
+	n := aead.NonceSize()
+	if len(ciphertext) < n {
+		return nil, fmt.Errorf("%w: too short", database.ErrInvalidRevisionToken)
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:n], ciphertext[n:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", database.ErrInvalidRevisionToken, err)
+	}
+	return plaintext, nil
+}
+
+// parseToken splits an encrypted token into its key ID and ciphertext.
+func parseToken(encoded string) (int64, []byte, error) {
+	parts := strings.SplitN(encoded, ".", 2)
+	if len(parts) != 2 {
+		return 0, nil, fmt.Errorf("%w: malformed token", database.ErrInvalidRevisionToken)
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: malformed key id", database.ErrInvalidRevisionToken)
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: malformed ciphertext", database.ErrInvalidRevisionToken)
+	}
+	return id, ciphertext, nil
+}
+
+// Rotate creates a new revision key, wrapped by the current version of the
+// KMS key, and makes it the active key. Tokens encrypted with older keys stay
+// readable until the keys are deleted.
+func (tm *TokenManager) Rotate(ctx context.Context) error {
+	raw := make([]byte, keySize)
+	if _, err := rand.Read(raw); err != nil {
+		return fmt.Errorf("generating revision key: %w", err)
+	}
+	wrapped, err := tm.wrapper.Encrypt(ctx, tm.kmsKeyID, raw, keyAAD)
+	if err != nil {
+		return fmt.Errorf("wrapping revision key: %w", err)
+	}
+	if _, err := tm.db.CreateRevisionKey(ctx, tm.kmsKeyID, wrapped); err != nil {
+		return err
+	}
+	return tm.load(ctx)
+}
+
+// RotateKeys rotates the active key if it was created more than period ago,
+// and deletes keys that were retired before deleteBefore. It returns whether
+// the key was rotated and the number of keys deleted.
+func (tm *TokenManager) RotateKeys(ctx context.Context, period time.Duration, deleteBefore time.Time) (bool, int64, error) {
+	keys, err := tm.db.ListRevisionKeys(ctx)
+	if err != nil {
+		return false, 0, err
+	}
+
+	rotated := false
+	for _, k := range keys {
+		if k.Active && time.Since(k.CreatedAt) > period {
+			if err := tm.Rotate(ctx); err != nil {
+				return false, 0, err
+			}
+			rotated = true
+			break
+		}
+	}
+
+	deleted, err := tm.db.DeleteRetiredRevisionKeys(ctx, deleteBefore)
+	if err != nil {
+		return rotated, 0, err
+	}
+	return rotated, deleted, nil
+}
@@ -0,0 +1,184 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package revision
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/go-cmp/cmp"
+)
+
+var testDB *database.DB
+
+func TestMain(m *testing.M) {
+	ctx := context.Background()
+
+	if os.Getenv("DB_USER") != "" {
+		var err error
+		testDB, err = database.CreateTestDB(ctx)
+		if err != nil {
+			log.Fatalf("creating test DB: %v", err)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// fakeKeyWrapper "wraps" keys with a fixed AES-GCM key, standing in for a
+// KMS.
+type fakeKeyWrapper struct{}
+
+func (fakeKeyWrapper) aead() cipher.AEAD {
+	block, _ := aes.NewCipher(bytes.Repeat([]byte{7}, 32))
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+func (w fakeKeyWrapper) Encrypt(_ context.Context, keyID string, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, w.aead().NonceSize())
+	return w.aead().Seal(nonce, nonce, plaintext, append([]byte(keyID), aad...)), nil
+}
+
+func (w fakeKeyWrapper) Decrypt(_ context.Context, keyID string, ciphertext, aad []byte) ([]byte, error) {
+	n := w.aead().NonceSize()
+	if len(ciphertext) < n {
+		return nil, errors.New("short ciphertext")
+	}
+	return w.aead().Open(nil, ciphertext[:n], ciphertext[n:], append([]byte(keyID), aad...))
+}
+
+func TestSealOpen(t *testing.T) {
+	aead, err := newAEAD(bytes.Repeat([]byte{1}, keySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte(`{"id":"abc"}`)
+
+	encoded, err := seal(3, aead, []byte("com.example.app"), plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encoded) {
+		t.Errorf("IsEncrypted(%q) = false", encoded)
+	}
+
+	id, ciphertext, err := parseToken(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 3 {
+		t.Errorf("got key id %d, want 3", id)
+	}
+	got, err := open(aead, []byte("com.example.app"), ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("got %q, want %q", got, plaintext)
+	}
+
+	if _, err := open(aead, []byte("com.example.other"), ciphertext); !errors.Is(err, database.ErrInvalidRevisionToken) {
+		t.Errorf("open with another app: got %v, want ErrInvalidRevisionToken", err)
+	}
+	for _, bad := range []string{"", "abc", "x.abc", "1.!!"} {
+		if _, _, err := parseToken(bad); !errors.Is(err, database.ErrInvalidRevisionToken) {
+			t.Errorf("parseToken(%q): got %v, want ErrInvalidRevisionToken", bad, err)
+		}
+	}
+}
+
+func TestTokenAddKeys(t *testing.T) {
+	token := &Token{ID: "abc", Keys: []TokenKey{{Key: "AQEBAQEBAQEBAQEBAQEBAQ==", IntervalNumber: 144, IntervalCount: 144}}}
+	token.AddKeys([]*database.Exposure{
+		{ExposureKey: bytes.Repeat([]byte{1}, 16), IntervalNumber: 144, IntervalCount: 144},
+		{ExposureKey: bytes.Repeat([]byte{2}, 16), IntervalNumber: 288, IntervalCount: 144},
+	})
+
+	want := &Token{
+		ID: "abc",
+		Keys: []TokenKey{
+			{Key: "AQEBAQEBAQEBAQEBAQEBAQ==", IntervalNumber: 144, IntervalCount: 144},
+			{Key: "AgICAgICAgICAgICAgICAg==", IntervalNumber: 288, IntervalCount: 144},
+		},
+	}
+	if diff := cmp.Diff(want, token); diff != "" {
+		t.Errorf("AddKeys mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTokenManager(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test DB")
+	}
+	defer database.ResetTestDB(t, testDB)
+	ctx := context.Background()
+
+	config := &Config{KeyID: "kms-key", KeyCacheDuration: time.Minute}
+	tm, err := New(ctx, testDB, fakeKeyWrapper{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := &Token{ID: "abc", Keys: []TokenKey{{Key: "AQEBAQEBAQEBAQEBAQEBAQ==", IntervalNumber: 144, IntervalCount: 144}}}
+	encoded, err := tm.MakeRevisionToken(ctx, "com.example.app", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens issued with a retired key remain readable, including by another
+	// server.
+	if err := tm.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	other, err := New(ctx, testDB, fakeKeyWrapper{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := other.UnmarshalRevisionToken(ctx, encoded, "com.example.app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(token, got); diff != "" {
+		t.Errorf("UnmarshalRevisionToken mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := other.UnmarshalRevisionToken(ctx, encoded, "com.example.other"); !errors.Is(err, database.ErrInvalidRevisionToken) {
+		t.Errorf("got %v, want ErrInvalidRevisionToken", err)
+	}
+
+	// Once the retired key is deleted its tokens are invalid.
+	rotated, deleted, err := tm.RotateKeys(ctx, time.Hour, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated || deleted != 1 {
+		t.Errorf("RotateKeys = %v, %d; want false, 1", rotated, deleted)
+	}
+	fresh, err := New(ctx, testDB, fakeKeyWrapper{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fresh.UnmarshalRevisionToken(ctx, encoded, "com.example.app"); !errors.Is(err, database.ErrInvalidRevisionToken) {
+		t.Errorf("got %v, want ErrInvalidRevisionToken", err)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package runtimeconfig reloads selected settings from the database while
+// servers run, and notifies listeners when tables of configuration change.
+package runtimeconfig
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+)
+
+// Reloadable maps the settings that servers reload at runtime to their kind,
+// "duration" or "int". Other settings in the ConfigSetting table are ignored.
+var Reloadable = map[string]string{
+	"CLEANUP_TTL":             "duration",
+	"EXPORT_FILE_MAX_RECORDS": "int",
+	"MAX_KEYS_PER_DAY":        "int",
+}
+
+// Validate returns an error if name isn't a reloadable setting or value isn't
+// valid for it.
+func Validate(name, value string) error {
+	var err error
+	switch Reloadable[name] {
+	case "duration":
+		_, err = time.ParseDuration(value)
+	case "int":
+		_, err = strconv.Atoi(value)
+	default:
+		return fmt.Errorf("%s can't be changed at runtime", name)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	return nil
+}
+
+// settingsDB is the part of the database the watcher polls.
+type settingsDB interface {
+	ListConfigSettings(ctx context.Context) ([]*database.ConfigSetting, error)
+	ConfigVersions(ctx context.Context) (map[string]int64, error)
+}
+
+// Watcher polls the database for changes to the configuration. The settings
+// in the ConfigSetting table override the environment variables of the same
+// name that support it, see Duration and Int. A nil Watcher has no overrides
+// and never notifies, so servers without one use their environment.
+type Watcher struct {
+	db       settingsDB
+	interval time.Duration
+
+	mu        sync.RWMutex
+	versions  map[string]int64
+	settings  map[string]string
+	listeners map[string][]func(ctx context.Context)
+}
+
+// New creates a Watcher that polls the database every interval once Run is
+// called.
+func New(db *database.DB, interval time.Duration) *Watcher {
+	return &Watcher{
+		db:        db,
+		interval:  interval,
+		listeners: make(map[string][]func(ctx context.Context)),
+	}
+}
+
+// OnChange registers fn to be called after the table changes, where table is
+// one of the database.ConfigTable constants. Listeners are called from the
+// polling goroutine, so they should only invalidate caches.
+func (w *Watcher) OnChange(table string, fn func(ctx context.Context)) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.listeners[table] = append(w.listeners[table], fn)
+}
+
+// Run polls the database until ctx is done. Errors are logged, and the
+// previous settings are kept until a poll succeeds.
+func (w *Watcher) Run(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.Poll(ctx); err != nil {
+				logger.Errorf("Failed to poll runtime config: %v", err)
+			}
+		}
+	}
+}
+
+// Poll reads the configuration versions once, reloads the settings if they
+// changed, and notifies the listeners of every other table that changed since
+// the previous poll. The first poll only loads the settings.
+func (w *Watcher) Poll(ctx context.Context) error {
+	versions, err := w.db.ConfigVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	w.mu.RLock()
+	first := w.versions == nil
+	var changed []string
+	for table, v := range versions {
+		if v != w.versions[table] {
+			changed = append(changed, table)
+		}
+	}
+	w.mu.RUnlock()
+	sort.Strings(changed)
+
+	if first || contains(changed, database.ConfigTableSettings) {
+		list, err := w.db.ListConfigSettings(ctx)
+		if err != nil {
+			return err
+		}
+		settings := make(map[string]string, len(list))
+		names := make([]string, 0, len(list))
+		for _, s := range list {
+			settings[s.Name] = s.Value
+			names = append(names, s.Name+"="+s.Value)
+		}
+		w.mu.Lock()
+		w.settings = settings
+		w.mu.Unlock()
+		logging.FromContext(ctx).Infof("Loaded runtime settings: %v", names)
+	}
+
+	w.mu.Lock()
+	w.versions = versions
+	var notify []func(ctx context.Context)
+	if !first {
+		for _, table := range changed {
+			notify = append(notify, w.listeners[table]...)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, fn := range notify {
+		fn(ctx)
+	}
+	return nil
+}
+
+// String returns the value of the setting, if it is set.
+func (w *Watcher) String(name string) (string, bool) {
+	if w == nil {
+		return "", false
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	v, ok := w.settings[name]
+	return v, ok
+}
+
+// Duration returns the setting as a duration, or def if it isn't set or isn't
+// a valid duration.
+func (w *Watcher) Duration(name string, def time.Duration) time.Duration {
+	v, ok := w.String(name)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// Int returns the setting as an integer, or def if it isn't set or isn't a
+// valid integer.
+func (w *Watcher) Int(name string, def int) int {
+	v, ok := w.String(name)
+	if !ok {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return i
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
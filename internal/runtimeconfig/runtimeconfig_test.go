@@ -0,0 +1,132 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtimeconfig
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeDB struct {
+	settings []*database.ConfigSetting
+	versions map[string]int64
+	lists    int
+}
+
+func (f *fakeDB) ListConfigSettings(ctx context.Context) ([]*database.ConfigSetting, error) {
+	f.lists++
+	return f.settings, nil
+}
+
+func (f *fakeDB) ConfigVersions(ctx context.Context) (map[string]int64, error) {
+	versions := make(map[string]int64, len(f.versions))
+	for k, v := range f.versions {
+		versions[k] = v
+	}
+	return versions, nil
+}
+
+func TestWatcher(t *testing.T) {
+	ctx := context.Background()
+
+	db := &fakeDB{
+		settings: []*database.ConfigSetting{
+			{Name: "CLEANUP_TTL", Value: "240h"},
+			{Name: "MAX_KEYS_PER_DAY", Value: "many"},
+		},
+		versions: map[string]int64{database.ConfigTableSettings: 1, database.ConfigTableAuthorizedApp: 3},
+	}
+	w := &Watcher{db: db, interval: time.Minute, listeners: make(map[string][]func(ctx context.Context))}
+
+	var notified []string
+	for _, table := range []string{database.ConfigTableAuthorizedApp, database.ConfigTableExportConfig} {
+		table := table
+		w.OnChange(table, func(ctx context.Context) { notified = append(notified, table) })
+	}
+
+	if err := w.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Duration("CLEANUP_TTL", time.Hour); got != 240*time.Hour {
+		t.Errorf("got CLEANUP_TTL %v, want %v", got, 240*time.Hour)
+	}
+	if got := w.Int("MAX_KEYS_PER_DAY", 3); got != 3 {
+		t.Errorf("got invalid MAX_KEYS_PER_DAY %v, want default 3", got)
+	}
+	if got := w.Int("EXPORT_FILE_MAX_RECORDS", 30000); got != 30000 {
+		t.Errorf("got unset EXPORT_FILE_MAX_RECORDS %v, want default 30000", got)
+	}
+	if len(notified) != 0 {
+		t.Errorf("first poll notified %v, want none", notified)
+	}
+
+	// Nothing changed.
+	if err := w.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if db.lists != 1 || len(notified) != 0 {
+		t.Errorf("unchanged poll: got %d lists and notified %v, want 1 and none", db.lists, notified)
+	}
+
+	db.settings = []*database.ConfigSetting{{Name: "MAX_KEYS_PER_DAY", Value: "5"}}
+	db.versions[database.ConfigTableSettings] = 2
+	db.versions[database.ConfigTableExportConfig] = 1
+	if err := w.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Duration("CLEANUP_TTL", time.Hour); got != time.Hour {
+		t.Errorf("got deleted CLEANUP_TTL %v, want default %v", got, time.Hour)
+	}
+	if got := w.Int("MAX_KEYS_PER_DAY", 3); got != 5 {
+		t.Errorf("got MAX_KEYS_PER_DAY %v, want 5", got)
+	}
+	if diff := cmp.Diff([]string{database.ConfigTableExportConfig}, notified); diff != "" {
+		t.Errorf("notified mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestNilWatcher(t *testing.T) {
+	var w *Watcher
+	w.OnChange(database.ConfigTableAuthorizedApp, func(ctx context.Context) {})
+	if got := w.Duration("CLEANUP_TTL", time.Hour); got != time.Hour {
+		t.Errorf("got %v, want default %v", got, time.Hour)
+	}
+	if got := w.Int("MAX_KEYS_PER_DAY", 3); got != 3 {
+		t.Errorf("got %v, want default 3", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"CLEANUP_TTL", "240h", true},
+		{"CLEANUP_TTL", "10", false},
+		{"MAX_KEYS_PER_DAY", "5", true},
+		{"MAX_KEYS_PER_DAY", "five", false},
+		{"DB_PASSWORD", "secret", false},
+	}
+	for _, tc := range cases {
+		if err := Validate(tc.name, tc.value); (err == nil) != tc.ok {
+			t.Errorf("Validate(%q, %q): got %v, want ok %v", tc.name, tc.value, err, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"time"
+)
+
+// Config defines the configuration of the scheduler.
+type Config struct {
+	// LockTTL paces the renewals of the lock of a running job, which happen
+	// every third of LockTTL and check that the lock is still held. A job
+	// stops if its lock is lost.
+	LockTTL time.Duration `envconfig:"SCHEDULER_LOCK_TTL" default:"1m"`
+
+	// Jitter is the most that each wait for a job is randomly extended by, as
+	// a fraction of the job's interval, so that instances don't all try to run
+	// a job at the same moment.
+	Jitter float64 `envconfig:"SCHEDULER_JITTER" default:"0.1"`
+}
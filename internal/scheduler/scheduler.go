@@ -0,0 +1,299 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scheduler runs periodic jobs, such as export batching and cleanup,
+// inside the servers that host them, so that deployments don't need an
+// external scheduler to call them.
+//
+// Any number of instances may run the same jobs. A job only runs while its
+// instance holds the job's database lock, which is renewed while the job
+// runs, and each run is recorded, so that the instances run each job once per
+// interval between them.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/server"
+)
+
+// Results of job runs, which label the run metrics.
+const (
+	resultSuccess  = "success"
+	resultFailure  = "failure"
+	resultSkipped  = "skipped"
+	resultLockLost = "lock_lost"
+)
+
+// minLockTTL is the shortest lock TTL, so that locks aren't renewed more than
+// once a second.
+const minLockTTL = 3 * time.Second
+
+// cleanupTimeout bounds the release of a job's lock and the record of its
+// result, which happen even if the scheduler is stopping.
+const cleanupTimeout = 10 * time.Second
+
+// Job is a job that runs every Interval.
+type Job struct {
+	// Name identifies the job across instances, and labels its metrics.
+	Name     string
+	Interval time.Duration
+
+	// Timeout, if not zero, bounds each run of the job.
+	Timeout time.Duration
+
+	// Run runs the job once. The job must stop when ctx is done, which
+	// happens if its lock is lost.
+	Run func(ctx context.Context) error
+}
+
+// Lock is the lock of a job, see database.JobLock.
+type Lock interface {
+	Renew(ctx context.Context, ttl time.Duration) error
+	Release(ctx context.Context) error
+}
+
+// Store holds the locks of jobs and the records of their runs.
+type Store interface {
+	// AcquireJobLock returns database.ErrAlreadyLocked if another instance
+	// holds the lock.
+	AcquireJobLock(ctx context.Context, name string, ttl time.Duration) (Lock, error)
+	LastJobStart(ctx context.Context, name string) (time.Time, error)
+	RecordJobStart(ctx context.Context, name string, started time.Time) error
+	RecordJobFinish(ctx context.Context, name string, result string) error
+}
+
+// NewDBStore returns a Store that keeps locks and runs in db.
+func NewDBStore(db *database.DB) Store {
+	return &dbStore{db}
+}
+
+type dbStore struct {
+	*database.DB
+}
+
+// AcquireJobLock takes an advisory lock, which is held until it is released
+// or its connection breaks, so ttl only paces its renewals.
+func (s *dbStore) AcquireJobLock(ctx context.Context, name string, ttl time.Duration) (Lock, error) {
+	lock, err := s.DB.AcquireJobLock(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Scheduler runs jobs on their intervals.
+type Scheduler struct {
+	config *Config
+	store  Store
+	jobs   []*Job
+}
+
+// New creates a Scheduler of jobs.
+func New(config *Config, store Store, jobs ...*Job) (*Scheduler, error) {
+	if config.LockTTL < minLockTTL {
+		return nil, fmt.Errorf("SCHEDULER_LOCK_TTL %v is less than the minimum of %v", config.LockTTL, minLockTTL)
+	}
+	if config.Jitter < 0 || config.Jitter > 1 {
+		return nil, fmt.Errorf("SCHEDULER_JITTER %v must be between 0 and 1", config.Jitter)
+	}
+	names := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		if job.Interval <= 0 {
+			return nil, fmt.Errorf("job %v: interval must be positive", job.Name)
+		}
+		if names[job.Name] {
+			return nil, fmt.Errorf("job %v is scheduled twice", job.Name)
+		}
+		names[job.Name] = true
+	}
+	return &Scheduler{config: config, store: store, jobs: jobs}, nil
+}
+
+// Run runs the jobs until ctx is done, and returns once the runs in progress
+// have stopped.
+func (s *Scheduler) Run(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		logger.Infof("Scheduling job %v every %v", job.Name, job.Interval)
+		wg.Add(1)
+		go func(job *Job) {
+			defer wg.Done()
+			s.schedule(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+}
+
+// schedule runs job whenever it is due, until ctx is done or the server it
+// runs in drains.
+func (s *Scheduler) schedule(ctx context.Context, job *Job) {
+	for {
+		timer := time.NewTimer(s.wait(ctx, job))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-server.Draining(ctx):
+			// Runs in progress finish, but no new ones start.
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.runOnce(ctx, job)
+	}
+}
+
+// wait returns how long to wait for the next run of job: until its interval
+// has passed since it last started on any instance, plus a random jitter of up
+// to SCHEDULER_JITTER of its interval.
+func (s *Scheduler) wait(ctx context.Context, job *Job) time.Duration {
+	last, err := s.store.LastJobStart(ctx, job.Name)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Failed to read last start of job %v, waiting a full interval: %v", job.Name, err)
+		last = time.Now()
+	}
+	wait := time.Until(last.Add(job.Interval))
+	if wait < 0 {
+		wait = 0
+	}
+	if max := int64(float64(job.Interval) * s.config.Jitter); max > 0 {
+		wait += time.Duration(rand.Int63n(max))
+	}
+	return wait
+}
+
+// runOnce runs job if it is due and no other instance holds its lock, and
+// returns the result of the run.
+func (s *Scheduler) runOnce(ctx context.Context, job *Job) string {
+	logger := logging.FromContext(ctx)
+
+	result := resultFailure
+	defer func() {
+		metrics.SchedulerRuns.WithLabelValues(job.Name, result).Inc()
+	}()
+
+	lock, err := s.store.AcquireJobLock(ctx, job.Name, s.config.LockTTL)
+	if err != nil {
+		if errors.Is(err, database.ErrAlreadyLocked) {
+			logger.Debugf("Skipping job %v, another instance is running it", job.Name)
+			result = resultSkipped
+			return result
+		}
+		logger.Errorf("Failed to lock job %v: %v", job.Name, err)
+		return result
+	}
+	defer func() {
+		releaseCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		if err := lock.Release(releaseCtx); err != nil {
+			logger.Errorf("Failed to release lock of job %v: %v", job.Name, err)
+		}
+	}()
+
+	// Another instance may have run the job since this one last checked.
+	now := time.Now()
+	last, err := s.store.LastJobStart(ctx, job.Name)
+	if err != nil {
+		logger.Errorf("Failed to read last start of job %v: %v", job.Name, err)
+		return result
+	}
+	if now.Before(last.Add(job.Interval)) {
+		logger.Debugf("Skipping job %v, it last started at %v", job.Name, last)
+		result = resultSkipped
+		return result
+	}
+	if err := s.store.RecordJobStart(ctx, job.Name, now); err != nil {
+		logger.Errorf("Failed to record start of job %v: %v", job.Name, err)
+		return result
+	}
+
+	result = s.run(ctx, job, lock)
+
+	recordCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := s.store.RecordJobFinish(recordCtx, job.Name, result); err != nil {
+		logger.Errorf("Failed to record result of job %v: %v", job.Name, err)
+	}
+	return result
+}
+
+// run runs job, renewing its lock every third of SCHEDULER_LOCK_TTL. If the
+// lock is lost, the job's context is canceled.
+func (s *Scheduler) run(ctx context.Context, job *Job, lock Lock) string {
+	logger := logging.FromContext(ctx)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if job.Timeout > 0 {
+		var timeoutCancel context.CancelFunc
+		runCtx, timeoutCancel = context.WithTimeout(runCtx, job.Timeout)
+		defer timeoutCancel()
+	}
+
+	lost := make(chan error, 1)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(s.config.LockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := lock.Renew(ctx, s.config.LockTTL); err != nil {
+					if ctx.Err() == nil {
+						lost <- err
+						cancel()
+					}
+					return
+				}
+			}
+		}
+	}()
+
+	start := time.Now()
+	err := job.Run(runCtx)
+	close(done)
+	wg.Wait()
+	elapsed := time.Since(start)
+	metrics.SchedulerRunDuration.WithLabelValues(job.Name).Observe(elapsed.Seconds())
+
+	select {
+	case lerr := <-lost:
+		logger.Errorf("Job %v lost its lock and was stopped: %v", job.Name, lerr)
+		return resultLockLost
+	default:
+	}
+	if err != nil {
+		logger.Errorf("Job %v failed after %v: %v", job.Name, elapsed, err)
+		return resultFailure
+	}
+	logger.Infof("Job %v succeeded in %v", job.Name, elapsed)
+	metrics.SchedulerLastSuccess.WithLabelValues(job.Name).SetToCurrentTime()
+	return resultSuccess
+}
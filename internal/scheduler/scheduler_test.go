@@ -0,0 +1,219 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeStore holds locks and runs in memory. Renewals fail once renewErr is
+// set.
+type fakeStore struct {
+	mu       sync.Mutex
+	locked   map[string]bool
+	started  map[string]time.Time
+	results  []string
+	renewErr error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{locked: make(map[string]bool), started: make(map[string]time.Time)}
+}
+
+type fakeLock struct {
+	store *fakeStore
+	name  string
+}
+
+func (s *fakeStore) AcquireJobLock(ctx context.Context, name string, ttl time.Duration) (Lock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked[name] {
+		return nil, database.ErrAlreadyLocked
+	}
+	s.locked[name] = true
+	return &fakeLock{store: s, name: name}, nil
+}
+
+func (s *fakeStore) LastJobStart(ctx context.Context, name string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started[name], nil
+}
+
+func (s *fakeStore) RecordJobStart(ctx context.Context, name string, started time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started[name] = started
+	return nil
+}
+
+func (s *fakeStore) RecordJobFinish(ctx context.Context, name string, result string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, result)
+	return nil
+}
+
+func (l *fakeLock) Renew(ctx context.Context, ttl time.Duration) error {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	return l.store.renewErr
+}
+
+func (l *fakeLock) Release(ctx context.Context) error {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	delete(l.store.locked, l.name)
+	return nil
+}
+
+func TestNew(t *testing.T) {
+	job := &Job{Name: "batcher", Interval: time.Minute}
+	cases := []struct {
+		name    string
+		config  *Config
+		jobs    []*Job
+		wantErr bool
+	}{
+		{name: "valid", config: &Config{LockTTL: time.Minute, Jitter: 0.1}, jobs: []*Job{job}},
+		{name: "short lock ttl", config: &Config{LockTTL: time.Second}, jobs: []*Job{job}, wantErr: true},
+		{name: "jitter", config: &Config{LockTTL: time.Minute, Jitter: 2}, jobs: []*Job{job}, wantErr: true},
+		{name: "no interval", config: &Config{LockTTL: time.Minute}, jobs: []*Job{{Name: "cleanup"}}, wantErr: true},
+		{name: "duplicate", config: &Config{LockTTL: time.Minute}, jobs: []*Job{job, job}, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := New(c.config, newFakeStore(), c.jobs...)
+			if (err != nil) != c.wantErr {
+				t.Errorf("got error %v, want error: %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestWait(t *testing.T) {
+	store := newFakeStore()
+	s := &Scheduler{config: &Config{LockTTL: time.Minute}, store: store}
+	job := &Job{Name: "batcher", Interval: time.Hour}
+	ctx := context.Background()
+
+	// A job that never ran is due at once.
+	if got := s.wait(ctx, job); got != 0 {
+		t.Errorf("never run: got wait %v, want 0", got)
+	}
+
+	store.started[job.Name] = time.Now().Add(-15 * time.Minute)
+	if got := s.wait(ctx, job); got < 44*time.Minute || got > 45*time.Minute {
+		t.Errorf("got wait %v, want 45m", got)
+	}
+
+	s.config.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		if got := s.wait(ctx, job); got < 44*time.Minute || got > 75*time.Minute {
+			t.Errorf("got wait %v, want between 45m and 75m", got)
+		}
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	ctx := context.Background()
+	errJob := errors.New("job failed")
+
+	cases := []struct {
+		name     string
+		locked   bool
+		started  time.Duration
+		renewErr error
+		run      func(ctx context.Context) error
+		want     string
+		wantRun  bool
+	}{
+		{
+			name:    "success",
+			run:     func(ctx context.Context) error { return nil },
+			want:    resultSuccess,
+			wantRun: true,
+		},
+		{
+			name:    "failure",
+			run:     func(ctx context.Context) error { return errJob },
+			want:    resultFailure,
+			wantRun: true,
+		},
+		{
+			name:   "locked",
+			locked: true,
+			want:   resultSkipped,
+		},
+		{
+			name:    "not due",
+			started: time.Minute,
+			want:    resultSkipped,
+		},
+		{
+			name:     "lock lost",
+			renewErr: database.ErrLockLost,
+			run: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			want:    resultLockLost,
+			wantRun: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.locked["batcher"] = c.locked
+			store.renewErr = c.renewErr
+			if c.started > 0 {
+				store.started["batcher"] = time.Now().Add(-c.started)
+			}
+			s := &Scheduler{config: &Config{LockTTL: 30 * time.Millisecond}, store: store}
+
+			ran := false
+			job := &Job{
+				Name:     "batcher",
+				Interval: time.Hour,
+				Run: func(ctx context.Context) error {
+					ran = true
+					return c.run(ctx)
+				},
+			}
+			if got := s.runOnce(ctx, job); got != c.want {
+				t.Errorf("got result %q, want %q", got, c.want)
+			}
+			if ran != c.wantRun {
+				t.Errorf("job ran: %v, want %v", ran, c.wantRun)
+			}
+			if c.wantRun {
+				if diff := cmp.Diff([]string{c.want}, store.results); diff != "" {
+					t.Errorf("recorded results mismatch (-want, +got):\n%s", diff)
+				}
+			}
+			if store.locked["batcher"] != c.locked {
+				t.Errorf("job locked: %v, want %v", store.locked["batcher"], c.locked)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+// Compile-time check to verify implements interface.
+var _ SecretManager = (*AWSSecretsManager)(nil)
+
+// AWSSecretsManager implements SecretManager.
+type AWSSecretsManager struct {
+	svc *secretsmanager.SecretsManager
+}
+
+// NewAWSSecretsManager creates a new secret manager for AWS. The region and
+// credentials are read from the environment, such as AWS_REGION and
+// AWS_ACCESS_KEY_ID, or the shared config.
+func NewAWSSecretsManager(ctx context.Context) (SecretManager, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("secrets.NewAWSSecretsManager: session: %w", err)
+	}
+
+	sm := &AWSSecretsManager{
+		svc: secretsmanager.New(sess),
+	}
+
+	return sm, nil
+}
+
+// GetSecretValue implements the SecretManager interface. Secrets are specified
+// by their name or ARN, optionally followed by a version stage:
+//
+//     my-secret
+//     arn:aws:secretsmanager:us-east-1:123456789012:secret:my-secret-AbCdEf#AWSPREVIOUS
+//
+// If the version stage is omitted, AWSCURRENT is used. Binary secrets are
+// returned as they are.
+func (sm *AWSSecretsManager) GetSecretValue(ctx context.Context, name string) (string, error) {
+	input := &secretsmanager.GetSecretValueInput{}
+	if i := strings.LastIndex(name, "#"); i >= 0 {
+		input.SecretId = aws.String(name[:i])
+		input.VersionStage = aws.String(name[i+1:])
+	} else {
+		input.SecretId = aws.String(name)
+	}
+
+	result, err := sm.svc.GetSecretValueWithContext(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to access secret %v: %w", name, err)
+	}
+	if result.SecretString != nil {
+		return *result.SecretString, nil
+	}
+	if result.SecretBinary != nil {
+		return string(result.SecretBinary), nil
+	}
+	return "", fmt.Errorf("found secret %v, but value was nil", name)
+}
@@ -34,6 +34,9 @@ type Cacher struct {
 
 	cache      map[string]*cachedItem
 	cacheMutex sync.Mutex
+
+	onChange   map[string][]func(context.Context, string)
+	watchMutex sync.Mutex
 }
 
 type cachedItem struct {
@@ -42,7 +45,7 @@ type cachedItem struct {
 }
 
 // NewCacher creates a new secret manager that caches results for the given ttl.
-func NewCacher(ctx context.Context, f SecretManagerFunc, ttl time.Duration) (SecretManager, error) {
+func NewCacher(ctx context.Context, f SecretManagerFunc, ttl time.Duration) (*Cacher, error) {
 	sm, err := f(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("cacher: %w", err)
@@ -52,16 +55,19 @@ func NewCacher(ctx context.Context, f SecretManagerFunc, ttl time.Duration) (Sec
 }
 
 // WrapCacher wraps an existing SecretManager with caching.
-func WrapCacher(ctx context.Context, sm SecretManager, ttl time.Duration) (SecretManager, error) {
+func WrapCacher(ctx context.Context, sm SecretManager, ttl time.Duration) (*Cacher, error) {
 	return &Cacher{
-		sm:    sm,
-		ttl:   ttl,
-		cache: make(map[string]*cachedItem),
+		sm:       sm,
+		ttl:      ttl,
+		cache:    make(map[string]*cachedItem),
+		onChange: make(map[string][]func(context.Context, string)),
 	}, nil
 }
 
 // GetSecretValue implements the SecretManager interface, but caches values and
-// retrieves them from the cache.
+// retrieves them from the cache. If an expired value can't be refreshed, the
+// expired value is returned instead, so that an outage of the secret manager
+// doesn't take down servers that already resolved their secrets.
 func (sm *Cacher) GetSecretValue(ctx context.Context, name string) (string, error) {
 	logger := logging.FromContext(ctx)
 
@@ -70,7 +76,8 @@ func (sm *Cacher) GetSecretValue(ctx context.Context, name string) (string, erro
 	defer sm.cacheMutex.Unlock()
 
 	// Lookup in cache
-	if i, ok := sm.cache[name]; ok && time.Since(i.cachedAt) < sm.ttl {
+	i, ok := sm.cache[name]
+	if ok && time.Since(i.cachedAt) < sm.ttl {
 		logger.Debugf("loaded secret %v from cache", name)
 		return i.value, nil
 	}
@@ -78,6 +85,10 @@ func (sm *Cacher) GetSecretValue(ctx context.Context, name string) (string, erro
 	// Delegate lookup to parent sm.
 	plaintext, err := sm.sm.GetSecretValue(ctx, name)
 	if err != nil {
+		if ok {
+			logger.Warnf("failed to refresh secret %v, using cached value: %v", name, err)
+			return i.value, nil
+		}
 		return "", err
 	}
 
@@ -89,3 +100,77 @@ func (sm *Cacher) GetSecretValue(ctx context.Context, name string) (string, erro
 
 	return plaintext, nil
 }
+
+// OnChange registers fn to be called with the new value when a refresh finds
+// that the secret name has changed, for example because it was rotated. fn is
+// not called for the initial value.
+func (sm *Cacher) OnChange(name string, fn func(ctx context.Context, value string)) {
+	sm.watchMutex.Lock()
+	defer sm.watchMutex.Unlock()
+	sm.onChange[name] = append(sm.onChange[name], fn)
+}
+
+// Refresh reads every cached secret from the wrapped secret manager and
+// notifies the OnChange functions of those that changed. Secrets that can't be
+// read keep their cached value, and the first error is returned.
+func (sm *Cacher) Refresh(ctx context.Context) error {
+	sm.cacheMutex.Lock()
+	names := make([]string, 0, len(sm.cache))
+	for name := range sm.cache {
+		names = append(names, name)
+	}
+	sm.cacheMutex.Unlock()
+
+	var firstErr error
+	for _, name := range names {
+		// Read outside the lock, so that a slow secret manager doesn't block
+		// cached reads.
+		plaintext, err := sm.sm.GetSecretValue(ctx, name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to refresh secret %v: %w", name, err)
+			}
+			continue
+		}
+
+		sm.cacheMutex.Lock()
+		changed := false
+		if i, ok := sm.cache[name]; ok && i.value != plaintext {
+			changed = true
+		}
+		sm.cache[name] = &cachedItem{
+			value:    plaintext,
+			cachedAt: time.Now(),
+		}
+		sm.cacheMutex.Unlock()
+
+		if changed {
+			sm.watchMutex.Lock()
+			fns := sm.onChange[name]
+			sm.watchMutex.Unlock()
+			for _, fn := range fns {
+				fn(ctx, plaintext)
+			}
+		}
+	}
+	return firstErr
+}
+
+// Run refreshes the cached secrets every interval until ctx is done. To never
+// serve expired values, interval should be less than the cache ttl.
+func (sm *Cacher) Run(ctx context.Context, interval time.Duration) {
+	logger := logging.FromContext(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := sm.Refresh(ctx); err != nil {
+				logger.Errorf("refreshing secrets: %v", err)
+			}
+		}
+	}
+}
@@ -16,17 +16,22 @@ package secrets
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 )
 
 type testSecretManager struct {
 	value string
+	err   error
 	hits  int
 }
 
 func (sm *testSecretManager) GetSecretValue(ctx context.Context, name string) (string, error) {
 	sm.hits++
+	if sm.err != nil {
+		return "", sm.err
+	}
 	return sm.value, nil
 }
 
@@ -80,3 +85,88 @@ func TestCacher_GetSecretValue(t *testing.T) {
 		t.Errorf("expected another hit: %d", sm.hits)
 	}
 }
+
+func TestCacher_StaleOnError(t *testing.T) {
+	ctx := context.Background()
+
+	sm := &testSecretManager{value: "first"}
+	cached, err := WrapCacher(ctx, sm, time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cached.GetSecretValue(ctx, "missing"); err != nil {
+		t.Fatal(err)
+	}
+	sm.err = errors.New("unavailable")
+
+	// The expired value is served while the secret manager fails.
+	got, err := cached.GetSecretValue(ctx, "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "first"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	// Secrets that were never read still fail.
+	if _, err := cached.GetSecretValue(ctx, "other"); err == nil {
+		t.Errorf("expected error for uncached secret")
+	}
+}
+
+func TestCacher_Refresh(t *testing.T) {
+	ctx := context.Background()
+
+	sm := &testSecretManager{value: "first"}
+	cached, err := WrapCacher(ctx, sm, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []string
+	cached.OnChange("secret", func(ctx context.Context, value string) {
+		changes = append(changes, value)
+	})
+
+	if _, err := cached.GetSecretValue(ctx, "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Refreshing an unchanged secret doesn't notify.
+	if err := cached.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("unexpected changes: %v", changes)
+	}
+
+	// A rotated secret is cached and notified without waiting for the ttl.
+	sm.value = "second"
+	if err := cached.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got, err := cached.GetSecretValue(ctx, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "second"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+	if len(changes) != 1 || changes[0] != "second" {
+		t.Errorf("expected one change to %q, got %v", "second", changes)
+	}
+
+	// A failed refresh keeps the cached value.
+	sm.err = errors.New("unavailable")
+	if err := cached.Refresh(ctx); err == nil {
+		t.Errorf("expected refresh error")
+	}
+	got, err = cached.GetSecretValue(ctx, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "second"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kenvconfig "github.com/kelseyhightower/envconfig"
+)
+
+// SecretManagerType defines a specific secret manager.
+type SecretManagerType string
+
+const (
+	SecretManagerTypeAWSSecretsManager   SecretManagerType = "AWS_SECRETS_MANAGER"
+	SecretManagerTypeAzureKeyVault       SecretManagerType = "AZURE_KEY_VAULT"
+	SecretManagerTypeFilesystem          SecretManagerType = "FILESYSTEM"
+	SecretManagerTypeGoogleSecretManager SecretManagerType = "GOOGLE_SECRET_MANAGER"
+	SecretManagerTypeHashiCorpVault      SecretManagerType = "HASHICORP_VAULT"
+)
+
+// Config defines the configuration for the secret manager that resolves
+// secret:// values in the environment.
+type Config struct {
+	SecretManagerType SecretManagerType `envconfig:"SECRET_MANAGER" default:"GOOGLE_SECRET_MANAGER"`
+
+	// SecretCacheTTL is how long resolved secret values are cached.
+	SecretCacheTTL time.Duration `envconfig:"SECRET_CACHE_TTL" default:"5m"`
+
+	// SecretRefreshInterval is how often resolved secrets are read again in
+	// the background, so that rotated secrets are picked up without a
+	// restart. Zero disables background refreshes.
+	SecretRefreshInterval time.Duration `envconfig:"SECRET_REFRESH_INTERVAL" default:"4m"`
+
+	// FilesystemRoot is the directory that the FILESYSTEM secret manager
+	// reads secrets from.
+	FilesystemRoot string `envconfig:"SECRET_FILESYSTEM_ROOT" default:"/var/run/secrets/app"`
+}
+
+// ConfigFromEnv reads the secret manager configuration from the environment.
+// It is read on its own, because the secret manager must exist before the
+// rest of the environment can be resolved.
+func ConfigFromEnv() (*Config, error) {
+	var config Config
+	if err := kenvconfig.Process("", &config); err != nil {
+		return nil, fmt.Errorf("failed to process secret manager config: %w", err)
+	}
+	return &config, nil
+}
+
+// SecretManagerFor returns the secret manager for the config. Its values are
+// not cached, see WrapCacher.
+func SecretManagerFor(ctx context.Context, config *Config) (SecretManager, error) {
+	switch config.SecretManagerType {
+	case SecretManagerTypeAWSSecretsManager:
+		return NewAWSSecretsManager(ctx)
+	case SecretManagerTypeAzureKeyVault:
+		return NewAzureKeyVault(ctx)
+	case SecretManagerTypeFilesystem:
+		return NewFilesystem(ctx, config.FilesystemRoot)
+	case SecretManagerTypeGoogleSecretManager:
+		return NewGCPSecretManager(ctx)
+	case SecretManagerTypeHashiCorpVault:
+		return NewHashiCorpVault(ctx)
+	}
+	return nil, fmt.Errorf("unknown secret manager type: %v", config.SecretManagerType)
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Compile-time check to verify implements interface.
+var _ SecretManager = (*Filesystem)(nil)
+
+// Filesystem implements SecretManager with files in a directory, such as
+// secrets mounted into a container. It is meant for local development and
+// platforms that deliver secrets as files.
+type Filesystem struct {
+	root string
+}
+
+// NewFilesystem creates a secret manager that reads secrets from the files in
+// root.
+func NewFilesystem(ctx context.Context, root string) (SecretManager, error) {
+	if root == "" {
+		return nil, fmt.Errorf("secrets.NewFilesystem: root directory is required")
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("secrets.NewFilesystem: %w", err)
+	}
+
+	sm := &Filesystem{
+		root: abs,
+	}
+
+	return sm, nil
+}
+
+// GetSecretValue implements the SecretManager interface. Secrets are specified
+// as the path of their file relative to the root directory, for example:
+//
+//     database/password
+//
+// A single trailing newline is removed from the value, since editors and
+// shells usually add one.
+func (sm *Filesystem) GetSecretValue(ctx context.Context, name string) (string, error) {
+	path := filepath.Join(sm.root, filepath.FromSlash(name))
+	if !strings.HasPrefix(path, sm.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("%v is not a valid secret ref", name)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to access secret %v: %w", name, err)
+	}
+	value := strings.TrimSuffix(string(b), "\n")
+	return strings.TrimSuffix(value, "\r"), nil
+}
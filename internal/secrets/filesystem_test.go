@@ -0,0 +1,100 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFilesystem(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "root")
+	if err := os.MkdirAll(filepath.Join(root, "database"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(root, "database", "password"): "hunter2\n",
+		filepath.Join(root, "api-key"):              "multi\nline",
+		filepath.Join(dir, "outside"):               "leaked",
+	}
+	for path, value := range files {
+		if err := ioutil.WriteFile(path, []byte(value), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sm, err := NewFilesystem(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		want string
+		err  string
+	}{
+		{name: "database/password", want: "hunter2"},
+		{name: "api-key", want: "multi\nline"},
+		{name: "missing", err: "failed to access secret"},
+		{name: "../outside", err: "not a valid secret ref"},
+		{name: "database/../../outside", err: "not a valid secret ref"},
+		{name: "", err: "not a valid secret ref"},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := sm.GetSecretValue(ctx, tc.name)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSecretManagerFor(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := SecretManagerFor(ctx, &Config{SecretManagerType: "UNKNOWN"}); err == nil {
+		t.Errorf("SecretManagerFor unknown type: got nil error")
+	}
+	sm, err := SecretManagerFor(ctx, &Config{SecretManagerType: SecretManagerTypeFilesystem, FilesystemRoot: "."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sm.(*Filesystem); !ok {
+		t.Errorf("got %T, want *Filesystem", sm)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package seed
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+)
+
+// ExportOptions selects the export configs and batches to create.
+type ExportOptions struct {
+	// BucketName is the bucket of the export files, which are named
+	// FilenamePrefix/REGION/... .
+	BucketName     string
+	FilenamePrefix string
+
+	// Regions are the regions to export, one config each.
+	Regions []string
+
+	// Period is the period of the export configs.
+	Period time.Duration
+
+	// Days is the number of whole UTC days before Now covered by batches.
+	Days int
+	Now  time.Time
+
+	// The signing key of the export files, and the identifiers of it and of
+	// the app that clients check.
+	SigningKey        string
+	SigningKeyID      string
+	SigningKeyVersion string
+	AppPackageName    string
+	BundleID          string
+}
+
+// CreateExports creates an export config for each region, signed with the
+// signing key, and the open batches of the config covering the Days UTC days
+// before Now. The export worker then writes their files. Batches created by
+// the export server afterwards follow on from them.
+func CreateExports(ctx context.Context, db *database.DB, opts *ExportOptions) ([]*database.ExportConfig, error) {
+	logger := logging.FromContext(ctx)
+
+	if opts.BucketName == "" || opts.SigningKey == "" {
+		return nil, fmt.Errorf("bucket name and signing key are required")
+	}
+	if opts.Days <= 0 {
+		return nil, fmt.Errorf("days must be positive")
+	}
+
+	si := &database.SignatureInfo{
+		SigningKey:        opts.SigningKey,
+		SigningKeyID:      opts.SigningKeyID,
+		SigningKeyVersion: opts.SigningKeyVersion,
+		AppPackageName:    opts.AppPackageName,
+		BundleID:          opts.BundleID,
+	}
+	if err := db.AddSignatureInfo(ctx, si); err != nil {
+		return nil, fmt.Errorf("adding signature info: %w", err)
+	}
+
+	thru := opts.Now.UTC().Truncate(24 * time.Hour)
+	from := thru.AddDate(0, 0, -opts.Days)
+
+	var configs []*database.ExportConfig
+	for _, region := range opts.Regions {
+		ec := &database.ExportConfig{
+			BucketName:       opts.BucketName,
+			FilenameRoot:     path.Join(opts.FilenamePrefix, region),
+			Period:           opts.Period,
+			Region:           region,
+			From:             from,
+			SignatureInfoIDs: []int64{si.ID},
+		}
+		if err := db.AddExportConfig(ctx, ec); err != nil {
+			return nil, fmt.Errorf("adding export config of %v: %w", region, err)
+		}
+		batches := Batches(ec, from, thru)
+		if err := db.AddExportBatches(ctx, batches); err != nil {
+			return nil, fmt.Errorf("adding export batches of %v: %w", region, err)
+		}
+		logger.Infof("Created export config %d of %v with %d batches", ec.ConfigID, region, len(batches))
+		configs = append(configs, ec)
+	}
+	return configs, nil
+}
+
+// Batches returns the open batches of ec, one for each of its periods in
+// [from, thru).
+func Batches(ec *database.ExportConfig, from, thru time.Time) []*database.ExportBatch {
+	var batches []*database.ExportBatch
+	for start := from; start.Before(thru); start = start.Add(ec.Period) {
+		batches = append(batches, &database.ExportBatch{
+			ConfigID:            ec.ConfigID,
+			BucketName:          ec.BucketName,
+			FilenameRoot:        ec.FilenameRoot,
+			StartTimestamp:      start,
+			EndTimestamp:        start.Add(ec.Period),
+			Region:              ec.Region,
+			Status:              database.ExportBatchOpen,
+			SignatureInfoIDs:    ec.SignatureInfoIDs,
+			HealthAuthorityID:   ec.HealthAuthorityID,
+			IncludeTravelers:    ec.IncludeTravelers,
+			IncludeReportTypes:  ec.IncludeReportTypes,
+			MinTransmissionRisk: ec.MinTransmissionRisk,
+		})
+	}
+	return batches
+}
@@ -0,0 +1,283 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package seed populates a database with realistic exposures and export
+// batches, for demos, staging environments and reproducing performance
+// issues.
+package seed
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/logging"
+)
+
+// maxKeysPerUpload is the most keys of an upload: keys are uploaded for the
+// days they may still have been infectious.
+const maxKeysPerUpload = 14
+
+// Options selects the exposures to generate.
+type Options struct {
+	// Exposures is the number of exposures to generate.
+	Exposures int
+
+	// Regions are the regions of the uploads, each upload having one of them.
+	Regions []string
+
+	// Days is the number of whole UTC days before Now over which the uploads
+	// are spread.
+	Days int
+
+	// KeysPerUpload is the number of keys of each upload, one for each of the
+	// days before the upload's day.
+	KeysPerUpload int
+
+	// ReportTypes weighs the report types of the uploads, such as
+	// confirmed:8, likely:2.
+	ReportTypes map[string]int
+
+	// AppPackageName and HealthAuthorityID are stamped on every exposure.
+	AppPackageName    string
+	HealthAuthorityID string
+
+	// TravelerRate is the share of uploads, between 0 and 1, marked as
+	// travelers.
+	TravelerRate float64
+
+	// TruncateWindow is the creation window of the server, to which the
+	// creation times of the exposures are truncated.
+	TruncateWindow time.Duration
+
+	// Now is the end of the generated days, truncated to a UTC day.
+	Now time.Time
+
+	// Seed seeds the random generator, so that runs with the same options
+	// generate the same exposures.
+	Seed int64
+}
+
+// DefaultReportTypes are the report types of uploads when Options doesn't set
+// them: mostly confirmed, with some likely diagnoses.
+var DefaultReportTypes = map[string]int{
+	database.ReportTypeConfirmed: 8,
+	database.ReportTypeLikely:    2,
+}
+
+// Validate checks that the options can generate exposures.
+func (o *Options) Validate() error {
+	switch {
+	case o.Exposures <= 0:
+		return fmt.Errorf("exposures must be positive")
+	case len(o.Regions) == 0:
+		return fmt.Errorf("at least one region is required")
+	case o.Days <= 0:
+		return fmt.Errorf("days must be positive")
+	case o.KeysPerUpload <= 0 || o.KeysPerUpload > maxKeysPerUpload:
+		return fmt.Errorf("keys per upload must be between 1 and %d", maxKeysPerUpload)
+	case o.TravelerRate < 0 || o.TravelerRate > 1:
+		return fmt.Errorf("traveler rate must be between 0 and 1")
+	case o.TruncateWindow <= 0:
+		return fmt.Errorf("truncate window must be positive")
+	}
+	for typ, weight := range o.ReportTypes {
+		if weight < 0 {
+			return fmt.Errorf("report type %q has a negative weight", typ)
+		}
+	}
+	return nil
+}
+
+// Generator generates the exposures of random uploads.
+type Generator struct {
+	opts  *Options
+	rng   *rand.Rand
+	today time.Time
+
+	// reportTypes and weights list the report types in a fixed order, so
+	// that a seed always picks the same ones.
+	reportTypes []string
+	weights     []int
+	totalWeight int
+}
+
+// NewGenerator returns a generator of the exposures selected by opts.
+func NewGenerator(opts *Options) (*Generator, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	g := &Generator{
+		opts:  opts,
+		rng:   rand.New(rand.NewSource(opts.Seed)),
+		today: opts.Now.UTC().Truncate(24 * time.Hour),
+	}
+
+	reportTypes := opts.ReportTypes
+	if len(reportTypes) == 0 {
+		reportTypes = DefaultReportTypes
+	}
+	for typ := range reportTypes {
+		g.reportTypes = append(g.reportTypes, typ)
+	}
+	sort.Strings(g.reportTypes)
+	for _, typ := range g.reportTypes {
+		g.weights = append(g.weights, reportTypes[typ])
+		g.totalWeight += reportTypes[typ]
+	}
+	if g.totalWeight == 0 {
+		return nil, fmt.Errorf("report types have no weight")
+	}
+	return g, nil
+}
+
+// Upload returns the exposures of one random upload: a key for each of the
+// KeysPerUpload days before the upload's day, in one of the regions, created
+// at a random time of one of the generated days.
+func (g *Generator) Upload() []*database.Exposure {
+	day := g.today.AddDate(0, 0, -1-g.rng.Intn(g.opts.Days))
+	createdAt := day.Add(time.Duration(g.rng.Int63n(int64(24 * time.Hour))))
+	createdAt = database.TruncateWindow(createdAt, g.opts.TruncateWindow)
+
+	region := g.opts.Regions[g.rng.Intn(len(g.opts.Regions))]
+	reportType := g.reportType()
+	traveler := g.rng.Float64() < g.opts.TravelerRate
+
+	// Symptoms started a few days before the upload, so the keys of the days
+	// around onset are the most infectious.
+	onset := -2 - g.rng.Intn(5)
+
+	exposures := make([]*database.Exposure, 0, g.opts.KeysPerUpload)
+	for i := 1; i <= g.opts.KeysPerUpload; i++ {
+		key := make([]byte, database.KeyLength)
+		g.rng.Read(key)
+
+		daysSinceOnset := int32(onset + i)
+		exposures = append(exposures, &database.Exposure{
+			ExposureKey:           key,
+			TransmissionRisk:      transmissionRisk(reportType, daysSinceOnset),
+			AppPackageName:        g.opts.AppPackageName,
+			Regions:               []string{region},
+			IntervalNumber:        database.IntervalNumber(day.AddDate(0, 0, -i)),
+			IntervalCount:         database.MaxIntervalCount,
+			CreatedAt:             createdAt,
+			LocalProvenance:       true,
+			ReportType:            reportType,
+			DaysSinceSymptomOnset: &daysSinceOnset,
+			HealthAuthorityID:     g.opts.HealthAuthorityID,
+			Traveler:              traveler,
+		})
+	}
+	return exposures
+}
+
+// reportType returns a report type picked by its weight.
+func (g *Generator) reportType() string {
+	n := g.rng.Intn(g.totalWeight)
+	for i, w := range g.weights {
+		if n < w {
+			return g.reportTypes[i]
+		}
+		n -= w
+	}
+	return g.reportTypes[len(g.reportTypes)-1]
+}
+
+// transmissionRisk returns the transmission risk of a key, highest around
+// symptom onset. Keys of negative reports carry no risk.
+func transmissionRisk(reportType string, daysSinceOnset int32) int {
+	if reportType == database.ReportTypeNegative {
+		return 0
+	}
+	risk := database.MaxTransmissionRisk
+	if daysSinceOnset < 0 {
+		daysSinceOnset = -daysSinceOnset
+	}
+	risk -= int(daysSinceOnset) / 2
+	if reportType == database.ReportTypeLikely {
+		risk--
+	}
+	if risk < 1 {
+		risk = 1
+	}
+	return risk
+}
+
+// Generate returns opts.Exposures exposures, in uploads of
+// opts.KeysPerUpload keys, the last of which may be cut short.
+func Generate(opts *Options) ([]*database.Exposure, error) {
+	g, err := NewGenerator(opts)
+	if err != nil {
+		return nil, err
+	}
+	exposures := make([]*database.Exposure, 0, opts.Exposures)
+	for len(exposures) < opts.Exposures {
+		exposures = append(exposures, g.Upload()...)
+	}
+	return exposures[:opts.Exposures], nil
+}
+
+// Insert generates opts.Exposures exposures and inserts them into db, in
+// transactions of up to batchSize exposures. Each exposure is stored in the
+// database of its region. Exposures whose key already exists are skipped.
+func Insert(ctx context.Context, db *database.DB, opts *Options, batchSize int) error {
+	logger := logging.FromContext(ctx)
+
+	if batchSize <= 0 {
+		return fmt.Errorf("batch size must be positive")
+	}
+	g, err := NewGenerator(opts)
+	if err != nil {
+		return err
+	}
+
+	pending := make(map[*database.DB][]*database.Exposure)
+	flush := func(rdb *database.DB) error {
+		if err := rdb.InsertExposures(ctx, pending[rdb]); err != nil {
+			return fmt.Errorf("inserting exposures into the %v database: %w", rdb.ResidencyName(), err)
+		}
+		pending[rdb] = pending[rdb][:0]
+		return nil
+	}
+
+	inserted := 0
+	for inserted < opts.Exposures {
+		upload := g.Upload()
+		if left := opts.Exposures - inserted; len(upload) > left {
+			upload = upload[:left]
+		}
+		rdb := db.ForRegion(upload[0].Regions[0])
+		pending[rdb] = append(pending[rdb], upload...)
+		inserted += len(upload)
+		if len(pending[rdb]) >= batchSize {
+			if err := flush(rdb); err != nil {
+				return err
+			}
+			logger.Infof("Inserted %d of %d exposures", inserted, opts.Exposures)
+		}
+	}
+	for rdb, exposures := range pending {
+		if len(exposures) == 0 {
+			continue
+		}
+		if err := flush(rdb); err != nil {
+			return err
+		}
+	}
+	logger.Infof("Inserted %d exposures", inserted)
+	return nil
+}
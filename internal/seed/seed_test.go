@@ -0,0 +1,156 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package seed
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/go-cmp/cmp"
+)
+
+func testOptions() *Options {
+	return &Options{
+		Exposures:      100,
+		Regions:        []string{"US", "CA"},
+		Days:           3,
+		KeysPerUpload:  14,
+		ReportTypes:    map[string]int{database.ReportTypeConfirmed: 1, database.ReportTypeNegative: 1},
+		AppPackageName: "com.example.app",
+		TruncateWindow: time.Hour,
+		Now:            time.Date(2020, 9, 8, 13, 0, 0, 0, time.UTC),
+		Seed:           1,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(*Options)
+		valid  bool
+	}{
+		{name: "valid", modify: func(*Options) {}, valid: true},
+		{name: "no exposures", modify: func(o *Options) { o.Exposures = 0 }},
+		{name: "no regions", modify: func(o *Options) { o.Regions = nil }},
+		{name: "no days", modify: func(o *Options) { o.Days = 0 }},
+		{name: "too many keys", modify: func(o *Options) { o.KeysPerUpload = 15 }},
+		{name: "traveler rate", modify: func(o *Options) { o.TravelerRate = 1.5 }},
+		{name: "no truncate window", modify: func(o *Options) { o.TruncateWindow = 0 }},
+		{name: "negative weight", modify: func(o *Options) { o.ReportTypes = map[string]int{database.ReportTypeLikely: -1} }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opts := testOptions()
+			c.modify(opts)
+			if err := opts.Validate(); (err == nil) != c.valid {
+				t.Errorf("got error %v, want valid: %v", err, c.valid)
+			}
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	opts := testOptions()
+	exposures, err := Generate(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exposures) != opts.Exposures {
+		t.Fatalf("got %d exposures, want %d", len(exposures), opts.Exposures)
+	}
+
+	from := time.Date(2020, 9, 5, 0, 0, 0, 0, time.UTC)
+	thru := time.Date(2020, 9, 8, 0, 0, 0, 0, time.UTC)
+	keys := make(map[string]bool)
+	for i, exp := range exposures {
+		if len(exp.ExposureKey) != database.KeyLength {
+			t.Fatalf("exposure %d: key length %d", i, len(exp.ExposureKey))
+		}
+		keys[string(exp.ExposureKey)] = true
+
+		if exp.CreatedAt.Before(from) || !exp.CreatedAt.Before(thru) || !exp.CreatedAt.Equal(exp.CreatedAt.Truncate(time.Hour)) {
+			t.Errorf("exposure %d: created at %v, want a whole hour in [%v, %v)", i, exp.CreatedAt, from, thru)
+		}
+		if exp.IntervalNumber%database.MaxIntervalCount != 0 || exp.IntervalCount != database.MaxIntervalCount {
+			t.Errorf("exposure %d: interval %d+%d, want a whole day", i, exp.IntervalNumber, exp.IntervalCount)
+		}
+		if end := database.IntervalTime(exp.IntervalNumber + exp.IntervalCount); end.After(exp.CreatedAt) {
+			t.Errorf("exposure %d: key valid until %v, after its creation at %v", i, end, exp.CreatedAt)
+		}
+		if exp.Regions[0] != "US" && exp.Regions[0] != "CA" {
+			t.Errorf("exposure %d: region %v", i, exp.Regions)
+		}
+		switch exp.ReportType {
+		case database.ReportTypeConfirmed:
+			if exp.TransmissionRisk < 1 || exp.TransmissionRisk > database.MaxTransmissionRisk {
+				t.Errorf("exposure %d: transmission risk %d", i, exp.TransmissionRisk)
+			}
+		case database.ReportTypeNegative:
+			if exp.TransmissionRisk != 0 {
+				t.Errorf("exposure %d: negative report with transmission risk %d", i, exp.TransmissionRisk)
+			}
+		default:
+			t.Errorf("exposure %d: report type %q", i, exp.ReportType)
+		}
+		if d := *exp.DaysSinceSymptomOnset; d < database.MinDaysSinceSymptomOnset || d > database.MaxDaysSinceSymptomOnset {
+			t.Errorf("exposure %d: days since onset %d", i, d)
+		}
+	}
+	if len(keys) != len(exposures) {
+		t.Errorf("got %d distinct keys, want %d", len(keys), len(exposures))
+	}
+
+	again, err := Generate(testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(exposures, again); diff != "" {
+		t.Errorf("same seed mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	ec := &database.ExportConfig{
+		ConfigID:         7,
+		BucketName:       "exports",
+		FilenameRoot:     "seed/US",
+		Period:           12 * time.Hour,
+		Region:           "US",
+		SignatureInfoIDs: []int64{3},
+	}
+	day := func(d, h int) time.Time { return time.Date(2020, 9, d, h, 0, 0, 0, time.UTC) }
+	batch := func(start, end time.Time) *database.ExportBatch {
+		return &database.ExportBatch{
+			ConfigID:         7,
+			BucketName:       "exports",
+			FilenameRoot:     "seed/US",
+			StartTimestamp:   start,
+			EndTimestamp:     end,
+			Region:           "US",
+			Status:           database.ExportBatchOpen,
+			SignatureInfoIDs: []int64{3},
+		}
+	}
+
+	got := Batches(ec, day(1, 0), day(2, 0))
+	want := []*database.ExportBatch{
+		batch(day(1, 0), day(1, 12)),
+		batch(day(1, 12), day(2, 0)),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package server runs the HTTP and gRPC listeners and the background work of
+// a service, and shuts them down gracefully when the process is asked to stop.
+//
+// On SIGINT or SIGTERM, a Server drains: its listeners stop accepting
+// connections, and in-flight requests and background work are given up to
+// SHUTDOWN_DRAIN_TIMEOUT to finish. Long-running work, such as export batches
+// and federation pulls, checks Draining between units of work, so that it
+// stops at a checkpoint the next run resumes from. Once the timeout passes,
+// the contexts of requests and background work are canceled.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+
+	kenvconfig "github.com/kelseyhightower/envconfig"
+	"google.golang.org/grpc"
+)
+
+// Config defines the configuration of graceful shutdown.
+type Config struct {
+	// DrainTimeout is how long in-flight requests and background work are
+	// waited for once the server starts draining. It should be shorter than
+	// the platform's grace period after SIGTERM, which is 10 seconds on Cloud
+	// Run and 30 seconds on Kubernetes by default.
+	DrainTimeout time.Duration `envconfig:"SHUTDOWN_DRAIN_TIMEOUT" default:"8s"`
+}
+
+// ConfigFromEnv reads the graceful shutdown configuration from the
+// environment.
+func ConfigFromEnv() (*Config, error) {
+	var config Config
+	if err := kenvconfig.Process("", &config); err != nil {
+		return nil, fmt.Errorf("failed to process shutdown config: %w", err)
+	}
+	return &config, nil
+}
+
+type drainingKey struct{}
+
+// Draining returns a channel that is closed once the server that ctx comes
+// from starts draining. For other contexts it returns nil, which is never
+// ready.
+func Draining(ctx context.Context) <-chan struct{} {
+	ch, _ := ctx.Value(drainingKey{}).(<-chan struct{})
+	return ch
+}
+
+// IsDraining reports whether the server that ctx comes from is draining.
+func IsDraining(ctx context.Context) bool {
+	select {
+	case <-Draining(ctx):
+		return true
+	default:
+		return false
+	}
+}
+
+// detached carries the values of a context without its cancellation.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// Server runs listeners and background work until it drains, see Wait.
+type Server struct {
+	config *Config
+
+	// parent starts the drain when it is done.
+	parent context.Context
+
+	// draining is closed when the drain starts.
+	draining chan struct{}
+
+	// ctx is the context of requests and background work. It carries the
+	// values of parent, and is canceled once the drain times out.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	stops []func(ctx context.Context)
+	errs  chan error
+	work  sync.WaitGroup
+}
+
+// New creates a Server that drains when the process receives SIGINT or
+// SIGTERM, or when ctx is done.
+func New(ctx context.Context, config *Config) *Server {
+	s := &Server{
+		config:   config,
+		parent:   ctx,
+		draining: make(chan struct{}),
+		errs:     make(chan error, 1),
+	}
+	var draining <-chan struct{} = s.draining
+	s.ctx, s.cancel = context.WithCancel(context.WithValue(detached{ctx}, drainingKey{}, draining))
+	return s
+}
+
+// NewFromEnv creates a Server configured from the environment, see New.
+func NewFromEnv(ctx context.Context) (*Server, error) {
+	config, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return New(ctx, config), nil
+}
+
+// ListenAndServe listens on port and serves handler until the server drains.
+func (s *Server) ListenAndServe(port string, handler http.Handler) error {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return fmt.Errorf("listening on :%s: %w", port, err)
+	}
+	s.Serve(listener, handler)
+	return nil
+}
+
+// Serve serves handler on listener until the server drains. Draining stops
+// accepting connections and waits for in-flight requests.
+func (s *Server) Serve(listener net.Listener, handler http.Handler) {
+	srv := &http.Server{
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return s.ctx },
+	}
+	s.addStop(func(ctx context.Context) {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+		}
+	})
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.fail(fmt.Errorf("serving HTTP on %v: %w", listener.Addr(), err))
+		}
+	}()
+}
+
+// ServeGRPC serves srv on listener until the server drains. Draining stops
+// accepting connections and waits for in-flight calls.
+func (s *Server) ServeGRPC(listener net.Listener, srv *grpc.Server) {
+	s.addStop(func(ctx context.Context) {
+		done := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			srv.Stop()
+		}
+	})
+	go func() {
+		if err := srv.Serve(listener); err != nil {
+			s.fail(fmt.Errorf("serving gRPC on %v: %w", listener.Addr(), err))
+		}
+	}()
+}
+
+// Go runs fn in the background. The drain waits for fn to return. fn should
+// stop taking new work once Draining(ctx) is closed; ctx is canceled once the
+// drain times out.
+func (s *Server) Go(fn func(ctx context.Context)) {
+	s.work.Add(1)
+	go func() {
+		defer s.work.Done()
+		fn(s.ctx)
+	}()
+}
+
+// Wait blocks until the process receives SIGINT or SIGTERM, the context of
+// the server is done, or a listener fails, and then drains the server. It
+// returns the listener's error, if one failed.
+func (s *Server) Wait() error {
+	logger := logging.FromContext(s.ctx)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var err error
+	select {
+	case sig := <-signals:
+		logger.Infof("Received %v, draining for up to %v", sig, s.config.DrainTimeout)
+	case <-s.parent.Done():
+		logger.Infof("Draining for up to %v", s.config.DrainTimeout)
+	case err = <-s.errs:
+		logger.Errorf("Draining after a listener failed: %v", err)
+	}
+	close(s.draining)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.DrainTimeout)
+	defer cancel()
+	go func() {
+		// Cancel whatever is still running once the drain times out.
+		<-ctx.Done()
+		s.cancel()
+	}()
+
+	s.mu.Lock()
+	stops := s.stops
+	s.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, stop := range stops {
+		wg.Add(1)
+		go func(stop func(context.Context)) {
+			defer wg.Done()
+			stop(ctx)
+		}(stop)
+	}
+	wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		s.work.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		logger.Infof("Drained")
+	case <-ctx.Done():
+		logger.Warnf("Background work still running after %v, giving up on it", s.config.DrainTimeout)
+	}
+	return err
+}
+
+func (s *Server) addStop(stop func(ctx context.Context)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stops = append(s.stops, stop)
+}
+
+// fail starts the drain because of a listener error.
+func (s *Server) fail(err error) {
+	select {
+	case s.errs <- err:
+	default:
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := New(ctx, &Config{DrainTimeout: 5 * time.Second})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		if !IsDraining(r.Context()) {
+			t.Errorf("request: not draining")
+		}
+		w.Write([]byte("done"))
+	}))
+
+	stopped := make(chan struct{})
+	srv.Go(func(ctx context.Context) {
+		<-Draining(ctx)
+		if ctx.Err() != nil {
+			t.Errorf("work canceled before the drain timed out: %v", ctx.Err())
+		}
+		close(stopped)
+	})
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			t.Errorf("in-flight request failed: %v", err)
+			body <- ""
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+	<-started
+
+	waited := make(chan error, 1)
+	go func() {
+		waited <- srv.Wait()
+	}()
+	cancel()
+	<-stopped
+	close(release)
+
+	if got, want := <-body, "done"; got != want {
+		t.Errorf("in-flight request: got %q, want %q", got, want)
+	}
+	if err := <-waited; err != nil {
+		t.Errorf("Wait: %v", err)
+	}
+	if _, err := http.Get("http://" + listener.Addr().String()); err == nil {
+		t.Errorf("request after the drain succeeded")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := New(ctx, &Config{DrainTimeout: 50 * time.Millisecond})
+
+	canceled := make(chan struct{})
+	srv.Go(func(ctx context.Context) {
+		// Ignores the drain, until the timeout cancels it.
+		<-ctx.Done()
+		close(canceled)
+	})
+
+	cancel()
+	if err := srv.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Errorf("work not canceled after the drain timed out")
+	}
+}
+
+func TestDraining(t *testing.T) {
+	if IsDraining(context.Background()) {
+		t.Errorf("IsDraining of a context without a server: got true")
+	}
+	if Draining(context.Background()) != nil {
+		t.Errorf("Draining of a context without a server: got a channel")
+	}
+}
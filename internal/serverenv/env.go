@@ -19,10 +19,14 @@ import (
 	"context"
 	"crypto"
 	"fmt"
+	"sync"
 
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
 	"github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/observability"
+	"github.com/google/exposure-notifications-server/internal/requestlog"
+	"github.com/google/exposure-notifications-server/internal/runtimeconfig"
 	"github.com/google/exposure-notifications-server/internal/secrets"
 	"github.com/google/exposure-notifications-server/internal/signing"
 	"github.com/google/exposure-notifications-server/internal/storage"
@@ -38,7 +42,14 @@ type ServerEnv struct {
 	database              *database.DB
 	exporter              metrics.ExporterFromContext
 	keyManager            signing.KeyManager
+	requestLogger         *requestlog.Logger
+	runtimeConfig         *runtimeconfig.Watcher
 	secretManager         secrets.SecretManager
+
+	// keyManagers holds the key managers of signing keys that select one
+	// other than keyManager, created when first needed.
+	keyManagersMu sync.Mutex
+	keyManagers   map[signing.KeyManagerType]signing.KeyManager
 }
 
 // Option defines function types to modify the ServerEnv on creation.
@@ -76,6 +87,22 @@ func WithAuthorizedAppProvider(p authorizedapp.Provider) Option {
 	}
 }
 
+// WithRuntimeConfig installs the watcher of settings that change at runtime.
+func WithRuntimeConfig(w *runtimeconfig.Watcher) Option {
+	return func(s *ServerEnv) *ServerEnv {
+		s.runtimeConfig = w
+		return s
+	}
+}
+
+// WithRequestLogger installs the logger of the requests served.
+func WithRequestLogger(l *requestlog.Logger) Option {
+	return func(s *ServerEnv) *ServerEnv {
+		s.requestLogger = l
+		return s
+	}
+}
+
 // WithMetricsExporter creates an Option to install a different metrics exporter.
 func WithMetricsExporter(f metrics.ExporterFromContext) Option {
 	return func(s *ServerEnv) *ServerEnv {
@@ -128,19 +155,74 @@ func (s *ServerEnv) Database() *database.DB {
 	return s.database
 }
 
+// RuntimeConfig returns the watcher of settings that change at runtime. It is
+// nil if there is none, which is safe to use and means no setting changes.
+func (s *ServerEnv) RuntimeConfig() *runtimeconfig.Watcher {
+	return s.runtimeConfig
+}
+
+// RequestLogger returns the logger of the requests served. It is nil if there
+// is none, which is safe to use and logs nothing.
+func (s *ServerEnv) RequestLogger() *requestlog.Logger {
+	return s.requestLogger
+}
+
 // GetSignerForKey returns the crypto.Singer implementation to use based on the installed KeyManager.
-// If there is no KeyManager installed, this returns an error.
-func (s *ServerEnv) GetSignerForKey(ctx context.Context, keyName string) (crypto.Signer, error) {
-	if s.keyManager == nil {
-		return nil, fmt.Errorf("no key manager installed, use WithKeyManager when creating the ServerEnv")
+// Keys with a key manager prefix, such as "awskms://", use that key manager
+// instead, see signing.ParseKeyID. If there is no KeyManager installed, this
+// returns an error.
+func (s *ServerEnv) GetSignerForKey(ctx context.Context, keyName string) (_ crypto.Signer, err error) {
+	ctx, span := observability.StartSpan(ctx, "signing.NewSigner")
+	defer observability.EndSpan(span, &err)
+
+	km, keyID, err := s.KeyManagerForKey(ctx, keyName)
+	if err != nil {
+		return nil, err
 	}
-	sign, err := s.keyManager.NewSigner(ctx, keyName)
+	sign, err := km.NewSigner(ctx, keyID)
 	if err != nil {
 		return nil, fmt.Errorf("KeyManager.NewSigner: %w", err)
 	}
 	return sign, nil
 }
 
+// KeyManagerForKey returns the key manager that holds a signing key, and the
+// key's ID within it.
+func (s *ServerEnv) KeyManagerForKey(ctx context.Context, keyName string) (signing.KeyManager, string, error) {
+	km := s.keyManager
+	if typ, keyID, ok := signing.ParseKeyID(keyName); ok {
+		var err error
+		if km, err = s.keyManagerFor(ctx, typ); err != nil {
+			return nil, "", err
+		}
+		keyName = keyID
+	}
+	if km == nil {
+		return nil, "", fmt.Errorf("no key manager installed, use WithKeyManager when creating the ServerEnv")
+	}
+	return km, keyName, nil
+}
+
+// keyManagerFor returns the key manager of the given type, creating it the
+// first time it is needed.
+func (s *ServerEnv) keyManagerFor(ctx context.Context, typ signing.KeyManagerType) (signing.KeyManager, error) {
+	s.keyManagersMu.Lock()
+	defer s.keyManagersMu.Unlock()
+
+	if km, ok := s.keyManagers[typ]; ok {
+		return km, nil
+	}
+	km, err := signing.KeyManagerFor(ctx, typ)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to key manager %v: %w", typ, err)
+	}
+	if s.keyManagers == nil {
+		s.keyManagers = make(map[signing.KeyManagerType]signing.KeyManager)
+	}
+	s.keyManagers[typ] = km
+	return km, nil
+}
+
 // MetricsExporter returns a context appropriate metrics exporter.
 func (s *ServerEnv) MetricsExporter(ctx context.Context) metrics.Exporter {
 	if s.exporter == nil {
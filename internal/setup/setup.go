@@ -17,13 +17,16 @@ package setup
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/google/exposure-notifications-server/internal/authorizedapp"
 	"github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/envconfig"
 	"github.com/google/exposure-notifications-server/internal/logging"
 	"github.com/google/exposure-notifications-server/internal/metrics"
+	"github.com/google/exposure-notifications-server/internal/migrate"
+	"github.com/google/exposure-notifications-server/internal/observability"
+	"github.com/google/exposure-notifications-server/internal/requestlog"
+	"github.com/google/exposure-notifications-server/internal/runtimeconfig"
 	"github.com/google/exposure-notifications-server/internal/secrets"
 	"github.com/google/exposure-notifications-server/internal/serverenv"
 	"github.com/google/exposure-notifications-server/internal/signing"
@@ -42,14 +45,23 @@ type AuthorizedAppConfigProvider interface {
 	AuthorizedAppConfig() *authorizedapp.Config
 }
 
-// KeyManagerProvider is a marker interface indicating the KeyManagerProvider should be installed.
+// KeyManagerProvider indicates the KeyManager should be installed if
+// KeyManager returns true.
 type KeyManagerProvider interface {
 	KeyManager() bool
 }
 
-// BlobStorageConfigProvider is a marker interface indicating the BlobStorage interface should be installed.
+// KeyManagerConfigProvider provides the configuration of the key manager that
+// is installed if KeyManager returns true. Without it, Google Cloud KMS is
+// used.
+type KeyManagerConfigProvider interface {
+	KeyManagerConfig() *signing.Config
+}
+
+// BlobStorageConfigProvider provides the information about current storage
+// configuration.
 type BlobStorageConfigProvider interface {
-	BlobStorage() bool
+	BlobStorageConfig() *storage.Config
 }
 
 // Function returned from setup to be deferred until the caller exits.
@@ -59,40 +71,65 @@ type Defer func()
 func Setup(ctx context.Context, config DBConfigProvider) (*serverenv.ServerEnv, Defer, error) {
 	logger := logging.FromContext(ctx)
 
-	// Can be changed with a different secret manager interface.
-	// TODO(mikehelmick): Make this extensible to other providers.
-	// TODO(sethvargo): Make TTL configurable.
-	sm, err := secrets.NewCacher(ctx, secrets.NewGCPSecretManager, 5*time.Minute)
+	// The secret manager is configured on its own, since it is needed to
+	// resolve secret:// values in the rest of the environment.
+	smConfig, err := secrets.ConfigFromEnv()
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to connect to secret manager: %v", err)
+		return nil, nil, fmt.Errorf("unable to configure secret manager: %w", err)
 	}
+	rawSM, err := secrets.SecretManagerFor(ctx, smConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to secret manager: %w", err)
+	}
+	sm, err := secrets.WrapCacher(ctx, rawSM, smConfig.SecretCacheTTL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create secret manager cache: %w", err)
+	}
+	logger.Infof("Using secret manager %v", smConfig.SecretManagerType)
 
 	if err := envconfig.Process(ctx, config, sm); err != nil {
 		return nil, nil, fmt.Errorf("error loading environment variables: %v", err)
 	}
 	logger.Infof("Effective environment variables: %+v", config)
 
+	// Metrics are written to the logs and served to Prometheus at /metrics.
+	prometheus := metrics.NewPrometheusExporter(metrics.Registry)
+
 	// Start building serverenv opts
 	opts := []serverenv.Option{
 		serverenv.WithSecretManager(sm),
-		serverenv.WithMetricsExporter(metrics.NewLogsBasedFromContext),
+		serverenv.WithMetricsExporter(func(ctx context.Context) metrics.Exporter {
+			return metrics.NewMultiExporter(metrics.NewLogsBasedFromContext(ctx), prometheus)
+		}),
 	}
 
-	// TODO(mikehelmick): Make this extensible to other providers.
-	if _, ok := config.(KeyManagerProvider); ok {
-		km, err := signing.NewGCPKMS(ctx)
+	var km signing.KeyManager
+	if p, ok := config.(KeyManagerProvider); ok && p.KeyManager() {
+		typ := signing.KeyManagerTypeGoogleCloudKMS
+		if p, ok := config.(KeyManagerConfigProvider); ok {
+			typ = p.KeyManagerConfig().KeyManagerType
+		}
+		km, err = signing.KeyManagerFor(ctx, typ)
 		if err != nil {
 			return nil, nil, fmt.Errorf("unable to connect to key manager: %w", err)
 		}
+		logger.Infof("Using key manager %v", typ)
 		opts = append(opts, serverenv.WithKeyManager(km))
 	}
-	// TODO(mikehelmick): Make this extensible to other providers.
-	if _, ok := config.(BlobStorageConfigProvider); ok {
-		storage, err := storage.NewGoogleCloudStorage(ctx)
+	if p, ok := config.(BlobStorageConfigProvider); ok {
+		typ := p.BlobStorageConfig().BlobstoreType
+		blobstore, err := storage.BlobstoreFor(ctx, typ)
 		if err != nil {
 			return nil, nil, fmt.Errorf("unable to connect to storage system: %v", err)
 		}
-		opts = append(opts, serverenv.WithBlobStorage(storage))
+		logger.Infof("Using blobstore %v", typ)
+		opts = append(opts, serverenv.WithBlobStorage(storage.NewTraced(blobstore)))
+	}
+
+	if config.DB().MigrateOnStart {
+		if err := migrate.Run(ctx, config.DB()); err != nil {
+			return nil, nil, fmt.Errorf("unable to migrate database: %w", err)
+		}
 	}
 
 	// Setup the database connection.
@@ -106,7 +143,59 @@ func Setup(ctx context.Context, config DBConfigProvider) (*serverenv.ServerEnv,
 		redactedDB.Password = "<hidden>"
 		logger.Infof("Effective DB config: %+v", redactedDB)
 	}
+	if keyID := config.DB().ExposureKeyEncryptionKey; keyID != "" {
+		if km == nil {
+			km, err = signing.NewGCPKMS(ctx)
+			if err != nil {
+				defer db.Close(ctx)
+				return nil, nil, fmt.Errorf("unable to connect to key manager: %w", err)
+			}
+		}
+		wrapper, ok := km.(database.KeyWrapper)
+		if !ok {
+			defer db.Close(ctx)
+			return nil, nil, fmt.Errorf("key manager %T cannot encrypt exposure keys", km)
+		}
+		if err := db.EnableExposureKeyEncryption(ctx, wrapper, keyID); err != nil {
+			defer db.Close(ctx)
+			return nil, nil, fmt.Errorf("unable to enable exposure key encryption: %w", err)
+		}
+	}
 	opts = append(opts, serverenv.WithDatabase(db))
+	poolCollector := db.PoolCollector()
+	if err := metrics.Registry.Register(poolCollector); err != nil {
+		logger.Errorf("Failed to register database pool metrics: %v", err)
+	}
+
+	// Secrets are refreshed in the background until the returned Defer is
+	// called, and rotated database secrets are handed to the open database.
+	if ref, ok := envconfig.SecretRef("DB_PASSWORD"); ok {
+		sm.OnChange(ref, db.SetPassword)
+	}
+	if ref, ok := envconfig.SecretRef("DB_CURSOR_SECRET"); ok {
+		sm.OnChange(ref, func(_ context.Context, secret string) { db.SetCursorSecret(secret) })
+	}
+	stopSecrets := func() {}
+	if interval := smConfig.SecretRefreshInterval; interval > 0 {
+		refreshCtx, cancel := context.WithCancel(ctx)
+		go sm.Run(refreshCtx, interval)
+		stopSecrets = cancel
+	}
+
+	// Runtime settings are loaded before the servers start, and then polled
+	// for in the background until the returned Defer is called.
+	var watcher *runtimeconfig.Watcher
+	stopWatcher := func() {}
+	if interval := config.DB().ConfigPollInterval; interval > 0 {
+		watcher = runtimeconfig.New(db, interval)
+		if err := watcher.Poll(ctx); err != nil {
+			logger.Errorf("Failed to load runtime settings, using the environment: %v", err)
+		}
+		watchCtx, cancel := context.WithCancel(ctx)
+		go watcher.Run(watchCtx)
+		stopWatcher = cancel
+		opts = append(opts, serverenv.WithRuntimeConfig(watcher))
+	}
 
 	// AuthorizedApp must come after database setup due to the dependency.
 	if typ, ok := config.(AuthorizedAppConfigProvider); ok {
@@ -115,10 +204,60 @@ func Setup(ctx context.Context, config DBConfigProvider) (*serverenv.ServerEnv,
 		if err != nil {
 			// Ensure the database is closed on an error.
 			defer db.Close(ctx)
+			defer stopWatcher()
+			defer stopSecrets()
 			return nil, nil, fmt.Errorf("unable to create AuthorizedApp provider: %v", err)
 		}
+		// Apps carry the settings of their health authority, so changes to
+		// either table drop the cached apps.
+		if p, ok := provider.(*authorizedapp.DatabaseProvider); ok {
+			invalidate := func(ctx context.Context) {
+				if err := p.InvalidateAll(ctx); err != nil {
+					logging.FromContext(ctx).Errorf("Failed to invalidate authorized apps: %v", err)
+				}
+			}
+			watcher.OnChange(database.ConfigTableAuthorizedApp, invalidate)
+			watcher.OnChange(database.ConfigTableHealthAuthority, invalidate)
+		}
 		opts = append(opts, serverenv.WithAuthorizedAppProvider(provider))
 	}
 
-	return serverenv.New(ctx, opts...), func() { db.Close(ctx) }, nil
+	requestLogConfig, err := requestlog.ConfigFromEnv()
+	if err != nil {
+		defer db.Close(ctx)
+		defer stopWatcher()
+		defer stopSecrets()
+		return nil, nil, fmt.Errorf("unable to configure request logging: %w", err)
+	}
+	requestLogger, err := requestlog.New(requestLogConfig)
+	if err != nil {
+		defer db.Close(ctx)
+		defer stopWatcher()
+		defer stopSecrets()
+		return nil, nil, fmt.Errorf("unable to create request logger: %w", err)
+	}
+	opts = append(opts, serverenv.WithRequestLogger(requestLogger))
+
+	traceConfig, err := observability.ConfigFromEnv()
+	if err != nil {
+		defer db.Close(ctx)
+		defer stopWatcher()
+		defer stopSecrets()
+		return nil, nil, fmt.Errorf("unable to configure tracing: %w", err)
+	}
+	stopTracing, err := observability.Start(ctx, traceConfig)
+	if err != nil {
+		defer db.Close(ctx)
+		defer stopWatcher()
+		defer stopSecrets()
+		return nil, nil, fmt.Errorf("unable to start tracing: %w", err)
+	}
+
+	return serverenv.New(ctx, opts...), func() {
+		stopWatcher()
+		stopSecrets()
+		stopTracing()
+		metrics.Registry.Unregister(poolCollector)
+		db.Close(ctx)
+	}, nil
 }
@@ -0,0 +1,98 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// Compile-time check to verify implements interface.
+var _ KeyManager = (*AWSKMS)(nil)
+
+// AWSKMS implements the signing.KeyManager interface and can be used to sign
+// export files with asymmetric ECC_NIST_P256 keys held by AWS KMS.
+type AWSKMS struct {
+	svc *kms.KMS
+}
+
+// NewAWSKMS creates an AWS KMS client. The region and credentials are read
+// from the environment, such as AWS_REGION and AWS_ACCESS_KEY_ID, or the
+// shared config.
+func NewAWSKMS(ctx context.Context) (KeyManager, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("session.NewSession: %w", err)
+	}
+	return &AWSKMS{svc: kms.New(sess)}, nil
+}
+
+// NewSigner returns a signer for the key keyID, which is a key ID, key ARN,
+// or alias.
+func (k *AWSKMS) NewSigner(ctx context.Context, keyID string) (crypto.Signer, error) {
+	out, err := k.svc.GetPublicKeyWithContext(ctx, &kms.GetPublicKeyInput{
+		KeyId: aws.String(keyID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kms.GetPublicKey: %w", err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(out.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key of %v: %w", keyID, err)
+	}
+	ecPub, err := p256PublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("key %v: %w", keyID, err)
+	}
+	return &awsKMSSigner{ctx: ctx, svc: k.svc, keyID: keyID, pub: ecPub}, nil
+}
+
+// awsKMSSigner signs digests with an AWS KMS key. crypto.Signer has no
+// context, so it uses the one that it was created with.
+type awsKMSSigner struct {
+	ctx   context.Context
+	svc   *kms.KMS
+	keyID string
+	pub   *ecdsa.PublicKey
+}
+
+func (s *awsKMSSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign signs a SHA-256 digest. AWS KMS returns ASN.1 DER encoded signatures.
+func (s *awsKMSSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if err := checkSHA256Digest(digest, opts); err != nil {
+		return nil, err
+	}
+	out, err := s.svc.SignWithContext(s.ctx, &kms.SignInput{
+		KeyId:            aws.String(s.keyID),
+		Message:          digest,
+		MessageType:      aws.String(kms.MessageTypeDigest),
+		SigningAlgorithm: aws.String(kms.SigningAlgorithmSpecEcdsaSha256),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kms.Sign: %w", err)
+	}
+	return out.Signature, nil
+}
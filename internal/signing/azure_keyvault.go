@@ -0,0 +1,134 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/keyvault/keyvault"
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/auth"
+)
+
+// Compile-time check to verify implements interface.
+var _ KeyManager = (*AzureKeyVault)(nil)
+
+// AzureKeyVault implements the signing.KeyManager interface and can be used
+// to sign export files with EC P-256 keys held by Azure Key Vault.
+type AzureKeyVault struct {
+	client *keyvault.BaseClient
+}
+
+// NewAzureKeyVault creates an Azure Key Vault client. Credentials are read
+// from the environment, like for the Azure Key Vault secret manager.
+func NewAzureKeyVault(ctx context.Context) (KeyManager, error) {
+	authorizer, err := auth.NewAuthorizerFromEnvironment()
+	if err != nil {
+		return nil, fmt.Errorf("signing.NewAzureKeyVault: auth: %w", err)
+	}
+
+	client := keyvault.New()
+	client.Authorizer = authorizer
+
+	return &AzureKeyVault{client: &client}, nil
+}
+
+// NewSigner returns a signer for a key, which is specified in the format:
+//
+//     AZURE_KEY_VAULT_NAME/KEY_NAME/KEY_VERSION
+//
+// The version is required, so that the key can't change under a SignatureInfo.
+func (kv *AzureKeyVault) NewSigner(ctx context.Context, keyID string) (crypto.Signer, error) {
+	parts := strings.Split(keyID, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("%v is not a valid key ref, want VAULT/KEY/VERSION", keyID)
+	}
+	s := &azureKeyVaultSigner{
+		ctx:      ctx,
+		client:   kv.client,
+		vaultURL: fmt.Sprintf("https://%s.vault.azure.net", parts[0]),
+		name:     parts[1],
+		version:  parts[2],
+	}
+
+	bundle, err := kv.client.GetKey(ctx, s.vaultURL, s.name, s.version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access key %v: %w", keyID, err)
+	}
+	jwk := bundle.Key
+	if jwk == nil || jwk.Crv != keyvault.P256 || jwk.X == nil || jwk.Y == nil {
+		return nil, fmt.Errorf("key %v is not an EC P-256 key", keyID)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*jwk.X, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decoding public key of %v: %w", keyID, err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*jwk.Y, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decoding public key of %v: %w", keyID, err)
+	}
+	s.pub = &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	return s, nil
+}
+
+// azureKeyVaultSigner signs digests with an Azure Key Vault key. crypto.Signer
+// has no context, so it uses the one that it was created with.
+type azureKeyVaultSigner struct {
+	ctx      context.Context
+	client   *keyvault.BaseClient
+	vaultURL string
+	name     string
+	version  string
+	pub      *ecdsa.PublicKey
+}
+
+func (s *azureKeyVaultSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign signs a SHA-256 digest. Key Vault returns the raw R || S signature,
+// which is converted to ASN.1 DER.
+func (s *azureKeyVaultSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if err := checkSHA256Digest(digest, opts); err != nil {
+		return nil, err
+	}
+	value := base64.RawURLEncoding.EncodeToString(digest)
+	result, err := s.client.Sign(s.ctx, s.vaultURL, s.name, s.version, keyvault.KeySignParameters{
+		Algorithm: keyvault.ES256,
+		Value:     &value,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("keyvault.Sign: %w", err)
+	}
+	if result.Result == nil {
+		return nil, fmt.Errorf("keyvault.Sign returned no signature")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*result.Result, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decoding signature: %w", err)
+	}
+	return rawToASN1Signature(raw)
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// KeyManagerType defines a specific key manager.
+type KeyManagerType string
+
+const (
+	KeyManagerTypeAWSKMS         KeyManagerType = "AWS_KMS"
+	KeyManagerTypeAzureKeyVault  KeyManagerType = "AZURE_KEY_VAULT"
+	KeyManagerTypeGoogleCloudKMS KeyManagerType = "GOOGLE_CLOUD_KMS"
+	KeyManagerTypeHSM            KeyManagerType = "HSM"
+	KeyManagerTypeLocal          KeyManagerType = "LOCAL"
+)
+
+// Config defines the configuration for signing.
+type Config struct {
+	// KeyManagerType is the key manager of the signing keys that don't select
+	// one with a prefix.
+	KeyManagerType KeyManagerType `envconfig:"KEY_MANAGER" default:"GOOGLE_CLOUD_KMS"`
+}
+
+// keyIDPrefixes are the prefixes of the signing keys of SignatureInfos that
+// are held by a key manager other than the server's default one.
+var keyIDPrefixes = map[string]KeyManagerType{
+	"awskms://":        KeyManagerTypeAWSKMS,
+	"azurekeyvault://": KeyManagerTypeAzureKeyVault,
+	"local://":         KeyManagerTypeLocal,
+	"pkcs11://":        KeyManagerTypeHSM,
+}
+
+// ParseKeyID splits a signing key into the key manager that holds it and the
+// key's ID within that key manager, such as "awskms://alias/export" into
+// AWS_KMS and "alias/export". ok is false if the signing key has no known
+// prefix, and is held by the server's default key manager.
+func ParseKeyID(signingKey string) (typ KeyManagerType, keyID string, ok bool) {
+	for prefix, typ := range keyIDPrefixes {
+		if strings.HasPrefix(signingKey, prefix) {
+			return typ, strings.TrimPrefix(signingKey, prefix), true
+		}
+	}
+	return "", signingKey, false
+}
+
+// KeyManagerFor returns the key manager for the given type, or an error if one
+// does not exist.
+func KeyManagerFor(ctx context.Context, typ KeyManagerType) (KeyManager, error) {
+	switch typ {
+	case KeyManagerTypeAWSKMS:
+		return NewAWSKMS(ctx)
+	case KeyManagerTypeAzureKeyVault:
+		return NewAzureKeyVault(ctx)
+	case KeyManagerTypeGoogleCloudKMS:
+		return NewGCPKMS(ctx)
+	case KeyManagerTypeHSM:
+		return NewHSM(ctx)
+	case KeyManagerTypeLocal:
+		return NewLocal(ctx)
+	}
+	return nil, fmt.Errorf("unknown key manager type: %v", typ)
+}
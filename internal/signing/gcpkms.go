@@ -18,13 +18,17 @@ package signing
 import (
 	"context"
 	"crypto"
+	"fmt"
+	"strings"
 
 	kms "cloud.google.com/go/kms/apiv1"
 	"github.com/sethvargo/go-gcpkms/pkg/gcpkms"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
 )
 
 // GCPKMS implements the signing.KeyManager interface and can be used to sign
-// export files.
+// export files. It also implements database.KeyWrapper, to wrap the keys that
+// encrypt exposure keys.
 type GCPKMS struct {
 	client *kms.KeyManagementClient
 }
@@ -44,3 +48,48 @@ func (kms *GCPKMS) NewSigner(ctx context.Context, keyID string) (crypto.Signer,
 	}
 	return signer, nil
 }
+
+// CreateKeyVersion creates a new version of the crypto key that the key
+// version keyID belongs to. Cloud KMS generates asymmetric keys
+// asynchronously, so the new version can only sign once it is enabled, usually
+// within seconds.
+func (kms *GCPKMS) CreateKeyVersion(ctx context.Context, keyID string) (string, error) {
+	i := strings.LastIndex(keyID, "/cryptoKeyVersions/")
+	if i < 0 {
+		return "", fmt.Errorf("%v is not a crypto key version", keyID)
+	}
+	version, err := kms.client.CreateCryptoKeyVersion(ctx, &kmspb.CreateCryptoKeyVersionRequest{
+		Parent:           keyID[:i],
+		CryptoKeyVersion: &kmspb.CryptoKeyVersion{},
+	})
+	if err != nil {
+		return "", err
+	}
+	return version.Name, nil
+}
+
+// Encrypt encrypts plaintext with the symmetric key keyID.
+func (kms *GCPKMS) Encrypt(ctx context.Context, keyID string, plaintext, aad []byte) ([]byte, error) {
+	resp, err := kms.client.Encrypt(ctx, &kmspb.EncryptRequest{
+		Name:                        keyID,
+		Plaintext:                   plaintext,
+		AdditionalAuthenticatedData: aad,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Ciphertext, nil
+}
+
+// Decrypt decrypts ciphertext that was encrypted with the symmetric key keyID.
+func (kms *GCPKMS) Decrypt(ctx context.Context, keyID string, ciphertext, aad []byte) ([]byte, error) {
+	resp, err := kms.client.Decrypt(ctx, &kmspb.DecryptRequest{
+		Name:                        keyID,
+		Ciphertext:                  ciphertext,
+		AdditionalAuthenticatedData: aad,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
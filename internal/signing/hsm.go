@@ -0,0 +1,69 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"os"
+
+	"github.com/ThalesIgnite/crypto11"
+)
+
+// Compile-time check to verify implements interface.
+var _ KeyManager = (*HSM)(nil)
+
+// HSM implements the signing.KeyManager interface and can be used to sign
+// export files with EC P-256 keys held by a hardware security module, through
+// its PKCS#11 library.
+type HSM struct {
+	ctx *crypto11.Context
+}
+
+// NewHSM opens a session with the HSM token. The path of the PKCS#11 library,
+// the token label, and the user PIN are read from PKCS11_MODULE,
+// PKCS11_TOKEN_LABEL, and PKCS11_PIN.
+func NewHSM(ctx context.Context) (KeyManager, error) {
+	config := &crypto11.Config{
+		Path:       os.Getenv("PKCS11_MODULE"),
+		TokenLabel: os.Getenv("PKCS11_TOKEN_LABEL"),
+		Pin:        os.Getenv("PKCS11_PIN"),
+	}
+	if config.Path == "" || config.TokenLabel == "" {
+		return nil, fmt.Errorf("PKCS11_MODULE and PKCS11_TOKEN_LABEL must be set")
+	}
+	c11, err := crypto11.Configure(config)
+	if err != nil {
+		return nil, fmt.Errorf("crypto11.Configure: %w", err)
+	}
+	return &HSM{ctx: c11}, nil
+}
+
+// NewSigner returns a signer for the key pair labelled keyID. The HSM signs
+// with the ECDSA mechanism, and crypto11 returns ASN.1 DER signatures.
+func (h *HSM) NewSigner(ctx context.Context, keyID string) (crypto.Signer, error) {
+	signer, err := h.ctx.FindKeyPair(nil, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("crypto11.FindKeyPair: %w", err)
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("no key pair labelled %v", keyID)
+	}
+	if _, err := p256PublicKey(signer.Public()); err != nil {
+		return nil, fmt.Errorf("key %v: %w", keyID, err)
+	}
+	return signer, nil
+}
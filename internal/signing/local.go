@@ -0,0 +1,111 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/google/exposure-notifications-server/internal/logging"
+)
+
+// Compile-time check to verify implements interface.
+var _ KeyManager = (*Local)(nil)
+
+// Local implements the signing.KeyManager interface with ECDSA P-256 private
+// keys that are loaded from PEM files or environment variables. The private
+// keys are held in memory by the server, so it is only meant for development,
+// tests, and air-gapped deployments where no KMS is available. It is not
+// suitable for production.
+type Local struct{}
+
+// NewLocal creates a key manager for keys loaded from PEM files or
+// environment variables.
+func NewLocal(ctx context.Context) (KeyManager, error) {
+	logging.FromContext(ctx).Warnf("Using local signing keys, which are not suitable for production")
+	return &Local{}, nil
+}
+
+// NewSigner loads the private key keyID, which is either the path of a PEM
+// file, or $NAME to read the PEM from the environment variable NAME. The PEM
+// holds a SEC 1 ("EC PRIVATE KEY") or PKCS #8 ("PRIVATE KEY") key. If it is
+// encrypted, as by "openssl ec -aes256", it is decrypted with the passphrase
+// in LOCAL_SIGNING_KEY_PASSPHRASE.
+func (l *Local) NewSigner(ctx context.Context, keyID string) (crypto.Signer, error) {
+	var data []byte
+	if name := strings.TrimPrefix(keyID, "$"); name != keyID {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %v is not set", name)
+		}
+		data = []byte(v)
+	} else {
+		var err error
+		if data, err = ioutil.ReadFile(keyID); err != nil {
+			return nil, fmt.Errorf("reading key: %w", err)
+		}
+	}
+	return parsePrivateKeyPEM(data, os.Getenv("LOCAL_SIGNING_KEY_PASSPHRASE"))
+}
+
+// parsePrivateKeyPEM parses an ECDSA P-256 private key, decrypting it with
+// passphrase if the PEM block is encrypted.
+func parsePrivateKeyPEM(data []byte, passphrase string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	der := block.Bytes
+	if x509.IsEncryptedPEMBlock(block) {
+		if passphrase == "" {
+			return nil, fmt.Errorf("key is encrypted and no passphrase was given")
+		}
+		var err error
+		if der, err = x509.DecryptPEMBlock(block, []byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("decrypting key: %w", err)
+		}
+	}
+
+	var key crypto.PrivateKey
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing key: %w", err)
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key is %T, not an ECDSA P-256 key", key)
+	}
+	if _, err := p256PublicKey(ecKey.Public()); err != nil {
+		return nil, err
+	}
+	return ecKey, nil
+}
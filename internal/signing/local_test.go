@@ -0,0 +1,116 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalNewSigner(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", sec1, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384DER, err := x509.MarshalECPrivateKey(p384)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+
+	cases := []struct {
+		name       string
+		pem        []byte
+		passphrase string
+		env        bool
+		err        bool
+	}{
+		{name: "sec1", pem: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1})},
+		{name: "pkcs8", pem: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})},
+		{name: "env", pem: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}), env: true},
+		{name: "encrypted", pem: pem.EncodeToMemory(encrypted), passphrase: "secret"},
+		{name: "wrong passphrase", pem: pem.EncodeToMemory(encrypted), passphrase: "wrong", err: true},
+		{name: "no passphrase", pem: pem.EncodeToMemory(encrypted), err: true},
+		{name: "p384", pem: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: p384DER}), err: true},
+		{name: "not pem", pem: []byte("not a key"), err: true},
+	}
+
+	km, err := NewLocal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			os.Setenv("LOCAL_SIGNING_KEY_PASSPHRASE", c.passphrase)
+			defer os.Unsetenv("LOCAL_SIGNING_KEY_PASSPHRASE")
+
+			keyID := filepath.Join(tmp, "key.pem")
+			if c.env {
+				os.Setenv("TEST_SIGNING_KEY", string(c.pem))
+				defer os.Unsetenv("TEST_SIGNING_KEY")
+				keyID = "$TEST_SIGNING_KEY"
+			} else if err := ioutil.WriteFile(keyID, c.pem, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			signer, err := km.NewSigner(ctx, keyID)
+			if (err != nil) != c.err {
+				t.Fatalf("got error %v, want error %v", err, c.err)
+			}
+			if err != nil {
+				return
+			}
+			if pub := signer.Public().(*ecdsa.PublicKey); pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+				t.Errorf("loaded a different key")
+			}
+		})
+	}
+
+	if _, err := km.NewSigner(ctx, "$UNSET_SIGNING_KEY"); err == nil {
+		t.Errorf("expected error for unset environment variable")
+	}
+}
@@ -18,11 +18,77 @@ package signing
 import (
 	"context"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
 )
 
 // KeyManager defines the interface for working with a KMS system that
 // is able to sign bytes using PKI.
 // KeyManager implementations must be able to return a crypto.Signer
+//
+// Export files are signed with ECDSA P-256 over SHA-256. The returned signer's
+// Public key must be a P-256 *ecdsa.PublicKey, and its Sign method must take a
+// SHA-256 digest and return an ASN.1 DER encoded signature, like
+// ecdsa.PrivateKey does.
 type KeyManager interface {
 	NewSigner(ctx context.Context, keyID string) (crypto.Signer, error)
 }
+
+// KeyVersionCreator is implemented by key managers that can create a new
+// version of a signing key, so that the key can be rotated automatically.
+type KeyVersionCreator interface {
+	// CreateKeyVersion creates a new version of the key that keyID is a
+	// version of, and returns the ID of the new version.
+	CreateKeyVersion(ctx context.Context, keyID string) (string, error)
+}
+
+// PublicKeyPEM returns the PEM encoded public key of a signer, as registered
+// with the app stores so devices can verify export files.
+func PublicKeyPEM(signer crypto.Signer) ([]byte, error) {
+	pub, err := p256PublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling public key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// p256PublicKey returns pub as an ECDSA P-256 public key, or an error if it is
+// any other kind of key.
+func p256PublicKey(pub crypto.PublicKey) (*ecdsa.PublicKey, error) {
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok || ecPub.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("key is %T, not an ECDSA P-256 key", pub)
+	}
+	return ecPub, nil
+}
+
+// checkSHA256Digest returns an error unless digest is a SHA-256 digest.
+func checkSHA256Digest(digest []byte, opts crypto.SignerOpts) error {
+	if opts.HashFunc() != crypto.SHA256 || len(digest) != crypto.SHA256.Size() {
+		return fmt.Errorf("only SHA-256 digests can be signed, got %v digest of %d bytes", opts.HashFunc(), len(digest))
+	}
+	return nil
+}
+
+// rawToASN1Signature converts an ECDSA P-256 signature in the raw R || S form,
+// as returned by some key managers, to the ASN.1 DER form.
+func rawToASN1Signature(raw []byte) ([]byte, error) {
+	if len(raw) != 64 {
+		return nil, fmt.Errorf("raw P-256 signature has %d bytes, want 64", len(raw))
+	}
+	return asn1.Marshal(struct {
+		R, S *big.Int
+	}{
+		R: new(big.Int).SetBytes(raw[:32]),
+		S: new(big.Int).SetBytes(raw[32:]),
+	})
+}
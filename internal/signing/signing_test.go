@@ -0,0 +1,141 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+)
+
+func TestParseKeyID(t *testing.T) {
+	cases := []struct {
+		signingKey string
+		typ        KeyManagerType
+		keyID      string
+		ok         bool
+	}{
+		{
+			signingKey: "projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1",
+			keyID:      "projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1",
+		},
+		{signingKey: "awskms://alias/export", typ: KeyManagerTypeAWSKMS, keyID: "alias/export", ok: true},
+		{signingKey: "azurekeyvault://vault/key/1", typ: KeyManagerTypeAzureKeyVault, keyID: "vault/key/1", ok: true},
+		{signingKey: "pkcs11://export-key", typ: KeyManagerTypeHSM, keyID: "export-key", ok: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.signingKey, func(t *testing.T) {
+			typ, keyID, ok := ParseKeyID(c.signingKey)
+			if typ != c.typ || keyID != c.keyID || ok != c.ok {
+				t.Errorf("got (%q, %q, %v), want (%q, %q, %v)", typ, keyID, ok, c.typ, c.keyID, c.ok)
+			}
+		})
+	}
+}
+
+func TestPublicKeyPEM(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := PublicKeyPEM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(got)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatalf("not a PEM public key: %s", got)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ecPub, ok := pub.(*ecdsa.PublicKey); !ok || ecPub.X.Cmp(key.X) != 0 || ecPub.Y.Cmp(key.Y) != 0 {
+		t.Errorf("got public key %v, want %v", pub, key.Public())
+	}
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PublicKeyPEM(p384); err == nil {
+		t.Errorf("expected error for P-384 key")
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PublicKeyPEM(rsaKey); err == nil {
+		t.Errorf("expected error for RSA key")
+	}
+}
+
+func TestRawToASN1Signature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("export"))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Key managers return R and S as 32 bytes each, zero padded.
+	raw := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(raw[32-len(rb):32], rb)
+	copy(raw[64-len(sb):], sb)
+
+	sig, err := rawToASN1Signature(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(sig, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.Verify(&key.PublicKey, digest[:], parsed.R, parsed.S) {
+		t.Errorf("converted signature doesn't verify")
+	}
+
+	if _, err := rawToASN1Signature(raw[:63]); err == nil {
+		t.Errorf("expected error for short signature")
+	}
+}
+
+func TestCheckSHA256Digest(t *testing.T) {
+	digest := sha256.Sum256([]byte("export"))
+	if err := checkSHA256Digest(digest[:], crypto.SHA256); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkSHA256Digest(digest[:], crypto.SHA384); err == nil {
+		t.Errorf("expected error for SHA-384")
+	}
+	if err := checkSHA256Digest(digest[:16], crypto.SHA256); err == nil {
+		t.Errorf("expected error for short digest")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Compile-time check to verify implements interface.
+var _ Blobstore = (*AWSS3)(nil)
+
+// AWSS3 implements the Blob interface and provides the ability
+// write files to AWS S3.
+type AWSS3 struct {
+	svc *s3.S3
+}
+
+// NewAWSS3 creates an AWS S3 Service, suitable for use with
+// serverenv.ServerEnv. The region and credentials are read from the
+// environment, such as AWS_REGION and AWS_ACCESS_KEY_ID, or the shared config.
+func NewAWSS3(ctx context.Context) (Blobstore, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("session.NewSession: %w", err)
+	}
+	return &AWSS3{svc: s3.New(sess)}, nil
+}
+
+// CreateObject creates a new S3 object or overwrites an existing one. The MD5
+// of the contents is sent along, so S3 rejects the write if the contents are
+// corrupted in transit.
+func (s *AWSS3) CreateObject(ctx context.Context, bucket, objectName string, contents []byte, cacheable bool) error {
+	sum := md5.Sum(contents)
+	putInput := &s3.PutObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(objectName),
+		Body:         bytes.NewReader(contents),
+		CacheControl: aws.String(CacheControl(cacheable)),
+		ContentMD5:   aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+	}
+	if _, err := s.svc.PutObjectWithContext(ctx, putInput); err != nil {
+		return fmt.Errorf("storage.CreateObject: %w", err)
+	}
+	return nil
+}
+
+// CreateObjectIfNotExists creates a new S3 object, or returns ErrObjectExists
+// if it already exists. S3 has no conditional writes, so the object is looked
+// up first, and a concurrent writer may still create it in between.
+func (s *AWSS3) CreateObjectIfNotExists(ctx context.Context, bucket, objectName string, contents []byte, cacheable bool) error {
+	headInput := &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(objectName),
+	}
+	_, err := s.svc.HeadObjectWithContext(ctx, headInput)
+	if err == nil {
+		return ErrObjectExists
+	}
+	var aerr awserr.RequestFailure
+	if !errors.As(err, &aerr) || aerr.StatusCode() != 404 {
+		return fmt.Errorf("storage.CreateObjectIfNotExists: %w", err)
+	}
+	return s.CreateObject(ctx, bucket, objectName, contents, cacheable)
+}
+
+// GetObject returns the contents of a S3 object.
+func (s *AWSS3) GetObject(ctx context.Context, bucket, objectName string) ([]byte, error) {
+	out, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(objectName),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("storage.GetObject: %w", err)
+	}
+	defer out.Body.Close()
+
+	contents, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("storage.GetObject: %w", err)
+	}
+	return contents, nil
+}
+
+// DeleteObject deletes a S3 object, returns nil if the object was successfully
+// deleted, or of the object doesn't exist.
+func (s *AWSS3) DeleteObject(ctx context.Context, bucket, objectName string) error {
+	if _, err := s.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(objectName),
+	}); err != nil {
+		return fmt.Errorf("storage.DeleteObject: %w", err)
+	}
+	return nil
+}
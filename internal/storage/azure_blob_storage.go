@@ -0,0 +1,135 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// Compile-time check to verify implements interface.
+var _ Blobstore = (*AzureBlobstore)(nil)
+
+// AzureBlobstore implements the Blob interface and provides the ability
+// write files to Azure Blob Storage. Buckets are Azure containers.
+type AzureBlobstore struct {
+	serviceURL *azblob.ServiceURL
+}
+
+// NewAzureBlobstore creates an Azure Blob Storage client, suitable for use
+// with serverenv.ServerEnv. The storage account and its access key are read
+// from AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_ACCESS_KEY.
+func NewAzureBlobstore(ctx context.Context) (Blobstore, error) {
+	accountName := os.Getenv("AZURE_STORAGE_ACCOUNT")
+	accountKey := os.Getenv("AZURE_STORAGE_ACCESS_KEY")
+	if accountName == "" || accountKey == "" {
+		return nil, fmt.Errorf("AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_ACCESS_KEY must be set")
+	}
+
+	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return nil, fmt.Errorf("azblob.NewSharedKeyCredential: %w", err)
+	}
+	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{})
+
+	primaryURL, err := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net", accountName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse primary url: %w", err)
+	}
+	serviceURL := azblob.NewServiceURL(*primaryURL, pipeline)
+
+	return &AzureBlobstore{serviceURL: &serviceURL}, nil
+}
+
+// CreateObject creates a new blob or overwrites an existing one.
+func (s *AzureBlobstore) CreateObject(ctx context.Context, container, blobName string, contents []byte, cacheable bool) error {
+	return s.upload(ctx, container, blobName, contents, cacheable, azblob.BlobAccessConditions{})
+}
+
+// CreateObjectIfNotExists creates a new blob, or returns ErrObjectExists if it
+// already exists. The check is done by Azure as part of the write.
+func (s *AzureBlobstore) CreateObjectIfNotExists(ctx context.Context, container, blobName string, contents []byte, cacheable bool) error {
+	conditions := azblob.BlobAccessConditions{
+		ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfNoneMatch: azblob.ETagAny},
+	}
+	err := s.upload(ctx, container, blobName, contents, cacheable, conditions)
+	var serr azblob.StorageError
+	if errors.As(err, &serr) && serr.Response().StatusCode == http.StatusPreconditionFailed {
+		return ErrObjectExists
+	}
+	return err
+}
+
+// upload writes a blob and stores the MD5 of its contents with it, so that
+// readers can check its integrity.
+func (s *AzureBlobstore) upload(ctx context.Context, container, blobName string, contents []byte, cacheable bool, conditions azblob.BlobAccessConditions) error {
+	sum := md5.Sum(contents)
+	blobURL := s.serviceURL.NewContainerURL(container).NewBlockBlobURL(blobName)
+	opts := azblob.UploadToBlockBlobOptions{
+		BlobHTTPHeaders: azblob.BlobHTTPHeaders{
+			CacheControl: CacheControl(cacheable),
+			ContentMD5:   sum[:],
+		},
+		AccessConditions: conditions,
+	}
+	if _, err := azblob.UploadBufferToBlockBlob(ctx, contents, blobURL, opts); err != nil {
+		return fmt.Errorf("storage.CreateObject: %w", err)
+	}
+	return nil
+}
+
+// GetObject returns the contents of a blob.
+func (s *AzureBlobstore) GetObject(ctx context.Context, container, blobName string) ([]byte, error) {
+	blobURL := s.serviceURL.NewContainerURL(container).NewBlockBlobURL(blobName)
+	resp, err := blobURL.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		var serr azblob.StorageError
+		if errors.As(err, &serr) && serr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("storage.GetObject: %w", err)
+	}
+	body := resp.Body(azblob.RetryReaderOptions{})
+	defer body.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(body); err != nil {
+		return nil, fmt.Errorf("storage.GetObject: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DeleteObject deletes a blob, returns nil if the blob was successfully
+// deleted, or of the blob doesn't exist.
+func (s *AzureBlobstore) DeleteObject(ctx context.Context, container, blobName string) error {
+	blobURL := s.serviceURL.NewContainerURL(container).NewBlockBlobURL(blobName)
+	if _, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{}); err != nil {
+		var serr azblob.StorageError
+		if errors.As(err, &serr) && serr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			// Blob doesn't exist; presumably already deleted.
+			return nil
+		}
+		return fmt.Errorf("storage.DeleteObject: %w", err)
+	}
+	return nil
+}
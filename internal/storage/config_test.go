@@ -0,0 +1,36 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBlobstoreFor(t *testing.T) {
+	ctx := context.Background()
+
+	got, err := BlobstoreFor(ctx, BlobstoreTypeFilesystem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.(*FilesystemStorage); !ok {
+		t.Errorf("expected *FilesystemStorage, got %T", got)
+	}
+
+	if _, err := BlobstoreFor(ctx, "NOPE"); err == nil {
+		t.Errorf("expected error for unknown blobstore type")
+	}
+}
@@ -17,6 +17,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -37,15 +38,61 @@ func NewFilesystemStorage(ctx context.Context) (Blobstore, error) {
 }
 
 // CreateObject creates a new object on the filesystem or overwrites an existing
-// one.
-func (s *FilesystemStorage) CreateObject(ctx context.Context, folder, filename string, contents []byte) error {
-	pth := filepath.Join(folder, filename)
-	if err := ioutil.WriteFile(pth, contents, 0644); err != nil {
+// one. The contents are written to a temporary file that is then renamed into
+// place, so readers see either the old or the new object, never a partial one.
+// The filesystem has no cache headers, so cacheable is ignored.
+func (s *FilesystemStorage) CreateObject(ctx context.Context, folder, filename string, contents []byte, cacheable bool) error {
+	return writeFile(filepath.Join(folder, filename), contents, os.Rename)
+}
+
+// CreateObjectIfNotExists creates a new object on the filesystem, or returns
+// ErrObjectExists if it already exists. The temporary file is hard linked into
+// place, which fails if the object exists.
+func (s *FilesystemStorage) CreateObjectIfNotExists(ctx context.Context, folder, filename string, contents []byte, cacheable bool) error {
+	err := writeFile(filepath.Join(folder, filename), contents, os.Link)
+	if errors.Is(err, os.ErrExist) {
+		return ErrObjectExists
+	}
+	return err
+}
+
+// writeFile writes contents to a temporary file next to pth, and then moves it
+// into place with place, which is either os.Rename or os.Link.
+func writeFile(pth string, contents []byte, place func(oldpath, newpath string) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(pth), "."+filepath.Base(pth)+".")
+	if err != nil {
+		return fmt.Errorf("failed to create object: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(contents); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to create object: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to create object: %w", err)
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to create object: %w", err)
+	}
+	if err := place(f.Name(), pth); err != nil {
 		return fmt.Errorf("failed to create object: %w", err)
 	}
 	return nil
 }
 
+// GetObject returns the contents of an object on the filesystem.
+func (s *FilesystemStorage) GetObject(ctx context.Context, folder, filename string) ([]byte, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(folder, filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	return contents, nil
+}
+
 // DeleteObject deletes an object from the filesystem. It returns nil if the
 // object was deleted or if the object no longer exists.
 func (s *FilesystemStorage) DeleteObject(ctx context.Context, folder, filename string) error {
@@ -17,6 +17,7 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -63,7 +64,7 @@ func TestFilesystemStorage_CreateObject(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			err = storage.CreateObject(ctx, tc.folder, tc.filepath, tc.contents)
+			err = storage.CreateObject(ctx, tc.folder, tc.filepath, tc.contents, false)
 			if (err != nil) != tc.err {
 				t.Fatal(err)
 			}
@@ -82,6 +83,81 @@ func TestFilesystemStorage_CreateObject(t *testing.T) {
 	}
 }
 
+func TestFilesystemStorage_CreateObjectOverwrite(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+
+	ctx := context.Background()
+	storage, err := NewFilesystemStorage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, contents := range []string{"first", "second"} {
+		if err := storage.CreateObject(ctx, tmp, "index.txt", []byte(contents), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(tmp, "index.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(contents), "second"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	// No temporary files are left behind.
+	files, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected 1 file, got %d", len(files))
+	}
+}
+
+func TestFilesystemStorage_CreateObjectIfNotExists(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+
+	ctx := context.Background()
+	storage, err := NewFilesystemStorage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.CreateObjectIfNotExists(ctx, tmp, "head.json", []byte("first"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.CreateObjectIfNotExists(ctx, tmp, "head.json", []byte("second"), false); !errors.Is(err, ErrObjectExists) {
+		t.Errorf("expected ErrObjectExists, got %v", err)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(tmp, "head.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(contents), "first"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+
+	// No temporary files are left behind.
+	files, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected 1 file, got %d", len(files))
+	}
+}
+
 func TestFilesystemStorage_DeleteObject(t *testing.T) {
 	f, err := ioutil.TempFile("", "")
 	if err != nil {
@@ -122,3 +198,32 @@ func TestFilesystemStorage_DeleteObject(t *testing.T) {
 		})
 	}
 }
+
+func TestFilesystemStorage_GetObject(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+
+	ctx := context.Background()
+	storage, err := NewFilesystemStorage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.GetObject(ctx, tmp, "a.zip"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("expected ErrObjectNotFound, got %v", err)
+	}
+
+	if err := storage.CreateObject(ctx, tmp, "a.zip", []byte("contents"), true); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := storage.GetObject(ctx, tmp, "a.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(contents), "contents"; got != want {
+		t.Errorf("expected %q to be %q", got, want)
+	}
+}
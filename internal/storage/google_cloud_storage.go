@@ -17,10 +17,14 @@ package storage
 
 import (
 	"context"
+	"crypto/md5"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
 )
 
 // Compile-time check to verify implements interface.
@@ -43,8 +47,32 @@ func NewGoogleCloudStorage(ctx context.Context) (Blobstore, error) {
 }
 
 // CreateObject creates a new cloud storage object or overwrites an existing one.
-func (gcs *GoogleCloudStorage) CreateObject(ctx context.Context, bucket, objectName string, contents []byte) error {
-	wc := gcs.client.Bucket(bucket).Object(objectName).NewWriter(ctx)
+func (gcs *GoogleCloudStorage) CreateObject(ctx context.Context, bucket, objectName string, contents []byte, cacheable bool) error {
+	return writeObject(ctx, gcs.client.Bucket(bucket).Object(objectName), contents, cacheable)
+}
+
+// CreateObjectIfNotExists creates a new cloud storage object, or returns
+// ErrObjectExists if it already exists. The check is done by Cloud Storage as
+// part of the write.
+func (gcs *GoogleCloudStorage) CreateObjectIfNotExists(ctx context.Context, bucket, objectName string, contents []byte, cacheable bool) error {
+	obj := gcs.client.Bucket(bucket).Object(objectName).If(storage.Conditions{DoesNotExist: true})
+	if err := writeObject(ctx, obj, contents, cacheable); err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed {
+			return ErrObjectExists
+		}
+		return err
+	}
+	return nil
+}
+
+// writeObject writes an object along with the MD5 of its contents, so Cloud
+// Storage rejects the write if the contents are corrupted in transit.
+func writeObject(ctx context.Context, obj *storage.ObjectHandle, contents []byte, cacheable bool) error {
+	sum := md5.Sum(contents)
+	wc := obj.NewWriter(ctx)
+	wc.CacheControl = CacheControl(cacheable)
+	wc.MD5 = sum[:]
 	if _, err := wc.Write(contents); err != nil {
 		return fmt.Errorf("storage.Writer.Write: %w", err)
 	}
@@ -54,6 +82,24 @@ func (gcs *GoogleCloudStorage) CreateObject(ctx context.Context, bucket, objectN
 	return nil
 }
 
+// GetObject returns the contents of a cloud storage object.
+func (gcs *GoogleCloudStorage) GetObject(ctx context.Context, bucket, objectName string) ([]byte, error) {
+	rc, err := gcs.client.Bucket(bucket).Object(objectName).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("storage.GetObject: %w", err)
+	}
+	defer rc.Close()
+
+	contents, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("storage.GetObject: %w", err)
+	}
+	return contents, nil
+}
+
 // DeleteObject deletes a cloud storage object, returns nil if the object was
 // successfully deleted, or of the object doesn't exist.
 func (gcs *GoogleCloudStorage) DeleteObject(ctx context.Context, bucket, objectName string) error {
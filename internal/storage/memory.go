@@ -0,0 +1,117 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Compile-time check to verify implements interface.
+var _ Blobstore = (*Memory)(nil)
+
+// Memory implements Blobstore and keeps objects in memory. It is meant for
+// tests and local development, where the export path can run without cloud
+// credentials. Objects are lost when the process exits.
+type Memory struct {
+	mu      sync.Mutex
+	objects map[string]map[string]*MemoryObject
+}
+
+// MemoryObject is an object held by a Memory blobstore.
+type MemoryObject struct {
+	Contents  []byte
+	Cacheable bool
+}
+
+// NewMemory creates an empty in-memory Blobstore, suitable for use with
+// serverenv.ServerEnv.
+func NewMemory(ctx context.Context) (Blobstore, error) {
+	return &Memory{objects: make(map[string]map[string]*MemoryObject)}, nil
+}
+
+// CreateObject creates a new object or overwrites an existing one.
+func (m *Memory) CreateObject(ctx context.Context, bucket, objectName string, contents []byte, cacheable bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(bucket, objectName, contents, cacheable)
+	return nil
+}
+
+// CreateObjectIfNotExists creates a new object, or returns ErrObjectExists if
+// it already exists.
+func (m *Memory) CreateObjectIfNotExists(ctx context.Context, bucket, objectName string, contents []byte, cacheable bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.objects[bucket][objectName]; ok {
+		return ErrObjectExists
+	}
+	m.put(bucket, objectName, contents, cacheable)
+	return nil
+}
+
+func (m *Memory) put(bucket, objectName string, contents []byte, cacheable bool) {
+	if m.objects[bucket] == nil {
+		m.objects[bucket] = make(map[string]*MemoryObject)
+	}
+	// Copy the contents, as the caller may reuse its slice.
+	m.objects[bucket][objectName] = &MemoryObject{
+		Contents:  append([]byte(nil), contents...),
+		Cacheable: cacheable,
+	}
+}
+
+// DeleteObject deletes an object. It returns nil if the object was deleted or
+// if it doesn't exist.
+func (m *Memory) DeleteObject(ctx context.Context, bucket, objectName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects[bucket], objectName)
+	return nil
+}
+
+// GetObject returns the contents of an object.
+func (m *Memory) GetObject(ctx context.Context, bucket, objectName string) ([]byte, error) {
+	obj, err := m.Object(bucket, objectName)
+	if err != nil {
+		return nil, err
+	}
+	return obj.Contents, nil
+}
+
+// Object returns an object along with how it was written, so tests can check
+// what was written.
+func (m *Memory) Object(bucket, objectName string) (*MemoryObject, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.objects[bucket][objectName]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return &MemoryObject{Contents: append([]byte(nil), obj.Contents...), Cacheable: obj.Cacheable}, nil
+}
+
+// ListObjects returns the sorted names of the objects in a bucket.
+func (m *Memory) ListObjects(bucket string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.objects[bucket]))
+	for name := range m.objects[bucket] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	blobstore, err := NewMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := blobstore.(*Memory)
+
+	contents := []byte("first")
+	if err := m.CreateObject(ctx, "bucket", "root/a.zip", contents, true); err != nil {
+		t.Fatal(err)
+	}
+	contents[0] = 'F'
+	if err := m.CreateObject(ctx, "bucket", "root/index.txt", []byte("index"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateObjectIfNotExists(ctx, "bucket", "root/a.zip", []byte("second"), true); !errors.Is(err, ErrObjectExists) {
+		t.Errorf("expected ErrObjectExists, got %v", err)
+	}
+	if err := m.CreateObjectIfNotExists(ctx, "other", "root/a.zip", []byte("other"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.Object("bucket", "root/a.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&MemoryObject{Contents: []byte("first"), Cacheable: true}, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"root/a.zip", "root/index.txt"}, m.ListObjects("bucket")); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := m.DeleteObject(ctx, "bucket", "root/a.zip"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.GetObject(ctx, "bucket", "root/a.zip"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("expected ErrObjectNotFound, got %v", err)
+	}
+	if diff := cmp.Diff([]string{"root/index.txt"}, m.ListObjects("bucket")); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
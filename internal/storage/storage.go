@@ -15,13 +15,48 @@
 // Package storage is an interface over file/blob storage
 package storage
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+// ErrObjectExists is returned by CreateObjectIfNotExists if the object
+// already exists.
+var ErrObjectExists = errors.New("object already exists")
+
+// ErrObjectNotFound is returned by GetObject if the object doesn't exist.
+var ErrObjectNotFound = errors.New("object not found")
 
 // Blobstore defines the minimum interface for a blob storage system.
 type Blobstore interface {
-	// CreateObject creates or overwrites an object in the storage system.
-	CreateObject(ctx context.Context, bucket, objectName string, contents []byte) error
+	// CreateObject creates or overwrites an object in the storage system. If
+	// cacheable is false, the object is served with headers that tell clients
+	// and CDNs to revalidate it on every request.
+	CreateObject(ctx context.Context, bucket, objectName string, contents []byte, cacheable bool) error
+
+	// CreateObjectIfNotExists is like CreateObject, but returns
+	// ErrObjectExists instead of overwriting an existing object.
+	CreateObjectIfNotExists(ctx context.Context, bucket, objectName string, contents []byte, cacheable bool) error
+
+	// GetObject returns the contents of an object, or ErrObjectNotFound if it
+	// doesn't exist.
+	GetObject(ctx context.Context, bucket, objectName string) ([]byte, error)
 
 	// DeleteObject deltes an object or does nothing if the object doesn't exist.
 	DeleteObject(ctx context.Context, bucket, objectName string) error
 }
+
+// Cache-Control headers of objects, depending on whether they may be cached.
+const (
+	cacheControlCacheable = "public, max-age=86400"
+	cacheControlNoCache   = "no-cache, max-age=0"
+)
+
+// CacheControl returns the Cache-Control header of an object, for blobstores
+// and for servers that serve objects themselves.
+func CacheControl(cacheable bool) string {
+	if cacheable {
+		return cacheControlCacheable
+	}
+	return cacheControlNoCache
+}
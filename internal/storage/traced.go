@@ -0,0 +1,87 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/exposure-notifications-server/internal/observability"
+
+	"go.opencensus.io/trace"
+)
+
+// Compile-time check to verify implements interface.
+var _ Blobstore = (*Traced)(nil)
+
+// Traced is a Blobstore that traces each operation of the blobstore it wraps.
+type Traced struct {
+	blobstore Blobstore
+}
+
+// NewTraced wraps blobstore so that its operations are traced.
+func NewTraced(blobstore Blobstore) *Traced {
+	return &Traced{blobstore: blobstore}
+}
+
+// CreateObject implements Blobstore.
+func (t *Traced) CreateObject(ctx context.Context, bucket, objectName string, contents []byte, cacheable bool) (err error) {
+	ctx, span := startSpan(ctx, "storage.CreateObject", bucket, objectName)
+	defer observability.EndSpan(span, &err)
+	span.AddAttributes(trace.Int64Attribute("size", int64(len(contents))))
+	return t.blobstore.CreateObject(ctx, bucket, objectName, contents, cacheable)
+}
+
+// CreateObjectIfNotExists implements Blobstore. ErrObjectExists doesn't fail
+// the span.
+func (t *Traced) CreateObjectIfNotExists(ctx context.Context, bucket, objectName string, contents []byte, cacheable bool) error {
+	ctx, span := startSpan(ctx, "storage.CreateObjectIfNotExists", bucket, objectName)
+	span.AddAttributes(trace.Int64Attribute("size", int64(len(contents))))
+	err := t.blobstore.CreateObjectIfNotExists(ctx, bucket, objectName, contents, cacheable)
+	endSpan(span, err, ErrObjectExists)
+	return err
+}
+
+// GetObject implements Blobstore. ErrObjectNotFound doesn't fail the span.
+func (t *Traced) GetObject(ctx context.Context, bucket, objectName string) ([]byte, error) {
+	ctx, span := startSpan(ctx, "storage.GetObject", bucket, objectName)
+	b, err := t.blobstore.GetObject(ctx, bucket, objectName)
+	endSpan(span, err, ErrObjectNotFound)
+	return b, err
+}
+
+// DeleteObject implements Blobstore.
+func (t *Traced) DeleteObject(ctx context.Context, bucket, objectName string) (err error) {
+	ctx, span := startSpan(ctx, "storage.DeleteObject", bucket, objectName)
+	defer observability.EndSpan(span, &err)
+	return t.blobstore.DeleteObject(ctx, bucket, objectName)
+}
+
+func startSpan(ctx context.Context, name, bucket, objectName string) (context.Context, *trace.Span) {
+	ctx, span := observability.StartSpan(ctx, name)
+	span.AddAttributes(
+		trace.StringAttribute("bucket", bucket),
+		trace.StringAttribute("object", objectName))
+	return ctx, span
+}
+
+// endSpan ends span, failing it if err is an error other than expected.
+func endSpan(span *trace.Span, err, expected error) {
+	if errors.Is(err, expected) {
+		span.Annotate(nil, err.Error())
+		err = nil
+	}
+	observability.EndSpan(span, &err)
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTraced(t *testing.T) {
+	ctx := context.Background()
+
+	mem, err := NewMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traced := NewTraced(mem)
+
+	if _, err := traced.GetObject(ctx, "bucket", "file"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("GetObject missing object: got %v, want %v", err, ErrObjectNotFound)
+	}
+	if err := traced.CreateObjectIfNotExists(ctx, "bucket", "file", []byte("contents"), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := traced.CreateObjectIfNotExists(ctx, "bucket", "file", []byte("other"), true); !errors.Is(err, ErrObjectExists) {
+		t.Errorf("CreateObjectIfNotExists existing object: got %v, want %v", err, ErrObjectExists)
+	}
+	if err := traced.CreateObject(ctx, "bucket", "file", []byte("replaced"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := traced.GetObject(ctx, "bucket", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("replaced", string(got)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	if err := traced.DeleteObject(ctx, "bucket", "file"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.GetObject(ctx, "bucket", "file"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("object was not deleted: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	authorizedapp "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/base64util"
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/observability"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// VerificationClaims are the claims of a verification certificate, the JWT a
+// public health authority issues to confirm a diagnosis.
+type VerificationClaims struct {
+	// ReportType is the diagnosis the certificate confirms. Optional.
+	ReportType string `json:"reportType"`
+
+	// SignedMAC is the base64 encoded HMAC of the keys being published, see
+	// CalculateExposureKeyHMAC.
+	SignedMAC string `json:"tekmac"`
+
+	jwt.StandardClaims
+}
+
+// VerifyCertificate verifies the verification certificate in the publish
+// request: that it was signed by one of the app's certificate keys, that it was
+// issued by and for the configured issuer and audience, and that it was issued
+// for exactly the keys in the request.
+func VerifyCertificate(ctx context.Context, cfg *authorizedapp.AuthorizedApp, data *database.Publish, keys CertificateKeys) (_ *VerificationClaims, err error) {
+	ctx, span := observability.StartSpan(ctx, "verification.VerifyCertificate")
+	defer observability.EndSpan(span, &err)
+
+	if cfg == nil {
+		return nil, fmt.Errorf("cannot verify certificate, missing config")
+	}
+	if data.VerificationPayload == "" {
+		return nil, fmt.Errorf("missing verification certificate")
+	}
+	if data.HMACKey == "" {
+		return nil, fmt.Errorf("missing hmac key")
+	}
+
+	claims := &VerificationClaims{}
+	_, err = jwt.ParseWithClaims(data.VerificationPayload, claims, func(tok *jwt.Token) (interface{}, error) {
+		switch tok.Method.(type) {
+		case *jwt.SigningMethodECDSA, *jwt.SigningMethodRSA:
+		default:
+			return nil, fmt.Errorf("unsupported signing method %v", tok.Header["alg"])
+		}
+		kid, ok := tok.Header["kid"].(string)
+		if !ok || kid == "" {
+			return nil, fmt.Errorf("missing kid header")
+		}
+		return keys.CertificateKey(ctx, cfg, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+
+	if claims.ExpiresAt == 0 {
+		return nil, fmt.Errorf("certificate has no expiry")
+	}
+	if !claims.VerifyIssuer(cfg.CertificateIssuer, true) {
+		return nil, fmt.Errorf("certificate issuer %q is not %q", claims.Issuer, cfg.CertificateIssuer)
+	}
+	if cfg.CertificateAudience != "" && !claims.VerifyAudience(cfg.CertificateAudience, true) {
+		return nil, fmt.Errorf("certificate audience %q is not %q", claims.Audience, cfg.CertificateAudience)
+	}
+	if !database.ValidReportType(claims.ReportType) {
+		return nil, fmt.Errorf("certificate has invalid report type %q", claims.ReportType)
+	}
+
+	secret, err := base64util.DecodeString(data.HMACKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode hmac key: %w", err)
+	}
+	signedMAC, err := base64util.DecodeString(claims.SignedMAC)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode certificate tekmac: %w", err)
+	}
+	if !hmac.Equal(CalculateExposureKeyHMAC(data.Keys, secret), signedMAC) {
+		return nil, fmt.Errorf("certificate was not issued for the keys in the request")
+	}
+	return claims, nil
+}
+
+// CalculateExposureKeyHMAC returns the HMAC-SHA256 of the keys under secret.
+// The keys are sorted by their base64 encoding, formatted as
+// base64(key).intervalNumber.intervalCount.transmissionRisk and joined with
+// commas.
+func CalculateExposureKeyHMAC(keys []database.ExposureKey, secret []byte) []byte {
+	sorted := make([]database.ExposureKey, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	parts := make([]string, 0, len(sorted))
+	for _, k := range sorted {
+		parts = append(parts, fmt.Sprintf("%v.%v.%v.%v", k.Key, k.IntervalNumber, k.IntervalCount, k.TransmissionRisk))
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join(parts, ",")))
+	return mac.Sum(nil)
+}
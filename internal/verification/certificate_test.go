@@ -0,0 +1,206 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	authorizedapp "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/database"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestVerifyCertificate(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "EC",
+				"kid": "v1",
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+				"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+			}},
+		})
+	}))
+	defer srv.Close()
+
+	cfg := &authorizedapp.AuthorizedApp{
+		AppPackageName:      appPkgName,
+		CertificateIssuer:   "ha.example.com",
+		CertificateAudience: "exposure-notifications-server",
+		CertificateJWKSURI:  srv.URL,
+	}
+
+	exposureKeys := []database.ExposureKey{
+		{Key: "UW7pkDKfLbfLs8LveFyY3w==", IntervalNumber: 2649168, IntervalCount: 144, TransmissionRisk: 1},
+		{Key: "QLIvVheW9p6JiTx4pslesg==", IntervalNumber: 2649312, IntervalCount: 144, TransmissionRisk: 1},
+	}
+	secret := []byte("0123456789abcdef")
+	hmacKey := base64.StdEncoding.EncodeToString(secret)
+	tekmac := base64.StdEncoding.EncodeToString(CalculateExposureKeyHMAC(exposureKeys, secret))
+
+	validClaims := func() *VerificationClaims {
+		return &VerificationClaims{
+			ReportType: database.ReportTypeConfirmed,
+			SignedMAC:  tekmac,
+			StandardClaims: jwt.StandardClaims{
+				Issuer:    cfg.CertificateIssuer,
+				Audience:  cfg.CertificateAudience,
+				IssuedAt:  time.Now().Unix(),
+				ExpiresAt: time.Now().Add(15 * time.Minute).Unix(),
+			},
+		}
+	}
+	sign := func(claims *VerificationClaims, kid string) string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		tok.Header["kid"] = kid
+		s, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	cases := []struct {
+		name    string
+		claims  func(c *VerificationClaims)
+		kid     string
+		keys    []database.ExposureKey
+		hmacKey string
+		err     string
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name:   "wrong_issuer",
+			claims: func(c *VerificationClaims) { c.Issuer = "other.example.com" },
+			err:    "certificate issuer",
+		},
+		{
+			name:   "wrong_audience",
+			claims: func(c *VerificationClaims) { c.Audience = "other" },
+			err:    "certificate audience",
+		},
+		{
+			name:   "expired",
+			claims: func(c *VerificationClaims) { c.ExpiresAt = time.Now().Add(-time.Minute).Unix() },
+			err:    "invalid certificate",
+		},
+		{
+			name:   "no_expiry",
+			claims: func(c *VerificationClaims) { c.ExpiresAt = 0 },
+			err:    "certificate has no expiry",
+		},
+		{
+			name: "unknown_kid",
+			kid:  "v2",
+			err:  `key "v2" not found`,
+		},
+		{
+			name: "different_keys",
+			keys: exposureKeys[:1],
+			err:  "not issued for the keys",
+		},
+		{
+			name:    "different_hmac_key",
+			hmacKey: base64.StdEncoding.EncodeToString([]byte("fedcba9876543210")),
+			err:     "not issued for the keys",
+		},
+		{
+			name:    "missing_hmac_key",
+			hmacKey: "-",
+			err:     "missing hmac key",
+		},
+		{
+			name:   "invalid_report_type",
+			claims: func(c *VerificationClaims) { c.ReportType = "unknown" },
+			err:    "invalid report type",
+		},
+	}
+
+	keySet := NewKeySet(time.Hour)
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			claims := validClaims()
+			if tc.claims != nil {
+				tc.claims(claims)
+			}
+			kid := "v1"
+			if tc.kid != "" {
+				kid = tc.kid
+			}
+			data := &database.Publish{
+				Keys:                exposureKeys,
+				VerificationPayload: sign(claims, kid),
+				HMACKey:             hmacKey,
+			}
+			if tc.keys != nil {
+				data.Keys = tc.keys
+			}
+			if tc.hmacKey == "-" {
+				data.HMACKey = ""
+			} else if tc.hmacKey != "" {
+				data.HMACKey = tc.hmacKey
+			}
+
+			got, err := VerifyCertificate(ctx, cfg, data, keySet)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("VerifyCertificate: %v", err)
+				}
+				if got.ReportType != database.ReportTypeConfirmed {
+					t.Errorf("got report type %q, want %q", got.ReportType, database.ReportTypeConfirmed)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestCalculateExposureKeyHMACOrder(t *testing.T) {
+	keys := []database.ExposureKey{
+		{Key: "b", IntervalNumber: 1, IntervalCount: 144},
+		{Key: "a", IntervalNumber: 2, IntervalCount: 144},
+	}
+	reversed := []database.ExposureKey{keys[1], keys[0]}
+	secret := []byte("secret")
+
+	if a, b := CalculateExposureKeyHMAC(keys, secret), CalculateExposureKeyHMAC(reversed, secret); string(a) != string(b) {
+		t.Errorf("HMAC depends on key order")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sync"
+	"time"
+
+	authorizedapp "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/database"
+)
+
+// CertificateKeys looks up the public keys that the verification certificates
+// of an app's health authority are signed with.
+type CertificateKeys interface {
+	CertificateKey(ctx context.Context, cfg *authorizedapp.AuthorizedApp, kid string) (crypto.PublicKey, error)
+}
+
+// healthAuthorityKeyDB is the part of the database that stores health
+// authority keys.
+type healthAuthorityKeyDB interface {
+	ListHealthAuthorityKeys(ctx context.Context, healthAuthorityID string) ([]*database.HealthAuthorityKey, error)
+}
+
+// HealthAuthorityKeys looks up certificate keys in the keys stored for the
+// app's health authority. Apps whose health authority has no stored keys use
+// the key set at their CertificateJWKSURI instead.
+type HealthAuthorityKeys struct {
+	db       healthAuthorityKeyDB
+	fallback *KeySet
+	ttl      time.Duration
+
+	// now is replaced in tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*healthAuthorityKeysEntry
+}
+
+type healthAuthorityKeysEntry struct {
+	keys    map[string]*database.HealthAuthorityKey
+	fetched time.Time
+	expires time.Time
+}
+
+// NewHealthAuthorityKeys creates HealthAuthorityKeys that read the keys of
+// each health authority from the database again after ttl.
+func NewHealthAuthorityKeys(db *database.DB, ttl time.Duration) *HealthAuthorityKeys {
+	return &HealthAuthorityKeys{
+		db:       db,
+		fallback: NewKeySet(ttl),
+		ttl:      ttl,
+		now:      time.Now,
+		entries:  make(map[string]*healthAuthorityKeysEntry),
+	}
+}
+
+// CertificateKey returns the key with the given version if it is valid now.
+// Like KeySet, the keys are read again early if kid is unknown, which happens
+// right after they are refreshed from a rotated key set.
+func (k *HealthAuthorityKeys) CertificateKey(ctx context.Context, cfg *authorizedapp.AuthorizedApp, kid string) (crypto.PublicKey, error) {
+	keys, err := k.keys(ctx, cfg.HealthAuthorityID, kid)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		if cfg.CertificateJWKSURI == "" {
+			return nil, fmt.Errorf("health authority %q has no certificate keys", cfg.HealthAuthorityID)
+		}
+		return k.fallback.CertificateKey(ctx, cfg, kid)
+	}
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("key %q not found for health authority %q", kid, cfg.HealthAuthorityID)
+	}
+	if !key.IsValidAt(k.now()) {
+		return nil, fmt.Errorf("key %q of health authority %q is not valid now", kid, cfg.HealthAuthorityID)
+	}
+	return ParsePublicKeyPEM(key.PublicKeyPEM)
+}
+
+// InvalidateAll drops the cached keys of every health authority, so that they
+// are read again on next use. It is called when the HealthAuthorityKey table
+// changes.
+func (k *HealthAuthorityKeys) InvalidateAll() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.entries = make(map[string]*healthAuthorityKeysEntry)
+}
+
+func (k *HealthAuthorityKeys) keys(ctx context.Context, healthAuthorityID, kid string) (map[string]*database.HealthAuthorityKey, error) {
+	if healthAuthorityID == "" {
+		return nil, nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := k.now()
+	e := k.entries[healthAuthorityID]
+	if e == nil || now.After(e.expires) || (len(e.keys) > 0 && e.keys[kid] == nil && now.Sub(e.fetched) >= keySetRefreshInterval) {
+		list, err := k.db.ListHealthAuthorityKeys(ctx, healthAuthorityID)
+		if err != nil {
+			return nil, fmt.Errorf("reading keys of health authority %q: %w", healthAuthorityID, err)
+		}
+		e = &healthAuthorityKeysEntry{
+			keys:    make(map[string]*database.HealthAuthorityKey, len(list)),
+			fetched: now,
+			expires: now.Add(k.ttl),
+		}
+		for _, key := range list {
+			e.keys[key.Version] = key
+		}
+		k.entries[healthAuthorityID] = e
+	}
+	return e.keys, nil
+}
+
+// ParsePublicKeyPEM parses a PEM encoded PKIX ECDSA or RSA public key.
+func ParsePublicKeyPEM(s string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("no PEM encoded public key found")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", pub)
+}
+
+// EncodePublicKeyPEM returns the PEM encoding of a PKIX public key.
+func EncodePublicKeyPEM(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("marshalling public key: %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
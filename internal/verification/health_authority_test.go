@@ -0,0 +1,123 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	authorizedapp "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/database"
+)
+
+// fakeKeyDB serves health authority keys and counts the reads.
+type fakeKeyDB struct {
+	keys  map[string][]*database.HealthAuthorityKey
+	reads int
+}
+
+func (f *fakeKeyDB) ListHealthAuthorityKeys(ctx context.Context, healthAuthorityID string) ([]*database.HealthAuthorityKey, error) {
+	f.reads++
+	return f.keys[healthAuthorityID], nil
+}
+
+func TestHealthAuthorityKeys(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem, err := EncodePublicKeyPEM(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+	ended := now.Add(-time.Minute)
+	db := &fakeKeyDB{keys: map[string][]*database.HealthAuthorityKey{
+		"ha": {
+			{Version: "v1", From: now.Add(-48 * time.Hour), Thru: &ended, PublicKeyPEM: pem},
+			{Version: "v2", From: now.Add(-24 * time.Hour), PublicKeyPEM: pem},
+			{Version: "v3", From: now.Add(time.Hour), PublicKeyPEM: pem},
+		},
+	}}
+	keys := &HealthAuthorityKeys{
+		db:       db,
+		fallback: NewKeySet(time.Hour),
+		ttl:      time.Hour,
+		now:      func() time.Time { return now },
+		entries:  make(map[string]*healthAuthorityKeysEntry),
+	}
+
+	cases := []struct {
+		name string
+		cfg  *authorizedapp.AuthorizedApp
+		kid  string
+		err  string
+	}{
+		{name: "valid", cfg: &authorizedapp.AuthorizedApp{HealthAuthorityID: "ha"}, kid: "v2"},
+		{name: "ended", cfg: &authorizedapp.AuthorizedApp{HealthAuthorityID: "ha"}, kid: "v1", err: "not valid now"},
+		{name: "not yet valid", cfg: &authorizedapp.AuthorizedApp{HealthAuthorityID: "ha"}, kid: "v3", err: "not valid now"},
+		{name: "unknown kid", cfg: &authorizedapp.AuthorizedApp{HealthAuthorityID: "ha"}, kid: "v4", err: "not found"},
+		{name: "no keys", cfg: &authorizedapp.AuthorizedApp{HealthAuthorityID: "other"}, kid: "v2", err: "has no certificate keys"},
+		{name: "no health authority", cfg: &authorizedapp.AuthorizedApp{}, kid: "v2", err: "has no certificate keys"},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := keys.CertificateKey(ctx, tc.cfg, tc.kid)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if pub, ok := got.(*ecdsa.PublicKey); !ok || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+					t.Errorf("got key %v, want %v", got, key.Public())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+
+	// The unknown kid was looked up right after the keys were read, so they
+	// were not read again.
+	if db.reads != 2 {
+		t.Errorf("got %d reads, want 2", db.reads)
+	}
+	now = now.Add(keySetRefreshInterval)
+	if _, err := keys.CertificateKey(ctx, &authorizedapp.AuthorizedApp{HealthAuthorityID: "ha"}, "v4"); err == nil {
+		t.Errorf("got nil error for unknown kid")
+	}
+	if db.reads != 3 {
+		t.Errorf("got %d reads after refresh interval, want 3", db.reads)
+	}
+}
+
+func TestParsePublicKeyPEM(t *testing.T) {
+	if _, err := ParsePublicKeyPEM("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"); err == nil {
+		t.Errorf("ParsePublicKeyPEM of a certificate: got nil error")
+	}
+	if _, err := ParsePublicKeyPEM("not a key"); err == nil {
+		t.Errorf("ParsePublicKeyPEM of garbage: got nil error")
+	}
+}
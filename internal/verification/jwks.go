@@ -0,0 +1,209 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verification
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	authorizedapp "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
+	"github.com/google/exposure-notifications-server/internal/logging"
+	"github.com/google/exposure-notifications-server/internal/observability"
+)
+
+const (
+	// maxKeySetSize bounds the size of a downloaded JSON Web Key Set.
+	maxKeySetSize = 1 << 20
+
+	// keySetRefreshInterval is the minimum time between downloads of the same
+	// key set, so that unknown key IDs can't be used to flood the issuer.
+	keySetRefreshInterval = time.Minute
+)
+
+// KeySet downloads and caches the JSON Web Key Sets that health authorities
+// publish the keys of their verification certificates in.
+type KeySet struct {
+	ttl    time.Duration
+	client *http.Client
+
+	// now is replaced in tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*keySetEntry
+}
+
+type keySetEntry struct {
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	expires time.Time
+}
+
+// NewKeySet creates a KeySet that refreshes each key set after ttl.
+func NewKeySet(ttl time.Duration) *KeySet {
+	return &KeySet{
+		ttl:     ttl,
+		client:  &http.Client{Timeout: 10 * time.Second, Transport: observability.HTTPTransport(nil)},
+		now:     time.Now,
+		entries: make(map[string]*keySetEntry),
+	}
+}
+
+// Key returns the public key with the given key ID from the key set at uri.
+// The key set is downloaded again if it has expired or doesn't contain kid,
+// which happens when the health authority rotates its keys.
+func (s *KeySet) Key(ctx context.Context, uri, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	e := s.entries[uri]
+	if e == nil || now.After(e.expires) || (e.keys[kid] == nil && now.Sub(e.fetched) >= keySetRefreshInterval) {
+		keys, err := s.Fetch(ctx, uri)
+		switch {
+		case err != nil && e == nil:
+			return nil, fmt.Errorf("fetching key set: %w", err)
+		case err != nil:
+			logging.FromContext(ctx).Warnf("using cached key set for %v: %v", uri, err)
+			e.fetched = now
+			e.expires = now.Add(keySetRefreshInterval)
+		default:
+			e = &keySetEntry{keys: keys, fetched: now, expires: now.Add(s.ttl)}
+			s.entries[uri] = e
+		}
+	}
+
+	key, ok := e.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("key %q not found in key set %v", kid, uri)
+	}
+	return key, nil
+}
+
+// CertificateKey returns the key from the app's certificate key set.
+func (s *KeySet) CertificateKey(ctx context.Context, cfg *authorizedapp.AuthorizedApp, kid string) (crypto.PublicKey, error) {
+	return s.Key(ctx, cfg.CertificateJWKSURI, kid)
+}
+
+// Fetch downloads the key set at uri, bypassing the cache, and returns its
+// supported keys by key ID.
+func (s *KeySet) Fetch(ctx context.Context, uri string) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxKeySetSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return parseKeySet(data)
+}
+
+// jsonWebKey is a single key in a JSON Web Key Set, see RFC 7517. Only the
+// fields for EC and RSA public keys are read.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// parseKeySet parses a JSON Web Key Set into its public keys by key ID. Keys
+// of unsupported types are skipped.
+func parseKeySet(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid key set: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kid == "" {
+			continue
+		}
+		switch k.Kty {
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid x: %w", k.Kid, err)
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid y: %w", k.Kid, err)
+			}
+			curve := elliptic.P256()
+			if !curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("key %q: point is not on curve", k.Kid)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid n: %w", k.Kid, err)
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid e: %w", k.Kid, err)
+			}
+			if !e.IsInt64() || e.Int64() > 1<<31-1 {
+				return nil, fmt.Errorf("key %q: exponent is too large", k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("key set contains no supported keys")
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
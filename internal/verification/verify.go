@@ -16,12 +16,14 @@ package verification
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
 	"time"
 
 	"github.com/google/exposure-notifications-server/internal/android"
 	authorizedapp "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
 	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/observability"
 
 	"github.com/google/exposure-notifications-server/internal/ios"
 )
@@ -38,6 +40,17 @@ func VerifyRegions(cfg *authorizedapp.AuthorizedApp, data *database.Publish) err
 		return fmt.Errorf("app configuration is empty")
 	}
 
+	// A traveler's keys may also be written to the regions they visited, as
+	// long as the app is allowed to write to one of the regions.
+	if data.Traveler && cfg.AllowTravelers {
+		for _, r := range data.Regions {
+			if cfg.IsAllowedRegion(r) {
+				return nil
+			}
+		}
+		return fmt.Errorf("app '%v' tried to write traveler keys without an authorized region: %v", cfg.AppPackageName, data.Regions)
+	}
+
 	for _, r := range data.Regions {
 		if !cfg.IsAllowedRegion(r) {
 			return fmt.Errorf("app '%v' tried to write unauthorized region: '%v'", cfg.AppPackageName, r)
@@ -48,14 +61,50 @@ func VerifyRegions(cfg *authorizedapp.AuthorizedApp, data *database.Publish) err
 	return nil
 }
 
+// FilterRegions is the lenient form of VerifyRegions. Instead of rejecting a
+// request that lists regions the app may not write to, it returns the regions
+// the keys may be written to and the ones that must be dropped. It returns an
+// error only if none of the requested regions are allowed.
+func FilterRegions(cfg *authorizedapp.AuthorizedApp, data *database.Publish) ([]string, []string, error) {
+	if cfg == nil {
+		return nil, nil, fmt.Errorf("app configuration is empty")
+	}
+
+	// A traveler's regions are allowed as a whole, see VerifyRegions.
+	if data.Traveler && cfg.AllowTravelers {
+		if err := VerifyRegions(cfg, data); err != nil {
+			return nil, nil, err
+		}
+		return data.Regions, nil, nil
+	}
+
+	var allowed, dropped []string
+	for _, r := range data.Regions {
+		if cfg.IsAllowedRegion(r) {
+			allowed = append(allowed, r)
+		} else {
+			dropped = append(dropped, r)
+		}
+	}
+	if len(allowed) == 0 && len(dropped) > 0 {
+		return nil, nil, fmt.Errorf("app '%v' tried to write only unauthorized regions: %v", cfg.AppPackageName, dropped)
+	}
+	return allowed, dropped, nil
+}
+
 // VerifySafetyNet verifies the Android SafetyNet device attestation against the
-// allowed configuration for the application.
-func VerifySafetyNet(ctx context.Context, requestTime time.Time, cfg *authorizedapp.AuthorizedApp, publish *database.Publish) error {
+// allowed configuration for the application. The attestation's certificate
+// chain must lead to one of roots, or to a system root if roots is nil.
+func VerifySafetyNet(ctx context.Context, requestTime time.Time, cfg *authorizedapp.AuthorizedApp, publish *database.Publish, roots *x509.CertPool) (err error) {
+	ctx, span := observability.StartSpan(ctx, "verification.VerifySafetyNet")
+	defer observability.EndSpan(span, &err)
+
 	if cfg == nil {
 		return fmt.Errorf("cannot enforce SafetyNet, missing config")
 	}
 
 	opts := android.VerifyOptsFor(cfg, requestTime, publish.AndroidNonce())
+	opts.Roots = roots
 	if err := androidValidateAttestation(ctx, publish.DeviceVerificationPayload, opts); err != nil {
 		return fmt.Errorf("android.ValidateAttestation: %w", err)
 	}
@@ -64,7 +113,10 @@ func VerifySafetyNet(ctx context.Context, requestTime time.Time, cfg *authorized
 }
 
 // VerifyDeviceCheck verifies an iOS DeviceCheck token against the Apple API.
-func VerifyDeviceCheck(ctx context.Context, cfg *authorizedapp.AuthorizedApp, data *database.Publish) error {
+func VerifyDeviceCheck(ctx context.Context, cfg *authorizedapp.AuthorizedApp, data *database.Publish) (err error) {
+	ctx, span := observability.StartSpan(ctx, "verification.VerifyDeviceCheck")
+	defer observability.EndSpan(span, &err)
+
 	if cfg == nil {
 		return fmt.Errorf("cannot enforce DeviceCheck, missing config")
 	}
@@ -24,6 +24,7 @@ import (
 	"github.com/google/exposure-notifications-server/internal/android"
 	authorizedapp "github.com/google/exposure-notifications-server/internal/authorizedapp/model"
 	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/go-cmp/cmp"
 )
 
 const (
@@ -92,6 +93,34 @@ func TestVerifyRegions(t *testing.T) {
 			},
 			err: true,
 		},
+		{
+			name: "traveler_visited_regions",
+			data: &database.Publish{Regions: []string{"US", "MX"}, Traveler: true},
+			cfg: &authorizedapp.AuthorizedApp{
+				AppPackageName: appPkgName,
+				AllowedRegions: map[string]struct{}{"US": {}},
+				AllowTravelers: true,
+			},
+		},
+		{
+			name: "traveler_without_home_region",
+			data: &database.Publish{Regions: []string{"MX"}, Traveler: true},
+			cfg: &authorizedapp.AuthorizedApp{
+				AppPackageName: appPkgName,
+				AllowedRegions: map[string]struct{}{"US": {}},
+				AllowTravelers: true,
+			},
+			err: true,
+		},
+		{
+			name: "travelers_not_allowed",
+			data: &database.Publish{Regions: []string{"US", "MX"}, Traveler: true},
+			cfg: &authorizedapp.AuthorizedApp{
+				AppPackageName: appPkgName,
+				AllowedRegions: map[string]struct{}{"US": {}},
+			},
+			err: true,
+		},
 		{
 			name: "region_matches_none",
 			data: &database.Publish{Regions: []string{"MX"}},
@@ -117,6 +146,89 @@ func TestVerifyRegions(t *testing.T) {
 	}
 }
 
+func TestFilterRegions(t *testing.T) {
+	usAndCA := &authorizedapp.AuthorizedApp{
+		AppPackageName: appPkgName,
+		AllowedRegions: map[string]struct{}{
+			"US": {},
+			"CA": {},
+		},
+	}
+
+	cases := []struct {
+		name        string
+		data        *database.Publish
+		cfg         *authorizedapp.AuthorizedApp
+		wantAllowed []string
+		wantDropped []string
+		err         bool
+	}{
+		{
+			name: "nil_config",
+			data: &database.Publish{Regions: []string{"US"}},
+			err:  true,
+		},
+		{
+			name:        "all_allowed",
+			data:        &database.Publish{Regions: []string{"US", "CA"}},
+			cfg:         usAndCA,
+			wantAllowed: []string{"US", "CA"},
+		},
+		{
+			name:        "some_dropped",
+			data:        &database.Publish{Regions: []string{"US", "MX", "CA", "FR"}},
+			cfg:         usAndCA,
+			wantAllowed: []string{"US", "CA"},
+			wantDropped: []string{"MX", "FR"},
+		},
+		{
+			name: "none_allowed",
+			data: &database.Publish{Regions: []string{"MX"}},
+			cfg:  usAndCA,
+			err:  true,
+		},
+		{
+			name: "no_regions",
+			data: &database.Publish{},
+			cfg:  usAndCA,
+		},
+		{
+			name: "traveler_visited_regions",
+			data: &database.Publish{Regions: []string{"US", "MX"}, Traveler: true},
+			cfg: &authorizedapp.AuthorizedApp{
+				AppPackageName: appPkgName,
+				AllowedRegions: map[string]struct{}{"US": {}},
+				AllowTravelers: true,
+			},
+			wantAllowed: []string{"US", "MX"},
+		},
+		{
+			name:        "travelers_not_allowed",
+			data:        &database.Publish{Regions: []string{"US", "MX"}, Traveler: true},
+			cfg:         usAndCA,
+			wantAllowed: []string{"US"},
+			wantDropped: []string{"MX"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			allowed, dropped, err := FilterRegions(tc.cfg, tc.data)
+			if (err != nil) != tc.err {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.wantAllowed, allowed); diff != "" {
+				t.Errorf("allowed mismatch (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantDropped, dropped); diff != "" {
+				t.Errorf("dropped mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestVerifySafetyNet(t *testing.T) {
 	allRegions := &authorizedapp.AuthorizedApp{
 		AppPackageName: appPkgName,
@@ -156,7 +268,7 @@ func TestVerifySafetyNet(t *testing.T) {
 			return c.AttestationResult
 		}
 
-		err := VerifySafetyNet(ctx, time.Now(), c.Cfg, c.Data)
+		err := VerifySafetyNet(ctx, time.Now(), c.Cfg, c.Data, nil)
 		if c.Msg == "" && err == nil {
 			continue
 		}
@@ -0,0 +1,32 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+
+BEGIN;
+
+CREATE TABLE ExposureOutbox (
+  id BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  exposure_count INT NOT NULL,
+  regions VARCHAR(5)[] NOT NULL,
+  first_created_at TIMESTAMPTZ NOT NULL,
+  last_created_at TIMESTAMPTZ NOT NULL,
+  attempts INT NOT NULL DEFAULT 0,
+  lease_expires TIMESTAMPTZ,
+  delivered_at TIMESTAMPTZ
+);
+
+CREATE INDEX exposure_outbox_pending_idx ON ExposureOutbox (id) WHERE delivered_at IS NULL;
+
+END;
@@ -0,0 +1,48 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- change_id orders exposures by when they were last inserted or revised. The
+-- high bits hold the writing transaction's ID and the low 20 bits a sequence
+-- number, so that readers can tell which changes are final.
+ALTER TABLE Exposure ADD COLUMN change_id BIGINT;
+
+-- Existing exposures sort before any new change.
+UPDATE Exposure SET change_id = ordered.n
+FROM (
+	SELECT exposure_key, row_number() OVER (ORDER BY created_at, exposure_key) AS n
+	FROM Exposure
+) AS ordered
+WHERE Exposure.exposure_key = ordered.exposure_key;
+
+ALTER TABLE Exposure ALTER COLUMN change_id SET NOT NULL;
+CREATE INDEX exposure_change_id ON Exposure (change_id);
+
+CREATE SEQUENCE exposure_change_seq;
+
+CREATE OR REPLACE FUNCTION SetExposureChangeID() RETURNS TRIGGER AS $$
+	BEGIN
+		NEW.change_id := (txid_current() << 20) | (nextval('exposure_change_seq') & 1048575);
+		RETURN NEW;
+	END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER exposure_change_id
+	BEFORE INSERT OR UPDATE OF
+		transmission_risk, regions, interval_number, interval_count, report_type, days_since_onset, revised_at
+	ON Exposure
+	FOR EACH ROW EXECUTE PROCEDURE SetExposureChangeID();
+
+END;
@@ -0,0 +1,23 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Apps with a certificate_issuer require publish requests to carry a
+-- verification certificate signed by their health authority.
+ALTER TABLE AuthorizedApp ADD COLUMN certificate_issuer VARCHAR(255);
+ALTER TABLE AuthorizedApp ADD COLUMN certificate_audience VARCHAR(255);
+ALTER TABLE AuthorizedApp ADD COLUMN certificate_jwks_uri VARCHAR(1000);
+
+END;
@@ -0,0 +1,30 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Keys that encrypt revision tokens, wrapped by a key in the KMS. Retired
+-- keys are kept so that tokens issued with them can still be read.
+CREATE TABLE RevisionTokenKey (
+	id SERIAL PRIMARY KEY,
+	kms_key_id VARCHAR(500) NOT NULL,
+	wrapped_key BYTEA NOT NULL,
+	active BOOLEAN NOT NULL DEFAULT false,
+	created_at TIMESTAMPTZ NOT NULL,
+	retired_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX revision_token_key_active ON RevisionTokenKey (active) WHERE active;
+
+END;
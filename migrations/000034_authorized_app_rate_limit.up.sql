@@ -0,0 +1,23 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Overrides of the default rate limit for publish requests from an app. The
+-- app may make rate_limit_tokens requests per client in any
+-- rate_limit_interval_seconds.
+ALTER TABLE AuthorizedApp ADD COLUMN rate_limit_tokens INT;
+ALTER TABLE AuthorizedApp ADD COLUMN rate_limit_interval_seconds INT;
+
+END;
@@ -0,0 +1,26 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Settings of a health authority, shared by all of its apps. A health
+-- authority without a row uses the defaults.
+CREATE TABLE HealthAuthority (
+	health_authority_id VARCHAR(100) PRIMARY KEY,
+	-- If true, apps of the health authority accept keys that are still valid,
+	-- and exports hold them back until they expire.
+	embargo_same_day_keys BOOLEAN NOT NULL DEFAULT false
+);
+
+END;
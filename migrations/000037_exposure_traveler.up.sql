@@ -0,0 +1,24 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Travelers' keys may be published to the regions they visited, and exports
+-- may include them regardless of the health authority that published them.
+ALTER TABLE Exposure ADD COLUMN traveler BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE HealthAuthority ADD COLUMN allow_travelers BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE ExportConfig ADD COLUMN include_travelers BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE ExportBatch ADD COLUMN include_travelers BOOLEAN NOT NULL DEFAULT false;
+
+END;
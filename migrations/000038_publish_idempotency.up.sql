@@ -0,0 +1,31 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Responses to publish requests that carried an Idempotency-Key header, so
+-- a retried request gets the original response instead of being processed
+-- again.
+CREATE TABLE PublishIdempotency (
+	app_package_name VARCHAR(1000) NOT NULL,
+	idempotency_key VARCHAR(255) NOT NULL,
+	request_hash VARCHAR(64) NOT NULL,
+	response BYTEA NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (app_package_name, idempotency_key)
+);
+
+CREATE INDEX publish_idempotency_created_at ON PublishIdempotency (created_at);
+
+END;
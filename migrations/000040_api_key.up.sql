@@ -0,0 +1,30 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- API keys that health authorities use for server to server requests. Only
+-- a hash of each key is stored. Permissions name the requests a key may make,
+-- and allowed_regions the regions it may publish keys to.
+CREATE TABLE APIKey (
+	key_sha256 VARCHAR(64) PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	health_authority_id VARCHAR(100) NOT NULL,
+	permissions TEXT[] NOT NULL,
+	allowed_regions TEXT[] NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	revoked_at TIMESTAMPTZ
+);
+
+END;
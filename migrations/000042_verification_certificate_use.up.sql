@@ -0,0 +1,29 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- The IDs (jti claims) of verification certificates that have been used to
+-- publish, so that a certificate can't be replayed. Rows are kept until the
+-- certificate expires.
+CREATE TABLE VerificationCertificateUse (
+	issuer VARCHAR(255) NOT NULL,
+	jti VARCHAR(255) NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (issuer, jti)
+);
+
+CREATE INDEX verification_certificate_use_expires_at ON VerificationCertificateUse (expires_at);
+
+END;
@@ -0,0 +1,26 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- The lease an export worker holds on the batch it is exporting. The token
+-- identifies the worker's claim, so that a worker whose lease expired and was
+-- claimed by another worker can't complete the batch.
+CREATE TABLE ExportBatchLease (
+	batch_id INT PRIMARY KEY REFERENCES ExportBatch(batch_id) ON DELETE CASCADE,
+	lease_token VARCHAR(64) NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+END;
@@ -0,0 +1,36 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+CREATE TABLE ExportBatchStats (
+	batch_id INT PRIMARY KEY REFERENCES ExportBatch(batch_id),
+	config_id INT NOT NULL REFERENCES ExportConfig(config_id),
+	region VARCHAR(5) NOT NULL,
+	start_timestamp TIMESTAMPTZ NOT NULL,
+	end_timestamp TIMESTAMPTZ NOT NULL,
+	keys INT NOT NULL,
+	-- Maps report types to the number of keys with them, as a JSON object such
+	-- as {"confirmed": 10, "": 2}.
+	keys_by_report_type JSONB NOT NULL,
+	dropped_keys INT NOT NULL,
+	padding_keys INT NOT NULL,
+	files INT NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX export_batch_stats_config_start ON ExportBatchStats (config_id, start_timestamp);
+
+END;
@@ -0,0 +1,25 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- auto_rotate marks the signing keys that the export server rotates. When a
+-- key is rotated, rotated_to_id points to the signature info of its new key
+-- version.
+ALTER TABLE SignatureInfo
+	ADD COLUMN auto_rotate BOOLEAN NOT NULL DEFAULT false,
+	ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	ADD COLUMN rotated_to_id INT REFERENCES SignatureInfo(id);
+
+END;
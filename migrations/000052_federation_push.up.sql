@@ -0,0 +1,33 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Partners that this server pushes local keys to. acked_change_id is the
+-- Exposure change_id high-water mark the partner has acknowledged.
+CREATE TABLE FederationPushTarget (
+	target_id VARCHAR(50) PRIMARY KEY,
+	protocol VARCHAR(10) NOT NULL,
+	endpoint VARCHAR(1000) NOT NULL,
+	oidc_audience VARCHAR(1000),
+	include_regions VARCHAR(5) [],
+	exclude_regions VARCHAR(5) [],
+	acked_change_id BIGINT NOT NULL DEFAULT 0,
+	last_success_at TIMESTAMPTZ,
+	last_failure_at TIMESTAMPTZ,
+	failures INT NOT NULL DEFAULT 0,
+	last_error VARCHAR(1000)
+);
+
+END;
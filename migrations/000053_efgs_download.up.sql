@@ -0,0 +1,30 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Batches downloaded from the EU federation gateway. Each day's batches form
+-- a chain through next_batch_tag, which downloads resume from.
+CREATE TABLE EFGSDownload (
+	batch_tag VARCHAR(100) PRIMARY KEY,
+	batch_date DATE NOT NULL,
+	next_batch_tag VARCHAR(100),
+	sync_id INT REFERENCES FederationInSync (sync_id),
+	keys INT NOT NULL DEFAULT 0,
+	downloaded_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX efgs_download_batch_date ON EFGSDownload (batch_date, downloaded_at);
+
+END;
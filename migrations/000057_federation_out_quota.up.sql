@@ -0,0 +1,34 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Overrides of the server's quotas for a partner pulling from this server.
+-- NULL uses the server default, and a negative value lifts the quota.
+ALTER TABLE FederationOutAuthorization ADD COLUMN requests_per_minute INT;
+ALTER TABLE FederationOutAuthorization ADD COLUMN keys_per_day INT;
+
+-- The fetch requests and keys served to each partner per UTC day, which the
+-- daily key quota is checked against.
+CREATE TABLE FederationOutUsage (
+	oidc_issuer VARCHAR(1000) NOT NULL,
+	oidc_subject VARCHAR(1000) NOT NULL,
+	day DATE NOT NULL,
+	requests INT NOT NULL DEFAULT 0,
+	keys BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (oidc_issuer, oidc_subject, day),
+	FOREIGN KEY (oidc_issuer, oidc_subject) REFERENCES FederationOutAuthorization (oidc_issuer, oidc_subject) ON DELETE CASCADE
+);
+
+END;
@@ -0,0 +1,25 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Filters on the keys exchanged with a partner. Only keys with one of the
+-- report types are exchanged, unless report_types is empty, and keys below
+-- min_transmission_risk never are.
+ALTER TABLE FederationInQuery ADD COLUMN report_types VARCHAR(20) [];
+ALTER TABLE FederationInQuery ADD COLUMN min_transmission_risk INT;
+ALTER TABLE FederationOutAuthorization ADD COLUMN report_types VARCHAR(20) [];
+ALTER TABLE FederationOutAuthorization ADD COLUMN min_transmission_risk INT;
+
+END;
@@ -0,0 +1,35 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- If jwks_uri is set, the health authority's keys are refreshed from the JSON
+-- Web Key Set published there. Otherwise they are added by hand.
+ALTER TABLE HealthAuthority
+	ADD COLUMN jwks_uri VARCHAR(1000) NOT NULL DEFAULT '',
+	ADD COLUMN jwks_refreshed_at TIMESTAMPTZ;
+
+-- The public keys that verification certificates of a health authority are
+-- signed with. version is the key ID in the certificates' kid header. A key is
+-- valid from from_timestamp until thru_timestamp, if set.
+CREATE TABLE HealthAuthorityKey (
+	health_authority_id VARCHAR(100) NOT NULL REFERENCES HealthAuthority (health_authority_id) ON DELETE CASCADE,
+	version VARCHAR(100) NOT NULL,
+	from_timestamp TIMESTAMPTZ NOT NULL,
+	thru_timestamp TIMESTAMPTZ,
+	public_key TEXT NOT NULL,
+	PRIMARY KEY (health_authority_id, version)
+);
+
+END;
@@ -0,0 +1,27 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+DROP TRIGGER signature_info_version ON SignatureInfo;
+DROP TRIGGER export_config_version ON ExportConfig;
+DROP TRIGGER health_authority_key_version ON HealthAuthorityKey;
+DROP TRIGGER health_authority_version ON HealthAuthority;
+DROP TRIGGER authorized_app_version ON AuthorizedApp;
+DROP TRIGGER config_setting_version ON ConfigSetting;
+DROP FUNCTION BumpConfigVersion;
+DROP TABLE ConfigVersion;
+DROP TABLE ConfigSetting;
+
+END;
@@ -0,0 +1,62 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Settings that servers reload while they run. name is the setting's
+-- environment variable, and value is parsed like the variable's value.
+CREATE TABLE ConfigSetting (
+	name VARCHAR(100) PRIMARY KEY,
+	value TEXT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Counts the changes to each table of configuration, so servers can cheaply
+-- poll for them. table_name is the lower case name of the table.
+CREATE TABLE ConfigVersion (
+	table_name VARCHAR(100) PRIMARY KEY,
+	version BIGINT NOT NULL,
+	changed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION BumpConfigVersion() RETURNS TRIGGER AS $$
+	BEGIN
+		INSERT INTO ConfigVersion (table_name, version, changed_at)
+		VALUES (TG_TABLE_NAME, 1, NOW())
+		ON CONFLICT (table_name) DO UPDATE
+			SET version = ConfigVersion.version + 1, changed_at = NOW();
+		RETURN NULL;
+	END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER config_setting_version
+	AFTER INSERT OR UPDATE OR DELETE ON ConfigSetting
+	FOR EACH STATEMENT EXECUTE PROCEDURE BumpConfigVersion();
+CREATE TRIGGER authorized_app_version
+	AFTER INSERT OR UPDATE OR DELETE ON AuthorizedApp
+	FOR EACH STATEMENT EXECUTE PROCEDURE BumpConfigVersion();
+CREATE TRIGGER health_authority_version
+	AFTER INSERT OR UPDATE OR DELETE ON HealthAuthority
+	FOR EACH STATEMENT EXECUTE PROCEDURE BumpConfigVersion();
+CREATE TRIGGER health_authority_key_version
+	AFTER INSERT OR UPDATE OR DELETE ON HealthAuthorityKey
+	FOR EACH STATEMENT EXECUTE PROCEDURE BumpConfigVersion();
+CREATE TRIGGER export_config_version
+	AFTER INSERT OR UPDATE OR DELETE ON ExportConfig
+	FOR EACH STATEMENT EXECUTE PROCEDURE BumpConfigVersion();
+CREATE TRIGGER signature_info_version
+	AFTER INSERT OR UPDATE OR DELETE ON SignatureInfo
+	FOR EACH STATEMENT EXECUTE PROCEDURE BumpConfigVersion();
+
+END;
@@ -0,0 +1,33 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Records who changed configuration or deleted data, and when. actor is the
+-- operator's email, "api-key:NAME" for admin API keys, or "system:SERVICE"
+-- for jobs. details holds the changed values as JSON.
+CREATE TABLE AuditLog (
+	audit_id BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	actor VARCHAR(255) NOT NULL,
+	action VARCHAR(50) NOT NULL,
+	resource VARCHAR(50) NOT NULL,
+	resource_id VARCHAR(255) NOT NULL DEFAULT '',
+	details JSONB
+);
+
+CREATE INDEX audit_log_created_at ON AuditLog (created_at);
+CREATE INDEX audit_log_resource ON AuditLog (resource, resource_id, created_at);
+
+END;
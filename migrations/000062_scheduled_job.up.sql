@@ -0,0 +1,26 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Records when each scheduled job last started, so that instances running
+-- the scheduler run each job once per interval between them.
+CREATE TABLE ScheduledJob (
+	job_name VARCHAR(100) PRIMARY KEY,
+	last_started TIMESTAMPTZ NOT NULL,
+	last_finished TIMESTAMPTZ,
+	last_result VARCHAR(20)
+);
+
+END;
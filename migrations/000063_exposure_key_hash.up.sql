@@ -0,0 +1,33 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- The key exposure_key_hash is computed with, wrapped by the KMS like the
+-- data keys. Unlike them it is never rotated, so that hashes stay comparable.
+-- There is at most one.
+CREATE TABLE ExposureKeyHashKey (
+	id INT PRIMARY KEY CHECK (id = 1),
+	kms_key_id VARCHAR(500) NOT NULL,
+	wrapped_key BYTEA NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+-- A keyed hash of the plain exposure key, set when exposure keys are
+-- encrypted. The same key encrypted with different data keys has different
+-- exposure_key values, so encrypted exposures are deduplicated by this hash.
+ALTER TABLE Exposure ADD COLUMN exposure_key_hash VARCHAR(64);
+CREATE UNIQUE INDEX exposure_key_hash ON Exposure (exposure_key_hash);
+
+END;
@@ -0,0 +1,23 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Exposures written before exposure keys were hashed. Their hash can't be
+-- computed here, because the hash key is wrapped by the KMS, so inserts set
+-- it on the rows they would conflict with until ReencryptExposures has
+-- hashed them all. The index stays empty after that.
+CREATE INDEX exposure_key_hash_missing ON Exposure (exposure_key) WHERE exposure_key_hash IS NULL;
+
+END;
@@ -0,0 +1,26 @@
+-- Copyright 2020 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--      http://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+
+BEGIN;
+
+-- Idempotency keys are scoped to the verification certificate of the request
+-- as well as its app, so that a stored response is only replayed to a client
+-- that holds the certificate it was published with. Existing records have
+-- no certificate hash and are no longer matched; they expire with
+-- IDEMPOTENCY_KEY_TTL.
+ALTER TABLE PublishIdempotency ADD COLUMN certificate_hash VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE PublishIdempotency DROP CONSTRAINT publishidempotency_pkey;
+ALTER TABLE PublishIdempotency ADD PRIMARY KEY (app_package_name, certificate_hash, idempotency_key);
+
+END;
@@ -51,3 +51,6 @@ BEGIN;
 END;'
 
 for m in $(ls migrations | tail -n 2); do echo "$TEMPLATE" >> "migrations/$m"; done
+
+# Embed the new migrations in the server.
+go generate ./internal/migrate
@@ -156,6 +156,26 @@ resource "google_secret_manager_secret_version" "db-pwd-initial" {
   secret_data = google_sql_user.user.password
 }
 
+resource "random_password" "cursor-secret" {
+  length  = 32
+  special = false
+}
+
+resource "google_secret_manager_secret" "db-cursor-secret" {
+  provider  = google-beta
+  secret_id = "dbCursorSecret"
+  replication {
+    automatic = true
+  }
+  depends_on = [google_project_service.services["secretmanager.googleapis.com"]]
+}
+
+resource "google_secret_manager_secret_version" "db-cursor-secret-initial" {
+  provider    = google-beta
+  secret      = google_secret_manager_secret.db-cursor-secret.id
+  secret_data = random_password.cursor-secret.result
+}
+
 locals {
   schema_substitutions = {
     "_DB_CONN" : google_sql_database_instance.db-inst.connection_name,
@@ -128,6 +128,10 @@ locals {
       name  = "DB_PASSWORD"
       value = "secret://${google_secret_manager_secret_version.db-pwd-initial.name}"
     },
+    {
+      name  = "DB_CURSOR_SECRET"
+      value = "secret://${google_secret_manager_secret_version.db-cursor-secret-initial.name}"
+    },
     {
       # NOTE: We disable SSL here because the Cloud Run services use the Cloud
       # SQL proxy which runs on localhost. The proxy still uses a secure
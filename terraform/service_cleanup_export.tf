@@ -46,6 +46,14 @@ resource "google_secret_manager_secret_iam_member" "cleanup-export-db-pwd" {
   member    = "serviceAccount:${google_service_account.cleanup-export.email}"
 }
 
+resource "google_secret_manager_secret_iam_member" "cleanup-export-db-cursor-secret" {
+  provider = google-beta
+
+  secret_id = google_secret_manager_secret.db-cursor-secret.id
+  role      = "roles/secretmanager.secretAccessor"
+  member    = "serviceAccount:${google_service_account.cleanup-export.email}"
+}
+
 resource "google_storage_bucket_iam_member" "cleanup-export-objectadmin" {
   bucket = google_storage_bucket.export.name
   role   = "roles/storage.objectAdmin"
@@ -46,6 +46,14 @@ resource "google_secret_manager_secret_iam_member" "cleanup-exposure-db-pwd" {
   member    = "serviceAccount:${google_service_account.cleanup-exposure.email}"
 }
 
+resource "google_secret_manager_secret_iam_member" "cleanup-exposure-db-cursor-secret" {
+  provider = google-beta
+
+  secret_id = google_secret_manager_secret.db-cursor-secret.id
+  role      = "roles/secretmanager.secretAccessor"
+  member    = "serviceAccount:${google_service_account.cleanup-exposure.email}"
+}
+
 resource "google_cloud_run_service" "cleanup-exposure" {
   name     = "cleanup-exposure"
   location = var.region
@@ -46,6 +46,14 @@ resource "google_secret_manager_secret_iam_member" "export-db-pwd" {
   member    = "serviceAccount:${google_service_account.export.email}"
 }
 
+resource "google_secret_manager_secret_iam_member" "export-db-cursor-secret" {
+  provider = google-beta
+
+  secret_id = google_secret_manager_secret.db-cursor-secret.id
+  role      = "roles/secretmanager.secretAccessor"
+  member    = "serviceAccount:${google_service_account.export.email}"
+}
+
 resource "google_storage_bucket_iam_member" "export-objectadmin" {
   bucket = google_storage_bucket.export.name
   role   = "roles/storage.objectAdmin" // overwrite is not included in objectCreator
@@ -179,3 +187,28 @@ resource "google_cloud_scheduler_job" "export-create-batches" {
     google_cloud_run_service_iam_member.export-invoker,
   ]
 }
+
+resource "google_cloud_scheduler_job" "export-rotate-keys" {
+  name             = "export-rotate-keys"
+  schedule         = "0 3 * * *"
+  time_zone        = "Etc/UTC"
+  attempt_deadline = "600s"
+
+  retry_config {
+    retry_count = 1
+  }
+
+  http_target {
+    http_method = "GET"
+    uri         = "${google_cloud_run_service.export.status.0.url}/rotate-keys"
+    oidc_token {
+      audience              = google_cloud_run_service.export.status.0.url
+      service_account_email = google_service_account.export-invoker.email
+    }
+  }
+
+  depends_on = [
+    google_app_engine_application.app,
+    google_cloud_run_service_iam_member.export-invoker,
+  ]
+}
@@ -46,6 +46,14 @@ resource "google_secret_manager_secret_iam_member" "exposure-db-pwd" {
   member    = "serviceAccount:${google_service_account.exposure.email}"
 }
 
+resource "google_secret_manager_secret_iam_member" "exposure-db-cursor-secret" {
+  provider = google-beta
+
+  secret_id = google_secret_manager_secret.db-cursor-secret.id
+  role      = "roles/secretmanager.secretAccessor"
+  member    = "serviceAccount:${google_service_account.exposure.email}"
+}
+
 resource "google_cloud_run_service" "exposure" {
   name     = "exposure"
   location = var.region
@@ -46,6 +46,14 @@ resource "google_secret_manager_secret_iam_member" "federationin-db-pwd" {
   member    = "serviceAccount:${google_service_account.federationin.email}"
 }
 
+resource "google_secret_manager_secret_iam_member" "federationin-db-cursor-secret" {
+  provider = google-beta
+
+  secret_id = google_secret_manager_secret.db-cursor-secret.id
+  role      = "roles/secretmanager.secretAccessor"
+  member    = "serviceAccount:${google_service_account.federationin.email}"
+}
+
 resource "google_cloud_run_service" "federationin" {
   name     = "federationin"
   location = var.region
@@ -46,6 +46,14 @@ resource "google_secret_manager_secret_iam_member" "federationout-db-pwd" {
   member    = "serviceAccount:${google_service_account.federationout.email}"
 }
 
+resource "google_secret_manager_secret_iam_member" "federationout-db-cursor-secret" {
+  provider = google-beta
+
+  secret_id = google_secret_manager_secret.db-cursor-secret.id
+  role      = "roles/secretmanager.secretAccessor"
+  member    = "serviceAccount:${google_service_account.federationout.email}"
+}
+
 resource "google_cloud_run_service" "federationout" {
   name     = "federationout"
   location = var.region
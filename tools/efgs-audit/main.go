@@ -0,0 +1,69 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This package is a CLI tool that shows the audit information of a batch
+// downloaded from the EU federation gateway: the uploads it is made of, with
+// their certificates and signatures.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/efgs"
+)
+
+var (
+	gatewayURL = flag.String("gateway-url", "", "(Required) The base URL of the gateway's API.")
+	certFile   = flag.String("cert", "", "(Required) A PEM file with the backend's TLS authentication certificate.")
+	keyFile    = flag.String("key", "", "(Required) A PEM file with the key of --cert.")
+	caFile     = flag.String("ca-cert", "", "A PEM file with certificates to trust in addition to the system ones.")
+
+	date     = flag.String("date", "", "(Required) The day the batch was downloaded for, as YYYY-MM-DD.")
+	batchTag = flag.String("batch-tag", "", "(Required) The tag of the batch.")
+)
+
+func main() {
+	flag.Parse()
+
+	if *gatewayURL == "" || *certFile == "" || *keyFile == "" {
+		log.Fatalf("--gateway-url, --cert and --key are required")
+	}
+	if *batchTag == "" {
+		log.Fatalf("--batch-tag is required")
+	}
+	day, err := time.Parse("2006-01-02", *date)
+	if err != nil {
+		log.Fatalf("--date must be YYYY-MM-DD: %v", err)
+	}
+
+	httpClient, err := efgs.HTTPClient(*caFile, *certFile, *keyFile, false)
+	if err != nil {
+		log.Fatalf("creating client: %v", err)
+	}
+	entries, err := efgs.NewClient(*gatewayURL, httpClient).Audit(context.Background(), day, *batchTag)
+	if err != nil {
+		log.Fatalf("auditing batch %s: %v", *batchTag, err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(entries); err != nil {
+		log.Fatalf("printing audit entries: %v", err)
+	}
+}
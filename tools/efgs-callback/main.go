@@ -0,0 +1,75 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This package is a CLI tool for managing the callbacks the EU federation
+// gateway calls when new batches are available.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/google/exposure-notifications-server/internal/efgs"
+)
+
+var (
+	gatewayURL = flag.String("gateway-url", "", "(Required) The base URL of the gateway's API.")
+	certFile   = flag.String("cert", "", "(Required) A PEM file with the backend's TLS authentication certificate.")
+	keyFile    = flag.String("key", "", "(Required) A PEM file with the key of --cert.")
+	caFile     = flag.String("ca-cert", "", "A PEM file with certificates to trust in addition to the system ones.")
+
+	callbackID  = flag.String("callback-id", "", "The ID of the callback to register or delete. Leave blank to list the callbacks.")
+	callbackURL = flag.String("url", "", "The URL of the efgs service for the gateway to call; registers --callback-id.")
+	deleteCB    = flag.Bool("delete", false, "Delete --callback-id instead of registering it.")
+)
+
+func main() {
+	flag.Parse()
+
+	if *gatewayURL == "" || *certFile == "" || *keyFile == "" {
+		log.Fatalf("--gateway-url, --cert and --key are required")
+	}
+	httpClient, err := efgs.HTTPClient(*caFile, *certFile, *keyFile, false)
+	if err != nil {
+		log.Fatalf("creating client: %v", err)
+	}
+	client := efgs.NewClient(*gatewayURL, httpClient)
+	ctx := context.Background()
+
+	switch {
+	case *callbackID == "":
+		callbacks, err := client.ListCallbacks(ctx)
+		if err != nil {
+			log.Fatalf("listing callbacks: %v", err)
+		}
+		for _, cb := range callbacks {
+			fmt.Printf("%s\t%s\n", cb.CallbackID, cb.URL)
+		}
+	case *deleteCB:
+		if err := client.DeleteCallback(ctx, *callbackID); err != nil {
+			log.Fatalf("deleting callback %s: %v", *callbackID, err)
+		}
+		log.Printf("Deleted callback %s", *callbackID)
+	default:
+		if *callbackURL == "" {
+			log.Fatalf("--url is required to register a callback")
+		}
+		if err := client.RegisterCallback(ctx, *callbackID, *callbackURL); err != nil {
+			log.Fatalf("registering callback %s: %v", *callbackID, err)
+		}
+		log.Printf("Registered callback %s to %s", *callbackID, *callbackURL)
+	}
+}
@@ -13,6 +13,11 @@
 // limitations under the License.
 
 // This package is used to create entries in the ExportConfig table. Each ExportConfig entry is used to create rows in the ExportBatch table.
+//
+// To rotate the signing key of an existing config, pass --config-id with the
+// new key. The new key is added alongside the existing ones, and exports are
+// signed by both until the old key is retired with --retire-signature-info-id
+// and --retire-timestamp.
 package main
 
 import (
@@ -33,44 +38,60 @@ var (
 	region            = flag.String("region", "", "The region for the export batches/files.")
 	fromTimestamp     = flag.String("from-timestamp", "", "The timestamp (RFC3339) when this config becomes active.")
 	thruTimestamp     = flag.String("thru-timestamp", "", "The timestamp (RFC3339) when this config ends.")
-	signingKey        = flag.String("signing-key", "", "The KMS resource ID to use for signing batches.")
+	signingKey        = flag.String("signing-key", "", "The KMS resource ID to use for signing batches. Prefix it with awskms://, azurekeyvault:// or pkcs11:// for keys held by AWS KMS, Azure Key Vault or an HSM.")
 	signingKeyID      = flag.String("signing-key-id", "", "The ID of the signing key (for clients).")
 	signingKeyVersion = flag.String("signing-key-version", "", "The version of the signing key (for clients).")
 	appPkgID          = flag.String("app-pkg-id", "", "The App Package ID to put in export headers")
 	bundleID          = flag.String("bundle-id", "", "The BundleID to put in export headers")
+	healthAuthorityID = flag.String("health-authority-id", "", "If set, only export keys published by this health authority's apps.")
+	includeTravelers  = flag.Bool("include-travelers", false, "With --health-authority-id, also export travelers' keys for the region from other health authorities.")
+	reportTypes       = flag.String("include-report-types", "", "Comma separated report types to export, e.g. confirmed,likely. If empty, all report types are exported.")
+	minRisk           = flag.Int("min-transmission-risk", 0, "If positive, only export keys with at least this transmission risk.")
+	deltaOf           = flag.Int64("delta-of-config-id", 0, "If set, create a delta config of this full config, whose index only lists files newer than the full config's latest batch.")
+
+	signingFrom   = flag.String("signing-key-from-timestamp", "", "The timestamp (RFC3339) when exports start being signed by the signing key.")
+	signingThru   = flag.String("signing-key-thru-timestamp", "", "The timestamp (RFC3339) when exports stop being signed by the signing key.")
+	autoRotate    = flag.Bool("auto-rotate", false, "If set, the export server rotates the signing key every SIGNING_KEY_ROTATION_PERIOD.")
+	configID      = flag.Int64("config-id", 0, "If set, add the signing key to this existing ExportConfig instead of creating a new one.")
+	retireSigInfo = flag.Int64("retire-signature-info-id", 0, "With --config-id, the SignatureInfo of the old signing key to expire at --retire-timestamp.")
+	retireAt      = flag.String("retire-timestamp", "", "The timestamp (RFC3339) when exports stop being signed by the old signing key.")
 )
 
 func main() {
 	flag.Parse()
 
-	if *bucketName == "" {
-		log.Fatal("--bucket-name is required.")
-	}
-	if *filenameRoot == "" {
-		log.Fatal("--filename-root is required.")
-	}
-	if *region == "" {
-		log.Fatal("--region is required.")
+	signingFromTime := parseTimestamp("signing-key-from-timestamp", *signingFrom)
+	signingThruTime := parseTimestamp("signing-key-thru-timestamp", *signingThru)
+	retireTime := parseTimestamp("retire-timestamp", *retireAt)
+
+	if *configID == 0 {
+		if *bucketName == "" {
+			log.Fatal("--bucket-name is required.")
+		}
+		if *filenameRoot == "" {
+			log.Fatal("--filename-root is required.")
+		}
+		if *region == "" {
+			log.Fatal("--region is required.")
+		}
+		if *retireSigInfo != 0 {
+			log.Fatal("--retire-signature-info-id requires --config-id.")
+		}
+	} else {
+		if *signingKey == "" {
+			log.Fatal("--signing-key is required with --config-id.")
+		}
+		if *retireSigInfo != 0 && retireTime.IsZero() {
+			log.Fatal("--retire-timestamp is required with --retire-signature-info-id.")
+		}
 	}
 	*region = strings.ToUpper(*region)
 
 	fromTime := time.Now()
 	if *fromTimestamp != "" {
-		var err error
-		fromTime, err = time.Parse(time.RFC3339, *fromTimestamp)
-		if err != nil {
-			log.Fatalf("Failed to parse --from-timestamp (use RFC3339): %v", err)
-		}
-	}
-
-	var thruTime time.Time
-	if *thruTimestamp != "" {
-		var err error
-		thruTime, err = time.Parse(time.RFC3339, *thruTimestamp)
-		if err != nil {
-			log.Fatalf("Failed to parse --thru-timestamp (use RFC3339): %v", err)
-		}
+		fromTime = parseTimestamp("from-timestamp", *fromTimestamp)
 	}
+	thruTime := parseTimestamp("thru-timestamp", *thruTimestamp)
 
 	if *signingKey == "" {
 		log.Printf("WARNING - you are creating an export config without a signing key!!")
@@ -95,22 +116,60 @@ func main() {
 		BundleID:          *bundleID,
 		SigningKeyVersion: *signingKeyVersion,
 		SigningKeyID:      *signingKeyID,
+		StartTimestamp:    signingFromTime,
+		EndTimestamp:      signingThruTime,
+		AutoRotate:        *autoRotate,
 	}
 	if err := db.AddSignatureInfo(ctx, &si); err != nil {
 		log.Fatalf("AddSignatureInfo: %v", err)
 	}
 
+	if *configID != 0 {
+		if err := db.AddExportConfigSignatureInfo(ctx, *configID, si.ID); err != nil {
+			log.Fatalf("AddExportConfigSignatureInfo: %v", err)
+		}
+		log.Printf("Added SignatureInfo %d to ExportConfig %d.", si.ID, *configID)
+
+		if *retireSigInfo != 0 {
+			if err := db.UpdateSignatureInfoEnd(ctx, *retireSigInfo, retireTime); err != nil {
+				log.Fatalf("UpdateSignatureInfoEnd: %v", err)
+			}
+			log.Printf("SignatureInfo %d expires at %v.", *retireSigInfo, retireTime.Format(time.RFC3339))
+		}
+		return
+	}
+
 	ec := database.ExportConfig{
-		BucketName:       *bucketName,
-		FilenameRoot:     *filenameRoot,
-		Period:           *period,
-		Region:           *region,
-		From:             fromTime,
-		Thru:             thruTime,
-		SignatureInfoIDs: []int64{si.ID},
+		BucketName:          *bucketName,
+		FilenameRoot:        *filenameRoot,
+		Period:              *period,
+		Region:              *region,
+		From:                fromTime,
+		Thru:                thruTime,
+		SignatureInfoIDs:    []int64{si.ID},
+		HealthAuthorityID:   *healthAuthorityID,
+		IncludeTravelers:    *includeTravelers,
+		MinTransmissionRisk: *minRisk,
+		DeltaOfConfigID:     *deltaOf,
+	}
+	if *reportTypes != "" {
+		ec.IncludeReportTypes = strings.Split(*reportTypes, ",")
 	}
 	if err := db.AddExportConfig(ctx, &ec); err != nil {
 		log.Fatalf("Failure: %v", err)
 	}
 	log.Printf("Successfully created ExportConfig %d.", ec.ConfigID)
 }
+
+// parseTimestamp parses the RFC3339 value of a flag. An empty value is the
+// zero time.
+func parseTimestamp(name, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatalf("Failed to parse --%s (use RFC3339): %v", name, err)
+	}
+	return t
+}
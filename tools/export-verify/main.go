@@ -0,0 +1,219 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This utility verifies the signatures of published export files against the
+// public keys that devices use, so health authorities can audit what the
+// server published. It reads an export file from disk or a URL, or every file
+// listed by an index.txt URL.
+//
+// Public keys are given as KEY_ID:KEY_VERSION=PATH, where PATH is a PEM
+// public key. If the version is omitted, the key matches any version.
+//
+//	go run ./tools/export-verify \
+//	  --in https://example.com/exposures/US/index.txt \
+//	  --public-key 310:v1=key.pem
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/exposure-notifications-server/internal/export"
+)
+
+// keyFlags collects the repeated --public-key flag.
+type keyFlags []string
+
+func (k *keyFlags) String() string {
+	return strings.Join(*k, ",")
+}
+
+func (k *keyFlags) Set(v string) error {
+	*k = append(*k, v)
+	return nil
+}
+
+var (
+	in         = flag.String("in", "", "The export file to verify: a path, a URL, or the URL of an index.txt to verify all of its files.")
+	publicKeys keyFlags
+)
+
+var httpClient = &http.Client{Timeout: time.Minute}
+
+func main() {
+	flag.Var(&publicKeys, "public-key", "A public key as KEY_ID:KEY_VERSION=PATH, may be repeated.")
+	flag.Parse()
+
+	if *in == "" {
+		log.Fatal("--in is required.")
+	}
+	if len(publicKeys) == 0 {
+		log.Fatal("--public-key is required.")
+	}
+
+	var keys []*export.VerificationKey
+	for _, v := range publicKeys {
+		key, err := parseKeyFlag(v)
+		if err != nil {
+			log.Fatalf("invalid --public-key %q: %v", v, err)
+		}
+		keys = append(keys, key)
+	}
+
+	files := []string{*in}
+	if isURL(*in) && path.Base(*in) == "index.txt" {
+		var err error
+		if files, err = indexFiles(*in); err != nil {
+			log.Fatalf("unable to read index: %v", err)
+		}
+	}
+
+	failed := 0
+	for _, file := range files {
+		if !verify(file, keys) {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d files failed verification\n", failed, len(files))
+		os.Exit(1)
+	}
+	fmt.Printf("%d files verified\n", len(files))
+}
+
+// verify verifies one export file and prints the outcome.
+func verify(file string, keys []*export.VerificationKey) bool {
+	data, err := read(file)
+	if err != nil {
+		fmt.Printf("FAIL %s: %v\n", file, err)
+		return false
+	}
+	ve, err := export.VerifyExportSignatures(data, keys)
+	if err != nil && !errors.Is(err, export.ErrUnverifiedExport) {
+		fmt.Printf("FAIL %s: %v\n", file, err)
+		return false
+	}
+
+	status := "OK"
+	if err != nil {
+		status = "FAIL"
+	}
+	fmt.Printf("%s %s: region %s, %v to %v, batch %d of %d, %d keys\n",
+		status, file, ve.Region, ve.StartTimestamp.Format(time.RFC3339), ve.EndTimestamp.Format(time.RFC3339),
+		ve.BatchNum, ve.BatchSize, ve.NumKeys)
+	for _, s := range ve.Signatures {
+		if s.Verified {
+			fmt.Printf("  key %s version %s: verified\n", s.KeyID, s.KeyVersion)
+		} else {
+			fmt.Printf("  key %s version %s: %s\n", s.KeyID, s.KeyVersion, s.Reason)
+		}
+	}
+	if len(ve.Signatures) == 0 {
+		fmt.Printf("  no signatures\n")
+	}
+	return err == nil
+}
+
+// parseKeyFlag parses KEY_ID:KEY_VERSION=PATH.
+func parseKeyFlag(v string) (*export.VerificationKey, error) {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("want KEY_ID:KEY_VERSION=PATH")
+	}
+	key := &export.VerificationKey{KeyID: parts[0]}
+	if i := strings.Index(parts[0], ":"); i >= 0 {
+		key.KeyID, key.KeyVersion = parts[0][:i], parts[0][i+1:]
+	}
+
+	data, err := ioutil.ReadFile(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%v has no PEM block", parts[1])
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is %T, not ECDSA", pub)
+	}
+	key.PublicKey = ecPub
+	return key, nil
+}
+
+// indexFiles returns the URLs of the files listed by the index at indexURL.
+// The index lists object names, such as "US/US-1589490000-00001-of-00001.zip",
+// which are relative to the bucket that holds the index under the same root.
+func indexFiles(indexURL string) ([]string, error) {
+	data, err := read(indexURL)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	indexName := path.Dir(names[0]) + "/index.txt"
+	if !strings.HasSuffix(indexURL, "/"+indexName) {
+		return nil, fmt.Errorf("index lists %v, which is not under the index's root", names[0])
+	}
+	base := strings.TrimSuffix(indexURL, indexName)
+
+	urls := make([]string, 0, len(names))
+	for _, name := range names {
+		urls = append(urls, base+name)
+	}
+	return urls, nil
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// read reads a file from disk or a URL.
+func read(file string) ([]byte, error) {
+	if !isURL(file) {
+		return ioutil.ReadFile(file)
+	}
+	resp, err := httpClient.Get(file)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v: %v", file, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
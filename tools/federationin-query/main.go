@@ -35,16 +35,22 @@ var (
 	validServerAddrStr    = `\A[a-z0-9.-]+(:\d+)?\z`
 	validServerAddrRegexp = regexp.MustCompile(validServerAddrStr)
 
-	queryID       = flag.String("query-id", "", "(Required) The ID of the federation query to set.")
-	serverAddr    = flag.String("server-addr", "", "(Required) The address of the remote server, in the form some-server:some-port")
-	audience      = flag.String("audience", federationin.DefaultAudience, "(Required) The OIDC audience to use when creating client tokens.")
-	lastTimestamp = flag.String("last-timestamp", "", "The last timestamp (RFC3339) to set; queries start from this point and go forward.")
+	queryID             = flag.String("query-id", "", "(Required) The ID of the federation query to set.")
+	serverAddr          = flag.String("server-addr", "", "(Required) The address of the remote server, in the form some-server:some-port")
+	audience            = flag.String("audience", federationin.DefaultAudience, "(Required) The OIDC audience to use when creating client tokens.")
+	lastTimestamp       = flag.String("last-timestamp", "", "The last timestamp (RFC3339) to set; queries start from this point and go forward.")
+	tombstone           = flag.Bool("tombstone-exposures", false, "Instead of setting the query, mark every key pulled by --query-id as deleted, e.g. when a partner withdraws its keys.")
+	minTransmissionRisk = flag.Int("min-transmission-risk", 0, "The minimum transmission risk of keys to accept from the partner.")
 )
 
 func main() {
 	var includeRegions, excludeRegions cflag.RegionListVar
 	flag.Var(&includeRegions, "regions", "A comma-separated list of regions to query. Leave blank for all regions.")
 	flag.Var(&excludeRegions, "exclude-regions", "A comma-separated list fo regions to exclude from the query.")
+	var regionMap cflag.RegionMapVar
+	flag.Var(&regionMap, "region-map", "A comma-separated list of PARTNER=LOCAL pairs translating the partner's regions into local ones, e.g. UK=GB.")
+	var reportTypes cflag.ReportTypeListVar
+	flag.Var(&reportTypes, "report-types", "A comma-separated list of report types to accept from the partner, e.g. confirmed. Leave blank for all report types.")
 	flag.Parse()
 
 	if *queryID == "" {
@@ -53,6 +59,20 @@ func main() {
 	if !validQueryIDRegexp.MatchString(*queryID) {
 		log.Fatalf("--query-id %q must match %s", *queryID, validQueryIDStr)
 	}
+
+	ctx := context.Background()
+	if *tombstone {
+		db := openDB(ctx)
+		defer db.Close(ctx)
+
+		n, err := db.TombstoneFederationInExposures(ctx, *queryID)
+		if err != nil {
+			log.Fatalf("tombstoning exposures of query %s: %v", *queryID, err)
+		}
+		log.Printf("Tombstoned %d exposures pulled by query %s", n, *queryID)
+		return
+	}
+
 	if *serverAddr == "" {
 		log.Fatalf("--server-addr is required")
 	}
@@ -74,26 +94,19 @@ func main() {
 		}
 	}
 
-	ctx := context.Background()
-	var config database.Config
-	err := envconfig.Process("database", &config)
-	if err != nil {
-		log.Fatalf("error loading environment variables: %v", err)
-	}
-
-	db, err := database.NewFromEnv(ctx, &config)
-	if err != nil {
-		log.Fatalf("unable to connect to database: %v", err)
-	}
+	db := openDB(ctx)
 	defer db.Close(ctx)
 
 	query := &database.FederationInQuery{
-		QueryID:        *queryID,
-		ServerAddr:     *serverAddr,
-		Audience:       *audience,
-		IncludeRegions: includeRegions,
-		ExcludeRegions: excludeRegions,
-		LastTimestamp:  lastTime,
+		QueryID:             *queryID,
+		ServerAddr:          *serverAddr,
+		Audience:            *audience,
+		IncludeRegions:      includeRegions,
+		ExcludeRegions:      excludeRegions,
+		LastTimestamp:       lastTime,
+		RegionMap:           regionMap,
+		ReportTypes:         reportTypes,
+		MinTransmissionRisk: *minTransmissionRisk,
 	}
 
 	if err := db.AddFederationInQuery(ctx, query); err != nil {
@@ -102,3 +115,16 @@ func main() {
 
 	log.Printf("Successfully added query %s %#v", *queryID, query)
 }
+
+func openDB(ctx context.Context) *database.DB {
+	var config database.Config
+	if err := envconfig.Process("database", &config); err != nil {
+		log.Fatalf("error loading environment variables: %v", err)
+	}
+
+	db, err := database.NewFromEnv(ctx, &config)
+	if err != nil {
+		log.Fatalf("unable to connect to database: %v", err)
+	}
+	return db
+}
@@ -18,11 +18,16 @@ package main
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/pem"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
 
 	"github.com/google/exposure-notifications-server/internal/database"
 	"github.com/google/exposure-notifications-server/internal/federationin"
+	"github.com/google/exposure-notifications-server/internal/federationout"
 	cflag "github.com/google/exposure-notifications-server/internal/flag"
 	"github.com/kelseyhightower/envconfig"
 )
@@ -34,19 +39,36 @@ const (
 var (
 	testRegions = []string{"TEST", "PROBE"}
 
-	subject  = flag.String("subject", "", "(Required) The OIDC subject (for issuer https://accounts.google.com, this is the obfuscated Gaia ID.)")
-	audience = flag.String("audience", federationin.DefaultAudience, "The OIDC audience; leaving this blank will cause server to not enforce the audience claim.")
-	note     = flag.String("note", "", "An open text note to include on the record.")
+	issuer     = flag.String("issuer", defaultIssuer, "The OIDC issuer; it must be one of the federationout server's OIDC_ISSUERS.")
+	subject    = flag.String("subject", "", "(Required unless --client-cert is set) The OIDC subject (for issuer https://accounts.google.com, this is the obfuscated Gaia ID.)")
+	clientCert = flag.String("client-cert", "", "A PEM file with the partner's client certificate; authorizes the partner by certificate instead of OIDC token.")
+	audience   = flag.String("audience", federationin.DefaultAudience, "The OIDC audience; leaving this blank will cause server to not enforce the audience claim.")
+	note       = flag.String("note", "", "An open text note to include on the record.")
+
+	requestsPerMinute   = flag.Int("requests-per-minute", 0, "The partner's fetch request quota per minute; 0 uses the server's QUOTA_REQUESTS_PER_MINUTE and a negative value lifts the quota.")
+	keysPerDay          = flag.Int("keys-per-day", 0, "The partner's quota of keys served per UTC day; 0 uses the server's QUOTA_KEYS_PER_DAY and a negative value lifts the quota.")
+	minTransmissionRisk = flag.Int("min-transmission-risk", 0, "The minimum transmission risk of keys to serve to the partner.")
 )
 
 func main() {
 	var includeRegions, excludeRegions cflag.RegionListVar
 	flag.Var(&includeRegions, "regions", "A comma-separated list of regions to query. Leave blank for all regions.")
 	flag.Var(&excludeRegions, "exclude-regions", "A comma-separated list fo regions to exclude from the query.")
+	var reportTypes cflag.ReportTypeListVar
+	flag.Var(&reportTypes, "report-types", "A comma-separated list of report types to serve to the partner, e.g. confirmed. Leave blank for all report types.")
 	flag.Parse()
 
-	if *subject == "" {
-		log.Fatalf("--subject is required")
+	authIssuer, authSubject, authAudience := *issuer, *subject, *audience
+	if *clientCert != "" {
+		cert, err := readCertificate(*clientCert)
+		if err != nil {
+			log.Fatalf("failed to read --client-cert: %v", err)
+		}
+		authIssuer, authSubject = federationout.CertificateIdentity(cert)
+		authAudience = "" // Certificates carry no audience.
+	}
+	if authSubject == "" {
+		log.Fatalf("--subject or --client-cert is required")
 	}
 
 	// Issue warnings about missing test regions in excludeRegions.
@@ -81,12 +103,16 @@ func main() {
 	defer db.Close(ctx)
 
 	auth := &database.FederationOutAuthorization{
-		Issuer:         defaultIssuer, // Authorization interceptor currently only supports defaultIssuer.
-		Subject:        *subject,
-		Audience:       *audience,
-		Note:           *note,
-		IncludeRegions: includeRegions,
-		ExcludeRegions: excludeRegions,
+		Issuer:              authIssuer,
+		Subject:             authSubject,
+		Audience:            authAudience,
+		Note:                *note,
+		IncludeRegions:      includeRegions,
+		ExcludeRegions:      excludeRegions,
+		RequestsPerMinute:   *requestsPerMinute,
+		KeysPerDay:          *keysPerDay,
+		ReportTypes:         reportTypes,
+		MinTransmissionRisk: *minTransmissionRisk,
 	}
 
 	if err := db.AddFederationOutAuthorization(ctx, auth); err != nil {
@@ -95,3 +121,15 @@ func main() {
 
 	log.Printf("Successfully added federation client authorization %#v", auth)
 }
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s does not contain a PEM certificate", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This package is a CLI tool for creating and updating FederationPushTarget
+// records, the partners that local keys are pushed to.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"regexp"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	cflag "github.com/google/exposure-notifications-server/internal/flag"
+	"github.com/kelseyhightower/envconfig"
+)
+
+var (
+	validTargetIDStr    = `\A[a-z][a-z0-9-_]*[a-z0-9]\z`
+	validTargetIDRegexp = regexp.MustCompile(validTargetIDStr)
+
+	targetID      = flag.String("target-id", "", "(Required) The ID of the push target to set.")
+	protocol      = flag.String("protocol", database.FederationPushGRPC, "The push protocol, GRPC, REST or EFGS.")
+	endpoint      = flag.String("endpoint", "", "(Required) For GRPC, the partner's address in the form some-server:some-port; for REST, the URL of its upload endpoint; for EFGS, the gateway's base URL.")
+	audience      = flag.String("audience", "", "The OIDC audience of the tokens sent to the partner; leave blank to send no token.")
+	ackedChangeID = flag.Int64("start-change-id", 0, "For a new target, the exposure change ID to start pushing after; 0 pushes all keys.")
+)
+
+func main() {
+	var includeRegions, excludeRegions cflag.RegionListVar
+	flag.Var(&includeRegions, "regions", "A comma-separated list of regions to push. Leave blank for all regions.")
+	flag.Var(&excludeRegions, "exclude-regions", "A comma-separated list of regions to not push.")
+	flag.Parse()
+
+	if *targetID == "" {
+		log.Fatalf("--target-id is required")
+	}
+	if !validTargetIDRegexp.MatchString(*targetID) {
+		log.Fatalf("--target-id %q must match %s", *targetID, validTargetIDStr)
+	}
+	switch *protocol {
+	case database.FederationPushGRPC, database.FederationPushREST, database.FederationPushEFGS:
+	default:
+		log.Fatalf("--protocol must be %s, %s or %s", database.FederationPushGRPC, database.FederationPushREST, database.FederationPushEFGS)
+	}
+	if *endpoint == "" {
+		log.Fatalf("--endpoint is required")
+	}
+
+	ctx := context.Background()
+	var config database.Config
+	err := envconfig.Process("database", &config)
+	if err != nil {
+		log.Fatalf("error loading environment variables: %v", err)
+	}
+
+	db, err := database.NewFromEnv(ctx, &config)
+	if err != nil {
+		log.Fatalf("unable to connect to database: %v", err)
+	}
+	defer db.Close(ctx)
+
+	target := &database.FederationPushTarget{
+		TargetID:       *targetID,
+		Protocol:       *protocol,
+		Endpoint:       *endpoint,
+		Audience:       *audience,
+		IncludeRegions: includeRegions,
+		ExcludeRegions: excludeRegions,
+		AckedChangeID:  *ackedChangeID,
+	}
+
+	if err := db.AddFederationPushTarget(ctx, target); err != nil {
+		log.Fatalf("adding push target %s %#v: %v", *targetID, target, err)
+	}
+
+	log.Printf("Successfully added push target %s %#v", *targetID, target)
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This package is a CLI tool for applying or reverting the database migrations
+// embedded in the server.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/google/exposure-notifications-server/internal/database"
+	"github.com/google/exposure-notifications-server/internal/migrate"
+	"github.com/kelseyhightower/envconfig"
+)
+
+var (
+	down   = flag.Int("down", 0, "Number of migrations to revert. If zero, all pending migrations are applied.")
+	dryRun = flag.Bool("dry-run", false, "Print the migrations that would run without running them.")
+)
+
+func main() {
+	flag.Parse()
+
+	ctx := context.Background()
+	var config database.Config
+	err := envconfig.Process("database", &config)
+	if err != nil {
+		log.Fatalf("error loading environment variables: %v", err)
+	}
+
+	m, err := migrate.New(ctx, &config)
+	if err != nil {
+		log.Fatalf("unable to create migrator: %v", err)
+	}
+	defer m.Close()
+
+	version, _, err := m.Version()
+	if err != nil {
+		log.Fatalf("unable to read schema version: %v", err)
+	}
+	log.Printf("Current schema version: %d", version)
+
+	var migrations []migrate.Migration
+	verb := "apply"
+	if *down > 0 {
+		verb = "revert"
+		migrations, err = m.Down(*down, *dryRun)
+	} else {
+		migrations, err = m.Up(*dryRun)
+	}
+	if err != nil {
+		log.Fatalf("migration failed: %v", err)
+	}
+
+	if *dryRun {
+		verb = "Would " + verb
+	} else {
+		verb = "Did " + verb
+	}
+	if len(migrations) == 0 {
+		log.Printf("No migrations to run.")
+	}
+	for _, m := range migrations {
+		log.Printf("%s %v", verb, m)
+	}
+}
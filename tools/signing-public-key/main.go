@@ -0,0 +1,59 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This utility prints the PEM encoded public key of an export signing key, to
+// register it with the app stores. The key is given like the --signing-key of
+// export-config, and may select a key manager with a prefix such as
+// "awskms://".
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/google/exposure-notifications-server/internal/signing"
+)
+
+var (
+	signingKey = flag.String("signing-key", "", "The signing key, as in the SignatureInfo.")
+)
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+
+	if *signingKey == "" {
+		log.Fatal("--signing-key is required.")
+	}
+
+	typ, keyID, ok := signing.ParseKeyID(*signingKey)
+	if !ok {
+		typ = signing.KeyManagerTypeGoogleCloudKMS
+	}
+	km, err := signing.KeyManagerFor(ctx, typ)
+	if err != nil {
+		log.Fatalf("unable to connect to key manager: %v", err)
+	}
+	signer, err := km.NewSigner(ctx, keyID)
+	if err != nil {
+		log.Fatalf("unable to get signer: %v", err)
+	}
+	pem, err := signing.PublicKeyPEM(signer)
+	if err != nil {
+		log.Fatalf("unable to encode public key: %v", err)
+	}
+	fmt.Print(string(pem))
+}